@@ -0,0 +1,144 @@
+// Command ai-eval runs the evaluation suite of a project and fails when its scores drop below thresholds.
+// With -stub the questions are answered by a local stand-in for the AI provider and embedded by a local
+// embedder instead, so the suite can run in CI without provider credentials or cost. The local embedder
+// only finds knowledge indexed with it, by running the indexer with KNOWLEDGE_EMBEDDING_SERVICE=stub.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+func main() {
+	tenant := flag.String("tenant", "", "Tenant ID")
+	project := flag.String("project", "", "Project ID")
+	label := flag.String("label", "", "Label of the run, such as the change being tested")
+	stub := flag.Bool("stub", false, "Answer and embed with local stubs instead of the configured AI and embedding providers")
+	minHitRate := flag.Float64("min-hit-rate", 0, "Fail when the retrieval hit rate is below this (0 to 1)")
+	minSimilarity := flag.Float64("min-similarity", 0, "Fail when the answer similarity is below this (0 to 1)")
+	minCitation := flag.Float64("min-citation-accuracy", 0, "Fail when the citation accuracy is below this (0 to 1)")
+	flag.Parse()
+
+	tenantID, err := uuid.Parse(*tenant)
+	if err != nil {
+		log.Fatal("Usage: go run cmd/ai-eval/main.go -tenant <id> -project <id> [-stub] [-label <label>] [-min-hit-rate 0.8]")
+	}
+	projectID, err := uuid.Parse(*project)
+	if err != nil {
+		log.Fatal("Usage: go run cmd/ai-eval/main.go -tenant <id> -project <id> [-stub] [-label <label>] [-min-hit-rate 0.8]")
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if *stub {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.Fatalf("Failed to start stub model: %v", err)
+		}
+		go http.Serve(listener, service.NewEvalStubLLM())
+		cfg.AI.Enabled = true
+		cfg.AI.Provider = string(service.ProviderOpenAI)
+		cfg.AI.Model = "stub"
+		cfg.AI.APIKey = "stub"
+		cfg.AI.BaseURL = "http://" + listener.Addr().String()
+		cfg.AI.Fallbacks = nil
+		cfg.AI.MaxRetries = 0
+		cfg.Knowledge.Enabled = true
+		cfg.Knowledge.EmbeddingService = service.EvalStubEmbeddingService
+	}
+
+	// Connect to database
+	database, err := db.Connect(&cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	// The knowledge lookup, AI profiles and guardrails chats are answered with; runs are not billed
+	settingsRepo := repo.NewSettingsRepository(database.DB.DB)
+	knowledgeService := service.NewKnowledgeService(repo.NewKnowledgeRepository(database.DB), service.NewEmbeddingService(&cfg.Knowledge))
+	aiProfileService := service.NewAIProfileService(repo.NewAIProfileRepository(database.DB), repo.NewChatWidgetRepo(database.DB), &cfg.AI)
+	aiGuardrailService := service.NewAIGuardrailService(settingsRepo, repo.NewAIGuardrailEventRepository(database.DB))
+	aiService := service.NewAIService(&cfg.AI, &cfg.Agentic, nil, knowledgeService, nil, nil, nil, nil, nil, nil, aiProfileService, nil, aiGuardrailService)
+	evaluationService := service.NewAIEvaluationService(repo.NewAIEvaluationRepository(database.DB), aiService)
+	if *stub {
+		evaluationService.UseProvider(cfg.AI.Provider, cfg.AI.Model)
+	}
+
+	run, err := evaluationService.RunSuite(context.Background(), tenantID, projectID, *label)
+	if err != nil {
+		log.Fatalf("Failed to run evaluation suite: %v", err)
+	}
+
+	for _, result := range run.Results {
+		status := "ok"
+		switch {
+		case result.Error != nil:
+			status = "error: " + *result.Error
+		case result.Handoff:
+			status = "handoff"
+		case result.Blocked:
+			status = "blocked"
+		}
+		fmt.Printf("%-60.60s  hit=%s  similarity=%s  citation=%s  %s\n", result.Question,
+			formatBool(result.RetrievalHit), formatScore(result.AnswerSimilarity), formatBool(result.CitationCorrect), status)
+	}
+	fmt.Printf("\nRun %s (%s): %d cases, %d failed\n", run.ID, run.Status, run.CaseCount, run.FailedCount)
+	fmt.Printf("Retrieval hit rate: %s\nAnswer similarity:  %s\nCitation accuracy:  %s\n",
+		formatScore(run.RetrievalHitRate), formatScore(run.AnswerSimilarity), formatScore(run.CitationAccuracy))
+
+	ok := run.Status == models.AIEvalRunCompleted
+	ok = meetsThreshold("Retrieval hit rate", run.RetrievalHitRate, *minHitRate) && ok
+	ok = meetsThreshold("Answer similarity", run.AnswerSimilarity, *minSimilarity) && ok
+	ok = meetsThreshold("Citation accuracy", run.CitationAccuracy, *minCitation) && ok
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// meetsThreshold reports whether a score reaches its minimum. A score no case was scored on only meets
+// a minimum of zero.
+func meetsThreshold(name string, score *float64, minimum float64) bool {
+	if minimum <= 0 {
+		return true
+	}
+	if score == nil || *score < minimum {
+		fmt.Printf("%s %s is below %.3f\n", name, formatScore(score), minimum)
+		return false
+	}
+	return true
+}
+
+func formatScore(score *float64) string {
+	if score == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.3f", *score)
+}
+
+func formatBool(value *bool) string {
+	if value == nil {
+		return "n/a"
+	}
+	if *value {
+		return "yes"
+	}
+	return "no"
+}
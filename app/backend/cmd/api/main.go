@@ -31,6 +31,7 @@ import (
 	_ "github.com/bareuptime/tms/docs" // This line is necessary for go-swagger to find your docs!
 	"github.com/bareuptime/tms/internal/auth"
 	"github.com/bareuptime/tms/internal/config" // Global middleware
+	"github.com/bareuptime/tms/internal/crypto"
 	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/handlers"
 	"github.com/bareuptime/tms/internal/mail"
@@ -95,6 +96,7 @@ func main() {
 	chatWidgetRepo := repo.NewChatWidgetRepo(database.DB)
 	chatSessionRepo := repo.NewChatSessionRepo(database.DB)
 	chatMessageRepo := repo.NewChatMessageRepo(database.DB)
+	chatParticipantRepo := repo.NewChatParticipantRepo(database.DB)
 
 	// Knowledge management repositories
 	knowledgeRepo := repo.NewKnowledgeRepository(database.DB)
@@ -105,10 +107,15 @@ func main() {
 	// Payment and credits repositories
 	creditsRepo := repo.NewCreditsRepository(database.DB.DB)
 	paymentWebhookRepo := repo.NewPaymentWebhookRepository(database.DB.DB)
+	planRepo := repo.NewPlanRepository(database.DB.DB)
+	mfaRepo := repo.NewMFARepository(database.DB.DB)
+	ssoRepo := repo.NewSSORepository(database.DB.DB)
+	aiProfileRepo := repo.NewAIProfileRepository(database.DB)
 
 	// Initialize mail service
 	mailLogger := zerolog.New(os.Stdout).With().Timestamp().Logger()
 	mailService := mail.NewService(mailLogger)
+	mailService.SetRoutingLookup(emailRepo)
 
 	// Initialize Redis service
 	redisService := redis.NewService(redis.RedisConfig{
@@ -140,9 +147,26 @@ func main() {
 		Endpoint:     google.Endpoint,
 	}
 
+	// Billing plans (limits are enforced by agent, project, widget and knowledge services)
+	planService := service.NewPlanService(planRepo, creditsRepo, agentRepo)
+
+	rbacService.SetRedisService(redisService)
+
 	authService := service.NewAuthService(agentRepo, rbacService, jwtAuth, redisService, emailProvider, authFeatureFlags, tenantRepo, domainValidationRepo, projectRepo, googleOAuthConfig)
-	projectService := service.NewProjectService(projectRepo)
-	agentService := service.NewAgentService(agentRepo, projectRepo, rbacService)
+
+	// Two-factor authentication (TOTP secrets are encrypted at rest)
+	mfaEncryption, err := crypto.NewPasswordEncryption()
+	if err != nil {
+		log.Fatalf("Failed to initialize MFA encryption: %v", err)
+	}
+	mfaService := service.NewMFAService(mfaRepo, agentRepo, rbacService, redisService, mfaEncryption, cfg.JWT.MFAIssuer)
+	authService.SetMFAService(mfaService)
+
+	// Per-tenant OIDC and SAML single sign-on (client secrets share the MFA encryption key)
+	ssoService := service.NewSSOService(ssoRepo, projectRepo, rbacService, redisService, mfaEncryption, cfg.OAuth.SSO.BaseURL)
+	authService.SetSSOService(ssoService)
+	projectService := service.NewProjectService(projectRepo, planService)
+	agentService := service.NewAgentService(agentRepo, projectRepo, rbacService, planService)
 	tenantService := service.NewTenantService(tenantRepo, agentRepo, rbacService)
 	customerService := service.NewCustomerService(customerRepo, rbacService)
 	messageService := service.NewMessageService(messageRepo, ticketRepo, customerRepo, agentRepo, rbacService)
@@ -150,10 +174,11 @@ func main() {
 
 	ticketService := service.NewTicketService(ticketRepo, customerRepo, agentRepo, messageRepo, rbacService, mailService, publicService, emailProvider, cfg.Server.PublicTicketUrl)
 	emailInboxService := service.NewEmailInboxService(emailInboxRepo, ticketRepo, messageRepo, customerRepo, emailRepo, mailService, mailLogger)
+	emailIngestService := service.NewEmailIngestService(emailRepo, emailInboxRepo, ticketRepo, messageRepo, customerRepo, mailService, cfg.Email.IngestSecret, cfg.Email.InboundDomain, mailLogger)
 	domainValidationService := service.NewDomainValidationService(domainValidationRepo, mailService)
 
 	// Chat services
-	chatWidgetService := service.NewChatWidgetService(chatWidgetRepo, domainValidationRepo)
+	chatWidgetService := service.NewChatWidgetService(chatWidgetRepo, domainValidationRepo, planService)
 
 	// Initialize enterprise connection manager (needed for chat session service)
 	connectionManager := websocket.NewConnectionManager(redisService.GetClient())
@@ -165,15 +190,18 @@ func main() {
 	// Slack service - needed by chat session service
 	slackService := service.NewSlackService(projectIntegrationRepo, chatSessionRepo, redisService)
 
-	chatSessionService := service.NewChatSessionService(chatSessionRepo, chatMessageRepo, chatWidgetRepo, customerRepo, ticketService, agentService, connectionManager, redisService, howlingAlarmService, slackService)
+	chatSessionService := service.NewChatSessionService(chatSessionRepo, chatMessageRepo, chatWidgetRepo, chatParticipantRepo, customerRepo, ticketService, agentService, connectionManager, redisService, howlingAlarmService, slackService)
+	chatTicketService := service.NewChatTicketService(chatSessionRepo, chatSessionService, ticketService, emailProvider)
+	chatParticipantService := service.NewChatParticipantService(chatParticipantRepo, chatSessionService, agentService, rbacService)
+	agentPresenceService := service.NewAgentPresenceService(redisService, chatSessionRepo, connectionManager)
 
 	// Knowledge management services
 	embeddingService := service.NewEmbeddingService(&cfg.Knowledge)
 	documentProcessorService := service.NewDocumentProcessorService(knowledgeRepo, embeddingService, "./uploads", cfg.Knowledge.MaxFileSize)
-	webScrapingService := service.NewWebScrapingService(knowledgeRepo, embeddingService, &cfg.Knowledge)
+	webScrapingService := service.NewWebScrapingService(knowledgeRepo, embeddingService, &cfg.Knowledge, planService)
 	publicURLAnalysisService := service.NewPublicURLAnalysisService(webScrapingService)
 	knowledgeService := service.NewKnowledgeService(knowledgeRepo, embeddingService)
-	aiUsageService := service.NewAIUsageService(creditsRepo)
+	aiUsageService := service.NewAIUsageService(creditsRepo, planService)
 
 	// Greeting services for agentic behavior
 	greetingDetectionService := service.NewGreetingDetectionService(&cfg.Agentic)
@@ -185,12 +213,50 @@ func main() {
 
 	// Notification service (needs connection manager for WebSocket delivery)
 	notificationService := service.NewNotificationService(notificationRepo, connectionManager)
+	planService.SetNotifier(notificationService)
 	// Enhanced notification service for agentic behavior
 	// enhancedNotificationService := service.NewEnhancedNotificationService(notificationRepo, connectionManager, howlingAlarmService, cfg)
 
+	// Per-project and per-widget AI persona, model and guardrails over the global AI config
+	aiProfileService := service.NewAIProfileService(aiProfileRepo, chatWidgetRepo, &cfg.AI)
+	aiToolRepo := repo.NewAIToolRepository(database.DB)
+	aiToolService := service.NewAIToolService(aiToolRepo, ticketService, chatTicketService, chatWidgetRepo, mfaEncryption)
+
+	// Masking of personal data and prompt-injection checks around every AI call, and optionally of stored
+	// customer messages, by the project's guardrail settings
+	aiGuardrailEventRepo := repo.NewAIGuardrailEventRepository(database.DB)
+	aiGuardrailService := service.NewAIGuardrailService(settingsRepo, aiGuardrailEventRepo)
+	chatSessionService.SetMessageRedactor(aiGuardrailService)
+	ticketService.SetMessageRedactor(aiGuardrailService)
+	publicService.SetMessageRedactor(aiGuardrailService)
+	emailIngestService.SetMessageRedactor(aiGuardrailService)
+	emailInboxService.SetMessageRedactor(aiGuardrailService)
+
 	// AI service (needs knowledge service for RAG, greeting services for agentic behavior, connection manager for handoff notifications, and auto assignment service)
-	aiService := service.NewAIService(&cfg.AI, &cfg.Agentic, chatSessionService, knowledgeService, aiUsageService, greetingDetectionService, brandGreetingService, connectionManager, howlingAlarmService)
+	aiService := service.NewAIService(&cfg.AI, &cfg.Agentic, chatSessionService, knowledgeService, aiUsageService, greetingDetectionService, brandGreetingService, connectionManager, howlingAlarmService, agentPresenceService, aiProfileService, aiToolService, aiGuardrailService)
 	aiBuilderService := service.NewAIBuilderService(chatWidgetService, webScrapingService, knowledgeService, aiService)
+	// Reply suggestions and summaries for human agents, over the same providers and billing as AI replies
+	aiAssistService := service.NewAIAssistService(aiService, chatSessionService, ticketRepo, messageRepo)
+	// Type, priority, tags, language and sentiment of tickets customers open, when enabled in automation settings
+	ticketTriageRepo := repo.NewTicketTriageRepository(database.DB)
+	ticketTriageService := service.NewTicketTriageService(ticketTriageRepo, ticketRepo, messageRepo, settingsRepo, aiService, &cfg.Agentic)
+	ticketService.SetTriager(ticketTriageService)
+	emailIngestService.SetTriager(ticketTriageService)
+	// Evaluation suites of test questions, run through the same knowledge lookup and AI profile as chats
+	aiEvaluationRepo := repo.NewAIEvaluationRepository(database.DB)
+	aiEvaluationService := service.NewAIEvaluationService(aiEvaluationRepo, aiService)
+	// Answers to repeated visitor questions, reused until their knowledge is indexed again
+	aiAnswerCacheRepo := repo.NewAIAnswerCacheRepository(database.DB)
+	aiAnswerCacheService := service.NewAIAnswerCacheService(aiAnswerCacheRepo, embeddingService, settingsRepo)
+	aiService.SetAnswerCache(aiAnswerCacheService)
+	webScrapingService.SetKnowledgeChangeListener(aiAnswerCacheService)
+	documentProcessorService.SetKnowledgeChangeListener(aiAnswerCacheService)
+
+	translationRepo := repo.NewTranslationRepository(database.DB)
+	translationService := service.NewTranslationService(translationRepo, service.NewLLMTranslationBackend(aiService), settingsRepo)
+	chatSessionService.SetTranslator(translationService)
+	ticketService.SetTranslator(translationService)
+	emailIngestService.SetTranslator(translationService)
 
 	// Public AI builder service for unauthenticated widget creation
 	publicAIBuilderService := service.NewPublicAIBuilderService(projectRepo, chatWidgetRepo, aiBuilderService, webScrapingService)
@@ -220,11 +286,19 @@ func main() {
 
 	// Chat handlers
 	chatWidgetHandler := handlers.NewChatWidgetHandler(chatWidgetService, webScrapingService, aiService)
-	chatSessionHandler := handlers.NewChatSessionHandler(chatSessionService, chatWidgetService, redisService)
+	chatSessionHandler := handlers.NewChatSessionHandler(chatSessionService, chatWidgetService, chatTicketService, chatParticipantService, redisService)
 
 	// Knowledge management handlers
 	knowledgeHandler := handlers.NewKnowledgeHandler(documentProcessorService, webScrapingService, knowledgeService, publicURLAnalysisService)
 	aiBuilderHandler := handlers.NewAIBuilderHandler(aiBuilderService, publicAIBuilderService)
+	aiProfileHandler := handlers.NewAIProfileHandler(aiProfileService, aiService)
+	aiToolHandler := handlers.NewAIToolHandler(aiToolService)
+	aiGuardrailHandler := handlers.NewAIGuardrailHandler(aiGuardrailService)
+	aiEvaluationHandler := handlers.NewAIEvaluationHandler(aiEvaluationService)
+	aiAnswerCacheHandler := handlers.NewAIAnswerCacheHandler(aiAnswerCacheService)
+	translationHandler := handlers.NewTranslationHandler(translationService)
+	aiAssistHandler := handlers.NewAIAssistHandler(aiAssistService)
+	ticketTriageHandler := handlers.NewTicketTriageHandler(ticketTriageService)
 
 	// Public AI builder handler
 	publicAIBuilderHandler := handlers.NewPublicAIBuilderHandler(publicAIBuilderService)
@@ -234,6 +308,11 @@ func main() {
 	// Payment handlers
 	paymentHandler := handlers.NewPaymentHandler(paymentService)
 	aiUsageHandler := handlers.NewAIUsageHandler(aiUsageService, cfg.Server.AiAgentLoginAccessKey)
+	roleHandler := handlers.NewRoleHandler(rbacService)
+	mfaHandler := handlers.NewMFAHandler(authService, mfaService)
+	ssoHandler := handlers.NewSSOHandler(authService, ssoService)
+	emailIngestHandler := handlers.NewEmailIngestHandler(emailIngestService)
+	billingHandler := handlers.NewBillingHandler(planService, cfg.Server.AiAgentLoginAccessKey)
 
 	// Integration OAuth handler
 	frontendURL := "http://localhost:3000" // Default frontend URL
@@ -250,17 +329,28 @@ func main() {
 	agentClient := service.NewAgentClient(cfg.Knowledge.AiAgentServiceUrl)
 
 	// Slack events handler (requires agentClient and aiService for AI responses)
+	chatInactivityService := service.NewChatInactivityService(chatSessionRepo, chatSessionService, emailProvider)
 	slackEventsHandler := handlers.NewSlackEventsHandler(slackService, chatSessionService, connectionManager, projectIntegrationRepo, chatSessionRepo, agentClient, aiService)
+	slackInteractionService := service.NewSlackInteractionService(slackService, chatSessionService, chatSessionRepo, chatTicketService, ticketRepo, agentRepo, knowledgeService, rbacService)
+	slackInteractionsHandler := handlers.NewSlackInteractionsHandler(slackInteractionService, slackService)
 
 	chatWebSocketHandler := handlers.NewChatWebSocketHandler(chatSessionService, connectionManager, notificationService, aiService, agentClient, jwtAuth)
-	agentWebSocketHandler := handlers.NewAgentWebSocketHandler(chatSessionService, connectionManager, agentService)
+	agentWebSocketHandler := handlers.NewAgentWebSocketHandler(chatSessionService, connectionManager, agentService, agentPresenceService, aiAssistService)
 
 	// Set up combined message handling - ChatWebSocketHandler handles all Redis pub/sub messages
 	// since it manages both visitor and agent connections
 	agentWebSocketHandler.SetChatWSHandler(chatWebSocketHandler)
 
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go planService.RunMonthlyGrants(jobsCtx, time.Hour)
+	go chatInactivityService.RunSweeper(jobsCtx, time.Minute)
+	go chatTicketService.RunSweeper(jobsCtx, time.Minute)
+	go agentPresenceService.RunSweeper(jobsCtx, 30*time.Second)
+
 	// Setup router
-	router := setupRouter(database.DB.DB, jwtAuth, apiKeyRepo, &cfg.CORS, rateLimiter, authHandler, projectHandler, ticketHandler, publicHandler, integrationHandler, emailHandler, emailInboxHandler, agentHandler, customerHandler, apiKeyHandler, settingsHandler, tenantHandler, domainValidationHandler, notificationHandler, chatWidgetHandler, chatSessionHandler, chatWebSocketHandler, agentWebSocketHandler, knowledgeHandler, aiBuilderHandler, publicAIBuilderHandler, alarmHandler, paymentHandler, aiUsageHandler, stripeWebhookHandler, cashfreeWebhookHandler, integrationOAuthHandler, slackEventsHandler)
+	router := setupRouter(database.DB.DB, jwtAuth, apiKeyRepo, rbacService, &cfg.CORS, &cfg.Slack, rateLimiter, authHandler, projectHandler, ticketHandler, publicHandler, integrationHandler, emailHandler, emailInboxHandler, agentHandler, customerHandler, apiKeyHandler, settingsHandler, tenantHandler, domainValidationHandler, notificationHandler, chatWidgetHandler, chatSessionHandler, chatWebSocketHandler, agentWebSocketHandler, knowledgeHandler, aiBuilderHandler, publicAIBuilderHandler, alarmHandler, paymentHandler, aiUsageHandler, stripeWebhookHandler, cashfreeWebhookHandler, integrationOAuthHandler, slackEventsHandler, billingHandler, roleHandler, mfaHandler, ssoHandler, emailIngestHandler, slackInteractionsHandler, aiProfileHandler, aiToolHandler, aiAssistHandler, ticketTriageHandler, aiGuardrailHandler, aiEvaluationHandler, aiAnswerCacheHandler, translationHandler)
 
 	// Create HTTP server
 	serverAddr := cfg.Server.Port
@@ -285,6 +375,9 @@ func main() {
 		log.Printf("Slack Client ID %s", cfg.Slack.ClientID)
 		// log.Printf("Slack Client Secret %s", cfg.Slack.ClientSecret)
 		log.Printf("Slack Redirect URI %s", cfg.Slack.RedirectURI)
+		if cfg.Slack.SigningSecret == "" {
+			log.Printf("SLACK_SIGNING_SECRET is not set; Slack events will be rejected")
+		}
 		// log.Printf("Google Client  Secret %s", cfg.OAuth.Google.ClientSecret)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
@@ -308,7 +401,7 @@ func main() {
 	log.Println("Server exited")
 }
 
-func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKeyRepository, corsConfig *config.CORSConfig, rateLimiter *rate.RateLimiter, authHandler *handlers.AuthHandler, projectHandler *handlers.ProjectHandler, ticketHandler *handlers.TicketHandler, publicHandler *handlers.PublicHandler, integrationHandler *handlers.IntegrationHandler, emailHandler *handlers.EmailHandler, emailInboxHandler *handlers.EmailInboxHandler, agentHandler *handlers.AgentHandler, customerHandler *handlers.CustomerHandler, apiKeyHandler *handlers.ApiKeyHandler, settingsHandler *handlers.SettingsHandler, tenantHandler *handlers.TenantHandler, domainNameHandler *handlers.DomainNameHandler, notificationHandler *handlers.NotificationHandler, chatWidgetHandler *handlers.ChatWidgetHandler, chatSessionHandler *handlers.ChatSessionHandler, chatWebSocketHandler *handlers.ChatWebSocketHandler, agentWebSocketHandler *handlers.AgentWebSocketHandler, knowledgeHandler *handlers.KnowledgeHandler, aiBuilderHandler *handlers.AIBuilderHandler, publicAIBuilderHandler *handlers.PublicAIBuilderHandler, alarmHandler *handlers.AlarmHandler, paymentHandler *handlers.PaymentHandler, aiUsageHandler *handlers.AIUsageHandler, stripeWebhookHandler *handlers.StripeWebhookHandler, cashfreeWebhookHandler *handlers.CashfreeWebhookHandler, integrationOAuthHandler *handlers.IntegrationOAuthHandler, slackEventsHandler *handlers.SlackEventsHandler) *gin.Engine {
+func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKeyRepository, rbacService *rbac.Service, corsConfig *config.CORSConfig, slackConfig *config.SlackConfig, rateLimiter *rate.RateLimiter, authHandler *handlers.AuthHandler, projectHandler *handlers.ProjectHandler, ticketHandler *handlers.TicketHandler, publicHandler *handlers.PublicHandler, integrationHandler *handlers.IntegrationHandler, emailHandler *handlers.EmailHandler, emailInboxHandler *handlers.EmailInboxHandler, agentHandler *handlers.AgentHandler, customerHandler *handlers.CustomerHandler, apiKeyHandler *handlers.ApiKeyHandler, settingsHandler *handlers.SettingsHandler, tenantHandler *handlers.TenantHandler, domainNameHandler *handlers.DomainNameHandler, notificationHandler *handlers.NotificationHandler, chatWidgetHandler *handlers.ChatWidgetHandler, chatSessionHandler *handlers.ChatSessionHandler, chatWebSocketHandler *handlers.ChatWebSocketHandler, agentWebSocketHandler *handlers.AgentWebSocketHandler, knowledgeHandler *handlers.KnowledgeHandler, aiBuilderHandler *handlers.AIBuilderHandler, publicAIBuilderHandler *handlers.PublicAIBuilderHandler, alarmHandler *handlers.AlarmHandler, paymentHandler *handlers.PaymentHandler, aiUsageHandler *handlers.AIUsageHandler, stripeWebhookHandler *handlers.StripeWebhookHandler, cashfreeWebhookHandler *handlers.CashfreeWebhookHandler, integrationOAuthHandler *handlers.IntegrationOAuthHandler, slackEventsHandler *handlers.SlackEventsHandler, billingHandler *handlers.BillingHandler, roleHandler *handlers.RoleHandler, mfaHandler *handlers.MFAHandler, ssoHandler *handlers.SSOHandler, emailIngestHandler *handlers.EmailIngestHandler, slackInteractionsHandler *handlers.SlackInteractionsHandler, aiProfileHandler *handlers.AIProfileHandler, aiToolHandler *handlers.AIToolHandler, aiAssistHandler *handlers.AIAssistHandler, ticketTriageHandler *handlers.TicketTriageHandler, aiGuardrailHandler *handlers.AIGuardrailHandler, aiEvaluationHandler *handlers.AIEvaluationHandler, aiAnswerCacheHandler *handlers.AIAnswerCacheHandler, translationHandler *handlers.TranslationHandler) *gin.Engine {
 	// Set Gin mode
 	if os.Getenv("GIN_MODE") == "" {
 		gin.SetMode(gin.ReleaseMode)
@@ -352,8 +445,14 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 		// Integration OAuth callback (public, no auth required)
 		publicRoutes.GET("/integrations/slack/callback", integrationOAuthHandler.SlackOAuthCallback)
 
-		// Slack events webhook (public, no auth required - called by Slack)
-		publicRoutes.POST("/integrations/slack/events", slackEventsHandler.HandleSlackEvents)
+		// Slack-facing endpoints (no user auth - every request must carry a valid Slack signature)
+		slackRoutes := publicRoutes.Group("/integrations/slack")
+		slackRoutes.Use(middleware.SlackSignatureMiddleware(slackConfig.SigningSecret))
+		{
+			slackRoutes.POST("/events", slackEventsHandler.HandleSlackEvents)
+			slackRoutes.POST("/interactions", slackInteractionsHandler.HandleInteraction)
+			slackRoutes.POST("/commands", slackInteractionsHandler.HandleSlashCommand)
+		}
 	}
 
 	// Public AI widget builder endpoint (with stricter rate limiting: 2 requests per 6 hours per IP)
@@ -377,12 +476,29 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 		authRoutes.POST("/verify-signup-otp", authHandler.VerifySignupOTP)
 		authRoutes.POST("/resend-signup-otp", authHandler.ResendSignupOTP)
 
+		// Second step of logins that require two-factor authentication
+		authRoutes.POST("/mfa/enroll", mfaHandler.StartLoginEnrollment)
+		authRoutes.POST("/mfa/verify", mfaHandler.VerifyLogin)
+
 		// Google OAuth routes
 		authRoutes.GET("/google/login", authHandler.GoogleOAuthLogin)
 		authRoutes.GET("/google/callback", authHandler.GoogleOAuthCallback)
 		// Client-side Google OAuth (modern approach)
 		authRoutes.POST("/google/token", authHandler.GoogleIDTokenCallback)
 		authRoutes.GET("/google/client-id", authHandler.GetGoogleClientID)
+
+		// Tenant single sign-on (OIDC and SAML 2.0)
+		authRoutes.POST("/sso/discover", ssoHandler.Discover)
+		authRoutes.GET("/sso/:provider_id/login", ssoHandler.Login)
+		authRoutes.GET("/sso/:provider_id/callback", ssoHandler.OIDCCallback)
+		authRoutes.POST("/sso/:provider_id/acs", ssoHandler.SAMLACS)
+		authRoutes.GET("/sso/:provider_id/metadata", ssoHandler.Metadata)
+	}
+
+	// Inbound mail from the bundled SMTP email-server (public, authenticated by HMAC signature)
+	ingestRoutes := router.Group("/v1/public")
+	{
+		ingestRoutes.POST("/email-to-ticket", emailIngestHandler.EmailToTicket)
 	}
 
 	// Payment routes (protected by auth middleware, no tenant_id in path as payments are global)
@@ -425,12 +541,21 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 	{
 		// Global agent WebSocket endpoint (not session-specific)
 		api.GET("/chat/agent/ws", agentWebSocketHandler.HandleAgentWebSocket)
+		api.GET("/chat/agent/presence", agentWebSocketHandler.GetMyPresence)
+		api.PUT("/chat/agent/presence", agentWebSocketHandler.SetMyPresence)
 
 		// Authentication endpoints that require auth
 		auth := api.Group("/auth")
 		{
 			auth.POST("/logout", authHandler.Logout)
 			auth.GET("/me", authHandler.Me)
+
+			// Two-factor authentication of the current agent
+			auth.GET("/mfa", mfaHandler.GetStatus)
+			auth.POST("/mfa/enroll", mfaHandler.StartEnrollment)
+			auth.POST("/mfa/enroll/confirm", mfaHandler.ConfirmEnrollment)
+			auth.POST("/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+			auth.POST("/mfa/disable", mfaHandler.Disable)
 		}
 
 		// Project management endpoints
@@ -468,11 +593,39 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 			api.POST("/agents/:agent_id/projects/:project_id", middleware.TenantAdminMiddleware(), agentHandler.AssignToProject)
 			api.DELETE("/agents/:agent_id/projects/:project_id", middleware.TenantAdminMiddleware(), agentHandler.RemoveFromProject)
 			api.GET("/agents/:agent_id/projects", agentHandler.GetAgentProjects)
+			api.DELETE("/agents/:agent_id/mfa", middleware.TenantAdminMiddleware(), mfaHandler.ResetAgentMFA)
 			// Agent notification preferences (Phase 4)
 			api.GET("/agents/:agent_id/notification-preferences", alarmHandler.GetNotificationPreferences)
 			api.PUT("/agents/:agent_id/notification-preferences", alarmHandler.UpdateNotificationPreferences)
 		}
 
+		// Permission catalogue and custom roles (tenant-level)
+		{
+			api.GET("/permissions", roleHandler.ListPermissions)
+			api.GET("/roles", roleHandler.ListRoles)
+			api.POST("/roles", middleware.TenantAdminMiddleware(), roleHandler.CreateRole)
+			api.PUT("/roles/:role_id", middleware.TenantAdminMiddleware(), roleHandler.UpdateRole)
+			api.DELETE("/roles/:role_id", middleware.TenantAdminMiddleware(), roleHandler.DeleteRole)
+		}
+
+		// Tenant security policy
+		{
+			api.GET("/security/mfa-policy", mfaHandler.GetPolicy)
+			api.PUT("/security/mfa-policy", middleware.TenantAdminMiddleware(), mfaHandler.SetPolicy)
+			api.PUT("/security/password-login", middleware.TenantAdminMiddleware(), ssoHandler.SetPasswordLogin)
+		}
+
+		// Single sign-on providers (tenant admin only)
+		sso := api.Group("/sso/providers")
+		sso.Use(middleware.TenantAdminMiddleware())
+		{
+			sso.GET("", ssoHandler.ListProviders)
+			sso.POST("", ssoHandler.CreateProvider)
+			sso.GET("/:provider_id", ssoHandler.GetProvider)
+			sso.PUT("/:provider_id", ssoHandler.UpdateProvider)
+			sso.DELETE("/:provider_id", ssoHandler.DeleteProvider)
+		}
+
 		// Customer management (tenant-level)
 		{
 			api.GET("/customers", middleware.AuthMiddleware(jwtAuth), customerHandler.ListCustomers)
@@ -482,12 +635,23 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 			api.DELETE("/customers/:customer_id", middleware.TenantAdminMiddleware(), customerHandler.DeleteCustomer)
 		}
 
+		// Billing plans, quotas and usage statements (tenant-level)
+		billing := api.Group("/billing")
+		billing.Use(middleware.RequirePermission(rbacService, rbac.PermBillingRead, rbac.PermBillingWrite))
+		{
+			billing.GET("/plans", billingHandler.ListPlans)
+			billing.GET("/plan", middleware.TenantAdminMiddleware(), billingHandler.GetPlan)
+			billing.PUT("/plan", middleware.TenantAdminMiddleware(), billingHandler.AssignPlan)
+			billing.GET("/statements/:period", middleware.TenantAdminMiddleware(), billingHandler.GetStatement)
+		}
+
 		// API Key management endpoints
 
 		// Project-scoped endpoints
 		projects := api.Group("/projects/:project_id")
 		{
 			apiKeys := projects.Group("/api-keys")
+			apiKeys.Use(middleware.RequirePermission(rbacService, rbac.PermApiKeyRead, rbac.PermApiKeyWrite))
 			{
 				apiKeys.GET("", apiKeyHandler.ListApiKeys)
 				apiKeys.POST("", apiKeyHandler.CreateApiKey)
@@ -498,6 +662,7 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 
 			// Settings endpoints
 			settings := projects.Group("/settings")
+			settings.Use(middleware.RequirePermission(rbacService, rbac.PermSettingsRead, rbac.PermSettingsWrite))
 			{
 				settings.GET("/branding", middleware.ProjectAdminMiddleware(), settingsHandler.GetBrandingSettings)
 				settings.PUT("/branding", middleware.ProjectAdminMiddleware(), settingsHandler.UpdateBrandingSettings)
@@ -505,6 +670,8 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 				settings.PUT("/automation", middleware.ProjectAdminMiddleware(), settingsHandler.UpdateAutomationSettings)
 				settings.GET("/about-me", middleware.ProjectAdminMiddleware(), settingsHandler.GetAboutMeSettings)
 				settings.PUT("/about-me", middleware.ProjectAdminMiddleware(), settingsHandler.UpdateAboutMeSettings)
+				settings.GET("/translation", middleware.ProjectAdminMiddleware(), translationHandler.GetSettings)
+				settings.PUT("/translation", middleware.ProjectAdminMiddleware(), translationHandler.UpdateSettings)
 			}
 
 			// Notifications endpoints
@@ -521,6 +688,74 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 			{
 				ais.POST("/usage/deduct", aiUsageHandler.DeductUsage)
 				ais.POST("/build", middleware.ProjectAdminMiddleware(), aiBuilderHandler.StreamBuild)
+
+				// Assistant persona, model and guardrails of the project and its widgets
+				profiles := ais.Group("/profiles")
+				profiles.Use(middleware.RequirePermission(rbacService, rbac.PermSettingsRead, rbac.PermSettingsWrite))
+				{
+					profiles.GET("", aiProfileHandler.ListProfiles)
+					profiles.POST("", aiProfileHandler.CreateProfile)
+					profiles.GET("/resolved", aiProfileHandler.GetResolvedProfile)
+					profiles.POST("/preview", aiProfileHandler.PreviewProfile)
+					profiles.GET("/:profile_id", aiProfileHandler.GetProfile)
+					profiles.PATCH("/:profile_id", aiProfileHandler.UpdateProfile)
+					profiles.DELETE("/:profile_id", aiProfileHandler.DeleteProfile)
+				}
+
+				// HTTP tools the assistant may call, such as order-status APIs
+				tools := ais.Group("/tools")
+				tools.Use(middleware.RequirePermission(rbacService, rbac.PermSettingsRead, rbac.PermSettingsWrite))
+				{
+					tools.GET("", aiToolHandler.ListTools)
+					tools.POST("", aiToolHandler.CreateTool)
+					tools.GET("/:tool_id", aiToolHandler.GetTool)
+					tools.PATCH("/:tool_id", aiToolHandler.UpdateTool)
+					tools.DELETE("/:tool_id", aiToolHandler.DeleteTool)
+					tools.POST("/:tool_id/test", aiToolHandler.TestTool)
+				}
+
+				// Ticket triage decisions and how often reviewers agreed with them
+				triage := ais.Group("/triage")
+				triage.Use(middleware.RequirePermission(rbacService, rbac.PermTicketRead, rbac.PermTicketWrite))
+				{
+					triage.GET("", ticketTriageHandler.ListTriage)
+					triage.GET("/accuracy", ticketTriageHandler.GetAccuracy)
+				}
+
+				// Masking of personal data, prompt-injection checks and the output blocklist, with what they did
+				guardrails := ais.Group("/guardrails")
+				guardrails.Use(middleware.RequirePermission(rbacService, rbac.PermSettingsRead, rbac.PermSettingsWrite))
+				{
+					guardrails.GET("", aiGuardrailHandler.GetSettings)
+					guardrails.PUT("", aiGuardrailHandler.UpdateSettings)
+					guardrails.GET("/events", aiGuardrailHandler.ListEvents)
+				}
+
+				// Evaluation suite of test questions and its scored runs
+				evaluations := ais.Group("/evaluations")
+				evaluations.Use(middleware.RequirePermission(rbacService, rbac.PermSettingsRead, rbac.PermSettingsWrite))
+				{
+					evaluations.GET("/cases", aiEvaluationHandler.ListCases)
+					evaluations.POST("/cases", aiEvaluationHandler.CreateCase)
+					evaluations.PUT("/cases/:case_id", aiEvaluationHandler.UpdateCase)
+					evaluations.DELETE("/cases/:case_id", aiEvaluationHandler.DeleteCase)
+					evaluations.POST("/cases/import/csv", aiEvaluationHandler.ImportCSV)
+					evaluations.POST("/cases/import/thumbs-down", aiEvaluationHandler.ImportThumbsDown)
+					evaluations.GET("/runs", aiEvaluationHandler.ListRuns)
+					evaluations.POST("/runs", aiEvaluationHandler.StartRun)
+					evaluations.GET("/runs/:run_id", aiEvaluationHandler.GetRun)
+					evaluations.GET("/runs/:run_id/compare", aiEvaluationHandler.CompareRuns)
+				}
+
+				// Reuse of AI answers for repeated questions, its hit rate and purging
+				answerCache := ais.Group("/answer-cache")
+				answerCache.Use(middleware.RequirePermission(rbacService, rbac.PermSettingsRead, rbac.PermSettingsWrite))
+				{
+					answerCache.GET("/settings", aiAnswerCacheHandler.GetSettings)
+					answerCache.PUT("/settings", aiAnswerCacheHandler.UpdateSettings)
+					answerCache.GET("/stats", aiAnswerCacheHandler.GetStats)
+					answerCache.DELETE("", aiAnswerCacheHandler.Purge)
+				}
 			}
 
 			// Alarms endpoints (Phase 4 implementation)
@@ -533,6 +768,7 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 
 			// Integrations - using the available methods
 			integrations := projects.Group("/integrations")
+			integrations.Use(middleware.RequirePermission(rbacService, rbac.PermIntegrationRead, rbac.PermIntegrationWrite))
 			{
 				// Integration categories and templates
 				integrations.GET("/categories", integrationHandler.ListIntegrationCategories)
@@ -572,6 +808,7 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 
 			// Email connectors and mailboxes
 			email := projects.Group("/email")
+			email.Use(middleware.RequirePermission(rbacService, rbac.PermEmailRead, rbac.PermEmailWrite))
 			{
 				// Email connectors
 				email.GET("/connectors", emailHandler.ListConnectors)
@@ -590,6 +827,9 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 				email.PUT("/mailboxes/:mailbox_id", emailHandler.UpdateMailbox)
 				email.DELETE("/mailboxes/:mailbox_id", emailHandler.DeleteMailbox)
 
+				// Address of the bundled SMTP email-server that creates tickets in this project
+				email.GET("/inbound-address", emailIngestHandler.GetInboundAddress)
+
 				// Email inbox
 				inbox := email.Group("/inbox")
 				{
@@ -616,26 +856,53 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 			chat := projects.Group("/chat")
 			{
 				// Chat widgets
-				chat.GET("/widgets", chatWidgetHandler.ListChatWidgets)
-				chat.POST("/widgets", chatWidgetHandler.CreateChatWidget)
-				chat.GET("/widgets/:widget_id", chatWidgetHandler.GetChatWidget)
-				chat.PATCH("/widgets/:widget_id", chatWidgetHandler.UpdateChatWidget)
-				chat.DELETE("/widgets/:widget_id", chatWidgetHandler.DeleteChatWidget)
-				chat.GET("/widgets/scrape-theme", chatWidgetHandler.ScrapeWebsiteTheme)
+				widgets := chat.Group("/widgets")
+				widgets.Use(middleware.RequirePermission(rbacService, rbac.PermWidgetRead, rbac.PermWidgetWrite))
+				{
+					widgets.GET("", chatWidgetHandler.ListChatWidgets)
+					widgets.POST("", chatWidgetHandler.CreateChatWidget)
+					widgets.GET("/:widget_id", chatWidgetHandler.GetChatWidget)
+					widgets.PATCH("/:widget_id", chatWidgetHandler.UpdateChatWidget)
+					widgets.DELETE("/:widget_id", chatWidgetHandler.DeleteChatWidget)
+					widgets.POST("/:widget_id/identity-secret", chatWidgetHandler.RotateIdentitySecret)
+					widgets.GET("/scrape-theme", chatWidgetHandler.ScrapeWebsiteTheme)
+				}
 
 				// Chat sessions (agent endpoints)
-				chat.GET("/sessions", chatSessionHandler.ListChatSessions)
-				chat.GET("/sessions/:session_id", chatSessionHandler.GetChatSession)
-				chat.POST("/sessions/:session_id/assign", chatSessionHandler.AssignAgent)
-				chat.POST("/sessions/:session_id/escalate", middleware.TenantAdminMiddleware(), chatSessionHandler.EscalateSession)
-				chat.GET("/sessions/:session_id/messages", chatSessionHandler.GetChatMessages)
-				chat.POST("/sessions/:session_id/messages/:message_id/read", chatSessionHandler.MarkAgentMessagesAsRead)
-				chat.GET("/sessions/:session_id/client/status", chatSessionHandler.IsCustomerOnline)
+				sessions := chat.Group("/sessions")
+				sessions.Use(middleware.RequirePermission(rbacService, rbac.PermChatRead, rbac.PermChatWrite))
+				{
+					sessions.GET("", chatSessionHandler.ListChatSessions)
+					sessions.GET("/:session_id", chatSessionHandler.GetChatSession)
+					sessions.POST("/:session_id/assign", chatSessionHandler.AssignAgent)
+					sessions.POST("/:session_id/escalate", middleware.TenantAdminMiddleware(), chatSessionHandler.EscalateSession)
+					sessions.GET("/:session_id/participants", chatSessionHandler.ListParticipants)
+					sessions.POST("/:session_id/participants", chatSessionHandler.InviteAgent)
+					sessions.POST("/:session_id/transfer", chatSessionHandler.TransferSession)
+					sessions.POST("/:session_id/leave", chatSessionHandler.LeaveSession)
+					sessions.POST("/:session_id/monitor", middleware.RequirePermission(rbacService, rbac.PermChatSupervise, rbac.PermChatSupervise), chatSessionHandler.MonitorSession)
+					sessions.POST("/:session_id/whisper", middleware.RequirePermission(rbacService, rbac.PermNotePrivateRead, rbac.PermNotePrivateWrite), chatSessionHandler.Whisper)
+					sessions.POST("/:session_id/convert-to-ticket", middleware.RequirePermission(rbacService, rbac.PermTicketRead, rbac.PermTicketWrite), chatSessionHandler.ConvertToTicket)
+					sessions.GET("/:session_id/messages", chatSessionHandler.GetChatMessages)
+					sessions.POST("/:session_id/messages/:message_id/read", chatSessionHandler.MarkAgentMessagesAsRead)
+					sessions.GET("/:session_id/client/status", chatSessionHandler.IsCustomerOnline)
+					sessions.GET("/:session_id/translations", translationHandler.ListChatTranslations)
+
+					// Agent assist
+					sessions.POST("/:session_id/ai/suggestions", aiAssistHandler.SuggestChatReplies)
+					sessions.POST("/:session_id/ai/summary", aiAssistHandler.SummarizeChat)
+				}
 
+				// Who is online, with their chat load
+				chat.GET("/presence", middleware.RequirePermission(rbacService, rbac.PermChatRead, rbac.PermChatWrite), agentWebSocketHandler.ListProjectPresence)
 			}
 
+			// Knowledge search is a read even though it is a POST, so it sits outside the knowledge group
+			projects.POST("/knowledge/search", middleware.RequirePermission(rbacService, rbac.PermKnowledgeRead, rbac.PermKnowledgeRead), knowledgeHandler.SearchKnowledgeBase)
+
 			// Knowledge management endpoints
 			knowledge := projects.Group("/knowledge")
+			knowledge.Use(middleware.RequirePermission(rbacService, rbac.PermKnowledgeRead, rbac.PermKnowledgeWrite))
 			{
 				// Document management
 				knowledge.POST("/documents", knowledgeHandler.UploadDocument)
@@ -660,7 +927,6 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 				knowledge.DELETE("/pages/:mapping_id", knowledgeHandler.DeleteProjectKnowledgePageMapping)
 
 				// Knowledge search
-				knowledge.POST("/search", knowledgeHandler.SearchKnowledgeBase)
 				knowledge.GET("/search", knowledgeHandler.SearchKnowledgeBaseGET)
 				knowledge.GET("/faq", knowledgeHandler.ListFAQItems)
 
@@ -681,11 +947,20 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 			// Embed script for public widgets
 			publicChat.GET("/widgets/:widget_id/embed.js", chatWidgetHandler.GetEmbedSnippet)
 
+			// Past conversations of visitors verified by a signed identity token
+			publicChat.GET("/widgets/:widget_id/conversations", chatSessionHandler.ListVisitorConversations)
+			publicChat.GET("/widgets/:widget_id/conversations/:session_id/messages", chatSessionHandler.GetVisitorConversationMessages)
+
 			// Public chat session endpoints (token-based auth)
 			publicChat.POST("/sessions/:session_id/messages/:message_id/read", chatSessionHandler.MarkVisitorMessagesAsRead)
 
 			// WebSocket endpoint for visitors
 			publicChat.GET("/ws/widgets/:widget_id/chat/:session_token", chatWebSocketHandler.HandleWebSocketPublic)
+
+			// Fallback transports for networks that block WebSockets: receive over SSE or long polling, send with POST
+			publicChat.GET("/sse/widgets/:widget_id/chat/:session_token", chatWebSocketHandler.HandleSSEPublic)
+			publicChat.GET("/poll/widgets/:widget_id/chat/:session_token", chatWebSocketHandler.HandleLongPollPublic)
+			publicChat.POST("/send/widgets/:widget_id/chat/:session_token", chatWebSocketHandler.HandleClientMessagePublic)
 		}
 
 		embedChat := router.Group("/embed")
@@ -698,6 +973,7 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 	// Tickets with flexible authentication (JWT or API key) - separate from api group to avoid inheriting AuthMiddleware
 	flexibleTickets := router.Group("/v1/tenants/:tenant_id/projects/:project_id/tickets")
 	flexibleTickets.Use(middleware.ApiKeyOrJWTAuthMiddleware(apiKeyRepo, jwtAuth))
+	flexibleTickets.Use(middleware.RequirePermission(rbacService, rbac.PermTicketRead, rbac.PermTicketWrite))
 	flexibleTickets.Use(middleware.TicketAccessMiddleware())
 	{
 		flexibleTickets.GET("", ticketHandler.ListTickets)
@@ -708,10 +984,10 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 		flexibleTickets.PATCH("/:ticket_id", middleware.TicketReassignmentMiddleware(), ticketHandler.UpdateTicket)
 
 		// Dedicated reassignment endpoint (requires admin permissions)
-		flexibleTickets.POST("/:ticket_id/reassign", middleware.ProjectAdminMiddleware(), ticketHandler.ReassignTicket)
+		flexibleTickets.POST("/:ticket_id/reassign", middleware.ProjectAdminMiddleware(), middleware.RequirePermission(rbacService, rbac.PermTicketAdmin, rbac.PermTicketAdmin), ticketHandler.ReassignTicket)
 
 		// Delete ticket (requires admin permissions)
-		flexibleTickets.DELETE("/:ticket_id", middleware.ProjectAdminMiddleware(), ticketHandler.DeleteTicket)
+		flexibleTickets.DELETE("/:ticket_id", middleware.ProjectAdminMiddleware(), middleware.RequirePermission(rbacService, rbac.PermTicketAdmin, rbac.PermTicketAdmin), ticketHandler.DeleteTicket)
 
 		// Customer validation and magic links
 		flexibleTickets.POST("/:ticket_id/validate-customer", ticketHandler.ValidateCustomer)
@@ -722,10 +998,21 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 		flexibleTickets.POST("/:ticket_id/messages", ticketHandler.AddMessage)
 		flexibleTickets.PATCH("/:ticket_id/messages/:message_id", ticketHandler.UpdateMessage)
 		flexibleTickets.DELETE("/:ticket_id/messages/:message_id", ticketHandler.DeleteMessage)
+		flexibleTickets.GET("/:ticket_id/translations", translationHandler.ListTicketTranslations)
+
+		// Agent assist
+		flexibleTickets.POST("/:ticket_id/ai/suggestions", aiAssistHandler.SuggestTicketReplies)
+		flexibleTickets.POST("/:ticket_id/ai/summary", aiAssistHandler.SummarizeTicket)
+
+		// AI triage
+		flexibleTickets.GET("/:ticket_id/triage", ticketTriageHandler.GetTriage)
+		flexibleTickets.POST("/:ticket_id/triage", ticketTriageHandler.TriageTicket)
+		flexibleTickets.POST("/:ticket_id/triage/review", ticketTriageHandler.ReviewTriage)
 	}
 
 	simpleTicketUrls := router.Group("/v1/tickets")
 	simpleTicketUrls.Use(middleware.ApiKeyOrJWTAuthMiddleware(apiKeyRepo, jwtAuth))
+	simpleTicketUrls.Use(middleware.RequirePermission(rbacService, rbac.PermTicketRead, rbac.PermTicketWrite))
 	{
 		simpleTicketUrls.GET("", ticketHandler.ListTickets)
 		simpleTicketUrls.POST("", ticketHandler.CreateTicket)
@@ -735,7 +1022,7 @@ func setupRouter(database *sql.DB, jwtAuth *auth.Service, apiKeyRepo repo.ApiKey
 		simpleTicketUrls.PATCH("/:ticket_id", middleware.TicketReassignmentMiddleware(), ticketHandler.UpdateTicket)
 
 		// Delete ticket (requires admin permissions)
-		simpleTicketUrls.DELETE("/:ticket_id", middleware.ProjectAdminMiddleware(), ticketHandler.DeleteTicket)
+		simpleTicketUrls.DELETE("/:ticket_id", middleware.ProjectAdminMiddleware(), middleware.RequirePermission(rbacService, rbac.PermTicketAdmin, rbac.PermTicketAdmin), ticketHandler.DeleteTicket)
 	}
 
 	return router
@@ -84,6 +84,25 @@ func runMigrations(database *db.DB) error {
 		"migrations/037_project_integrations.sql",
 		"migrations/038_add_chat_sessions_meta.sql",
 		"migrations/039_add_slack_columns_to_chat_sessions.sql",
+		"migrations/040_billing_plans.sql",
+		"migrations/041_api_key_scopes_and_ip_allowlist.sql",
+		"migrations/042_custom_roles.sql",
+		"migrations/043_agent_mfa.sql",
+		"migrations/044_tenant_sso.sql",
+		"migrations/045_project_inbound_email_key.sql",
+		"migrations/046_chat_idle_timeout.sql",
+		"migrations/047_chat_unanswered_ticket.sql",
+		"migrations/048_chat_identity_verification.sql",
+		"migrations/049_agent_max_concurrent_chats.sql",
+		"migrations/050_chat_message_sequence.sql",
+		"migrations/051_ai_profiles.sql",
+		"migrations/052_ai_tools.sql",
+		"migrations/053_ticket_triage.sql",
+		"migrations/054_chat_context_summary.sql",
+		"migrations/055_ai_guardrail_events.sql",
+		"migrations/056_ai_evaluations.sql",
+		"migrations/057_ai_answer_cache.sql",
+		"migrations/058_message_translations.sql",
 	}
 
 	for _, migration := range migrations {
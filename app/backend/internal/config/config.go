@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -30,11 +31,12 @@ type Config struct {
 	Slack         SlackConfig         `mapstructure:"slack"`
 }
 
-// SlackConfig represents Slack OAuth configuration
+// SlackConfig represents Slack OAuth and request signing configuration
 type SlackConfig struct {
-	ClientID     string `mapstructure:"client_id"`
-	ClientSecret string `mapstructure:"client_secret"`
-	RedirectURI  string `mapstructure:"redirect_uri"`
+	ClientID      string `mapstructure:"client_id"`
+	ClientSecret  string `mapstructure:"client_secret"`
+	RedirectURI   string `mapstructure:"redirect_uri"`
+	SigningSecret string `mapstructure:"signing_secret"`
 }
 
 // ServerConfig represents server configuration
@@ -100,6 +102,7 @@ type JWTConfig struct {
 	RefreshTokenExpiry time.Duration `mapstructure:"refresh_token_expiry"`
 	MagicLinkExpiry    time.Duration `mapstructure:"magic_link_expiry"`
 	UnauthTokenExpiry  time.Duration `mapstructure:"unauth_token_expiry"`
+	MFAIssuer          string        `mapstructure:"mfa_issuer"` // Issuer shown in authenticator apps
 }
 
 // FeatureFlags represents feature toggles
@@ -152,6 +155,8 @@ type EmailConfig struct {
 	MaxAttachmentSize          int64         `mapstructure:"max_attachment_size"`
 	EnableEmailToTicket        bool          `mapstructure:"enable_email_to_ticket"`
 	DefaultReturnPathDomain    string        `mapstructure:"default_return_path_domain"`
+	InboundDomain              string        `mapstructure:"inbound_domain"` // Domain served by the bundled SMTP email-server
+	IngestSecret               string        `mapstructure:"ingest_secret"`  // Shared secret the email-server signs requests with
 }
 
 // ObservabilityConfig represents observability configuration
@@ -176,6 +181,27 @@ type AIConfig struct {
 	SystemPrompt         string        `mapstructure:"system_prompt"`
 	HandoffKeywords      []string      `mapstructure:"handoff_keywords"`
 	AutoHandoffTime      time.Duration `mapstructure:"auto_handoff_time"`
+
+	// Context budgeting; the window is looked up by model name when not set
+	ContextWindow      int `mapstructure:"context_window"`       // tokens the model accepts, prompt and reply together
+	ContextTokenBudget int `mapstructure:"context_token_budget"` // most prompt tokens sent per reply, to bound cost
+
+	// Fallbacks are tried in order when the provider fails with a rate limit, server error or timeout
+	Fallbacks               []AIFallbackConfig `mapstructure:"fallbacks"`
+	RequestTimeout          time.Duration      `mapstructure:"request_timeout"`           // per attempt; for streams, the longest wait for the next token
+	MaxRetries              int                `mapstructure:"max_retries"`               // retries of a provider before failing over
+	RetryBackoff            time.Duration      `mapstructure:"retry_backoff"`             // doubled on every retry
+	CircuitBreakerThreshold int                `mapstructure:"circuit_breaker_threshold"` // consecutive failures that take a provider out
+	CircuitBreakerCooldown  time.Duration      `mapstructure:"circuit_breaker_cooldown"`  // before a provider taken out is tried again
+}
+
+// AIFallbackConfig is a provider to fail over to. Models are named differently per provider, so each
+// fallback has its own; for Azure OpenAI it is the deployment name.
+type AIFallbackConfig struct {
+	Provider string `mapstructure:"provider"`
+	APIKey   string `mapstructure:"api_key"`
+	BaseURL  string `mapstructure:"base_url"`
+	Model    string `mapstructure:"model"`
 }
 
 // KnowledgeConfig represents knowledge management configuration
@@ -219,6 +245,13 @@ type CashfreeConfig struct {
 // OAuthConfig represents OAuth provider configuration
 type OAuthConfig struct {
 	Google GoogleOAuthConfig `mapstructure:"google"`
+	SSO    SSOConfig         `mapstructure:"sso"`
+}
+
+// SSOConfig represents tenant single sign-on configuration
+type SSOConfig struct {
+	// BaseURL is the public URL of this API, used for OIDC callbacks and the SAML ACS/entity ID
+	BaseURL string `mapstructure:"base_url"`
 }
 
 // GoogleOAuthConfig represents Google OAuth configuration
@@ -282,6 +315,11 @@ func Load() (*Config, error) {
 	viper.BindEnv("ai.temperature", "AI_TEMPERATURE")
 	viper.BindEnv("ai.system_prompt", "AI_SYSTEM_PROMPT")
 	viper.BindEnv("ai.auto_handoff_time", "AI_AUTO_HANDOFF_TIME")
+	viper.BindEnv("ai.request_timeout", "AI_REQUEST_TIMEOUT")
+	viper.BindEnv("ai.max_retries", "AI_MAX_RETRIES")
+	viper.BindEnv("ai.retry_backoff", "AI_RETRY_BACKOFF")
+	viper.BindEnv("ai.circuit_breaker_threshold", "AI_CIRCUIT_BREAKER_THRESHOLD")
+	viper.BindEnv("ai.circuit_breaker_cooldown", "AI_CIRCUIT_BREAKER_COOLDOWN")
 
 	// Knowledge management configuration bindings
 	viper.BindEnv("knowledge.enabled", "KNOWLEDGE_ENABLED")
@@ -305,6 +343,8 @@ func Load() (*Config, error) {
 
 	// Email subsystem bindings
 	viper.BindEnv("email.provider", "EMAIL_PROVIDER")
+	viper.BindEnv("email.inbound_domain", "MAIL_DOMAIN")
+	viper.BindEnv("email.ingest_secret", "EMAIL_INGEST_SECRET")
 
 	// Resend configuration bindings
 	viper.BindEnv("resend.api_key", "RESEND_API_KEY")
@@ -325,11 +365,13 @@ func Load() (*Config, error) {
 	viper.BindEnv("oauth.google.client_id", "GOOGLE_CLIENT_ID")
 	viper.BindEnv("oauth.google.client_secret", "GOOGLE_CLIENT_SECRET")
 	viper.BindEnv("oauth.google.redirect_url", "GOOGLE_REDIRECT_URL")
+	viper.BindEnv("oauth.sso.base_url", "SSO_BASE_URL")
 
 	// Slack configuration bindings
 	viper.BindEnv("slack.client_id", "SLACK_CLIENT_ID")
 	viper.BindEnv("slack.client_secret", "SLACK_CLIENT_SECRET")
 	viper.BindEnv("slack.redirect_uri", "SLACK_REDIRECT_URI")
+	viper.BindEnv("slack.signing_secret", "SLACK_SIGNING_SECRET")
 
 	// Read config file (optional)
 	if err := viper.ReadInConfig(); err != nil {
@@ -364,6 +406,25 @@ func Load() (*Config, error) {
 		config.CORS.AllowedOrigins = origins
 	}
 
+	// Handle comma-separated AI_FALLBACK_PROVIDERS, each configured by AI_FALLBACK_<PROVIDER>_API_KEY,
+	// _BASE_URL and _MODEL
+	if providersStr := os.Getenv("AI_FALLBACK_PROVIDERS"); providersStr != "" {
+		config.AI.Fallbacks = nil
+		for _, provider := range strings.Split(providersStr, ",") {
+			provider = strings.ToLower(strings.TrimSpace(provider))
+			if provider == "" {
+				continue
+			}
+			prefix := "AI_FALLBACK_" + strings.ToUpper(provider) + "_"
+			config.AI.Fallbacks = append(config.AI.Fallbacks, AIFallbackConfig{
+				Provider: provider,
+				APIKey:   os.Getenv(prefix + "API_KEY"),
+				BaseURL:  os.Getenv(prefix + "BASE_URL"),
+				Model:    os.Getenv(prefix + "MODEL"),
+			})
+		}
+	}
+
 	return &config, nil
 }
 
@@ -408,12 +469,14 @@ func setDefaults() {
 
 	// Email provider defaults
 	viper.SetDefault("email.provider", "resend")
+	viper.SetDefault("email.inbound_domain", "hith.chat")
 	viper.SetDefault("maileroo.timeout_seconds", 30)
 
 	// JWT defaults
 	viper.SetDefault("jwt.secret", "your-secret-key")
 	viper.SetDefault("jwt.access_token_expiry", "24h")
 	viper.SetDefault("jwt.refresh_token_expiry", "168h")
+	viper.SetDefault("jwt.mfa_issuer", "Hith")
 
 	// Feature flags defaults
 	viper.SetDefault("features.enable_registration", true)
@@ -429,6 +492,12 @@ func setDefaults() {
 	viper.SetDefault("ai.temperature", 0.7)
 	viper.SetDefault("ai.system_prompt", "You are a helpful customer support assistant. Be concise, professional, and friendly. If you cannot help with a request, suggest that a human agent will take over.")
 	viper.SetDefault("ai.auto_handoff_time", "10m")
+	viper.SetDefault("ai.context_token_budget", 12000)
+	viper.SetDefault("ai.request_timeout", "30s")
+	viper.SetDefault("ai.max_retries", 1)
+	viper.SetDefault("ai.retry_backoff", "500ms")
+	viper.SetDefault("ai.circuit_breaker_threshold", 5)
+	viper.SetDefault("ai.circuit_breaker_cooldown", "30s")
 
 	// Knowledge management defaults
 	viper.SetDefault("knowledge.enabled", true)
@@ -459,6 +528,7 @@ func setDefaults() {
 		"https://www.googleapis.com/auth/userinfo.profile",
 	})
 	viper.SetDefault("oauth.google.redirect_url", "http://localhost:3000/auth/google/callback")
+	viper.SetDefault("oauth.sso.base_url", "http://localhost:8080")
 
 	// Slack defaults
 	viper.SetDefault("slack.client_id", "9933968395767.9943091289283")
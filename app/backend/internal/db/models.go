@@ -43,6 +43,9 @@ const (
 	AgentStatusDoNotDisturb AgentStatus = "dnd"
 )
 
+// DefaultMaxConcurrentChats is the chat capacity of new agents
+const DefaultMaxConcurrentChats = 5
+
 // AgentSkill represents skills an agent possesses
 type AgentSkill string
 
@@ -57,21 +60,23 @@ const (
 
 // Agent represents a user who can access the system
 type Agent struct {
-	ID              uuid.UUID    `db:"id" json:"id"`
-	TenantID        uuid.UUID    `db:"tenant_id" json:"tenant_id"`
-	Email           string       `db:"email" json:"email" validate:"required,email"`
-	Name            string       `db:"name" json:"name" validate:"required,min=1,max=255"`
-	Status          AgentStatus  `db:"status" json:"status" validate:"oneof=active inactive suspended"`
-	PasswordHash    *string      `db:"password_hash" json:"-"`
-	CreatedAt       time.Time    `db:"created_at" json:"created_at"`
-	UpdatedAt       time.Time    `db:"updated_at" json:"updated_at"`
-	Skills          []AgentSkill `json:"skills"`
-	ActiveChats     int          `json:"active_chats"`
-	MaxChats        int          `json:"max_chats"`
-	AvgResponseTime float64      `json:"avg_response_time_seconds"`
-	LastActivity    time.Time    `json:"last_activity"`
-	LastAssignment  time.Time    `json:"last_assignment"`
-	Workload        float64      `json:"workload"` // 0.0 to 1.0 representing capacity usage
+	ID           uuid.UUID    `db:"id" json:"id"`
+	TenantID     uuid.UUID    `db:"tenant_id" json:"tenant_id"`
+	Email        string       `db:"email" json:"email" validate:"required,email"`
+	Name         string       `db:"name" json:"name" validate:"required,min=1,max=255"`
+	Status       AgentStatus  `db:"status" json:"status" validate:"oneof=active inactive suspended"`
+	PasswordHash *string      `db:"password_hash" json:"-"`
+	CreatedAt    time.Time    `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time    `db:"updated_at" json:"updated_at"`
+	Skills       []AgentSkill `json:"skills"`
+	ActiveChats  int          `json:"active_chats"`
+	MaxChats     int          `db:"max_chats" json:"max_chats"`
+	// PreferredLanguage is the ISO 639-1 code customer messages are translated into for the agent
+	PreferredLanguage *string   `db:"preferred_language" json:"preferred_language,omitempty"`
+	AvgResponseTime   float64   `json:"avg_response_time_seconds"`
+	LastActivity      time.Time `json:"last_activity"`
+	LastAssignment    time.Time `json:"last_assignment"`
+	Workload          float64   `json:"workload"` // 0.0 to 1.0 representing capacity usage
 }
 
 // AgentProjectRole represents role binding between agents and projects
@@ -235,22 +240,87 @@ type RoleBinding struct {
 	UpdatedAt time.Time       `db:"updated_at" json:"updated_at"`
 }
 
+// TenantRole represents a custom role defined by a tenant from the permission catalogue
+type TenantRole struct {
+	ID          uuid.UUID      `db:"id" json:"id"`
+	TenantID    uuid.UUID      `db:"tenant_id" json:"tenant_id"`
+	Name        string         `db:"name" json:"name"`
+	Description *string        `db:"description" json:"description,omitempty"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	CreatedAt   time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+// AgentMFA represents the TOTP second factor of an agent
+type AgentMFA struct {
+	AgentID         uuid.UUID  `db:"agent_id" json:"agent_id"`
+	TenantID        uuid.UUID  `db:"tenant_id" json:"tenant_id"`
+	SecretEncrypted string     `db:"secret_encrypted" json:"-"`
+	Enabled         bool       `db:"enabled" json:"enabled"`
+	EnabledAt       *time.Time `db:"enabled_at" json:"enabled_at,omitempty"`
+	LastUsedStep    int64      `db:"last_used_step" json:"-"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// MFA policies a tenant can enforce
+const (
+	MFAPolicyOff    = "off"
+	MFAPolicyAdmins = "admins"
+	MFAPolicyAll    = "all"
+)
+
+// TenantSecuritySettings represents the authentication policy of a tenant
+type TenantSecuritySettings struct {
+	TenantID              uuid.UUID `db:"tenant_id" json:"tenant_id"`
+	MFAPolicy             string    `db:"mfa_policy" json:"mfa_policy"`
+	PasswordLoginDisabled bool      `db:"password_login_disabled" json:"password_login_disabled"`
+	CreatedAt             time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// SSOProvider represents a tenant's OIDC or SAML 2.0 identity provider
+type SSOProvider struct {
+	ID                    uuid.UUID               `db:"id" json:"id"`
+	TenantID              uuid.UUID               `db:"tenant_id" json:"tenant_id"`
+	Name                  string                  `db:"name" json:"name"`
+	Protocol              string                  `db:"protocol" json:"protocol"`
+	Enabled               bool                    `db:"enabled" json:"enabled"`
+	IssuerURL             *string                 `db:"issuer_url" json:"issuer_url,omitempty"`
+	ClientID              *string                 `db:"client_id" json:"client_id,omitempty"`
+	ClientSecretEncrypted *string                 `db:"client_secret_encrypted" json:"-"`
+	Scopes                pq.StringArray          `db:"scopes" json:"scopes"`
+	IDPEntityID           *string                 `db:"idp_entity_id" json:"idp_entity_id,omitempty"`
+	IDPSSOURL             *string                 `db:"idp_sso_url" json:"idp_sso_url,omitempty"`
+	IDPCertificate        *string                 `db:"idp_certificate" json:"idp_certificate,omitempty"`
+	GroupsClaim           string                  `db:"groups_claim" json:"groups_claim"`
+	EmailDomains          pq.StringArray          `db:"email_domains" json:"email_domains"`
+	JITProvisioning       bool                    `db:"jit_provisioning" json:"jit_provisioning"`
+	DefaultRole           models.RoleType         `db:"default_role" json:"default_role"`
+	DefaultProjectID      *uuid.UUID              `db:"default_project_id" json:"default_project_id,omitempty"`
+	GroupMappings         models.SSOGroupMappings `db:"group_mappings" json:"group_mappings"`
+	CreatedAt             time.Time               `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time               `db:"updated_at" json:"updated_at"`
+}
+
 // ApiKey represents an API key for tenant/project access
 type ApiKey struct {
 	ID        uuid.UUID `db:"id" json:"id"`
 	TenantID  uuid.UUID `db:"tenant_id" json:"tenant_id"`
 	ProjectID uuid.UUID `db:"project_id" json:"project_id,omitempty"`
 
-	Name       string         `db:"name" json:"name" validate:"required,min=1,max=255"`
-	KeyHash    string         `db:"key_hash" json:"-"`             // Never expose the hash
-	KeyPrefix  string         `db:"key_prefix" json:"key_preview"` // For display
-	Scopes     pq.StringArray `db:"scopes" json:"scopes,omitempty"`
-	LastUsedAt *time.Time     `db:"last_used_at" json:"last_used,omitempty"`
-	ExpiresAt  *time.Time     `db:"expires_at" json:"expires_at,omitempty"`
-	IsActive   bool           `db:"is_active" json:"is_active"`
-	AgentID    uuid.UUID      `db:"agent_id" json:"agent_id"`
-	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt  time.Time      `db:"updated_at" json:"updated_at"`
+	Name      string         `db:"name" json:"name" validate:"required,min=1,max=255"`
+	KeyHash   string         `db:"key_hash" json:"-"`             // Never expose the hash
+	KeyPrefix string         `db:"key_prefix" json:"key_preview"` // For display
+	Scopes    pq.StringArray `db:"scopes" json:"scopes,omitempty"`
+	// AllowedCIDRs restricts the key to these client networks; empty allows any address
+	AllowedCIDRs pq.StringArray `db:"allowed_cidrs" json:"allowed_cidrs,omitempty"`
+	LastUsedAt   *time.Time     `db:"last_used_at" json:"last_used,omitempty"`
+	ExpiresAt    *time.Time     `db:"expires_at" json:"expires_at,omitempty"`
+	IsActive     bool           `db:"is_active" json:"is_active"`
+	AgentID      uuid.UUID      `db:"agent_id" json:"agent_id"`
+	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time      `db:"updated_at" json:"updated_at"`
 }
 
 // Credits represents the credit balance for a tenant
@@ -279,6 +349,43 @@ type CreditTransaction struct {
 	CreatedAt       time.Time `db:"created_at" json:"created_at"`
 }
 
+// BillingPlan represents a subscription plan with resource limits and a monthly AI credit allowance.
+// A nil limit means the resource is not restricted by the plan.
+type BillingPlan struct {
+	ID                  uuid.UUID `db:"id" json:"id"`
+	Code                string    `db:"code" json:"code"`
+	Name                string    `db:"name" json:"name"`
+	SeatLimit           *int      `db:"seat_limit" json:"seat_limit,omitempty"`
+	ProjectLimit        *int      `db:"project_limit" json:"project_limit,omitempty"`
+	WidgetLimit         *int      `db:"widget_limit" json:"widget_limit,omitempty"`
+	KnowledgePageLimit  *int      `db:"knowledge_page_limit" json:"knowledge_page_limit,omitempty"`
+	MonthlyAICredits    int64     `db:"monthly_ai_credits" json:"monthly_ai_credits"`
+	LowBalanceThreshold int64     `db:"low_balance_threshold" json:"low_balance_threshold"`
+	IsActive            bool      `db:"is_active" json:"is_active"`
+	CreatedAt           time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt           time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// TenantPlan binds a tenant to its active billing plan
+type TenantPlan struct {
+	TenantID             uuid.UUID    `db:"tenant_id" json:"tenant_id"`
+	PlanID               uuid.UUID    `db:"plan_id" json:"plan_id"`
+	LastGrantAt          *time.Time   `db:"last_grant_at" json:"last_grant_at,omitempty"`
+	LowBalanceNotifiedAt *time.Time   `db:"low_balance_notified_at" json:"low_balance_notified_at,omitempty"`
+	ExhaustedNotifiedAt  *time.Time   `db:"exhausted_notified_at" json:"exhausted_notified_at,omitempty"`
+	CreatedAt            time.Time    `db:"created_at" json:"created_at"`
+	UpdatedAt            time.Time    `db:"updated_at" json:"updated_at"`
+	Plan                 *BillingPlan `db:"-" json:"plan,omitempty"`
+}
+
+// PlanUsage captures the current consumption of plan-limited resources for a tenant
+type PlanUsage struct {
+	Seats          int `json:"seats"`
+	Projects       int `json:"projects"`
+	Widgets        int `json:"widgets"`
+	KnowledgePages int `json:"knowledge_pages"`
+}
+
 // PaymentWebhookEvent represents a webhook event received from payment gateways
 type PaymentWebhookEvent struct {
 	ID             int64           `db:"id" json:"id"`
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/rbac"
 	"github.com/bareuptime/tms/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -157,6 +159,10 @@ func (h *AgentHandler) CreateAgent(c *gin.Context) {
 
 	agent, err := h.agentService.CreateAgent(c.Request.Context(), tenantID, creatorAgentID, req)
 	if err != nil {
+		if errors.Is(err, service.ErrPlanLimitReached) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create agent"})
 		return
 	}
@@ -245,8 +251,18 @@ func (h *AgentHandler) UpdateAgent(c *gin.Context) {
 		return
 	}
 
-	agent, statusCode, err := h.agentService.UpdateAgent(c.Request.Context(), tenantID, requestAgentID, paramsAgentID, req)
+	// Chat capacity is set by admins, not by agents themselves
+	if req.MaxConcurrentChats != nil && !isTenantAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only tenant admins can change the chat capacity"})
+		return
+	}
+
+	agent, statusCode, err := h.agentService.UpdateAgent(c.Request.Context(), tenantID, paramsAgentID, requestAgentID, req)
 	if err != nil {
+		if statusCode == http.StatusBadRequest {
+			c.JSON(statusCode, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(statusCode, gin.H{"error": "Failed to update agent"})
 		return
 	}
@@ -296,6 +312,10 @@ func (h *AgentHandler) AssignRole(c *gin.Context) {
 
 	err = h.agentService.AssignRole(c.Request.Context(), tenantID, agentID, assignerAgentID, req)
 	if err != nil {
+		if errors.Is(err, rbac.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
 		return
 	}
@@ -413,6 +433,10 @@ func (h *AgentHandler) AssignToProject(c *gin.Context) {
 
 	err = h.agentService.AssignToProject(c.Request.Context(), tenantID, agentID, req)
 	if err != nil {
+		if errors.Is(err, rbac.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("Failed to assign agent to project: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign agent to project"})
 		return
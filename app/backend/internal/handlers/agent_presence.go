@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// GetMyPresence returns the calling agent's presence
+// @Summary Get my presence
+// @Description Get the calling agent's status and chat load as other agents see it
+// @Tags Agent WebSocket
+// @Produce json
+// @Security BearerAuth
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Success 200 {object} models.AgentPresence
+// @Failure 500 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/chat/agent/presence [get]
+func (h *AgentWebSocketHandler) GetMyPresence(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	agentID := middleware.GetAgentID(c)
+
+	presence, err := h.presenceService.GetPresence(c.Request.Context(), tenantID, agentID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get presence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, presence)
+}
+
+// SetMyPresence sets the calling agent's status
+// @Summary Set my presence
+// @Description Set the calling agent's status. Away, busy and offline stick until changed; online goes back to following the agent's connection.
+// @Tags Agent WebSocket
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param presence body models.SetAgentPresenceRequest true "New status"
+// @Success 200 {object} models.AgentPresence
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/chat/agent/presence [put]
+func (h *AgentWebSocketHandler) SetMyPresence(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	agentID := middleware.GetAgentID(c)
+
+	var req models.SetAgentPresenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	presence, err := h.presenceService.SetStatus(c.Request.Context(), tenantID, agentID, req.Status, time.Now())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPresenceStatus) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set presence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, presence)
+}
+
+// ListProjectPresence lists who is online in a project
+// @Summary List agent presence
+// @Description List the project's agents who are online, away or busy, with their active chats and capacity. Agents who can take another chat come first.
+// @Tags chat-sessions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param project_id path string true "Project ID" format(uuid)
+// @Success 200 {object} object{agents=[]models.AgentPresence}
+// @Failure 500 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/chat/presence [get]
+func (h *AgentWebSocketHandler) ListProjectPresence(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	presences, err := h.presenceService.ListProjectPresence(c.Request.Context(), tenantID, projectID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list agent presence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"agents": presences})
+}
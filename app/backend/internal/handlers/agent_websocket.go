@@ -21,7 +21,7 @@ import (
 // AgentWebSocketRequest represents WebSocket message types that agents can send
 // @Description WebSocket message format for agent communications
 type AgentWebSocketRequest struct {
-	Type            string      `json:"type" example:"chat_message" enums:"chat_message,typing_start,typing_stop,ping,session_subscribe,session_unsubscribe"`
+	Type            string      `json:"type" example:"chat_message" enums:"chat_message,typing_start,typing_stop,ping,agent_presence,session_subscribe,session_unsubscribe,ai_assist"`
 	ClientSessionID *string     `json:"client_session_id,omitempty" example:"123e4567-e89b-12d3-a456-426614174000"`
 	AgentSessionID  *string     `json:"agent_session_id,omitempty" example:"123e4567-e89b-12d3-a456-426614174000"`
 	ProjectID       *string     `json:"project_id,omitempty" example:"123e4567-e89b-12d3-a456-426614174000"`
@@ -33,7 +33,7 @@ type AgentWebSocketRequest struct {
 // AgentWebSocketResponse represents WebSocket message types that agents receive
 // @Description WebSocket message format for agent responses
 type AgentWebSocketResponse struct {
-	Type      string      `json:"type" example:"agent_connected" enums:"agent_connected,pong,error,chat_message,typing_start,typing_stop,notification"`
+	Type      string      `json:"type" example:"agent_connected" enums:"agent_connected,pong,error,chat_message,typing_start,typing_stop,notification,agent_presence"`
 	SessionID string      `json:"session_id" example:"123e4567-e89b-12d3-a456-426614174000"`
 	Data      interface{} `json:"data"`
 	FromType  string      `json:"from_type" example:"agent" enums:"agent,customer,system"`
@@ -74,14 +74,18 @@ type AgentWebSocketHandler struct {
 	chatSessionService *service.ChatSessionService
 	connectionManager  *ws.ConnectionManager
 	agentService       *service.AgentService
+	presenceService    *service.AgentPresenceService
+	assistService      *service.AIAssistService
 	chatWSHandler      *ChatWebSocketHandler // Reference to main WebSocket handler
 }
 
-func NewAgentWebSocketHandler(chatSessionService *service.ChatSessionService, connectionManager *ws.ConnectionManager, agentService *service.AgentService) *AgentWebSocketHandler {
+func NewAgentWebSocketHandler(chatSessionService *service.ChatSessionService, connectionManager *ws.ConnectionManager, agentService *service.AgentService, presenceService *service.AgentPresenceService, assistService *service.AIAssistService) *AgentWebSocketHandler {
 	handler := &AgentWebSocketHandler{
 		chatSessionService: chatSessionService,
 		connectionManager:  connectionManager,
 		agentService:       agentService,
+		presenceService:    presenceService,
+		assistService:      assistService,
 		chatWSHandler:      nil, // Will be set later
 	}
 
@@ -146,6 +150,16 @@ func (h *AgentWebSocketHandler) HandleAgentWebSocket(c *gin.Context) {
 		h.connectionManager.RemoveConnection(connectionID)
 	}()
 
+	// Track presence across replicas; the agent goes offline with their last connection
+	if err := h.presenceService.Connect(c.Request.Context(), agent, projects, time.Now()); err != nil {
+		log.Printf("Failed to record agent presence: %v", err)
+	}
+	defer func() {
+		if err := h.presenceService.Disconnect(context.Background(), agentID, time.Now()); err != nil {
+			log.Printf("Failed to record agent disconnection: %v", err)
+		}
+	}()
+
 	// Send welcome message to agent
 	welcomeMsg := &ws.Message{
 		Type:      "agent_connected",
@@ -163,6 +177,7 @@ func (h *AgentWebSocketHandler) HandleAgentWebSocket(c *gin.Context) {
 	// Set up ping handler for connection health
 	conn.SetPongHandler(func(string) error {
 		h.connectionManager.UpdateConnectionPing(connectionID)
+		h.presenceService.Heartbeat(c.Request.Context(), agentID, time.Now())
 		return nil
 	})
 
@@ -191,6 +206,12 @@ func (h *AgentWebSocketHandler) handleAgentGlobalMessage(ctx context.Context, te
 		h.broadcastTypingIndicator(ctx, *msg.AgentSessionID, string(msg.Type), agentName)
 
 	case "ping":
+		// The console pings periodically, which doubles as the presence heartbeat
+		h.connectionManager.UpdateConnectionPing(connectionID)
+		if err := h.presenceService.Heartbeat(ctx, agentUUID, time.Now()); err != nil {
+			log.Printf("Failed to record agent heartbeat: %v", err)
+		}
+
 		// Respond to ping
 		pongMsg := &ws.Message{
 			Type:      "pong",
@@ -199,6 +220,13 @@ func (h *AgentWebSocketHandler) handleAgentGlobalMessage(ctx context.Context, te
 			FromType:  ws.ConnectionTypeAgent,
 		}
 		h.connectionManager.SendToConnection(connectionID, pongMsg)
+	case models.WSMsgTypeAgentPresence:
+		h.handlePresenceChange(ctx, tenantID, agentUUID, msg, connectionID)
+	case models.WSMsgTypeAIAssist:
+		if msg.AgentSessionID != nil && msg.ProjectID != nil {
+			// Generating takes a while; keep reading the agent's other messages meanwhile
+			go h.handleAIAssist(context.Background(), tenantID, agentUUID, *msg.ProjectID, *msg.AgentSessionID, msg, connectionID)
+		}
 	case "session_subscribe":
 		// Agent wants to receive updates for a specific session
 		if msg.AgentSessionID != nil {
@@ -261,6 +289,103 @@ func (h *AgentWebSocketHandler) handleChatMessage(ctx context.Context, tenantID,
 	}
 }
 
+// handlePresenceChange sets the status the agent picked in the console, e.g. {"status": "away"}
+func (h *AgentWebSocketHandler) handlePresenceChange(ctx context.Context, tenantID, agentUUID uuid.UUID, msg models.WSMessage, connectionID string) {
+	var presenceData struct {
+		Status string `json:"status"`
+	}
+	dataBytes, err := json.Marshal(msg.Data)
+	if err == nil {
+		err = json.Unmarshal(dataBytes, &presenceData)
+	}
+	if err == nil {
+		_, err = h.presenceService.SetStatus(ctx, tenantID, agentUUID, presenceData.Status, time.Now())
+	}
+	if err != nil {
+		log.Printf("Failed to set agent presence: %v", err)
+		errorData, _ := json.Marshal(ErrorData{Error: "Failed to set presence", Details: err.Error()})
+		errorMsg := &ws.Message{
+			Type:      "error",
+			SessionID: agentUUID,
+			Data:      errorData,
+			FromType:  ws.ConnectionTypeAgent,
+		}
+		h.connectionManager.SendToConnection(connectionID, errorMsg)
+	}
+}
+
+// handleAIAssist answers an agent's request for reply suggestions or a summary of a session, e.g.
+// {"action": "suggest_replies", "count": 3}, with an ai_assist_result message on the same connection
+func (h *AgentWebSocketHandler) handleAIAssist(ctx context.Context, tenantID, agentUUID, projectID, sessionID uuid.UUID, msg models.WSMessage, connectionID string) {
+	var assistData struct {
+		Action string `json:"action"`
+		models.AIReplySuggestionsRequest
+	}
+	dataBytes, err := json.Marshal(msg.Data)
+	if err == nil {
+		err = json.Unmarshal(dataBytes, &assistData)
+	}
+
+	// Assistance is billed to the project, so the agent has to belong to it
+	if err == nil && !h.agentInProject(ctx, tenantID, agentUUID, projectID) {
+		err = service.ErrAIAssistNotFound
+	}
+
+	var result interface{}
+	if err == nil {
+		switch {
+		case h.assistService == nil:
+			err = service.ErrAIDisabled
+		case assistData.Action == models.AIAssistActionSuggestReplies:
+			result, err = h.assistService.SuggestChatReplies(ctx, tenantID, projectID, sessionID, &assistData.AIReplySuggestionsRequest)
+		case assistData.Action == models.AIAssistActionSummarize:
+			result, err = h.assistService.SummarizeChat(ctx, tenantID, projectID, sessionID)
+		default:
+			err = fmt.Errorf("unknown AI assist action %q", assistData.Action)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to assist agent in session %s: %v", sessionID, err)
+		_, message := aiAssistErrorStatus(err)
+		errorData, _ := json.Marshal(ErrorData{Error: "Failed to assist", Details: message})
+		errorMsg := &ws.Message{
+			Type:      "error",
+			SessionID: sessionID,
+			Data:      errorData,
+			FromType:  ws.ConnectionTypeAgent,
+		}
+		h.connectionManager.SendToConnection(connectionID, errorMsg)
+		return
+	}
+
+	resultData, _ := json.Marshal(map[string]interface{}{
+		"action":     assistData.Action,
+		"session_id": sessionID,
+		"result":     result,
+	})
+	resultMsg := &ws.Message{
+		Type:      string(models.WSMsgTypeAIAssistResult),
+		SessionID: sessionID,
+		Data:      resultData,
+		FromType:  ws.ConnectionTypeAgent,
+	}
+	h.connectionManager.SendToConnection(connectionID, resultMsg)
+}
+
+func (h *AgentWebSocketHandler) agentInProject(ctx context.Context, tenantID, agentUUID, projectID uuid.UUID) bool {
+	projects, err := h.agentService.GetAgentProjectsList(ctx, tenantID, agentUUID)
+	if err != nil {
+		log.Printf("Failed to get agent projects: %v", err)
+		return false
+	}
+	for _, id := range projects {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *AgentWebSocketHandler) broadcastTypingIndicator(ctx context.Context, sessionID uuid.UUID, typingType, agentName string) {
 	typingData := map[string]interface{}{
 		"author_type": "agent",
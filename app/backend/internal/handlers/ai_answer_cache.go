@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// AIAnswerCacheHandler manages how a project reuses AI answers for repeated questions, and reports how
+// often it does
+type AIAnswerCacheHandler struct {
+	answerCacheService *service.AIAnswerCacheService
+}
+
+// NewAIAnswerCacheHandler creates a new AI answer cache handler
+func NewAIAnswerCacheHandler(answerCacheService *service.AIAnswerCacheService) *AIAnswerCacheHandler {
+	return &AIAnswerCacheHandler{answerCacheService: answerCacheService}
+}
+
+// GetSettings returns the answer cache settings of a project
+// @Summary Get AI answer cache settings
+// @Description Get whether questions that open a chat are answered like an earlier question that means the same, how similar they have to be and how long answers are reused. Projects that have not set them get the defaults: on, a similarity of 0.95 and a week.
+// @Tags ai-answer-cache
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Success 200 {object} models.AIAnswerCacheSettings
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/answer-cache/settings [get]
+func (h *AIAnswerCacheHandler) GetSettings(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	c.JSON(http.StatusOK, h.answerCacheService.Settings(c.Request.Context(), tenantID, projectID))
+}
+
+// UpdateSettings replaces the answer cache settings of a project
+// @Summary Update AI answer cache settings
+// @Description Replace the answer cache settings of a project. similarity_threshold takes 0.8 to 1, ttl_hours 1 to 720. Answers already cached keep the expiry they were given.
+// @Tags ai-answer-cache
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param settings body models.AIAnswerCacheSettings true "Answer cache settings"
+// @Success 200 {object} models.AIAnswerCacheSettings
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/answer-cache/settings [put]
+func (h *AIAnswerCacheHandler) UpdateSettings(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var req models.AIAnswerCacheSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.answerCacheService.UpdateSettings(c.Request.Context(), tenantID, projectID, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAIAnswerCacheSettings) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update AI answer cache settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// GetStats reports how the answer cache of a project did
+// @Summary Get AI answer cache stats
+// @Description Get the hits, misses, hit rate and tokens saved of the project's answer cache over its last days, with the number of answers it holds and of those visitors gave a thumbs down
+// @Tags ai-answer-cache
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param days query int false "Number of days, today included (default 30, max 90)"
+// @Success 200 {object} models.AIAnswerCacheStats
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/answer-cache/stats [get]
+func (h *AIAnswerCacheHandler) GetStats(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	days := 0
+	if daysStr := c.Query("days"); daysStr != "" {
+		if d, err := strconv.Atoi(daysStr); err == nil && d > 0 {
+			days = d
+		}
+	}
+
+	stats, err := h.answerCacheService.Stats(c.Request.Context(), tenantID, projectID, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get AI answer cache stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// Purge removes every cached answer of a project
+// @Summary Purge AI answer cache
+// @Description Remove every answer the project's answer cache holds, so the next questions are answered by the AI provider again. Stats are kept.
+// @Tags ai-answer-cache
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Success 200 {object} models.AIAnswerCachePurgeResult
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/answer-cache [delete]
+func (h *AIAnswerCacheHandler) Purge(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	result, err := h.answerCacheService.Purge(c.Request.Context(), tenantID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge AI answer cache"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// AIAssistHandler gives agents AI reply suggestions and summaries for chats and tickets
+type AIAssistHandler struct {
+	assistService *service.AIAssistService
+}
+
+// NewAIAssistHandler creates a new agent-assist handler
+func NewAIAssistHandler(assistService *service.AIAssistService) *AIAssistHandler {
+	return &AIAssistHandler{assistService: assistService}
+}
+
+// SuggestChatReplies drafts replies for a chat
+// @Summary Suggest chat replies
+// @Description Draft replies the agent could send next in a chat, grounded in the knowledge base and similar resolved tickets. Tokens are billed to the tenant's AI credits.
+// @Tags ai-assist
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat session ID"
+// @Param request body models.AIReplySuggestionsRequest false "Suggestion options"
+// @Success 200 {object} models.AIReplySuggestions
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 402 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/chat/sessions/{session_id}/ai/suggestions [post]
+func (h *AIAssistHandler) SuggestChatReplies(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	var req models.AIReplySuggestionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	suggestions, err := h.assistService.SuggestChatReplies(c.Request.Context(), tenantID, projectID, sessionID, &req)
+	if err != nil {
+		respondAIAssistError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, suggestions)
+}
+
+// SummarizeChat summarizes a chat
+// @Summary Summarize chat
+// @Description Summarize the transcript of a chat, private notes included, for the agent handling it. Tokens are billed to the tenant's AI credits.
+// @Tags ai-assist
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat session ID"
+// @Success 200 {object} models.AIConversationSummary
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 402 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/chat/sessions/{session_id}/ai/summary [post]
+func (h *AIAssistHandler) SummarizeChat(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	summary, err := h.assistService.SummarizeChat(c.Request.Context(), tenantID, projectID, sessionID)
+	if err != nil {
+		respondAIAssistError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// SuggestTicketReplies drafts replies for a ticket
+// @Summary Suggest ticket replies
+// @Description Draft replies the agent could send next on a ticket, grounded in the knowledge base and similar resolved tickets. Tokens are billed to the tenant's AI credits.
+// @Tags ai-assist
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id path string true "Tenant ID"
+// @Param project_id path string true "Project ID"
+// @Param ticket_id path string true "Ticket ID"
+// @Param request body models.AIReplySuggestionsRequest false "Suggestion options"
+// @Success 200 {object} models.AIReplySuggestions
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 402 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/tickets/{ticket_id}/ai/suggestions [post]
+func (h *AIAssistHandler) SuggestTicketReplies(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	ticketID, err := uuid.Parse(c.Param("ticket_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID format"})
+		return
+	}
+
+	var req models.AIReplySuggestionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	suggestions, err := h.assistService.SuggestTicketReplies(c.Request.Context(), tenantID, projectID, ticketID, &req)
+	if err != nil {
+		respondAIAssistError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, suggestions)
+}
+
+// SummarizeTicket summarizes a ticket
+// @Summary Summarize ticket
+// @Description Summarize the thread of a ticket, private notes included, for the agent handling it. Tokens are billed to the tenant's AI credits.
+// @Tags ai-assist
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id path string true "Tenant ID"
+// @Param project_id path string true "Project ID"
+// @Param ticket_id path string true "Ticket ID"
+// @Success 200 {object} models.AIConversationSummary
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 402 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/tickets/{ticket_id}/ai/summary [post]
+func (h *AIAssistHandler) SummarizeTicket(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	ticketID, err := uuid.Parse(c.Param("ticket_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID format"})
+		return
+	}
+
+	summary, err := h.assistService.SummarizeTicket(c.Request.Context(), tenantID, projectID, ticketID)
+	if err != nil {
+		respondAIAssistError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+func respondAIAssistError(c *gin.Context, err error) {
+	status, message := aiAssistErrorStatus(err)
+	c.JSON(status, gin.H{"error": message})
+}
+
+// aiAssistErrorStatus maps an agent-assist error to the status and message reported to the agent
+func aiAssistErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, service.ErrAIAssistNotFound):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, service.ErrAIAssistEmpty):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, service.ErrAIAssistNoCredits):
+		return http.StatusPaymentRequired, err.Error()
+	case errors.Is(err, service.ErrAIDisabled):
+		return http.StatusServiceUnavailable, err.Error()
+	case errors.Is(err, service.ErrLLMUnavailable):
+		return http.StatusBadGateway, err.Error()
+	default:
+		return http.StatusInternalServerError, "Failed to assist: " + err.Error()
+	}
+}
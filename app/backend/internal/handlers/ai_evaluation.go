@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// maxAIEvalCSVSize caps the size of an uploaded evaluation CSV
+const maxAIEvalCSVSize = 2 << 20
+
+// AIEvaluationHandler manages the evaluation suite of a project's assistant and its runs
+type AIEvaluationHandler struct {
+	evaluationService *service.AIEvaluationService
+}
+
+// NewAIEvaluationHandler creates a new AI evaluation handler
+func NewAIEvaluationHandler(evaluationService *service.AIEvaluationService) *AIEvaluationHandler {
+	return &AIEvaluationHandler{evaluationService: evaluationService}
+}
+
+// ListCases returns the evaluation suite of a project
+// @Summary List AI evaluation cases
+// @Description List the test questions of the project's evaluation suite with the answers and knowledge sources expected for them
+// @Tags ai-evaluations
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Success 200 {object} object{cases=[]models.AIEvalCase}
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/cases [get]
+func (h *AIEvaluationHandler) ListCases(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	cases, err := h.evaluationService.ListCases(c.Request.Context(), tenantID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list evaluation cases"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cases": cases})
+}
+
+// CreateCase adds a case to the evaluation suite of a project
+// @Summary Create AI evaluation case
+// @Description Add a test question to the project's evaluation suite. Expected sources are the URLs or file names of knowledge that should be retrieved and cited; a URL also matches the pages below it. Leave the expected answer or sources empty to not score the case on them.
+// @Tags ai-evaluations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param case body models.AIEvalCaseRequest true "Evaluation case"
+// @Success 201 {object} models.AIEvalCase
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/cases [post]
+func (h *AIEvaluationHandler) CreateCase(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var req models.AIEvalCaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	evalCase, err := h.evaluationService.CreateCase(c.Request.Context(), tenantID, projectID, &req)
+	if err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, evalCase)
+}
+
+// UpdateCase replaces the question and expectations of an evaluation case
+// @Summary Update AI evaluation case
+// @Description Replace the question, expected answer and expected sources of an evaluation case, such as one imported from a thumbs down
+// @Tags ai-evaluations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param case_id path string true "Evaluation case ID"
+// @Param case body models.AIEvalCaseRequest true "Evaluation case"
+// @Success 200 {object} models.AIEvalCase
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/cases/{case_id} [put]
+func (h *AIEvaluationHandler) UpdateCase(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	caseID, err := uuid.Parse(c.Param("case_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid case ID format"})
+		return
+	}
+
+	var req models.AIEvalCaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	evalCase, err := h.evaluationService.UpdateCase(c.Request.Context(), tenantID, projectID, caseID, &req)
+	if err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, evalCase)
+}
+
+// DeleteCase removes a case from the evaluation suite of a project
+// @Summary Delete AI evaluation case
+// @Description Remove a test question from the project's evaluation suite; results of past runs keep it
+// @Tags ai-evaluations
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param case_id path string true "Evaluation case ID"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/cases/{case_id} [delete]
+func (h *AIEvaluationHandler) DeleteCase(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	caseID, err := uuid.Parse(c.Param("case_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid case ID format"})
+		return
+	}
+
+	if err := h.evaluationService.DeleteCase(c.Request.Context(), tenantID, projectID, caseID); err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ImportCSV adds the cases of a CSV file to the evaluation suite of a project
+// @Summary Import AI evaluation cases from CSV
+// @Description Add test questions from a CSV file, uploaded as the file field of a form or sent as a text/csv body. The header names the columns: question is required, expected_answer and expected_sources are optional, and several expected sources are separated by "|". Rows without a question are skipped.
+// @Tags ai-evaluations
+// @Accept multipart/form-data,text/csv
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param file formData file false "CSV file"
+// @Success 201 {object} models.AIEvalImportResult
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/cases/import/csv [post]
+func (h *AIEvaluationHandler) ImportCSV(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAIEvalCSVSize)
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, _, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file from request"})
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	result, err := h.evaluationService.ImportCSV(c.Request.Context(), tenantID, projectID, body)
+	if err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// ImportThumbsDown adds the AI replies visitors gave a thumbs down to the evaluation suite of a project
+// @Summary Import AI evaluation cases from thumbs-down chats
+// @Description Add the questions whose AI reply a visitor gave a thumbs down in the chat widget, newest first. The reply is kept as the rejected answer; fill in the expected answer and sources before the case is scored. Replies that already have a case are skipped.
+// @Tags ai-evaluations
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param since query string false "Only thumbs downs since this time, in RFC 3339 (default 30 days ago)"
+// @Param limit query int false "Maximum number of chats (default 100, max 500)"
+// @Success 201 {object} models.AIEvalImportResult
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/cases/import/thumbs-down [post]
+func (h *AIEvaluationHandler) ImportThumbsDown(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var since *time.Time
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected RFC 3339"})
+			return
+		}
+		since = &parsed
+	}
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	result, err := h.evaluationService.ImportThumbsDown(c.Request.Context(), tenantID, projectID, since, limit)
+	if err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// ListRuns lists the runs of the evaluation suite of a project
+// @Summary List AI evaluation runs
+// @Description List the runs of the project's evaluation suite with their scores, newest first
+// @Tags ai-evaluations
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param limit query int false "Maximum number of runs (default 20, max 100)"
+// @Success 200 {object} object{runs=[]models.AIEvalRun}
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/runs [get]
+func (h *AIEvaluationHandler) ListRuns(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	runs, err := h.evaluationService.ListRuns(c.Request.Context(), tenantID, projectID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list evaluation runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// StartRun runs the evaluation suite of a project
+// @Summary Start AI evaluation run
+// @Description Ask every question of the project's evaluation suite through the same knowledge lookup and AI profile as visitor chats, and score retrieval hit rate, answer similarity and citation accuracy. The run continues in the background; poll it until it is completed or failed. Tokens used are billed like chat replies.
+// @Tags ai-evaluations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param run body models.AIEvalRunRequest false "Run label, such as the change being tested"
+// @Success 202 {object} models.AIEvalRun
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/runs [post]
+func (h *AIEvaluationHandler) StartRun(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	var req models.AIEvalRunRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var startedBy *uuid.UUID
+	if agentID != uuid.Nil {
+		startedBy = &agentID
+	}
+	run, err := h.evaluationService.StartRun(c.Request.Context(), tenantID, projectID, req.Label, startedBy)
+	if err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// GetRun returns a run of the evaluation suite of a project with its results
+// @Summary Get AI evaluation run
+// @Description Get a run with its scores and, for every case, what was retrieved, answered and cited
+// @Tags ai-evaluations
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param run_id path string true "Evaluation run ID"
+// @Success 200 {object} models.AIEvalRunDetail
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/runs/{run_id} [get]
+func (h *AIEvaluationHandler) GetRun(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	runID, err := uuid.Parse(c.Param("run_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run ID format"})
+		return
+	}
+
+	run, err := h.evaluationService.GetRun(c.Request.Context(), tenantID, projectID, runID)
+	if err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// CompareRuns diffs a run of the evaluation suite of a project against a base run
+// @Summary Compare AI evaluation runs
+// @Description Diff a run against a base run, such as the one before a knowledge or prompt change: how each score moved and which cases improved, regressed, changed their answer, were added or were removed
+// @Tags ai-evaluations
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param run_id path string true "Evaluation run ID"
+// @Param base query string true "ID of the run to compare against"
+// @Success 200 {object} models.AIEvalComparison
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/evaluations/runs/{run_id}/compare [get]
+func (h *AIEvaluationHandler) CompareRuns(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	runID, err := uuid.Parse(c.Param("run_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run ID format"})
+		return
+	}
+	baseRunID, err := uuid.Parse(c.Query("base"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid base run ID format"})
+		return
+	}
+
+	comparison, err := h.evaluationService.CompareRuns(c.Request.Context(), tenantID, projectID, baseRunID, runID)
+	if err != nil {
+		respondAIEvaluationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+func respondAIEvaluationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAIEvalCaseNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Evaluation case not found"})
+	case errors.Is(err, service.ErrAIEvalRunNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Evaluation run not found"})
+	case errors.Is(err, service.ErrInvalidAIEvalCase), errors.Is(err, service.ErrInvalidAIEvalCSV), errors.Is(err, service.ErrAIEvalNoCases):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAIEvalSuiteFull):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAIDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to manage AI evaluation: " + err.Error()})
+	}
+}
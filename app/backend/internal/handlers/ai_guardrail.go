@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// AIGuardrailHandler manages how a project guards what its AI sees and says, and lists what the
+// guardrails did
+type AIGuardrailHandler struct {
+	guardrailService *service.AIGuardrailService
+}
+
+// NewAIGuardrailHandler creates a new AI guardrail handler
+func NewAIGuardrailHandler(guardrailService *service.AIGuardrailService) *AIGuardrailHandler {
+	return &AIGuardrailHandler{guardrailService: guardrailService}
+}
+
+// GetSettings returns the guardrail settings of a project
+// @Summary Get AI guardrail settings
+// @Description Get how personal data is masked before it reaches AI providers or storage, how prompt injections are handled and which phrases AI replies must not contain. Projects that have not set them get the defaults: personal data masked for AI, injections in knowledge left out.
+// @Tags ai-guardrails
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Success 200 {object} models.AIGuardrailSettings
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/guardrails [get]
+func (h *AIGuardrailHandler) GetSettings(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	c.JSON(http.StatusOK, h.guardrailService.Settings(c.Request.Context(), tenantID, projectID))
+}
+
+// UpdateSettings replaces the guardrail settings of a project
+// @Summary Update AI guardrail settings
+// @Description Replace the guardrail settings of a project. pii_types takes email, phone, card, iban and national_id; leave it empty to mask all of them.
+// @Tags ai-guardrails
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param settings body models.AIGuardrailSettings true "Guardrail settings"
+// @Success 200 {object} models.AIGuardrailSettings
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/guardrails [put]
+func (h *AIGuardrailHandler) UpdateSettings(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var req models.AIGuardrailSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.guardrailService.UpdateSettings(c.Request.Context(), tenantID, projectID, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPIIType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update AI guardrail settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// ListEvents lists what the guardrails of a project did
+// @Summary List AI guardrail events
+// @Description List the project's guardrail interventions, newest first: personal data masked, knowledge chunks dropped, messages and replies blocked. Events hold the kinds and counts of what was found, never the text itself.
+// @Tags ai-guardrails
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param stage query string false "Only events at this stage (input, knowledge, output, storage)"
+// @Param kind query string false "Only events of this kind (pii, prompt_injection, blocklist)"
+// @Param session_id query string false "Only events of this chat session"
+// @Param since query string false "Only events since this time, in RFC 3339"
+// @Param limit query int false "Maximum number of events (default 50, max 200)"
+// @Success 200 {object} object{events=[]models.AIGuardrailEvent}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/guardrails/events [get]
+func (h *AIGuardrailHandler) ListEvents(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	filter := models.AIGuardrailEventFilter{
+		Stage: c.Query("stage"),
+		Kind:  c.Query("kind"),
+	}
+	switch filter.Stage {
+	case "", models.AIGuardrailStageInput, models.AIGuardrailStageKnowledge, models.AIGuardrailStageOutput, models.AIGuardrailStageStorage:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stage"})
+		return
+	}
+	switch filter.Kind {
+	case "", models.AIGuardrailKindPII, models.AIGuardrailKindPromptInjection, models.AIGuardrailKindBlocklist:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kind"})
+		return
+	}
+	if sessionIDStr := c.Query("session_id"); sessionIDStr != "" {
+		sessionID, err := uuid.Parse(sessionIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+			return
+		}
+		filter.SessionID = &sessionID
+	}
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected RFC 3339"})
+			return
+		}
+		filter.Since = &since
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			filter.Limit = l
+		}
+	}
+
+	events, err := h.guardrailService.ListEvents(c.Request.Context(), tenantID, projectID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list AI guardrail events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
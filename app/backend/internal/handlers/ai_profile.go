@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// AIProfileHandler manages the AI assistant profiles of a project
+type AIProfileHandler struct {
+	profileService *service.AIProfileService
+	aiService      *service.AIService
+}
+
+// NewAIProfileHandler creates a new AI profile handler
+func NewAIProfileHandler(profileService *service.AIProfileService, aiService *service.AIService) *AIProfileHandler {
+	return &AIProfileHandler{
+		profileService: profileService,
+		aiService:      aiService,
+	}
+}
+
+// ListProfiles lists the AI profiles of a project
+// @Summary List AI profiles
+// @Description List the project-wide AI profile and the widget profiles that override it
+// @Tags ai-profiles
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Success 200 {object} object{profiles=[]models.AIProfile}
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/profiles [get]
+func (h *AIProfileHandler) ListProfiles(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	profiles, err := h.profileService.ListProfiles(c.Request.Context(), tenantID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list AI profiles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles})
+}
+
+// GetResolvedProfile returns the AI configuration in effect
+// @Summary Get effective AI configuration
+// @Description The AI configuration chats of the project, or of one of its widgets, currently get: the global configuration overridden by the project-wide and widget profiles
+// @Tags ai-profiles
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param widget_id query string false "Widget ID"
+// @Success 200 {object} models.ResolvedAIProfile
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/profiles/resolved [get]
+func (h *AIProfileHandler) GetResolvedProfile(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var widgetID *uuid.UUID
+	if raw := c.Query("widget_id"); raw != "" {
+		parsed, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid widget ID format"})
+			return
+		}
+		widgetID = &parsed
+	}
+
+	profile, err := h.profileService.ResolveProfile(c.Request.Context(), tenantID, projectID, widgetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve AI profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// CreateProfile creates the project-wide AI profile or the profile of a widget
+// @Summary Create AI profile
+// @Description Create the project-wide AI profile, or a widget's profile when widget_id is set. Fields left unset are inherited from the project profile and then from the global configuration.
+// @Tags ai-profiles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param profile body models.AIProfileRequest true "AI profile"
+// @Success 201 {object} models.AIProfile
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/profiles [post]
+func (h *AIProfileHandler) CreateProfile(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var req models.AIProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.profileService.CreateProfile(c.Request.Context(), tenantID, projectID, &req)
+	if err != nil {
+		respondAIProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, profile)
+}
+
+// GetProfile returns an AI profile
+// @Summary Get AI profile
+// @Tags ai-profiles
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param profile_id path string true "AI profile ID"
+// @Success 200 {object} models.AIProfile
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/profiles/{profile_id} [get]
+func (h *AIProfileHandler) GetProfile(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	profileID, err := uuid.Parse(c.Param("profile_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile ID format"})
+		return
+	}
+
+	profile, err := h.profileService.GetProfile(c.Request.Context(), tenantID, projectID, profileID)
+	if err != nil {
+		respondAIProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateProfile changes an AI profile
+// @Summary Update AI profile
+// @Description Change the fields the request sets. An empty string or list, or a max_tokens of 0, clears a field so it is inherited again.
+// @Tags ai-profiles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param profile_id path string true "AI profile ID"
+// @Param profile body models.AIProfileRequest true "Fields to change"
+// @Success 200 {object} models.AIProfile
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/profiles/{profile_id} [patch]
+func (h *AIProfileHandler) UpdateProfile(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	profileID, err := uuid.Parse(c.Param("profile_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile ID format"})
+		return
+	}
+
+	var req models.AIProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.profileService.UpdateProfile(c.Request.Context(), tenantID, projectID, profileID, &req)
+	if err != nil {
+		respondAIProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteProfile removes an AI profile
+// @Summary Delete AI profile
+// @Description Remove an AI profile; its project or widget falls back to the next level
+// @Tags ai-profiles
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param profile_id path string true "AI profile ID"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/profiles/{profile_id} [delete]
+func (h *AIProfileHandler) DeleteProfile(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	profileID, err := uuid.Parse(c.Param("profile_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile ID format"})
+		return
+	}
+
+	if err := h.profileService.DeleteProfile(c.Request.Context(), tenantID, projectID, profileID); err != nil {
+		respondAIProfileError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PreviewProfile tries a question against the project's knowledge with its AI profile
+// @Summary Preview AI profile
+// @Description Ask the assistant a test question as a visitor of the project, or of one of its widgets, would. Unsaved draft settings are applied over the saved profiles. Tokens used are billed like chat replies.
+// @Tags ai-profiles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param preview body models.AIProfilePreviewRequest true "Test question and draft settings"
+// @Success 200 {object} models.AIProfilePreviewResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/profiles/preview [post]
+func (h *AIProfileHandler) PreviewProfile(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var req models.AIProfilePreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.profileService.PreviewProfile(c.Request.Context(), tenantID, projectID, req.WidgetID, req.Draft)
+	if err != nil {
+		respondAIProfileError(c, err)
+		return
+	}
+
+	preview, err := h.aiService.PreviewProfile(c.Request.Context(), tenantID, projectID, profile, req.Message)
+	if err != nil {
+		if errors.Is(err, service.ErrAIDisabled) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
+func respondAIProfileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAIProfileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "AI profile not found"})
+	case errors.Is(err, service.ErrInvalidAIProfile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAIProfileExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to manage AI profile: " + err.Error()})
+	}
+}
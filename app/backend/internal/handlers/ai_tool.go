@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// AIToolHandler manages the HTTP tools the AI assistant of a project may call
+type AIToolHandler struct {
+	toolService *service.AIToolService
+}
+
+// NewAIToolHandler creates a new AI tool handler
+func NewAIToolHandler(toolService *service.AIToolService) *AIToolHandler {
+	return &AIToolHandler{toolService: toolService}
+}
+
+// ListTools lists the HTTP tools of a project
+// @Summary List AI tools
+// @Description List the HTTP tools the project's assistant may call, besides its built-in tools (create_ticket, list_my_tickets, check_business_hours, hand_off_to_human)
+// @Tags ai-tools
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Success 200 {object} object{tools=[]models.AITool}
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/tools [get]
+func (h *AIToolHandler) ListTools(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	tools, err := h.toolService.ListTools(c.Request.Context(), tenantID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list AI tools"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tools": tools})
+}
+
+// CreateTool adds an HTTP tool to a project
+// @Summary Create AI tool
+// @Description Let the assistant call an HTTP endpoint, such as an order-status API. The model fills in the arguments described by the parameters JSON schema; GET tools receive them as query parameters, POST tools as a JSON body. Headers are stored encrypted. Tools that require a verified visitor are only offered in chats with a verified identity and receive X-Visitor-Id and X-Visitor-Email.
+// @Tags ai-tools
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param tool body models.AIToolRequest true "AI tool"
+// @Success 201 {object} models.AITool
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/tools [post]
+func (h *AIToolHandler) CreateTool(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var req models.AIToolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tool, err := h.toolService.CreateTool(c.Request.Context(), tenantID, projectID, &req)
+	if err != nil {
+		respondAIToolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, tool)
+}
+
+// GetTool returns an HTTP tool
+// @Summary Get AI tool
+// @Tags ai-tools
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param tool_id path string true "AI tool ID"
+// @Success 200 {object} models.AITool
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/tools/{tool_id} [get]
+func (h *AIToolHandler) GetTool(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	toolID, err := uuid.Parse(c.Param("tool_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tool ID format"})
+		return
+	}
+
+	tool, err := h.toolService.GetTool(c.Request.Context(), tenantID, projectID, toolID)
+	if err != nil {
+		respondAIToolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tool)
+}
+
+// UpdateTool changes an HTTP tool
+// @Summary Update AI tool
+// @Description Change the fields the request sets. Headers replace all stored headers; an empty object removes them.
+// @Tags ai-tools
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param tool_id path string true "AI tool ID"
+// @Param tool body models.AIToolRequest true "Fields to change"
+// @Success 200 {object} models.AITool
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/tools/{tool_id} [patch]
+func (h *AIToolHandler) UpdateTool(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	toolID, err := uuid.Parse(c.Param("tool_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tool ID format"})
+		return
+	}
+
+	var req models.AIToolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tool, err := h.toolService.UpdateTool(c.Request.Context(), tenantID, projectID, toolID, &req)
+	if err != nil {
+		respondAIToolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tool)
+}
+
+// DeleteTool removes an HTTP tool
+// @Summary Delete AI tool
+// @Tags ai-tools
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param tool_id path string true "AI tool ID"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/tools/{tool_id} [delete]
+func (h *AIToolHandler) DeleteTool(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	toolID, err := uuid.Parse(c.Param("tool_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tool ID format"})
+		return
+	}
+
+	if err := h.toolService.DeleteTool(c.Request.Context(), tenantID, projectID, toolID); err != nil {
+		respondAIToolError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// TestTool calls an HTTP tool with sample arguments
+// @Summary Test AI tool
+// @Description Call the tool with the given arguments as the assistant would for an anonymous visitor, and return what the assistant would be given. Failures of the tool are reported in the invocation, not as an error status.
+// @Tags ai-tools
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param tool_id path string true "AI tool ID"
+// @Param test body models.AIToolTestRequest true "Arguments"
+// @Success 200 {object} models.AIToolTestResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/tools/{tool_id}/test [post]
+func (h *AIToolHandler) TestTool(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	toolID, err := uuid.Parse(c.Param("tool_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tool ID format"})
+		return
+	}
+
+	var req models.AIToolTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.toolService.TestTool(c.Request.Context(), tenantID, projectID, toolID, req.Arguments)
+	if err != nil {
+		respondAIToolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func respondAIToolError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAIToolNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "AI tool not found"})
+	case errors.Is(err, service.ErrInvalidAITool):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAIToolExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to manage AI tool: " + err.Error()})
+	}
+}
@@ -136,12 +136,18 @@ func (h *AIUsageHandler) DeductUsage(c *gin.Context) {
 }
 
 func (h *AIUsageHandler) ensureS2SAuthorized(c *gin.Context) error {
-	if h.s2sKey == "" {
+	return ensureS2SKey(c, h.s2sKey)
+}
+
+// ensureS2SKey validates the X-S2S-KEY header for service-to-service calls and
+// writes a 401 response when it does not match
+func ensureS2SKey(c *gin.Context, s2sKey string) error {
+	if s2sKey == "" {
 		return nil
 	}
 
 	key := c.GetHeader("X-S2S-KEY")
-	if key == "" || key != h.s2sKey {
+	if key == "" || key != s2sKey {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing s2s key"})
 		return fmt.Errorf("unauthorized")
 	}
@@ -3,12 +3,16 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/rbac"
 	"github.com/bareuptime/tms/internal/repo"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -25,19 +29,26 @@ func NewApiKeyHandler(apiKeyRepo repo.ApiKeyRepository) *ApiKeyHandler {
 	}
 }
 
+// defaultApiKeyScopes are granted when a key is created without explicit scopes
+var defaultApiKeyScopes = []string{string(rbac.PermTicketRead), string(rbac.PermTicketWrite)}
+
 // ApiKeyRequest represents the request payload for creating an API key
 type ApiKeyRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name         string   `json:"name" binding:"required"`
+	Scopes       []string `json:"scopes,omitempty" example:"ticket:read"`
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty" example:"203.0.113.0/24"`
 }
 
 // ApiKeyResponse represents an API key in responses
 type ApiKeyResponse struct {
-	ID         string     `json:"id"`
-	Name       string     `json:"name"`
-	KeyPreview string     `json:"key_preview"`
-	CreatedAt  time.Time  `json:"created_at"`
-	LastUsed   *time.Time `json:"last_used,omitempty"`
-	IsActive   bool       `json:"is_active"`
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	KeyPreview   string     `json:"key_preview"`
+	Scopes       []string   `json:"scopes"`
+	AllowedCIDRs []string   `json:"allowed_cidrs"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsed     *time.Time `json:"last_used,omitempty"`
+	IsActive     bool       `json:"is_active"`
 }
 
 // ApiKeyWithValueResponse includes the actual key value (only shown once during creation)
@@ -76,14 +87,7 @@ func (h *ApiKeyHandler) ListApiKeys(c *gin.Context) {
 	// Initialize as empty slice to ensure JSON returns [] instead of null
 	response := make([]ApiKeyResponse, 0)
 	for _, apiKey := range apiKeys {
-		response = append(response, ApiKeyResponse{
-			ID:         apiKey.ID.String(),
-			Name:       apiKey.Name,
-			KeyPreview: apiKey.KeyPrefix,
-			CreatedAt:  apiKey.CreatedAt,
-			LastUsed:   apiKey.LastUsedAt,
-			IsActive:   apiKey.IsActive,
-		})
+		response = append(response, newApiKeyResponse(apiKey))
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -91,7 +95,7 @@ func (h *ApiKeyHandler) ListApiKeys(c *gin.Context) {
 
 // CreateApiKey handles POST /tenants/:tenant_id/api-keys
 // @Summary Create API key
-// @Description Create a new API key for the project
+// @Description Create a new API key for the project. Scopes default to ticket:read and ticket:write; allowed_cidrs optionally restricts the client networks.
 // @Tags API Keys
 // @Accept json
 // @Produce json
@@ -116,6 +120,20 @@ func (h *ApiKeyHandler) CreateApiKey(c *gin.Context) {
 		return
 	}
 
+	if len(req.Scopes) == 0 {
+		req.Scopes = defaultApiKeyScopes
+	}
+	scopes, err := normalizeApiKeyScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	allowedCIDRs, err := normalizeAllowedCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Generate API key
 	apiKey, err := generateApiKey()
 	if err != nil {
@@ -126,17 +144,18 @@ func (h *ApiKeyHandler) CreateApiKey(c *gin.Context) {
 	// Create API key record
 	now := time.Now()
 	keyRecord := &db.ApiKey{
-		ID:        uuid.New(),
-		TenantID:  tenantID,
-		ProjectID: projectID, // Tenant-level API keys
-		Name:      req.Name,
-		KeyHash:   repo.HashApiKey(apiKey),
-		KeyPrefix: apiKey[:12] + "...", // Store preview
-		Scopes:    pq.StringArray{},    // Default empty scopes as pq.StringArray
-		IsActive:  true,
-		AgentID:   agentID,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:           uuid.New(),
+		TenantID:     tenantID,
+		ProjectID:    projectID, // Tenant-level API keys
+		Name:         req.Name,
+		KeyHash:      repo.HashApiKey(apiKey),
+		KeyPrefix:    apiKey[:12] + "...", // Store preview
+		Scopes:       scopes,
+		AllowedCIDRs: allowedCIDRs,
+		IsActive:     true,
+		AgentID:      agentID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
 	}
 
 	// Store in database
@@ -148,15 +167,8 @@ func (h *ApiKeyHandler) CreateApiKey(c *gin.Context) {
 
 	// Return the API key with the full value (only time it's shown)
 	response := ApiKeyWithValueResponse{
-		ApiKeyResponse: ApiKeyResponse{
-			ID:         keyRecord.ID.String(),
-			Name:       keyRecord.Name,
-			KeyPreview: keyRecord.KeyPrefix,
-			CreatedAt:  keyRecord.CreatedAt,
-			LastUsed:   keyRecord.LastUsedAt,
-			IsActive:   keyRecord.IsActive,
-		},
-		Key: apiKey,
+		ApiKeyResponse: newApiKeyResponse(keyRecord),
+		Key:            apiKey,
 	}
 
 	c.JSON(http.StatusCreated, response)
@@ -177,14 +189,7 @@ func (h *ApiKeyHandler) GetApiKey(c *gin.Context) {
 		return
 	}
 
-	response := ApiKeyResponse{
-		ID:         apiKey.ID.String(),
-		Name:       apiKey.Name,
-		KeyPreview: apiKey.KeyPrefix,
-		CreatedAt:  apiKey.CreatedAt,
-		LastUsed:   apiKey.LastUsedAt,
-		IsActive:   apiKey.IsActive,
-	}
+	response := newApiKeyResponse(apiKey)
 
 	c.JSON(http.StatusOK, response)
 }
@@ -200,8 +205,10 @@ func (h *ApiKeyHandler) UpdateApiKey(c *gin.Context) {
 	}
 
 	var req struct {
-		Name     string `json:"name"`
-		IsActive *bool  `json:"is_active"`
+		Name         string    `json:"name"`
+		IsActive     *bool     `json:"is_active"`
+		Scopes       *[]string `json:"scopes"`
+		AllowedCIDRs *[]string `json:"allowed_cidrs"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -223,6 +230,22 @@ func (h *ApiKeyHandler) UpdateApiKey(c *gin.Context) {
 	if req.IsActive != nil {
 		apiKey.IsActive = *req.IsActive
 	}
+	if req.Scopes != nil {
+		scopes, err := normalizeApiKeyScopes(*req.Scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		apiKey.Scopes = scopes
+	}
+	if req.AllowedCIDRs != nil {
+		allowedCIDRs, err := normalizeAllowedCIDRs(*req.AllowedCIDRs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		apiKey.AllowedCIDRs = allowedCIDRs
+	}
 
 	// Save changes
 	err = h.apiKeyRepo.Update(c.Request.Context(), apiKey)
@@ -231,14 +254,7 @@ func (h *ApiKeyHandler) UpdateApiKey(c *gin.Context) {
 		return
 	}
 
-	response := ApiKeyResponse{
-		ID:         apiKey.ID.String(),
-		Name:       apiKey.Name,
-		KeyPreview: apiKey.KeyPrefix,
-		CreatedAt:  apiKey.CreatedAt,
-		LastUsed:   apiKey.LastUsedAt,
-		IsActive:   apiKey.IsActive,
-	}
+	response := newApiKeyResponse(apiKey)
 
 	c.JSON(http.StatusOK, response)
 }
@@ -262,6 +278,75 @@ func (h *ApiKeyHandler) DeleteApiKey(c *gin.Context) {
 	c.JSON(http.StatusNoContent, nil)
 }
 
+// newApiKeyResponse converts an API key record to its response format
+func newApiKeyResponse(apiKey *db.ApiKey) ApiKeyResponse {
+	scopes := []string(apiKey.Scopes)
+	if scopes == nil {
+		scopes = []string{}
+	}
+	allowedCIDRs := []string(apiKey.AllowedCIDRs)
+	if allowedCIDRs == nil {
+		allowedCIDRs = []string{}
+	}
+
+	return ApiKeyResponse{
+		ID:           apiKey.ID.String(),
+		Name:         apiKey.Name,
+		KeyPreview:   apiKey.KeyPrefix,
+		Scopes:       scopes,
+		AllowedCIDRs: allowedCIDRs,
+		CreatedAt:    apiKey.CreatedAt,
+		LastUsed:     apiKey.LastUsedAt,
+		IsActive:     apiKey.IsActive,
+	}
+}
+
+// normalizeApiKeyScopes validates scopes against the permissions API keys can hold and removes duplicates
+func normalizeApiKeyScopes(requested []string) (pq.StringArray, error) {
+	scopes := pq.StringArray{}
+	seen := make(map[string]bool)
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		if !rbac.IsApiKeyScope(rbac.Permission(scope)) {
+			return nil, fmt.Errorf("invalid scope: %s", scope)
+		}
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// normalizeAllowedCIDRs validates the IP allowlist. Bare addresses are turned into single-host networks.
+func normalizeAllowedCIDRs(requested []string) (pq.StringArray, error) {
+	cidrs := pq.StringArray{}
+	for _, value := range requested {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", value)
+			}
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", value)
+		}
+		cidrs = append(cidrs, network.String())
+	}
+	return cidrs, nil
+}
+
 // generateApiKey generates a cryptographically secure API key
 func generateApiKey() (string, error) {
 	bytes := make([]byte, 32) // 256 bits
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -48,6 +49,8 @@ type LoginResponse struct {
 	TokenType    string `json:"token_type" example:"Bearer"`
 	ExpiresIn    int    `json:"expires_in" example:"3600"`
 	User         User   `json:"user"`
+	// RecoveryCodes are only present when MFA enrollment was completed during login
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
 // User represents the user data returned in login response
@@ -62,7 +65,7 @@ type User struct {
 
 // Login handles user login
 // @Summary User login
-// @Description Authenticate user with email and password
+// @Description Authenticate user with email and password. When the agent has two-factor authentication enabled, or the tenant policy requires it, the response is an MFA challenge (mfa_required, mfa_token) to complete with /v1/auth/mfa/verify instead of tokens.
 // @Tags Auth
 // @Accept json
 // @Produce json
@@ -95,10 +98,22 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	response, err := h.authService.Login(c.Request.Context(), loginReq)
 	if err != nil {
 		logger.ErrorfCtx(c.Request.Context(), err, "Login authentication failed for email %s: %v", req.Email, err)
+		if errors.Is(err, service.ErrPasswordLoginDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
+	// A second factor is needed before tokens are issued
+	if response.MFAChallenge != nil {
+		logger.InfofCtx(c.Request.Context(), "MFA challenge issued - user_id: %s, enrollment_required: %v",
+			response.Agent.ID.String(), response.MFAChallenge.EnrollmentRequired)
+		c.JSON(http.StatusOK, response.MFAChallenge)
+		return
+	}
+
 	// Determine primary role (use tenant_admin if available, otherwise first role found)
 	primaryRole := models.RoleAgent.String() // default
 	for _, roles := range response.RoleBindings {
@@ -567,6 +582,14 @@ func (h *AuthHandler) GoogleOAuthCallback(c *gin.Context) {
 		return
 	}
 
+	// A second factor is needed before tokens are issued
+	if response.MFAChallenge != nil {
+		logger.InfofCtx(c.Request.Context(), "MFA challenge issued - user_id: %s, enrollment_required: %v",
+			response.Agent.ID.String(), response.MFAChallenge.EnrollmentRequired)
+		c.JSON(http.StatusOK, response.MFAChallenge)
+		return
+	}
+
 	// Determine primary role
 	primaryRole := models.RoleAgent.String()
 	for _, roles := range response.RoleBindings {
@@ -643,6 +666,14 @@ func (h *AuthHandler) GoogleIDTokenCallback(c *gin.Context) {
 		return
 	}
 
+	// A second factor is needed before tokens are issued
+	if response.MFAChallenge != nil {
+		logger.InfofCtx(c.Request.Context(), "MFA challenge issued - user_id: %s, enrollment_required: %v",
+			response.Agent.ID.String(), response.MFAChallenge.EnrollmentRequired)
+		c.JSON(http.StatusOK, response.MFAChallenge)
+		return
+	}
+
 	// Determine primary role
 	primaryRole := models.RoleAgent.String()
 	for _, roles := range response.RoleBindings {
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// BillingHandler exposes billing plan, quota and usage statement endpoints
+type BillingHandler struct {
+	planService *service.PlanService
+	s2sKey      string
+}
+
+// NewBillingHandler creates a new billing handler
+func NewBillingHandler(planService *service.PlanService, s2sKey string) *BillingHandler {
+	return &BillingHandler{
+		planService: planService,
+		s2sKey:      s2sKey,
+	}
+}
+
+type assignPlanRequest struct {
+	PlanCode string `json:"plan_code" binding:"required"`
+}
+
+// ListPlans handles GET /billing/plans
+// @Summary List billing plans
+// @Description Get all active billing plans with their limits and monthly AI credits
+// @Tags Billing
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "List of plans"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/billing/plans [get]
+func (h *BillingHandler) ListPlans(c *gin.Context) {
+	plans, err := h.planService.ListPlans(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to list billing plans: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list plans"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// GetPlan handles GET /billing/plan
+// @Summary Get tenant plan
+// @Description Get the tenant's billing plan together with its current usage and credit balance
+// @Tags Billing
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} service.PlanOverview "Plan overview"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/billing/plan [get]
+func (h *BillingHandler) GetPlan(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+
+	overview, err := h.planService.GetPlanOverview(c.Request.Context(), tenantID)
+	if err != nil {
+		log.Printf("Failed to get plan overview: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get plan"})
+		return
+	}
+
+	c.JSON(http.StatusOK, overview)
+}
+
+// AssignPlan handles PUT /billing/plan
+// @Summary Assign tenant plan
+// @Description Subscribe the tenant to a billing plan (called by the billing backend)
+// @Tags Billing
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param request body assignPlanRequest true "Plan to assign"
+// @Security BearerAuth
+// @Security S2SAuth
+// @Success 200 {object} db.TenantPlan "Assigned plan"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Invalid or missing s2s key"
+// @Failure 404 {object} map[string]interface{} "Plan not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/billing/plan [put]
+func (h *BillingHandler) AssignPlan(c *gin.Context) {
+	if err := ensureS2SKey(c, h.s2sKey); err != nil {
+		return
+	}
+
+	tenantID := middleware.GetTenantID(c)
+
+	var req assignPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tenantPlan, err := h.planService.AssignPlan(c.Request.Context(), tenantID, req.PlanCode)
+	if err != nil {
+		if errors.Is(err, service.ErrPlanNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Failed to assign plan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign plan"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tenantPlan)
+}
+
+// GetStatement handles GET /billing/statements/:period
+// @Summary Get monthly usage statement
+// @Description Get the credit usage statement for a month (YYYY-MM). Use format=csv to download it as CSV.
+// @Tags Billing
+// @Produce json
+// @Produce text/csv
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param period path string true "Statement month" example(2025-01)
+// @Param format query string false "Response format (json or csv)"
+// @Security BearerAuth
+// @Success 200 {object} service.UsageStatement "Usage statement"
+// @Failure 400 {object} map[string]interface{} "Invalid period"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/billing/statements/{period} [get]
+func (h *BillingHandler) GetStatement(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	period := c.Param("period")
+
+	if _, err := time.Parse("2006-01", period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be formatted as YYYY-MM"})
+		return
+	}
+
+	statement, err := h.planService.GenerateStatement(c.Request.Context(), tenantID, period)
+	if err != nil {
+		log.Printf("Failed to generate usage statement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate statement"})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage-statement-%s.csv", period))
+		c.Status(http.StatusOK)
+		if err := statement.WriteCSV(c.Writer); err != nil {
+			log.Printf("Failed to write usage statement CSV: %v", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, statement)
+}
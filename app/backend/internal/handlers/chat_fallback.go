@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/models"
+	ws "github.com/bareuptime/tms/internal/websocket"
+)
+
+// Visitors whose network blocks WebSockets receive over Server-Sent Events or long polling and send
+// with plain POSTs. Their connections are registered with the ConnectionManager like WebSockets, so
+// agents cannot tell the difference.
+const (
+	// fallbackQueueSize is how many messages wait for a client before it is dropped as too far behind
+	fallbackQueueSize = 256
+
+	// fallbackKeepAlive is how often an idle event stream is kept open through proxies
+	fallbackKeepAlive = 25 * time.Second
+
+	// longPollWait is how long a poll waits for messages before returning empty-handed
+	longPollWait = 25 * time.Second
+
+	// longPollIdleTimeout closes a poll connection once its client stops polling
+	longPollIdleTimeout = time.Minute
+)
+
+// pollConnection is a long-poll connection held on this server between polls
+type pollConnection struct {
+	id        string
+	sessionID uuid.UUID
+	writer    *ws.QueueWriter
+	expiry    *time.Timer
+}
+
+type pollRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*pollConnection
+}
+
+func fallbackConnectionKey(connID string) string {
+	return fmt.Sprintf("livechat:fallback:%s", connID)
+}
+
+// openFallbackConnection registers a queued connection for the visitor's session and greets it with the
+// connection ID to send messages with. A client that passes last_seq, or the Last-Event-ID an EventSource
+// sends when it reconnects, catches up on the messages it missed.
+func (h *ChatWebSocketHandler) openFallbackConnection(c *gin.Context, session *models.ChatSession) (string, *ws.QueueWriter, error) {
+	writer := ws.NewQueueWriter(fallbackQueueSize)
+	connectionID, err := h.connectionManager.AddConnection(
+		ws.ConnectionTypeVisitor,
+		session.ID,
+		[]uuid.UUID{session.ProjectID},
+		nil, // No user ID for visitors
+		writer,
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Messages may be posted to another server, which checks the connection belongs to the session here
+	h.connectionManager.GetRedisClient().Set(c.Request.Context(), fallbackConnectionKey(connectionID), session.ID.String(), 2*longPollIdleTimeout)
+
+	welcomeData, _ := json.Marshal(map[string]interface{}{
+		"type":          "connected",
+		"message":       "Connected to chat session",
+		"connection_id": connectionID,
+	})
+	h.connectionManager.SendToConnection(connectionID, &ws.Message{
+		Type:         "session_update",
+		SessionID:    session.ID,
+		Data:         welcomeData,
+		FromType:     ws.ConnectionTypeVisitor,
+		DeliveryType: ws.Self,
+	})
+
+	lastSeq := c.Query("last_seq")
+	if lastSeq == "" {
+		lastSeq = c.GetHeader("Last-Event-ID")
+	}
+	if lastSeq != "" {
+		if afterSeq, err := strconv.ParseInt(lastSeq, 10, 64); err == nil && afterSeq >= 0 {
+			h.resumeVisitor(c.Request.Context(), session, &afterSeq, connectionID)
+		}
+	}
+
+	return connectionID, writer, nil
+}
+
+func (h *ChatWebSocketHandler) closeFallbackConnection(connectionID string) {
+	h.connectionManager.RemoveConnection(connectionID)
+	h.connectionManager.GetRedisClient().Del(context.Background(), fallbackConnectionKey(connectionID))
+}
+
+// HandleSSEPublic streams chat events to a visitor over Server-Sent Events
+// @Summary Public chat event stream
+// @Description Server-Sent Events alternative to the chat WebSocket for networks that block WebSockets. Each event carries the same JSON as a WebSocket frame; chat messages use their sequence number as event ID, so a reconnecting EventSource resumes where it left off. The first event holds the connection_id to send messages with.
+// @Tags chat-websocket
+// @Produce text/event-stream
+// @Param widget_id path string true "Widget ID"
+// @Param session_token path string true "Session token for authentication"
+// @Param last_seq query int false "Sequence number of the last message the client received"
+// @Success 200 {string} string "Event stream"
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/public/chat/sse/widgets/{widget_id}/chat/{session_token} [get]
+func (h *ChatWebSocketHandler) HandleSSEPublic(c *gin.Context) {
+	session, ok := h.resolveVisitorSession(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	connectionID, writer, err := h.openFallbackConnection(c, session)
+	if err != nil {
+		log.Printf("Failed to register event stream: %v", err)
+		return
+	}
+	defer h.closeFallbackConnection(connectionID)
+
+	keepAlive := time.NewTicker(fallbackKeepAlive)
+	defer keepAlive.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case message := <-writer.Messages():
+			if id := chatEventID(message); id != "" {
+				fmt.Fprintf(c.Writer, "id: %s\n", id)
+			}
+			fmt.Fprintf(c.Writer, "data: %s\n\n", message)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			h.connectionManager.GetRedisClient().Expire(ctx, fallbackConnectionKey(connectionID), 2*longPollIdleTimeout)
+		case <-writer.Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// chatEventID returns the sequence number of a chat message event, empty for other events
+func chatEventID(message json.RawMessage) string {
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Seq int64 `json:"seq"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message, &event); err != nil || event.Type != string(models.WSMsgTypeChatMessage) || event.Data.Seq == 0 {
+		return ""
+	}
+	return strconv.FormatInt(event.Data.Seq, 10)
+}
+
+// HandleLongPollPublic returns pending chat events to a visitor polling for them
+// @Summary Public chat long poll
+// @Description Long-polling alternative to the chat WebSocket. The first poll opens a connection and returns its connection_id; later polls pass it back and wait up to 25 seconds for events. A connection that is not polled for a minute is closed.
+// @Tags chat-websocket
+// @Produce json
+// @Param widget_id path string true "Widget ID"
+// @Param session_token path string true "Session token for authentication"
+// @Param connection_id query string false "Connection returned by an earlier poll"
+// @Param last_seq query int false "Sequence number of the last message the client received, when opening a connection"
+// @Success 200 {object} object{connection_id=string,messages=[]object}
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/public/chat/poll/widgets/{widget_id}/chat/{session_token} [get]
+func (h *ChatWebSocketHandler) HandleLongPollPublic(c *gin.Context) {
+	session, ok := h.resolveVisitorSession(c)
+	if !ok {
+		return
+	}
+
+	poll := h.polls.touch(c.Query("connection_id"), session.ID)
+	if poll == nil {
+		// First poll, or the connection expired or lives on another server
+		connectionID, writer, err := h.openFallbackConnection(c, session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open connection"})
+			return
+		}
+		poll = &pollConnection{id: connectionID, sessionID: session.ID, writer: writer}
+		poll.expiry = time.AfterFunc(longPollIdleTimeout, func() {
+			h.polls.remove(connectionID)
+			h.closeFallbackConnection(connectionID)
+		})
+		h.polls.add(poll)
+	}
+	h.connectionManager.GetRedisClient().Expire(c.Request.Context(), fallbackConnectionKey(poll.id), 2*longPollIdleTimeout)
+
+	messages := []json.RawMessage{}
+	wait := time.NewTimer(longPollWait)
+	defer wait.Stop()
+
+	select {
+	case message := <-poll.writer.Messages():
+		messages = append(messages, message)
+	case <-wait.C:
+	case <-poll.writer.Done():
+	case <-c.Request.Context().Done():
+		return
+	}
+	// Hand over everything else already queued
+	for drained := false; !drained; {
+		select {
+		case message := <-poll.writer.Messages():
+			messages = append(messages, message)
+		default:
+			drained = true
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"connection_id": poll.id, "messages": messages})
+}
+
+// HandleClientMessagePublic accepts a message from a visitor on an event stream or long-poll connection
+// @Summary Send public chat event
+// @Description Send what a WebSocket client would send as a frame (chat_message, typing_start, typing_stop, read_receipt, resume, ack) from an event stream or long-poll connection. Replies for the visitor arrive on that connection.
+// @Tags chat-websocket
+// @Accept json
+// @Produce json
+// @Param widget_id path string true "Widget ID"
+// @Param session_token path string true "Session token for authentication"
+// @Param connection_id query string true "Event stream or long-poll connection"
+// @Param message body models.WSMessage true "Event"
+// @Success 202 {object} object{status=string}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /api/public/chat/send/widgets/{widget_id}/chat/{session_token} [post]
+func (h *ChatWebSocketHandler) HandleClientMessagePublic(c *gin.Context) {
+	session, ok := h.resolveVisitorSession(c)
+	if !ok {
+		return
+	}
+
+	connectionID := c.Query("connection_id")
+	if connectionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "connection_id is required"})
+		return
+	}
+	owner, err := h.connectionManager.GetRedisClient().Get(c.Request.Context(), fallbackConnectionKey(connectionID)).Result()
+	if err != nil || owner != session.ID.String() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+		return
+	}
+
+	var msg models.WSMessage
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// AI replies keep streaming after this request returns
+	ctx := context.WithoutCancel(c.Request.Context())
+	h.handleVisitorMessage(ctx, session, msg, connectionID)
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "accepted"})
+}
+
+// touch returns a poll connection of the session and pushes back its expiry, nil when there is none here
+func (r *pollRegistry) touch(connectionID string, sessionID uuid.UUID) *pollConnection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	poll, ok := r.conns[connectionID]
+	if !ok || poll.sessionID != sessionID {
+		return nil
+	}
+	select {
+	case <-poll.writer.Done():
+		// Dropped for falling behind; the client starts over with a new connection
+		return nil
+	default:
+	}
+	if !poll.expiry.Stop() {
+		// Expiring right now
+		return nil
+	}
+	poll.expiry.Reset(longPollIdleTimeout)
+	return poll
+}
+
+func (r *pollRegistry) add(poll *pollConnection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[poll.id] = poll
+}
+
+func (r *pollRegistry) remove(connectionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, connectionID)
+}
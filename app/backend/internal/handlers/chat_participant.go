@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// ListParticipants lists the agents currently in a chat session
+// @Summary List chat participants
+// @Description List the agents currently in a chat session: the assigned agent, invited agents and monitoring supervisors
+// @Tags chat-sessions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat Session ID"
+// @Success 200 {object} object{participants=[]models.ChatSessionParticipant}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-sessions/{session_id}/participants [get]
+func (h *ChatSessionHandler) ListParticipants(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	participants, err := h.chatParticipantService.ListParticipants(c.Request.Context(), tenantID, projectID, sessionID)
+	if err != nil {
+		respondChatParticipantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"participants": participants})
+}
+
+// TransferSession transfers a live chat to another agent or back to the project queue
+// @Summary Transfer chat session
+// @Description Hand a live chat to another agent, or back to the project queue when no agent is given. The optional handover note is stored as a private message.
+// @Tags chat-sessions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat Session ID"
+// @Param transfer body models.TransferChatSessionRequest true "Transfer target and handover note"
+// @Success 200 {object} models.ChatSession
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-sessions/{session_id}/transfer [post]
+func (h *ChatSessionHandler) TransferSession(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	var req models.TransferChatSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := h.chatParticipantService.TransferSession(c.Request.Context(), tenantID, projectID, sessionID, agentID, req)
+	if err != nil {
+		respondChatParticipantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// InviteAgent invites a second agent into a live chat
+// @Summary Invite agent to chat
+// @Description Bring another agent into a live chat alongside the assigned agent
+// @Tags chat-sessions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat Session ID"
+// @Param invite body models.InviteChatAgentRequest true "Agent to invite"
+// @Success 201 {object} models.ChatSessionParticipant
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-sessions/{session_id}/participants [post]
+func (h *ChatSessionHandler) InviteAgent(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	var req models.InviteChatAgentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	participant, err := h.chatParticipantService.InviteAgent(c.Request.Context(), tenantID, projectID, sessionID, req.AgentID)
+	if err != nil {
+		respondChatParticipantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, participant)
+}
+
+// MonitorSession lets a supervisor monitor a live chat
+// @Summary Monitor chat session
+// @Description Join a live chat as an observer. Observers receive every message and can whisper to the agents; the visitor is not told.
+// @Tags chat-sessions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat Session ID"
+// @Success 201 {object} models.ChatSessionParticipant
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-sessions/{session_id}/monitor [post]
+func (h *ChatSessionHandler) MonitorSession(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	participant, err := h.chatParticipantService.MonitorSession(c.Request.Context(), tenantID, projectID, sessionID, agentID)
+	if err != nil {
+		respondChatParticipantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, participant)
+}
+
+// Whisper sends a private message to the agents of a live chat
+// @Summary Whisper in chat session
+// @Description Send a private message that only the agents in the chat see
+// @Tags chat-sessions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat Session ID"
+// @Param whisper body models.WhisperChatMessageRequest true "Private message"
+// @Success 201 {object} models.ChatMessage
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-sessions/{session_id}/whisper [post]
+func (h *ChatSessionHandler) Whisper(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	var req models.WhisperChatMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.chatParticipantService.Whisper(c.Request.Context(), tenantID, projectID, sessionID, agentID, req.Content)
+	if err != nil {
+		respondChatParticipantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, message)
+}
+
+// LeaveSession takes the calling agent out of a chat they were invited to or were monitoring
+// @Summary Leave chat session
+// @Description Leave a chat as an invited agent or observer. The assigned agent has to transfer the chat instead.
+// @Tags chat-sessions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat Session ID"
+// @Success 200 {object} object{message=string}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-sessions/{session_id}/leave [post]
+func (h *ChatSessionHandler) LeaveSession(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	if err := h.chatParticipantService.LeaveSession(c.Request.Context(), tenantID, projectID, sessionID, agentID); err != nil {
+		respondChatParticipantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Left the chat session"})
+}
+
+func respondChatParticipantError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrChatSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found"})
+	case errors.Is(err, service.ErrChatNotParticipant),
+		errors.Is(err, service.ErrChatTransferForbidden),
+		errors.Is(err, service.ErrChatMonitorForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrChatSessionNotActive),
+		errors.Is(err, service.ErrChatAlreadyAssigned),
+		errors.Is(err, service.ErrChatAlreadyParticipant),
+		errors.Is(err, service.ErrChatTransferConflict),
+		errors.Is(err, service.ErrChatPrimaryCannotLeave):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrChatAgentUnavailable):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat participants: " + err.Error()})
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/auth"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// chatRoles grants each agent the permissions of their built-in project role, both to the route
+// guards and to the participant service
+type chatRoles map[uuid.UUID]models.RoleType
+
+func (r chatRoles) CheckPermission(ctx context.Context, agentID, tenantID, projectID uuid.UUID, permission rbac.Permission) (bool, error) {
+	return rbac.RoleHasPermission(r[agentID], permission), nil
+}
+
+func (r chatRoles) TenantRoleHasPermission(ctx context.Context, tenantID uuid.UUID, role models.RoleType, permission rbac.Permission) (bool, error) {
+	return rbac.RoleHasPermission(role, permission), nil
+}
+
+type chatParticipants map[uuid.UUID]*models.ChatSessionParticipant
+
+func (p chatParticipants) AddParticipant(ctx context.Context, participant *models.ChatSessionParticipant) error {
+	p[participant.AgentID] = participant
+	return nil
+}
+
+func (p chatParticipants) RemoveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (bool, error) {
+	_, ok := p[agentID]
+	delete(p, agentID)
+	return ok, nil
+}
+
+func (p chatParticipants) GetActiveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (*models.ChatSessionParticipant, error) {
+	return p[agentID], nil
+}
+
+func (p chatParticipants) ListActiveParticipants(ctx context.Context, tenantID, sessionID uuid.UUID) ([]*models.ChatSessionParticipant, error) {
+	var participants []*models.ChatSessionParticipant
+	for _, participant := range p {
+		participants = append(participants, participant)
+	}
+	return participants, nil
+}
+
+type chatSessionStub struct {
+	session *models.ChatSession
+}
+
+func (s *chatSessionStub) GetChatSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	if s.session.ID != sessionID {
+		return nil, nil
+	}
+	return s.session, nil
+}
+
+func (s *chatSessionStub) ReassignSession(ctx context.Context, session *models.ChatSession, agentID *uuid.UUID) (bool, error) {
+	session.AssignedAgentID = agentID
+	return true, nil
+}
+
+func (s *chatSessionStub) SendSystemNotice(ctx context.Context, session *models.ChatSession, content string) (*models.ChatMessage, error) {
+	return &models.ChatMessage{ID: uuid.New(), Content: content}, nil
+}
+
+func (s *chatSessionStub) SendPrivateMessage(ctx context.Context, session *models.ChatSession, agentID uuid.UUID, agentName, content string, metadata models.JSONMap) (*models.ChatMessage, error) {
+	return &models.ChatMessage{ID: uuid.New(), Content: content, IsPrivate: true}, nil
+}
+
+func (s *chatSessionStub) PublishParticipantEvent(ctx context.Context, session *models.ChatSession, eventType string, data json.RawMessage, agentsOnly bool) {
+}
+
+type chatAgents map[uuid.UUID]*db.Agent
+
+func (a chatAgents) GetAgent(ctx context.Context, tenantID, agentID uuid.UUID) (*db.Agent, error) {
+	return a[agentID], nil
+}
+
+func TestChatParticipantRoutesLetSupervisorsMonitorAndTransfer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tenantID, projectID := uuid.New(), uuid.New()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	roles := chatRoles{alice: models.RoleAgent, bob: models.RoleAgent, carol: models.RoleSupervisor}
+	session := &models.ChatSession{ID: uuid.New(), TenantID: tenantID, ProjectID: projectID, Status: "active", AssignedAgentID: &alice}
+	agents := chatAgents{alice: {ID: alice, Name: "Alice"}, bob: {ID: bob, Name: "Bob"}, carol: {ID: carol, Name: "Carol"}}
+	participants := service.NewChatParticipantService(chatParticipants{}, &chatSessionStub{session: session}, agents, roles)
+	handler := &ChatSessionHandler{chatParticipantService: participants}
+
+	// serve sends a request as the given agent, whose project role binding comes from the JWT claims
+	serve := func(agentID uuid.UUID, action, body string) int {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("claims", &auth.Claims{TenantID: tenantID.String(), RoleBindings: map[string][]string{projectID.String(): {string(roles[agentID])}}})
+			c.Set("tenant_id", tenantID.String())
+			c.Set("agent_id", agentID.String())
+		})
+		sessions := router.Group("/projects/:project_id/chat/sessions")
+		sessions.Use(middleware.RequirePermission(roles, rbac.PermChatRead, rbac.PermChatWrite))
+		sessions.POST("/:session_id/transfer", handler.TransferSession)
+		sessions.POST("/:session_id/monitor", middleware.RequirePermission(roles, rbac.PermChatSupervise, rbac.PermChatSupervise), handler.MonitorSession)
+
+		path := "/projects/" + projectID.String() + "/chat/sessions/" + session.ID.String() + "/" + action
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	// Agents may neither monitor a chat nor move one that is not theirs
+	require.Equal(t, http.StatusForbidden, serve(bob, "monitor", ""))
+	require.Equal(t, http.StatusForbidden, serve(bob, "transfer", `{"agent_id":"`+bob.String()+`"}`))
+
+	// A supervisor may do both
+	require.Equal(t, http.StatusCreated, serve(carol, "monitor", ""))
+	require.Equal(t, http.StatusOK, serve(carol, "transfer", `{"agent_id":"`+bob.String()+`"}`))
+	require.Equal(t, bob, *session.AssignedAgentID)
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -17,16 +19,20 @@ import (
 )
 
 type ChatSessionHandler struct {
-	chatSessionService *service.ChatSessionService
-	chatWidgetService  *service.ChatWidgetService
-	redisClient        *redis.Service
+	chatSessionService     *service.ChatSessionService
+	chatWidgetService      *service.ChatWidgetService
+	chatTicketService      *service.ChatTicketService
+	chatParticipantService *service.ChatParticipantService
+	redisClient            *redis.Service
 }
 
-func NewChatSessionHandler(chatSessionService *service.ChatSessionService, chatWidgetService *service.ChatWidgetService, redisClient *redis.Service) *ChatSessionHandler {
+func NewChatSessionHandler(chatSessionService *service.ChatSessionService, chatWidgetService *service.ChatWidgetService, chatTicketService *service.ChatTicketService, chatParticipantService *service.ChatParticipantService, redisClient *redis.Service) *ChatSessionHandler {
 	return &ChatSessionHandler{
-		chatSessionService: chatSessionService,
-		chatWidgetService:  chatWidgetService,
-		redisClient:        redisClient,
+		chatSessionService:     chatSessionService,
+		chatWidgetService:      chatWidgetService,
+		chatTicketService:      chatTicketService,
+		chatParticipantService: chatParticipantService,
+		redisClient:            redisClient,
 	}
 }
 
@@ -183,7 +189,9 @@ func (h *ChatSessionHandler) AssignAgent(c *gin.Context) {
 // @Param tenant_id header string true "Tenant ID"
 // @Param project_id header string true "Project ID"
 // @Param session_id path string true "Chat Session ID"
-// @Success 200 {object} object{messages=[]models.ChatMessage}
+// @Param include_private query bool false "Include private messages"
+// @Param after_seq query int false "Only messages after this sequence number, for catching up after a reconnect; returns at most 200 with has_more"
+// @Success 200 {object} object{messages=[]models.ChatMessage,last_seq=int,has_more=bool}
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
@@ -202,6 +210,32 @@ func (h *ChatSessionHandler) GetChatMessages(c *gin.Context) {
 
 	includePrivate := c.Query("include_private") == "true"
 
+	if afterSeqStr := c.Query("after_seq"); afterSeqStr != "" {
+		afterSeq, err := strconv.ParseInt(afterSeqStr, 10, 64)
+		if err != nil || afterSeq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after_seq"})
+			return
+		}
+
+		session, err := h.chatSessionService.GetChatSession(c.Request.Context(), tenantID, projectID, sessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+			return
+		}
+		if session == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found"})
+			return
+		}
+
+		resume, err := h.chatSessionService.GetMessagesAfter(c.Request.Context(), sessionID, afterSeq, includePrivate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+			return
+		}
+		c.JSON(http.StatusOK, resume)
+		return
+	}
+
 	messages, err := h.chatSessionService.GetChatMessages(c.Request.Context(), tenantID, projectID, sessionID, includePrivate)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
@@ -359,3 +393,129 @@ func (h *ChatSessionHandler) EscalateSession(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// ConvertToTicket converts a chat session into a ticket
+// @Summary Convert chat session to ticket
+// @Description Create a ticket from the chat transcript, assign it to the calling agent, link it to the session and email the visitor a follow-up link
+// @Tags chat-sessions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat Session ID"
+// @Success 201 {object} db.Ticket
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-sessions/{session_id}/convert-to-ticket [post]
+func (h *ChatSessionHandler) ConvertToTicket(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	ticket, err := h.chatTicketService.ConvertSessionToTicket(c.Request.Context(), tenantID, projectID, sessionID, agentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrChatSessionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found"})
+		case errors.Is(err, service.ErrChatAlreadyConverted):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrChatWithoutEmail):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert chat session: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, ticket)
+}
+
+// ListVisitorConversations lists the past conversations of a verified visitor
+// @Summary List verified visitor conversations
+// @Description List the chat sessions of the visitor identified by a signed identity token. Only sessions started with a verified identity are returned.
+// @Tags chat-sessions
+// @Produce json
+// @Param widget_id path string true "Chat Widget ID"
+// @Param Authorization header string true "Bearer identity token signed with the widget's identity secret"
+// @Success 200 {object} object{conversations=[]models.VisitorConversation}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/public/chat/widgets/{widget_id}/conversations [get]
+func (h *ChatSessionHandler) ListVisitorConversations(c *gin.Context) {
+	widgetID, err := uuid.Parse(c.Param("widget_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid widget ID format"})
+		return
+	}
+
+	conversations, err := h.chatSessionService.ListVisitorConversations(c.Request.Context(), widgetID, bearerToken(c))
+	if err != nil {
+		respondVisitorIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversations": conversations})
+}
+
+// GetVisitorConversationMessages gets the messages of a verified visitor's past conversation
+// @Summary Get verified visitor conversation messages
+// @Description Retrieve the public messages of one of the chat sessions of the visitor identified by a signed identity token
+// @Tags chat-sessions
+// @Produce json
+// @Param widget_id path string true "Chat Widget ID"
+// @Param session_id path string true "Chat Session ID"
+// @Param Authorization header string true "Bearer identity token signed with the widget's identity secret"
+// @Success 200 {object} object{messages=[]models.ChatMessage}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/public/chat/widgets/{widget_id}/conversations/{session_id}/messages [get]
+func (h *ChatSessionHandler) GetVisitorConversationMessages(c *gin.Context) {
+	widgetID, err := uuid.Parse(c.Param("widget_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid widget ID format"})
+		return
+	}
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	messages, err := h.chatSessionService.GetVisitorConversationMessages(c.Request.Context(), widgetID, sessionID, bearerToken(c))
+	if err != nil {
+		respondVisitorIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+func bearerToken(c *gin.Context) string {
+	return strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+}
+
+func respondVisitorIdentityError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrIdentityTokenInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid identity token"})
+	case errors.Is(err, service.ErrIdentityVerificationDisabled), errors.Is(err, service.ErrChatSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load conversations: " + err.Error()})
+	}
+}
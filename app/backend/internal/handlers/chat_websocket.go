@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -48,6 +49,7 @@ type ChatWebSocketHandler struct {
 	aiService           *service.AIService
 	aiAgentClient       *service.AiAgentClient
 	authService         *auth.Service
+	polls               *pollRegistry
 }
 
 func NewChatWebSocketHandler(chatSessionService *service.ChatSessionService, connectionManager *ws.ConnectionManager, notificationService *service.NotificationService, aiService *service.AIService, agentClient *service.AiAgentClient, authService *auth.Service) *ChatWebSocketHandler {
@@ -58,6 +60,7 @@ func NewChatWebSocketHandler(chatSessionService *service.ChatSessionService, con
 		aiService:           aiService,
 		aiAgentClient:       agentClient,
 		authService:         authService,
+		polls:               &pollRegistry{conns: make(map[string]*pollConnection)},
 	}
 }
 
@@ -68,53 +71,18 @@ func NewChatWebSocketHandler(chatSessionService *service.ChatSessionService, con
 // @Accept json
 // @Produce json
 // @Param session_token header string true "Session token for authentication"
+// @Param last_seq query int false "Sequence number of the last message the client received; missed messages are replayed after connecting"
 // @Success 101 "Switching Protocols - WebSocket connection established"
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /public/chat/ws [get]
 func (h *ChatWebSocketHandler) HandleWebSocketPublic(c *gin.Context) {
-	sessionToken := middleware.GetSessionToken(c)
-	widgetID := middleware.GetWidgetID(c)
-
-	// Validate chat token and extract claims
-	claims, err := h.authService.ValidateChatToken(sessionToken, widgetID)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session token"})
+	session, ok := h.resolveVisitorSession(c)
+	if !ok {
 		return
 	}
-	// Extract session ID from claims
-	clientSessionID := claims.SessionID
-	// Validate session
-	session, err := h.chatSessionService.GetChatSessionByClientSessionID(c.Request.Context(), clientSessionID)
-	if err != nil || session == nil {
-		// Create a minimal session or use InitiateChat with required data
-		initReq := &models.InitiateChatRequest{
-			VisitorName:  "",
-			VisitorEmail: "",
-			VisitorInfo:  claims.VisitorInfo,
-		}
-		if claims.VisitorName != nil {
-			initReq.VisitorName = *claims.VisitorName
-			initReq.VisitorInfo["visitor_name"] = *claims.VisitorName
-		}
-		if claims.VisitorEmail != nil {
-			initReq.VisitorEmail = *claims.VisitorEmail
-			initReq.VisitorInfo["visitor_email"] = *claims.VisitorEmail
-		}
-
-		fmt.Println("Initiating chat session:", initReq)
-
-		session, err = h.chatSessionService.InitiateChat(c.Request.Context(), claims.WidgetID, clientSessionID, initReq)
-		if err != nil {
-			fmt.Println("Error creating chat session:", err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat session: " + err.Error()})
-			return
-		}
-
-		// Update the ClientSessionID to match the token
-		session.ClientSessionID = clientSessionID
-	}
+	clientSessionID := session.ClientSessionID
 
 	// Upgrade connection
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -155,6 +123,13 @@ func (h *ChatWebSocketHandler) HandleWebSocketPublic(c *gin.Context) {
 	}
 	h.connectionManager.SendToConnection(connectionID, welcomeMsg)
 
+	// A reconnecting client catches up on what it missed while it was away
+	if lastSeq := c.Query("last_seq"); lastSeq != "" {
+		if afterSeq, err := strconv.ParseInt(lastSeq, 10, 64); err == nil && afterSeq >= 0 {
+			h.resumeVisitor(c.Request.Context(), session, &afterSeq, connectionID)
+		}
+	}
+
 	// Set up ping handler for connection health
 	conn.SetPongHandler(func(string) error {
 		h.connectionManager.UpdateConnectionPing(connectionID)
@@ -187,6 +162,55 @@ func (h *ChatWebSocketHandler) HandleWebSocketPublic(c *gin.Context) {
 	}
 }
 
+// resolveVisitorSession validates the visitor's chat token and returns their session, starting one on
+// first contact. It responds with an error itself when it fails.
+func (h *ChatWebSocketHandler) resolveVisitorSession(c *gin.Context) (*models.ChatSession, bool) {
+	sessionToken := middleware.GetSessionToken(c)
+	widgetID := middleware.GetWidgetID(c)
+
+	// Validate chat token and extract claims
+	claims, err := h.authService.ValidateChatToken(sessionToken, widgetID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session token"})
+		return nil, false
+	}
+	// Extract session ID from claims
+	clientSessionID := claims.SessionID
+	// Validate session
+	session, err := h.chatSessionService.GetChatSessionByClientSessionID(c.Request.Context(), clientSessionID)
+	if err != nil || session == nil {
+		// Create a minimal session or use InitiateChat with required data
+		initReq := &models.InitiateChatRequest{
+			VisitorName:  "",
+			VisitorEmail: "",
+			VisitorInfo:  claims.VisitorInfo,
+		}
+		if claims.VisitorName != nil {
+			initReq.VisitorName = *claims.VisitorName
+			initReq.VisitorInfo["visitor_name"] = *claims.VisitorName
+		}
+		if claims.VisitorEmail != nil {
+			initReq.VisitorEmail = *claims.VisitorEmail
+			initReq.VisitorInfo["visitor_email"] = *claims.VisitorEmail
+		}
+		if claims.IdentityToken != nil {
+			initReq.IdentityToken = *claims.IdentityToken
+		}
+
+		session, err = h.chatSessionService.InitiateChat(c.Request.Context(), claims.WidgetID, clientSessionID, initReq)
+		if err != nil {
+			fmt.Println("Error creating chat session:", err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat session: " + err.Error()})
+			return nil, false
+		}
+
+		// Update the ClientSessionID to match the token
+		session.ClientSessionID = clientSessionID
+	}
+
+	return session, true
+}
+
 // handleVisitorMessage handles incoming WebSocket messages from visitors
 func (h *ChatWebSocketHandler) handleVisitorMessage(ctx context.Context, session *models.ChatSession, msg models.WSMessage, connID string) {
 	switch msg.Type {
@@ -198,9 +222,55 @@ func (h *ChatWebSocketHandler) handleVisitorMessage(ctx context.Context, session
 		h.processVisitorTyping(session, msg, false)
 	case models.WSMsgTypeReadReceipt:
 		h.processReadReceipt(ctx, session, msg, "visitor")
+	case models.WSMsgTypeResume:
+		var data struct {
+			LastSeq *int64 `json:"last_seq"`
+		}
+		decodeWSData(msg.Data, &data)
+		h.resumeVisitor(ctx, session, data.LastSeq, connID)
+	case models.WSMsgTypeAck:
+		var data struct {
+			Seq int64 `json:"seq"`
+		}
+		decodeWSData(msg.Data, &data)
+		if err := h.chatSessionService.AcknowledgeDelivery(ctx, session, service.ChatRecipientVisitor, data.Seq); err != nil {
+			log.Printf("Failed to acknowledge delivery for session %s: %v", session.ID, err)
+		}
+	}
+}
+
+// resumeVisitor replays the messages a visitor missed after lastSeq, or after the last message they
+// acknowledged when the client does not know
+func (h *ChatWebSocketHandler) resumeVisitor(ctx context.Context, session *models.ChatSession, lastSeq *int64, connID string) {
+	var afterSeq int64
+	if lastSeq != nil {
+		afterSeq = *lastSeq
+	} else {
+		acked, err := h.chatSessionService.AcknowledgedSeq(ctx, session.ID, service.ChatRecipientVisitor)
+		if err != nil {
+			log.Printf("Failed to read acknowledged delivery for session %s: %v", session.ID, err)
+		}
+		afterSeq = acked
+	}
+
+	if _, err := h.chatSessionService.ReplayMessages(ctx, session, afterSeq, connID); err != nil {
+		log.Printf("Failed to resume session %s: %v", session.ID, err)
+		h.sendError(connID, "Failed to resume chat")
 	}
 }
 
+// decodeWSData decodes the data of a client WebSocket message, which arrives as generic JSON
+func decodeWSData(data interface{}, v interface{}) {
+	if data == nil {
+		return
+	}
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(dataBytes, v)
+}
+
 // // handleAgentMessage handles incoming WebSocket messages from agents
 // func (h *ChatWebSocketHandler) handleAgentMessage(ctx context.Context, tenantID, projectID, sessionID, agentID uuid.UUID, msg models.WSMessage, connID string) {
 // 	switch msg.Type {
@@ -229,7 +299,8 @@ func (h *ChatWebSocketHandler) processVisitorChatMessage(ctx context.Context, se
 				visitorName = *session.CustomerName
 			}
 
-			go h.chatSessionService.SendMessage(
+			// Stored inline so the visitor's messages keep the order they were sent in
+			if _, err := h.chatSessionService.SendMessage(
 				ctx,
 				session,
 				req,
@@ -237,10 +308,15 @@ func (h *ChatWebSocketHandler) processVisitorChatMessage(ctx context.Context, se
 				nil,
 				visitorName,
 				connID,
-			)
+			); err != nil {
+				log.Printf("Failed to send visitor message for session %s: %v", session.ID, err)
+				h.sendError(connID, "Failed to send message")
+				return
+			}
 
 			// Process AI response using agent client SSE
-			shouldRespondWithAI := h.aiAgentClient != nil && session.UseAI && session.AssignedAgentID == nil
+			shouldRespondWithAI := h.aiAgentClient != nil && session.UseAI && session.AssignedAgentID == nil &&
+				h.aiService.CheckCreditsOrHandoff(ctx, session, connID)
 			fmt.Println("should respond with AI: h.agentClient != nil ->", h.aiAgentClient != nil)
 			fmt.Println("should respond with AI: session.UseAI ->", session.UseAI)
 			fmt.Println("should respond with AI: session.AssignedAgentID == nil ->", session.AssignedAgentID == nil)
@@ -254,90 +330,13 @@ func (h *ChatWebSocketHandler) processVisitorChatMessage(ctx context.Context, se
 					// 	return
 					// }
 					fmt.Println("Processing visitor message with AI agent client... ", content)
-					h.processVisitorResponseUsingAi(ctx, session, content, connID)
+					h.aiService.RespondWithAgent(ctx, session, content, connID, h.aiAgentClient)
 				}()
 			}
 		}
 	}
 }
 
-// getStringValue safely extracts string value from string pointer
-func getStringValue(s *string) string {
-	if s == nil {
-		return ""
-	}
-	return *s
-}
-
-// processVisitorResponseUsingAi handles AI agent response using SSE streaming
-func (h *ChatWebSocketHandler) processVisitorResponseUsingAi(ctx context.Context, session *models.ChatSession, content string, connID string) {
-	// Create agent request
-	request := service.ChatRequest{
-		Message:   content,
-		TenantID:  session.TenantID.String(), // Using ProjectID as TenantID for now
-		ProjectID: session.ProjectID.String(),
-		SessionID: session.ID.String(),
-		UserID:    getStringValue(session.CustomerEmail), // Use customer email as user ID if available
-		Metadata: map[string]string{
-			"connection_id": connID,
-			"widget_id":     session.WidgetID.String(),
-		},
-	}
-
-	fmt.Println("Using AI to response")
-
-	// Start streaming response from agent service
-	responseChan, errorChan := h.aiAgentClient.ProcessMessageStream(ctx, request)
-
-	// Handle the streaming response
-	for {
-		select {
-		case response, ok := <-responseChan:
-			fmt.Println("\nReceived from AI agent response channel:", response, ok)
-			if !ok {
-				// Channel closed, finish processing
-				return
-			}
-
-			// Handle different response types
-			switch response.Type {
-			case "message":
-				fmt.Println("\nReceived AI agent message chunk:", response.Content)
-				if response.Content != "" {
-					h.aiService.SendAIResponse(ctx, session, connID, response.Content, map[string]interface{}{
-						"ai_generated":  true,
-						"response_type": "knowledge_based",
-					})
-				}
-				h.aiService.ProcessAiTyping(session, models.WSMessage{}, connID, false)
-			case "thinking":
-				// Send typing indicator
-				h.aiService.ProcessAiTyping(session, models.WSMessage{}, connID, true)
-			case "done", "metadata":
-				// Processing complete
-				h.aiService.ProcessAiTyping(session, models.WSMessage{}, connID, false)
-			case "error":
-				log.Printf("AI agent processing error for session %s: %s", session.ID, response.Content)
-				h.aiService.ProcessAiTyping(session, models.WSMessage{}, connID, false)
-				return
-			}
-
-		case err, ok := <-errorChan:
-			fmt.Println("\n\nReceived from AI agent error channel:", err, ok)
-			if !ok {
-				return
-			}
-			log.Printf("AI Agent client error for session %s: %v", session.ID, err)
-			h.aiService.ProcessAiTyping(session, models.WSMessage{}, connID, false)
-			return
-
-		case <-ctx.Done():
-			log.Printf("Context cancelled for ai-agent processing in session %s", session.ID)
-			return
-		}
-	}
-}
-
 // sendStreamingResponse sends a streaming chunk to the WebSocket
 func (h *ChatWebSocketHandler) sendStreamingResponse(session *models.ChatSession, content string, isFirst bool, connectionID string) {
 	message := &ws.Message{
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -53,6 +54,10 @@ func (h *ChatWidgetHandler) CreateChatWidget(c *gin.Context) {
 
 	widget, err := h.chatWidgetService.CreateChatWidget(c.Request.Context(), tenantID, projectID, &req)
 	if err != nil {
+		if errors.Is(err, service.ErrPlanLimitReached) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat widget: " + err.Error()})
 		return
 	}
@@ -166,6 +171,10 @@ func (h *ChatWidgetHandler) UpdateChatWidget(c *gin.Context) {
 	}
 
 	widget, err := h.chatWidgetService.UpdateChatWidget(c.Request.Context(), tenantID, projectID, widgetID, &req)
+	if errors.Is(err, service.ErrIdentitySecretMissing) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat widget: " + err.Error()})
 		return
@@ -174,6 +183,40 @@ func (h *ChatWidgetHandler) UpdateChatWidget(c *gin.Context) {
 	c.JSON(http.StatusOK, widget)
 }
 
+// RotateIdentitySecret generates a new identity verification secret for a chat widget
+// @Summary Rotate chat widget identity secret
+// @Description Generate a new secret for signing visitor identity tokens ({user_id, email, name, exp} as an HS256 JWT). The secret is only returned here; tokens signed with the previous secret stop verifying.
+// @Tags chat-widget
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param widget_id path string true "Chat Widget ID"
+// @Success 200 {object} object{identity_secret=string}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/chat-widgets/{widget_id}/identity-secret [post]
+func (h *ChatWidgetHandler) RotateIdentitySecret(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	widgetID, err := uuid.Parse(c.Param("widget_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid widget ID format"})
+		return
+	}
+
+	secret, err := h.chatWidgetService.RotateIdentitySecret(c.Request.Context(), tenantID, projectID, widgetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate identity secret: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"identity_secret": secret})
+}
+
 // DeleteChatWidget deletes a chat widget
 // @Summary Delete chat widget
 // @Description Delete a chat widget by its ID
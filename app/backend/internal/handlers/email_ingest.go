@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	emailIngestTimestampHeader = "X-TMS-Timestamp"
+	emailIngestSignatureHeader = "X-TMS-Signature"
+
+	// maxEmailIngestBodySize caps the JSON body; the base64 raw message is about 4/3 of the SMTP message size
+	maxEmailIngestBodySize = 40 << 20
+)
+
+// EmailIngestHandler receives mail forwarded by the bundled SMTP email-server
+type EmailIngestHandler struct {
+	ingestService *service.EmailIngestService
+}
+
+// NewEmailIngestHandler creates a new email ingestion handler
+func NewEmailIngestHandler(ingestService *service.EmailIngestService) *EmailIngestHandler {
+	return &EmailIngestHandler{ingestService: ingestService}
+}
+
+// EmailToTicket handles POST /v1/public/email-to-ticket
+// @Summary Ingest an email from the SMTP email-server
+// @Description Creates a ticket from a raw MIME message, or adds it as a reply to the ticket it threads onto. The request must carry an X-TMS-Timestamp header and an X-TMS-Signature header of the form sha256=<hex HMAC-SHA256 of "<timestamp>.<body>"> keyed with EMAIL_INGEST_SECRET.
+// @Tags Email
+// @Accept json
+// @Produce json
+// @Param X-TMS-Timestamp header string true "Unix timestamp of the request"
+// @Param X-TMS-Signature header string true "HMAC-SHA256 signature"
+// @Param request body service.InboundEmailRequest true "Envelope and raw message"
+// @Success 200 {object} service.InboundEmailResult "Reply added, duplicate or ignored message"
+// @Success 201 {object} service.InboundEmailResult "Ticket created"
+// @Failure 400 {object} map[string]interface{} "Invalid message"
+// @Failure 401 {object} map[string]interface{} "Invalid signature"
+// @Failure 404 {object} map[string]interface{} "Unknown recipient"
+// @Failure 422 {object} service.InboundEmailResult "Message rejected by the mailbox"
+// @Failure 503 {object} map[string]interface{} "Email ingestion not configured"
+// @Router /v1/public/email-to-ticket [post]
+func (h *EmailIngestHandler) EmailToTicket(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxEmailIngestBodySize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		return
+	}
+
+	if err := h.ingestService.VerifySignature(c.GetHeader(emailIngestTimestampHeader), c.GetHeader(emailIngestSignatureHeader), body); err != nil {
+		respondEmailIngestError(c, err, "Failed to verify request")
+		return
+	}
+
+	var req service.InboundEmailRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	result, err := h.ingestService.Ingest(c.Request.Context(), &req)
+	if err != nil {
+		respondEmailIngestError(c, err, "Failed to ingest email")
+		return
+	}
+
+	switch result.Action {
+	case "create":
+		c.JSON(http.StatusCreated, result)
+	case "reject":
+		c.JSON(http.StatusUnprocessableEntity, result)
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetInboundAddress handles GET /v1/tenants/:tenant_id/projects/:project_id/email/inbound-address
+// @Summary Get the inbound email address of a project
+// @Description Mail sent to this address through the bundled SMTP email-server becomes tickets of the project
+// @Tags Email
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param project_id path string true "Project ID" format(uuid)
+// @Success 200 {object} map[string]interface{} "Inbound address"
+// @Failure 404 {object} map[string]interface{} "Project not found"
+// @Security BearerAuth
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/email/inbound-address [get]
+func (h *EmailIngestHandler) GetInboundAddress(c *gin.Context) {
+	address, err := h.ingestService.InboundAddress(c.Request.Context(), middleware.GetTenantID(c), middleware.GetProjectID(c))
+	if err != nil {
+		if errors.Is(err, service.ErrEmailIngestUnknownRecipient) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+		respondEmailIngestError(c, err, "Failed to get inbound address")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"address": address})
+}
+
+// respondEmailIngestError maps ingestion errors so the email-server can tell
+// permanent failures (bounce) from temporary ones (retry)
+func respondEmailIngestError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrEmailIngestDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEmailIngestSignatureInvalid):
+		logger.WarnfCtx(c.Request.Context(), "%s: %v", fallback, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEmailIngestInvalidMessage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEmailIngestUnknownRecipient):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		logger.ErrorfCtx(c.Request.Context(), err, "%s: %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -811,6 +812,10 @@ func (h *KnowledgeHandler) ScrapeURLs(c *gin.Context) {
 
 	result, err := h.webScraper.ScrapeURLs(c.Request.Context(), tenantID, projectID, req.URLs, req.ForceRefresh)
 	if err != nil {
+		if errors.Is(err, service.ErrPlanLimitReached) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scrape URLs", "details": err.Error()})
 		return
 	}
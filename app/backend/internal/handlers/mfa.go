@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+// MFAHandler handles two-factor authentication endpoints
+type MFAHandler struct {
+	authService *service.AuthService
+	mfaService  *service.MFAService
+	validator   *validator.Validate
+}
+
+// NewMFAHandler creates a new MFA handler
+func NewMFAHandler(authService *service.AuthService, mfaService *service.MFAService) *MFAHandler {
+	return &MFAHandler{
+		authService: authService,
+		mfaService:  mfaService,
+		validator:   validator.New(),
+	}
+}
+
+// MFATokenRequest identifies a pending login challenge
+// @Description MFA challenge token returned by login
+type MFATokenRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
+
+// VerifyMFARequest represents the second step of a login
+// @Description MFA challenge token and a TOTP or recovery code
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token" validate:"required" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Code     string `json:"code" validate:"required" example:"123456"`
+}
+
+// MFACodeRequest carries a verification code
+// @Description TOTP code, or a recovery code where accepted
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required" example:"123456"`
+}
+
+// MFAPolicyRequest sets the tenant MFA policy
+// @Description Tenant MFA policy: off, admins or all
+type MFAPolicyRequest struct {
+	Policy string `json:"policy" binding:"required" example:"admins"`
+}
+
+// StartLoginEnrollment handles POST /v1/auth/mfa/enroll
+// @Summary Start MFA enrollment during login
+// @Description Issue a TOTP secret to an agent whose login challenge has enrollment_required set. Confirm it with /v1/auth/mfa/verify.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body MFATokenRequest true "MFA challenge token"
+// @Success 200 {object} service.MFAEnrollment "TOTP secret and provisioning URI"
+// @Failure 400 {object} map[string]interface{} "Invalid request"
+// @Failure 401 {object} map[string]interface{} "Challenge expired"
+// @Router /v1/auth/mfa/enroll [post]
+func (h *MFAHandler) StartLoginEnrollment(c *gin.Context) {
+	var req MFATokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	enrollment, err := h.authService.StartMFALoginEnrollment(c.Request.Context(), req.MFAToken)
+	if err != nil {
+		respondMFAError(c, err, "Failed to start enrollment")
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// VerifyLogin handles POST /v1/auth/mfa/verify
+// @Summary Complete login with a second factor
+// @Description Exchange an MFA challenge token and a TOTP or recovery code for access tokens. When the challenge required enrollment, the code confirms the new secret and recovery codes are returned once.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body VerifyMFARequest true "Challenge token and code"
+// @Success 200 {object} LoginResponse "Successfully authenticated"
+// @Failure 400 {object} map[string]interface{} "Invalid request"
+// @Failure 401 {object} map[string]interface{} "Invalid code or expired challenge"
+// @Failure 429 {object} map[string]interface{} "Too many attempts"
+// @Router /v1/auth/mfa/verify [post]
+func (h *MFAHandler) VerifyLogin(c *gin.Context) {
+	var req VerifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	response, err := h.authService.VerifyMFA(c.Request.Context(), service.VerifyMFARequest{
+		MFAToken: req.MFAToken,
+		Code:     req.Code,
+	})
+	if err != nil {
+		respondMFAError(c, err, "Failed to verify code")
+		return
+	}
+
+	primaryRole := models.RoleAgent.String()
+	for _, roles := range response.RoleBindings {
+		for _, role := range roles {
+			if role == models.RoleTenantAdmin.String() {
+				primaryRole = role
+				break
+			}
+			if primaryRole == models.RoleAgent.String() {
+				primaryRole = role
+			}
+		}
+		if primaryRole == models.RoleTenantAdmin.String() {
+			break
+		}
+	}
+
+	logger.InfofCtx(c.Request.Context(), "MFA login successful - user_id: %s, tenant_id: %s, primary_role: %s",
+		response.Agent.ID.String(), response.Agent.TenantID.String(), primaryRole)
+
+	c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  response.AccessToken,
+		RefreshToken: response.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    3600,
+		User: User{
+			ID:       response.Agent.ID.String(),
+			Email:    response.Agent.Email,
+			Name:     response.Agent.Name,
+			Role:     primaryRole,
+			TenantID: response.Agent.TenantID.String(),
+		},
+		RecoveryCodes: response.RecoveryCodes,
+	})
+}
+
+// GetStatus handles GET /auth/mfa
+// @Summary Get MFA status
+// @Description Get the two-factor authentication status of the current agent
+// @Tags Auth
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} service.MFAStatus "MFA status"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/auth/mfa [get]
+func (h *MFAHandler) GetStatus(c *gin.Context) {
+	status, err := h.mfaService.GetStatus(c.Request.Context(), middleware.GetTenantID(c), middleware.GetAgentID(c))
+	if err != nil {
+		respondMFAError(c, err, "Failed to get MFA status")
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// StartEnrollment handles POST /auth/mfa/enroll
+// @Summary Start MFA enrollment
+// @Description Generate a TOTP secret and provisioning URI (render it as a QR code). The factor is enabled once confirmed.
+// @Tags Auth
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} service.MFAEnrollment "TOTP secret and provisioning URI"
+// @Failure 409 {object} map[string]interface{} "MFA already enabled"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/auth/mfa/enroll [post]
+func (h *MFAHandler) StartEnrollment(c *gin.Context) {
+	enrollment, err := h.mfaService.StartEnrollment(c.Request.Context(), middleware.GetTenantID(c), middleware.GetAgentID(c))
+	if err != nil {
+		respondMFAError(c, err, "Failed to start enrollment")
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmEnrollment handles POST /auth/mfa/enroll/confirm
+// @Summary Confirm MFA enrollment
+// @Description Enable two-factor authentication with a code from the authenticator app. Returns the recovery codes, which are only shown once.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param request body MFACodeRequest true "TOTP code"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Recovery codes"
+// @Failure 400 {object} map[string]interface{} "No pending enrollment"
+// @Failure 401 {object} map[string]interface{} "Invalid code"
+// @Failure 429 {object} map[string]interface{} "Too many attempts"
+// @Router /v1/tenants/{tenant_id}/auth/mfa/enroll/confirm [post]
+func (h *MFAHandler) ConfirmEnrollment(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	codes, err := h.mfaService.ConfirmEnrollment(c.Request.Context(), middleware.GetTenantID(c), middleware.GetAgentID(c), req.Code)
+	if err != nil {
+		respondMFAError(c, err, "Failed to confirm enrollment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes handles POST /auth/mfa/recovery-codes
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes of the current agent. Requires a TOTP code.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param request body MFACodeRequest true "TOTP code"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Recovery codes"
+// @Failure 401 {object} map[string]interface{} "Invalid code"
+// @Failure 429 {object} map[string]interface{} "Too many attempts"
+// @Router /v1/tenants/{tenant_id}/auth/mfa/recovery-codes [post]
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(c.Request.Context(), middleware.GetTenantID(c), middleware.GetAgentID(c), req.Code)
+	if err != nil {
+		respondMFAError(c, err, "Failed to regenerate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Disable handles POST /auth/mfa/disable
+// @Summary Disable MFA
+// @Description Turn off two-factor authentication for the current agent. Not allowed when the tenant policy requires it.
+// @Tags Auth
+// @Accept json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param request body MFACodeRequest true "TOTP or recovery code"
+// @Security BearerAuth
+// @Success 204 "MFA disabled"
+// @Failure 401 {object} map[string]interface{} "Invalid code"
+// @Failure 403 {object} map[string]interface{} "Required by tenant policy"
+// @Router /v1/tenants/{tenant_id}/auth/mfa/disable [post]
+func (h *MFAHandler) Disable(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.mfaService.Disable(c.Request.Context(), middleware.GetTenantID(c), middleware.GetAgentID(c), req.Code); err != nil {
+		respondMFAError(c, err, "Failed to disable MFA")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetPolicy handles GET /security/mfa-policy
+// @Summary Get tenant MFA policy
+// @Description Get which agents must use two-factor authentication: off, admins or all
+// @Tags Auth
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "MFA policy"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/security/mfa-policy [get]
+func (h *MFAHandler) GetPolicy(c *gin.Context) {
+	policy, err := h.mfaService.GetMFAPolicy(c.Request.Context(), middleware.GetTenantID(c))
+	if err != nil {
+		respondMFAError(c, err, "Failed to get MFA policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policy": policy})
+}
+
+// SetPolicy handles PUT /security/mfa-policy
+// @Summary Set tenant MFA policy
+// @Description Require two-factor authentication for admins or for all agents. Agents without MFA enroll on their next login.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param request body MFAPolicyRequest true "MFA policy"
+// @Security BearerAuth
+// @Success 200 {object} db.TenantSecuritySettings "Updated settings"
+// @Failure 400 {object} map[string]interface{} "Invalid policy"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/security/mfa-policy [put]
+func (h *MFAHandler) SetPolicy(c *gin.Context) {
+	var req MFAPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	settings, err := h.mfaService.SetMFAPolicy(c.Request.Context(), middleware.GetTenantID(c), req.Policy)
+	if err != nil {
+		respondMFAError(c, err, "Failed to set MFA policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// ResetAgentMFA handles DELETE /agents/:agent_id/mfa
+// @Summary Reset agent MFA
+// @Description Remove the second factor and recovery codes of an agent, e.g. after a lost device
+// @Tags Agents
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param agent_id path string true "Agent ID" format(uuid)
+// @Security BearerAuth
+// @Success 204 "MFA reset"
+// @Failure 400 {object} map[string]interface{} "Invalid agent ID"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/agents/{agent_id}/mfa [delete]
+func (h *MFAHandler) ResetAgentMFA(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("agent_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent ID"})
+		return
+	}
+
+	if err := h.mfaService.ResetAgentMFA(c.Request.Context(), middleware.GetTenantID(c), agentID); err != nil {
+		respondMFAError(c, err, "Failed to reset MFA")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// respondMFAError maps MFA errors to HTTP responses
+func respondMFAError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrMFAInvalidCode), errors.Is(err, service.ErrMFAChallengeExpired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFATooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFARequiredByPolicy):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFANotEnabled), errors.Is(err, service.ErrMFANotEnrolling), errors.Is(err, service.ErrMFAInvalidPolicy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.ErrorfCtx(c.Request.Context(), err, "%s: %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RoleHandler manages the permission catalogue and tenant defined roles
+type RoleHandler struct {
+	rbacService *rbac.Service
+}
+
+// NewRoleHandler creates a new role handler
+func NewRoleHandler(rbacService *rbac.Service) *RoleHandler {
+	return &RoleHandler{
+		rbacService: rbacService,
+	}
+}
+
+// ListPermissions handles GET /permissions
+// @Summary List permissions
+// @Description Get the permission catalogue that roles and API key scopes are built from
+// @Tags Roles
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Permission catalogue"
+// @Router /v1/tenants/{tenant_id}/permissions [get]
+func (h *RoleHandler) ListPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"permissions": rbac.AllPermissions})
+}
+
+// ListRoles handles GET /roles
+// @Summary List roles
+// @Description Get the built-in roles and the tenant's custom roles with their permissions
+// @Tags Roles
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "List of roles"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/roles [get]
+func (h *RoleHandler) ListRoles(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+
+	roles, err := h.rbacService.ListRoles(c.Request.Context(), tenantID)
+	if err != nil {
+		log.Printf("Failed to list roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// CreateRole handles POST /roles
+// @Summary Create custom role
+// @Description Define a tenant role from the permission catalogue. It can then be assigned through the agent role endpoints.
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param role body rbac.CustomRoleRequest true "Role definition"
+// @Security BearerAuth
+// @Success 201 {object} db.TenantRole "Role created"
+// @Failure 400 {object} map[string]interface{} "Invalid role definition"
+// @Failure 409 {object} map[string]interface{} "Role already exists"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/roles [post]
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+
+	var req rbac.CustomRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	role, err := h.rbacService.CreateCustomRole(c.Request.Context(), tenantID, req)
+	if err != nil {
+		respondRoleError(c, err, "Failed to create role")
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole handles PUT /roles/:role_id
+// @Summary Update custom role
+// @Description Replace the description and permissions of a custom role. Changes apply to every agent holding the role.
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param role_id path string true "Role ID" format(uuid)
+// @Param role body rbac.CustomRoleRequest true "Role definition"
+// @Security BearerAuth
+// @Success 200 {object} db.TenantRole "Role updated"
+// @Failure 400 {object} map[string]interface{} "Invalid role definition"
+// @Failure 404 {object} map[string]interface{} "Role not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/roles/{role_id} [put]
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+
+	roleID, err := uuid.Parse(c.Param("role_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var req rbac.CustomRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	role, err := h.rbacService.UpdateCustomRole(c.Request.Context(), tenantID, roleID, req)
+	if err != nil {
+		respondRoleError(c, err, "Failed to update role")
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole handles DELETE /roles/:role_id
+// @Summary Delete custom role
+// @Description Delete a custom role that is not assigned to any agent
+// @Tags Roles
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param role_id path string true "Role ID" format(uuid)
+// @Security BearerAuth
+// @Success 204 "Role deleted"
+// @Failure 404 {object} map[string]interface{} "Role not found"
+// @Failure 409 {object} map[string]interface{} "Role is still assigned"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/roles/{role_id} [delete]
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+
+	roleID, err := uuid.Parse(c.Param("role_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	if err := h.rbacService.DeleteCustomRole(c.Request.Context(), tenantID, roleID); err != nil {
+		respondRoleError(c, err, "Failed to delete role")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// respondRoleError maps custom role errors to HTTP responses
+func respondRoleError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, rbac.ErrInvalidRoleInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, rbac.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, rbac.ErrRoleExists), errors.Is(err, rbac.ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("%s: %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -45,6 +45,11 @@ type AutomationSettings struct {
 	EscalationThresholdHours int    `json:"escalation_threshold_hours"`
 	EnableAutoReply          bool   `json:"enable_auto_reply"`
 	AutoReplyTemplate        string `json:"auto_reply_template"`
+	// AI triage classifies tickets customers open; type and priority at or above the auto-apply
+	// confidence are set on the ticket, those at or above the suggest confidence are shown to agents
+	EnableAITriage              bool    `json:"enable_ai_triage"`
+	AITriageAutoApplyConfidence float64 `json:"ai_triage_auto_apply_confidence,omitempty" binding:"omitempty,gt=0,lte=1"`
+	AITriageSuggestConfidence   float64 `json:"ai_triage_suggest_confidence,omitempty" binding:"omitempty,gt=0,lte=1"`
 }
 
 // AboutMeSettings represents about me configuration
@@ -56,6 +56,7 @@ type SlackEventRequest struct {
 	Challenge string          `json:"challenge,omitempty"` // For URL verification
 	Token     string          `json:"token"`
 	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id,omitempty"`
 	Event     json.RawMessage `json:"event"`
 }
 
@@ -73,11 +74,14 @@ type SlackMessageEvent struct {
 
 // HandleSlackEvents handles incoming Slack event webhooks
 // @Summary Slack events webhook
-// @Description Receives Slack events for bidirectional chat sync
+// @Description Receives Slack events for bidirectional chat sync. Requests must be signed with the Slack app signing secret.
 // @Tags integrations
 // @Accept json
 // @Produce json
+// @Param X-Slack-Request-Timestamp header string true "Unix timestamp of the request"
+// @Param X-Slack-Signature header string true "v0 HMAC-SHA256 signature"
 // @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string "Invalid signature or stale timestamp"
 // @Router /api/public/integrations/slack/events [post]
 func (h *SlackEventsHandler) HandleSlackEvents(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -103,6 +107,20 @@ func (h *SlackEventsHandler) HandleSlackEvents(c *gin.Context) {
 
 	// Handle event callback
 	if eventReq.Type == "event_callback" {
+		// Slack retries events it did not see acknowledged in time; only process each event once
+		firstDelivery, err := h.slackService.ClaimSlackEvent(ctx, eventReq.EventID)
+		if err != nil {
+			logger.WarnfCtx(ctx, "Slack event de-duplication unavailable: %v", err)
+		}
+		if !firstDelivery {
+			logger.GetTxLogger(ctx).Info().
+				Str("event_id", eventReq.EventID).
+				Str("retry_num", c.GetHeader("X-Slack-Retry-Num")).
+				Msg("Ignoring duplicate Slack event delivery")
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+			return
+		}
+
 		var messageEvent SlackMessageEvent
 		if err := json.Unmarshal(eventReq.Event, &messageEvent); err != nil {
 			logger.GetTxLogger(ctx).Error().Err(err).Msg("Failed to parse Slack message event")
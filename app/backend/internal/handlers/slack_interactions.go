@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// SlackInteractionsHandler handles Slack interactivity (Block Kit buttons) and slash commands
+type SlackInteractionsHandler struct {
+	interactionService *service.SlackInteractionService
+	slackService       *service.SlackService
+}
+
+// NewSlackInteractionsHandler creates a new Slack interactions handler
+func NewSlackInteractionsHandler(interactionService *service.SlackInteractionService, slackService *service.SlackService) *SlackInteractionsHandler {
+	return &SlackInteractionsHandler{
+		interactionService: interactionService,
+		slackService:       slackService,
+	}
+}
+
+// HandleInteraction handles Block Kit button clicks on chat session posts
+// @Summary Slack interactivity webhook
+// @Description Receives Block Kit actions (claim, escalate, close, convert to ticket) from chat session posts. Requests must be signed with the Slack app signing secret.
+// @Tags integrations
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param X-Slack-Request-Timestamp header string true "Unix timestamp of the request"
+// @Param X-Slack-Signature header string true "v0 HMAC-SHA256 signature"
+// @Param payload formData string true "JSON interaction payload"
+// @Success 200 "Acknowledged"
+// @Failure 400 {object} map[string]string "Invalid payload"
+// @Failure 401 {object} map[string]string "Invalid signature or stale timestamp"
+// @Router /api/public/integrations/slack/interactions [post]
+func (h *SlackInteractionsHandler) HandleInteraction(c *gin.Context) {
+	var payload service.SlackInteractionPayload
+	if err := json.Unmarshal([]byte(c.PostForm("payload")), &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	// Slack expects an acknowledgement within 3 seconds; results go to the thread or response_url
+	c.Status(http.StatusOK)
+	if payload.Type != "block_actions" {
+		return
+	}
+
+	ctx := logger.WithTransaction(context.Background())
+	go func() {
+		if err := h.interactionService.HandleBlockAction(ctx, &payload); err != nil {
+			h.respondSlackError(ctx, payload.ResponseURL, err, "Slack action failed")
+		}
+	}()
+}
+
+// HandleSlashCommand handles the /tms slash command
+// @Summary Slack slash command webhook
+// @Description Runs /tms sub-commands: ticket <number>, search <query> and status. Requests must be signed with the Slack app signing secret.
+// @Tags integrations
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param X-Slack-Request-Timestamp header string true "Unix timestamp of the request"
+// @Param X-Slack-Signature header string true "v0 HMAC-SHA256 signature"
+// @Success 200 "Acknowledged"
+// @Failure 400 {object} map[string]string "Invalid command"
+// @Failure 401 {object} map[string]string "Invalid signature or stale timestamp"
+// @Router /api/public/integrations/slack/commands [post]
+func (h *SlackInteractionsHandler) HandleSlashCommand(c *gin.Context) {
+	var cmd service.SlackSlashCommand
+	if err := c.ShouldBind(&cmd); err != nil || cmd.ResponseURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid command"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+
+	ctx := logger.WithTransaction(context.Background())
+	go func() {
+		response, err := h.interactionService.HandleSlashCommand(ctx, &cmd)
+		if err != nil {
+			h.respondSlackError(ctx, cmd.ResponseURL, err, "Slack command failed")
+			return
+		}
+		if err := h.slackService.RespondToURL(ctx, cmd.ResponseURL, response); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to respond to Slack command: %v", err)
+		}
+	}()
+}
+
+// respondSlackError tells the Slack user why their action failed, without leaking internal errors
+func (h *SlackInteractionsHandler) respondSlackError(ctx context.Context, responseURL string, err error, fallback string) {
+	text := "Something went wrong, please try again."
+	switch {
+	case errors.Is(err, service.ErrSlackIntegrationNotFound),
+		errors.Is(err, service.ErrSlackAgentNotLinked),
+		errors.Is(err, service.ErrSlackPermissionDenied),
+		errors.Is(err, service.ErrSlackSessionNotFound),
+		errors.Is(err, service.ErrSlackUnknownAction),
+		errors.Is(err, service.ErrSlackChatWithoutEmail):
+		logger.WarnfCtx(ctx, "%s: %v", fallback, err)
+		text = "Sorry, " + err.Error() + "."
+	default:
+		logger.ErrorfCtx(ctx, err, "%s: %v", fallback, err)
+	}
+
+	if responseURL == "" {
+		return
+	}
+	if err := h.slackService.RespondToURL(ctx, responseURL, &service.SlackResponseMessage{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}); err != nil {
+		logger.ErrorfCtx(ctx, err, "Failed to respond to Slack: %v", err)
+	}
+}
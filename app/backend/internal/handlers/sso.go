@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+// SSOHandler handles single sign-on logins and identity provider configuration
+type SSOHandler struct {
+	authService *service.AuthService
+	ssoService  *service.SSOService
+	validator   *validator.Validate
+}
+
+// NewSSOHandler creates a new SSO handler
+func NewSSOHandler(authService *service.AuthService, ssoService *service.SSOService) *SSOHandler {
+	return &SSOHandler{
+		authService: authService,
+		ssoService:  ssoService,
+		validator:   validator.New(),
+	}
+}
+
+// SSODiscoverRequest looks up the identity providers for an email address
+// @Description Email address entered on the login page
+type SSODiscoverRequest struct {
+	Email string `json:"email" validate:"required,email" example:"jane@example.com"`
+}
+
+// PasswordLoginRequest turns password logins on or off
+// @Description Whether agents of the tenant may sign in with a password, magic link or Google
+type PasswordLoginRequest struct {
+	Disabled bool `json:"disabled" example:"true"`
+}
+
+// SSOProviderResponse is an identity provider together with the URLs to register at the IdP
+// @Description SSO provider configuration and service provider URLs
+type SSOProviderResponse struct {
+	*db.SSOProvider
+	LoginURL    string `json:"login_url"`
+	CallbackURL string `json:"callback_url,omitempty"`
+	ACSURL      string `json:"acs_url,omitempty"`
+	EntityID    string `json:"entity_id,omitempty"`
+}
+
+// Discover handles POST /v1/auth/sso/discover
+// @Summary Find SSO providers for an email
+// @Description Return the single sign-on providers that claim the domain of an email address, so the login page can offer them
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body SSODiscoverRequest true "Email address"
+// @Success 200 {object} map[string]interface{} "Matching providers"
+// @Failure 400 {object} map[string]interface{} "Invalid request"
+// @Router /v1/auth/sso/discover [post]
+func (h *SSOHandler) Discover(c *gin.Context) {
+	var req SSODiscoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	providers, err := h.ssoService.DiscoverProviders(c.Request.Context(), req.Email)
+	if err != nil {
+		respondSSOError(c, err, "Failed to find SSO providers")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// Login handles GET /v1/auth/sso/:provider_id/login
+// @Summary Start SSO login
+// @Description Redirects the browser to the tenant's OIDC or SAML identity provider
+// @Tags Auth
+// @Param provider_id path string true "SSO provider ID" format(uuid)
+// @Success 302 {string} string "Redirect to the identity provider"
+// @Failure 404 {object} map[string]interface{} "Provider not found"
+// @Router /v1/auth/sso/{provider_id}/login [get]
+func (h *SSOHandler) Login(c *gin.Context) {
+	providerID, err := uuid.Parse(c.Param("provider_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	redirectURL, err := h.ssoService.BeginLogin(c.Request.Context(), providerID)
+	if err != nil {
+		respondSSOError(c, err, "Failed to start SSO login")
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// OIDCCallback handles GET /v1/auth/sso/:provider_id/callback
+// @Summary Handle OIDC callback
+// @Description Exchanges the authorization code, verifies the ID token and logs in the agent, creating it when just-in-time provisioning is enabled. May return an MFA challenge instead of tokens.
+// @Tags Auth
+// @Produce json
+// @Param provider_id path string true "SSO provider ID" format(uuid)
+// @Param code query string true "Authorization code"
+// @Param state query string true "Login state"
+// @Success 200 {object} LoginResponse "Successfully authenticated"
+// @Failure 401 {object} map[string]interface{} "Authentication failed"
+// @Failure 403 {object} map[string]interface{} "Agent not provisioned"
+// @Router /v1/auth/sso/{provider_id}/callback [get]
+func (h *SSOHandler) OIDCCallback(c *gin.Context) {
+	providerID, err := uuid.Parse(c.Param("provider_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	if idpError := c.Query("error"); idpError != "" {
+		logger.WarnfCtx(c.Request.Context(), "Identity provider returned an error - provider_id: %s, error: %s", providerID, idpError)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "SSO login was cancelled or denied", "details": c.Query("error_description")})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing authorization code"})
+		return
+	}
+
+	identity, err := h.ssoService.CompleteOIDCLogin(c.Request.Context(), providerID, c.Query("state"), code)
+	if err != nil {
+		respondSSOError(c, err, "SSO login failed")
+		return
+	}
+
+	h.completeLogin(c, identity)
+}
+
+// SAMLACS handles POST /v1/auth/sso/:provider_id/acs
+// @Summary Handle SAML response
+// @Description Assertion consumer service for the HTTP-POST binding. Validates the signed SAML response and logs in the agent, creating it when just-in-time provisioning is enabled. May return an MFA challenge instead of tokens.
+// @Tags Auth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param provider_id path string true "SSO provider ID" format(uuid)
+// @Param SAMLResponse formData string true "Base64 encoded SAML response"
+// @Param RelayState formData string true "Login state"
+// @Success 200 {object} LoginResponse "Successfully authenticated"
+// @Failure 401 {object} map[string]interface{} "Authentication failed"
+// @Failure 403 {object} map[string]interface{} "Agent not provisioned"
+// @Router /v1/auth/sso/{provider_id}/acs [post]
+func (h *SSOHandler) SAMLACS(c *gin.Context) {
+	providerID, err := uuid.Parse(c.Param("provider_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	samlResponse := c.PostForm("SAMLResponse")
+	if samlResponse == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing SAML response"})
+		return
+	}
+
+	identity, err := h.ssoService.CompleteSAMLLogin(c.Request.Context(), providerID, c.PostForm("RelayState"), samlResponse)
+	if err != nil {
+		respondSSOError(c, err, "SSO login failed")
+		return
+	}
+
+	h.completeLogin(c, identity)
+}
+
+// Metadata handles GET /v1/auth/sso/:provider_id/metadata
+// @Summary Get SAML service provider metadata
+// @Description Service provider metadata to upload to the SAML identity provider
+// @Tags Auth
+// @Produce xml
+// @Param provider_id path string true "SSO provider ID" format(uuid)
+// @Success 200 {string} string "SP metadata"
+// @Failure 404 {object} map[string]interface{} "Provider not found"
+// @Router /v1/auth/sso/{provider_id}/metadata [get]
+func (h *SSOHandler) Metadata(c *gin.Context) {
+	providerID, err := uuid.Parse(c.Param("provider_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	metadata, err := h.ssoService.ServiceProviderMetadata(c.Request.Context(), providerID)
+	if err != nil {
+		respondSSOError(c, err, "Failed to build metadata")
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", []byte(metadata))
+}
+
+// completeLogin signs in the agent behind a verified identity and writes the login response
+func (h *SSOHandler) completeLogin(c *gin.Context, identity *service.SSOIdentity) {
+	response, err := h.authService.CompleteSSOLogin(c.Request.Context(), identity)
+	if err != nil {
+		respondSSOError(c, err, "SSO login failed")
+		return
+	}
+
+	// A second factor is needed before tokens are issued
+	if response.MFAChallenge != nil {
+		logger.InfofCtx(c.Request.Context(), "MFA challenge issued - user_id: %s, enrollment_required: %v",
+			response.Agent.ID.String(), response.MFAChallenge.EnrollmentRequired)
+		c.JSON(http.StatusOK, response.MFAChallenge)
+		return
+	}
+
+	// Determine primary role
+	primaryRole := models.RoleAgent.String()
+	for _, roles := range response.RoleBindings {
+		for _, role := range roles {
+			if role == models.RoleTenantAdmin.String() {
+				primaryRole = role
+				break
+			}
+			if primaryRole == models.RoleAgent.String() {
+				primaryRole = role
+			}
+		}
+		if primaryRole == models.RoleTenantAdmin.String() {
+			break
+		}
+	}
+
+	logger.InfofCtx(c.Request.Context(), "SSO login successful - user_id: %s, tenant_id: %s, provider_id: %s, primary_role: %s",
+		response.Agent.ID.String(), response.Agent.TenantID.String(), identity.Provider.ID.String(), primaryRole)
+
+	c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  response.AccessToken,
+		RefreshToken: response.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    3600,
+		User: User{
+			ID:       response.Agent.ID.String(),
+			Email:    response.Agent.Email,
+			Name:     response.Agent.Name,
+			Role:     primaryRole,
+			TenantID: response.Agent.TenantID.String(),
+		},
+	})
+}
+
+// ListProviders handles GET /sso/providers
+// @Summary List SSO providers
+// @Description List the OIDC and SAML identity providers of the tenant
+// @Tags Tenants
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "SSO providers"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /v1/tenants/{tenant_id}/sso/providers [get]
+func (h *SSOHandler) ListProviders(c *gin.Context) {
+	providers, err := h.ssoService.ListProviders(c.Request.Context(), middleware.GetTenantID(c))
+	if err != nil {
+		respondSSOError(c, err, "Failed to list SSO providers")
+		return
+	}
+
+	responses := make([]SSOProviderResponse, 0, len(providers))
+	for _, provider := range providers {
+		responses = append(responses, h.providerResponse(provider))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"providers": responses})
+}
+
+// GetProvider handles GET /sso/providers/:provider_id
+// @Summary Get SSO provider
+// @Description Get an identity provider and the URLs to register at the IdP
+// @Tags Tenants
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param provider_id path string true "SSO provider ID" format(uuid)
+// @Security BearerAuth
+// @Success 200 {object} SSOProviderResponse "SSO provider"
+// @Failure 404 {object} map[string]interface{} "Provider not found"
+// @Router /v1/tenants/{tenant_id}/sso/providers/{provider_id} [get]
+func (h *SSOHandler) GetProvider(c *gin.Context) {
+	providerID, err := uuid.Parse(c.Param("provider_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	provider, err := h.ssoService.GetProvider(c.Request.Context(), middleware.GetTenantID(c), providerID)
+	if err != nil {
+		respondSSOError(c, err, "Failed to get SSO provider")
+		return
+	}
+
+	c.JSON(http.StatusOK, h.providerResponse(provider))
+}
+
+// CreateProvider handles POST /sso/providers
+// @Summary Create SSO provider
+// @Description Configure an OIDC or SAML 2.0 identity provider, its email domains and IdP group to role mappings
+// @Tags Tenants
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param request body service.SSOProviderRequest true "Provider configuration"
+// @Security BearerAuth
+// @Success 201 {object} SSOProviderResponse "Created provider"
+// @Failure 400 {object} map[string]interface{} "Invalid configuration"
+// @Failure 409 {object} map[string]interface{} "Provider name already used"
+// @Router /v1/tenants/{tenant_id}/sso/providers [post]
+func (h *SSOHandler) CreateProvider(c *gin.Context) {
+	var req service.SSOProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+
+	provider, err := h.ssoService.CreateProvider(c.Request.Context(), middleware.GetTenantID(c), req)
+	if err != nil {
+		respondSSOError(c, err, "Failed to create SSO provider")
+		return
+	}
+
+	c.JSON(http.StatusCreated, h.providerResponse(provider))
+}
+
+// UpdateProvider handles PUT /sso/providers/:provider_id
+// @Summary Update SSO provider
+// @Description Replace the configuration of an identity provider. An empty client_secret keeps the stored secret.
+// @Tags Tenants
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param provider_id path string true "SSO provider ID" format(uuid)
+// @Param request body service.SSOProviderRequest true "Provider configuration"
+// @Security BearerAuth
+// @Success 200 {object} SSOProviderResponse "Updated provider"
+// @Failure 400 {object} map[string]interface{} "Invalid configuration"
+// @Failure 404 {object} map[string]interface{} "Provider not found"
+// @Router /v1/tenants/{tenant_id}/sso/providers/{provider_id} [put]
+func (h *SSOHandler) UpdateProvider(c *gin.Context) {
+	providerID, err := uuid.Parse(c.Param("provider_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	var req service.SSOProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+
+	provider, err := h.ssoService.UpdateProvider(c.Request.Context(), middleware.GetTenantID(c), providerID, req)
+	if err != nil {
+		respondSSOError(c, err, "Failed to update SSO provider")
+		return
+	}
+
+	c.JSON(http.StatusOK, h.providerResponse(provider))
+}
+
+// DeleteProvider handles DELETE /sso/providers/:provider_id
+// @Summary Delete SSO provider
+// @Description Remove an identity provider. The last enabled provider cannot be removed while password login is disabled.
+// @Tags Tenants
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param provider_id path string true "SSO provider ID" format(uuid)
+// @Security BearerAuth
+// @Success 204 "Provider deleted"
+// @Failure 404 {object} map[string]interface{} "Provider not found"
+// @Failure 409 {object} map[string]interface{} "Last enabled provider"
+// @Router /v1/tenants/{tenant_id}/sso/providers/{provider_id} [delete]
+func (h *SSOHandler) DeleteProvider(c *gin.Context) {
+	providerID, err := uuid.Parse(c.Param("provider_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	if err := h.ssoService.DeleteProvider(c.Request.Context(), middleware.GetTenantID(c), providerID); err != nil {
+		respondSSOError(c, err, "Failed to delete SSO provider")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SetPasswordLogin handles PUT /security/password-login
+// @Summary Enable or disable password login
+// @Description Require agents of the tenant to sign in through an SSO provider. Disabling password login needs at least one enabled provider.
+// @Tags Tenants
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID" format(uuid)
+// @Param request body PasswordLoginRequest true "Password login setting"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Updated setting"
+// @Failure 400 {object} map[string]interface{} "No enabled SSO provider"
+// @Router /v1/tenants/{tenant_id}/security/password-login [put]
+func (h *SSOHandler) SetPasswordLogin(c *gin.Context) {
+	var req PasswordLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.ssoService.SetPasswordLoginDisabled(c.Request.Context(), middleware.GetTenantID(c), req.Disabled); err != nil {
+		respondSSOError(c, err, "Failed to update password login")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"password_login_disabled": req.Disabled})
+}
+
+func (h *SSOHandler) providerResponse(provider *db.SSOProvider) SSOProviderResponse {
+	response := SSOProviderResponse{
+		SSOProvider: provider,
+		LoginURL:    h.ssoService.LoginURL(provider.ID),
+	}
+	if provider.Protocol == models.SSOProtocolSAML {
+		response.ACSURL = h.ssoService.ACSURL(provider.ID)
+		response.EntityID = h.ssoService.EntityID(provider.ID)
+	} else {
+		response.CallbackURL = h.ssoService.CallbackURL(provider.ID)
+	}
+	return response
+}
+
+// respondSSOError maps SSO errors to HTTP responses
+func respondSSOError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrSSOProviderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSSOInvalidProvider), errors.Is(err, service.ErrSSONoEnabledProvider):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSSOStateInvalid), errors.Is(err, service.ErrSSOAuthFailed):
+		logger.WarnfCtx(c.Request.Context(), "%s: %v", fallback, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "SSO authentication failed"})
+	case errors.Is(err, service.ErrSSOEmailNotAllowed), errors.Is(err, service.ErrSSONotProvisioned), errors.Is(err, service.ErrSSOAccountConflict):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSSOLastProvider), errors.Is(err, service.ErrSSOProviderExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logger.ErrorfCtx(c.Request.Context(), err, "%s: %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+const defaultTriageAccuracyWindow = 30 * 24 * time.Hour
+
+// TicketTriageHandler exposes AI ticket triage decisions and their review
+type TicketTriageHandler struct {
+	triageService *service.TicketTriageService
+}
+
+// NewTicketTriageHandler creates a new ticket triage handler
+func NewTicketTriageHandler(triageService *service.TicketTriageService) *TicketTriageHandler {
+	return &TicketTriageHandler{triageService: triageService}
+}
+
+// GetTriage returns the latest triage decision of a ticket
+// @Summary Get ticket triage
+// @Description Get the latest AI triage decision of a ticket, with the fields that were applied or only suggested
+// @Tags ticket-triage
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id path string true "Tenant ID"
+// @Param project_id path string true "Project ID"
+// @Param ticket_id path string true "Ticket ID"
+// @Success 200 {object} models.TicketTriage
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/tickets/{ticket_id}/triage [get]
+func (h *TicketTriageHandler) GetTriage(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	ticketID, err := uuid.Parse(c.Param("ticket_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID format"})
+		return
+	}
+
+	triage, err := h.triageService.GetLatestTriage(c.Request.Context(), tenantID, projectID, ticketID)
+	if err != nil {
+		respondTicketTriageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, triage)
+}
+
+// TriageTicket triages a ticket again
+// @Summary Triage ticket
+// @Description Classify a ticket's type, priority, tags, language and sentiment from its customer messages. Type and priority are applied or suggested by the project's confidence thresholds; the LLM pass is billed to the tenant's AI credits.
+// @Tags ticket-triage
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id path string true "Tenant ID"
+// @Param project_id path string true "Project ID"
+// @Param ticket_id path string true "Ticket ID"
+// @Success 200 {object} models.TicketTriage
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/tickets/{ticket_id}/triage [post]
+func (h *TicketTriageHandler) TriageTicket(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	ticketID, err := uuid.Parse(c.Param("ticket_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID format"})
+		return
+	}
+
+	triage, err := h.triageService.TriageTicket(c.Request.Context(), tenantID, projectID, ticketID)
+	if err != nil {
+		respondTicketTriageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, triage)
+}
+
+// ReviewTriage records an agent's verdict on a ticket's triage
+// @Summary Review ticket triage
+// @Description Accept or correct the latest triage decision of a ticket. Corrected type and priority are applied to the ticket.
+// @Tags ticket-triage
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id path string true "Tenant ID"
+// @Param project_id path string true "Project ID"
+// @Param ticket_id path string true "Ticket ID"
+// @Param review body models.TicketTriageReviewRequest true "Reviewed values"
+// @Success 200 {object} models.TicketTriage
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/tickets/{ticket_id}/triage/review [post]
+func (h *TicketTriageHandler) ReviewTriage(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	ticketID, err := uuid.Parse(c.Param("ticket_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID format"})
+		return
+	}
+
+	var req models.TicketTriageReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	triage, err := h.triageService.ReviewTriage(c.Request.Context(), tenantID, projectID, ticketID, agentID, &req)
+	if err != nil {
+		respondTicketTriageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, triage)
+}
+
+// ListTriage lists a project's triage decisions
+// @Summary List ticket triage decisions
+// @Description List a project's AI triage decisions, newest first, for accuracy review
+// @Tags ticket-triage
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param review_status query string false "Only decisions with this review status (pending, accepted, corrected)"
+// @Param limit query int false "Maximum number of decisions (default 50, max 200)"
+// @Success 200 {array} models.TicketTriage
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/triage [get]
+func (h *TicketTriageHandler) ListTriage(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	reviewStatus := c.Query("review_status")
+	switch reviewStatus {
+	case "", models.TicketTriagePending, models.TicketTriageAccepted, models.TicketTriageCorrected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review status"})
+		return
+	}
+
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	decisions, err := h.triageService.ListTriage(c.Request.Context(), tenantID, projectID, reviewStatus, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list triage decisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, decisions)
+}
+
+// GetAccuracy reports how accurate a project's triage has been
+// @Summary Get ticket triage accuracy
+// @Description How often reviewers agreed with the triaged type and priority, overall and for values that were auto-applied
+// @Tags ticket-triage
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param since query string false "Start of the period in RFC 3339 (default 30 days ago)"
+// @Success 200 {object} models.TicketTriageAccuracy
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/ai/triage/accuracy [get]
+func (h *TicketTriageHandler) GetAccuracy(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	since := time.Now().Add(-defaultTriageAccuracyWindow)
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected RFC 3339"})
+			return
+		}
+		since = parsed
+	}
+
+	accuracy, err := h.triageService.Accuracy(c.Request.Context(), tenantID, projectID, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get triage accuracy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, accuracy)
+}
+
+func respondTicketTriageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTicketTriageNotFound), errors.Is(err, service.ErrTicketTriageNotTriaged):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to triage ticket: " + err.Error()})
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/middleware"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/service"
+)
+
+// TranslationHandler manages how a project translates between customers and agents, and serves the
+// translations of its conversations
+type TranslationHandler struct {
+	translationService *service.TranslationService
+}
+
+// NewTranslationHandler creates a new translation handler
+func NewTranslationHandler(translationService *service.TranslationService) *TranslationHandler {
+	return &TranslationHandler{translationService: translationService}
+}
+
+// GetSettings returns the translation settings of a project
+// @Summary Get translation settings
+// @Description Get whether customer messages are translated for agents and agent replies for customers, and the language agents who have not set their own read. Projects that have not set them get the defaults: off, and English.
+// @Tags translation
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Success 200 {object} models.TranslationSettings
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/settings/translation [get]
+func (h *TranslationHandler) GetSettings(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	c.JSON(http.StatusOK, h.translationService.Settings(c.Request.Context(), tenantID, projectID))
+}
+
+// UpdateSettings replaces the translation settings of a project
+// @Summary Update translation settings
+// @Description Replace the translation settings of a project. agent_language takes an ISO 639-1 code; agents set their own with preferred_language.
+// @Tags translation
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param settings body models.TranslationSettings true "Translation settings"
+// @Success 200 {object} models.TranslationSettings
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/settings/translation [put]
+func (h *TranslationHandler) UpdateSettings(c *gin.Context) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+
+	var req models.TranslationSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.translationService.UpdateSettings(c.Request.Context(), tenantID, projectID, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidTranslationSettings) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update translation settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// ListChatTranslations returns the translations of a chat's messages
+// @Summary List chat translations
+// @Description List the translations of a chat's messages into the language of the requesting agent: visitor messages translated for the agent, and the originals of agent replies that were translated for the visitor. Visitor messages translated for an agent reading another language are translated first. Tokens are billed to the tenant's AI credits.
+// @Tags translation
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id header string true "Tenant ID"
+// @Param project_id header string true "Project ID"
+// @Param session_id path string true "Chat session ID"
+// @Success 200 {object} models.MessageTranslationList
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/tenants/{tenant_id}/projects/{project_id}/chat/sessions/{session_id}/translations [get]
+func (h *TranslationHandler) ListChatTranslations(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+	h.listTranslations(c, models.MessageKindChat, sessionID)
+}
+
+// ListTicketTranslations returns the translations of a ticket's messages
+// @Summary List ticket translations
+// @Description List the translations of a ticket's messages into the language of the requesting agent: customer emails translated for the agent, and the originals of agent replies that were translated for the customer. Emails translated for an agent reading another language are translated first. Tokens are billed to the tenant's AI credits.
+// @Tags translation
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tenant_id path string true "Tenant ID"
+// @Param project_id path string true "Project ID"
+// @Param ticket_id path string true "Ticket ID"
+// @Success 200 {object} models.MessageTranslationList
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /v1/tenants/{tenant_id}/projects/{project_id}/tickets/{ticket_id}/translations [get]
+func (h *TranslationHandler) ListTicketTranslations(c *gin.Context) {
+	ticketID, err := uuid.Parse(c.Param("ticket_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID format"})
+		return
+	}
+	h.listTranslations(c, models.MessageKindTicket, ticketID)
+}
+
+func (h *TranslationHandler) listTranslations(c *gin.Context, kind string, conversationID uuid.UUID) {
+	tenantID := middleware.GetTenantID(c)
+	projectID := middleware.GetProjectID(c)
+	agentID := middleware.GetAgentID(c)
+
+	translations, err := h.translationService.ListTranslations(c.Request.Context(), tenantID, projectID, kind, conversationID, agentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list translations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, translations)
+}
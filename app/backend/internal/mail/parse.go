@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset" // registers charset decoders for non UTF-8 mail
+	gomail "github.com/emersion/go-message/mail"
+)
+
+var verpTokenPattern = regexp.MustCompile(`^t\+([a-zA-Z0-9]+)@`)
+
+// ParseRawMessage parses a raw RFC 5322 message, as received over SMTP. Text and HTML
+// bodies are decoded to UTF-8 and every other part is kept as an attachment.
+func ParseRawMessage(raw []byte) (*ParsedMessage, error) {
+	reader, err := gomail.CreateReader(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	parsed := &ParsedMessage{
+		Headers:    make(map[string][]string),
+		RawMessage: raw,
+	}
+
+	header := reader.Header
+	fields := header.Fields()
+	for fields.Next() {
+		value, err := fields.Text()
+		if err != nil {
+			value = fields.Value()
+		}
+		key := strings.ToLower(fields.Key())
+		parsed.Headers[key] = append(parsed.Headers[key], value)
+	}
+
+	parsed.MessageID, _ = header.MessageID()
+	if inReplyTo, _ := header.MsgIDList("In-Reply-To"); len(inReplyTo) > 0 {
+		parsed.InReplyTo = inReplyTo[0]
+	}
+	parsed.References, _ = header.MsgIDList("References")
+	parsed.Subject, _ = header.Subject()
+	parsed.Date, _ = header.Date()
+
+	if from, _ := header.AddressList("From"); len(from) > 0 {
+		parsed.From = formatMailAddress(from[0])
+	}
+	to, _ := header.AddressList("To")
+	parsed.To = formatMailAddresses(to)
+	cc, _ := header.AddressList("Cc")
+	parsed.CC = formatMailAddresses(cc)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !message.IsUnknownCharset(err) {
+			return nil, fmt.Errorf("failed to read message part: %w", err)
+		}
+
+		body, err := io.ReadAll(part.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message part: %w", err)
+		}
+
+		switch h := part.Header.(type) {
+		case *gomail.InlineHeader:
+			contentType, _, _ := h.ContentType()
+			switch {
+			case contentType == "text/plain" && parsed.TextBody == "":
+				parsed.TextBody = string(body)
+				continue
+			case contentType == "text/html" && parsed.HTMLBody == "":
+				parsed.HTMLBody = string(body)
+				continue
+			}
+			attachment := &gomail.AttachmentHeader{Header: h.Header}
+			parsed.Attachments = append(parsed.Attachments, newAttachment(attachment, body, true))
+		case *gomail.AttachmentHeader:
+			parsed.Attachments = append(parsed.Attachments, newAttachment(h, body, false))
+		}
+	}
+
+	return parsed, nil
+}
+
+// ExtractVERPToken returns the routing token of a t+{token}@ reply address, or an empty string
+func ExtractVERPToken(address string) string {
+	if parsed, err := gomail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+
+	matches := verpTokenPattern.FindStringSubmatch(strings.ToLower(address))
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
+
+func newAttachment(header *gomail.AttachmentHeader, content []byte, inline bool) Attachment {
+	contentType, params, _ := header.ContentType()
+	filename, _ := header.Filename()
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	return Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Content:     content,
+		ContentID:   strings.Trim(header.Get("Content-Id"), "<>"),
+		Inline:      inline,
+	}
+}
+
+func formatMailAddress(addr *gomail.Address) string {
+	if addr.Name != "" {
+		return fmt.Sprintf("%s <%s>", addr.Name, addr.Address)
+	}
+	return addr.Address
+}
+
+func formatMailAddresses(addrs []*gomail.Address) []string {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	result := make([]string, len(addrs))
+	for i, addr := range addrs {
+		result[i] = formatMailAddress(addr)
+	}
+	return result
+}
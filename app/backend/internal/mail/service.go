@@ -22,6 +22,14 @@ type Service struct {
 	imapClient *IMAPClient
 	templates  map[string]*EmailTemplate
 	encryption *crypto.PasswordEncryption
+	routing    RoutingLookup
+}
+
+// RoutingLookup finds tickets from stored VERP tokens and Message-ID roots.
+// It is implemented by repo.EmailRepo.
+type RoutingLookup interface {
+	GetTicketRoutingByToken(ctx context.Context, tenantID uuid.UUID, token string) (*models.TicketMailRouting, error)
+	FindTicketByMessageID(ctx context.Context, tenantID uuid.UUID, messageIDRoot string) (*uuid.UUID, error)
 }
 
 // NewService creates a new email service
@@ -40,6 +48,11 @@ func NewService(logger zerolog.Logger) *Service {
 	}
 }
 
+// SetRoutingLookup enables threading of inbound mail onto existing tickets
+func (s *Service) SetRoutingLookup(routing RoutingLookup) {
+	s.routing = routing
+}
+
 // GetIMAPClient returns the configured IMAP client
 func (s *Service) GetIMAPClient() *IMAPClient {
 	return s.imapClient
@@ -232,20 +245,26 @@ func (s *Service) isAutoReply(msg *ParsedMessage) bool {
 func (s *Service) findTicketByThreading(ctx context.Context, msg *ParsedMessage, tenantID uuid.UUID) (*uuid.UUID, error) {
 	// Check for VERP token in To/CC addresses
 	for _, addr := range append(msg.To, msg.CC...) {
-		if ticketID := s.extractVERPTicket(addr); ticketID != nil {
-			return ticketID, nil
+		ticketID, err := s.findTicketByVERP(ctx, tenantID, addr)
+		if err != nil || ticketID != nil {
+			return ticketID, err
 		}
 	}
 
 	// Check Message-ID threading
 	if msg.InReplyTo != "" {
-		// TODO: Query database for ticket with this message ID root
+		ticketID, err := s.findTicketByMessageID(ctx, tenantID, msg.InReplyTo)
+		if err != nil || ticketID != nil {
+			return ticketID, err
+		}
 	}
 
 	// Check References header
 	for _, ref := range msg.References {
-		// TODO: Query database for ticket with this message ID root
-		_ = ref // Avoid unused variable warning
+		ticketID, err := s.findTicketByMessageID(ctx, tenantID, ref)
+		if err != nil || ticketID != nil {
+			return ticketID, err
+		}
 	}
 
 	// Check X-Ticket-ID header
@@ -258,20 +277,27 @@ func (s *Service) findTicketByThreading(ctx context.Context, msg *ParsedMessage,
 	return nil, nil
 }
 
-// extractVERPTicket extracts ticket ID from VERP address
-func (s *Service) extractVERPTicket(address string) *uuid.UUID {
-	// Parse addresses like t+{token}@reply.domain.com
-	re := regexp.MustCompile(`^t\+([a-zA-Z0-9]+)@`)
-	matches := re.FindStringSubmatch(address)
-	if len(matches) < 2 {
-		return nil
+// findTicketByVERP resolves the ticket of a t+{token}@ reply address
+func (s *Service) findTicketByVERP(ctx context.Context, tenantID uuid.UUID, address string) (*uuid.UUID, error) {
+	token := ExtractVERPToken(address)
+	if token == "" || s.routing == nil {
+		return nil, nil
 	}
 
-	token := matches[1]
-	// TODO: Query database to find ticket by token
-	// For now, return nil
-	_ = token
-	return nil
+	routing, err := s.routing.GetTicketRoutingByToken(ctx, tenantID, token)
+	if err != nil || routing == nil {
+		return nil, err
+	}
+	return &routing.TicketID, nil
+}
+
+// findTicketByMessageID resolves the ticket whose thread was started by a Message-ID
+func (s *Service) findTicketByMessageID(ctx context.Context, tenantID uuid.UUID, messageID string) (*uuid.UUID, error) {
+	messageID = strings.Trim(strings.TrimSpace(messageID), "<>")
+	if messageID == "" || s.routing == nil {
+		return nil, nil
+	}
+	return s.routing.FindTicketByMessageID(ctx, tenantID, messageID)
 }
 
 // routeToProject determines which project to route the email to
@@ -27,6 +27,8 @@ type Attachment struct {
 	ContentType string
 	Content     []byte
 	Reader      io.Reader
+	ContentID   string
+	Inline      bool
 }
 
 // ParsedMessage represents a parsed inbound email
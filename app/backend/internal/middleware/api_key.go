@@ -49,12 +49,20 @@ func ApiKeyAuthMiddleware(apiKeyRepo repo.ApiKeyRepository) gin.HandlerFunc {
 			return
 		}
 
+		// Check if the request comes from an allowed network
+		if !apiKeyAllowsIP(apiKeyRecord.AllowedCIDRs, c.ClientIP()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed from this IP address"})
+			c.Abort()
+			return
+		}
+
 		// Set context values from the API key record
 		c.Set("tenant_id", apiKeyRecord.TenantID.String())
 		c.Set("agent_id", apiKeyRecord.AgentID.String())
 		c.Set("project_id", apiKeyRecord.ProjectID.String())
 		c.Set("api_key_auth", true) // Flag to indicate this is API key auth
 		c.Set("api_key_id", apiKeyRecord.ID.String())
+		c.Set("api_key_scopes", []string(apiKeyRecord.Scopes))
 
 		// Update last used timestamp asynchronously to avoid blocking the request
 		go func() {
@@ -126,12 +134,20 @@ func handleApiKeyAuth(c *gin.Context, apiKeyRepo repo.ApiKeyRepository, apiKey s
 		return
 	}
 
+	// Check if the request comes from an allowed network
+	if !apiKeyAllowsIP(apiKeyRecord.AllowedCIDRs, c.ClientIP()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed from this IP address"})
+		c.Abort()
+		return
+	}
+
 	// Set context values from the API key record
 	c.Set("tenant_id", apiKeyRecord.TenantID.String())
 	c.Set("agent_id", apiKeyRecord.AgentID.String())
 	c.Set("project_id", apiKeyRecord.ProjectID.String())
 	c.Set("api_key_auth", true) // Flag to indicate this is API key auth
 	c.Set("api_key_id", apiKeyRecord.ID.String())
+	c.Set("api_key_scopes", []string(apiKeyRecord.Scopes))
 
 	// Update last used timestamp asynchronously to avoid blocking the request
 	go func() {
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PermissionChecker resolves permissions of agents and of built-in or tenant defined roles
+type PermissionChecker interface {
+	CheckPermission(ctx context.Context, agentID, tenantID, projectID uuid.UUID, permission rbac.Permission) (bool, error)
+	TenantRoleHasPermission(ctx context.Context, tenantID uuid.UUID, role models.RoleType, permission rbac.Permission) (bool, error)
+}
+
+// RequirePermission guards a route group with a read and a write permission.
+// Safe methods (GET, HEAD, OPTIONS) need the read permission, everything else the write permission.
+// JWT requests are checked against the role bindings in the token. API key requests need the
+// permission in the key's scopes and the agent that owns the key must still hold it.
+func RequirePermission(checker PermissionChecker, read, write rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permission := write
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			permission = read
+		}
+
+		if isApiKeyAuth, exists := c.Get("api_key_auth"); exists && isApiKeyAuth.(bool) {
+			checkApiKeyPermission(c, checker, permission)
+			return
+		}
+
+		claims := GetClaims(c)
+		if claims == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No valid claims found"})
+			c.Abort()
+			return
+		}
+
+		tenantID, _ := uuid.Parse(claims.TenantID)
+		allowed, err := claimsHavePermission(c.Request.Context(), checker, tenantID, claims.IsTenantAdmin, claims.RoleBindings, c.Param("project_id"), permission)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: missing permission " + string(permission)})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// checkApiKeyPermission enforces the API key scopes and the role bindings of the key owner
+func checkApiKeyPermission(c *gin.Context, checker PermissionChecker, permission rbac.Permission) {
+	if !HasApiKeyScope(c, permission) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing scope " + string(permission)})
+		c.Abort()
+		return
+	}
+
+	// A key is bound to one project and cannot be used on another project's routes
+	keyProjectID := c.GetString("project_id")
+	if routeProjectID := c.Param("project_id"); routeProjectID != "" && routeProjectID != keyProjectID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not valid for this project"})
+		c.Abort()
+		return
+	}
+
+	if checker != nil {
+		tenantID, _ := uuid.Parse(c.GetString("tenant_id"))
+		agentID, _ := uuid.Parse(c.GetString("agent_id"))
+		projectID, _ := uuid.Parse(keyProjectID)
+
+		allowed, err := checker.CheckPermission(c.Request.Context(), agentID, tenantID, projectID, permission)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: API key owner is missing permission " + string(permission)})
+			c.Abort()
+			return
+		}
+	}
+
+	c.Next()
+}
+
+// claimsHavePermission checks the role bindings carried in a JWT.
+// Tenant-level bindings are stored under the empty project key. When the route has no
+// project_id, a binding on any project is enough.
+func claimsHavePermission(ctx context.Context, checker PermissionChecker, tenantID uuid.UUID, isTenantAdmin bool, roleBindings map[string][]string, projectID string, permission rbac.Permission) (bool, error) {
+	if isTenantAdmin && rbac.RoleHasPermission(models.RoleTenantAdmin, permission) {
+		return true, nil
+	}
+
+	for bindingProject, roles := range roleBindings {
+		if bindingProject != "" && projectID != "" && bindingProject != projectID {
+			continue
+		}
+		for _, role := range roles {
+			if checker == nil {
+				if rbac.RoleHasPermission(models.RoleType(role), permission) {
+					return true, nil
+				}
+				continue
+			}
+			granted, err := checker.TenantRoleHasPermission(ctx, tenantID, models.RoleType(role), permission)
+			if err != nil {
+				return false, err
+			}
+			if granted {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// HasApiKeyScope reports whether the API key used for the request was granted the permission
+func HasApiKeyScope(c *gin.Context, permission rbac.Permission) bool {
+	scopes, exists := c.Get("api_key_scopes")
+	if !exists {
+		return false
+	}
+	values, ok := scopes.([]string)
+	if !ok {
+		return false
+	}
+	for _, scope := range values {
+		if scope == string(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyAllowsIP reports whether the client IP falls inside one of the allowed networks.
+// An empty allowlist allows any address.
+func apiKeyAllowsIP(allowedCIDRs []string, clientIP string) bool {
+	if len(allowedCIDRs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(clientIP))
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range allowedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bareuptime/tms/internal/auth"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePermissionChecker struct {
+	agentAllowed bool
+}
+
+func (f *fakePermissionChecker) CheckPermission(ctx context.Context, agentID, tenantID, projectID uuid.UUID, permission rbac.Permission) (bool, error) {
+	return f.agentAllowed, nil
+}
+
+func (f *fakePermissionChecker) TenantRoleHasPermission(ctx context.Context, tenantID uuid.UUID, role models.RoleType, permission rbac.Permission) (bool, error) {
+	return rbac.RoleHasPermission(role, permission), nil
+}
+
+// servePermission runs one request through RequirePermission(ticket:read, ticket:write) after the
+// given middleware has set up the authentication context
+func servePermission(t *testing.T, checker PermissionChecker, method, path string, authenticate gin.HandlerFunc) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(authenticate)
+	guard := RequirePermission(checker, rbac.PermTicketRead, rbac.PermTicketWrite)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.Handle(method, "/projects/:project_id/tickets", guard, ok)
+	router.Handle(method, "/tickets", guard, ok)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder.Code
+}
+
+func TestRequirePermissionApiKey(t *testing.T) {
+	t.Parallel()
+
+	keyProject := uuid.New().String()
+	otherProject := uuid.New().String()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		scopes       []string
+		agentAllowed bool
+		want         int
+	}{
+		{"read scope allows GET", http.MethodGet, "/projects/" + keyProject + "/tickets", []string{"ticket:read"}, true, http.StatusOK},
+		{"read scope does not allow POST", http.MethodPost, "/projects/" + keyProject + "/tickets", []string{"ticket:read"}, true, http.StatusForbidden},
+		{"write scope allows POST", http.MethodPost, "/projects/" + keyProject + "/tickets", []string{"ticket:read", "ticket:write"}, true, http.StatusOK},
+		{"no scopes", http.MethodGet, "/projects/" + keyProject + "/tickets", nil, true, http.StatusForbidden},
+		{"other project", http.MethodGet, "/projects/" + otherProject + "/tickets", []string{"ticket:read"}, true, http.StatusForbidden},
+		{"route without project", http.MethodGet, "/tickets", []string{"ticket:read"}, true, http.StatusOK},
+		{"owner lost the permission", http.MethodGet, "/projects/" + keyProject + "/tickets", []string{"ticket:read"}, false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			checker := &fakePermissionChecker{agentAllowed: tt.agentAllowed}
+			code := servePermission(t, checker, tt.method, tt.path, func(c *gin.Context) {
+				c.Set("api_key_auth", true)
+				c.Set("api_key_scopes", tt.scopes)
+				c.Set("tenant_id", uuid.New().String())
+				c.Set("agent_id", uuid.New().String())
+				c.Set("project_id", keyProject)
+			})
+			require.Equal(t, tt.want, code)
+		})
+	}
+}
+
+func TestRequirePermissionJWT(t *testing.T) {
+	t.Parallel()
+
+	project := uuid.New().String()
+	otherProject := uuid.New().String()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		claims *auth.Claims
+		want   int
+	}{
+		{"no claims", http.MethodGet, "/projects/" + project + "/tickets", nil, http.StatusUnauthorized},
+		{"tenant admin", http.MethodPost, "/projects/" + project + "/tickets", &auth.Claims{IsTenantAdmin: true}, http.StatusOK},
+		{"read-only binding on the project allows GET", http.MethodGet, "/projects/" + project + "/tickets",
+			&auth.Claims{RoleBindings: map[string][]string{project: {"read_only"}}}, http.StatusOK},
+		{"read-only binding on the project denies POST", http.MethodPost, "/projects/" + project + "/tickets",
+			&auth.Claims{RoleBindings: map[string][]string{project: {"read_only"}}}, http.StatusForbidden},
+		{"binding on another project", http.MethodPost, "/projects/" + project + "/tickets",
+			&auth.Claims{RoleBindings: map[string][]string{otherProject: {"agent"}}}, http.StatusForbidden},
+		{"tenant-level binding", http.MethodPost, "/projects/" + project + "/tickets",
+			&auth.Claims{RoleBindings: map[string][]string{"": {"agent"}}}, http.StatusOK},
+		{"any project binding on a route without project", http.MethodPost, "/tickets",
+			&auth.Claims{RoleBindings: map[string][]string{otherProject: {"agent"}}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			code := servePermission(t, &fakePermissionChecker{}, tt.method, tt.path, func(c *gin.Context) {
+				if tt.claims != nil {
+					tt.claims.TenantID = uuid.New().String()
+					c.Set("claims", tt.claims)
+				}
+			})
+			require.Equal(t, tt.want, code)
+		})
+	}
+}
+
+func TestApiKeyAllowsIP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		cidrs    []string
+		clientIP string
+		want     bool
+	}{
+		{"empty allowlist", nil, "198.51.100.7", true},
+		{"inside network", []string{"203.0.113.0/24"}, "203.0.113.42", true},
+		{"outside network", []string{"203.0.113.0/24"}, "198.51.100.7", false},
+		{"second network", []string{"203.0.113.0/24", "198.51.100.0/24"}, "198.51.100.7", true},
+		{"single host", []string{"198.51.100.7/32"}, "198.51.100.8", false},
+		{"ipv6", []string{"2001:db8::/32"}, "2001:db8::1", true},
+		{"invalid entries are ignored", []string{"not-a-cidr", "203.0.113.0/24"}, "203.0.113.1", true},
+		{"unparsable client address", []string{"203.0.113.0/24"}, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, apiKeyAllowsIP(tt.cidrs, tt.clientIP), tt.name)
+	}
+}
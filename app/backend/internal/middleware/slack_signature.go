@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// maxSlackRequestBodySize caps Slack payloads; events and interaction payloads are well below this
+const maxSlackRequestBodySize = 1 << 20
+
+// SlackSignatureMiddleware verifies that requests were signed by Slack with the app signing secret.
+// It is meant for every Slack-facing endpoint (events, interactivity, slash commands) and
+// restores the request body so handlers can read it again.
+func SlackSignatureMiddleware(signingSecret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSlackRequestBodySize))
+		if err != nil {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = service.VerifySlackRequest(
+			signingSecret,
+			c.GetHeader("X-Slack-Request-Timestamp"),
+			c.GetHeader("X-Slack-Signature"),
+			body,
+			time.Now(),
+		)
+		if err != nil {
+			if errors.Is(err, service.ErrSlackSigningDisabled) {
+				logger.ErrorfCtx(c.Request.Context(), err, "Rejecting Slack request: %v", err)
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			} else {
+				logger.WarnfCtx(c.Request.Context(), "Rejecting Slack request from %s: %v", c.ClientIP(), err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
+)
+
+// AIAnswerCacheSettings is how a project reuses AI answers for questions that mean the same as earlier
+// ones. They are kept with the project's settings under "ai_answer_cache_settings"; a project without them
+// gets DefaultAIAnswerCacheSettings.
+type AIAnswerCacheSettings struct {
+	// Answer repeated questions from the cache instead of the AI provider
+	Enabled bool `json:"enabled"`
+	// How similar a question has to be to a cached one to get its answer, from 0.8 to 1
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	// How long answers are reused, in hours, at most 30 days
+	TTLHours int `json:"ttl_hours"`
+}
+
+// DefaultAIAnswerCacheSettings reuse answers for a week for questions that are nearly the same
+func DefaultAIAnswerCacheSettings() *AIAnswerCacheSettings {
+	return &AIAnswerCacheSettings{
+		Enabled:             true,
+		SimilarityThreshold: 0.95,
+		TTLHours:            168,
+	}
+}
+
+// AIAnswerCacheSource is a knowledge source a cached answer was based on. ContentHash is the hash of its
+// content when the answer was given; the answer is not reused once it changes.
+type AIAnswerCacheSource struct {
+	ID          uuid.UUID `json:"id"`
+	Type        string    `json:"type"` // "document" (a chunk) or "webpage"
+	Source      string    `json:"source"`
+	ContentHash string    `json:"content_hash"`
+}
+
+// AIAnswerCacheSources are the sources of a cached answer, stored as JSONB
+type AIAnswerCacheSources []AIAnswerCacheSource
+
+// Scan implements the sql.Scanner interface
+func (s *AIAnswerCacheSources) Scan(value interface{}) error {
+	if value == nil {
+		*s = AIAnswerCacheSources{}
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, s)
+}
+
+// Value implements the driver.Valuer interface
+func (s AIAnswerCacheSources) Value() (driver.Value, error) {
+	if s == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(s)
+}
+
+// AIAnswerCacheEntry is an AI answer kept for reuse. Question is the visitor's question without its
+// personal data; ProfileHash identifies the AI profile configuration that answered it.
+type AIAnswerCacheEntry struct {
+	ID               uuid.UUID            `json:"id" db:"id"`
+	TenantID         uuid.UUID            `json:"tenant_id" db:"tenant_id"`
+	ProjectID        uuid.UUID            `json:"project_id" db:"project_id"`
+	ProfileHash      string               `json:"profile_hash" db:"profile_hash"`
+	Question         string               `json:"question" db:"question"`
+	Embedding        pgvector.Vector      `json:"-" db:"embedding"`
+	Answer           string               `json:"answer" db:"answer"`
+	Sources          AIAnswerCacheSources `json:"sources" db:"sources"`
+	PromptTokens     int                  `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int                  `json:"completion_tokens" db:"completion_tokens"`
+	HitCount         int                  `json:"hit_count" db:"hit_count"`
+	LastHitAt        *time.Time           `json:"last_hit_at,omitempty" db:"last_hit_at"`
+	RejectedAt       *time.Time           `json:"rejected_at,omitempty" db:"rejected_at"`
+	CreatedAt        time.Time            `json:"created_at" db:"created_at"`
+	ExpiresAt        time.Time            `json:"expires_at" db:"expires_at"`
+
+	// How similar the question looked up was, set by lookups
+	Similarity float64 `json:"similarity,omitempty" db:"similarity"`
+}
+
+// AIAnswerCacheStats is how the answer cache of a project did over its last days. HitRate is nil when
+// no question was looked up.
+type AIAnswerCacheStats struct {
+	Days            int      `json:"days"`
+	Hits            int64    `json:"hits" db:"hits"`
+	Misses          int64    `json:"misses" db:"misses"`
+	HitRate         *float64 `json:"hit_rate"`
+	TokensSaved     int64    `json:"tokens_saved" db:"tokens_saved"`
+	Entries         int64    `json:"entries" db:"entries"`
+	RejectedEntries int64    `json:"rejected_entries" db:"rejected_entries"`
+}
+
+// AIAnswerCachePurgeResult says how many cached answers a purge removed
+type AIAnswerCachePurgeResult struct {
+	Purged int64 `json:"purged"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "github.com/google/uuid"
+
+// Agent-assist actions, requested over the agent WebSocket as the action of an ai_assist message
+const (
+	AIAssistActionSuggestReplies = "suggest_replies"
+	AIAssistActionSummarize      = "summarize"
+)
+
+// AIReplySuggestionsRequest asks for replies an agent could send next in a chat or ticket
+type AIReplySuggestionsRequest struct {
+	Count       int    `json:"count,omitempty" binding:"omitempty,min=1,max=5" example:"3"`
+	Instruction string `json:"instruction,omitempty" binding:"omitempty,max=500" example:"Offer a refund"` // what the agent wants the replies to do
+}
+
+// AISimilarTicket is a resolved ticket like the conversation an agent is working on
+type AISimilarTicket struct {
+	ID         uuid.UUID `json:"id"`
+	Number     int       `json:"number"`
+	Subject    string    `json:"subject"`
+	Resolution string    `json:"resolution,omitempty"` // the last public agent reply
+}
+
+// AIReplySuggestions are replies drafted for an agent, with the knowledge and tickets they were based on
+type AIReplySuggestions struct {
+	Suggestions      []string                `json:"suggestions"`
+	Sources          []KnowledgeSearchResult `json:"sources"`
+	SimilarTickets   []AISimilarTicket       `json:"similar_tickets"`
+	PromptTokens     int64                   `json:"prompt_tokens"`
+	CompletionTokens int64                   `json:"completion_tokens"`
+}
+
+// AIConversationSummary summarizes a chat transcript or ticket thread for an agent
+type AIConversationSummary struct {
+	Summary          string `json:"summary"`
+	MessageCount     int    `json:"message_count"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Where an evaluation case came from
+const (
+	AIEvalOriginManual     = "manual"
+	AIEvalOriginCSV        = "csv"
+	AIEvalOriginThumbsDown = "thumbs_down"
+)
+
+// Evaluation run statuses
+const (
+	AIEvalRunRunning   = "running"
+	AIEvalRunCompleted = "completed"
+	AIEvalRunFailed    = "failed"
+)
+
+// AIEvalCase is a test question of a project's evaluation suite. Expected sources are the URLs or file
+// names of knowledge that should be retrieved and cited for it; cases without an expected answer or
+// sources are not scored on them.
+type AIEvalCase struct {
+	ID              uuid.UUID      `json:"id" db:"id"`
+	TenantID        uuid.UUID      `json:"tenant_id" db:"tenant_id"`
+	ProjectID       uuid.UUID      `json:"project_id" db:"project_id"`
+	Question        string         `json:"question" db:"question"`
+	ExpectedAnswer  string         `json:"expected_answer" db:"expected_answer"`
+	ExpectedSources pq.StringArray `json:"expected_sources" db:"expected_sources"`
+	Origin          string         `json:"origin" db:"origin"` // manual, csv or thumbs_down
+	OriginSessionID *uuid.UUID     `json:"origin_session_id,omitempty" db:"origin_session_id"`
+	OriginMessageID *uuid.UUID     `json:"origin_message_id,omitempty" db:"origin_message_id"`
+	RejectedAnswer  *string        `json:"rejected_answer,omitempty" db:"rejected_answer"` // the reply the visitor gave a thumbs down
+	CreatedAt       time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// AIEvalCaseRequest creates or updates an evaluation case
+type AIEvalCaseRequest struct {
+	Question        string   `json:"question" binding:"required,max=4000"`
+	ExpectedAnswer  string   `json:"expected_answer" binding:"max=8000"`
+	ExpectedSources []string `json:"expected_sources"`
+}
+
+// AIEvalRunRequest starts a run of an evaluation suite, labelled with the change it tests
+type AIEvalRunRequest struct {
+	Label string `json:"label" binding:"max=255"`
+}
+
+// AIEvalImportResult counts the cases an import added and the rows or chats it left out
+type AIEvalImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// AIEvalRun is a run of a project's evaluation suite. Retrieval hit rate is the share of cases with
+// expected sources where one of them was retrieved, answer similarity the mean word overlap (F1) with
+// the expected answers, and citation accuracy the share of cases with expected sources whose answer
+// cited only expected sources.
+type AIEvalRun struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	TenantID         uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	ProjectID        uuid.UUID  `json:"project_id" db:"project_id"`
+	Label            string     `json:"label" db:"label"`
+	Status           string     `json:"status" db:"status"`
+	Provider         string     `json:"provider" db:"provider"`
+	Model            string     `json:"model" db:"model"`
+	CaseCount        int        `json:"case_count" db:"case_count"`
+	FailedCount      int        `json:"failed_count" db:"failed_count"`
+	RetrievalHitRate *float64   `json:"retrieval_hit_rate" db:"retrieval_hit_rate"`
+	AnswerSimilarity *float64   `json:"answer_similarity" db:"answer_similarity"`
+	CitationAccuracy *float64   `json:"citation_accuracy" db:"citation_accuracy"`
+	PromptTokens     int64      `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64      `json:"completion_tokens" db:"completion_tokens"`
+	Error            *string    `json:"error,omitempty" db:"error"`
+	StartedBy        *uuid.UUID `json:"started_by,omitempty" db:"started_by"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt      *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// AIEvalResult is what the assistant answered to a case in a run and how it scored
+type AIEvalResult struct {
+	ID               uuid.UUID      `json:"id" db:"id"`
+	RunID            uuid.UUID      `json:"run_id" db:"run_id"`
+	CaseID           uuid.UUID      `json:"case_id" db:"case_id"`
+	Question         string         `json:"question" db:"question"`
+	ExpectedAnswer   string         `json:"expected_answer" db:"expected_answer"`
+	ExpectedSources  pq.StringArray `json:"expected_sources" db:"expected_sources"`
+	Answer           string         `json:"answer" db:"answer"`
+	RetrievedSources pq.StringArray `json:"retrieved_sources" db:"retrieved_sources"`
+	CitedSources     pq.StringArray `json:"cited_sources" db:"cited_sources"`
+	RetrievalHit     *bool          `json:"retrieval_hit" db:"retrieval_hit"`
+	AnswerSimilarity *float64       `json:"answer_similarity" db:"answer_similarity"`
+	CitationCorrect  *bool          `json:"citation_correct" db:"citation_correct"`
+	Handoff          bool           `json:"handoff" db:"handoff"`
+	Blocked          bool           `json:"blocked" db:"blocked"`
+	Error            *string        `json:"error,omitempty" db:"error"`
+	LatencyMS        int64          `json:"latency_ms" db:"latency_ms"`
+	CreatedAt        time.Time      `json:"created_at" db:"created_at"`
+}
+
+// AIEvalRunDetail is a run with the results of its cases
+type AIEvalRunDetail struct {
+	AIEvalRun
+	Results []*AIEvalResult `json:"results"`
+}
+
+// AIEvalScoreDelta is how a score changed from a base run to another; nil when either run has no score
+type AIEvalScoreDelta struct {
+	RetrievalHitRate *float64 `json:"retrieval_hit_rate"`
+	AnswerSimilarity *float64 `json:"answer_similarity"`
+	CitationAccuracy *float64 `json:"citation_accuracy"`
+}
+
+// AIEvalCaseDiff compares the results of a case in two runs
+type AIEvalCaseDiff struct {
+	CaseID   uuid.UUID     `json:"case_id"`
+	Question string        `json:"question"`
+	Base     *AIEvalResult `json:"base,omitempty"` // nil when the case was not in the base run
+	Run      *AIEvalResult `json:"run,omitempty"`  // nil when the case was not in the run
+	// improved, regressed, changed (the answer differs, scores do not), unchanged, added or removed
+	Change string `json:"change"`
+}
+
+// AIEvalComparison diffs a run against a base run
+type AIEvalComparison struct {
+	Base  AIEvalRun        `json:"base"`
+	Run   AIEvalRun        `json:"run"`
+	Delta AIEvalScoreDelta `json:"delta"`
+	Cases []AIEvalCaseDiff `json:"cases"`
+}
+
+// AIThumbsDownReply is an AI reply a visitor gave a thumbs down, with the question it answered
+type AIThumbsDownReply struct {
+	FeedbackMessageID uuid.UUID `db:"feedback_message_id"`
+	SessionID         uuid.UUID `db:"session_id"`
+	Question          string    `db:"question"`
+	Answer            string    `db:"answer"`
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of personal data the AI guardrails mask
+const (
+	PIITypeEmail      = "email"
+	PIITypePhone      = "phone"
+	PIITypeCard       = "card"
+	PIITypeIBAN       = "iban"
+	PIITypeNationalID = "national_id"
+)
+
+// AllPIITypes are the kinds of personal data the guardrails know, in the order they are looked for
+var AllPIITypes = []string{PIITypeEmail, PIITypeIBAN, PIITypeCard, PIITypeNationalID, PIITypePhone}
+
+// Where in the AI pipeline a guardrail intervened
+const (
+	AIGuardrailStageInput     = "input"     // what is sent to the model
+	AIGuardrailStageKnowledge = "knowledge" // knowledge chunks put into the system prompt
+	AIGuardrailStageOutput    = "output"    // the model's reply
+	AIGuardrailStageStorage   = "storage"   // customer messages before they are stored
+)
+
+// What a guardrail found
+const (
+	AIGuardrailKindPII             = "pii"
+	AIGuardrailKindPromptInjection = "prompt_injection"
+	AIGuardrailKindBlocklist       = "blocklist"
+)
+
+// What a guardrail did about it
+const (
+	AIGuardrailActionMasked  = "masked"
+	AIGuardrailActionFlagged = "flagged"
+	AIGuardrailActionDropped = "dropped"
+	AIGuardrailActionBlocked = "blocked"
+)
+
+// AIGuardrailSettings is how a project guards what its AI sees and says. They are kept with the project's
+// settings under "ai_guardrail_settings"; a project without them gets DefaultAIGuardrailSettings.
+type AIGuardrailSettings struct {
+	// Mask personal data in everything sent to AI providers
+	RedactPII bool `json:"redact_pii"`
+	// Kinds of personal data to mask, all of them when empty
+	PIITypes []string `json:"pii_types"`
+	// Also mask personal data in chat and ticket messages of customers before they are stored
+	RedactStoredMessages bool `json:"redact_stored_messages"`
+	// Look for attempts to override the assistant's instructions in visitor messages and knowledge; knowledge
+	// chunks with them are left out of the prompt
+	DetectPromptInjection bool `json:"detect_prompt_injection"`
+	// Hand visitors whose message looks like a prompt injection to a human instead of answering
+	BlockPromptInjection bool `json:"block_prompt_injection"`
+	// Words and phrases AI replies must not contain, in addition to the forbidden phrases of AI profiles
+	OutputBlocklist []string `json:"output_blocklist"`
+}
+
+// DefaultAIGuardrailSettings masks personal data sent to AI providers and leaves prompt injections in
+// knowledge out, without changing what is stored
+func DefaultAIGuardrailSettings() *AIGuardrailSettings {
+	return &AIGuardrailSettings{
+		RedactPII:             true,
+		PIITypes:              []string{},
+		DetectPromptInjection: true,
+		OutputBlocklist:       []string{},
+	}
+}
+
+// AIGuardrailEvent records one intervention of the AI guardrails. Details say what was found, such as
+// the kinds and counts of personal data or the names of injection patterns, never the text itself.
+type AIGuardrailEvent struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	TenantID  uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	ProjectID uuid.UUID  `json:"project_id" db:"project_id"`
+	SessionID *uuid.UUID `json:"session_id,omitempty" db:"session_id"`
+	TicketID  *uuid.UUID `json:"ticket_id,omitempty" db:"ticket_id"`
+	Stage     string     `json:"stage" db:"stage"`
+	Kind      string     `json:"kind" db:"kind"`
+	Action    string     `json:"action" db:"action"`
+	Details   JSONMap    `json:"details" db:"details"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// AIGuardrailEventFilter narrows a listing of guardrail events
+type AIGuardrailEventFilter struct {
+	Stage     string
+	Kind      string
+	SessionID *uuid.UUID
+	Since     *time.Time
+	Limit     int
+}
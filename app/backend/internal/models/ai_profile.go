@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DefaultAIPersonaName is the author name of AI messages when no profile names the assistant
+const DefaultAIPersonaName = "AI Assistant"
+
+// AIProfile configures the AI assistant of a project, or of one of its widgets when WidgetID is set.
+// Unset fields inherit from the project profile and then from the global AI configuration.
+type AIProfile struct {
+	ID               uuid.UUID      `json:"id" db:"id"`
+	TenantID         uuid.UUID      `json:"tenant_id" db:"tenant_id"`
+	ProjectID        uuid.UUID      `json:"project_id" db:"project_id"`
+	WidgetID         *uuid.UUID     `json:"widget_id,omitempty" db:"widget_id"`
+	Enabled          bool           `json:"enabled" db:"enabled"`
+	PersonaName      *string        `json:"persona_name,omitempty" db:"persona_name"`
+	SystemPrompt     *string        `json:"system_prompt,omitempty" db:"system_prompt"`
+	AllowedTopics    pq.StringArray `json:"allowed_topics" db:"allowed_topics"`
+	ForbiddenPhrases pq.StringArray `json:"forbidden_phrases" db:"forbidden_phrases"`
+	HandoffKeywords  pq.StringArray `json:"handoff_keywords" db:"handoff_keywords"`
+	Provider         *string        `json:"provider,omitempty" db:"provider"`
+	Model            *string        `json:"model,omitempty" db:"model"`
+	Temperature      *float64       `json:"temperature,omitempty" db:"temperature"`
+	MaxTokens        *int           `json:"max_tokens,omitempty" db:"max_tokens"`
+	CreatedAt        time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// AIProfileRequest creates an AI profile or updates the fields it sets. An empty string or list clears a
+// field so it is inherited again.
+type AIProfileRequest struct {
+	WidgetID         *uuid.UUID `json:"widget_id,omitempty"`
+	Enabled          *bool      `json:"enabled,omitempty"`
+	PersonaName      *string    `json:"persona_name,omitempty" example:"Ava"`
+	SystemPrompt     *string    `json:"system_prompt,omitempty"`
+	AllowedTopics    []string   `json:"allowed_topics,omitempty" example:"billing,shipping"`
+	ForbiddenPhrases []string   `json:"forbidden_phrases,omitempty"`
+	HandoffKeywords  []string   `json:"handoff_keywords,omitempty" example:"refund,cancel my order"`
+	Provider         *string    `json:"provider,omitempty" example:"openai"`
+	Model            *string    `json:"model,omitempty" example:"gpt-4o-mini"`
+	Temperature      *float64   `json:"temperature,omitempty" example:"0.3"`
+	MaxTokens        *int       `json:"max_tokens,omitempty" example:"600"`
+}
+
+// ResolvedAIProfile is the AI configuration in effect for a project or widget
+type ResolvedAIProfile struct {
+	ProfileIDs       []uuid.UUID `json:"profile_ids"` // profiles applied, project first
+	PersonaName      string      `json:"persona_name"`
+	SystemPrompt     string      `json:"system_prompt"`
+	AllowedTopics    []string    `json:"allowed_topics"`
+	ForbiddenPhrases []string    `json:"forbidden_phrases"`
+	HandoffKeywords  []string    `json:"handoff_keywords"`
+	Provider         string      `json:"provider"`
+	Model            string      `json:"model"`
+	Temperature      float64     `json:"temperature"`
+	MaxTokens        int         `json:"max_tokens"`
+}
+
+// AuthorName is the name AI messages are sent under
+func (p *ResolvedAIProfile) AuthorName() string {
+	if p.PersonaName != "" {
+		return p.PersonaName
+	}
+	return DefaultAIPersonaName
+}
+
+// AIProfilePreviewRequest asks the assistant a test question as a visitor of the project would. Draft
+// settings are applied over the saved profile without storing them.
+type AIProfilePreviewRequest struct {
+	Message  string            `json:"message" binding:"required" example:"Do you ship to Canada?"`
+	WidgetID *uuid.UUID        `json:"widget_id,omitempty"`
+	Draft    *AIProfileRequest `json:"draft,omitempty"`
+}
+
+// AIProfilePreviewResponse is what the assistant would have done with a preview question
+type AIProfilePreviewResponse struct {
+	Profile          ResolvedAIProfile       `json:"profile"`
+	Handoff          bool                    `json:"handoff"` // the question would be handed to a human agent
+	Reply            string                  `json:"reply,omitempty"`
+	Blocked          bool                    `json:"blocked"` // the reply used a forbidden phrase and would not be sent
+	Sources          []KnowledgeSearchResult `json:"sources"`
+	PromptTokens     int64                   `json:"prompt_tokens"`
+	CompletionTokens int64                   `json:"completion_tokens"`
+}
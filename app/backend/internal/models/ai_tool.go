@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// AI tool sources
+const (
+	AIToolSourceBuiltin = "builtin"
+	AIToolSourceHTTP    = "http"
+)
+
+// AITool is an HTTP endpoint of the tenant the AI assistant of a project may call, such as an order-status
+// API. The model fills in the arguments described by Parameters, a JSON schema.
+type AITool struct {
+	ID                      uuid.UUID      `json:"id" db:"id"`
+	TenantID                uuid.UUID      `json:"tenant_id" db:"tenant_id"`
+	ProjectID               uuid.UUID      `json:"project_id" db:"project_id"`
+	Name                    string         `json:"name" db:"name"`
+	Description             string         `json:"description" db:"description"`
+	Parameters              JSONMap        `json:"parameters" db:"parameters"`
+	Method                  string         `json:"method" db:"method"`
+	URL                     string         `json:"url" db:"url"`
+	HeadersEncrypted        *string        `json:"-" db:"headers_encrypted"`
+	HeaderNames             pq.StringArray `json:"header_names" db:"header_names"` // names of the stored auth headers; values are never returned
+	TimeoutMs               int            `json:"timeout_ms" db:"timeout_ms"`
+	MaxResponseBytes        int            `json:"max_response_bytes" db:"max_response_bytes"`
+	RequiresVerifiedVisitor bool           `json:"requires_verified_visitor" db:"requires_verified_visitor"`
+	Enabled                 bool           `json:"enabled" db:"enabled"`
+	CreatedAt               time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt               time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// AIToolRequest creates an AI tool or updates the fields it sets. Headers replace the stored auth
+// headers; an empty object removes them.
+type AIToolRequest struct {
+	Name                    *string                `json:"name,omitempty" example:"order_status"`
+	Description             *string                `json:"description,omitempty" example:"Look up the status of one of the visitor's orders"`
+	Parameters              map[string]interface{} `json:"parameters,omitempty"`
+	Method                  *string                `json:"method,omitempty" example:"GET"`
+	URL                     *string                `json:"url,omitempty" example:"https://api.example.com/orders/status"`
+	Headers                 map[string]string      `json:"headers,omitempty"`
+	TimeoutMs               *int                   `json:"timeout_ms,omitempty" example:"5000"`
+	MaxResponseBytes        *int                   `json:"max_response_bytes,omitempty" example:"16384"`
+	RequiresVerifiedVisitor *bool                  `json:"requires_verified_visitor,omitempty"`
+	Enabled                 *bool                  `json:"enabled,omitempty"`
+}
+
+// AIToolTestRequest calls a tool with the given arguments, as the assistant would for an anonymous visitor
+type AIToolTestRequest struct {
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+// AIToolInvocation records a tool call of the AI assistant in the metadata of its reply
+type AIToolInvocation struct {
+	Tool       string          `json:"tool"`
+	ToolID     *uuid.UUID      `json:"tool_id,omitempty"`
+	Source     string          `json:"source"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Status     string          `json:"status"` // ok or error
+	Error      string          `json:"error,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+}
+
+// AIToolTestResponse is the outcome of a tool test
+type AIToolTestResponse struct {
+	Invocation AIToolInvocation `json:"invocation"`
+	Result     string           `json:"result"` // what the assistant would have been given
+}
@@ -40,6 +40,7 @@ const (
 	TransactionTypeRefund     = "refund"
 	TransactionTypeBonus      = "bonus"
 	TransactionTypeAdjustment = "adjustment"
+	TransactionTypePlanGrant  = "plan_grant"
 )
 
 // Payment gateway constants
@@ -208,7 +208,7 @@ type EmailInbox struct {
 	ProcessingError     *string        `json:"processing_error,omitempty" db:"processing_error"`
 	TicketID            *uuid.UUID     `json:"ticket_id,omitempty" db:"ticket_id"`
 	IsConvertedToTicket bool           `json:"is_converted_to_ticket" db:"is_converted_to_ticket"`
-	ConnectorID         uuid.UUID      `json:"connector_id" db:"connector_id"`
+	ConnectorID         *uuid.UUID     `json:"connector_id,omitempty" db:"connector_id"`
 	Headers             JSONMap        `json:"headers,omitempty" db:"headers"`
 	RawEmail            []byte         `json:"raw_email,omitempty" db:"raw_email"`
 	CreatedAt           time.Time      `json:"created_at" db:"created_at"`
@@ -349,6 +349,9 @@ type PublicChatClaims struct {
 	Exp          int64     `json:"exp"`
 	Iat          int64     `json:"iat"`
 	Timestamp    int64     `json:"timestamp"`
+
+	// IdentityToken is passed through from the host page; it is verified separately
+	IdentityToken *string `json:"identity_token,omitempty"`
 }
 
 // GetExpirationTime implements jwt.Claims
@@ -536,6 +539,19 @@ type ChatWidget struct {
 	// AI and advanced features
 	UseAI bool `db:"use_ai" json:"use_ai"`
 
+	// Inactivity handling (0 minutes disables the step)
+	IdleWarningMinutes     int     `db:"idle_warning_minutes" json:"idle_warning_minutes"`
+	IdleTimeoutMinutes     int     `db:"idle_timeout_minutes" json:"idle_timeout_minutes"`
+	SendTranscript         bool    `db:"send_transcript" json:"send_transcript"`
+	TranscriptArchiveEmail *string `db:"transcript_archive_email" json:"transcript_archive_email,omitempty"`
+
+	// Unanswered chats become tickets after this many minutes (0 disables)
+	UnansweredTicketMinutes int `db:"unanswered_ticket_minutes" json:"unanswered_ticket_minutes"`
+
+	// Identity verification; the secret is only returned when it is generated
+	IdentityVerificationEnabled bool    `db:"identity_verification_enabled" json:"identity_verification_enabled"`
+	IdentitySecret              *string `db:"identity_secret" json:"-"`
+
 	// Business hours and embed settings
 	BusinessHours JSONMap `db:"business_hours" json:"business_hours"`
 	EmbedCode     *string `db:"embed_code" json:"embed_code,omitempty"`
@@ -588,6 +604,17 @@ type ChatWidgetPublic struct {
 	// AI and advanced features
 	UseAI bool `db:"use_ai" json:"use_ai"`
 
+	// Inactivity handling is enforced server side
+	IdleWarningMinutes     int     `db:"idle_warning_minutes" json:"-"`
+	IdleTimeoutMinutes     int     `db:"idle_timeout_minutes" json:"-"`
+	SendTranscript         bool    `db:"send_transcript" json:"-"`
+	TranscriptArchiveEmail *string `db:"transcript_archive_email" json:"-"`
+
+	UnansweredTicketMinutes int `db:"unanswered_ticket_minutes" json:"-"`
+
+	IdentityVerificationEnabled bool    `db:"identity_verification_enabled" json:"identity_verification_enabled"`
+	IdentitySecret              *string `db:"identity_secret" json:"-"`
+
 	// Business hours and embed settings
 	BusinessHours JSONMap `db:"business_hours" json:"business_hours"`
 	EmbedCode     *string `db:"embed_code" json:"embed_code,omitempty"`
@@ -596,6 +623,17 @@ type ChatWidgetPublic struct {
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
 }
 
+// IdleChatSession is an active chat session together with the inactivity settings of its widget
+type IdleChatSession struct {
+	ChatSession
+
+	IdleWarnedAt           *time.Time `db:"idle_warned_at" json:"idle_warned_at,omitempty"`
+	IdleWarningMinutes     int        `db:"idle_warning_minutes" json:"idle_warning_minutes"`
+	IdleTimeoutMinutes     int        `db:"idle_timeout_minutes" json:"idle_timeout_minutes"`
+	SendTranscript         bool       `db:"send_transcript" json:"send_transcript"`
+	TranscriptArchiveEmail *string    `db:"transcript_archive_email" json:"transcript_archive_email,omitempty"`
+}
+
 // ChatSession represents a chat conversation session
 type ChatSession struct {
 	ID              uuid.UUID `db:"id" json:"id"`
@@ -612,6 +650,9 @@ type ChatSession struct {
 	Status      string  `db:"status" json:"status"`
 	VisitorInfo JSONMap `db:"visitor_info" json:"visitor_info"`
 
+	// IdentityVerified is set when the customer link comes from a signed identity token
+	IdentityVerified bool `db:"identity_verified" json:"identity_verified"`
+
 	// Agent assignment
 	AssignedAgentID *uuid.UUID `db:"assigned_agent_id" json:"assigned_agent_id,omitempty"`
 	AssignedAt      *time.Time `db:"assigned_at" json:"assigned_at,omitempty"`
@@ -639,6 +680,14 @@ type ChatSession struct {
 	UseAI             bool    `db:"use_ai" json:"use_ai"`
 }
 
+// ChatContextSummary is the rolling summary of the older messages of a chat, sent to the AI in their place
+// once the conversation outgrows the model's context budget
+type ChatContextSummary struct {
+	Summary   string     `db:"context_summary" json:"summary"`
+	Seq       int64      `db:"context_summary_seq" json:"seq"` // the last message covered
+	UpdatedAt *time.Time `db:"context_summary_updated_at" json:"updated_at,omitempty"`
+}
+
 // SlackSessionMeta represents Slack-specific metadata stored in ChatSession.Meta
 type SlackSessionMeta struct {
 	ThreadTS  string `json:"thread_ts"`  // Slack thread timestamp
@@ -701,6 +750,9 @@ type ChatMessage struct {
 	ProjectID uuid.UUID `db:"project_id" json:"project_id"`
 	SessionID uuid.UUID `db:"session_id" json:"session_id"`
 
+	// Seq numbers the messages of a session 1, 2, 3... in the order they were stored
+	Seq int64 `db:"seq" json:"seq"`
+
 	// Message content
 	MessageType string `db:"message_type" json:"message_type"`
 	Content     string `db:"content" json:"content"`
@@ -736,6 +788,13 @@ type ChatSessionParticipant struct {
 	AgentEmail string `db:"agent_email" json:"agent_email,omitempty"`
 }
 
+// Chat session participant roles
+const (
+	ChatParticipantPrimary     = "primary"     // The assigned agent
+	ChatParticipantParticipant = "participant" // An invited agent who chats alongside the primary
+	ChatParticipantObserver    = "observer"    // A supervisor monitoring the chat, invisible to the visitor
+)
+
 // Chat Request/Response DTOs
 
 // CreateChatWidgetRequest represents a request to create a chat widget
@@ -766,6 +825,13 @@ type CreateChatWidgetRequest struct {
 	SoundEnabled     bool    `json:"sound_enabled"`
 	ShowPoweredBy    bool    `json:"show_powered_by"`
 	UseAI            bool    `json:"use_ai"`
+
+	IdleWarningMinutes     *int    `json:"idle_warning_minutes,omitempty" binding:"omitempty,min=0,max=1440"`
+	IdleTimeoutMinutes     *int    `json:"idle_timeout_minutes,omitempty" binding:"omitempty,min=0,max=10080"`
+	SendTranscript         bool    `json:"send_transcript"`
+	TranscriptArchiveEmail *string `json:"transcript_archive_email,omitempty" binding:"omitempty,email"`
+
+	UnansweredTicketMinutes *int `json:"unanswered_ticket_minutes,omitempty" binding:"omitempty,min=0,max=1440"`
 }
 
 // UpdateChatWidgetRequest represents a request to update a chat widget
@@ -796,6 +862,26 @@ type UpdateChatWidgetRequest struct {
 	AgentAvatarURL   *string  `json:"agent_avatar_url,omitempty" binding:"omitempty,url"`
 	CustomGreeting   *string  `json:"custom_greeting,omitempty" binding:"omitempty,max=500"`
 	UseAI            *bool    `json:"use_ai,omitempty"`
+
+	IdleWarningMinutes     *int    `json:"idle_warning_minutes,omitempty" binding:"omitempty,min=0,max=1440"`
+	IdleTimeoutMinutes     *int    `json:"idle_timeout_minutes,omitempty" binding:"omitempty,min=0,max=10080"`
+	SendTranscript         *bool   `json:"send_transcript,omitempty"`
+	TranscriptArchiveEmail *string `json:"transcript_archive_email,omitempty" binding:"omitempty,email"` // empty string clears it
+
+	UnansweredTicketMinutes *int `json:"unanswered_ticket_minutes,omitempty" binding:"omitempty,min=0,max=1440"`
+
+	IdentityVerificationEnabled *bool `json:"identity_verification_enabled,omitempty"`
+}
+
+// VisitorConversation is a past chat session as shown to its verified visitor
+type VisitorConversation struct {
+	ID                uuid.UUID  `json:"id"`
+	Status            string     `json:"status"`
+	AssignedAgentName *string    `json:"assigned_agent_name,omitempty"`
+	TicketID          *uuid.UUID `json:"ticket_id,omitempty"`
+	StartedAt         time.Time  `json:"started_at"`
+	EndedAt           *time.Time `json:"ended_at,omitempty"`
+	LastActivityAt    time.Time  `json:"last_activity_at"`
 }
 
 // InitiateChatRequest represents a request to start a chat session
@@ -804,6 +890,7 @@ type InitiateChatRequest struct {
 	VisitorEmail   string  `json:"visitor_email" binding:"omitempty,email"`
 	InitialMessage string  `json:"initial_message" binding:"omitempty,max=1000"`
 	VisitorInfo    JSONMap `json:"visitor_info"`
+	IdentityToken  string  `json:"identity_token" binding:"omitempty,max=4096"` // signed by the customer's backend
 }
 
 // SendChatMessageRequest represents a request to send a chat message
@@ -820,6 +907,22 @@ type AssignChatSessionRequest struct {
 	AgentID uuid.UUID `json:"agent_id" binding:"required"`
 }
 
+// TransferChatSessionRequest hands a live chat to another agent, or back to the project queue when no agent is given
+type TransferChatSessionRequest struct {
+	AgentID *uuid.UUID `json:"agent_id,omitempty"`
+	Note    string     `json:"note" binding:"omitempty,max=2000"`
+}
+
+// InviteChatAgentRequest represents a request to invite a second agent into a chat
+type InviteChatAgentRequest struct {
+	AgentID uuid.UUID `json:"agent_id" binding:"required"`
+}
+
+// WhisperChatMessageRequest represents a private message visible only to the agents of a chat
+type WhisperChatMessageRequest struct {
+	Content string `json:"content" binding:"required,max=5000"`
+}
+
 // EscalateChatSessionRequest represents a request to escalate a chat session
 type EscalateChatSessionRequest struct {
 	Reason   string               `json:"reason" binding:"required,max=500"`
@@ -842,6 +945,22 @@ type ChatSessionWithMessages struct {
 	Participants []ChatSessionParticipant `json:"participants"`
 }
 
+// AgentPresence is an agent's availability as seen by the other agents of a project
+type AgentPresence struct {
+	AgentID     uuid.UUID  `json:"agent_id"`
+	AgentName   string     `json:"agent_name"`
+	Status      string     `json:"status"` // online, away, busy or offline
+	ActiveChats int        `json:"active_chats"`
+	MaxChats    int        `json:"max_chats"`
+	Available   bool       `json:"available"` // online and below capacity
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// SetAgentPresenceRequest sets an agent's status explicitly; online returns to the status derived from the connection
+type SetAgentPresenceRequest struct {
+	Status string `json:"status" binding:"required,oneof=online away busy offline"`
+}
+
 // WebSocket Message Types for real-time chat
 type WSMessageType string
 
@@ -855,6 +974,29 @@ const (
 	WSMsgTypeReadReceipt   WSMessageType = "read_receipt"
 	WSMsgTypeSessionUpdate WSMessageType = "session_update"
 	WSMsgTypeNotification  WSMessageType = "notification"
+
+	// Agent presence: sent by agents to set their status, broadcast to project agents on change
+	WSMsgTypeAgentPresence WSMessageType = "agent_presence"
+
+	// Reliable delivery: visitors resume after a reconnect from the last sequence number they saw
+	// and acknowledge what they received; agents are told once a visitor received a message
+	WSMsgTypeResume           WSMessageType = "resume"
+	WSMsgTypeResumeComplete   WSMessageType = "resume_complete"
+	WSMsgTypeAck              WSMessageType = "ack"
+	WSMsgTypeMessageDelivered WSMessageType = "message_delivered"
+
+	// AI reply streaming: pieces of an AI reply as it is generated, followed by the stored chat_message
+	// carrying the same stream_id in its metadata
+	WSMsgTypeChatMessageDelta WSMessageType = "chat_message_delta"
+
+	// Agent assist: agents ask for reply suggestions or a summary of a session and get the outcome back
+	// on the same connection
+	WSMsgTypeAIAssist       WSMessageType = "ai_assist"
+	WSMsgTypeAIAssistResult WSMessageType = "ai_assist_result"
+
+	// Translation: a visitor message translated into the language of the session's agent, delivered to
+	// the agents after the message itself
+	WSMsgTypeMessageTranslation WSMessageType = "message_translation"
 )
 
 // WSMessage represents a WebSocket message
@@ -889,6 +1031,8 @@ const (
 	NotificationTypeAlarmEscalation   NotificationType = "alarm_escalation"
 	NotificationTypeAlarmAcknowledged NotificationType = "alarm_acknowledged"
 	NotificationTypeUrgentRequest     NotificationType = "urgent_request"
+	// Billing notification types
+	NotificationTypeLowCreditBalance NotificationType = "low_credit_balance"
 )
 
 type NotificationChannel string
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// SSO protocols supported for tenant identity providers
+const (
+	SSOProtocolOIDC = "oidc"
+	SSOProtocolSAML = "saml"
+)
+
+// SSOGroupMapping grants a role to members of an IdP group.
+// Without a project the role is granted on every project of the tenant.
+type SSOGroupMapping struct {
+	Group     string     `json:"group" example:"support-admins"`
+	Role      RoleType   `json:"role" example:"project_admin"`
+	ProjectID *uuid.UUID `json:"project_id,omitempty"`
+}
+
+// SSOGroupMappings is the ordered list of group mappings stored as JSONB.
+// The first mapping that matches a project wins.
+type SSOGroupMappings []SSOGroupMapping
+
+// Scan implements the sql.Scanner interface
+func (m *SSOGroupMappings) Scan(value interface{}) error {
+	if value == nil {
+		*m = SSOGroupMappings{}
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, m)
+}
+
+// Value implements the driver.Valuer interface
+func (m SSOGroupMappings) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Ticket triage methods
+const (
+	TicketTriageMethodHeuristic = "heuristic"
+	TicketTriageMethodLLM       = "llm"
+)
+
+// Ticket triage review statuses
+const (
+	TicketTriagePending   = "pending"
+	TicketTriageAccepted  = "accepted"
+	TicketTriageCorrected = "corrected"
+)
+
+// Ticket triage fields that may be applied to a ticket or suggested to agents
+const (
+	TicketTriageFieldType     = "type"
+	TicketTriageFieldPriority = "priority"
+)
+
+// TicketTriage is how the AI classified a ticket. Type and priority are applied to the ticket when their
+// confidence reaches the project's auto-apply threshold and suggested to agents when it reaches the
+// suggest threshold; tags, language and sentiment are informational.
+type TicketTriage struct {
+	ID                 uuid.UUID      `json:"id" db:"id"`
+	TenantID           uuid.UUID      `json:"tenant_id" db:"tenant_id"`
+	ProjectID          uuid.UUID      `json:"project_id" db:"project_id"`
+	TicketID           uuid.UUID      `json:"ticket_id" db:"ticket_id"`
+	Method             string         `json:"method" db:"method"` // heuristic or llm
+	Model              *string        `json:"model,omitempty" db:"model"`
+	Type               string         `json:"type" db:"type"`
+	TypeConfidence     float64        `json:"type_confidence" db:"type_confidence"`
+	Priority           string         `json:"priority" db:"priority"`
+	PriorityConfidence float64        `json:"priority_confidence" db:"priority_confidence"`
+	Tags               pq.StringArray `json:"tags" db:"tags"`
+	Language           *string        `json:"language,omitempty" db:"language"` // ISO 639-1
+	Sentiment          string         `json:"sentiment" db:"sentiment"`         // positive, neutral or negative
+	Urgency            string         `json:"urgency" db:"urgency"`             // low, normal, high or critical
+	PreviousType       string         `json:"previous_type" db:"previous_type"`
+	PreviousPriority   string         `json:"previous_priority" db:"previous_priority"`
+	AppliedFields      pq.StringArray `json:"applied_fields" db:"applied_fields"`
+	SuggestedFields    pq.StringArray `json:"suggested_fields" db:"suggested_fields"`
+	ReviewStatus       string         `json:"review_status" db:"review_status"`
+	ReviewedType       *string        `json:"reviewed_type,omitempty" db:"reviewed_type"`
+	ReviewedPriority   *string        `json:"reviewed_priority,omitempty" db:"reviewed_priority"`
+	ReviewedTags       pq.StringArray `json:"reviewed_tags,omitempty" db:"reviewed_tags"`
+	ReviewedBy         *uuid.UUID     `json:"reviewed_by,omitempty" db:"reviewed_by"`
+	ReviewedAt         *time.Time     `json:"reviewed_at,omitempty" db:"reviewed_at"`
+	CreatedAt          time.Time      `json:"created_at" db:"created_at"`
+}
+
+// TicketTriageReviewRequest records an agent's verdict on a triage decision. Fields left out are taken as
+// correct; the reviewed type and priority are applied to the ticket.
+type TicketTriageReviewRequest struct {
+	Type     *string  `json:"type,omitempty" binding:"omitempty,oneof=question incident problem task"`
+	Priority *string  `json:"priority,omitempty" binding:"omitempty,oneof=low normal high urgent"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// TicketTriageFieldAccuracy is how often reviewers agreed with the triage of one field
+type TicketTriageFieldAccuracy struct {
+	Reviewed           int64   `json:"reviewed" db:"reviewed"`
+	Correct            int64   `json:"correct" db:"correct"`
+	Accuracy           float64 `json:"accuracy"`
+	AutoApplied        int64   `json:"auto_applied" db:"auto_applied"`
+	AutoAppliedCorrect int64   `json:"auto_applied_correct" db:"auto_applied_correct"`
+	AppliedAccuracy    float64 `json:"auto_applied_accuracy"`
+}
+
+// TicketTriageAccuracy summarizes the reviews of a project's triage decisions
+type TicketTriageAccuracy struct {
+	Since    time.Time                 `json:"since"`
+	Total    int64                     `json:"total"`
+	Reviewed int64                     `json:"reviewed"`
+	Type     TicketTriageFieldAccuracy `json:"type"`
+	Priority TicketTriageFieldAccuracy `json:"priority"`
+}
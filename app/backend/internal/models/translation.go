@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of messages that are translated, by the conversation they belong to
+const (
+	MessageKindChat   = "chat"
+	MessageKindTicket = "ticket"
+)
+
+// TranslationSettings is how a project translates between its customers and agents. They are kept with
+// the project's settings under "translation_settings"; a project without them gets
+// DefaultTranslationSettings.
+type TranslationSettings struct {
+	// Translate customer messages for agents and agent replies for customers
+	Enabled bool `json:"enabled"`
+	// ISO 639-1 code of the language customer messages are translated into for agents who have not set
+	// their own
+	AgentLanguage string `json:"agent_language"`
+}
+
+// DefaultTranslationSettings leave translation off, with English for agents once it is turned on
+func DefaultTranslationSettings() *TranslationSettings {
+	return &TranslationSettings{
+		Enabled:       false,
+		AgentLanguage: "en",
+	}
+}
+
+// MessageLanguage is the language detected in a customer message. ConversationID is the chat session or
+// ticket of the message.
+type MessageLanguage struct {
+	MessageKind    string    `json:"message_kind" db:"message_kind"`
+	MessageID      uuid.UUID `json:"message_id" db:"message_id"`
+	TenantID       uuid.UUID `json:"tenant_id" db:"tenant_id"`
+	ProjectID      uuid.UUID `json:"project_id" db:"project_id"`
+	ConversationID uuid.UUID `json:"conversation_id" db:"conversation_id"`
+	Language       string    `json:"language" db:"language"`
+	Backend        string    `json:"backend" db:"backend"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// MessageTranslation is a chat or ticket message in another language. For agent replies that were
+// translated for the customer, it is the agent's original text.
+type MessageTranslation struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	TenantID       uuid.UUID `json:"tenant_id" db:"tenant_id"`
+	ProjectID      uuid.UUID `json:"project_id" db:"project_id"`
+	MessageKind    string    `json:"message_kind" db:"message_kind"`
+	MessageID      uuid.UUID `json:"message_id" db:"message_id"`
+	ConversationID uuid.UUID `json:"conversation_id" db:"conversation_id"`
+	SourceLanguage string    `json:"source_language" db:"source_language"`
+	TargetLanguage string    `json:"target_language" db:"target_language"`
+	TranslatedText string    `json:"translated_text" db:"translated_text"`
+	Backend        string    `json:"backend" db:"backend"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// UntranslatedMessage is a customer message of a conversation that has no translation into a language yet
+type UntranslatedMessage struct {
+	MessageID uuid.UUID `db:"message_id"`
+	Language  string    `db:"language"`
+	Text      string    `db:"text"`
+}
+
+// ReplyTranslation is an agent reply translated into the customer's language before delivery
+type ReplyTranslation struct {
+	Original       string
+	Text           string
+	SourceLanguage string
+	TargetLanguage string
+	Backend        string
+}
+
+// MessageTranslationList is the translations of a conversation's messages into the language of the agent
+// reading it
+type MessageTranslationList struct {
+	Language     string                `json:"language"`
+	Translations []*MessageTranslation `json:"translations"`
+}
@@ -0,0 +1,339 @@
+package rbac
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Custom role errors
+var (
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrRoleNotFound     = errors.New("role not found")
+	ErrRoleExists       = errors.New("role already exists")
+	ErrRoleInUse        = errors.New("role is assigned to agents")
+	ErrInvalidRoleInput = errors.New("invalid role definition")
+)
+
+// tenantRolesCacheTTL bounds how long a tenant's role definitions stay cached
+const tenantRolesCacheTTL = 10 * time.Minute
+
+var customRoleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// RoleDefinition describes a built-in or custom role and the permissions it grants
+type RoleDefinition struct {
+	ID          *uuid.UUID   `json:"id,omitempty"`
+	Name        string       `json:"name"`
+	Description *string      `json:"description,omitempty"`
+	Permissions []Permission `json:"permissions"`
+	BuiltIn     bool         `json:"built_in"`
+}
+
+// CustomRoleRequest is the payload for creating or updating a custom role
+type CustomRoleRequest struct {
+	Name        string   `json:"name" binding:"required" example:"knowledge-editor"`
+	Description *string  `json:"description,omitempty"`
+	Permissions []string `json:"permissions" binding:"required" example:"knowledge:read,knowledge:write"`
+}
+
+// IsBuiltInRole reports whether the role is one of the hard-coded roles
+func IsBuiltInRole(role models.RoleType) bool {
+	_, exists := roleMap[role]
+	return exists
+}
+
+func tenantRolesCacheKey(tenantID uuid.UUID) string {
+	return fmt.Sprintf("rbac:tenant_roles:%s", tenantID.String())
+}
+
+// RoleExists reports whether the role is built-in or defined by the tenant
+func (s *Service) RoleExists(ctx context.Context, tenantID uuid.UUID, role models.RoleType) (bool, error) {
+	if IsBuiltInRole(role) {
+		return true, nil
+	}
+
+	roles, err := s.tenantRolePermissions(ctx, tenantID)
+	if err != nil {
+		return false, err
+	}
+	_, exists := roles[string(role)]
+	return exists, nil
+}
+
+// TenantRoleHasPermission checks if a built-in or tenant defined role grants a permission
+func (s *Service) TenantRoleHasPermission(ctx context.Context, tenantID uuid.UUID, role models.RoleType, permission Permission) (bool, error) {
+	if IsBuiltInRole(role) {
+		return RoleHasPermission(role, permission), nil
+	}
+
+	roles, err := s.tenantRolePermissions(ctx, tenantID)
+	if err != nil {
+		return false, err
+	}
+	for _, perm := range roles[string(role)] {
+		if Permission(perm) == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// tenantRolePermissions returns the tenant's custom roles keyed by name, served from Redis when possible
+func (s *Service) tenantRolePermissions(ctx context.Context, tenantID uuid.UUID) (map[string][]string, error) {
+	cacheKey := tenantRolesCacheKey(tenantID)
+
+	if s.redisService != nil {
+		cached, err := s.redisService.GetClient().Get(ctx, cacheKey).Result()
+		if err == nil && cached != "" {
+			var roles map[string][]string
+			if err := json.Unmarshal([]byte(cached), &roles); err == nil {
+				return roles, nil
+			}
+		}
+	}
+
+	customRoles, err := s.ListCustomRoles(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string][]string, len(customRoles))
+	for _, role := range customRoles {
+		roles[role.Name] = role.Permissions
+	}
+
+	if s.redisService != nil {
+		if data, err := json.Marshal(roles); err == nil {
+			if err := s.redisService.GetClient().Set(ctx, cacheKey, data, tenantRolesCacheTTL).Err(); err != nil {
+				log.Printf("Failed to cache tenant roles for %s: %v", tenantID, err)
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// invalidateTenantRoles drops the cached role definitions of a tenant
+func (s *Service) invalidateTenantRoles(ctx context.Context, tenantID uuid.UUID) {
+	if s.redisService == nil {
+		return
+	}
+	if err := s.redisService.GetClient().Del(ctx, tenantRolesCacheKey(tenantID)).Err(); err != nil {
+		log.Printf("Failed to invalidate tenant roles cache for %s: %v", tenantID, err)
+	}
+}
+
+// ListRoles returns the built-in roles followed by the tenant's custom roles
+func (s *Service) ListRoles(ctx context.Context, tenantID uuid.UUID) ([]*RoleDefinition, error) {
+	var definitions []*RoleDefinition
+	for _, roleType := range models.AllRoles() {
+		role := roleMap[roleType]
+		definitions = append(definitions, &RoleDefinition{
+			Name:        string(role.Name),
+			Permissions: role.Permissions,
+			BuiltIn:     true,
+		})
+	}
+
+	customRoles, err := s.ListCustomRoles(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range customRoles {
+		definitions = append(definitions, customRoleDefinition(role))
+	}
+
+	return definitions, nil
+}
+
+func customRoleDefinition(role *db.TenantRole) *RoleDefinition {
+	permissions := make([]Permission, 0, len(role.Permissions))
+	for _, perm := range role.Permissions {
+		permissions = append(permissions, Permission(perm))
+	}
+	id := role.ID
+	return &RoleDefinition{
+		ID:          &id,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: permissions,
+	}
+}
+
+// ListCustomRoles retrieves the custom roles of a tenant
+func (s *Service) ListCustomRoles(ctx context.Context, tenantID uuid.UUID) ([]*db.TenantRole, error) {
+	query := `
+		SELECT id, tenant_id, name, description, permissions, created_at, updated_at
+		FROM tenant_roles
+		WHERE tenant_id = $1
+		ORDER BY name ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenant roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []*db.TenantRole
+	for rows.Next() {
+		var role db.TenantRole
+		if err := rows.Scan(&role.ID, &role.TenantID, &role.Name, &role.Description, &role.Permissions, &role.CreatedAt, &role.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant role: %w", err)
+		}
+		roles = append(roles, &role)
+	}
+
+	return roles, rows.Err()
+}
+
+// getCustomRole retrieves a custom role by ID
+func (s *Service) getCustomRole(ctx context.Context, tenantID, roleID uuid.UUID) (*db.TenantRole, error) {
+	query := `
+		SELECT id, tenant_id, name, description, permissions, created_at, updated_at
+		FROM tenant_roles
+		WHERE tenant_id = $1 AND id = $2
+	`
+
+	var role db.TenantRole
+	err := s.db.QueryRowContext(ctx, query, tenantID, roleID).Scan(
+		&role.ID, &role.TenantID, &role.Name, &role.Description, &role.Permissions, &role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to get tenant role: %w", err)
+	}
+
+	return &role, nil
+}
+
+// validateCustomRole checks the name and permissions of a custom role
+func validateCustomRole(req CustomRoleRequest) (string, pq.StringArray, error) {
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	if !customRoleNamePattern.MatchString(name) {
+		return "", nil, fmt.Errorf("%w: name must be 2-50 lowercase letters, digits, '-' or '_'", ErrInvalidRoleInput)
+	}
+	if IsBuiltInRole(models.RoleType(name)) {
+		return "", nil, fmt.Errorf("%w: %s is a built-in role", ErrRoleExists, name)
+	}
+	if len(req.Permissions) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one permission is required", ErrInvalidRoleInput)
+	}
+
+	permissions := pq.StringArray{}
+	seen := make(map[string]bool)
+	for _, perm := range req.Permissions {
+		perm = strings.TrimSpace(perm)
+		if !IsValidPermission(Permission(perm)) {
+			return "", nil, fmt.Errorf("%w: unknown permission %s", ErrInvalidRoleInput, perm)
+		}
+		if seen[perm] {
+			continue
+		}
+		seen[perm] = true
+		permissions = append(permissions, perm)
+	}
+
+	return name, permissions, nil
+}
+
+// CreateCustomRole defines a new role for a tenant
+func (s *Service) CreateCustomRole(ctx context.Context, tenantID uuid.UUID, req CustomRoleRequest) (*db.TenantRole, error) {
+	name, permissions, err := validateCustomRole(req)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO tenant_roles (tenant_id, name, description, permissions, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		ON CONFLICT (tenant_id, name) DO NOTHING
+		RETURNING id, tenant_id, name, description, permissions, created_at, updated_at
+	`
+
+	var role db.TenantRole
+	err = s.db.QueryRowContext(ctx, query, tenantID, name, req.Description, permissions).Scan(
+		&role.ID, &role.TenantID, &role.Name, &role.Description, &role.Permissions, &role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrRoleExists, name)
+		}
+		return nil, fmt.Errorf("failed to create tenant role: %w", err)
+	}
+
+	s.invalidateTenantRoles(ctx, tenantID)
+	return &role, nil
+}
+
+// UpdateCustomRole replaces the description and permissions of a custom role.
+// Renaming is not supported because role bindings reference the role by name.
+func (s *Service) UpdateCustomRole(ctx context.Context, tenantID, roleID uuid.UUID, req CustomRoleRequest) (*db.TenantRole, error) {
+	existing, err := s.getCustomRole(ctx, tenantID, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	name, permissions, err := validateCustomRole(req)
+	if err != nil {
+		return nil, err
+	}
+	if name != existing.Name {
+		return nil, fmt.Errorf("%w: roles cannot be renamed", ErrInvalidRoleInput)
+	}
+
+	query := `
+		UPDATE tenant_roles
+		SET description = $3, permissions = $4, updated_at = NOW()
+		WHERE tenant_id = $1 AND id = $2
+		RETURNING id, tenant_id, name, description, permissions, created_at, updated_at
+	`
+
+	var role db.TenantRole
+	err = s.db.QueryRowContext(ctx, query, tenantID, roleID, req.Description, permissions).Scan(
+		&role.ID, &role.TenantID, &role.Name, &role.Description, &role.Permissions, &role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update tenant role: %w", err)
+	}
+
+	s.invalidateTenantRoles(ctx, tenantID)
+	return &role, nil
+}
+
+// DeleteCustomRole removes a custom role that is no longer assigned to any agent
+func (s *Service) DeleteCustomRole(ctx context.Context, tenantID, roleID uuid.UUID) error {
+	role, err := s.getCustomRole(ctx, tenantID, roleID)
+	if err != nil {
+		return err
+	}
+
+	var assignments int
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM agent_project_roles WHERE tenant_id = $1 AND role = $2`,
+		tenantID, role.Name).Scan(&assignments)
+	if err != nil {
+		return fmt.Errorf("failed to count role assignments: %w", err)
+	}
+	if assignments > 0 {
+		return fmt.Errorf("%w: %s has %d assignment(s)", ErrRoleInUse, role.Name, assignments)
+	}
+
+	_, err = s.db.ExecContext(ctx, `DELETE FROM tenant_roles WHERE tenant_id = $1 AND id = $2`, tenantID, roleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant role: %w", err)
+	}
+
+	s.invalidateTenantRoles(ctx, tenantID)
+	return nil
+}
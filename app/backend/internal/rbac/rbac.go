@@ -8,6 +8,7 @@ import (
 
 	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/redis"
 	"github.com/google/uuid"
 )
 
@@ -32,8 +33,81 @@ const (
 	// Note permissions
 	PermNotePrivateRead  Permission = "note:private:read"
 	PermNotePrivateWrite Permission = "note:private:write"
+
+	// Knowledge base permissions
+	PermKnowledgeRead  Permission = "knowledge:read"
+	PermKnowledgeWrite Permission = "knowledge:write"
+
+	// Chat widget permissions
+	PermWidgetRead  Permission = "widget:read"
+	PermWidgetWrite Permission = "widget:write"
+
+	// Integration permissions
+	PermIntegrationRead  Permission = "integration:read"
+	PermIntegrationWrite Permission = "integration:write"
+
+	// Email connector, mailbox and inbox permissions
+	PermEmailRead  Permission = "email:read"
+	PermEmailWrite Permission = "email:write"
+
+	// Project settings permissions
+	PermSettingsRead  Permission = "settings:read"
+	PermSettingsWrite Permission = "settings:write"
+
+	// Chat session permissions
+	PermChatRead      Permission = "chat:read"
+	PermChatWrite     Permission = "chat:write"
+	PermChatSupervise Permission = "chat:supervise"
+
+	// Billing permissions
+	PermBillingRead  Permission = "billing:read"
+	PermBillingWrite Permission = "billing:write"
+
+	// API key management permissions
+	PermApiKeyRead  Permission = "api_key:read"
+	PermApiKeyWrite Permission = "api_key:write"
 )
 
+// AllPermissions is the permission catalogue
+var AllPermissions = []Permission{
+	PermTicketRead, PermTicketWrite, PermTicketAdmin,
+	PermAgentRead, PermAgentWrite,
+	PermCustomerRead, PermCustomerWrite,
+	PermNotePrivateRead, PermNotePrivateWrite,
+	PermKnowledgeRead, PermKnowledgeWrite,
+	PermWidgetRead, PermWidgetWrite,
+	PermIntegrationRead, PermIntegrationWrite,
+	PermEmailRead, PermEmailWrite,
+	PermSettingsRead, PermSettingsWrite,
+	PermChatRead, PermChatWrite, PermChatSupervise,
+	PermBillingRead, PermBillingWrite,
+	PermApiKeyRead, PermApiKeyWrite,
+}
+
+// ApiKeyScopes are the permissions an API key can be granted. API keys only authenticate the ticket
+// routes, so no other scope would ever be usable.
+var ApiKeyScopes = []Permission{PermTicketRead, PermTicketWrite, PermTicketAdmin}
+
+// IsApiKeyScope reports whether the permission can be granted to an API key
+func IsApiKeyScope(permission Permission) bool {
+	for _, scope := range ApiKeyScopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidPermission reports whether the permission is part of the catalogue
+func IsValidPermission(permission Permission) bool {
+	for _, perm := range AllPermissions {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
 // Role represents a role with its permissions
 type Role struct {
 	Name        models.RoleType
@@ -43,13 +117,8 @@ type Role struct {
 // Define roles
 var (
 	RoleTenantAdmin = Role{
-		Name: models.RoleTenantAdmin,
-		Permissions: []Permission{
-			PermTicketRead, PermTicketWrite, PermTicketAdmin,
-			PermAgentRead, PermAgentWrite,
-			PermCustomerRead, PermCustomerWrite,
-			PermNotePrivateRead, PermNotePrivateWrite,
-		},
+		Name:        models.RoleTenantAdmin,
+		Permissions: AllPermissions,
 	}
 
 	RoleProjectAdmin = Role{
@@ -59,6 +128,14 @@ var (
 			PermAgentRead, PermAgentWrite,
 			PermCustomerRead, PermCustomerWrite,
 			PermNotePrivateRead, PermNotePrivateWrite,
+			PermKnowledgeRead, PermKnowledgeWrite,
+			PermWidgetRead, PermWidgetWrite,
+			PermIntegrationRead, PermIntegrationWrite,
+			PermEmailRead, PermEmailWrite,
+			PermSettingsRead, PermSettingsWrite,
+			PermChatRead, PermChatWrite, PermChatSupervise,
+			PermBillingRead,
+			PermApiKeyRead, PermApiKeyWrite,
 		},
 	}
 
@@ -69,6 +146,12 @@ var (
 			PermAgentRead,
 			PermCustomerRead, PermCustomerWrite,
 			PermNotePrivateRead, PermNotePrivateWrite,
+			PermKnowledgeRead, PermKnowledgeWrite,
+			PermWidgetRead,
+			PermIntegrationRead,
+			PermEmailRead, PermEmailWrite,
+			PermSettingsRead,
+			PermChatRead, PermChatWrite, PermChatSupervise,
 		},
 	}
 
@@ -77,6 +160,11 @@ var (
 		Permissions: []Permission{
 			PermTicketRead, PermTicketWrite,
 			PermCustomerRead, PermCustomerWrite,
+			PermKnowledgeRead,
+			PermWidgetRead,
+			PermIntegrationRead,
+			PermEmailRead, PermEmailWrite,
+			PermChatRead, PermChatWrite,
 		},
 	}
 
@@ -85,18 +173,16 @@ var (
 		Permissions: []Permission{
 			PermTicketRead,
 			PermCustomerRead,
+			PermKnowledgeRead,
+			PermWidgetRead,
+			PermChatRead,
 		},
 	}
 
 	// Legacy roles for backwards compatibility
 	RoleAdmin = Role{
-		Name: "admin",
-		Permissions: []Permission{
-			PermTicketRead, PermTicketWrite, PermTicketAdmin,
-			PermAgentRead, PermAgentWrite,
-			PermCustomerRead, PermCustomerWrite,
-			PermNotePrivateRead, PermNotePrivateWrite,
-		},
+		Name:        "admin",
+		Permissions: AllPermissions,
 	}
 
 	RoleViewer = Role{
@@ -104,6 +190,9 @@ var (
 		Permissions: []Permission{
 			PermTicketRead,
 			PermCustomerRead,
+			PermKnowledgeRead,
+			PermWidgetRead,
+			PermChatRead,
 		},
 	}
 )
@@ -121,7 +210,8 @@ var roleMap = map[models.RoleType]Role{
 
 // Service handles RBAC operations
 type Service struct {
-	db *sql.DB
+	db           *sql.DB
+	redisService *redis.Service
 }
 
 // NewService creates a new RBAC service
@@ -129,6 +219,11 @@ func NewService(database *sql.DB) *Service {
 	return &Service{db: database}
 }
 
+// SetRedisService enables Redis caching of tenant role definitions
+func (s *Service) SetRedisService(redisService *redis.Service) {
+	s.redisService = redisService
+}
+
 // CheckPermission checks if an agent has a specific permission
 func (s *Service) CheckPermission(ctx context.Context, agentID, tenantID, projectID uuid.UUID, permission Permission) (bool, error) {
 	log.Printf("CheckPermission called: agentID=%s, tenantID=%s, projectID=%s, permission=%s", agentID, tenantID, projectID, permission)
@@ -161,7 +256,11 @@ func (s *Service) CheckPermission(ctx context.Context, agentID, tenantID, projec
 		// If projectID is specified, check project-specific roles
 		if binding.ProjectID != nil && *binding.ProjectID == projectID {
 			log.Printf("Checking project-specific role: %s", binding.Role)
-			if s.hasPermission(binding.Role, permission) {
+			granted, err := s.TenantRoleHasPermission(ctx, tenantID, binding.Role, permission)
+			if err != nil {
+				return false, err
+			}
+			if granted {
 				log.Printf("Permission granted via project-specific role: %s", binding.Role)
 				return true, nil
 			}
@@ -175,24 +274,22 @@ func (s *Service) CheckPermission(ctx context.Context, agentID, tenantID, projec
 
 // hasPermission checks if a role has a specific permission
 func (s *Service) hasPermission(roleName models.RoleType, permission Permission) bool {
-	log.Printf("hasPermission called: roleName=%s, permission=%s", roleName, permission)
+	return RoleHasPermission(roleName, permission)
+}
 
+// RoleHasPermission checks if a built-in role grants a specific permission
+func RoleHasPermission(roleName models.RoleType, permission Permission) bool {
 	role, exists := roleMap[roleName]
 	if !exists {
-		log.Printf("Role not found in roleMap: %s", roleName)
 		return false
 	}
 
-	log.Printf("Role found, checking %d permissions", len(role.Permissions))
 	for _, perm := range role.Permissions {
-		log.Printf("Checking permission: %s", perm)
 		if perm == permission {
-			log.Printf("Permission match found!")
 			return true
 		}
 	}
 
-	log.Printf("No permission match found for role %s", roleName)
 	return false
 }
 
@@ -239,12 +336,16 @@ func (s *Service) GetAgentRoleBindings(ctx context.Context, agentID, tenantID uu
 	return bindings, nil
 }
 
-// AssignRole assigns a role to an agent
+// AssignRole assigns a built-in or tenant defined role to an agent
 func (s *Service) AssignRole(ctx context.Context, agentID, tenantID, projectID uuid.UUID, role models.RoleType) error {
 
 	// Validate role exists
-	if _, exists := roleMap[role]; !exists {
-		return fmt.Errorf("invalid role: %s", role)
+	exists, err := s.RoleExists(ctx, tenantID, role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrInvalidRole, role)
 	}
 
 	query := `
@@ -254,7 +355,7 @@ func (s *Service) AssignRole(ctx context.Context, agentID, tenantID, projectID u
 		DO UPDATE SET role = EXCLUDED.role, updated_at = NOW()
 	`
 
-	_, err := s.db.ExecContext(ctx, query, agentID, tenantID, projectID, role)
+	_, err = s.db.ExecContext(ctx, query, agentID, tenantID, projectID, role)
 	if err != nil {
 		return fmt.Errorf("failed to assign role: %w", err)
 	}
@@ -21,13 +21,16 @@ func NewAgentRepository(database *sql.DB) AgentRepository {
 // Create creates a new agent
 func (r *agentRepository) Create(ctx context.Context, agent *db.Agent) error {
 	query := `
-		INSERT INTO agents (id, tenant_id, email, name, status, password_hash, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+		INSERT INTO agents (id, tenant_id, email, name, status, password_hash, max_chats, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
 	`
 
+	if agent.MaxChats <= 0 {
+		agent.MaxChats = db.DefaultMaxConcurrentChats
+	}
 	_, err := r.db.ExecContext(ctx, query,
 		agent.ID, agent.TenantID, agent.Email, agent.Name,
-		agent.Status, agent.PasswordHash)
+		agent.Status, agent.PasswordHash, agent.MaxChats)
 	if err != nil {
 		return fmt.Errorf("failed to create agent: %w", err)
 	}
@@ -38,7 +41,7 @@ func (r *agentRepository) Create(ctx context.Context, agent *db.Agent) error {
 // GetByID retrieves an agent by ID
 func (r *agentRepository) GetByID(ctx context.Context, tenantID, agentID uuid.UUID) (*db.Agent, error) {
 	query := `
-		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at
+		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at, COALESCE(max_chats, 5), preferred_language
 		FROM agents
 		WHERE tenant_id = $1 AND id = $2
 	`
@@ -46,7 +49,8 @@ func (r *agentRepository) GetByID(ctx context.Context, tenantID, agentID uuid.UU
 	var agent db.Agent
 	err := r.db.QueryRowContext(ctx, query, tenantID, agentID).Scan(
 		&agent.ID, &agent.TenantID, &agent.Email, &agent.Name,
-		&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt)
+		&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt, &agent.MaxChats,
+		&agent.PreferredLanguage)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("agent not found")
@@ -60,7 +64,7 @@ func (r *agentRepository) GetByID(ctx context.Context, tenantID, agentID uuid.UU
 // GetByEmail retrieves an agent by email
 func (r *agentRepository) GetByEmail(ctx context.Context, tenantID uuid.UUID, email string) (*db.Agent, error) {
 	query := `
-		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at
+		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at, COALESCE(max_chats, 5), preferred_language
 		FROM agents
 		WHERE tenant_id = $1 AND email = $2
 	`
@@ -68,7 +72,8 @@ func (r *agentRepository) GetByEmail(ctx context.Context, tenantID uuid.UUID, em
 	var agent db.Agent
 	err := r.db.QueryRowContext(ctx, query, tenantID, email).Scan(
 		&agent.ID, &agent.TenantID, &agent.Email, &agent.Name,
-		&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt)
+		&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt, &agent.MaxChats,
+		&agent.PreferredLanguage)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("agent not found")
@@ -82,7 +87,7 @@ func (r *agentRepository) GetByEmail(ctx context.Context, tenantID uuid.UUID, em
 // GetByEmail retrieves an agent by email
 func (r *agentRepository) GetByEmailWithoutTenantID(ctx context.Context, email string) (*db.Agent, error) {
 	query := `
-		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at
+		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at, COALESCE(max_chats, 5), preferred_language
 		FROM agents
 		WHERE email = $1
 	`
@@ -90,7 +95,8 @@ func (r *agentRepository) GetByEmailWithoutTenantID(ctx context.Context, email s
 	var agent db.Agent
 	err := r.db.QueryRowContext(ctx, query, email).Scan(
 		&agent.ID, &agent.TenantID, &agent.Email, &agent.Name,
-		&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt)
+		&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt, &agent.MaxChats,
+		&agent.PreferredLanguage)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("agent not found")
@@ -105,13 +111,15 @@ func (r *agentRepository) GetByEmailWithoutTenantID(ctx context.Context, email s
 func (r *agentRepository) Update(ctx context.Context, agent *db.Agent) error {
 	query := `
 		UPDATE agents
-		SET email = $3, name = $4, status = $5, password_hash = $6, updated_at = NOW()
+		SET email = $3, name = $4, status = $5, password_hash = $6,
+			max_chats = COALESCE(NULLIF($7, 0), max_chats), preferred_language = $8,
+			updated_at = NOW()
 		WHERE tenant_id = $1 AND id = $2
 	`
 
 	result, err := r.db.ExecContext(ctx, query,
 		agent.TenantID, agent.ID, agent.Email, agent.Name,
-		agent.Status, agent.PasswordHash)
+		agent.Status, agent.PasswordHash, agent.MaxChats, agent.PreferredLanguage)
 	if err != nil {
 		return fmt.Errorf("failed to update agent: %w", err)
 	}
@@ -152,7 +160,7 @@ func (r *agentRepository) Delete(ctx context.Context, tenantID, agentID uuid.UUI
 // List retrieves a list of agents with filtering and pagination
 func (r *agentRepository) List(ctx context.Context, tenantID uuid.UUID, filters AgentFilters, pagination PaginationParams) ([]*db.Agent, string, error) {
 	query := `
-		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at
+		SELECT id, tenant_id, email, name, status, password_hash, created_at, updated_at, COALESCE(max_chats, 5), preferred_language
 		FROM agents
 		WHERE tenant_id = $1
 	`
@@ -220,7 +228,8 @@ func (r *agentRepository) List(ctx context.Context, tenantID uuid.UUID, filters
 		var agent db.Agent
 		err := rows.Scan(
 			&agent.ID, &agent.TenantID, &agent.Email, &agent.Name,
-			&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt)
+			&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt, &agent.MaxChats,
+			&agent.PreferredLanguage)
 		if err != nil {
 			return nil, "", fmt.Errorf("failed to scan agent: %w", err)
 		}
@@ -241,7 +250,7 @@ func (r *agentRepository) List(ctx context.Context, tenantID uuid.UUID, filters
 // GetTenantAdmins retrieves all agents with tenant_admin role for a given tenant
 func (r *agentRepository) GetTenantAdmins(ctx context.Context, tenantID uuid.UUID) ([]*db.Agent, error) {
 	query := `
-		SELECT DISTINCT a.id, a.tenant_id, a.email, a.name, a.status, a.password_hash, a.created_at, a.updated_at
+		SELECT DISTINCT a.id, a.tenant_id, a.email, a.name, a.status, a.password_hash, a.created_at, a.updated_at, COALESCE(a.max_chats, 5), a.preferred_language
 		FROM agents a
 		INNER JOIN agent_project_roles apr ON a.id = apr.agent_id
 		WHERE a.tenant_id = $1 AND apr.role = 'tenant_admin' AND a.status = 'active'
@@ -259,7 +268,8 @@ func (r *agentRepository) GetTenantAdmins(ctx context.Context, tenantID uuid.UUI
 		var agent db.Agent
 		err := rows.Scan(
 			&agent.ID, &agent.TenantID, &agent.Email, &agent.Name,
-			&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt)
+			&agent.Status, &agent.PasswordHash, &agent.CreatedAt, &agent.UpdatedAt, &agent.MaxChats,
+			&agent.PreferredLanguage)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan agent: %w", err)
 		}
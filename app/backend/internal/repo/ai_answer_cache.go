@@ -0,0 +1,194 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pgvector/pgvector-go"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type AIAnswerCacheRepository struct {
+	db *sqlx.DB
+}
+
+func NewAIAnswerCacheRepository(db *sqlx.DB) *AIAnswerCacheRepository {
+	return &AIAnswerCacheRepository{db: db}
+}
+
+const aiAnswerCacheColumns = `id, tenant_id, project_id, profile_hash, question, embedding, answer, sources,
+		prompt_tokens, completion_tokens, hit_count, last_hit_at, rejected_at, created_at, expires_at`
+
+// Nearest retrieves the cached answer of a project and AI profile whose question is most similar to the
+// given embedding, or nil when there is none. Rejected and expired answers are left out.
+func (r *AIAnswerCacheRepository) Nearest(ctx context.Context, tenantID, projectID uuid.UUID, profileHash string, embedding pgvector.Vector) (*models.AIAnswerCacheEntry, error) {
+	var entry models.AIAnswerCacheEntry
+	query := `
+		SELECT ` + aiAnswerCacheColumns + `, 1 - (embedding <=> $4) AS similarity
+		FROM ai_answer_cache
+		WHERE tenant_id = $1 AND project_id = $2 AND profile_hash = $3
+			AND rejected_at IS NULL AND expires_at > NOW()
+		ORDER BY embedding <=> $4
+		LIMIT 1`
+
+	err := r.db.GetContext(ctx, &entry, query, tenantID, projectID, profileHash, embedding)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// SourceHashes returns the current content hash of knowledge sources by ID, scraped pages and document
+// chunks alike. Sources that no longer exist are missing from the result.
+func (r *AIAnswerCacheRepository) SourceHashes(ctx context.Context, sourceIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	hashes := map[uuid.UUID]string{}
+	if len(sourceIDs) == 0 {
+		return hashes, nil
+	}
+
+	ids := make([]string, len(sourceIDs))
+	for i, id := range sourceIDs {
+		ids[i] = id.String()
+	}
+
+	query := `
+		SELECT id, COALESCE(NULLIF(content_hash, ''), encode(sha256(convert_to(content, 'UTF8')), 'hex'))
+		FROM knowledge_scraped_pages
+		WHERE id = ANY($1::uuid[])
+		UNION ALL
+		SELECT id, encode(sha256(convert_to(content, 'UTF8')), 'hex')
+		FROM knowledge_chunks
+		WHERE id = ANY($1::uuid[])`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		hashes[id] = hash
+	}
+	return hashes, rows.Err()
+}
+
+// Create stores an answer for reuse
+func (r *AIAnswerCacheRepository) Create(ctx context.Context, entry *models.AIAnswerCacheEntry) error {
+	query := `
+		INSERT INTO ai_answer_cache (
+			tenant_id, project_id, profile_hash, question, embedding, answer, sources,
+			prompt_tokens, completion_tokens, created_at, expires_at
+		) VALUES (
+			:tenant_id, :project_id, :profile_hash, :question, :embedding, :answer, :sources,
+			:prompt_tokens, :completion_tokens, NOW(), :expires_at
+		)
+		RETURNING id, created_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, entry)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&entry.ID, &entry.CreatedAt)
+	}
+	return rows.Err()
+}
+
+// RecordHit counts a reuse of a cached answer
+func (r *AIAnswerCacheRepository) RecordHit(ctx context.Context, entryID uuid.UUID) error {
+	query := `UPDATE ai_answer_cache SET hit_count = hit_count + 1, last_hit_at = NOW() WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, entryID)
+	return err
+}
+
+// Delete removes a cached answer
+func (r *AIAnswerCacheRepository) Delete(ctx context.Context, entryID uuid.UUID) error {
+	query := `DELETE FROM ai_answer_cache WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, entryID)
+	return err
+}
+
+// DeleteProject removes the cached answers of a project and returns how many there were
+func (r *AIAnswerCacheRepository) DeleteProject(ctx context.Context, tenantID, projectID uuid.UUID) (int64, error) {
+	query := `DELETE FROM ai_answer_cache WHERE tenant_id = $1 AND project_id = $2`
+	result, err := r.db.ExecContext(ctx, query, tenantID, projectID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RejectLatestReply marks the cached answer behind the latest AI reply of a chat session as rejected, so
+// it is not reused. Replies that did not come from or go to the cache are left alone.
+func (r *AIAnswerCacheRepository) RejectLatestReply(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) error {
+	query := `
+		UPDATE ai_answer_cache SET rejected_at = NOW()
+		WHERE tenant_id = $1 AND project_id = $2 AND rejected_at IS NULL AND id = (
+			SELECT NULLIF(m.metadata->>'answer_cache_id', '')::uuid
+			FROM chat_messages m
+			WHERE m.tenant_id = $1 AND m.project_id = $2 AND m.session_id = $3 AND m.author_type = 'ai-agent'
+			ORDER BY m.seq DESC
+			LIMIT 1
+		)`
+	_, err := r.db.ExecContext(ctx, query, tenantID, projectID, sessionID)
+	return err
+}
+
+// RecordLookup counts a lookup of a project's answer cache for today, with the tokens a hit saved
+func (r *AIAnswerCacheRepository) RecordLookup(ctx context.Context, tenantID, projectID uuid.UUID, hit bool, tokensSaved int) error {
+	hits, misses := 0, 1
+	if hit {
+		hits, misses = 1, 0
+	}
+	query := `
+		INSERT INTO ai_answer_cache_stats (tenant_id, project_id, day, hits, misses, tokens_saved)
+		VALUES ($1, $2, CURRENT_DATE, $3, $4, $5)
+		ON CONFLICT (project_id, day) DO UPDATE SET
+			hits = ai_answer_cache_stats.hits + EXCLUDED.hits,
+			misses = ai_answer_cache_stats.misses + EXCLUDED.misses,
+			tokens_saved = ai_answer_cache_stats.tokens_saved + EXCLUDED.tokens_saved`
+	_, err := r.db.ExecContext(ctx, query, tenantID, projectID, hits, misses, tokensSaved)
+	return err
+}
+
+// Stats totals the lookups of a project's answer cache over its last days, today included, and counts
+// the answers it holds
+func (r *AIAnswerCacheRepository) Stats(ctx context.Context, tenantID, projectID uuid.UUID, days int) (*models.AIAnswerCacheStats, error) {
+	stats := &models.AIAnswerCacheStats{Days: days}
+	query := `
+		SELECT
+			COALESCE(SUM(hits), 0) AS hits,
+			COALESCE(SUM(misses), 0) AS misses,
+			COALESCE(SUM(tokens_saved), 0) AS tokens_saved
+		FROM ai_answer_cache_stats
+		WHERE tenant_id = $1 AND project_id = $2 AND day > CURRENT_DATE - $3::int`
+	if err := r.db.GetContext(ctx, stats, query, tenantID, projectID, days); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT
+			COUNT(*) FILTER (WHERE rejected_at IS NULL AND expires_at > NOW()) AS entries,
+			COUNT(*) FILTER (WHERE rejected_at IS NOT NULL) AS rejected_entries
+		FROM ai_answer_cache
+		WHERE tenant_id = $1 AND project_id = $2`
+	if err := r.db.GetContext(ctx, stats, query, tenantID, projectID); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,255 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type AIEvaluationRepository struct {
+	db *sqlx.DB
+}
+
+func NewAIEvaluationRepository(db *sqlx.DB) *AIEvaluationRepository {
+	return &AIEvaluationRepository{db: db}
+}
+
+const aiEvalCaseColumns = `id, tenant_id, project_id, question, expected_answer, expected_sources, origin,
+		origin_session_id, origin_message_id, rejected_answer, created_at, updated_at`
+
+const aiEvalRunColumns = `id, tenant_id, project_id, label, status, provider, model, case_count, failed_count,
+		retrieval_hit_rate, answer_similarity, citation_accuracy, prompt_tokens, completion_tokens, error,
+		started_by, created_at, completed_at`
+
+const aiEvalResultColumns = `id, run_id, case_id, question, expected_answer, expected_sources, answer,
+		retrieved_sources, cited_sources, retrieval_hit, answer_similarity, citation_correct, handoff, blocked,
+		error, latency_ms, created_at`
+
+// CreateCase stores a new evaluation case. Cases from a chat message that already has one are not
+// stored again, and false is returned for them.
+func (r *AIEvaluationRepository) CreateCase(ctx context.Context, evalCase *models.AIEvalCase) (bool, error) {
+	query := `
+		INSERT INTO ai_eval_cases (
+			tenant_id, project_id, question, expected_answer, expected_sources, origin,
+			origin_session_id, origin_message_id, rejected_answer, created_at, updated_at
+		) VALUES (
+			:tenant_id, :project_id, :question, :expected_answer, :expected_sources, :origin,
+			:origin_session_id, :origin_message_id, :rejected_answer, NOW(), NOW()
+		)
+		ON CONFLICT (project_id, origin_message_id) WHERE origin_message_id IS NOT NULL DO NOTHING
+		RETURNING id, created_at, updated_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, evalCase)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	created := false
+	if rows.Next() {
+		if err := rows.Scan(&evalCase.ID, &evalCase.CreatedAt, &evalCase.UpdatedAt); err != nil {
+			return false, err
+		}
+		created = true
+	}
+	return created, rows.Err()
+}
+
+// GetCase retrieves an evaluation case of a project, or nil when it does not exist
+func (r *AIEvaluationRepository) GetCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) (*models.AIEvalCase, error) {
+	var evalCase models.AIEvalCase
+	query := `
+		SELECT ` + aiEvalCaseColumns + `
+		FROM ai_eval_cases
+		WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
+
+	err := r.db.GetContext(ctx, &evalCase, query, tenantID, projectID, caseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &evalCase, nil
+}
+
+// ListCases retrieves the evaluation suite of a project, oldest case first
+func (r *AIEvaluationRepository) ListCases(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIEvalCase, error) {
+	cases := []*models.AIEvalCase{}
+	query := `
+		SELECT ` + aiEvalCaseColumns + `
+		FROM ai_eval_cases
+		WHERE tenant_id = $1 AND project_id = $2
+		ORDER BY created_at ASC, id ASC`
+
+	err := r.db.SelectContext(ctx, &cases, query, tenantID, projectID)
+	return cases, err
+}
+
+// UpdateCase saves changes to the question and expectations of an evaluation case
+func (r *AIEvaluationRepository) UpdateCase(ctx context.Context, evalCase *models.AIEvalCase) error {
+	query := `
+		UPDATE ai_eval_cases SET
+			question = :question,
+			expected_answer = :expected_answer,
+			expected_sources = :expected_sources,
+			updated_at = NOW()
+		WHERE tenant_id = :tenant_id AND project_id = :project_id AND id = :id`
+
+	_, err := r.db.NamedExecContext(ctx, query, evalCase)
+	return err
+}
+
+// DeleteCase removes an evaluation case; results of past runs keep their copy of it
+func (r *AIEvaluationRepository) DeleteCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) error {
+	query := `DELETE FROM ai_eval_cases WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
+	_, err := r.db.ExecContext(ctx, query, tenantID, projectID, caseID)
+	return err
+}
+
+// ThumbsDownReplies retrieves the AI replies of a project that visitors gave a thumbs down since a time,
+// newest first, with the visitor question each one answered. A thumbs down is the 👎 quick reaction of
+// the chat widget, which rates the AI reply before it.
+func (r *AIEvaluationRepository) ThumbsDownReplies(ctx context.Context, tenantID, projectID uuid.UUID, since time.Time, limit int) ([]*models.AIThumbsDownReply, error) {
+	replies := []*models.AIThumbsDownReply{}
+	query := `
+		SELECT d.id AS feedback_message_id, d.session_id, q.content AS question, a.content AS answer
+		FROM chat_messages d
+		JOIN LATERAL (
+			SELECT m.seq, m.content FROM chat_messages m
+			WHERE m.session_id = d.session_id AND m.seq < d.seq AND m.author_type = 'ai-agent'
+			ORDER BY m.seq DESC LIMIT 1
+		) a ON TRUE
+		JOIN LATERAL (
+			SELECT m.content FROM chat_messages m
+			WHERE m.session_id = d.session_id AND m.seq < a.seq AND m.author_type = 'visitor'
+				AND btrim(m.content) NOT IN ('👍', '👎')
+			ORDER BY m.seq DESC LIMIT 1
+		) q ON TRUE
+		WHERE d.tenant_id = $1 AND d.project_id = $2 AND d.author_type = 'visitor'
+			AND btrim(d.content) = '👎' AND d.created_at >= $3
+		ORDER BY d.created_at DESC
+		LIMIT $4`
+
+	err := r.db.SelectContext(ctx, &replies, query, tenantID, projectID, since, limit)
+	return replies, err
+}
+
+// CreateRun stores a new evaluation run
+func (r *AIEvaluationRepository) CreateRun(ctx context.Context, run *models.AIEvalRun) error {
+	query := `
+		INSERT INTO ai_eval_runs (tenant_id, project_id, label, status, provider, model, case_count, started_by, created_at)
+		VALUES (:tenant_id, :project_id, :label, :status, :provider, :model, :case_count, :started_by, NOW())
+		RETURNING id, created_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, run)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&run.ID, &run.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// FinishRun saves the status, scores and token counts of an evaluation run
+func (r *AIEvaluationRepository) FinishRun(ctx context.Context, run *models.AIEvalRun) error {
+	query := `
+		UPDATE ai_eval_runs SET
+			status = :status,
+			failed_count = :failed_count,
+			retrieval_hit_rate = :retrieval_hit_rate,
+			answer_similarity = :answer_similarity,
+			citation_accuracy = :citation_accuracy,
+			prompt_tokens = :prompt_tokens,
+			completion_tokens = :completion_tokens,
+			error = :error,
+			completed_at = :completed_at
+		WHERE tenant_id = :tenant_id AND project_id = :project_id AND id = :id`
+
+	_, err := r.db.NamedExecContext(ctx, query, run)
+	return err
+}
+
+// GetRun retrieves an evaluation run of a project, or nil when it does not exist
+func (r *AIEvaluationRepository) GetRun(ctx context.Context, tenantID, projectID, runID uuid.UUID) (*models.AIEvalRun, error) {
+	var run models.AIEvalRun
+	query := `
+		SELECT ` + aiEvalRunColumns + `
+		FROM ai_eval_runs
+		WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
+
+	err := r.db.GetContext(ctx, &run, query, tenantID, projectID, runID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ListRuns retrieves the evaluation runs of a project, newest first
+func (r *AIEvaluationRepository) ListRuns(ctx context.Context, tenantID, projectID uuid.UUID, limit int) ([]*models.AIEvalRun, error) {
+	runs := []*models.AIEvalRun{}
+	query := `
+		SELECT ` + aiEvalRunColumns + `
+		FROM ai_eval_runs
+		WHERE tenant_id = $1 AND project_id = $2
+		ORDER BY created_at DESC
+		LIMIT $3`
+
+	err := r.db.SelectContext(ctx, &runs, query, tenantID, projectID, limit)
+	return runs, err
+}
+
+// CreateResult stores the result of a case in an evaluation run
+func (r *AIEvaluationRepository) CreateResult(ctx context.Context, result *models.AIEvalResult) error {
+	query := `
+		INSERT INTO ai_eval_results (
+			run_id, case_id, question, expected_answer, expected_sources, answer, retrieved_sources,
+			cited_sources, retrieval_hit, answer_similarity, citation_correct, handoff, blocked, error,
+			latency_ms, created_at
+		) VALUES (
+			:run_id, :case_id, :question, :expected_answer, :expected_sources, :answer, :retrieved_sources,
+			:cited_sources, :retrieval_hit, :answer_similarity, :citation_correct, :handoff, :blocked, :error,
+			:latency_ms, NOW()
+		)
+		RETURNING id, created_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, result)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&result.ID, &result.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListResults retrieves the results of an evaluation run in the order its cases ran
+func (r *AIEvaluationRepository) ListResults(ctx context.Context, runID uuid.UUID) ([]*models.AIEvalResult, error) {
+	results := []*models.AIEvalResult{}
+	query := `
+		SELECT ` + aiEvalResultColumns + `
+		FROM ai_eval_results
+		WHERE run_id = $1
+		ORDER BY created_at ASC, id ASC`
+
+	err := r.db.SelectContext(ctx, &results, query, runID)
+	return results, err
+}
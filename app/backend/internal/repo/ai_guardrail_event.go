@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type AIGuardrailEventRepository struct {
+	db *sqlx.DB
+}
+
+func NewAIGuardrailEventRepository(db *sqlx.DB) *AIGuardrailEventRepository {
+	return &AIGuardrailEventRepository{db: db}
+}
+
+// Create stores a guardrail event
+func (r *AIGuardrailEventRepository) Create(ctx context.Context, event *models.AIGuardrailEvent) error {
+	if event.Details == nil {
+		event.Details = models.JSONMap{}
+	}
+	query := `
+		INSERT INTO ai_guardrail_events (tenant_id, project_id, session_id, ticket_id, stage, kind, action, details, created_at)
+		VALUES (:tenant_id, :project_id, :session_id, :ticket_id, :stage, :kind, :action, :details, NOW())
+		RETURNING id, created_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&event.ID, &event.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// List retrieves a project's guardrail events, newest first
+func (r *AIGuardrailEventRepository) List(ctx context.Context, tenantID, projectID uuid.UUID, filter models.AIGuardrailEventFilter) ([]*models.AIGuardrailEvent, error) {
+	conditions := []string{"tenant_id = $1", "project_id = $2"}
+	args := []interface{}{tenantID, projectID}
+
+	if filter.Stage != "" {
+		args = append(args, filter.Stage)
+		conditions = append(conditions, fmt.Sprintf("stage = $%d", len(args)))
+	}
+	if filter.Kind != "" {
+		args = append(args, filter.Kind)
+		conditions = append(conditions, fmt.Sprintf("kind = $%d", len(args)))
+	}
+	if filter.SessionID != nil {
+		args = append(args, *filter.SessionID)
+		conditions = append(conditions, fmt.Sprintf("session_id = $%d", len(args)))
+	}
+	if filter.Since != nil {
+		args = append(args, *filter.Since)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	args = append(args, filter.Limit)
+
+	query := fmt.Sprintf(`
+		SELECT id, tenant_id, project_id, session_id, ticket_id, stage, kind, action, details, created_at
+		FROM ai_guardrail_events
+		WHERE %s
+		ORDER BY created_at DESC
+		LIMIT $%d`, strings.Join(conditions, " AND "), len(args))
+
+	events := []*models.AIGuardrailEvent{}
+	err := r.db.SelectContext(ctx, &events, query, args...)
+	return events, err
+}
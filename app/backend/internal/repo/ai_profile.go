@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type AIProfileRepository struct {
+	db *sqlx.DB
+}
+
+func NewAIProfileRepository(db *sqlx.DB) *AIProfileRepository {
+	return &AIProfileRepository{db: db}
+}
+
+const aiProfileColumns = `id, tenant_id, project_id, widget_id, enabled, persona_name, system_prompt, allowed_topics,
+		forbidden_phrases, handoff_keywords, provider, model, temperature, max_tokens, created_at, updated_at`
+
+// Create stores a new AI profile
+func (r *AIProfileRepository) Create(ctx context.Context, profile *models.AIProfile) error {
+	query := `
+		INSERT INTO ai_profiles (
+			tenant_id, project_id, widget_id, enabled, persona_name, system_prompt, allowed_topics,
+			forbidden_phrases, handoff_keywords, provider, model, temperature, max_tokens, created_at, updated_at
+		) VALUES (
+			:tenant_id, :project_id, :widget_id, :enabled, :persona_name, :system_prompt, :allowed_topics,
+			:forbidden_phrases, :handoff_keywords, :provider, :model, :temperature, :max_tokens, NOW(), NOW()
+		)
+		RETURNING id, created_at, updated_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, profile)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&profile.ID, &profile.CreatedAt, &profile.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetByID retrieves an AI profile of a project, or nil when it does not exist
+func (r *AIProfileRepository) GetByID(ctx context.Context, tenantID, projectID, profileID uuid.UUID) (*models.AIProfile, error) {
+	var profile models.AIProfile
+	query := `
+		SELECT ` + aiProfileColumns + `
+		FROM ai_profiles
+		WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
+
+	err := r.db.GetContext(ctx, &profile, query, tenantID, projectID, profileID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// ListByProject retrieves the AI profiles of a project, the project-wide profile first
+func (r *AIProfileRepository) ListByProject(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIProfile, error) {
+	profiles := []*models.AIProfile{}
+	query := `
+		SELECT ` + aiProfileColumns + `
+		FROM ai_profiles
+		WHERE tenant_id = $1 AND project_id = $2
+		ORDER BY widget_id NULLS FIRST, created_at ASC`
+
+	err := r.db.SelectContext(ctx, &profiles, query, tenantID, projectID)
+	return profiles, err
+}
+
+// ListEffective retrieves the enabled profiles that apply to a widget of a project, the project-wide
+// profile first. Without a widget only the project-wide profile applies.
+func (r *AIProfileRepository) ListEffective(ctx context.Context, tenantID, projectID uuid.UUID, widgetID *uuid.UUID) ([]*models.AIProfile, error) {
+	profiles := []*models.AIProfile{}
+	query := `
+		SELECT ` + aiProfileColumns + `
+		FROM ai_profiles
+		WHERE tenant_id = $1 AND project_id = $2 AND enabled = TRUE
+			AND (widget_id IS NULL OR widget_id = $3)
+		ORDER BY widget_id NULLS FIRST`
+
+	err := r.db.SelectContext(ctx, &profiles, query, tenantID, projectID, widgetID)
+	return profiles, err
+}
+
+// Update saves changes to an AI profile
+func (r *AIProfileRepository) Update(ctx context.Context, profile *models.AIProfile) error {
+	query := `
+		UPDATE ai_profiles SET
+			enabled = :enabled,
+			persona_name = :persona_name,
+			system_prompt = :system_prompt,
+			allowed_topics = :allowed_topics,
+			forbidden_phrases = :forbidden_phrases,
+			handoff_keywords = :handoff_keywords,
+			provider = :provider,
+			model = :model,
+			temperature = :temperature,
+			max_tokens = :max_tokens,
+			updated_at = NOW()
+		WHERE tenant_id = :tenant_id AND project_id = :project_id AND id = :id`
+
+	_, err := r.db.NamedExecContext(ctx, query, profile)
+	return err
+}
+
+// Delete removes an AI profile
+func (r *AIProfileRepository) Delete(ctx context.Context, tenantID, projectID, profileID uuid.UUID) error {
+	query := `DELETE FROM ai_profiles WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
+	_, err := r.db.ExecContext(ctx, query, tenantID, projectID, profileID)
+	return err
+}
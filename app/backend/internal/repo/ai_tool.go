@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type AIToolRepository struct {
+	db *sqlx.DB
+}
+
+func NewAIToolRepository(db *sqlx.DB) *AIToolRepository {
+	return &AIToolRepository{db: db}
+}
+
+const aiToolColumns = `id, tenant_id, project_id, name, description, parameters, method, url, headers_encrypted,
+		header_names, timeout_ms, max_response_bytes, requires_verified_visitor, enabled, created_at, updated_at`
+
+// Create stores a new AI tool
+func (r *AIToolRepository) Create(ctx context.Context, tool *models.AITool) error {
+	query := `
+		INSERT INTO ai_tools (
+			tenant_id, project_id, name, description, parameters, method, url, headers_encrypted,
+			header_names, timeout_ms, max_response_bytes, requires_verified_visitor, enabled, created_at, updated_at
+		) VALUES (
+			:tenant_id, :project_id, :name, :description, :parameters, :method, :url, :headers_encrypted,
+			:header_names, :timeout_ms, :max_response_bytes, :requires_verified_visitor, :enabled, NOW(), NOW()
+		)
+		RETURNING id, created_at, updated_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, tool)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&tool.ID, &tool.CreatedAt, &tool.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetByID retrieves an AI tool of a project, or nil when it does not exist
+func (r *AIToolRepository) GetByID(ctx context.Context, tenantID, projectID, toolID uuid.UUID) (*models.AITool, error) {
+	var tool models.AITool
+	query := `
+		SELECT ` + aiToolColumns + `
+		FROM ai_tools
+		WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
+
+	err := r.db.GetContext(ctx, &tool, query, tenantID, projectID, toolID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &tool, nil
+}
+
+// ListByProject retrieves the AI tools of a project by name
+func (r *AIToolRepository) ListByProject(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AITool, error) {
+	tools := []*models.AITool{}
+	query := `
+		SELECT ` + aiToolColumns + `
+		FROM ai_tools
+		WHERE tenant_id = $1 AND project_id = $2
+		ORDER BY name`
+
+	err := r.db.SelectContext(ctx, &tools, query, tenantID, projectID)
+	return tools, err
+}
+
+// ListEnabled retrieves the AI tools the assistant of a project may call
+func (r *AIToolRepository) ListEnabled(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AITool, error) {
+	tools := []*models.AITool{}
+	query := `
+		SELECT ` + aiToolColumns + `
+		FROM ai_tools
+		WHERE tenant_id = $1 AND project_id = $2 AND enabled = TRUE
+		ORDER BY name`
+
+	err := r.db.SelectContext(ctx, &tools, query, tenantID, projectID)
+	return tools, err
+}
+
+// Update saves changes to an AI tool
+func (r *AIToolRepository) Update(ctx context.Context, tool *models.AITool) error {
+	query := `
+		UPDATE ai_tools SET
+			name = :name,
+			description = :description,
+			parameters = :parameters,
+			method = :method,
+			url = :url,
+			headers_encrypted = :headers_encrypted,
+			header_names = :header_names,
+			timeout_ms = :timeout_ms,
+			max_response_bytes = :max_response_bytes,
+			requires_verified_visitor = :requires_verified_visitor,
+			enabled = :enabled,
+			updated_at = NOW()
+		WHERE tenant_id = :tenant_id AND project_id = :project_id AND id = :id`
+
+	_, err := r.db.NamedExecContext(ctx, query, tool)
+	return err
+}
+
+// Delete removes an AI tool
+func (r *AIToolRepository) Delete(ctx context.Context, tenantID, projectID, toolID uuid.UUID) error {
+	query := `DELETE FROM ai_tools WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
+	_, err := r.db.ExecContext(ctx, query, tenantID, projectID, toolID)
+	return err
+}
@@ -30,9 +30,9 @@ func (r *apiKeyRepository) Create(ctx context.Context, apiKey *db.ApiKey) error
 	query := `
 		INSERT INTO api_keys (
 			id, tenant_id, project_id, name, key_hash, key_prefix, 
-			scopes, expires_at, is_active, agent_id, created_at, updated_at
+			scopes, allowed_cidrs, expires_at, is_active, agent_id, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
 		)`
 
 	_, err := r.db.ExecContext(ctx, query,
@@ -43,6 +43,7 @@ func (r *apiKeyRepository) Create(ctx context.Context, apiKey *db.ApiKey) error
 		apiKey.KeyHash,
 		apiKey.KeyPrefix,
 		pq.Array(apiKey.Scopes),
+		pq.Array(apiKey.AllowedCIDRs),
 		apiKey.ExpiresAt,
 		apiKey.IsActive,
 		apiKey.AgentID,
@@ -57,7 +58,7 @@ func (r *apiKeyRepository) Create(ctx context.Context, apiKey *db.ApiKey) error
 func (r *apiKeyRepository) GetByID(ctx context.Context, tenantID uuid.UUID, keyID uuid.UUID) (*db.ApiKey, error) {
 	query := `
 		SELECT id, tenant_id, project_id, name, key_hash, key_prefix,
-			   scopes, last_used_at, expires_at, is_active, agent_id, created_at, updated_at
+			   scopes, allowed_cidrs, last_used_at, expires_at, is_active, agent_id, created_at, updated_at
 		FROM api_keys 
 		WHERE id = $1 AND tenant_id = $2`
 
@@ -77,7 +78,7 @@ func (r *apiKeyRepository) GetByID(ctx context.Context, tenantID uuid.UUID, keyI
 func (r *apiKeyRepository) GetByHash(ctx context.Context, keyHash string) (*db.ApiKey, error) {
 	query := `
 		SELECT id, tenant_id, project_id, name, key_hash, key_prefix,
-			   scopes, last_used_at, expires_at, is_active, agent_id, created_at, updated_at
+			   scopes, allowed_cidrs, last_used_at, expires_at, is_active, agent_id, created_at, updated_at
 		FROM api_keys 
 		WHERE key_hash = $1 AND is_active = true 
 		AND (expires_at IS NULL OR expires_at > NOW())`
@@ -101,7 +102,7 @@ func (r *apiKeyRepository) List(ctx context.Context, tenantID uuid.UUID, project
 
 	query = `
 		SELECT id, tenant_id, project_id, name, key_hash, key_prefix,
-				scopes, last_used_at, expires_at, is_active, agent_id, created_at, updated_at
+				scopes, allowed_cidrs, last_used_at, expires_at, is_active, agent_id, created_at, updated_at
 		FROM api_keys 
 		WHERE tenant_id = $1 AND project_id = $2 
 		ORDER BY created_at DESC`
@@ -119,14 +120,15 @@ func (r *apiKeyRepository) List(ctx context.Context, tenantID uuid.UUID, project
 func (r *apiKeyRepository) Update(ctx context.Context, apiKey *db.ApiKey) error {
 	query := `
 		UPDATE api_keys 
-		SET name = $1, scopes = $2, expires_at = $3, is_active = $4, updated_at = $5
-		WHERE id = $6 AND tenant_id = $7`
+		SET name = $1, scopes = $2, allowed_cidrs = $3, expires_at = $4, is_active = $5, updated_at = $6
+		WHERE id = $7 AND tenant_id = $8`
 
 	apiKey.UpdatedAt = time.Now()
 
 	_, err := r.db.ExecContext(ctx, query,
 		apiKey.Name,
 		pq.Array(apiKey.Scopes),
+		pq.Array(apiKey.AllowedCIDRs),
 		apiKey.ExpiresAt,
 		apiKey.IsActive,
 		apiKey.UpdatedAt,
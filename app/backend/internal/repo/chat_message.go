@@ -19,27 +19,45 @@ func NewChatMessageRepo(db *sqlx.DB) *ChatMessageRepo {
 	return &ChatMessageRepo{db: db}
 }
 
-// CreateChatMessage creates a new chat message
+// CreateChatMessage creates a new chat message and gives it the next sequence number of its session.
+// Bumping the session counter locks the session row, so messages of a session are numbered in the order
+// they are stored.
 func (r *ChatMessageRepo) CreateChatMessage(ctx context.Context, message *models.ChatMessage) error {
 	query := `
+		WITH next AS (
+			UPDATE chat_sessions SET last_seq = last_seq + 1
+			WHERE id = :session_id
+			RETURNING last_seq
+		)
 		INSERT INTO chat_messages (
-			id, tenant_id, project_id, session_id, message_type, content,
+			id, tenant_id, project_id, session_id, seq, message_type, content,
 			author_type, author_id, author_name, metadata, is_private,
 			read_by_visitor, read_by_agent, read_at, created_at
 		) VALUES (
-			:id, :tenant_id, :project_id, :session_id, :message_type, :content,
+			:id, :tenant_id, :project_id, :session_id, (SELECT last_seq FROM next), :message_type, :content,
 			:author_type, :author_id, :author_name, :metadata, :is_private,
 			:read_by_visitor, :read_by_agent, :read_at, :created_at
 		)
+		RETURNING seq
 	`
-	_, err := r.db.NamedExecContext(ctx, query, message)
-	return err
+	rows, err := r.db.NamedQueryContext(ctx, query, message)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&message.Seq); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 // GetChatMessage gets a chat message by ID
 func (r *ChatMessageRepo) GetChatMessage(ctx context.Context, tenantID, projectID, messageID uuid.UUID) (*models.ChatMessage, error) {
 	query := `
-		SELECT id, tenant_id, project_id, session_id, message_type, content,
+		SELECT id, tenant_id, project_id, session_id, seq, message_type, content,
 			   author_type, author_id, author_name, metadata, is_private,
 			   read_by_visitor, read_by_agent, read_at, created_at
 		FROM chat_messages
@@ -60,7 +78,7 @@ func (r *ChatMessageRepo) GetChatMessage(ctx context.Context, tenantID, projectI
 // ListChatMessages lists messages for a chat session
 func (r *ChatMessageRepo) ListChatMessages(ctx context.Context, tenantID, projectID, sessionID uuid.UUID, includePrivate bool) ([]*models.ChatMessage, error) {
 	query := `
-		SELECT id, tenant_id, project_id, session_id, message_type, content,
+		SELECT id, tenant_id, project_id, session_id, seq, message_type, content,
 			   author_type, author_id, author_name, metadata, is_private,
 			   read_by_visitor, read_by_agent, read_at, created_at
 		FROM chat_messages
@@ -73,7 +91,7 @@ func (r *ChatMessageRepo) ListChatMessages(ctx context.Context, tenantID, projec
 		query += " AND is_private = false"
 	}
 
-	query += " ORDER BY created_at ASC"
+	query += " ORDER BY seq ASC"
 
 	var messages []*models.ChatMessage
 	err := r.db.SelectContext(ctx, &messages, query, args...)
@@ -86,12 +104,12 @@ func (r *ChatMessageRepo) ListChatMessages(ctx context.Context, tenantID, projec
 // ListChatMessagesForSession lists messages for a session (public access via token)
 func (r *ChatMessageRepo) ListChatMessagesForSession(ctx context.Context, sessionID uuid.UUID) ([]*models.ChatMessage, error) {
 	query := `
-		SELECT id, tenant_id, project_id, session_id, message_type, content,
+		SELECT id, tenant_id, project_id, session_id, seq, message_type, content,
 			   author_type, author_id, author_name, metadata, is_private,
 			   read_by_visitor, read_by_agent, read_at, created_at
 		FROM chat_messages
 		WHERE session_id = $1 AND is_private = false
-		ORDER BY created_at ASC
+		ORDER BY seq ASC
 	`
 
 	var messages []*models.ChatMessage
@@ -102,6 +120,26 @@ func (r *ChatMessageRepo) ListChatMessagesForSession(ctx context.Context, sessio
 	return messages, nil
 }
 
+// ListChatMessagesAfter lists up to limit messages of a session with a sequence number above afterSeq, oldest first
+func (r *ChatMessageRepo) ListChatMessagesAfter(ctx context.Context, sessionID uuid.UUID, afterSeq int64, includePrivate bool, limit int) ([]*models.ChatMessage, error) {
+	query := `
+		SELECT id, tenant_id, project_id, session_id, seq, message_type, content,
+			   author_type, author_id, author_name, metadata, is_private,
+			   read_by_visitor, read_by_agent, read_at, created_at
+		FROM chat_messages
+		WHERE session_id = $1 AND seq > $2 AND (is_private = false OR $3)
+		ORDER BY seq ASC
+		LIMIT $4
+	`
+
+	var messages []*models.ChatMessage
+	err := r.db.SelectContext(ctx, &messages, query, sessionID, afterSeq, includePrivate, limit)
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
 // MarkMessagesAsRead marks messages as read by visitor or agent
 func (r *ChatMessageRepo) MarkAgentMessagesAsRead(ctx context.Context, tenantID, projectID, sessionID, messageID uuid.UUID, readerType string) error {
 	var query string
@@ -180,7 +218,7 @@ func (r *ChatMessageRepo) GetUnreadMessageCount(ctx context.Context, sessionID u
 // GetRecentMessages gets recent messages across all active sessions for an agent
 func (r *ChatMessageRepo) GetRecentMessages(ctx context.Context, tenantID, agentID uuid.UUID, limit int) ([]*models.ChatMessage, error) {
 	query := `
-		SELECT cm.id, cm.tenant_id, cm.project_id, cm.session_id, cm.message_type, cm.content,
+		SELECT cm.id, cm.tenant_id, cm.project_id, cm.session_id, cm.seq, cm.message_type, cm.content,
 			   cm.author_type, cm.author_id, cm.author_name, cm.metadata, cm.is_private,
 			   cm.read_by_visitor, cm.read_by_agent, cm.read_at, cm.created_at
 		FROM chat_messages cm
@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type ChatParticipantRepo struct {
+	db *sqlx.DB
+}
+
+func NewChatParticipantRepo(db *sqlx.DB) *ChatParticipantRepo {
+	return &ChatParticipantRepo{db: db}
+}
+
+// AddParticipant records an agent joining a session; an agent who left earlier rejoins with the new role
+func (r *ChatParticipantRepo) AddParticipant(ctx context.Context, participant *models.ChatSessionParticipant) error {
+	query := `
+		INSERT INTO chat_session_participants (session_id, agent_id, tenant_id, role, joined_at, left_at)
+		VALUES (:session_id, :agent_id, :tenant_id, :role, :joined_at, NULL)
+		ON CONFLICT (session_id, agent_id) DO UPDATE SET
+			role = EXCLUDED.role,
+			joined_at = EXCLUDED.joined_at,
+			left_at = NULL
+	`
+	_, err := r.db.NamedExecContext(ctx, query, participant)
+	return err
+}
+
+// RemoveParticipant marks an agent as having left a session; it reports false when the agent was not in it
+func (r *ChatParticipantRepo) RemoveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE chat_session_participants SET left_at = NOW()
+		WHERE session_id = $1 AND agent_id = $2 AND left_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, sessionID, agentID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// RemoveAllParticipants marks every agent still in a session as having left
+func (r *ChatParticipantRepo) RemoveAllParticipants(ctx context.Context, sessionID uuid.UUID) error {
+	query := `UPDATE chat_session_participants SET left_at = NOW() WHERE session_id = $1 AND left_at IS NULL`
+	_, err := r.db.ExecContext(ctx, query, sessionID)
+	return err
+}
+
+// GetActiveParticipant gets an agent's current participation in a session
+func (r *ChatParticipantRepo) GetActiveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (*models.ChatSessionParticipant, error) {
+	query := `
+		SELECT p.session_id, p.agent_id, p.tenant_id, p.role, p.joined_at, p.left_at,
+			   a.name AS agent_name, a.email AS agent_email
+		FROM chat_session_participants p
+		JOIN agents a ON a.id = p.agent_id
+		WHERE p.session_id = $1 AND p.agent_id = $2 AND p.left_at IS NULL
+	`
+	var participant models.ChatSessionParticipant
+	err := r.db.GetContext(ctx, &participant, query, sessionID, agentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &participant, nil
+}
+
+// ListActiveParticipants lists the agents currently in a session, in the order they joined
+func (r *ChatParticipantRepo) ListActiveParticipants(ctx context.Context, tenantID, sessionID uuid.UUID) ([]*models.ChatSessionParticipant, error) {
+	query := `
+		SELECT p.session_id, p.agent_id, p.tenant_id, p.role, p.joined_at, p.left_at,
+			   a.name AS agent_name, a.email AS agent_email
+		FROM chat_session_participants p
+		JOIN agents a ON a.id = p.agent_id
+		WHERE p.tenant_id = $1 AND p.session_id = $2 AND p.left_at IS NULL
+		ORDER BY p.joined_at ASC
+	`
+	var participants []*models.ChatSessionParticipant
+	err := r.db.SelectContext(ctx, &participants, query, tenantID, sessionID)
+	return participants, err
+}
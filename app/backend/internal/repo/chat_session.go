@@ -8,7 +8,9 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 
+	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/logger"
 	"github.com/bareuptime/tms/internal/models"
 )
@@ -33,12 +35,12 @@ func (r *ChatSessionRepo) CreateChatSession(ctx context.Context, session *models
 			id, tenant_id, project_id, widget_id, customer_id, ticket_id,
 			status, visitor_info, assigned_agent_id, assigned_at, started_at, ended_at,
 			last_activity_at, created_at, updated_at, client_session_id,
-			slack_thread_ts, slack_channel_id
+			slack_thread_ts, slack_channel_id, identity_verified
 		) VALUES (
 			:id, :tenant_id, :project_id, :widget_id, :customer_id, :ticket_id,
 			:status, :visitor_info, :assigned_agent_id, :assigned_at, :started_at, :ended_at,
 			:last_activity_at, :created_at, :updated_at, :client_session_id,
-			:slack_thread_ts, :slack_channel_id
+			:slack_thread_ts, :slack_channel_id, :identity_verified
 		)
 	`
 	_, err := r.db.NamedExecContext(ctx, query, session)
@@ -51,7 +53,7 @@ func (r *ChatSessionRepo) GetChatSession(ctx context.Context, tenantID, projectI
 		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
 			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
 			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
-			   cs.slack_thread_ts, cs.slack_channel_id,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified,
 			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
 			   cw.name as widget_name, cw.use_ai as use_ai
 		FROM chat_sessions cs
@@ -78,7 +80,7 @@ func (r *ChatSessionRepo) GetChatSessionByID(ctx context.Context, tenantID, sess
 		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
 			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
 			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
-			   cs.slack_thread_ts, cs.slack_channel_id,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified,
 			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
 			   cw.name as widget_name, cw.use_ai as use_ai
 		FROM chat_sessions cs
@@ -105,7 +107,7 @@ func (r *ChatSessionRepo) GetChatSessionByClientSessionID(ctx context.Context, c
 		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
 			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
 			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
-			   cs.slack_thread_ts, cs.slack_channel_id,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified,
 			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
 			   cw.name as widget_name, cw.use_ai as use_ai
 		FROM chat_sessions cs
@@ -132,7 +134,7 @@ func (r *ChatSessionRepo) ListChatSessions(ctx context.Context, tenantID, projec
 		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
 			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
 			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
-			   cs.slack_thread_ts, cs.slack_channel_id,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified,
 			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
 			   cw.name as widget_name, cw.use_ai as use_ai
 		FROM chat_sessions cs
@@ -201,13 +203,278 @@ func (r *ChatSessionRepo) UpdateChatSession(ctx context.Context, session *models
 	return err
 }
 
-// UpdateLastActivity updates the last activity timestamp for a session
+// UpdateLastActivity updates the last activity timestamp for a session and re-arms its idle warning
 func (r *ChatSessionRepo) UpdateLastActivity(ctx context.Context, sessionID uuid.UUID) error {
-	query := `UPDATE chat_sessions SET last_activity_at = NOW() WHERE id = $1`
+	query := `UPDATE chat_sessions SET last_activity_at = NOW(), idle_warned_at = NULL WHERE id = $1`
 	_, err := r.db.ExecContext(ctx, query, sessionID)
 	return err
 }
 
+const idleChatSessionColumns = `
+		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
+			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
+			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified, cs.idle_warned_at,
+			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
+			   cw.name as widget_name, cw.use_ai as use_ai,
+			   cw.idle_warning_minutes, cw.idle_timeout_minutes, cw.send_transcript, cw.transcript_archive_email
+		FROM chat_sessions cs
+		JOIN chat_widgets cw ON cs.widget_id = cw.id
+		LEFT JOIN agents a ON cs.assigned_agent_id = a.id
+		LEFT JOIN customers c ON cs.customer_id = c.id
+`
+
+// ListSessionsToWarn lists active sessions that have been idle past their widget's warning threshold
+// and have not been warned yet. Sessions that will time out before the warning is due are skipped.
+func (r *ChatSessionRepo) ListSessionsToWarn(ctx context.Context, now time.Time, limit int) ([]*models.IdleChatSession, error) {
+	query := idleChatSessionColumns + `
+		WHERE cs.status = 'active'
+		  AND cs.idle_warned_at IS NULL
+		  AND cw.idle_warning_minutes > 0
+		  AND (cw.idle_timeout_minutes = 0 OR cw.idle_warning_minutes < cw.idle_timeout_minutes)
+		  AND cs.last_activity_at <= $1 - make_interval(mins => cw.idle_warning_minutes)
+		ORDER BY cs.last_activity_at ASC
+		LIMIT $2
+	`
+
+	var sessions []*models.IdleChatSession
+	if err := r.db.SelectContext(ctx, &sessions, query, now, limit); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// ListSessionsToClose lists active sessions that have been idle past their widget's timeout
+func (r *ChatSessionRepo) ListSessionsToClose(ctx context.Context, now time.Time, limit int) ([]*models.IdleChatSession, error) {
+	query := idleChatSessionColumns + `
+		WHERE cs.status = 'active'
+		  AND cw.idle_timeout_minutes > 0
+		  AND cs.last_activity_at <= $1 - make_interval(mins => cw.idle_timeout_minutes)
+		ORDER BY cs.last_activity_at ASC
+		LIMIT $2
+	`
+
+	var sessions []*models.IdleChatSession
+	if err := r.db.SelectContext(ctx, &sessions, query, now, limit); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// MarkIdleWarned records that the idle warning was sent, unless the session saw activity meanwhile
+func (r *ChatSessionRepo) MarkIdleWarned(ctx context.Context, sessionID uuid.UUID, lastActivityAt, warnedAt time.Time) (bool, error) {
+	query := `
+		UPDATE chat_sessions SET idle_warned_at = $1
+		WHERE id = $2 AND status = 'active' AND idle_warned_at IS NULL AND last_activity_at = $3
+	`
+	result, err := r.db.ExecContext(ctx, query, warnedAt, sessionID, lastActivityAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// CloseIdleSession ends a session for inactivity, unless it saw activity or was ended meanwhile
+func (r *ChatSessionRepo) CloseIdleSession(ctx context.Context, sessionID uuid.UUID, lastActivityAt, endedAt time.Time) (bool, error) {
+	query := `
+		UPDATE chat_sessions SET status = 'ended', ended_at = $1, updated_at = $1
+		WHERE id = $2 AND status = 'active' AND last_activity_at = $3
+	`
+	result, err := r.db.ExecContext(ctx, query, endedAt, sessionID, lastActivityAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// ListUnansweredSessions lists active, unassigned sessions not yet linked to a ticket whose latest
+// public message came from the visitor longer ago than the widget's unanswered threshold.
+// Visitors without an email address cannot be followed up by ticket and are skipped.
+func (r *ChatSessionRepo) ListUnansweredSessions(ctx context.Context, now time.Time, limit int) ([]*models.ChatSession, error) {
+	query := `
+		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
+			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
+			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified,
+			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
+			   cw.name as widget_name, cw.use_ai as use_ai
+		FROM chat_sessions cs
+		JOIN chat_widgets cw ON cs.widget_id = cw.id
+		JOIN customers c ON cs.customer_id = c.id
+		LEFT JOIN agents a ON cs.assigned_agent_id = a.id
+		JOIN LATERAL (
+			SELECT m.author_type, m.created_at
+			FROM chat_messages m
+			WHERE m.session_id = cs.id
+			  AND m.is_private = false
+			  AND m.author_type IN ('visitor', 'agent', 'ai', 'ai-agent')
+			ORDER BY m.created_at DESC
+			LIMIT 1
+		) last_message ON true
+		WHERE cs.status = 'active'
+		  AND cs.ticket_id IS NULL
+		  AND cs.assigned_agent_id IS NULL
+		  AND cw.unanswered_ticket_minutes > 0
+		  AND c.email <> ''
+		  AND last_message.author_type = 'visitor'
+		  AND last_message.created_at <= $1 - make_interval(mins => cw.unanswered_ticket_minutes)
+		ORDER BY last_message.created_at ASC
+		LIMIT $2
+	`
+
+	var sessions []*models.ChatSession
+	if err := r.db.SelectContext(ctx, &sessions, query, now, limit); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// CreateLinkedTicket claims a session that has no ticket yet and, in the same transaction, creates the
+// ticket with its first message and links it to the session. It reports false, creating nothing, when
+// the session is already linked to a ticket. The ticket number is set on success.
+func (r *ChatSessionRepo) CreateLinkedTicket(ctx context.Context, sessionID uuid.UUID, ticket *db.Ticket, message *db.TicketMessage) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Locking the row makes a concurrent conversion wait, and then find the session taken
+	var claimed uuid.UUID
+	claimQuery := `SELECT id FROM chat_sessions WHERE id = $1 AND ticket_id IS NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, &claimed, claimQuery, sessionID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	ticketQuery := `
+		INSERT INTO tickets (id, tenant_id, project_id, number, subject, status, priority, type, source, customer_id, assignee_agent_id, created_at, updated_at)
+		VALUES ($1, $2, $3, (SELECT COALESCE(MAX(number), 0) + 1 FROM tickets WHERE tenant_id = $2 AND project_id = $3), $4, $5, $6, $7, $8, $9, $10, NOW(), NOW())
+		RETURNING number, created_at, updated_at
+	`
+	err = tx.QueryRowContext(ctx, ticketQuery,
+		ticket.ID, ticket.TenantID, ticket.ProjectID, ticket.Subject,
+		ticket.Status, ticket.Priority, ticket.Type, ticket.Source,
+		ticket.CustomerID, ticket.AssigneeAgentID).Scan(&ticket.Number, &ticket.CreatedAt, &ticket.UpdatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create ticket: %w", err)
+	}
+
+	messageQuery := `
+		INSERT INTO ticket_messages (id, tenant_id, project_id, ticket_id, author_type, author_id, body, is_private, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err = tx.ExecContext(ctx, messageQuery,
+		message.ID, message.TenantID, message.ProjectID, message.TicketID,
+		message.AuthorType, message.AuthorID, message.Body, message.IsPrivate, message.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create initial message: %w", err)
+	}
+
+	linkQuery := `UPDATE chat_sessions SET ticket_id = $1, updated_at = NOW() WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, linkQuery, ticket.ID, sessionID); err != nil {
+		return false, fmt.Errorf("failed to link ticket to chat session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReassignAgent moves an active session from one assignee to another, or to the queue when toAgentID is nil.
+// It reports false when the session is no longer active or was reassigned concurrently.
+func (r *ChatSessionRepo) ReassignAgent(ctx context.Context, sessionID uuid.UUID, fromAgentID, toAgentID *uuid.UUID) (bool, error) {
+	query := `
+		UPDATE chat_sessions SET
+			assigned_agent_id = $1,
+			assigned_at = CASE WHEN $1::uuid IS NULL THEN NULL ELSE NOW() END,
+			updated_at = NOW()
+		WHERE id = $2 AND status = 'active' AND assigned_agent_id IS NOT DISTINCT FROM $3
+	`
+	result, err := r.db.ExecContext(ctx, query, toAgentID, sessionID, fromAgentID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// CountActiveSessionsByAgent counts the active sessions assigned to each of the given agents
+func (r *ChatSessionRepo) CountActiveSessionsByAgent(ctx context.Context, tenantID uuid.UUID, agentIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	counts := make(map[uuid.UUID]int, len(agentIDs))
+	if len(agentIDs) == 0 {
+		return counts, nil
+	}
+
+	ids := make([]string, len(agentIDs))
+	for i, id := range agentIDs {
+		ids[i] = id.String()
+	}
+
+	query := `
+		SELECT assigned_agent_id, COUNT(*)
+		FROM chat_sessions
+		WHERE tenant_id = $1 AND status = 'active' AND assigned_agent_id = ANY($2::uuid[])
+		GROUP BY assigned_agent_id
+	`
+	rows, err := r.db.QueryContext(ctx, query, tenantID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var agentID uuid.UUID
+		var count int
+		if err := rows.Scan(&agentID, &count); err != nil {
+			return nil, err
+		}
+		counts[agentID] = count
+	}
+	return counts, rows.Err()
+}
+
+// CountQueuedSessions counts the active sessions of a project waiting for an agent
+func (r *ChatSessionRepo) CountQueuedSessions(ctx context.Context, tenantID, projectID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM chat_sessions
+		WHERE tenant_id = $1 AND project_id = $2 AND status = 'active' AND assigned_agent_id IS NULL
+	`
+	var count int
+	err := r.db.GetContext(ctx, &count, query, tenantID, projectID)
+	return count, err
+}
+
+// ListVerifiedSessionsForCustomer lists a customer's sessions that were started with a verified identity
+func (r *ChatSessionRepo) ListVerifiedSessionsForCustomer(ctx context.Context, tenantID, projectID, customerID uuid.UUID, limit int) ([]*models.ChatSession, error) {
+	query := `
+		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
+			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
+			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified,
+			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
+			   cw.name as widget_name, cw.use_ai as use_ai
+		FROM chat_sessions cs
+		LEFT JOIN agents a ON cs.assigned_agent_id = a.id
+		LEFT JOIN customers c ON cs.customer_id = c.id
+		LEFT JOIN chat_widgets cw ON cs.widget_id = cw.id
+		WHERE cs.tenant_id = $1 AND cs.project_id = $2 AND cs.customer_id = $3 AND cs.identity_verified = true
+		ORDER BY cs.started_at DESC
+		LIMIT $4
+	`
+
+	var sessions []*models.ChatSession
+	if err := r.db.SelectContext(ctx, &sessions, query, tenantID, projectID, customerID, limit); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
 // UpdateSessionMeta updates the meta field for a session
 func (r *ChatSessionRepo) UpdateSessionMeta(ctx context.Context, sessionID uuid.UUID, meta models.JSONMap) error {
 	query := `UPDATE chat_sessions SET meta = $1, updated_at = NOW() WHERE id = $2`
@@ -215,6 +482,36 @@ func (r *ChatSessionRepo) UpdateSessionMeta(ctx context.Context, sessionID uuid.
 	return err
 }
 
+// GetContextSummary gets the rolling AI context summary of a session; it is empty until one is saved
+func (r *ChatSessionRepo) GetContextSummary(ctx context.Context, sessionID uuid.UUID) (*models.ChatContextSummary, error) {
+	query := `
+		SELECT COALESCE(context_summary, '') AS context_summary, context_summary_seq, context_summary_updated_at
+		FROM chat_sessions
+		WHERE id = $1
+	`
+	var summary models.ChatContextSummary
+	err := r.db.GetContext(ctx, &summary, query, sessionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &models.ChatContextSummary{}, nil
+		}
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// UpdateContextSummary saves the rolling AI context summary of a session. A summary covering fewer
+// messages than the saved one is ignored, so concurrent replies cannot roll it back.
+func (r *ChatSessionRepo) UpdateContextSummary(ctx context.Context, sessionID uuid.UUID, summary string, seq int64) error {
+	query := `
+		UPDATE chat_sessions
+		SET context_summary = $1, context_summary_seq = $2, context_summary_updated_at = NOW()
+		WHERE id = $3 AND context_summary_seq < $2
+	`
+	_, err := r.db.ExecContext(ctx, query, summary, seq, sessionID)
+	return err
+}
+
 // UpdateSlackThreadInfo updates Slack thread information for a session
 func (r *ChatSessionRepo) UpdateSlackThreadInfo(ctx context.Context, sessionID uuid.UUID, threadTS, channelID string) error {
 	query := `UPDATE chat_sessions SET slack_thread_ts = $1, slack_channel_id = $2, updated_at = NOW() WHERE id = $3`
@@ -228,7 +525,7 @@ func (r *ChatSessionRepo) GetChatSessionBySlackThread(ctx context.Context, threa
 		SELECT cs.id, cs.tenant_id, cs.project_id, cs.widget_id, cs.customer_id, cs.ticket_id,
 			   cs.status, cs.visitor_info, cs.assigned_agent_id, cs.assigned_at, cs.started_at, cs.ended_at, cs.client_session_id,
 			   cs.last_activity_at, cs.created_at, cs.updated_at, cs.meta,
-			   cs.slack_thread_ts, cs.slack_channel_id,
+			   cs.slack_thread_ts, cs.slack_channel_id, cs.identity_verified,
 			   a.name as assigned_agent_name, c.name as customer_name, c.email as customer_email,
 			   cw.name as widget_name, cw.use_ai as use_ai
 		FROM chat_sessions cs
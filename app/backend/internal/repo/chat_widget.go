@@ -31,6 +31,8 @@ func (r *ChatWidgetRepo) CreateChatWidget(ctx context.Context, widget *models.Ch
 			agent_name, agent_avatar_url,
 			auto_open_delay, show_agent_avatars, allow_file_uploads, require_email, require_name,
 			sound_enabled, show_powered_by, use_ai,
+			idle_warning_minutes, idle_timeout_minutes, send_transcript, transcript_archive_email, unanswered_ticket_minutes,
+			identity_verification_enabled, identity_secret,
 			business_hours, embed_code, created_at, updated_at
 		) VALUES (
 			:id, :tenant_id, :project_id, :domain_url, :name, :is_active,
@@ -40,6 +42,8 @@ func (r *ChatWidgetRepo) CreateChatWidget(ctx context.Context, widget *models.Ch
 			:agent_name, :agent_avatar_url,
 			:auto_open_delay, :show_agent_avatars, :allow_file_uploads, :require_email, :require_name,
 			:sound_enabled, :show_powered_by, :use_ai,
+			:idle_warning_minutes, :idle_timeout_minutes, :send_transcript, :transcript_archive_email, :unanswered_ticket_minutes,
+			:identity_verification_enabled, :identity_secret,
 			:business_hours, :embed_code, :created_at, :updated_at
 		)
 	`
@@ -57,6 +61,8 @@ func (r *ChatWidgetRepo) GetChatWidget(ctx context.Context, tenantID, projectID,
 			   cw.agent_name, cw.agent_avatar_url,
 			   cw.auto_open_delay, cw.show_agent_avatars, cw.allow_file_uploads, cw.require_email, cw.require_name,
 			   cw.sound_enabled, cw.show_powered_by, cw.use_ai,
+			   cw.idle_warning_minutes, cw.idle_timeout_minutes, cw.send_transcript, cw.transcript_archive_email, cw.unanswered_ticket_minutes,
+			   cw.identity_verification_enabled, cw.identity_secret,
 			   cw.business_hours, cw.embed_code, cw.created_at, cw.updated_at
 		FROM chat_widgets cw
 		WHERE cw.tenant_id = $1 AND cw.project_id = $2 AND cw.id = $3
@@ -82,6 +88,8 @@ func (r *ChatWidgetRepo) GetChatWidgetById(ctx context.Context, widgetID uuid.UU
 			   cw.agent_name, cw.agent_avatar_url,
 			   cw.auto_open_delay, cw.show_agent_avatars, cw.allow_file_uploads, cw.require_email,
 			   cw.require_name, cw.sound_enabled, cw.show_powered_by, cw.use_ai,
+			   cw.idle_warning_minutes, cw.idle_timeout_minutes, cw.send_transcript, cw.transcript_archive_email, cw.unanswered_ticket_minutes,
+			   cw.identity_verification_enabled, cw.identity_secret,
 			   cw.business_hours, cw.embed_code, cw.created_at, cw.updated_at
 		FROM chat_widgets cw
 		WHERE cw.id = $1
@@ -108,6 +116,8 @@ func (r *ChatWidgetRepo) GetChatWidgetByDomain(ctx context.Context, domain strin
 			   cw.agent_name, cw.agent_avatar_url,
 			   cw.auto_open_delay, cw.show_agent_avatars, cw.allow_file_uploads, cw.require_email,
 			   cw.require_name, cw.sound_enabled, cw.show_powered_by, cw.use_ai,
+			   cw.idle_warning_minutes, cw.idle_timeout_minutes, cw.send_transcript, cw.transcript_archive_email, cw.unanswered_ticket_minutes,
+			   cw.identity_verification_enabled, cw.identity_secret,
 			   cw.business_hours, cw.embed_code, cw.created_at, cw.updated_at
 		FROM chat_widgets cw
 		WHERE edv.domain = $1 AND cw.is_active = true AND edv.status = 'verified'
@@ -135,7 +145,10 @@ func (r *ChatWidgetRepo) ListChatWidgets(ctx context.Context, tenantID, projectI
 			   cw.agent_name, cw.agent_avatar_url,
 			   cw.auto_open_delay, cw.show_agent_avatars, cw.allow_file_uploads, cw.require_email,
 			   cw.sound_enabled, cw.show_powered_by, cw.use_ai,
-			   cw.require_name, cw.business_hours, cw.embed_code, cw.created_at, cw.updated_at
+			   cw.require_name,
+			   cw.idle_warning_minutes, cw.idle_timeout_minutes, cw.send_transcript, cw.transcript_archive_email, cw.unanswered_ticket_minutes,
+			   cw.identity_verification_enabled, cw.identity_secret,
+			   cw.business_hours, cw.embed_code, cw.created_at, cw.updated_at
 		FROM chat_widgets cw
 		WHERE cw.tenant_id = $1 AND cw.project_id = $2
 		ORDER BY cw.created_at DESC
@@ -183,6 +196,13 @@ func (r *ChatWidgetRepo) UpdateChatWidget(ctx context.Context, widget *models.Ch
 			sound_enabled = :sound_enabled,
 			show_powered_by = :show_powered_by,
 			use_ai = :use_ai,
+			idle_warning_minutes = :idle_warning_minutes,
+			idle_timeout_minutes = :idle_timeout_minutes,
+			send_transcript = :send_transcript,
+			transcript_archive_email = :transcript_archive_email,
+			unanswered_ticket_minutes = :unanswered_ticket_minutes,
+			identity_verification_enabled = :identity_verification_enabled,
+			identity_secret = :identity_secret,
 			business_hours = :business_hours,
 			embed_code = :embed_code,
 			updated_at = :updated_at
@@ -209,6 +229,8 @@ func (r *ChatWidgetRepo) GetRecentWidgetByName(ctx context.Context, tenantID, pr
 			   cw.agent_name, cw.agent_avatar_url,
 			   cw.auto_open_delay, cw.show_agent_avatars, cw.allow_file_uploads, cw.require_email, cw.require_name,
 			   cw.sound_enabled, cw.show_powered_by, cw.use_ai,
+			   cw.idle_warning_minutes, cw.idle_timeout_minutes, cw.send_transcript, cw.transcript_archive_email, cw.unanswered_ticket_minutes,
+			   cw.identity_verification_enabled, cw.identity_secret,
 			   cw.business_hours, cw.embed_code, cw.created_at, cw.updated_at
 		FROM chat_widgets cw
 		WHERE cw.tenant_id = $1
@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,6 +11,9 @@ import (
 	"github.com/google/uuid"
 )
 
+// ErrInsufficientCredits is returned when a deduction exceeds the available balance
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
 type creditsRepository struct {
 	db *sql.DB
 }
@@ -203,7 +207,7 @@ func (r *creditsRepository) DeductCredits(ctx context.Context, tenantID uuid.UUI
 
 	// Check if sufficient credits available
 	if credits.Balance < amount {
-		return nil, fmt.Errorf("insufficient credits: balance %d, required %d", credits.Balance, amount)
+		return nil, fmt.Errorf("%w: balance %d, required %d", ErrInsufficientCredits, credits.Balance, amount)
 	}
 
 	// Calculate new balance
@@ -341,3 +345,56 @@ func (r *creditsRepository) GetTransactionByPaymentEventID(ctx context.Context,
 
 	return &transaction, nil
 }
+
+// GetTransactionsInRange retrieves all credit transactions for a tenant created in [from, to), oldest first
+func (r *creditsRepository) GetTransactionsInRange(ctx context.Context, tenantID uuid.UUID, from, to time.Time) ([]*db.CreditTransaction, error) {
+	query := `
+		SELECT id, tenant_id, amount, transaction_type, payment_gateway, payment_event_id, description, balance_before, balance_after, created_at
+		FROM credit_transactions
+		WHERE tenant_id = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at ASC, id ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, tenantID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []*db.CreditTransaction
+	for rows.Next() {
+		var transaction db.CreditTransaction
+		err := rows.Scan(
+			&transaction.ID, &transaction.TenantID, &transaction.Amount, &transaction.TransactionType,
+			&transaction.PaymentGateway, &transaction.PaymentEventID, &transaction.Description,
+			&transaction.BalanceBefore, &transaction.BalanceAfter, &transaction.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, &transaction)
+	}
+
+	return transactions, rows.Err()
+}
+
+// GetBalanceAt returns the tenant balance as of the given instant, based on the last transaction before it
+func (r *creditsRepository) GetBalanceAt(ctx context.Context, tenantID uuid.UUID, at time.Time) (int64, error) {
+	query := `
+		SELECT balance_after
+		FROM credit_transactions
+		WHERE tenant_id = $1 AND created_at < $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`
+
+	var balance int64
+	err := r.db.QueryRowContext(ctx, query, tenantID, at).Scan(&balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return balance, nil
+}
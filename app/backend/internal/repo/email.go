@@ -258,6 +258,49 @@ func (r *EmailRepo) GetTicketRoutingByToken(ctx context.Context, tenantID uuid.U
 	return &routing, err
 }
 
+// GetTicketRoutingByPublicToken retrieves ticket routing by public token across tenants.
+// Used to resolve reply addresses on mail that arrives without tenant context.
+func (r *EmailRepo) GetTicketRoutingByPublicToken(ctx context.Context, token string) (*models.TicketMailRouting, error) {
+	var routing models.TicketMailRouting
+	query := `
+		SELECT * FROM ticket_mail_routing
+		WHERE public_token = $1 AND revoked_at IS NULL`
+
+	err := r.db.GetContext(ctx, &routing, query, token)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &routing, err
+}
+
+// GetProjectByInboundKey retrieves the active project addressed by tenant-<key>@ inbound mail
+func (r *EmailRepo) GetProjectByInboundKey(ctx context.Context, key string) (*models.Project, error) {
+	var project models.Project
+	query := `
+		SELECT id, tenant_id, key, name, status, is_public, expires_at, created_at, updated_at
+		FROM projects
+		WHERE inbound_email_key = $1 AND status = 'active'
+			AND (expires_at IS NULL OR expires_at > NOW())`
+
+	err := r.db.GetContext(ctx, &project, query, key)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &project, err
+}
+
+// GetProjectInboundKey retrieves the inbound email key of a project
+func (r *EmailRepo) GetProjectInboundKey(ctx context.Context, tenantID, projectID uuid.UUID) (string, error) {
+	var key string
+	query := `SELECT inbound_email_key FROM projects WHERE tenant_id = $1 AND id = $2`
+
+	err := r.db.GetContext(ctx, &key, query, tenantID, projectID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return key, err
+}
+
 // FindTicketByMessageID finds a ticket by message ID root
 func (r *EmailRepo) FindTicketByMessageID(ctx context.Context, tenantID uuid.UUID, messageIDRoot string) (*uuid.UUID, error) {
 	var ticketID uuid.UUID
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"time"
 
 	"github.com/bareuptime/tms/internal/db"
 	"github.com/google/uuid"
@@ -49,9 +50,12 @@ type TicketRepository interface {
 	GetByTenantAndProjectID(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*db.Ticket, error)
 	GetByID(ctx context.Context, ticketID uuid.UUID) (*db.Ticket, error)
 	Update(ctx context.Context, ticket *db.Ticket) error
+	ApplyTriage(ctx context.Context, ticket *db.Ticket, previousType, previousPriority string) (bool, error)
 	Delete(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) error
 	List(ctx context.Context, tenantID, projectID uuid.UUID, filters TicketFilters, pagination PaginationParams) ([]*db.Ticket, string, error)
 	GetByNumber(ctx context.Context, tenantID uuid.UUID, number int) (*db.Ticket, error)
+	GetByProjectAndNumber(ctx context.Context, tenantID, projectID uuid.UUID, number int) (*db.Ticket, error)
+	FindSimilarResolved(ctx context.Context, tenantID, projectID uuid.UUID, terms []string, excludeID *uuid.UUID, limit int) ([]*db.Ticket, error)
 }
 
 // AgentRepository interface
@@ -149,6 +153,8 @@ type CreditsRepository interface {
 	CreateTransaction(ctx context.Context, transaction *db.CreditTransaction) error
 	GetTransactionsByTenantID(ctx context.Context, tenantID uuid.UUID, pagination PaginationParams) ([]*db.CreditTransaction, string, error)
 	GetTransactionByPaymentEventID(ctx context.Context, paymentEventID string) (*db.CreditTransaction, error)
+	GetTransactionsInRange(ctx context.Context, tenantID uuid.UUID, from, to time.Time) ([]*db.CreditTransaction, error)
+	GetBalanceAt(ctx context.Context, tenantID uuid.UUID, at time.Time) (int64, error)
 }
 
 // PaymentWebhookRepository interface
@@ -158,3 +164,44 @@ type PaymentWebhookRepository interface {
 	Update(event *db.PaymentWebhookEvent) error
 	FindTenantByEmail(email string) (*db.Tenant, error)
 }
+
+// PlanRepository interface
+type PlanRepository interface {
+	ListPlans(ctx context.Context) ([]*db.BillingPlan, error)
+	GetPlanByCode(ctx context.Context, code string) (*db.BillingPlan, error)
+	GetTenantPlan(ctx context.Context, tenantID uuid.UUID) (*db.TenantPlan, error)
+	ListTenantPlans(ctx context.Context) ([]*db.TenantPlan, error)
+	AssignPlan(ctx context.Context, tenantID, planID uuid.UUID) error
+	MarkCreditsGranted(ctx context.Context, tenantID uuid.UUID, grantedAt time.Time) error
+	MarkLowBalanceNotified(ctx context.Context, tenantID uuid.UUID, notifiedAt time.Time) error
+	MarkExhaustedNotified(ctx context.Context, tenantID uuid.UUID, notifiedAt time.Time) error
+	GetUsage(ctx context.Context, tenantID uuid.UUID) (*db.PlanUsage, error)
+}
+
+// MFARepository interface
+type MFARepository interface {
+	GetAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID) (*db.AgentMFA, error)
+	SavePendingAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID, secretEncrypted string) error
+	EnableAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID, step int64, recoveryCodeHashes []string) error
+	MarkStepUsed(ctx context.Context, tenantID, agentID uuid.UUID, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, tenantID, agentID uuid.UUID, recoveryCodeHashes []string) error
+	ConsumeRecoveryCode(ctx context.Context, tenantID, agentID uuid.UUID, codeHash string) (bool, error)
+	CountUnusedRecoveryCodes(ctx context.Context, tenantID, agentID uuid.UUID) (int, error)
+	DeleteAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID) error
+	GetSecuritySettings(ctx context.Context, tenantID uuid.UUID) (*db.TenantSecuritySettings, error)
+	SetMFAPolicy(ctx context.Context, tenantID uuid.UUID, policy string) (*db.TenantSecuritySettings, error)
+}
+
+// SSORepository interface
+type SSORepository interface {
+	ListProviders(ctx context.Context, tenantID uuid.UUID) ([]*db.SSOProvider, error)
+	GetProvider(ctx context.Context, tenantID, providerID uuid.UUID) (*db.SSOProvider, error)
+	GetEnabledProvider(ctx context.Context, providerID uuid.UUID) (*db.SSOProvider, error)
+	FindProvidersByEmailDomain(ctx context.Context, domain string) ([]*db.SSOProvider, error)
+	CreateProvider(ctx context.Context, provider *db.SSOProvider) error
+	UpdateProvider(ctx context.Context, provider *db.SSOProvider) error
+	DeleteProvider(ctx context.Context, tenantID, providerID uuid.UUID) error
+	CountEnabledProviders(ctx context.Context, tenantID uuid.UUID) (int, error)
+	IsPasswordLoginDisabled(ctx context.Context, tenantID uuid.UUID) (bool, error)
+	SetPasswordLoginDisabled(ctx context.Context, tenantID uuid.UUID, disabled bool) error
+}
@@ -0,0 +1,222 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/google/uuid"
+)
+
+type mfaRepository struct {
+	db *sql.DB
+}
+
+// NewMFARepository creates a new MFA repository
+func NewMFARepository(database *sql.DB) MFARepository {
+	return &mfaRepository{db: database}
+}
+
+// GetAgentMFA retrieves the second factor of an agent, or nil when none was set up
+func (r *mfaRepository) GetAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID) (*db.AgentMFA, error) {
+	query := `
+		SELECT agent_id, tenant_id, secret_encrypted, enabled, enabled_at, last_used_step, created_at, updated_at
+		FROM agent_mfa
+		WHERE tenant_id = $1 AND agent_id = $2
+	`
+
+	var mfa db.AgentMFA
+	err := r.db.QueryRowContext(ctx, query, tenantID, agentID).Scan(
+		&mfa.AgentID, &mfa.TenantID, &mfa.SecretEncrypted, &mfa.Enabled, &mfa.EnabledAt,
+		&mfa.LastUsedStep, &mfa.CreatedAt, &mfa.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// SavePendingAgentMFA stores a new secret awaiting confirmation. An enabled factor is left untouched.
+func (r *mfaRepository) SavePendingAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID, secretEncrypted string) error {
+	query := `
+		INSERT INTO agent_mfa (agent_id, tenant_id, secret_encrypted, enabled, last_used_step, created_at, updated_at)
+		VALUES ($1, $2, $3, FALSE, 0, NOW(), NOW())
+		ON CONFLICT (agent_id) DO UPDATE
+		SET secret_encrypted = EXCLUDED.secret_encrypted, last_used_step = 0, updated_at = NOW()
+		WHERE agent_mfa.enabled = FALSE
+	`
+
+	_, err := r.db.ExecContext(ctx, query, agentID, tenantID, secretEncrypted)
+	return err
+}
+
+// EnableAgentMFA confirms enrollment and stores the initial recovery codes
+func (r *mfaRepository) EnableAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE agent_mfa
+		SET enabled = TRUE, enabled_at = NOW(), last_used_step = $3, updated_at = NOW()
+		WHERE tenant_id = $1 AND agent_id = $2 AND enabled = FALSE
+	`, tenantID, agentID, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable MFA: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, tenantID, agentID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkStepUsed records a TOTP time step as consumed. It reports false when the step
+// (or a later one) was already used, which rejects replayed codes.
+func (r *mfaRepository) MarkStepUsed(ctx context.Context, tenantID, agentID uuid.UUID, step int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE agent_mfa
+		SET last_used_step = $3, updated_at = NOW()
+		WHERE tenant_id = $1 AND agent_id = $2 AND last_used_step < $3
+	`, tenantID, agentID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ReplaceRecoveryCodes invalidates all recovery codes of an agent and stores new ones
+func (r *mfaRepository) ReplaceRecoveryCodes(ctx context.Context, tenantID, agentID uuid.UUID, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, tenantID, agentID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, tenantID, agentID uuid.UUID, recoveryCodeHashes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM agent_mfa_recovery_codes WHERE tenant_id = $1 AND agent_id = $2`, tenantID, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO agent_mfa_recovery_codes (agent_id, tenant_id, code_hash, created_at)
+			VALUES ($1, $2, $3, NOW())
+		`, agentID, tenantID, hash)
+		if err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ConsumeRecoveryCode marks an unused recovery code as used. It reports false when no such code exists.
+func (r *mfaRepository) ConsumeRecoveryCode(ctx context.Context, tenantID, agentID uuid.UUID, codeHash string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE agent_mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE tenant_id = $1 AND agent_id = $2 AND code_hash = $3 AND used_at IS NULL
+	`, tenantID, agentID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// CountUnusedRecoveryCodes returns how many recovery codes an agent has left
+func (r *mfaRepository) CountUnusedRecoveryCodes(ctx context.Context, tenantID, agentID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(1) FROM agent_mfa_recovery_codes
+		WHERE tenant_id = $1 AND agent_id = $2 AND used_at IS NULL
+	`, tenantID, agentID).Scan(&count)
+	return count, err
+}
+
+// DeleteAgentMFA removes the second factor and recovery codes of an agent
+func (r *mfaRepository) DeleteAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM agent_mfa_recovery_codes WHERE tenant_id = $1 AND agent_id = $2`, tenantID, agentID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM agent_mfa WHERE tenant_id = $1 AND agent_id = $2`, tenantID, agentID); err != nil {
+		return fmt.Errorf("failed to delete MFA: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetSecuritySettings retrieves the security settings of a tenant, or nil when none were saved
+func (r *mfaRepository) GetSecuritySettings(ctx context.Context, tenantID uuid.UUID) (*db.TenantSecuritySettings, error) {
+	query := `
+		SELECT tenant_id, mfa_policy, password_login_disabled, created_at, updated_at
+		FROM tenant_security_settings
+		WHERE tenant_id = $1
+	`
+
+	var settings db.TenantSecuritySettings
+	err := r.db.QueryRowContext(ctx, query, tenantID).Scan(
+		&settings.TenantID, &settings.MFAPolicy, &settings.PasswordLoginDisabled, &settings.CreatedAt, &settings.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// SetMFAPolicy stores the MFA policy of a tenant
+func (r *mfaRepository) SetMFAPolicy(ctx context.Context, tenantID uuid.UUID, policy string) (*db.TenantSecuritySettings, error) {
+	query := `
+		INSERT INTO tenant_security_settings (tenant_id, mfa_policy, created_at, updated_at)
+		VALUES ($1, $2, NOW(), NOW())
+		ON CONFLICT (tenant_id) DO UPDATE
+		SET mfa_policy = EXCLUDED.mfa_policy, updated_at = NOW()
+		RETURNING tenant_id, mfa_policy, password_login_disabled, created_at, updated_at
+	`
+
+	var settings db.TenantSecuritySettings
+	err := r.db.QueryRowContext(ctx, query, tenantID, policy).Scan(
+		&settings.TenantID, &settings.MFAPolicy, &settings.PasswordLoginDisabled, &settings.CreatedAt, &settings.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
@@ -0,0 +1,201 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/google/uuid"
+)
+
+type planRepository struct {
+	db *sql.DB
+}
+
+// NewPlanRepository creates a new billing plan repository
+func NewPlanRepository(database *sql.DB) PlanRepository {
+	return &planRepository{db: database}
+}
+
+const billingPlanColumns = `p.id, p.code, p.name, p.seat_limit, p.project_limit, p.widget_limit, p.knowledge_page_limit,
+		p.monthly_ai_credits, p.low_balance_threshold, p.is_active, p.created_at, p.updated_at`
+
+func scanBillingPlan(scanner interface{ Scan(...interface{}) error }, plan *db.BillingPlan, extra ...interface{}) error {
+	dest := []interface{}{
+		&plan.ID, &plan.Code, &plan.Name, &plan.SeatLimit, &plan.ProjectLimit, &plan.WidgetLimit, &plan.KnowledgePageLimit,
+		&plan.MonthlyAICredits, &plan.LowBalanceThreshold, &plan.IsActive, &plan.CreatedAt, &plan.UpdatedAt,
+	}
+	return scanner.Scan(append(dest, extra...)...)
+}
+
+// ListPlans retrieves all active billing plans
+func (r *planRepository) ListPlans(ctx context.Context) ([]*db.BillingPlan, error) {
+	query := `
+		SELECT ` + billingPlanColumns + `
+		FROM billing_plans p
+		WHERE p.is_active = TRUE
+		ORDER BY p.monthly_ai_credits ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []*db.BillingPlan
+	for rows.Next() {
+		var plan db.BillingPlan
+		if err := scanBillingPlan(rows, &plan); err != nil {
+			return nil, err
+		}
+		plans = append(plans, &plan)
+	}
+
+	return plans, rows.Err()
+}
+
+// GetPlanByCode retrieves a billing plan by its code
+func (r *planRepository) GetPlanByCode(ctx context.Context, code string) (*db.BillingPlan, error) {
+	query := `
+		SELECT ` + billingPlanColumns + `
+		FROM billing_plans p
+		WHERE p.code = $1
+	`
+
+	var plan db.BillingPlan
+	err := scanBillingPlan(r.db.QueryRowContext(ctx, query, code), &plan)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// GetTenantPlan retrieves the active plan of a tenant, or nil when no plan is assigned
+func (r *planRepository) GetTenantPlan(ctx context.Context, tenantID uuid.UUID) (*db.TenantPlan, error) {
+	query := `
+		SELECT ` + billingPlanColumns + `,
+			tp.tenant_id, tp.plan_id, tp.last_grant_at, tp.low_balance_notified_at, tp.exhausted_notified_at, tp.created_at, tp.updated_at
+		FROM tenant_plans tp
+		JOIN billing_plans p ON p.id = tp.plan_id
+		WHERE tp.tenant_id = $1
+	`
+
+	tenantPlan := &db.TenantPlan{Plan: &db.BillingPlan{}}
+	err := scanBillingPlan(r.db.QueryRowContext(ctx, query, tenantID), tenantPlan.Plan,
+		&tenantPlan.TenantID, &tenantPlan.PlanID, &tenantPlan.LastGrantAt, &tenantPlan.LowBalanceNotifiedAt,
+		&tenantPlan.ExhaustedNotifiedAt, &tenantPlan.CreatedAt, &tenantPlan.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return tenantPlan, nil
+}
+
+// ListTenantPlans retrieves every tenant plan subscription along with its plan
+func (r *planRepository) ListTenantPlans(ctx context.Context) ([]*db.TenantPlan, error) {
+	query := `
+		SELECT ` + billingPlanColumns + `,
+			tp.tenant_id, tp.plan_id, tp.last_grant_at, tp.low_balance_notified_at, tp.exhausted_notified_at, tp.created_at, tp.updated_at
+		FROM tenant_plans tp
+		JOIN billing_plans p ON p.id = tp.plan_id
+		ORDER BY tp.created_at ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenantPlans []*db.TenantPlan
+	for rows.Next() {
+		tenantPlan := &db.TenantPlan{Plan: &db.BillingPlan{}}
+		err := scanBillingPlan(rows, tenantPlan.Plan,
+			&tenantPlan.TenantID, &tenantPlan.PlanID, &tenantPlan.LastGrantAt, &tenantPlan.LowBalanceNotifiedAt,
+			&tenantPlan.ExhaustedNotifiedAt, &tenantPlan.CreatedAt, &tenantPlan.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tenantPlans = append(tenantPlans, tenantPlan)
+	}
+
+	return tenantPlans, rows.Err()
+}
+
+// AssignPlan sets or replaces the plan of a tenant
+func (r *planRepository) AssignPlan(ctx context.Context, tenantID, planID uuid.UUID) error {
+	query := `
+		INSERT INTO tenant_plans (tenant_id, plan_id, created_at, updated_at)
+		VALUES ($1, $2, NOW(), NOW())
+		ON CONFLICT (tenant_id)
+		DO UPDATE SET plan_id = EXCLUDED.plan_id, updated_at = NOW()
+	`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, planID)
+	return err
+}
+
+// MarkCreditsGranted records the monthly grant and re-arms the low and exhausted balance warnings
+func (r *planRepository) MarkCreditsGranted(ctx context.Context, tenantID uuid.UUID, grantedAt time.Time) error {
+	query := `
+		UPDATE tenant_plans
+		SET last_grant_at = $2, low_balance_notified_at = NULL, exhausted_notified_at = NULL, updated_at = NOW()
+		WHERE tenant_id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, grantedAt)
+	return err
+}
+
+// MarkLowBalanceNotified records that tenant admins were warned about a low balance
+func (r *planRepository) MarkLowBalanceNotified(ctx context.Context, tenantID uuid.UUID, notifiedAt time.Time) error {
+	query := `
+		UPDATE tenant_plans
+		SET low_balance_notified_at = $2, updated_at = NOW()
+		WHERE tenant_id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, notifiedAt)
+	return err
+}
+
+// MarkExhaustedNotified records that tenant admins were warned about an exhausted balance
+func (r *planRepository) MarkExhaustedNotified(ctx context.Context, tenantID uuid.UUID, notifiedAt time.Time) error {
+	query := `
+		UPDATE tenant_plans
+		SET exhausted_notified_at = $2, updated_at = NOW()
+		WHERE tenant_id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, notifiedAt)
+	return err
+}
+
+// GetUsage counts the plan-limited resources currently used by a tenant
+func (r *planRepository) GetUsage(ctx context.Context, tenantID uuid.UUID) (*db.PlanUsage, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(1) FROM agents WHERE tenant_id = $1 AND status = 'active'),
+			(SELECT COUNT(1) FROM projects WHERE tenant_id = $1 AND (expires_at IS NULL OR expires_at > NOW())),
+			(SELECT COUNT(1) FROM chat_widgets WHERE tenant_id = $1),
+			(SELECT COUNT(DISTINCT page_id) FROM project_knowledge_pages WHERE tenant_id = $1)
+	`
+
+	var usage db.PlanUsage
+	err := r.db.QueryRowContext(ctx, query, tenantID).Scan(
+		&usage.Seats, &usage.Projects, &usage.Widgets, &usage.KnowledgePages)
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
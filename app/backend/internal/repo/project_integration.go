@@ -92,6 +92,19 @@ func (r *ProjectIntegrationRepository) ListByTenant(ctx context.Context, tenantI
 	return integrations, err
 }
 
+// ListActiveBySlackTeam retrieves the active Slack integrations installed in a Slack workspace.
+// Slack callbacks carry no tenant context, so the workspace (team) ID is the only way in.
+func (r *ProjectIntegrationRepository) ListActiveBySlackTeam(ctx context.Context, teamID string) ([]*models.ProjectIntegration, error) {
+	var integrations []*models.ProjectIntegration
+	query := `
+		SELECT * FROM project_integrations
+		WHERE integration_type = $1 AND status = $2 AND meta->>'team_id' = $3
+		ORDER BY created_at ASC`
+
+	err := r.db.SelectContext(ctx, &integrations, query, models.ProjectIntegrationTypeSlack, models.ProjectIntegrationStatusActive, teamID)
+	return integrations, err
+}
+
 // Update updates a project integration
 func (r *ProjectIntegrationRepository) Update(ctx context.Context, integration *models.ProjectIntegration) error {
 	query := `
@@ -0,0 +1,189 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/google/uuid"
+)
+
+type ssoRepository struct {
+	db *sql.DB
+}
+
+// NewSSORepository creates a new SSO provider repository
+func NewSSORepository(database *sql.DB) SSORepository {
+	return &ssoRepository{db: database}
+}
+
+const ssoProviderColumns = `id, tenant_id, name, protocol, enabled, issuer_url, client_id, client_secret_encrypted, scopes,
+		idp_entity_id, idp_sso_url, idp_certificate, groups_claim, email_domains, jit_provisioning,
+		default_role, default_project_id, group_mappings, created_at, updated_at`
+
+func scanSSOProvider(scanner interface{ Scan(...interface{}) error }, provider *db.SSOProvider) error {
+	return scanner.Scan(
+		&provider.ID, &provider.TenantID, &provider.Name, &provider.Protocol, &provider.Enabled,
+		&provider.IssuerURL, &provider.ClientID, &provider.ClientSecretEncrypted, &provider.Scopes,
+		&provider.IDPEntityID, &provider.IDPSSOURL, &provider.IDPCertificate, &provider.GroupsClaim,
+		&provider.EmailDomains, &provider.JITProvisioning, &provider.DefaultRole, &provider.DefaultProjectID,
+		&provider.GroupMappings, &provider.CreatedAt, &provider.UpdatedAt)
+}
+
+func (r *ssoRepository) queryProviders(ctx context.Context, query string, args ...interface{}) ([]*db.SSOProvider, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var providers []*db.SSOProvider
+	for rows.Next() {
+		var provider db.SSOProvider
+		if err := scanSSOProvider(rows, &provider); err != nil {
+			return nil, err
+		}
+		providers = append(providers, &provider)
+	}
+
+	return providers, rows.Err()
+}
+
+// ListProviders retrieves the identity providers of a tenant
+func (r *ssoRepository) ListProviders(ctx context.Context, tenantID uuid.UUID) ([]*db.SSOProvider, error) {
+	query := `
+		SELECT ` + ssoProviderColumns + `
+		FROM tenant_sso_providers
+		WHERE tenant_id = $1
+		ORDER BY name ASC
+	`
+	return r.queryProviders(ctx, query, tenantID)
+}
+
+// GetProvider retrieves an identity provider of a tenant, or nil when it does not exist
+func (r *ssoRepository) GetProvider(ctx context.Context, tenantID, providerID uuid.UUID) (*db.SSOProvider, error) {
+	query := `
+		SELECT ` + ssoProviderColumns + `
+		FROM tenant_sso_providers
+		WHERE tenant_id = $1 AND id = $2
+	`
+
+	var provider db.SSOProvider
+	err := scanSSOProvider(r.db.QueryRowContext(ctx, query, tenantID, providerID), &provider)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+// GetEnabledProvider retrieves an enabled identity provider by ID for the public login routes
+func (r *ssoRepository) GetEnabledProvider(ctx context.Context, providerID uuid.UUID) (*db.SSOProvider, error) {
+	query := `
+		SELECT ` + ssoProviderColumns + `
+		FROM tenant_sso_providers
+		WHERE id = $1 AND enabled = TRUE
+	`
+
+	var provider db.SSOProvider
+	err := scanSSOProvider(r.db.QueryRowContext(ctx, query, providerID), &provider)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+// FindProvidersByEmailDomain retrieves the enabled identity providers that claim an email domain
+func (r *ssoRepository) FindProvidersByEmailDomain(ctx context.Context, domain string) ([]*db.SSOProvider, error) {
+	query := `
+		SELECT ` + ssoProviderColumns + `
+		FROM tenant_sso_providers
+		WHERE enabled = TRUE AND $1 = ANY(email_domains)
+		ORDER BY name ASC
+	`
+	return r.queryProviders(ctx, query, domain)
+}
+
+// CreateProvider stores a new identity provider
+func (r *ssoRepository) CreateProvider(ctx context.Context, provider *db.SSOProvider) error {
+	query := `
+		INSERT INTO tenant_sso_providers (tenant_id, name, protocol, enabled, issuer_url, client_id, client_secret_encrypted,
+			scopes, idp_entity_id, idp_sso_url, idp_certificate, groups_claim, email_domains, jit_provisioning,
+			default_role, default_project_id, group_mappings, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, NOW(), NOW())
+		RETURNING id, created_at, updated_at
+	`
+
+	return r.db.QueryRowContext(ctx, query,
+		provider.TenantID, provider.Name, provider.Protocol, provider.Enabled, provider.IssuerURL, provider.ClientID,
+		provider.ClientSecretEncrypted, provider.Scopes, provider.IDPEntityID, provider.IDPSSOURL, provider.IDPCertificate,
+		provider.GroupsClaim, provider.EmailDomains, provider.JITProvisioning, provider.DefaultRole,
+		provider.DefaultProjectID, provider.GroupMappings,
+	).Scan(&provider.ID, &provider.CreatedAt, &provider.UpdatedAt)
+}
+
+// UpdateProvider saves changes to an identity provider
+func (r *ssoRepository) UpdateProvider(ctx context.Context, provider *db.SSOProvider) error {
+	query := `
+		UPDATE tenant_sso_providers
+		SET name = $3, enabled = $4, issuer_url = $5, client_id = $6, client_secret_encrypted = $7, scopes = $8,
+			idp_entity_id = $9, idp_sso_url = $10, idp_certificate = $11, groups_claim = $12, email_domains = $13,
+			jit_provisioning = $14, default_role = $15, default_project_id = $16, group_mappings = $17, updated_at = NOW()
+		WHERE tenant_id = $1 AND id = $2
+		RETURNING updated_at
+	`
+
+	return r.db.QueryRowContext(ctx, query,
+		provider.TenantID, provider.ID, provider.Name, provider.Enabled, provider.IssuerURL, provider.ClientID,
+		provider.ClientSecretEncrypted, provider.Scopes, provider.IDPEntityID, provider.IDPSSOURL, provider.IDPCertificate,
+		provider.GroupsClaim, provider.EmailDomains, provider.JITProvisioning, provider.DefaultRole,
+		provider.DefaultProjectID, provider.GroupMappings,
+	).Scan(&provider.UpdatedAt)
+}
+
+// DeleteProvider removes an identity provider
+func (r *ssoRepository) DeleteProvider(ctx context.Context, tenantID, providerID uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM tenant_sso_providers WHERE tenant_id = $1 AND id = $2`, tenantID, providerID)
+	return err
+}
+
+// CountEnabledProviders returns how many enabled identity providers a tenant has
+func (r *ssoRepository) CountEnabledProviders(ctx context.Context, tenantID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM tenant_sso_providers WHERE tenant_id = $1 AND enabled = TRUE`,
+		tenantID).Scan(&count)
+	return count, err
+}
+
+// IsPasswordLoginDisabled reports whether the tenant only allows single sign-on
+func (r *ssoRepository) IsPasswordLoginDisabled(ctx context.Context, tenantID uuid.UUID) (bool, error) {
+	var disabled bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT password_login_disabled FROM tenant_security_settings WHERE tenant_id = $1`,
+		tenantID).Scan(&disabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return disabled, err
+}
+
+// SetPasswordLoginDisabled turns password logins off or back on for a tenant
+func (r *ssoRepository) SetPasswordLoginDisabled(ctx context.Context, tenantID uuid.UUID, disabled bool) error {
+	query := `
+		INSERT INTO tenant_security_settings (tenant_id, password_login_disabled, created_at, updated_at)
+		VALUES ($1, $2, NOW(), NOW())
+		ON CONFLICT (tenant_id) DO UPDATE
+		SET password_login_disabled = EXCLUDED.password_login_disabled, updated_at = NOW()
+	`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, disabled)
+	return err
+}
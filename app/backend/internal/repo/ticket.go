@@ -109,6 +109,30 @@ func (r *ticketRepository) GetByNumber(ctx context.Context, tenantID uuid.UUID,
 	return &ticket, nil
 }
 
+// GetByProjectAndNumber retrieves a ticket by its project-scoped number
+func (r *ticketRepository) GetByProjectAndNumber(ctx context.Context, tenantID, projectID uuid.UUID, number int) (*db.Ticket, error) {
+	query := `
+		SELECT id, tenant_id, project_id, number, subject, status, priority, type, source, customer_id, assignee_agent_id, created_at, updated_at
+		FROM tickets
+		WHERE tenant_id = $1 AND project_id = $2 AND number = $3
+	`
+
+	var ticket db.Ticket
+	err := r.db.QueryRowContext(ctx, query, tenantID, projectID, number).Scan(
+		&ticket.ID, &ticket.TenantID, &ticket.ProjectID, &ticket.Number,
+		&ticket.Subject, &ticket.Status, &ticket.Priority, &ticket.Type,
+		&ticket.Source, &ticket.CustomerID, &ticket.AssigneeAgentID,
+		&ticket.CreatedAt, &ticket.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("ticket not found")
+		}
+		return nil, fmt.Errorf("failed to get ticket: %w", err)
+	}
+
+	return &ticket, nil
+}
+
 // Update updates an existing ticket
 func (r *ticketRepository) Update(ctx context.Context, ticket *db.Ticket) error {
 	query := `
@@ -136,6 +160,28 @@ func (r *ticketRepository) Update(ctx context.Context, ticket *db.Ticket) error
 	return nil
 }
 
+// ApplyTriage sets the type and priority triage chose for a ticket, only while both still have the
+// values triage classified. It reports false when they were changed in the meantime.
+func (r *ticketRepository) ApplyTriage(ctx context.Context, ticket *db.Ticket, previousType, previousPriority string) (bool, error) {
+	query := `
+		UPDATE tickets
+		SET type = $4, priority = $5, updated_at = NOW()
+		WHERE tenant_id = $1 AND project_id = $2 AND id = $3 AND type = $6 AND priority = $7
+	`
+
+	result, err := r.db.ExecContext(ctx, query,
+		ticket.TenantID, ticket.ProjectID, ticket.ID, ticket.Type, ticket.Priority,
+		previousType, previousPriority)
+	if err != nil {
+		return false, fmt.Errorf("failed to apply ticket triage: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rows > 0, nil
+}
+
 // Delete deletes a ticket
 func (r *ticketRepository) Delete(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) error {
 	query := `DELETE FROM tickets WHERE tenant_id = $1 AND project_id = $2 AND id = $3`
@@ -285,3 +331,47 @@ func (r *ticketRepository) List(ctx context.Context, tenantID, projectID uuid.UU
 
 	return tickets, nextCursor, nil
 }
+
+// FindSimilarResolved retrieves the resolved and closed tickets of a project whose subject or public
+// messages match any of the given terms, best matches first. Terms must be plain words.
+func (r *ticketRepository) FindSimilarResolved(ctx context.Context, tenantID, projectID uuid.UUID, terms []string, excludeID *uuid.UUID, limit int) ([]*db.Ticket, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT t.id, t.tenant_id, t.project_id, t.number, t.subject, t.status, t.priority, t.type, t.source, t.customer_id, t.assignee_agent_id, t.created_at, t.updated_at
+		FROM tickets t
+		CROSS JOIN LATERAL (
+			SELECT to_tsvector('english', t.subject || ' ' || COALESCE(string_agg(m.body, ' '), '')) AS document
+			FROM ticket_messages m
+			WHERE m.ticket_id = t.id AND m.is_private = false
+		) d
+		WHERE t.tenant_id = $1 AND t.project_id = $2 AND t.status IN ('resolved', 'closed')
+			AND d.document @@ to_tsquery('english', $3)
+			AND ($4::uuid IS NULL OR t.id <> $4)
+		ORDER BY ts_rank(d.document, to_tsquery('english', $3)) DESC, t.updated_at DESC
+		LIMIT $5
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, tenantID, projectID, strings.Join(terms, " | "), excludeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find similar tickets: %w", err)
+	}
+	defer rows.Close()
+
+	var tickets []*db.Ticket
+	for rows.Next() {
+		var ticket db.Ticket
+		err := rows.Scan(
+			&ticket.ID, &ticket.TenantID, &ticket.ProjectID, &ticket.Number,
+			&ticket.Subject, &ticket.Status, &ticket.Priority, &ticket.Type,
+			&ticket.Source, &ticket.CustomerID, &ticket.AssigneeAgentID,
+			&ticket.CreatedAt, &ticket.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ticket: %w", err)
+		}
+		tickets = append(tickets, &ticket)
+	}
+	return tickets, rows.Err()
+}
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type TicketTriageRepository struct {
+	db *sqlx.DB
+}
+
+func NewTicketTriageRepository(db *sqlx.DB) *TicketTriageRepository {
+	return &TicketTriageRepository{db: db}
+}
+
+const ticketTriageColumns = `id, tenant_id, project_id, ticket_id, method, model, type, type_confidence, priority,
+		priority_confidence, tags, language, sentiment, urgency, previous_type, previous_priority, applied_fields,
+		suggested_fields, review_status, reviewed_type, reviewed_priority, reviewed_tags, reviewed_by, reviewed_at,
+		created_at`
+
+// Create stores a triage decision
+func (r *TicketTriageRepository) Create(ctx context.Context, triage *models.TicketTriage) error {
+	query := `
+		INSERT INTO ticket_triage (
+			tenant_id, project_id, ticket_id, method, model, type, type_confidence, priority, priority_confidence,
+			tags, language, sentiment, urgency, previous_type, previous_priority, applied_fields, suggested_fields,
+			review_status, created_at
+		) VALUES (
+			:tenant_id, :project_id, :ticket_id, :method, :model, :type, :type_confidence, :priority, :priority_confidence,
+			:tags, :language, :sentiment, :urgency, :previous_type, :previous_priority, :applied_fields, :suggested_fields,
+			:review_status, NOW()
+		)
+		RETURNING id, created_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, triage)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&triage.ID, &triage.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetLatest retrieves the most recent triage decision of a ticket, or nil when it was never triaged
+func (r *TicketTriageRepository) GetLatest(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*models.TicketTriage, error) {
+	var triage models.TicketTriage
+	query := `
+		SELECT ` + ticketTriageColumns + `
+		FROM ticket_triage
+		WHERE tenant_id = $1 AND project_id = $2 AND ticket_id = $3
+		ORDER BY created_at DESC
+		LIMIT 1`
+
+	err := r.db.GetContext(ctx, &triage, query, tenantID, projectID, ticketID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &triage, nil
+}
+
+// List retrieves a project's triage decisions, newest first, optionally only those with a review status
+func (r *TicketTriageRepository) List(ctx context.Context, tenantID, projectID uuid.UUID, reviewStatus string, limit int) ([]*models.TicketTriage, error) {
+	decisions := []*models.TicketTriage{}
+	query := `
+		SELECT ` + ticketTriageColumns + `
+		FROM ticket_triage
+		WHERE tenant_id = $1 AND project_id = $2 AND ($3 = '' OR review_status = $3)
+		ORDER BY created_at DESC
+		LIMIT $4`
+
+	err := r.db.SelectContext(ctx, &decisions, query, tenantID, projectID, reviewStatus, limit)
+	return decisions, err
+}
+
+// SaveReview records an agent's review of a triage decision
+func (r *TicketTriageRepository) SaveReview(ctx context.Context, triage *models.TicketTriage) error {
+	query := `
+		UPDATE ticket_triage SET
+			review_status = :review_status,
+			reviewed_type = :reviewed_type,
+			reviewed_priority = :reviewed_priority,
+			reviewed_tags = :reviewed_tags,
+			reviewed_by = :reviewed_by,
+			reviewed_at = :reviewed_at
+		WHERE tenant_id = :tenant_id AND project_id = :project_id AND id = :id`
+
+	_, err := r.db.NamedExecContext(ctx, query, triage)
+	return err
+}
+
+// Accuracy counts a project's triage decisions since a time and how reviewers judged them
+func (r *TicketTriageRepository) Accuracy(ctx context.Context, tenantID, projectID uuid.UUID, since time.Time) (*models.TicketTriageAccuracy, error) {
+	var counts struct {
+		Total                   int64 `db:"total"`
+		Reviewed                int64 `db:"reviewed"`
+		TypeCorrect             int64 `db:"type_correct"`
+		TypeAutoApplied         int64 `db:"type_auto_applied"`
+		TypeAutoAppliedCorrect  int64 `db:"type_auto_applied_correct"`
+		PriorityCorrect         int64 `db:"priority_correct"`
+		PriorityAutoApplied     int64 `db:"priority_auto_applied"`
+		PriorityAutoAppliedGood int64 `db:"priority_auto_applied_correct"`
+	}
+	query := `
+		SELECT
+			COUNT(*) AS total,
+			COUNT(*) FILTER (WHERE review_status <> 'pending') AS reviewed,
+			COUNT(*) FILTER (WHERE review_status <> 'pending' AND reviewed_type = type) AS type_correct,
+			COUNT(*) FILTER (WHERE review_status <> 'pending' AND 'type' = ANY(applied_fields)) AS type_auto_applied,
+			COUNT(*) FILTER (WHERE review_status <> 'pending' AND 'type' = ANY(applied_fields) AND reviewed_type = type) AS type_auto_applied_correct,
+			COUNT(*) FILTER (WHERE review_status <> 'pending' AND reviewed_priority = priority) AS priority_correct,
+			COUNT(*) FILTER (WHERE review_status <> 'pending' AND 'priority' = ANY(applied_fields)) AS priority_auto_applied,
+			COUNT(*) FILTER (WHERE review_status <> 'pending' AND 'priority' = ANY(applied_fields) AND reviewed_priority = priority) AS priority_auto_applied_correct
+		FROM ticket_triage
+		WHERE tenant_id = $1 AND project_id = $2 AND created_at >= $3`
+
+	if err := r.db.GetContext(ctx, &counts, query, tenantID, projectID, since); err != nil {
+		return nil, err
+	}
+
+	return &models.TicketTriageAccuracy{
+		Since:    since,
+		Total:    counts.Total,
+		Reviewed: counts.Reviewed,
+		Type: models.TicketTriageFieldAccuracy{
+			Reviewed:           counts.Reviewed,
+			Correct:            counts.TypeCorrect,
+			AutoApplied:        counts.TypeAutoApplied,
+			AutoAppliedCorrect: counts.TypeAutoAppliedCorrect,
+		},
+		Priority: models.TicketTriageFieldAccuracy{
+			Reviewed:           counts.Reviewed,
+			Correct:            counts.PriorityCorrect,
+			AutoApplied:        counts.PriorityAutoApplied,
+			AutoAppliedCorrect: counts.PriorityAutoAppliedGood,
+		},
+	}, nil
+}
@@ -0,0 +1,171 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type TranslationRepository struct {
+	db *sqlx.DB
+}
+
+func NewTranslationRepository(db *sqlx.DB) *TranslationRepository {
+	return &TranslationRepository{db: db}
+}
+
+// AgentLanguage returns the preferred language of an agent, or "" when the agent has not set one
+func (r *TranslationRepository) AgentLanguage(ctx context.Context, tenantID, agentID uuid.UUID) (string, error) {
+	var language sql.NullString
+	query := `SELECT preferred_language FROM agents WHERE tenant_id = $1 AND id = $2`
+	if err := r.db.GetContext(ctx, &language, query, tenantID, agentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return language.String, nil
+}
+
+// SaveLanguage stores the language detected in a customer message, replacing an earlier detection
+func (r *TranslationRepository) SaveLanguage(ctx context.Context, language *models.MessageLanguage) error {
+	query := `
+		INSERT INTO message_languages (
+			message_kind, message_id, tenant_id, project_id, conversation_id, language, backend, created_at
+		) VALUES (
+			:message_kind, :message_id, :tenant_id, :project_id, :conversation_id, :language, :backend, NOW()
+		)
+		ON CONFLICT (message_kind, message_id) DO UPDATE SET
+			language = EXCLUDED.language,
+			backend = EXCLUDED.backend
+		RETURNING created_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, language)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&language.CreatedAt)
+	}
+	return rows.Err()
+}
+
+// ConversationLanguage returns the language of the latest customer message of a chat session or ticket,
+// or "" when none was detected
+func (r *TranslationRepository) ConversationLanguage(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID) (string, error) {
+	var language string
+	query := `
+		SELECT language FROM message_languages
+		WHERE tenant_id = $1 AND project_id = $2 AND message_kind = $3 AND conversation_id = $4
+		ORDER BY created_at DESC
+		LIMIT 1`
+	if err := r.db.GetContext(ctx, &language, query, tenantID, projectID, kind, conversationID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return language, nil
+}
+
+// GetTranslation retrieves the translation of a message into a language, or nil when there is none
+func (r *TranslationRepository) GetTranslation(ctx context.Context, kind string, messageID uuid.UUID, targetLanguage string) (*models.MessageTranslation, error) {
+	var translation models.MessageTranslation
+	query := `
+		SELECT id, tenant_id, project_id, message_kind, message_id, conversation_id, source_language,
+			target_language, translated_text, backend, created_at
+		FROM message_translations
+		WHERE message_kind = $1 AND message_id = $2 AND target_language = $3`
+	if err := r.db.GetContext(ctx, &translation, query, kind, messageID, targetLanguage); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &translation, nil
+}
+
+// SaveTranslation stores the translation of a message, replacing an earlier one into the same language
+func (r *TranslationRepository) SaveTranslation(ctx context.Context, translation *models.MessageTranslation) error {
+	query := `
+		INSERT INTO message_translations (
+			tenant_id, project_id, message_kind, message_id, conversation_id, source_language,
+			target_language, translated_text, backend, created_at
+		) VALUES (
+			:tenant_id, :project_id, :message_kind, :message_id, :conversation_id, :source_language,
+			:target_language, :translated_text, :backend, NOW()
+		)
+		ON CONFLICT (message_kind, message_id, target_language) DO UPDATE SET
+			source_language = EXCLUDED.source_language,
+			translated_text = EXCLUDED.translated_text,
+			backend = EXCLUDED.backend
+		RETURNING id, created_at`
+
+	rows, err := r.db.NamedQueryContext(ctx, query, translation)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&translation.ID, &translation.CreatedAt)
+	}
+	return rows.Err()
+}
+
+// ListTranslations lists the translations of the messages of a chat session or ticket into a language,
+// oldest first
+func (r *TranslationRepository) ListTranslations(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, targetLanguage string) ([]*models.MessageTranslation, error) {
+	translations := []*models.MessageTranslation{}
+	query := `
+		SELECT id, tenant_id, project_id, message_kind, message_id, conversation_id, source_language,
+			target_language, translated_text, backend, created_at
+		FROM message_translations
+		WHERE tenant_id = $1 AND project_id = $2 AND message_kind = $3 AND conversation_id = $4
+			AND target_language = $5
+		ORDER BY created_at ASC`
+	if err := r.db.SelectContext(ctx, &translations, query, tenantID, projectID, kind, conversationID, targetLanguage); err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
+
+// ListUntranslated lists the customer messages of a chat session or ticket, oldest first, that are in
+// another language than the given one and have no translation into it yet
+func (r *TranslationRepository) ListUntranslated(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, targetLanguage string, limit int) ([]*models.UntranslatedMessage, error) {
+	var join, text string
+	switch kind {
+	case models.MessageKindChat:
+		join, text = `JOIN chat_messages m ON m.id = l.message_id`, `m.content`
+	case models.MessageKindTicket:
+		join, text = `JOIN ticket_messages m ON m.id = l.message_id`, `m.body`
+	default:
+		return nil, fmt.Errorf("unknown message kind %q", kind)
+	}
+
+	messages := []*models.UntranslatedMessage{}
+	query := `
+		SELECT l.message_id, l.language, ` + text + ` AS text
+		FROM message_languages l
+		` + join + `
+		WHERE l.tenant_id = $1 AND l.project_id = $2 AND l.message_kind = $3 AND l.conversation_id = $4
+			AND l.language <> $5
+			AND NOT EXISTS (
+				SELECT 1 FROM message_translations t
+				WHERE t.message_kind = l.message_kind AND t.message_id = l.message_id AND t.target_language = $5
+			)
+		ORDER BY l.created_at ASC
+		LIMIT $6`
+	if err := r.db.SelectContext(ctx, &messages, query, tenantID, projectID, kind, conversationID, targetLanguage, limit); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/models"
@@ -19,14 +20,16 @@ type AgentService struct {
 	agentRepo   repo.AgentRepository
 	projectRepo repo.ProjectRepository
 	rbacService *rbac.Service
+	planService *PlanService
 }
 
 // NewAgentService creates a new agent service
-func NewAgentService(agentRepo repo.AgentRepository, projectRepo repo.ProjectRepository, rbacService *rbac.Service) *AgentService {
+func NewAgentService(agentRepo repo.AgentRepository, projectRepo repo.ProjectRepository, rbacService *rbac.Service, planService *PlanService) *AgentService {
 	return &AgentService{
 		agentRepo:   agentRepo,
 		projectRepo: projectRepo,
 		rbacService: rbacService,
+		planService: planService,
 	}
 }
 
@@ -47,6 +50,11 @@ func (s *AgentService) CreateAgent(ctx context.Context, tenantID, creatorAgentID
 		return nil, fmt.Errorf("agent with email %s already exists", req.Email)
 	}
 
+	// Enforce the seat limit of the tenant's plan
+	if err := s.planService.CheckSeatLimit(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -83,6 +91,13 @@ type UpdateAgentRequest struct {
 	Name     *string `json:"name,omitempty" validate:"omitempty,min=1,max=255"`
 	IsActive *bool   `json:"is_active,omitempty"`
 	Password *string `json:"password,omitempty" validate:"omitempty,min=8"`
+
+	// MaxConcurrentChats caps how many chats the agent handles at once
+	MaxConcurrentChats *int `json:"max_concurrent_chats,omitempty" validate:"omitempty,min=1,max=100"`
+
+	// PreferredLanguage is the ISO 639-1 code customer messages are translated into for the agent; an
+	// empty string clears it
+	PreferredLanguage *string `json:"preferred_language,omitempty"`
 }
 
 // UpdateAgent updates an existing agent
@@ -110,6 +125,23 @@ func (s *AgentService) UpdateAgent(ctx context.Context, tenantID, agentID, updat
 			agent.Status = "inactive"
 		}
 	}
+	if req.MaxConcurrentChats != nil {
+		if *req.MaxConcurrentChats < 1 || *req.MaxConcurrentChats > 100 {
+			return nil, http.StatusBadRequest, fmt.Errorf("max_concurrent_chats must be between 1 and 100")
+		}
+		agent.MaxChats = *req.MaxConcurrentChats
+	}
+	if req.PreferredLanguage != nil {
+		language := strings.ToLower(strings.TrimSpace(*req.PreferredLanguage))
+		switch {
+		case language == "":
+			agent.PreferredLanguage = nil
+		case translationLanguagePattern.MatchString(language):
+			agent.PreferredLanguage = &language
+		default:
+			return nil, http.StatusBadRequest, fmt.Errorf("preferred_language must be an ISO 639-1 code")
+		}
+	}
 	if req.Password != nil {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
 		if err != nil {
@@ -170,7 +202,8 @@ func (s *AgentService) ListAgents(ctx context.Context, tenantID, requestorAgentI
 	return agents, nextCursor, nil
 }
 
-// AssignRoleRequest represents a role assignment request
+// AssignRoleRequest represents a role assignment request.
+// Role is a built-in role or the name of a tenant defined custom role.
 type AssignRoleRequest struct {
 	Role      models.RoleType `json:"role" validate:"required"`
 	ProjectID *string         `json:"project_id,omitempty"`
@@ -0,0 +1,488 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/redis"
+	ws "github.com/bareuptime/tms/internal/websocket"
+)
+
+const (
+	// presenceAwayAfter marks a connected agent away once heartbeats stop for this long;
+	// the agent console pings every 30 seconds
+	presenceAwayAfter = 90 * time.Second
+
+	// presenceOfflineAfter treats an agent as gone once heartbeats stop for this long, which
+	// also covers connections left behind by a replica that went away
+	presenceOfflineAfter = 3 * time.Minute
+
+	// presenceRetention keeps the record of a disconnected agent, so an explicit status survives reconnects
+	presenceRetention = 24 * time.Hour
+
+	// presenceWaitPerChat is the expected wait per visitor ahead in the queue and online agent
+	presenceWaitPerChat = 3 * time.Minute
+
+	presenceAgentsKey = "presence:agents"
+)
+
+var ErrInvalidPresenceStatus = errors.New("status must be one of online, away, busy or offline")
+
+// presenceLoadCounter counts chats per agent and per project queue; implemented by ChatSessionRepo
+type presenceLoadCounter interface {
+	CountActiveSessionsByAgent(ctx context.Context, tenantID uuid.UUID, agentIDs []uuid.UUID) (map[uuid.UUID]int, error)
+	CountQueuedSessions(ctx context.Context, tenantID, projectID uuid.UUID) (int, error)
+}
+
+// presenceBroadcaster delivers presence changes; implemented by the websocket ConnectionManager
+type presenceBroadcaster interface {
+	DeliverWebSocketMessage(sessionID uuid.UUID, message *ws.Message) error
+}
+
+// ChatAvailability is how soon a visitor handed off to a human can expect an answer
+type ChatAvailability struct {
+	OnlineAgents  int
+	EstimatedWait time.Duration // zero when an agent has room for another chat
+}
+
+// presenceRecord is an agent's presence as stored in Redis, shared by all replicas
+type presenceRecord struct {
+	AgentID     uuid.UUID
+	TenantID    uuid.UUID
+	Name        string
+	MaxChats    int
+	ProjectIDs  []uuid.UUID
+	Status      string // explicit status; empty follows the connection
+	Connections int
+	LastSeen    time.Time
+	Published   string // status last broadcast to the project agents
+}
+
+// effectiveStatus derives the status other agents see: an explicit status while connected,
+// otherwise online, or away once heartbeats stop
+func (r *presenceRecord) effectiveStatus(now time.Time) string {
+	idle := now.Sub(r.LastSeen)
+	if r.Connections <= 0 || idle > presenceOfflineAfter {
+		return string(db.AgentStatusOffline)
+	}
+	if r.Status != "" {
+		return r.Status
+	}
+	if idle > presenceAwayAfter {
+		return string(db.AgentStatusAway)
+	}
+	return string(db.AgentStatusOnline)
+}
+
+// AgentPresenceService tracks which agents are online, away, busy or offline, from explicit status
+// changes and agent WebSocket heartbeats, and how many chats each of them is handling
+type AgentPresenceService struct {
+	redisService *redis.Service
+	load         presenceLoadCounter
+	broadcaster  presenceBroadcaster
+}
+
+// NewAgentPresenceService creates a new agent presence service
+func NewAgentPresenceService(redisService *redis.Service, load presenceLoadCounter, broadcaster presenceBroadcaster) *AgentPresenceService {
+	return &AgentPresenceService{
+		redisService: redisService,
+		load:         load,
+		broadcaster:  broadcaster,
+	}
+}
+
+func presenceAgentKey(agentID uuid.UUID) string {
+	return fmt.Sprintf("presence:agent:%s", agentID)
+}
+
+func presenceProjectKey(projectID uuid.UUID) string {
+	return fmt.Sprintf("presence:project:%s", projectID)
+}
+
+// Connect records a new agent WebSocket connection
+func (s *AgentPresenceService) Connect(ctx context.Context, agent *db.Agent, projectIDs []uuid.UUID, now time.Time) error {
+	projects := make([]string, len(projectIDs))
+	for i, projectID := range projectIDs {
+		projects[i] = projectID.String()
+	}
+	maxChats := agent.MaxChats
+	if maxChats <= 0 {
+		maxChats = db.DefaultMaxConcurrentChats
+	}
+
+	key := presenceAgentKey(agent.ID)
+	_, err := s.redisService.GetClient().TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"tenant_id", agent.TenantID.String(),
+			"name", agent.Name,
+			"max_chats", maxChats,
+			"projects", strings.Join(projects, ","),
+			"last_seen", now.Unix(),
+		)
+		pipe.HIncrBy(ctx, key, "connections", 1)
+		pipe.Expire(ctx, key, presenceRetention)
+		pipe.SAdd(ctx, presenceAgentsKey, agent.ID.String())
+		for _, projectID := range projectIDs {
+			pipe.SAdd(ctx, presenceProjectKey(projectID), agent.ID.String())
+			pipe.Expire(ctx, presenceProjectKey(projectID), presenceRetention)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record agent connection: %w", err)
+	}
+
+	s.publishIfChanged(ctx, agent.ID, now)
+	return nil
+}
+
+// Heartbeat records that an agent connection is still alive
+func (s *AgentPresenceService) Heartbeat(ctx context.Context, agentID uuid.UUID, now time.Time) error {
+	key := presenceAgentKey(agentID)
+	exists, err := s.redisService.GetClient().Exists(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read agent presence: %w", err)
+	}
+	if exists == 0 {
+		return nil
+	}
+	if err := s.redisService.GetClient().HSet(ctx, key, "last_seen", now.Unix()).Err(); err != nil {
+		return fmt.Errorf("failed to record agent heartbeat: %w", err)
+	}
+
+	s.publishIfChanged(ctx, agentID, now)
+	return nil
+}
+
+// Disconnect records an agent WebSocket connection closing; the agent goes offline with its last connection
+func (s *AgentPresenceService) Disconnect(ctx context.Context, agentID uuid.UUID, now time.Time) error {
+	key := presenceAgentKey(agentID)
+	connections, err := s.redisService.GetClient().HIncrBy(ctx, key, "connections", -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to record agent disconnection: %w", err)
+	}
+	if connections < 0 {
+		s.redisService.GetClient().HSet(ctx, key, "connections", 0)
+	}
+
+	s.publishIfChanged(ctx, agentID, now)
+	return nil
+}
+
+// SetStatus sets an agent's status explicitly. Online clears it, so the status follows the connection again.
+func (s *AgentPresenceService) SetStatus(ctx context.Context, tenantID, agentID uuid.UUID, status string, now time.Time) (*models.AgentPresence, error) {
+	switch db.AgentStatus(status) {
+	case db.AgentStatusOnline:
+		status = ""
+	case db.AgentStatusAway, db.AgentStatusBusy, db.AgentStatusOffline:
+	default:
+		return nil, ErrInvalidPresenceStatus
+	}
+
+	key := presenceAgentKey(agentID)
+	_, err := s.redisService.GetClient().TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HSet(ctx, key, "tenant_id", tenantID.String(), "status", status)
+		pipe.Expire(ctx, key, presenceRetention)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set agent status: %w", err)
+	}
+
+	s.publishIfChanged(ctx, agentID, now)
+	return s.GetPresence(ctx, tenantID, agentID, now)
+}
+
+// GetPresence returns an agent's current presence
+func (s *AgentPresenceService) GetPresence(ctx context.Context, tenantID, agentID uuid.UUID, now time.Time) (*models.AgentPresence, error) {
+	record, err := s.getRecord(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.TenantID != tenantID {
+		return &models.AgentPresence{AgentID: agentID, Status: string(db.AgentStatusOffline), MaxChats: db.DefaultMaxConcurrentChats}, nil
+	}
+
+	presences, err := s.withLoad(ctx, tenantID, []*presenceRecord{record}, now)
+	if err != nil {
+		return nil, err
+	}
+	return presences[0], nil
+}
+
+// ListProjectPresence lists the agents of a project who are not offline with their current load,
+// available agents first
+func (s *AgentPresenceService) ListProjectPresence(ctx context.Context, tenantID, projectID uuid.UUID, now time.Time) ([]*models.AgentPresence, error) {
+	client := s.redisService.GetClient()
+	members, err := client.SMembers(ctx, presenceProjectKey(projectID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project presence: %w", err)
+	}
+
+	var records []*presenceRecord
+	for _, member := range members {
+		agentID, err := uuid.Parse(member)
+		if err != nil {
+			continue
+		}
+		record, err := s.getRecord(ctx, agentID)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			client.SRem(ctx, presenceProjectKey(projectID), member)
+			continue
+		}
+		if record.TenantID != tenantID || !slices.Contains(record.ProjectIDs, projectID) {
+			continue
+		}
+		if record.effectiveStatus(now) == string(db.AgentStatusOffline) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	presences, err := s.withLoad(ctx, tenantID, records, now)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(presences, func(i, j int) bool {
+		if presences[i].Available != presences[j].Available {
+			return presences[i].Available
+		}
+		if presences[i].Status != presences[j].Status {
+			return presences[i].Status < presences[j].Status
+		}
+		return presences[i].AgentName < presences[j].AgentName
+	})
+	return presences, nil
+}
+
+// ProjectAvailability tells how many agents of a project are online and roughly how long a newly queued
+// visitor waits: nothing while an online agent has room, otherwise a share of the queue ahead of them
+func (s *AgentPresenceService) ProjectAvailability(ctx context.Context, tenantID, projectID uuid.UUID, now time.Time) (*ChatAvailability, error) {
+	presences, err := s.ListProjectPresence(ctx, tenantID, projectID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	availability := &ChatAvailability{}
+	freeSlots := 0
+	for _, presence := range presences {
+		if presence.Status != string(db.AgentStatusOnline) {
+			continue
+		}
+		availability.OnlineAgents++
+		if presence.ActiveChats < presence.MaxChats {
+			freeSlots += presence.MaxChats - presence.ActiveChats
+		}
+	}
+	if availability.OnlineAgents == 0 {
+		return availability, nil
+	}
+
+	queued, err := s.load.CountQueuedSessions(ctx, tenantID, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count queued sessions: %w", err)
+	}
+	// The visitor being handed off is already in the queue
+	ahead := queued - 1
+	if ahead < freeSlots {
+		return availability, nil
+	}
+	availability.EstimatedWait = time.Duration((ahead-freeSlots)/availability.OnlineAgents+1) * presenceWaitPerChat
+	return availability, nil
+}
+
+// RunSweeper broadcasts presence changes that come from time passing (heartbeats stopping) every interval
+// until ctx is cancelled
+func (s *AgentPresenceService) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx, time.Now()); err != nil {
+			logger.ErrorfCtx(ctx, err, "Agent presence sweep failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep re-derives the status of every known agent and broadcasts those that changed
+func (s *AgentPresenceService) Sweep(ctx context.Context, now time.Time) (int, error) {
+	client := s.redisService.GetClient()
+	members, err := client.SMembers(ctx, presenceAgentsKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list agents with presence: %w", err)
+	}
+
+	changed := 0
+	for _, member := range members {
+		agentID, err := uuid.Parse(member)
+		if err != nil {
+			client.SRem(ctx, presenceAgentsKey, member)
+			continue
+		}
+		exists, err := client.Exists(ctx, presenceAgentKey(agentID)).Result()
+		if err != nil {
+			return changed, fmt.Errorf("failed to read agent presence: %w", err)
+		}
+		if exists == 0 {
+			client.SRem(ctx, presenceAgentsKey, member)
+			continue
+		}
+		if s.publishIfChanged(ctx, agentID, now) {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// publishIfChanged broadcasts an agent's presence to the agents of its projects when the derived
+// status differs from the one last broadcast
+func (s *AgentPresenceService) publishIfChanged(ctx context.Context, agentID uuid.UUID, now time.Time) bool {
+	record, err := s.getRecord(ctx, agentID)
+	if err != nil || record == nil {
+		return false
+	}
+	status := record.effectiveStatus(now)
+	if status == record.Published {
+		return false
+	}
+	if err := s.redisService.GetClient().HSet(ctx, presenceAgentKey(agentID), "published", status).Err(); err != nil {
+		logger.WarnfCtx(ctx, "Failed to record published presence of agent %s: %v", agentID, err)
+		return false
+	}
+
+	if s.broadcaster == nil || record.TenantID == uuid.Nil {
+		return true
+	}
+	presences, err := s.withLoad(ctx, record.TenantID, []*presenceRecord{record}, now)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Failed to count chats of agent %s: %v", agentID, err)
+		return true
+	}
+	data, _ := json.Marshal(presences[0])
+
+	for _, projectID := range record.ProjectIDs {
+		projectID := projectID
+		tenantID := record.TenantID
+		noAgent := uuid.Nil
+		// Visitor-side messages without an agent are routed to every agent of the project
+		msg := &ws.Message{
+			Type:         string(models.WSMsgTypeAgentPresence),
+			SessionID:    agentID,
+			Data:         data,
+			FromType:     ws.ConnectionTypeVisitor,
+			ProjectID:    &projectID,
+			TenantID:     &tenantID,
+			AgentID:      &noAgent,
+			DeliveryType: ws.Direct,
+			Timestamp:    now,
+		}
+		if err := s.broadcaster.DeliverWebSocketMessage(agentID, msg); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to broadcast presence of agent %s to project %s", agentID, projectID)
+		}
+	}
+	return true
+}
+
+// withLoad turns presence records into presences with each agent's active chat count
+func (s *AgentPresenceService) withLoad(ctx context.Context, tenantID uuid.UUID, records []*presenceRecord, now time.Time) ([]*models.AgentPresence, error) {
+	agentIDs := make([]uuid.UUID, len(records))
+	for i, record := range records {
+		agentIDs[i] = record.AgentID
+	}
+	counts, err := s.load.CountActiveSessionsByAgent(ctx, tenantID, agentIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count active chats: %w", err)
+	}
+
+	presences := make([]*models.AgentPresence, len(records))
+	for i, record := range records {
+		status := record.effectiveStatus(now)
+		lastSeen := record.LastSeen
+		presence := &models.AgentPresence{
+			AgentID:     record.AgentID,
+			AgentName:   record.Name,
+			Status:      status,
+			ActiveChats: counts[record.AgentID],
+			MaxChats:    record.MaxChats,
+			LastSeenAt:  &lastSeen,
+		}
+		if record.LastSeen.IsZero() {
+			presence.LastSeenAt = nil
+		}
+		presence.Available = status == string(db.AgentStatusOnline) && presence.ActiveChats < presence.MaxChats
+		presences[i] = presence
+	}
+	return presences, nil
+}
+
+func (s *AgentPresenceService) getRecord(ctx context.Context, agentID uuid.UUID) (*presenceRecord, error) {
+	fields, err := s.redisService.GetClient().HGetAll(ctx, presenceAgentKey(agentID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent presence: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	record := &presenceRecord{
+		AgentID:   agentID,
+		Name:      fields["name"],
+		Status:    fields["status"],
+		Published: fields["published"],
+		MaxChats:  db.DefaultMaxConcurrentChats,
+	}
+	record.TenantID, _ = uuid.Parse(fields["tenant_id"])
+	if maxChats, err := strconv.Atoi(fields["max_chats"]); err == nil && maxChats > 0 {
+		record.MaxChats = maxChats
+	}
+	record.Connections, _ = strconv.Atoi(fields["connections"])
+	if lastSeen, err := strconv.ParseInt(fields["last_seen"], 10, 64); err == nil {
+		record.LastSeen = time.Unix(lastSeen, 0)
+	}
+	for _, project := range strings.Split(fields["projects"], ",") {
+		if projectID, err := uuid.Parse(project); err == nil {
+			record.ProjectIDs = append(record.ProjectIDs, projectID)
+		}
+	}
+	return record, nil
+}
+
+// handoffNotice tells a visitor handed off to a human how long they will wait, or that nobody is online.
+// A nil availability means presence is unknown and keeps the generic notice.
+func handoffNotice(availability *ChatAvailability) string {
+	const connecting = "I'll connect you with a human agent who can better assist you."
+	switch {
+	case availability == nil || (availability.OnlineAgents > 0 && availability.EstimatedWait == 0):
+		return connecting + " Please wait a moment."
+	case availability.OnlineAgents == 0:
+		return "I'll pass this on to our team, but no agents are online right now. Leave your email and we'll get back to you as soon as we can."
+	default:
+		minutes := int(availability.EstimatedWait.Round(time.Minute) / time.Minute)
+		if minutes <= 1 {
+			return connecting + " The estimated wait is about a minute."
+		}
+		return fmt.Sprintf("%s The estimated wait is about %d minutes.", connecting, minutes)
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/redis"
+	ws "github.com/bareuptime/tms/internal/websocket"
+)
+
+type fakePresenceLoad struct {
+	active map[uuid.UUID]int
+	queued int
+}
+
+func (f *fakePresenceLoad) CountActiveSessionsByAgent(ctx context.Context, tenantID uuid.UUID, agentIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	counts := make(map[uuid.UUID]int)
+	for _, agentID := range agentIDs {
+		counts[agentID] = f.active[agentID]
+	}
+	return counts, nil
+}
+
+func (f *fakePresenceLoad) CountQueuedSessions(ctx context.Context, tenantID, projectID uuid.UUID) (int, error) {
+	return f.queued, nil
+}
+
+type fakePresenceBroadcaster struct {
+	messages []*ws.Message
+}
+
+func (f *fakePresenceBroadcaster) DeliverWebSocketMessage(sessionID uuid.UUID, message *ws.Message) error {
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+func (f *fakePresenceBroadcaster) statuses(t *testing.T) []string {
+	t.Helper()
+	var statuses []string
+	for _, message := range f.messages {
+		var presence models.AgentPresence
+		require.NoError(t, json.Unmarshal(message.Data, &presence))
+		statuses = append(statuses, presence.Status)
+	}
+	return statuses
+}
+
+func newTestAgentPresenceService(t *testing.T) (*AgentPresenceService, *fakePresenceLoad, *fakePresenceBroadcaster) {
+	t.Helper()
+
+	mini, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mini.Close)
+
+	redisService := redis.NewService(redis.RedisConfig{
+		URL:         fmt.Sprintf("redis://%s", mini.Addr()),
+		Environment: "test",
+	})
+	load := &fakePresenceLoad{active: make(map[uuid.UUID]int)}
+	broadcaster := &fakePresenceBroadcaster{}
+	return NewAgentPresenceService(redisService, load, broadcaster), load, broadcaster
+}
+
+func newPresenceAgent(tenantID uuid.UUID, name string, maxChats int) *db.Agent {
+	return &db.Agent{ID: uuid.New(), TenantID: tenantID, Name: name, MaxChats: maxChats}
+}
+
+func TestAgentPresenceFollowsConnectionAndHeartbeats(t *testing.T) {
+	ctx := context.Background()
+	svc, _, broadcaster := newTestAgentPresenceService(t)
+	tenantID, projectID := uuid.New(), uuid.New()
+	agent := newPresenceAgent(tenantID, "Ada", 3)
+	start := time.Now()
+
+	require.NoError(t, svc.Connect(ctx, agent, []uuid.UUID{projectID}, start))
+	presence, err := svc.GetPresence(ctx, tenantID, agent.ID, start)
+	require.NoError(t, err)
+	require.Equal(t, "online", presence.Status)
+	require.True(t, presence.Available)
+
+	// Heartbeats stop: away, then offline
+	_, err = svc.Sweep(ctx, start.Add(2*time.Minute))
+	require.NoError(t, err)
+	_, err = svc.Sweep(ctx, start.Add(5*time.Minute))
+	require.NoError(t, err)
+
+	// A heartbeat brings the agent back, and disconnecting the last connection takes them offline
+	require.NoError(t, svc.Heartbeat(ctx, agent.ID, start.Add(6*time.Minute)))
+	require.NoError(t, svc.Disconnect(ctx, agent.ID, start.Add(7*time.Minute)))
+
+	require.Equal(t, []string{"online", "away", "offline", "online", "offline"}, broadcaster.statuses(t))
+	require.Equal(t, projectID, *broadcaster.messages[0].ProjectID)
+	require.Equal(t, string(models.WSMsgTypeAgentPresence), broadcaster.messages[0].Type)
+}
+
+func TestAgentPresenceExplicitStatusAndMultipleConnections(t *testing.T) {
+	ctx := context.Background()
+	svc, _, broadcaster := newTestAgentPresenceService(t)
+	tenantID, projectID := uuid.New(), uuid.New()
+	agent := newPresenceAgent(tenantID, "Ada", 3)
+	now := time.Now()
+
+	require.NoError(t, svc.Connect(ctx, agent, []uuid.UUID{projectID}, now))
+	require.NoError(t, svc.Connect(ctx, agent, []uuid.UUID{projectID}, now))
+
+	presence, err := svc.SetStatus(ctx, tenantID, agent.ID, "busy", now)
+	require.NoError(t, err)
+	require.Equal(t, "busy", presence.Status)
+	require.False(t, presence.Available)
+
+	_, err = svc.SetStatus(ctx, tenantID, agent.ID, "dnd", now)
+	require.ErrorIs(t, err, ErrInvalidPresenceStatus)
+
+	// A second tab closing keeps the agent connected
+	require.NoError(t, svc.Disconnect(ctx, agent.ID, now))
+	presence, err = svc.GetPresence(ctx, tenantID, agent.ID, now)
+	require.NoError(t, err)
+	require.Equal(t, "busy", presence.Status)
+
+	presence, err = svc.SetStatus(ctx, tenantID, agent.ID, "online", now)
+	require.NoError(t, err)
+	require.Equal(t, "online", presence.Status)
+
+	require.Equal(t, []string{"online", "busy", "online"}, broadcaster.statuses(t))
+}
+
+func TestListProjectPresenceReportsLoad(t *testing.T) {
+	ctx := context.Background()
+	svc, load, _ := newTestAgentPresenceService(t)
+	tenantID, projectID := uuid.New(), uuid.New()
+	now := time.Now()
+
+	full := newPresenceAgent(tenantID, "Ada", 2)
+	free := newPresenceAgent(tenantID, "Bo", 2)
+	gone := newPresenceAgent(tenantID, "Cy", 2)
+	otherProject := newPresenceAgent(tenantID, "Di", 2)
+	for _, agent := range []*db.Agent{full, free, gone} {
+		require.NoError(t, svc.Connect(ctx, agent, []uuid.UUID{projectID}, now))
+	}
+	require.NoError(t, svc.Connect(ctx, otherProject, []uuid.UUID{uuid.New()}, now))
+	require.NoError(t, svc.Disconnect(ctx, gone.ID, now))
+	load.active[full.ID] = 2
+	load.active[free.ID] = 1
+
+	presences, err := svc.ListProjectPresence(ctx, tenantID, projectID, now)
+	require.NoError(t, err)
+	require.Len(t, presences, 2)
+	require.Equal(t, free.ID, presences[0].AgentID)
+	require.True(t, presences[0].Available)
+	require.Equal(t, 1, presences[0].ActiveChats)
+	require.Equal(t, full.ID, presences[1].AgentID)
+	require.False(t, presences[1].Available)
+
+	// Another tenant sees nobody
+	presences, err = svc.ListProjectPresence(ctx, uuid.New(), projectID, now)
+	require.NoError(t, err)
+	require.Empty(t, presences)
+}
+
+func TestProjectAvailabilityEstimatesWait(t *testing.T) {
+	ctx := context.Background()
+	svc, load, _ := newTestAgentPresenceService(t)
+	tenantID, projectID := uuid.New(), uuid.New()
+	now := time.Now()
+
+	availability, err := svc.ProjectAvailability(ctx, tenantID, projectID, now)
+	require.NoError(t, err)
+	require.Zero(t, availability.OnlineAgents)
+	require.Contains(t, handoffNotice(availability), "no agents are online")
+
+	first := newPresenceAgent(tenantID, "Ada", 2)
+	second := newPresenceAgent(tenantID, "Bo", 2)
+	require.NoError(t, svc.Connect(ctx, first, []uuid.UUID{projectID}, now))
+	require.NoError(t, svc.Connect(ctx, second, []uuid.UUID{projectID}, now))
+	load.active[first.ID] = 2
+	load.active[second.ID] = 1
+
+	// One free slot and nobody else waiting
+	load.queued = 1
+	availability, err = svc.ProjectAvailability(ctx, tenantID, projectID, now)
+	require.NoError(t, err)
+	require.Equal(t, 2, availability.OnlineAgents)
+	require.Zero(t, availability.EstimatedWait)
+	require.Contains(t, handoffNotice(availability), "Please wait a moment")
+
+	// Five visitors ahead with one free slot, shared by two agents
+	load.queued = 6
+	availability, err = svc.ProjectAvailability(ctx, tenantID, projectID, now)
+	require.NoError(t, err)
+	require.Equal(t, 9*time.Minute, availability.EstimatedWait)
+	require.Contains(t, handoffNotice(availability), "about 9 minutes")
+
+	// Busy agents do not take chats
+	_, err = svc.SetStatus(ctx, tenantID, first.ID, "busy", now)
+	require.NoError(t, err)
+	_, err = svc.SetStatus(ctx, tenantID, second.ID, "away", now)
+	require.NoError(t, err)
+	availability, err = svc.ProjectAvailability(ctx, tenantID, projectID, now)
+	require.NoError(t, err)
+	require.Zero(t, availability.OnlineAgents)
+
+	require.Contains(t, handoffNotice(nil), "Please wait a moment")
+}
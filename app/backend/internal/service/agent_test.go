@@ -3,12 +3,16 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/models"
 	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/bareuptime/tms/internal/redis"
 	"github.com/bareuptime/tms/internal/repo"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
@@ -107,7 +111,7 @@ func TestAgentServiceCreateAgentSuccess(t *testing.T) {
 
 	rbacService := rbac.NewService(dbConn)
 
-	svc := NewAgentService(mockRepo, nil, rbacService)
+	svc := NewAgentService(mockRepo, nil, rbacService, nil)
 
 	agent, err := svc.CreateAgent(ctx, tenantID, uuid.New(), CreateAgentRequest{
 		Email:    "agent@example.com",
@@ -140,7 +144,7 @@ func TestAgentServiceCreateAgentDuplicate(t *testing.T) {
 	require.NoError(t, err)
 	defer dbConn.Close()
 
-	svc := NewAgentService(mockRepo, nil, rbac.NewService(dbConn))
+	svc := NewAgentService(mockRepo, nil, rbac.NewService(dbConn), nil)
 
 	_, err = svc.CreateAgent(ctx, tenantID, uuid.New(), CreateAgentRequest{
 		Email:    "agent@example.com",
@@ -152,3 +156,59 @@ func TestAgentServiceCreateAgentDuplicate(t *testing.T) {
 	require.Contains(t, err.Error(), "already exists")
 	require.Zero(t, len(mockRepo.createdAgents))
 }
+
+func TestAgentServiceAssignToProjectCustomRole(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tenantID := uuid.New()
+	projectID := uuid.New()
+	agentID := uuid.New()
+
+	dbConn, sqlMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer dbConn.Close()
+
+	mini, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mini.Close()
+
+	rbacService := rbac.NewService(dbConn)
+	rbacService.SetRedisService(redis.NewService(redis.RedisConfig{
+		URL:         fmt.Sprintf("redis://%s", mini.Addr()),
+		Environment: "test",
+	}))
+
+	roleColumns := []string{"id", "tenant_id", "name", "description", "permissions", "created_at", "updated_at"}
+	sqlMock.ExpectQuery("FROM tenant_roles").
+		WithArgs(tenantID).
+		WillReturnRows(sqlmock.NewRows(roleColumns).
+			AddRow(uuid.New(), tenantID, "knowledge-editor", nil, "{knowledge:read,knowledge:write}", time.Now(), time.Now()))
+	sqlMock.ExpectExec("INSERT INTO agent_project_roles").
+		WithArgs(agentID, tenantID, projectID, models.RoleType("knowledge-editor")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	svc := NewAgentService(&mockAgentRepository{}, nil, rbacService, nil)
+
+	err = svc.AssignToProject(ctx, tenantID, agentID, AssignToProjectRequest{
+		ProjectID: projectID,
+		Role:      "knowledge-editor",
+	})
+	require.NoError(t, err)
+
+	// Role definitions are now served from the cache without hitting the database
+	granted, err := rbacService.TenantRoleHasPermission(ctx, tenantID, "knowledge-editor", rbac.PermKnowledgeWrite)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	granted, err = rbacService.TenantRoleHasPermission(ctx, tenantID, "knowledge-editor", rbac.PermBillingRead)
+	require.NoError(t, err)
+	require.False(t, granted)
+
+	err = svc.AssignToProject(ctx, tenantID, agentID, AssignToProjectRequest{
+		ProjectID: projectID,
+		Role:      "billing-viewer",
+	})
+	require.ErrorIs(t, err, rbac.ErrInvalidRole)
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
@@ -1,17 +1,16 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
 
 	"github.com/bareuptime/tms/internal/config"
 	"github.com/bareuptime/tms/internal/logger"
@@ -30,12 +29,19 @@ type AIService struct {
 	brandGreeting       *BrandGreetingService
 	connectionManager   *ws.ConnectionManager
 	howlingAlarmService *HowlingAlarmService
-	httpClient          *http.Client
+	presenceService     *AgentPresenceService
+	profileService      *AIProfileService
+	toolService         *AIToolService
+	guardrails          *AIGuardrailService
+	answerCache         *AIAnswerCacheService
+	llm                 *LLMRouter
+	contextSummaries    aiContextSummaryStore
+	tokenCounter        aiTokenCounter
 }
 
 // NewAIService creates a new AI service instance
-func NewAIService(cfg *config.AIConfig, agenticConfig *config.AgenticConfig, chatSessionService *ChatSessionService, knowledgeService *KnowledgeService, usageService *AIUsageService, greetingDetection *GreetingDetectionService, brandGreeting *BrandGreetingService, connectionManager *ws.ConnectionManager, howlingAlarmService *HowlingAlarmService) *AIService {
-	return &AIService{
+func NewAIService(cfg *config.AIConfig, agenticConfig *config.AgenticConfig, chatSessionService *ChatSessionService, knowledgeService *KnowledgeService, usageService *AIUsageService, greetingDetection *GreetingDetectionService, brandGreeting *BrandGreetingService, connectionManager *ws.ConnectionManager, howlingAlarmService *HowlingAlarmService, presenceService *AgentPresenceService, profileService *AIProfileService, toolService *AIToolService, guardrails *AIGuardrailService) *AIService {
+	service := &AIService{
 		config:              cfg,
 		agenticConfig:       agenticConfig,
 		chatSessionService:  chatSessionService,
@@ -45,10 +51,17 @@ func NewAIService(cfg *config.AIConfig, agenticConfig *config.AgenticConfig, cha
 		brandGreeting:       brandGreeting,
 		connectionManager:   connectionManager,
 		howlingAlarmService: howlingAlarmService,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		presenceService:     presenceService,
+		profileService:      profileService,
+		toolService:         toolService,
+		guardrails:          guardrails,
+		llm:                 NewLLMRouter(cfg, &http.Client{}),
+		tokenCounter:        newTiktokenCounter(),
+	}
+	if chatSessionService != nil {
+		service.contextSummaries = chatSessionService
 	}
+	return service
 }
 
 // IsAgenticBehaviorEnabled checks if agentic behavior is enabled
@@ -82,12 +95,41 @@ type ChatCompletionRequest struct {
 	Messages    []ChatCompletionMessage `json:"messages"`
 	MaxTokens   int                     `json:"max_tokens"`
 	Temperature float64                 `json:"temperature"`
+	Tools       []ChatTool              `json:"tools,omitempty"`
 }
 
 // ChatCompletionMessage represents a message in the chat completion
 type ChatCompletionMessage struct {
-	Role    string      `json:"role"`
-	Content interface{} `json:"content"` // Can be string or []ContentPart for multi-modal
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"`                // Can be string or []ContentPart for multi-modal
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`   // Tools an assistant message asks to call
+	ToolCallID string      `json:"tool_call_id,omitempty"` // The call a "tool" message answers
+}
+
+// ChatTool is a function the model may ask to call
+type ChatTool struct {
+	Type     string           `json:"type"` // "function"
+	Function ChatToolFunction `json:"function"`
+}
+
+// ChatToolFunction describes a tool; Parameters is a JSON schema of its arguments
+type ChatToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall is a call of a tool the model asked for, with its arguments as a JSON object
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"` // "function"
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the tool of a call and carries its JSON arguments
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // ContentPart represents a part of multi-modal content (text or image)
@@ -138,17 +180,39 @@ func (s *AIService) ShouldHandleSession(ctx context.Context, session *models.Cha
 
 // ProcessMessage handles incoming visitor messages and generates AI responses
 func (s *AIService) ProcessMessage(ctx context.Context, session *models.ChatSession, messageContent, connID string) (*models.ChatMessage, error) {
+	// A thumbs down stops the answer it rates from being reused, whoever handles the session now
+	if s.answerCache != nil && strings.TrimSpace(messageContent) == aiAnswerCacheThumbsDown {
+		if err := s.answerCache.RejectLatestReply(ctx, session.TenantID, session.ProjectID, session.ID); err != nil {
+			fmt.Printf("Failed to reject cached answer for session %s: %v\n", session.ID, err)
+		}
+	}
+
 	if !s.ShouldHandleSession(ctx, session) {
 		fmt.Println("AI Service not handling session:", session.ID)
 		return nil, nil
 	}
 
+	// Stop AI replies once the tenant is out of credits
+	if !s.CheckCreditsOrHandoff(ctx, session, connID) {
+		return nil, nil
+	}
+
+	ctx = s.guardedContext(ctx, session.TenantID, session.ProjectID, &session.ID, nil)
+	profile := s.ResolveProfile(ctx, session)
+
 	// Check for handoff keywords first
-	if s.shouldHandoffToAgent(session, messageContent) {
+	if s.shouldHandoffToAgent(session, profile, messageContent) {
 		s.requestHumanAgent(ctx, session, "Customer requested human assistance", connID)
 		return nil, nil
 	}
 
+	// Messages that try to override the assistant's instructions are recorded, and go to a human when the
+	// project blocks them
+	if s.screenVisitorMessage(ctx, messageContent) {
+		s.requestHumanAgent(ctx, session, "Message looked like a prompt injection", connID)
+		return nil, nil
+	}
+
 	// Check if this is a greeting message (only if agentic behavior is enabled)
 	if s.IsGreetingDetectionEnabled() && s.greetingDetection != nil {
 		greetingResult := s.greetingDetection.DetectGreeting(ctx, messageContent)
@@ -165,11 +229,34 @@ func (s *AIService) ProcessMessage(ctx context.Context, session *models.ChatSess
 
 	// For complex messages, use the existing AI processing flow
 	go s.ProcessAiTyping(session, models.WSMessage{}, connID, true)
-	resp, err := s.processComplexMessage(ctx, session, messageContent, connID)
+	resp, err := s.processComplexMessage(ctx, session, profile, messageContent, connID)
 	go s.ProcessAiTyping(session, models.WSMessage{}, connID, false)
 	return resp, err
 }
 
+// CheckCreditsOrHandoff reports whether AI may reply in the session. When the tenant's credit
+// balance is exhausted the session is handed off to a human agent and false is returned.
+func (s *AIService) CheckCreditsOrHandoff(ctx context.Context, session *models.ChatSession, connID string) bool {
+	if s.usageService == nil {
+		return true
+	}
+
+	hasCredits, err := s.usageService.HasAvailableCredits(ctx, session.TenantID)
+	if err != nil {
+		// Don't block replies on a balance lookup failure
+		fmt.Printf("Failed to check AI credit balance for tenant %s: %v\n", session.TenantID, err)
+		return true
+	}
+
+	if !hasCredits {
+		fmt.Println("AI credits exhausted, handing off session:", session.ID)
+		s.requestHumanAgent(ctx, session, "AI credits exhausted", connID)
+		return false
+	}
+
+	return true
+}
+
 // processVisitorTyping handles visitor typing indicators
 func (s *AIService) ProcessAiTyping(session *models.ChatSession, msg models.WSMessage, connID string, isTyping bool) {
 
@@ -236,100 +323,180 @@ func (s *AIService) processComplexMessageThroughAgent(ctx context.Context, sessi
 		return nil, fmt.Errorf("failed to get conversation history: %w", err)
 	}
 
-	// Convert to slice and limit to recent messages
+	// How much of the history is sent is decided by the model's context budget
 	var recentMessages []models.ChatMessage
-	messageCount := len(messages)
-	startIdx := 0
-	if messageCount > 20 {
-		startIdx = messageCount - 20
-	}
-
-	for i := startIdx; i < messageCount; i++ {
-		if messages[i] != nil {
-			recentMessages = append(recentMessages, *messages[i])
+	for _, message := range messages {
+		if message != nil {
+			recentMessages = append(recentMessages, *message)
 		}
 	}
 
 	// Generate AI response with knowledge context
-	response, usageMetrics, err := s.generateResponseWithContext(ctx, session, recentMessages, messageContent)
+	profile := s.ResolveProfile(ctx, session)
+	sources := s.relevantKnowledge(ctx, session.TenantID, session.ProjectID, messageContent)
+	completion, err := s.generateResponseWithContext(ctx, session, profile, recentMessages, sources, nil, nil)
 	if err != nil {
 		fmt.Println("Error generating AI response:", err.Error())
 		return nil, fmt.Errorf("failed to generate AI response: %w", err)
 	}
+	response := completion.Content
 
 	fmt.Println("Response from ai -", response)
 
-	if usageMetrics != nil && s.usageService != nil {
+	if completion.Usage != nil && s.usageService != nil {
 		if _, err := s.usageService.DeductUsage(ctx, UsageDeductionInput{
 			TenantID:  session.TenantID,
 			ProjectID: session.ProjectID,
-			Model:     s.config.Model,
+			Provider:  string(completion.Provider),
+			Model:     completion.Model,
 			SessionID: &session.ID,
-			Metrics:   *usageMetrics,
+			Metrics:   *completion.Usage,
 		}); err != nil {
 			fmt.Printf("Failed to deduct AI usage credits: %v\n", err)
 		}
 	}
 
 	// Send the AI response
-	return s.SendAIResponse(ctx, session, connID, response, map[string]interface{}{
+	return s.SendAIResponseAs(ctx, session, connID, profile.AuthorName(), response, map[string]interface{}{
 		"ai_generated":  true,
 		"response_type": "knowledge_based",
 	})
 }
 
 // processComplexMessage handles non-greeting messages using AI and knowledge base
-func (s *AIService) processComplexMessage(ctx context.Context, session *models.ChatSession, messageContent, connID string) (*models.ChatMessage, error) {
+func (s *AIService) processComplexMessage(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, messageContent, connID string) (*models.ChatMessage, error) {
 	// Get conversation history
 	messages, err := s.chatSessionService.GetChatMessages(ctx, session.TenantID, session.ProjectID, session.ID, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get conversation history: %w", err)
 	}
 
-	// Convert to slice and limit to recent messages
+	// How much of the history is sent is decided by the model's context budget
 	var recentMessages []models.ChatMessage
-	messageCount := len(messages)
-	startIdx := 0
-	if messageCount > 20 {
-		startIdx = messageCount - 20
+	for _, message := range messages {
+		if message != nil {
+			recentMessages = append(recentMessages, *message)
+		}
+	}
+
+	// Questions that open a conversation are answered like an earlier question that means the same
+	cached, cacheQuery := s.lookupCachedAnswer(ctx, session, profile, messages, messageContent)
+	if cached != nil {
+		return s.SendAIResponseAs(ctx, session, connID, profile.AuthorName(), cached.Answer, map[string]interface{}{
+			"ai_generated":                  true,
+			"response_type":                 aiAnswerCacheResponseType,
+			aiAnswerCacheMetadataEntry:      cached.ID.String(),
+			aiAnswerCacheMetadataSimilarity: cached.Similarity,
+		})
+	}
+
+	// Knowledge is searched with the question embedded for the cache, when it was
+	var sources []models.KnowledgeSearchResult
+	if cacheQuery != nil {
+		sources = s.relevantKnowledgeFor(ctx, session.TenantID, session.ProjectID, cacheQuery.embedding)
+	} else {
+		sources = s.relevantKnowledge(ctx, session.TenantID, session.ProjectID, messageContent)
 	}
 
-	for i := startIdx; i < messageCount; i++ {
-		if messages[i] != nil {
-			recentMessages = append(recentMessages, *messages[i])
+	// Tools the assistant may call while answering; without them it answers from knowledge only
+	var tools *aiToolset
+	if s.toolService != nil {
+		tools, err = s.toolService.Toolset(ctx, session)
+		if err != nil {
+			fmt.Printf("Failed to load AI tools for session %s: %v\n", session.ID, err)
 		}
 	}
 
+	// Stream the reply to the chat as it is generated, unless it has to be checked for forbidden phrases
+	// before anyone sees it
+	var stream *aiReplyStream
+	var onDelta func(string)
+	if len(profile.ForbiddenPhrases) == 0 && s.connectionManager != nil {
+		stream = s.newReplyStream(session, connID, profile.AuthorName())
+		onDelta = stream.add
+	}
+
 	// Generate AI response with knowledge context
-	response, usageMetrics, err := s.generateResponseWithContext(ctx, session, recentMessages, messageContent)
+	completion, err := s.generateResponseWithContext(ctx, session, profile, recentMessages, sources, tools, onDelta)
 	if err != nil {
+		if stream != nil {
+			stream.abort()
+		}
 		fmt.Println("Error generating AI response:", err.Error())
 		return nil, fmt.Errorf("failed to generate AI response: %w", err)
 	}
+	response := completion.Content
 
 	fmt.Println("Response from ai -", response)
 
-	if usageMetrics != nil && s.usageService != nil {
+	if completion.Usage != nil && s.usageService != nil {
 		if _, err := s.usageService.DeductUsage(ctx, UsageDeductionInput{
 			TenantID:  session.TenantID,
 			ProjectID: session.ProjectID,
-			Model:     s.config.Model,
+			Provider:  string(completion.Provider),
+			Model:     completion.Model,
 			SessionID: &session.ID,
-			Metrics:   *usageMetrics,
+			Metrics:   *completion.Usage,
 		}); err != nil {
 			fmt.Printf("Failed to deduct AI usage credits: %v\n", err)
 		}
 	}
 
-	// Send the AI response
-	return s.SendAIResponse(ctx, session, connID, response, map[string]interface{}{
+	// Tool calls are recorded on the message that follows them, the reply or the handoff notice
+	var toolMetadata map[string]interface{}
+	if tools != nil && len(tools.invocations) > 0 {
+		toolMetadata = map[string]interface{}{"tool_invocations": tools.invocations}
+	}
+
+	// The assistant asked for a human; the handoff notice replaces its reply
+	if tools != nil && tools.handedOff() {
+		if stream != nil {
+			stream.abort()
+		}
+		s.requestHumanAgentWithMetadata(ctx, session, tools.handoffReason, connID, toolMetadata)
+		return nil, nil
+	}
+
+	// Replies that break the project's rules are not sent; a human takes over instead
+	if phrase := forbiddenPhraseIn(profile, response); phrase != "" {
+		fmt.Printf("AI reply for session %s used forbidden phrase %q, handing off\n", session.ID, phrase)
+		s.recordBlockedOutput(ctx, phrase, models.AIGuardrailActionBlocked)
+		s.requestHumanAgentWithMetadata(ctx, session, "AI reply used a forbidden phrase", connID, toolMetadata)
+		return nil, nil
+	}
+
+	metadata := map[string]interface{}{
 		"ai_generated":  true,
 		"response_type": "knowledge_based",
-	})
+	}
+	for key, value := range toolMetadata {
+		metadata[key] = value
+	}
+	// Answers that needed tools depend on the visitor and are not reused
+	if cacheQuery != nil && toolMetadata == nil {
+		if entry, err := s.answerCache.Store(ctx, cacheQuery, response, sources, completion.Usage); err != nil {
+			fmt.Printf("Failed to cache AI answer for session %s: %v\n", session.ID, err)
+		} else if entry != nil {
+			metadata[aiAnswerCacheMetadataEntry] = entry.ID.String()
+		}
+	}
+	if stream != nil {
+		// The stored message replaces the streamed pieces on the visitor's and agents' screens
+		stream.flush()
+		metadata["stream_id"] = stream.id.String()
+	}
+
+	// Send the AI response
+	return s.SendAIResponseAs(ctx, session, connID, profile.AuthorName(), response, metadata)
 }
 
 // SendAIResponse is a helper method to send AI responses
 func (s *AIService) SendAIResponse(ctx context.Context, session *models.ChatSession, connID, content string, metadata map[string]interface{}) (*models.ChatMessage, error) {
+	return s.SendAIResponseAs(ctx, session, connID, models.DefaultAIPersonaName, content, metadata)
+}
+
+// SendAIResponseAs sends an AI response under the persona name of the project's AI profile
+func (s *AIService) SendAIResponseAs(ctx context.Context, session *models.ChatSession, connID, authorName, content string, metadata map[string]interface{}) (*models.ChatMessage, error) {
 	// Send AI response
 	aiMessageReq := &models.SendChatMessageRequest{
 		Content:     content,
@@ -346,7 +513,7 @@ func (s *AIService) SendAIResponse(ctx context.Context, session *models.ChatSess
 		aiMessageReq,
 		"ai-agent",
 		nil,
-		"AI Assistant",
+		authorName,
 		connID,
 	)
 
@@ -360,8 +527,25 @@ func (s *AIService) generateAIAgentID(sessionID uuid.UUID) uuid.UUID {
 	return uuid.NewSHA1(namespace, sessionID[:])
 }
 
+// ResolveProfile returns the AI configuration in effect for a chat session, the global config when the
+// project has no AI profile or it cannot be loaded. The project's output blocklist is added to its
+// forbidden phrases.
+func (s *AIService) ResolveProfile(ctx context.Context, session *models.ChatSession) *models.ResolvedAIProfile {
+	if s.profileService == nil {
+		return s.withOutputBlocklist(ctx, session.TenantID, session.ProjectID, globalAIProfile(s.config))
+	}
+
+	widgetID := session.WidgetID
+	profile, err := s.profileService.ResolveProfile(ctx, session.TenantID, session.ProjectID, &widgetID)
+	if err != nil {
+		fmt.Printf("Failed to resolve AI profile for session %s, using global config: %v\n", session.ID, err)
+		profile = globalAIProfile(s.config)
+	}
+	return s.withOutputBlocklist(ctx, session.TenantID, session.ProjectID, profile)
+}
+
 // shouldHandoffToAgent checks if the message contains handoff keywords
-func (s *AIService) shouldHandoffToAgent(session *models.ChatSession, content string) bool {
+func (s *AIService) shouldHandoffToAgent(session *models.ChatSession, profile *models.ResolvedAIProfile, content string) bool {
 
 	// Check if session has been ongoing for too long (auto handoff)
 	if s.config.AutoHandoffTime > 0 {
@@ -370,36 +554,52 @@ func (s *AIService) shouldHandoffToAgent(session *models.ChatSession, content st
 			return true
 		}
 	}
-	content = strings.ToLower(content)
-
-	defaultKeywords := []string{
-		"speak to human", "human agent", "real person", "live agent",
-		"escalate", "supervisor", "manager", "human help",
-		"not helpful", "doesn't work", "frustrated", "angry",
-	}
-
-	keywords := s.config.HandoffKeywords
-	if len(keywords) == 0 {
-		keywords = defaultKeywords
-	}
+	return containsHandoffKeyword(profile, content)
+}
 
-	for _, keyword := range keywords {
+// containsHandoffKeyword reports whether a message asks for a human in the words of the profile
+func containsHandoffKeyword(profile *models.ResolvedAIProfile, content string) bool {
+	content = strings.ToLower(content)
+	for _, keyword := range profile.HandoffKeywords {
 		if strings.Contains(content, strings.ToLower(keyword)) {
 			return true
 		}
 	}
-
 	return false
 }
 
+// forbiddenPhraseIn returns the first phrase of the profile's forbidden list a reply uses, empty if none
+func forbiddenPhraseIn(profile *models.ResolvedAIProfile, reply string) string {
+	reply = strings.ToLower(reply)
+	for _, phrase := range profile.ForbiddenPhrases {
+		if strings.Contains(reply, strings.ToLower(phrase)) {
+			return phrase
+		}
+	}
+	return ""
+}
+
 // requestHumanAgent triggers handoff to human agent
 func (s *AIService) requestHumanAgent(ctx context.Context, session *models.ChatSession, reason, connID string) error {
-	// Send notification message about handoff
-	messageContent := "I'll connect you with a human agent who can better assist you. Please wait a moment."
+	return s.requestHumanAgentWithMetadata(ctx, session, reason, connID, nil)
+}
+
+// requestHumanAgentWithMetadata triggers handoff to human agent, adding metadata to the handoff notice
+func (s *AIService) requestHumanAgentWithMetadata(ctx context.Context, session *models.ChatSession, reason, connID string, metadata map[string]interface{}) error {
+	// Tell the visitor how long they will wait, or that nobody is online
+	var availability *ChatAvailability
+	if s.presenceService != nil {
+		var err error
+		availability, err = s.presenceService.ProjectAvailability(ctx, session.TenantID, session.ProjectID, time.Now())
+		if err != nil {
+			fmt.Printf("Failed to check agent availability for session %s: %v\n", session.ID, err)
+		}
+	}
+	messageContent := handoffNotice(availability)
 	if s.config.AutoHandoffTime > 0 {
 		if time.Since(session.CreatedAt) > s.config.AutoHandoffTime {
 			fmt.Println("Session exceeded auto handoff time:", session.ID)
-			messageContent = "It seems we've been chatting for a while. I'll connect you with a human agent who can better assist you. Please wait a moment."
+			messageContent = "It seems we've been chatting for a while. " + messageContent
 		}
 	}
 
@@ -412,6 +612,9 @@ func (s *AIService) requestHumanAgent(ctx context.Context, session *models.ChatS
 			"handoff_reason": reason,
 		},
 	}
+	for key, value := range metadata {
+		handoffMessage.Metadata[key] = value
+	}
 
 	aiAgentID := s.generateAIAgentID(session.ID)
 
@@ -425,6 +628,9 @@ func (s *AIService) requestHumanAgent(ctx context.Context, session *models.ChatS
 		connID,
 	)
 
+	// Brief the agent who picks the chat up on what has been said so far
+	go s.postHandoffSummary(context.Background(), session, reason)
+
 	// Send real-time handoff notification to all agents in the project
 	fmt.Printf("🤝 Sending handoff notification for session %s to project %s\n", session.ID, session.ProjectID)
 
@@ -433,7 +639,7 @@ func (s *AIService) requestHumanAgent(ctx context.Context, session *models.ChatS
 		fmt.Printf("🚨 Triggering handoff alarm for session %s\n", session.ID)
 
 		// Create metadata for the handoff alarm
-		metadata := models.JSONMap{
+		alarmMetadata := models.JSONMap{
 			"handoff_reason":     reason,
 			"session_id":         session.ID,
 			"customer_id":        session.CustomerID,
@@ -450,7 +656,7 @@ func (s *AIService) requestHumanAgent(ctx context.Context, session *models.ChatS
 			"Human Agent Requested",
 			fmt.Sprintf("Customer in session %s is requesting human assistance: %s", session.ID, reason),
 			models.NotificationPriorityHigh, // priority
-			metadata,
+			alarmMetadata,
 		)
 
 		if alarmErr != nil {
@@ -465,267 +671,205 @@ func (s *AIService) requestHumanAgent(ctx context.Context, session *models.ChatS
 	return nil
 }
 
-// generateResponseWithContext generates AI response with knowledge context
-func (s *AIService) generateResponseWithContext(ctx context.Context, session *models.ChatSession, messages []models.ChatMessage, userMessage string) (string, *TokenUsageMetrics, error) {
-	// Get relevant knowledge context if knowledge service is available
-	var knowledgeContext string
-	if s.knowledgeService != nil {
-		contextResults, err := s.knowledgeService.GetRelevantContext(ctx, session.TenantID, session.ProjectID, userMessage)
-		if err != nil {
-			// Log error but don't fail - continue without knowledge context
-			fmt.Printf("Error getting knowledge context: %v\n", err)
-		} else if len(contextResults) > 0 {
-			knowledgeContext = s.knowledgeService.FormatContextForAI(contextResults)
-		}
-	}
-
-	// Build conversation context with knowledge
-	chatMessages := []ChatCompletionMessage{}
-
-	// Enhanced system prompt with knowledge context
-	systemPrompt := s.config.SystemPrompt
-	if knowledgeContext != "" {
-		systemPrompt = fmt.Sprintf("%s\n\n%s", systemPrompt, knowledgeContext)
-	}
-
-	chatMessages = append(chatMessages, ChatCompletionMessage{
-		Role:    "system",
-		Content: systemPrompt,
-	})
-
-	// Add recent conversation history
-	for _, msg := range messages {
-		role := "user"
-		if msg.AuthorType == "ai-agent" || msg.AuthorType == "agent" {
-			role = "assistant"
-		}
-
-		chatMessages = append(chatMessages, ChatCompletionMessage{
-			Role:    role,
-			Content: msg.Content,
-		})
-	}
+// generateResponseWithContext generates AI response with the given knowledge context, in the persona and
+// with the model settings of the project's AI profile. The model may call the given tools; the reply is
+// streamed to onDelta when it is set.
+func (s *AIService) generateResponseWithContext(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, messages []models.ChatMessage, sources []models.KnowledgeSearchResult, tools *aiToolset, onDelta func(string)) (*LLMResponse, error) {
+	// Build conversation context with knowledge, within the model's context budget
+	chatMessages := s.budgetedConversation(ctx, session, profile, sources, messages, tools)
 
-	// Create request
-	req := ChatCompletionRequest{
-		Model:       s.config.Model,
-		Messages:    chatMessages,
-		MaxTokens:   s.config.MaxTokens,
-		Temperature: s.config.Temperature,
-	}
-
-	// Make API call based on provider
-	switch AIProvider(s.config.Provider) {
-	case ProviderOpenAI:
-		return s.callOpenAI(ctx, req)
-	case ProviderAnthropic:
-		return s.callAnthropic(ctx, req)
-	case ProviderAzure:
-		return s.callAzureOpenAI(ctx, req)
-	case ProviderBB:
-		return s.callBB(ctx, req)
-	default:
-		return "", nil, fmt.Errorf("unsupported AI provider: %s", s.config.Provider)
-	}
+	return s.completeWithProfile(ctx, profile, chatMessages, tools, onDelta)
 }
 
-func (s *AIService) generateResponseForAIRequest(ctx context.Context, req ChatCompletionRequest) (string, *TokenUsageMetrics, error) {
-	// Get relevant knowledge context if knowledge service is available
-	// Make API call based on provider
-	switch AIProvider(s.config.Provider) {
-	case ProviderOpenAI:
-		return s.callOpenAI(ctx, req)
-	case ProviderAnthropic:
-		return s.callAnthropic(ctx, req)
-	case ProviderAzure:
-		return s.callAzureOpenAI(ctx, req)
-	case ProviderBB:
-		return s.callBB(ctx, req)
-	default:
-		return "", nil, fmt.Errorf("unsupported AI provider: %s", s.config.Provider)
+// PreviewProfile answers a test question as the assistant of a project would with the given profile,
+// without a chat session. Tokens used are billed to the tenant like chat replies.
+func (s *AIService) PreviewProfile(ctx context.Context, tenantID, projectID uuid.UUID, profile *models.ResolvedAIProfile, message string) (*models.AIProfilePreviewResponse, error) {
+	if !s.IsEnabled() {
+		return nil, ErrAIDisabled
 	}
-}
 
-// callOpenAI makes API call to OpenAI
-func (s *AIService) callOpenAI(ctx context.Context, req ChatCompletionRequest) (string, *TokenUsageMetrics, error) {
-	url := "https://api.openai.com/v1/chat/completions"
-	if s.config.BaseURL != "" {
-		url = s.config.BaseURL + "/v1/chat/completions"
+	ctx = s.guardedContext(ctx, tenantID, projectID, nil, nil)
+	profile = s.withOutputBlocklist(ctx, tenantID, projectID, profile)
+	preview := &models.AIProfilePreviewResponse{
+		Profile: *profile,
+		Sources: []models.KnowledgeSearchResult{},
 	}
-
-	return s.makeAPICall(ctx, url, req, map[string]string{
-		"Authorization": "Bearer " + s.config.APIKey,
-		"Content-Type":  "application/json",
-	})
-}
-
-func (s *AIService) callBB(ctx context.Context, req ChatCompletionRequest) (string, *TokenUsageMetrics, error) {
-
-	logger.Info("Calling BB AI Provider")
-
-	url := ""
-	if s.config.BaseURL != "" {
-		url = s.config.BaseURL + "/v1/chat/completions"
+	if containsHandoffKeyword(profile, message) {
+		preview.Handoff = true
+		return preview, nil
 	}
-	return s.makeAPICall(ctx, url, req, map[string]string{
-		"api-key":      s.config.APIKey,
-		"Content-Type": "application/json",
-	})
-}
 
-// callAnthropic makes API call to Anthropic Claude
-func (s *AIService) callAnthropic(ctx context.Context, req ChatCompletionRequest) (string, *TokenUsageMetrics, error) {
-	// Convert to Anthropic format
-	anthropicReq := map[string]interface{}{
-		"model":      req.Model,
-		"max_tokens": req.MaxTokens,
-		"messages":   req.Messages[1:],        // Skip system message
-		"system":     req.Messages[0].Content, // System message separately
+	if sources := s.relevantKnowledge(ctx, tenantID, projectID, message); sources != nil {
+		preview.Sources = sources
 	}
-
-	url := "https://api.anthropic.com/v1/messages"
-	if s.config.BaseURL != "" {
-		url = s.config.BaseURL + "/v1/messages"
+	completion, err := s.completeWithProfile(ctx, profile, []ChatCompletionMessage{
+		{Role: "system", Content: s.buildSystemPrompt(profile, preview.Sources)},
+		{Role: "user", Content: message},
+	}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate AI response: %w", err)
 	}
 
-	headers := map[string]string{
-		"x-api-key":         s.config.APIKey,
-		"Content-Type":      "application/json",
-		"anthropic-version": "2023-06-01",
+	preview.Reply = completion.Content
+	preview.Blocked = forbiddenPhraseIn(profile, completion.Content) != ""
+	if completion.Usage != nil {
+		preview.PromptTokens = completion.Usage.PromptTokens
+		preview.CompletionTokens = completion.Usage.CompletionTokens
+		if s.usageService != nil {
+			if _, err := s.usageService.DeductUsage(ctx, UsageDeductionInput{
+				TenantID:  tenantID,
+				ProjectID: projectID,
+				Provider:  string(completion.Provider),
+				Model:     completion.Model,
+				Metrics:   *completion.Usage,
+			}); err != nil {
+				fmt.Printf("Failed to deduct AI usage credits: %v\n", err)
+			}
+		}
 	}
+	return preview, nil
+}
 
-	jsonData, err := json.Marshal(anthropicReq)
-	if err != nil {
-		return "", nil, err
+// relevantKnowledge looks up the knowledge of a project that helps answer a message. Lookup failures are
+// logged and answered without knowledge. The message is searched for without its personal data, and
+// chunks that try to instruct the assistant are left out.
+func (s *AIService) relevantKnowledge(ctx context.Context, tenantID, projectID uuid.UUID, message string) []models.KnowledgeSearchResult {
+	if s.knowledgeService == nil {
+		return nil
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	results, err := s.knowledgeService.GetRelevantContext(ctx, tenantID, projectID, s.redactForAI(ctx, message))
 	if err != nil {
-		return "", nil, err
+		// Log error but don't fail - continue without knowledge context
+		fmt.Printf("Error getting knowledge context: %v\n", err)
+		return nil
 	}
+	return s.screenKnowledge(ctx, results)
+}
 
-	for key, value := range headers {
-		httpReq.Header.Set(key, value)
+// relevantKnowledgeFor is relevantKnowledge for a message that was already embedded without its personal
+// data
+func (s *AIService) relevantKnowledgeFor(ctx context.Context, tenantID, projectID uuid.UUID, embedding pgvector.Vector) []models.KnowledgeSearchResult {
+	if s.knowledgeService == nil {
+		return nil
 	}
-
-	resp, err := s.httpClient.Do(httpReq)
+	results, err := s.knowledgeService.GetRelevantContextForEmbedding(ctx, tenantID, projectID, embedding)
 	if err != nil {
-		return "", nil, err
+		fmt.Printf("Error getting knowledge context: %v\n", err)
+		return nil
 	}
-	defer resp.Body.Close()
+	return s.screenKnowledge(ctx, results)
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", nil, err
+// buildSystemPrompt combines the profile's prompt, persona and topic rules with the knowledge context
+func (s *AIService) buildSystemPrompt(profile *models.ResolvedAIProfile, sources []models.KnowledgeSearchResult) string {
+	parts := []string{}
+	if profile.SystemPrompt != "" {
+		parts = append(parts, profile.SystemPrompt)
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", nil, fmt.Errorf("API call failed with status %d: %s", resp.StatusCode, string(body))
+	if profile.PersonaName != "" {
+		parts = append(parts, fmt.Sprintf("Your name is %s.", profile.PersonaName))
 	}
-
-	// Parse Anthropic response format
-	var anthropicResp struct {
-		Content []struct {
-			Text string `json:"text"`
-		} `json:"content"`
+	if len(profile.AllowedTopics) > 0 {
+		parts = append(parts, fmt.Sprintf("Only help with questions about: %s. For anything else, politely say you can only help with these topics and offer to connect the visitor with a human agent.",
+			strings.Join(profile.AllowedTopics, ", ")))
 	}
-
-	if err := json.Unmarshal(body, &anthropicResp); err != nil {
-		return "", nil, err
+	if len(profile.ForbiddenPhrases) > 0 {
+		quoted := make([]string, len(profile.ForbiddenPhrases))
+		for i, phrase := range profile.ForbiddenPhrases {
+			quoted[i] = fmt.Sprintf("%q", phrase)
+		}
+		parts = append(parts, fmt.Sprintf("Never use these words or phrases: %s.", strings.Join(quoted, ", ")))
 	}
-
-	if len(anthropicResp.Content) == 0 {
-		return "", nil, fmt.Errorf("no content in response")
+	if len(sources) > 0 && s.knowledgeService != nil {
+		parts = append(parts, s.knowledgeService.FormatContextForAI(sources))
 	}
-
-	return anthropicResp.Content[0].Text, nil, nil
+	return strings.Join(parts, "\n\n")
 }
 
-// callAzureOpenAI makes API call to Azure OpenAI
-func (s *AIService) callAzureOpenAI(ctx context.Context, req ChatCompletionRequest) (string, *TokenUsageMetrics, error) {
-	if s.config.BaseURL == "" {
-		return "", nil, fmt.Errorf("base URL required for Azure OpenAI")
+// completeWithProfile sends a conversation to the provider and model of the profile, streaming the reply
+// to onDelta when it is set. Tool calls the model asks for are run and their results sent back until it
+// answers, for at most maxAIToolRounds rounds; usage is the total of all rounds. The provider and model
+// returned are those that answered, which differ from the profile's after a failover, and are the ones
+// to bill. Personal data in the conversation and in tool results is masked before it is sent.
+func (s *AIService) completeWithProfile(ctx context.Context, profile *models.ResolvedAIProfile, messages []ChatCompletionMessage, tools *aiToolset, onDelta func(string)) (*LLMResponse, error) {
+	messages = append([]ChatCompletionMessage(nil), messages...)
+	s.redactCompletionMessages(ctx, messages)
+	guarded := len(messages)
+
+	call := LLMCall{
+		Provider:   AIProvider(profile.Provider),
+		Deployment: profile.Model,
+		Request: ChatCompletionRequest{
+			Model:       profile.Model,
+			Messages:    messages,
+			MaxTokens:   profile.MaxTokens,
+			Temperature: profile.Temperature,
+		},
 	}
-
-	url := s.config.BaseURL + "/openai/deployments/" + s.config.Model + "/chat/completions?api-version=2023-12-01-preview"
-
-	return s.makeAPICall(ctx, url, req, map[string]string{
-		"api-key":      s.config.APIKey,
-		"Content-Type": "application/json",
-	})
-}
-
-// makeAPICall is a helper function for making HTTP API calls
-func (s *AIService) makeAPICall(ctx context.Context, url string, req ChatCompletionRequest, headers map[string]string) (string, *TokenUsageMetrics, error) {
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return "", nil, err
+	if tools != nil {
+		call.Request.Tools = tools.definitions
 	}
 
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, 300*time.Second)
-	defer cancel()
-
-	httpReq, err := http.NewRequestWithContext(ctxWithTimeout, "POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", nil, err
-	}
+	completion := &LLMResponse{Usage: &TokenUsageMetrics{}}
+	for round := 0; ; round++ {
+		var resp *LLMResponse
+		var err error
+		if onDelta != nil {
+			resp, err = s.llm.Stream(ctx, call, onDelta)
+		} else {
+			resp, err = s.llm.Complete(ctx, call)
+		}
+		if err != nil {
+			return nil, err
+		}
+		completion.Provider, completion.Model = resp.Provider, resp.Model
+		if resp.Usage != nil {
+			completion.Usage.PromptTokens += resp.Usage.PromptTokens
+			completion.Usage.CompletionTokens += resp.Usage.CompletionTokens
+			completion.Usage.TotalTokens += resp.Usage.TotalTokens
+		}
 
-	for key, value := range headers {
-		httpReq.Header.Set(key, value)
-	}
+		if len(resp.ToolCalls) == 0 || tools == nil {
+			completion.Content = resp.Content
+			return completion, nil
+		}
+		if round >= maxAIToolRounds {
+			return nil, fmt.Errorf("AI still calling tools after %d rounds", maxAIToolRounds)
+		}
 
-	resp, err := s.httpClient.Do(httpReq)
-	if err != nil {
-		return "", nil, err
+		call.Request.Messages = append(call.Request.Messages, ChatCompletionMessage{
+			Role:      "assistant",
+			Content:   resp.Content,
+			ToolCalls: resp.ToolCalls,
+		})
+		for _, toolCall := range resp.ToolCalls {
+			call.Request.Messages = append(call.Request.Messages, ChatCompletionMessage{
+				Role:       "tool",
+				Content:    tools.call(ctx, toolCall),
+				ToolCallID: toolCall.ID,
+			})
+		}
+		if tools.handedOff() {
+			return completion, nil
+		}
+		s.redactCompletionMessages(ctx, call.Request.Messages[guarded:])
+		guarded = len(call.Request.Messages)
 	}
-	defer resp.Body.Close()
+}
 
-	body, err := io.ReadAll(resp.Body)
+// generateResponseForAIRequest runs a request against the configured provider. Azure OpenAI addresses
+// models by deployment name, which is the configured model.
+func (s *AIService) generateResponseForAIRequest(ctx context.Context, req ChatCompletionRequest) (string, *TokenUsageMetrics, error) {
+	resp, err := s.llm.Complete(ctx, LLMCall{
+		Provider:   AIProvider(s.config.Provider),
+		Deployment: s.config.Model,
+		Request:    req,
+	})
 	if err != nil {
 		return "", nil, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", nil, fmt.Errorf("API call failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var chatResp ChatCompletionResponse
-	if err := json.Unmarshal(body, &chatResp); err != nil {
-		return "", nil, err
-	}
-
-	if len(chatResp.Choices) == 0 {
-		return "", nil, fmt.Errorf("no choices in response")
-	}
-
-	var usageMetrics *TokenUsageMetrics
-	if chatResp.Usage != nil {
-		usageMetrics = &TokenUsageMetrics{
-			PromptTokens:     int64(chatResp.Usage.PromptTokens),
-			CompletionTokens: int64(chatResp.Usage.CompletionTokens),
-			TotalTokens:      int64(chatResp.Usage.TotalTokens),
-		}
-	}
-
-	// Extract content from the message (handle both string and multi-modal content)
-	content := ""
-	switch v := chatResp.Choices[0].Message.Content.(type) {
-	case string:
-		content = v
-	case []interface{}:
-		// For multi-modal responses, extract text parts
-		for _, part := range v {
-			if partMap, ok := part.(map[string]interface{}); ok {
-				if partMap["type"] == "text" {
-					if text, ok := partMap["text"].(string); ok {
-						content += text
-					}
-				}
-			}
-		}
-	}
-
-	return content, usageMetrics, nil
+	return resp.Content, resp.Usage, nil
 }
 
 // AcceptHandoff handles agent accepting a handoff request
@@ -1358,31 +1502,60 @@ type AIResponseHandler interface {
 	OnError(ctx context.Context, err error)
 }
 
-// FetchConversationHistory fetches and converts conversation history to AI agent format
+// FetchConversationHistory fetches and converts conversation history to AI agent format. The history is
+// cut to the model's context budget, leaving the agent room for its own prompt and knowledge; older
+// messages are replaced by the session's rolling summary.
 func (ai *AIService) FetchConversationHistory(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) ([]ChatMessage, error) {
+	ctx = ai.guardedContext(ctx, tenantID, projectID, &sessionID, nil)
 	messages, err := ai.chatSessionService.GetChatMessages(ctx, tenantID, projectID, sessionID, false)
 	if err != nil {
 		logger.GetTxLogger(ctx).Error().Err(err).Msg("Failed to fetch conversation history")
 		return []ChatMessage{}, nil // Return empty history rather than failing
 	}
 
-	messageHistory := make([]ChatMessage, 0, len(messages))
+	session, err := ai.chatSessionService.GetChatSession(ctx, tenantID, projectID, sessionID)
+	if err != nil || session == nil {
+		session = &models.ChatSession{ID: sessionID, TenantID: tenantID, ProjectID: projectID}
+	}
+	history := make([]models.ChatMessage, 0, len(messages))
 	for _, msg := range messages {
-		role := "user"
-		if msg.AuthorType == "agent" || msg.AuthorType == "ai" {
-			role = "assistant"
+		if msg != nil {
+			history = append(history, *msg)
 		}
-		messageHistory = append(messageHistory, ChatMessage{
-			Role:    role,
-			Content: msg.Content,
-		})
+	}
+
+	return ai.agentConversationHistory(ctx, session, ai.ResolveProfile(ctx, session), history), nil
+}
+
+// agentConversationHistory fits chat history into the share of the context budget the AI agent service
+// leaves for it, with the rolling summary of older messages first. Personal data in it is masked.
+func (ai *AIService) agentConversationHistory(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, messages []models.ChatMessage) []ChatMessage {
+	budget := ai.promptBudget(profile)
+	budget -= ai.countTokens(profile.Model, ai.buildSystemPrompt(profile, nil)) + aiMessageTokenOverhead
+	budget = int(float64(max(budget, 0)) * (1 - aiKnowledgeBudgetShare))
+
+	summary, history := ai.fitConversation(ctx, session, profile, messages, budget)
+
+	conversation := make([]ChatCompletionMessage, 0, len(history)+1)
+	if summary != "" {
+		conversation = append(conversation, ChatCompletionMessage{Role: "system", Content: aiContextSummaryHeading + summary})
+	}
+	for i := range history {
+		conversation = append(conversation, ChatCompletionMessage{Role: chatCompletionRole(&history[i]), Content: history[i].Content})
+	}
+	ai.redactCompletionMessages(ctx, conversation)
+
+	messageHistory := make([]ChatMessage, 0, len(conversation))
+	for _, msg := range conversation {
+		content, _ := msg.Content.(string)
+		messageHistory = append(messageHistory, ChatMessage{Role: msg.Role, Content: content})
 	}
 
 	logger.GetTxLogger(ctx).Info().
 		Int("message_count", len(messageHistory)).
 		Msg("Fetched conversation history for AI agent")
 
-	return messageHistory, nil
+	return messageHistory
 }
 
 // ProcessAIStreamingResponse handles streaming AI responses with custom handler for different channels
@@ -1394,6 +1567,11 @@ func (ai *AIService) ProcessAIStreamingResponse(
 	handler AIResponseHandler,
 	aiAgentClient *AiAgentClient,
 ) {
+	if !ai.CheckCreditsOrHandoff(ctx, session, "") {
+		return
+	}
+	ctx = ai.guardedContext(ctx, session.TenantID, session.ProjectID, &session.ID, nil)
+
 	// Fetch conversation history
 	messageHistory, err := ai.FetchConversationHistory(ctx, session.TenantID, session.ProjectID, session.ID)
 	if err != nil {
@@ -1403,7 +1581,7 @@ func (ai *AIService) ProcessAIStreamingResponse(
 
 	// Create agent request with conversation history
 	request := ChatRequest{
-		Message:        content,
+		Message:        ai.redactForAI(ctx, content),
 		TenantID:       session.TenantID.String(),
 		ProjectID:      session.ProjectID.String(),
 		SessionID:      session.ID.String(),
@@ -1463,3 +1641,115 @@ func (ai *AIService) ProcessAIStreamingResponse(
 		}
 	}
 }
+
+// aiAgentStreamer streams the replies of the Python agent service; implemented by AiAgentClient
+type aiAgentStreamer interface {
+	ProcessMessageStream(ctx context.Context, req ChatRequest) (<-chan AgentResponse, <-chan error)
+}
+
+// RespondWithAgent answers a visitor message in the live widget with the AI agent service, under the
+// project's AI profile and guardrails. The chat goes to a human instead when the message or a reply
+// fails a check.
+func (s *AIService) RespondWithAgent(ctx context.Context, session *models.ChatSession, content, connID string, agent aiAgentStreamer) {
+	ctx = s.guardedContext(ctx, session.TenantID, session.ProjectID, &session.ID, nil)
+	profile := s.ResolveProfile(ctx, session)
+
+	typing := func(isTyping bool) {
+		s.ProcessAiTyping(session, models.WSMessage{}, connID, isTyping)
+	}
+	reply := func(content string) {
+		if _, err := s.SendAIResponseAs(ctx, session, connID, profile.AuthorName(), content, map[string]interface{}{
+			"ai_generated":  true,
+			"response_type": "knowledge_based",
+		}); err != nil {
+			fmt.Printf("Failed to send AI agent reply for session %s: %v\n", session.ID, err)
+		}
+	}
+
+	if reason := s.streamAgentReply(ctx, session, profile, content, connID, agent, typing, reply); reason != "" {
+		s.requestHumanAgent(ctx, session, reason, connID)
+	}
+}
+
+// streamAgentReply passes the agent service's replies to a visitor message on to reply, one by one, and
+// returns why the chat must go to a human instead, empty when it need not. Replies are checked before
+// they are passed on, so a visitor never sees one that breaks the project's rules.
+func (s *AIService) streamAgentReply(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, content, connID string, agent aiAgentStreamer, typing func(bool), reply func(string)) string {
+	// Visitors who ask for a human in the words of the profile get one
+	if s.shouldHandoffToAgent(session, profile, content) {
+		return "Customer requested human assistance"
+	}
+
+	// Messages that try to override the assistant's instructions are recorded, and go to a human when the
+	// project blocks them
+	if s.screenVisitorMessage(ctx, content) {
+		return "Message looked like a prompt injection"
+	}
+
+	userID := ""
+	if session.CustomerEmail != nil {
+		userID = *session.CustomerEmail
+	}
+	request := ChatRequest{
+		Message:   s.redactForAI(ctx, content),
+		TenantID:  session.TenantID.String(),
+		ProjectID: session.ProjectID.String(),
+		SessionID: session.ID.String(),
+		UserID:    userID,
+		Metadata: map[string]string{
+			"connection_id": connID,
+			"widget_id":     session.WidgetID.String(),
+		},
+		Profile: profile,
+	}
+
+	// Stop the agent's stream once a reply is blocked
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer typing(false)
+
+	responseChan, errorChan := agent.ProcessMessageStream(ctx, request)
+	for {
+		select {
+		case response, ok := <-responseChan:
+			if !ok {
+				return ""
+			}
+
+			switch response.Type {
+			case "message":
+				if response.Content == "" {
+					continue
+				}
+				// Replies that break the project's rules are not sent; a human takes over instead
+				if phrase := forbiddenPhraseIn(profile, response.Content); phrase != "" {
+					fmt.Printf("AI agent reply for session %s used forbidden phrase %q, handing off\n", session.ID, phrase)
+					s.recordBlockedOutput(ctx, phrase, models.AIGuardrailActionBlocked)
+					return "AI reply used a forbidden phrase"
+				}
+				reply(response.Content)
+				typing(false)
+			case "thinking":
+				typing(true)
+			case "done", "metadata":
+				typing(false)
+			case "error":
+				fmt.Printf("AI agent processing error for session %s: %s\n", session.ID, response.Content)
+				return ""
+			}
+
+		case err, ok := <-errorChan:
+			if !ok {
+				// The stream has ended; replies may still be buffered
+				errorChan = nil
+				continue
+			}
+			fmt.Printf("AI agent client error for session %s: %v\n", session.ID, err)
+			return ""
+
+		case <-ctx.Done():
+			fmt.Printf("Context cancelled for AI agent processing in session %s\n", session.ID)
+			return ""
+		}
+	}
+}
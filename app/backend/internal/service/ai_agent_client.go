@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/bareuptime/tms/internal/models"
 )
 
 // AiAgentClient handles communication with the Python agent service
@@ -21,14 +23,15 @@ type AiAgentClient struct {
 
 // ChatRequest represents a request to the Python agent service
 type ChatRequest struct {
-	Message        string            `json:"message"`
-	TenantID       string            `json:"tenant_id"`
-	ProjectID      string            `json:"project_id"`
-	SessionID      string            `json:"session_id"`
-	UserID         string            `json:"user_id,omitempty"`
-	Metadata       map[string]string `json:"metadata,omitempty"`
-	MessageHistory []ChatMessage     `json:"message_history,omitempty"` // Conversation history
-	UseHistory     bool              `json:"use_history,omitempty"`     // If true, use provided history instead of agent's memory
+	Message        string                    `json:"message"`
+	TenantID       string                    `json:"tenant_id"`
+	ProjectID      string                    `json:"project_id"`
+	SessionID      string                    `json:"session_id"`
+	UserID         string                    `json:"user_id,omitempty"`
+	Metadata       map[string]string         `json:"metadata,omitempty"`
+	MessageHistory []ChatMessage             `json:"message_history,omitempty"` // Conversation history
+	UseHistory     bool                      `json:"use_history,omitempty"`     // If true, use provided history instead of agent's memory
+	Profile        *models.ResolvedAIProfile `json:"ai_profile,omitempty"`      // Persona, model and guardrails of the project's AI profile
 }
 
 // ChatMessage represents a single message in the conversation history
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+const (
+	aiAnswerCacheSettingsKey        = "ai_answer_cache_settings"
+	minAIAnswerCacheSimilarity      = 0.8
+	maxAIAnswerCacheTTLHours        = 30 * 24
+	defaultAIAnswerCacheDays        = 30
+	maxAIAnswerCacheDays            = 90
+	aiAnswerCacheThumbsDown         = "👎"
+	aiAnswerCacheGreetingType       = "greeting"
+	aiAnswerCacheResponseType       = "cached"
+	aiAnswerCacheMetadataEntry      = "answer_cache_id"
+	aiAnswerCacheMetadataSimilarity = "answer_cache_similarity"
+)
+
+var ErrInvalidAIAnswerCacheSettings = errors.New("invalid answer cache settings")
+
+type aiAnswerCacheStore interface {
+	Nearest(ctx context.Context, tenantID, projectID uuid.UUID, profileHash string, embedding pgvector.Vector) (*models.AIAnswerCacheEntry, error)
+	SourceHashes(ctx context.Context, sourceIDs []uuid.UUID) (map[uuid.UUID]string, error)
+	Create(ctx context.Context, entry *models.AIAnswerCacheEntry) error
+	RecordHit(ctx context.Context, entryID uuid.UUID) error
+	Delete(ctx context.Context, entryID uuid.UUID) error
+	DeleteProject(ctx context.Context, tenantID, projectID uuid.UUID) (int64, error)
+	RejectLatestReply(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) error
+	RecordLookup(ctx context.Context, tenantID, projectID uuid.UUID, hit bool, tokensSaved int) error
+	Stats(ctx context.Context, tenantID, projectID uuid.UUID, days int) (*models.AIAnswerCacheStats, error)
+}
+
+type aiAnswerCacheEmbedder interface {
+	QueryEmbedding(ctx context.Context, query string) (pgvector.Vector, error)
+}
+
+// KnowledgeChangeListener is told when the knowledge of a project was indexed again
+type KnowledgeChangeListener interface {
+	KnowledgeChanged(ctx context.Context, tenantID, projectID uuid.UUID)
+}
+
+// aiAnswerCacheQuery is a question looked up in the answer cache, kept to store its answer after a miss
+// and to search knowledge without embedding the question again
+type aiAnswerCacheQuery struct {
+	tenantID    uuid.UUID
+	projectID   uuid.UUID
+	profileHash string
+	question    string
+	embedding   pgvector.Vector
+	settings    *models.AIAnswerCacheSettings
+}
+
+// AIAnswerCacheService reuses AI answers for visitor questions that mean the same as earlier ones. An
+// answer is only reused for the same AI profile configuration, until it expires, a visitor gives it a
+// thumbs down or the knowledge it was based on changes.
+type AIAnswerCacheService struct {
+	store    aiAnswerCacheStore
+	embedder aiAnswerCacheEmbedder
+	settings projectSettingsStore
+}
+
+// NewAIAnswerCacheService creates a new AI answer cache service
+func NewAIAnswerCacheService(store aiAnswerCacheStore, embedder aiAnswerCacheEmbedder, settings projectSettingsStore) *AIAnswerCacheService {
+	return &AIAnswerCacheService{store: store, embedder: embedder, settings: settings}
+}
+
+// Settings returns the answer cache settings of a project, the defaults for what it has not set
+func (s *AIAnswerCacheService) Settings(ctx context.Context, tenantID, projectID uuid.UUID) *models.AIAnswerCacheSettings {
+	settings := models.DefaultAIAnswerCacheSettings()
+	if s.settings == nil {
+		return settings
+	}
+	stored, _, err := s.settings.GetSetting(ctx, tenantID, projectID, aiAnswerCacheSettingsKey)
+	if err != nil {
+		return settings
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return settings
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		fmt.Printf("Invalid answer cache settings for project %s, using defaults: %v\n", projectID, err)
+		return models.DefaultAIAnswerCacheSettings()
+	}
+	return settings
+}
+
+// UpdateSettings replaces the answer cache settings of a project
+func (s *AIAnswerCacheService) UpdateSettings(ctx context.Context, tenantID, projectID uuid.UUID, settings *models.AIAnswerCacheSettings) (*models.AIAnswerCacheSettings, error) {
+	if settings.SimilarityThreshold < minAIAnswerCacheSimilarity || settings.SimilarityThreshold > 1 {
+		return nil, fmt.Errorf("%w: similarity_threshold must be between %.1f and 1", ErrInvalidAIAnswerCacheSettings, minAIAnswerCacheSimilarity)
+	}
+	if settings.TTLHours < 1 || settings.TTLHours > maxAIAnswerCacheTTLHours {
+		return nil, fmt.Errorf("%w: ttl_hours must be between 1 and %d", ErrInvalidAIAnswerCacheSettings, maxAIAnswerCacheTTLHours)
+	}
+
+	updated := *settings
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if err := s.settings.UpdateSetting(ctx, tenantID, projectID, aiAnswerCacheSettingsKey, value); err != nil {
+		return nil, fmt.Errorf("failed to update answer cache settings: %w", err)
+	}
+	return &updated, nil
+}
+
+// Lookup returns the cached answer to a question that means the same, or nil on a miss, with the project's
+// settings. The question should be without personal data. Unless the project turned the cache off, the
+// embedded question is returned too, to store its answer with.
+func (s *AIAnswerCacheService) Lookup(ctx context.Context, tenantID, projectID uuid.UUID, settings *models.AIAnswerCacheSettings, profile *models.ResolvedAIProfile, question string) (*models.AIAnswerCacheEntry, *aiAnswerCacheQuery, error) {
+	if !settings.Enabled || strings.TrimSpace(question) == "" {
+		return nil, nil, nil
+	}
+
+	profileHash, err := aiAnswerCacheProfileHash(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	embedding, err := s.embedder.QueryEmbedding(ctx, question)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to embed question: %w", err)
+	}
+	query := &aiAnswerCacheQuery{
+		tenantID:    tenantID,
+		projectID:   projectID,
+		profileHash: profileHash,
+		question:    question,
+		embedding:   embedding,
+		settings:    settings,
+	}
+
+	entry, err := s.store.Nearest(ctx, tenantID, projectID, profileHash, embedding)
+	if err != nil {
+		return nil, query, fmt.Errorf("failed to look up cached answers: %w", err)
+	}
+	if entry != nil && entry.Similarity >= settings.SimilarityThreshold {
+		fresh, err := s.sourcesUnchanged(ctx, entry.Sources)
+		if err != nil {
+			return nil, query, err
+		}
+		if fresh {
+			if err := s.store.RecordHit(ctx, entry.ID); err != nil {
+				fmt.Printf("Failed to record answer cache hit %s: %v\n", entry.ID, err)
+			}
+			s.recordLookup(ctx, tenantID, projectID, true, entry.PromptTokens+entry.CompletionTokens)
+			return entry, query, nil
+		}
+		// The knowledge the answer was based on changed; it is not given again
+		if err := s.store.Delete(ctx, entry.ID); err != nil {
+			fmt.Printf("Failed to delete stale cached answer %s: %v\n", entry.ID, err)
+		}
+	}
+
+	s.recordLookup(ctx, tenantID, projectID, false, 0)
+	return nil, query, nil
+}
+
+// Store keeps the answer to a looked up question for reuse, with the knowledge sources it was based on.
+// Answers whose sources are gone already are not kept, and nil is returned.
+func (s *AIAnswerCacheService) Store(ctx context.Context, query *aiAnswerCacheQuery, answer string, sources []models.KnowledgeSearchResult, usage *TokenUsageMetrics) (*models.AIAnswerCacheEntry, error) {
+	if query == nil || strings.TrimSpace(answer) == "" {
+		return nil, nil
+	}
+
+	sourceIDs := make([]uuid.UUID, 0, len(sources))
+	for _, source := range sources {
+		sourceIDs = append(sourceIDs, source.ID)
+	}
+	hashes, err := s.store.SourceHashes(ctx, sourceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash answer sources: %w", err)
+	}
+
+	entry := &models.AIAnswerCacheEntry{
+		TenantID:    query.tenantID,
+		ProjectID:   query.projectID,
+		ProfileHash: query.profileHash,
+		Question:    query.question,
+		Embedding:   query.embedding,
+		Answer:      answer,
+		Sources:     models.AIAnswerCacheSources{},
+		ExpiresAt:   time.Now().Add(time.Duration(query.settings.TTLHours) * time.Hour),
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, source := range sources {
+		if seen[source.ID] {
+			continue
+		}
+		seen[source.ID] = true
+		hash, ok := hashes[source.ID]
+		if !ok {
+			return nil, nil
+		}
+		entry.Sources = append(entry.Sources, models.AIAnswerCacheSource{
+			ID:          source.ID,
+			Type:        source.Type,
+			Source:      source.Source,
+			ContentHash: hash,
+		})
+	}
+	if usage != nil {
+		entry.PromptTokens = int(usage.PromptTokens)
+		entry.CompletionTokens = int(usage.CompletionTokens)
+	}
+
+	if err := s.store.Create(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to store answer: %w", err)
+	}
+	return entry, nil
+}
+
+// RejectLatestReply stops reusing the answer of the latest AI reply in a chat session, when it came from
+// or went to the cache
+func (s *AIAnswerCacheService) RejectLatestReply(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) error {
+	if err := s.store.RejectLatestReply(ctx, tenantID, projectID, sessionID); err != nil {
+		return fmt.Errorf("failed to reject cached answer: %w", err)
+	}
+	return nil
+}
+
+// KnowledgeChanged drops the cached answers of a project once its knowledge was indexed again, as new
+// knowledge may answer its questions better
+func (s *AIAnswerCacheService) KnowledgeChanged(ctx context.Context, tenantID, projectID uuid.UUID) {
+	purged, err := s.store.DeleteProject(ctx, tenantID, projectID)
+	if err != nil {
+		fmt.Printf("Failed to invalidate answer cache of project %s: %v\n", projectID, err)
+		return
+	}
+	if purged > 0 {
+		fmt.Printf("Invalidated %d cached answers of project %s after knowledge changed\n", purged, projectID)
+	}
+}
+
+// Purge removes every cached answer of a project
+func (s *AIAnswerCacheService) Purge(ctx context.Context, tenantID, projectID uuid.UUID) (*models.AIAnswerCachePurgeResult, error) {
+	purged, err := s.store.DeleteProject(ctx, tenantID, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge answer cache: %w", err)
+	}
+	return &models.AIAnswerCachePurgeResult{Purged: purged}, nil
+}
+
+// Stats returns how the answer cache of a project did over its last days, 30 by default and at most 90
+func (s *AIAnswerCacheService) Stats(ctx context.Context, tenantID, projectID uuid.UUID, days int) (*models.AIAnswerCacheStats, error) {
+	if days <= 0 {
+		days = defaultAIAnswerCacheDays
+	}
+	if days > maxAIAnswerCacheDays {
+		days = maxAIAnswerCacheDays
+	}
+
+	stats, err := s.store.Stats(ctx, tenantID, projectID, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get answer cache stats: %w", err)
+	}
+	stats.Days = days
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		rate := float64(stats.Hits) / float64(lookups)
+		stats.HitRate = &rate
+	}
+	return stats, nil
+}
+
+// sourcesUnchanged reports whether the knowledge sources of a cached answer all still exist with the
+// content they had when it was given
+func (s *AIAnswerCacheService) sourcesUnchanged(ctx context.Context, sources models.AIAnswerCacheSources) (bool, error) {
+	if len(sources) == 0 {
+		return true, nil
+	}
+	sourceIDs := make([]uuid.UUID, len(sources))
+	for i, source := range sources {
+		sourceIDs[i] = source.ID
+	}
+	hashes, err := s.store.SourceHashes(ctx, sourceIDs)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash answer sources: %w", err)
+	}
+	for _, source := range sources {
+		if hash, ok := hashes[source.ID]; !ok || hash != source.ContentHash {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *AIAnswerCacheService) recordLookup(ctx context.Context, tenantID, projectID uuid.UUID, hit bool, tokensSaved int) {
+	if err := s.store.RecordLookup(ctx, tenantID, projectID, hit, tokensSaved); err != nil {
+		fmt.Printf("Failed to record answer cache lookup for project %s: %v\n", projectID, err)
+	}
+}
+
+// aiAnswerCacheProfileHash identifies an AI profile configuration, so answers are only reused for the
+// prompt, persona, rules and model they were given with
+func aiAnswerCacheProfileHash(profile *models.ResolvedAIProfile) (string, error) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// isStandaloneQuestion reports whether a visitor's latest message opens the conversation, so its answer
+// does not depend on what was said before. Greetings do not count as conversation.
+func isStandaloneQuestion(messages []*models.ChatMessage) bool {
+	for _, message := range messages {
+		if message == nil {
+			continue
+		}
+		switch message.AuthorType {
+		case "agent":
+			return false
+		case "ai-agent":
+			if responseType, _ := message.Metadata["response_type"].(string); responseType != aiAnswerCacheGreetingType {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetAnswerCache lets the AI service answer repeated questions from the cache
+func (s *AIService) SetAnswerCache(answerCache *AIAnswerCacheService) {
+	s.answerCache = answerCache
+}
+
+// lookupCachedAnswer looks a visitor's question up in the answer cache when it opens the conversation.
+// The cached answer is returned on a hit; on a miss the query is returned to store the answer with, nil
+// when the question is not cached. Lookup failures are logged and answered without the cache.
+func (s *AIService) lookupCachedAnswer(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, history []*models.ChatMessage, question string) (*models.AIAnswerCacheEntry, *aiAnswerCacheQuery) {
+	if s.answerCache == nil || !isStandaloneQuestion(history) {
+		return nil, nil
+	}
+	settings := s.answerCache.Settings(ctx, session.TenantID, session.ProjectID)
+	if !settings.Enabled {
+		return nil, nil
+	}
+	cached, query, err := s.answerCache.Lookup(ctx, session.TenantID, session.ProjectID, settings, profile, s.redactForAI(ctx, question))
+	if err != nil {
+		fmt.Printf("Failed to look up cached answer for session %s: %v\n", session.ID, err)
+		return nil, query
+	}
+	return cached, query
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeAnswerCacheStore struct {
+	entries     []*models.AIAnswerCacheEntry
+	hashes      map[uuid.UUID]string
+	hits        int64
+	misses      int64
+	tokensSaved int64
+	rejectNext  uuid.UUID
+}
+
+func newFakeAnswerCacheStore() *fakeAnswerCacheStore {
+	return &fakeAnswerCacheStore{hashes: map[uuid.UUID]string{}}
+}
+
+func (f *fakeAnswerCacheStore) Nearest(ctx context.Context, tenantID, projectID uuid.UUID, profileHash string, embedding pgvector.Vector) (*models.AIAnswerCacheEntry, error) {
+	var nearest *models.AIAnswerCacheEntry
+	for _, entry := range f.entries {
+		if entry.ProjectID != projectID || entry.ProfileHash != profileHash || entry.RejectedAt != nil || !entry.ExpiresAt.After(time.Now()) {
+			continue
+		}
+		similarity := models.CosineSimilarity(entry.Embedding, embedding)
+		if nearest == nil || similarity > nearest.Similarity {
+			found := *entry
+			found.Similarity = similarity
+			nearest = &found
+		}
+	}
+	return nearest, nil
+}
+
+func (f *fakeAnswerCacheStore) SourceHashes(ctx context.Context, sourceIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	hashes := map[uuid.UUID]string{}
+	for _, id := range sourceIDs {
+		if hash, ok := f.hashes[id]; ok {
+			hashes[id] = hash
+		}
+	}
+	return hashes, nil
+}
+
+func (f *fakeAnswerCacheStore) Create(ctx context.Context, entry *models.AIAnswerCacheEntry) error {
+	entry.ID = uuid.New()
+	entry.CreatedAt = time.Now()
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func (f *fakeAnswerCacheStore) RecordHit(ctx context.Context, entryID uuid.UUID) error {
+	for _, entry := range f.entries {
+		if entry.ID == entryID {
+			entry.HitCount++
+		}
+	}
+	return nil
+}
+
+func (f *fakeAnswerCacheStore) Delete(ctx context.Context, entryID uuid.UUID) error {
+	kept := f.entries[:0]
+	for _, entry := range f.entries {
+		if entry.ID != entryID {
+			kept = append(kept, entry)
+		}
+	}
+	f.entries = kept
+	return nil
+}
+
+func (f *fakeAnswerCacheStore) DeleteProject(ctx context.Context, tenantID, projectID uuid.UUID) (int64, error) {
+	purged := int64(0)
+	kept := f.entries[:0]
+	for _, entry := range f.entries {
+		if entry.ProjectID == projectID {
+			purged++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	f.entries = kept
+	return purged, nil
+}
+
+func (f *fakeAnswerCacheStore) RejectLatestReply(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) error {
+	now := time.Now()
+	for _, entry := range f.entries {
+		if entry.ID == f.rejectNext {
+			entry.RejectedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeAnswerCacheStore) RecordLookup(ctx context.Context, tenantID, projectID uuid.UUID, hit bool, tokensSaved int) error {
+	if hit {
+		f.hits++
+	} else {
+		f.misses++
+	}
+	f.tokensSaved += int64(tokensSaved)
+	return nil
+}
+
+func (f *fakeAnswerCacheStore) Stats(ctx context.Context, tenantID, projectID uuid.UUID, days int) (*models.AIAnswerCacheStats, error) {
+	return &models.AIAnswerCacheStats{Hits: f.hits, Misses: f.misses, TokensSaved: f.tokensSaved, Entries: int64(len(f.entries))}, nil
+}
+
+// fakeQuestionEmbedder embeds known questions as given, anything else as an unrelated direction
+type fakeQuestionEmbedder map[string][]float32
+
+func (f fakeQuestionEmbedder) QueryEmbedding(ctx context.Context, query string) (pgvector.Vector, error) {
+	if embedding, ok := f[query]; ok {
+		return pgvector.NewVector(embedding), nil
+	}
+	return pgvector.NewVector([]float32{0, 0, 1}), nil
+}
+
+var answerCacheQuestions = fakeQuestionEmbedder{
+	"How do I return an item?":     {1, 0, 0},
+	"how can I return an item":     {0.99, 0.05, 0},
+	"What are your opening hours?": {0, 1, 0},
+}
+
+func TestAnswerCacheReusesAnswersToSimilarQuestions(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	store := newFakeAnswerCacheStore()
+	svc := NewAIAnswerCacheService(store, answerCacheQuestions, nil)
+	settings := svc.Settings(ctx, tenantID, projectID)
+	profile := &models.ResolvedAIProfile{PersonaName: "Ava", Provider: "openai", Model: "gpt-4o-mini"}
+
+	pageID := uuid.New()
+	store.hashes[pageID] = "hash-1"
+	sources := []models.KnowledgeSearchResult{{ID: pageID, Type: "webpage", Source: "https://acme.example/returns"}}
+
+	cached, query, err := svc.Lookup(ctx, tenantID, projectID, settings, profile, "How do I return an item?")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+	require.NotNil(t, query)
+
+	entry, err := svc.Store(ctx, query, "Send it back within 30 days.", sources, &TokenUsageMetrics{PromptTokens: 900, CompletionTokens: 40})
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	require.Equal(t, "hash-1", entry.Sources[0].ContentHash)
+	require.WithinDuration(t, time.Now().Add(168*time.Hour), entry.ExpiresAt, time.Minute)
+
+	cached, _, err = svc.Lookup(ctx, tenantID, projectID, settings, profile, "how can I return an item")
+	require.NoError(t, err)
+	require.NotNil(t, cached)
+	require.Equal(t, entry.ID, cached.ID)
+	require.Equal(t, "Send it back within 30 days.", cached.Answer)
+
+	// Different questions and other profile configurations get their own answers
+	cached, _, err = svc.Lookup(ctx, tenantID, projectID, settings, profile, "What are your opening hours?")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+	cached, _, err = svc.Lookup(ctx, tenantID, projectID, settings, &models.ResolvedAIProfile{PersonaName: "Max", Provider: "openai", Model: "gpt-4o-mini"}, "How do I return an item?")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+
+	stats, err := svc.Stats(ctx, tenantID, projectID, 0)
+	require.NoError(t, err)
+	require.Equal(t, 30, stats.Days)
+	require.Equal(t, int64(1), stats.Hits)
+	require.Equal(t, int64(3), stats.Misses)
+	require.Equal(t, int64(940), stats.TokensSaved)
+	require.InDelta(t, 0.25, *stats.HitRate, 0.0001)
+}
+
+func TestAnswerCacheDropsAnswersWhoseSourcesChanged(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	store := newFakeAnswerCacheStore()
+	svc := NewAIAnswerCacheService(store, answerCacheQuestions, nil)
+	settings := svc.Settings(ctx, tenantID, projectID)
+	profile := &models.ResolvedAIProfile{Model: "gpt-4o-mini"}
+
+	chunkID := uuid.New()
+	store.hashes[chunkID] = "before"
+	_, query, err := svc.Lookup(ctx, tenantID, projectID, settings, profile, "How do I return an item?")
+	require.NoError(t, err)
+	_, err = svc.Store(ctx, query, "Within 30 days.", []models.KnowledgeSearchResult{{ID: chunkID, Type: "document"}}, nil)
+	require.NoError(t, err)
+
+	store.hashes[chunkID] = "after"
+	cached, _, err := svc.Lookup(ctx, tenantID, projectID, settings, profile, "How do I return an item?")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+	require.Empty(t, store.entries)
+
+	// Answers from sources that are gone already are not kept
+	_, query, err = svc.Lookup(ctx, tenantID, projectID, settings, profile, "How do I return an item?")
+	require.NoError(t, err)
+	entry, err := svc.Store(ctx, query, "Within 30 days.", []models.KnowledgeSearchResult{{ID: uuid.New(), Type: "document"}}, nil)
+	require.NoError(t, err)
+	require.Nil(t, entry)
+	require.Empty(t, store.entries)
+}
+
+func TestAnswerCacheRejectionInvalidationAndPurge(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	store := newFakeAnswerCacheStore()
+	svc := NewAIAnswerCacheService(store, answerCacheQuestions, nil)
+	settings := svc.Settings(ctx, tenantID, projectID)
+	profile := &models.ResolvedAIProfile{Model: "gpt-4o-mini"}
+
+	remember := func(question string) *models.AIAnswerCacheEntry {
+		_, query, err := svc.Lookup(ctx, tenantID, projectID, settings, profile, question)
+		require.NoError(t, err)
+		entry, err := svc.Store(ctx, query, "An answer.", nil, nil)
+		require.NoError(t, err)
+		return entry
+	}
+
+	// A thumbs down stops the answer from being reused
+	entry := remember("How do I return an item?")
+	store.rejectNext = entry.ID
+	require.NoError(t, svc.RejectLatestReply(ctx, tenantID, projectID, uuid.New()))
+	cached, _, err := svc.Lookup(ctx, tenantID, projectID, settings, profile, "How do I return an item?")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+
+	remember("What are your opening hours?")
+	svc.KnowledgeChanged(ctx, tenantID, projectID)
+	require.Empty(t, store.entries)
+
+	remember("What are your opening hours?")
+	result, err := svc.Purge(ctx, tenantID, projectID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), result.Purged)
+}
+
+func TestAnswerCacheSettings(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	stored := fakeSettingsStore{}
+	svc := NewAIAnswerCacheService(newFakeAnswerCacheStore(), answerCacheQuestions, stored)
+
+	settings := svc.Settings(ctx, tenantID, projectID)
+	require.True(t, settings.Enabled)
+	require.Equal(t, 0.95, settings.SimilarityThreshold)
+
+	_, err := svc.UpdateSettings(ctx, tenantID, projectID, &models.AIAnswerCacheSettings{Enabled: true, SimilarityThreshold: 0.5, TTLHours: 24})
+	require.ErrorIs(t, err, ErrInvalidAIAnswerCacheSettings)
+	_, err = svc.UpdateSettings(ctx, tenantID, projectID, &models.AIAnswerCacheSettings{Enabled: true, SimilarityThreshold: 0.9, TTLHours: 0})
+	require.ErrorIs(t, err, ErrInvalidAIAnswerCacheSettings)
+
+	_, err = svc.UpdateSettings(ctx, tenantID, projectID, &models.AIAnswerCacheSettings{Enabled: false, SimilarityThreshold: 0.9, TTLHours: 24})
+	require.NoError(t, err)
+	settings = svc.Settings(ctx, tenantID, projectID)
+	require.False(t, settings.Enabled)
+	require.Equal(t, 24, settings.TTLHours)
+
+	// A project that turned the cache off looks nothing up
+	cached, query, err := svc.Lookup(ctx, tenantID, projectID, settings, &models.ResolvedAIProfile{}, "How do I return an item?")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+	require.Nil(t, query)
+}
+
+func TestIsStandaloneQuestion(t *testing.T) {
+	visitor := &models.ChatMessage{AuthorType: "visitor"}
+	greeting := &models.ChatMessage{AuthorType: "ai-agent", Metadata: models.JSONMap{"response_type": "greeting"}}
+	reply := &models.ChatMessage{AuthorType: "ai-agent", Metadata: models.JSONMap{"response_type": "knowledge_based"}}
+	agent := &models.ChatMessage{AuthorType: "agent"}
+
+	require.True(t, isStandaloneQuestion([]*models.ChatMessage{visitor}))
+	require.True(t, isStandaloneQuestion([]*models.ChatMessage{visitor, greeting, visitor}))
+	require.False(t, isStandaloneQuestion([]*models.ChatMessage{visitor, reply, visitor}))
+	require.False(t, isStandaloneQuestion([]*models.ChatMessage{visitor, agent, visitor}))
+}
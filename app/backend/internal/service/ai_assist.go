@@ -0,0 +1,529 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+)
+
+var (
+	ErrAIAssistNotFound  = errors.New("conversation not found")
+	ErrAIAssistEmpty     = errors.New("conversation has no messages yet")
+	ErrAIAssistNoCredits = errors.New("no AI credits left")
+)
+
+const (
+	defaultAIReplySuggestions = 3
+	maxAIReplySuggestions     = 5
+	maxAIAssistTranscript     = 24000 // characters of the most recent messages sent to the model
+	maxAIAssistTicketMessages = 100
+	maxAISimilarTickets       = 3
+	maxAISimilarTicketTerms   = 12
+	maxAISimilarResolution    = 1000
+	aiHandoffSummaryAuthor    = "AI Assistant"
+)
+
+const aiReplySuggestionsPrompt = `You help a customer support agent answer a customer. Draft %d different replies the agent could send next, written as the agent, in the customer's language. Base them on the knowledge base and similar resolved tickets below; do not promise anything they do not support. Keep each reply short and ready to send.
+
+Respond with JSON only, in the form {"suggestions": ["first reply", "second reply"]}.`
+
+const aiSummaryPrompt = `Summarize this customer support conversation for the agent taking it over. In a few short bullet points, state what the customer needs, what has been tried or answered so far, and what is still open. Mention order numbers, account details and promises made. Do not add anything that is not in the conversation.`
+
+// aiAssistWordPattern matches the words of a conversation used to look up similar tickets
+var aiAssistWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+type aiAssistChats interface {
+	GetChatSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error)
+	GetChatMessages(ctx context.Context, tenantID, projectID, sessionID uuid.UUID, includePrivate bool) ([]*models.ChatMessage, error)
+}
+
+type aiAssistTickets interface {
+	GetByTenantAndProjectID(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*db.Ticket, error)
+	FindSimilarResolved(ctx context.Context, tenantID, projectID uuid.UUID, terms []string, excludeID *uuid.UUID, limit int) ([]*db.Ticket, error)
+}
+
+type aiAssistTicketMessages interface {
+	GetByTenantProjectAndTicketID(ctx context.Context, tenantID, projectID, ticketID uuid.UUID, includePrivate bool, pagination repo.PaginationParams) ([]*db.TicketMessage, string, error)
+}
+
+// AIAssistService helps human agents with chats and tickets: it drafts replies grounded in the knowledge
+// base and resolved tickets, and summarizes long conversations. Tokens are billed like AI chat replies.
+type AIAssistService struct {
+	ai             *AIService
+	chats          aiAssistChats
+	tickets        aiAssistTickets
+	ticketMessages aiAssistTicketMessages
+}
+
+// NewAIAssistService creates a new agent-assist service
+func NewAIAssistService(ai *AIService, chats aiAssistChats, tickets aiAssistTickets, ticketMessages aiAssistTicketMessages) *AIAssistService {
+	return &AIAssistService{
+		ai:             ai,
+		chats:          chats,
+		tickets:        tickets,
+		ticketMessages: ticketMessages,
+	}
+}
+
+// aiAssistConversation is a chat or ticket as the model is shown it
+type aiAssistConversation struct {
+	tenantID   uuid.UUID
+	projectID  uuid.UUID
+	sessionID  *uuid.UUID
+	ticketID   *uuid.UUID
+	widgetID   *uuid.UUID
+	subject    string
+	lines      []string
+	lastAsk    string // the latest message of the customer
+	customerIn []string
+}
+
+// SuggestChatReplies drafts replies an agent could send next in a chat session
+func (s *AIAssistService) SuggestChatReplies(ctx context.Context, tenantID, projectID, sessionID uuid.UUID, req *models.AIReplySuggestionsRequest) (*models.AIReplySuggestions, error) {
+	conversation, err := s.chatConversation(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.suggestReplies(ctx, conversation, req)
+}
+
+// SuggestTicketReplies drafts replies an agent could send next on a ticket
+func (s *AIAssistService) SuggestTicketReplies(ctx context.Context, tenantID, projectID, ticketID uuid.UUID, req *models.AIReplySuggestionsRequest) (*models.AIReplySuggestions, error) {
+	conversation, err := s.ticketConversation(ctx, tenantID, projectID, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	return s.suggestReplies(ctx, conversation, req)
+}
+
+// SummarizeChat summarizes the transcript of a chat session, private notes included
+func (s *AIAssistService) SummarizeChat(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.AIConversationSummary, error) {
+	conversation, err := s.chatConversation(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.summarize(ctx, conversation)
+}
+
+// SummarizeTicket summarizes the thread of a ticket, private notes included
+func (s *AIAssistService) SummarizeTicket(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*models.AIConversationSummary, error) {
+	conversation, err := s.ticketConversation(ctx, tenantID, projectID, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	return s.summarize(ctx, conversation)
+}
+
+func (s *AIAssistService) suggestReplies(ctx context.Context, conversation *aiAssistConversation, req *models.AIReplySuggestionsRequest) (*models.AIReplySuggestions, error) {
+	if err := s.checkAvailable(ctx, conversation.tenantID); err != nil {
+		return nil, err
+	}
+	ctx = s.ai.guardedContext(ctx, conversation.tenantID, conversation.projectID, conversation.sessionID, conversation.ticketID)
+
+	count := defaultAIReplySuggestions
+	instruction := ""
+	if req != nil {
+		if req.Count > 0 && req.Count <= maxAIReplySuggestions {
+			count = req.Count
+		}
+		instruction = strings.TrimSpace(req.Instruction)
+	}
+
+	result := &models.AIReplySuggestions{
+		Suggestions:    []string{},
+		Sources:        []models.KnowledgeSearchResult{},
+		SimilarTickets: []models.AISimilarTicket{},
+	}
+	if sources := s.ai.relevantKnowledge(ctx, conversation.tenantID, conversation.projectID, conversation.lastAsk); sources != nil {
+		result.Sources = sources
+	}
+	result.SimilarTickets = s.similarTickets(ctx, conversation)
+
+	prompt := []string{fmt.Sprintf(aiReplySuggestionsPrompt, count)}
+	if len(result.Sources) > 0 && s.ai.knowledgeService != nil {
+		prompt = append(prompt, s.ai.knowledgeService.FormatContextForAI(result.Sources))
+	}
+	if len(result.SimilarTickets) > 0 {
+		var similar strings.Builder
+		similar.WriteString("Similar resolved tickets:\n")
+		for _, ticket := range result.SimilarTickets {
+			fmt.Fprintf(&similar, "\n#%d %s\nResolution: %s\n", ticket.Number, ticket.Subject, ticket.Resolution)
+		}
+		prompt = append(prompt, s.ai.redactForAI(ctx, similar.String()))
+	}
+
+	userMessage := "Conversation:\n" + assistTranscript(conversation.lines)
+	if instruction != "" {
+		userMessage += "\n\nThe agent wants the replies to: " + instruction
+	}
+
+	profile := s.ai.assistProfile(ctx, conversation.tenantID, conversation.projectID, conversation.widgetID)
+	completion, err := s.ai.completeWithProfile(ctx, profile, []ChatCompletionMessage{
+		{Role: "system", Content: strings.Join(prompt, "\n\n")},
+		{Role: "user", Content: userMessage},
+	}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate AI response: %w", err)
+	}
+	s.ai.deductAssistUsage(ctx, conversation.tenantID, conversation.projectID, conversation.sessionID, "agent_assist_suggestions", completion)
+
+	// Drafts that break the project's rules are dropped like AI replies to visitors would be
+	for _, suggestion := range parseReplySuggestions(completion.Content) {
+		if phrase := forbiddenPhraseIn(profile, suggestion); phrase != "" {
+			s.ai.recordBlockedOutput(ctx, phrase, models.AIGuardrailActionDropped)
+			continue
+		}
+		result.Suggestions = append(result.Suggestions, suggestion)
+		if len(result.Suggestions) == count {
+			break
+		}
+	}
+	if completion.Usage != nil {
+		result.PromptTokens = completion.Usage.PromptTokens
+		result.CompletionTokens = completion.Usage.CompletionTokens
+	}
+	return result, nil
+}
+
+func (s *AIAssistService) summarize(ctx context.Context, conversation *aiAssistConversation) (*models.AIConversationSummary, error) {
+	if err := s.checkAvailable(ctx, conversation.tenantID); err != nil {
+		return nil, err
+	}
+	ctx = s.ai.guardedContext(ctx, conversation.tenantID, conversation.projectID, conversation.sessionID, conversation.ticketID)
+
+	profile := s.ai.assistProfile(ctx, conversation.tenantID, conversation.projectID, conversation.widgetID)
+	summary, err := s.ai.summarizeTranscript(ctx, profile, conversation.subject, conversation.lines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate AI response: %w", err)
+	}
+	s.ai.deductAssistUsage(ctx, conversation.tenantID, conversation.projectID, conversation.sessionID, "agent_assist_summary", summary)
+
+	result := &models.AIConversationSummary{
+		Summary:      summary.Content,
+		MessageCount: len(conversation.lines),
+	}
+	if summary.Usage != nil {
+		result.PromptTokens = summary.Usage.PromptTokens
+		result.CompletionTokens = summary.Usage.CompletionTokens
+	}
+	return result, nil
+}
+
+// checkAvailable refuses assistance when AI is off or the tenant has no credits left to bill it to
+func (s *AIAssistService) checkAvailable(ctx context.Context, tenantID uuid.UUID) error {
+	if !s.ai.IsEnabled() {
+		return ErrAIDisabled
+	}
+	if s.ai.usageService != nil {
+		hasCredits, err := s.ai.usageService.HasAvailableCredits(ctx, tenantID)
+		if err != nil {
+			return fmt.Errorf("failed to check AI credits: %w", err)
+		}
+		if !hasCredits {
+			return ErrAIAssistNoCredits
+		}
+	}
+	return nil
+}
+
+func (s *AIAssistService) chatConversation(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*aiAssistConversation, error) {
+	session, err := s.chats.GetChatSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrAIAssistNotFound
+	}
+
+	messages, err := s.chats.GetChatMessages(ctx, tenantID, projectID, sessionID, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation history: %w", err)
+	}
+
+	widgetID := session.WidgetID
+	conversation := &aiAssistConversation{
+		tenantID:  tenantID,
+		projectID: projectID,
+		sessionID: &session.ID,
+		ticketID:  session.TicketID,
+		widgetID:  &widgetID,
+	}
+	for _, message := range messages {
+		if message == nil || message.AuthorType == "system" || (message.MessageType != "text" && message.MessageType != "") {
+			continue
+		}
+		conversation.add(chatAuthorLabel(message), message.Content, message.AuthorType == "visitor", message.IsPrivate)
+	}
+	if len(conversation.lines) == 0 {
+		return nil, ErrAIAssistEmpty
+	}
+	return conversation, nil
+}
+
+func (s *AIAssistService) ticketConversation(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*aiAssistConversation, error) {
+	ticket, err := s.tickets.GetByTenantAndProjectID(ctx, tenantID, projectID, ticketID)
+	if err != nil || ticket == nil {
+		return nil, ErrAIAssistNotFound
+	}
+
+	messages, _, err := s.ticketMessages.GetByTenantProjectAndTicketID(ctx, tenantID, projectID, ticketID, true, repo.PaginationParams{Limit: maxAIAssistTicketMessages})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ticket messages: %w", err)
+	}
+
+	conversation := &aiAssistConversation{
+		tenantID:  tenantID,
+		projectID: projectID,
+		ticketID:  &ticket.ID,
+		subject:   ticket.Subject,
+	}
+	for _, message := range messages {
+		if message.AuthorType == "system" {
+			continue
+		}
+		label := "Agent"
+		switch message.AuthorType {
+		case "customer":
+			label = "Customer"
+		case "ai-agent":
+			label = "AI assistant"
+		}
+		conversation.add(label, message.Body, message.AuthorType == "customer", message.IsPrivate)
+	}
+	if len(conversation.lines) == 0 {
+		return nil, ErrAIAssistEmpty
+	}
+	return conversation, nil
+}
+
+func (c *aiAssistConversation) add(label, content string, fromCustomer, private bool) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return
+	}
+	if private {
+		label = "[private note] " + label
+	}
+	c.lines = append(c.lines, label+": "+content)
+	if fromCustomer {
+		c.lastAsk = content
+		c.customerIn = append(c.customerIn, content)
+	}
+}
+
+func chatAuthorLabel(message *models.ChatMessage) string {
+	switch message.AuthorType {
+	case "visitor":
+		return "Customer"
+	case "ai-agent":
+		return "AI assistant"
+	}
+	if message.AuthorName != "" {
+		return "Agent (" + message.AuthorName + ")"
+	}
+	return "Agent"
+}
+
+// similarTickets looks up resolved tickets that match what the customer wrote, with the reply that
+// resolved them. Lookup failures are logged and answered without tickets.
+func (s *AIAssistService) similarTickets(ctx context.Context, conversation *aiAssistConversation) []models.AISimilarTicket {
+	similar := []models.AISimilarTicket{}
+	if s.tickets == nil {
+		return similar
+	}
+	terms := similarTicketTerms(conversation.subject + " " + strings.Join(conversation.customerIn, " "))
+	tickets, err := s.tickets.FindSimilarResolved(ctx, conversation.tenantID, conversation.projectID, terms, conversation.ticketID, maxAISimilarTickets)
+	if err != nil {
+		fmt.Printf("Failed to find similar tickets for agent assist: %v\n", err)
+		return similar
+	}
+
+	for _, ticket := range tickets {
+		entry := models.AISimilarTicket{ID: ticket.ID, Number: ticket.Number, Subject: ticket.Subject}
+		messages, _, err := s.ticketMessages.GetByTenantProjectAndTicketID(ctx, ticket.TenantID, ticket.ProjectID, ticket.ID, false, repo.PaginationParams{Limit: maxAIAssistTicketMessages})
+		if err == nil {
+			for i := len(messages) - 1; i >= 0; i-- {
+				if messages[i].AuthorType == "agent" {
+					entry.Resolution = truncateRunes(strings.TrimSpace(messages[i].Body), maxAISimilarResolution)
+					break
+				}
+			}
+		}
+		similar = append(similar, entry)
+	}
+	return similar
+}
+
+// similarTicketTerms picks the distinctive words of a text, longest first, to search tickets with
+func similarTicketTerms(text string) []string {
+	seen := map[string]bool{}
+	var terms []string
+	for _, word := range aiAssistWordPattern.FindAllString(strings.ToLower(text), -1) {
+		if len([]rune(word)) < 4 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+	// Longer words tend to say more about the problem than short ones
+	sort.SliceStable(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+	if len(terms) > maxAISimilarTicketTerms {
+		terms = terms[:maxAISimilarTicketTerms]
+	}
+	return terms
+}
+
+// parseReplySuggestions reads the suggestions of a model reply, falling back to the whole reply when it
+// is not the JSON asked for
+func parseReplySuggestions(reply string) []string {
+	var parsed struct {
+		Suggestions []string `json:"suggestions"`
+	}
+	if err := json.Unmarshal([]byte(stripMarkdownCodeBlocks(reply)), &parsed); err != nil {
+		if reply = strings.TrimSpace(reply); reply != "" {
+			return []string{reply}
+		}
+		return nil
+	}
+
+	var suggestions []string
+	for _, suggestion := range parsed.Suggestions {
+		if suggestion = strings.TrimSpace(suggestion); suggestion != "" {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	return suggestions
+}
+
+// assistTranscript joins conversation lines, dropping the oldest when they do not fit the model's context
+func assistTranscript(lines []string) string {
+	size := 0
+	start := len(lines)
+	for start > 0 && size+len(lines[start-1])+1 <= maxAIAssistTranscript {
+		start--
+		size += len(lines[start]) + 1
+	}
+	if start == len(lines) && start > 0 {
+		// A single message larger than the limit is cut instead of dropped
+		return truncateRunes(lines[start-1], maxAIAssistTranscript)
+	}
+	transcript := strings.Join(lines[start:], "\n")
+	if start > 0 {
+		transcript = fmt.Sprintf("[%d earlier messages omitted]\n%s", start, transcript)
+	}
+	return transcript
+}
+
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
+
+// assistProfile returns the AI configuration used to assist agents of a project. Only its provider, model,
+// limits and forbidden phrases, with the project's output blocklist, apply; the persona is meant for visitors.
+func (s *AIService) assistProfile(ctx context.Context, tenantID, projectID uuid.UUID, widgetID *uuid.UUID) *models.ResolvedAIProfile {
+	if s.profileService == nil {
+		return s.withOutputBlocklist(ctx, tenantID, projectID, globalAIProfile(s.config))
+	}
+	profile, err := s.profileService.ResolveProfile(ctx, tenantID, projectID, widgetID)
+	if err != nil {
+		fmt.Printf("Failed to resolve AI profile for project %s, using global config: %v\n", projectID, err)
+		profile = globalAIProfile(s.config)
+	}
+	return s.withOutputBlocklist(ctx, tenantID, projectID, profile)
+}
+
+// summarizeTranscript summarizes conversation lines for an agent
+func (s *AIService) summarizeTranscript(ctx context.Context, profile *models.ResolvedAIProfile, subject string, lines []string) (*LLMResponse, error) {
+	conversation := assistTranscript(lines)
+	if subject != "" {
+		conversation = "Subject: " + subject + "\n\n" + conversation
+	}
+	summary, err := s.completeWithProfile(ctx, profile, []ChatCompletionMessage{
+		{Role: "system", Content: aiSummaryPrompt},
+		{Role: "user", Content: conversation},
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	summary.Content = strings.TrimSpace(summary.Content)
+	return summary, nil
+}
+
+// deductAssistUsage bills the tokens of a completion used to assist an agent to the tenant, at the
+// provider and model that answered. Failures are logged; the assistance has already been given.
+func (s *AIService) deductAssistUsage(ctx context.Context, tenantID, projectID uuid.UUID, sessionID *uuid.UUID, requestID string, completion *LLMResponse) {
+	if completion == nil || completion.Usage == nil || s.usageService == nil {
+		return
+	}
+	if _, err := s.usageService.DeductUsage(ctx, UsageDeductionInput{
+		TenantID:  tenantID,
+		ProjectID: projectID,
+		Provider:  string(completion.Provider),
+		Model:     completion.Model,
+		SessionID: sessionID,
+		RequestID: requestID,
+		Metrics:   *completion.Usage,
+	}); err != nil {
+		fmt.Printf("Failed to deduct AI usage credits: %v\n", err)
+	}
+}
+
+// postHandoffSummary summarizes a chat the AI handed to a human and posts the summary as a private note
+// for the agent who picks it up
+func (s *AIService) postHandoffSummary(ctx context.Context, session *models.ChatSession, reason string) {
+	if s.chatSessionService == nil || s.llm == nil || !s.IsEnabled() {
+		return
+	}
+	ctx = s.guardedContext(ctx, session.TenantID, session.ProjectID, &session.ID, nil)
+
+	messages, err := s.chatSessionService.GetChatMessages(ctx, session.TenantID, session.ProjectID, session.ID, false)
+	if err != nil {
+		fmt.Printf("Failed to load chat for handoff summary of session %s: %v\n", session.ID, err)
+		return
+	}
+	conversation := &aiAssistConversation{}
+	for _, message := range messages {
+		if message != nil && message.AuthorType != "system" {
+			conversation.add(chatAuthorLabel(message), message.Content, message.AuthorType == "visitor", false)
+		}
+	}
+	// Nothing to summarize before the visitor has said anything
+	if len(conversation.customerIn) == 0 {
+		return
+	}
+
+	profile := s.ResolveProfile(ctx, session)
+	completion, err := s.summarizeTranscript(ctx, profile, "", conversation.lines)
+	if err != nil {
+		fmt.Printf("Failed to summarize session %s for handoff: %v\n", session.ID, err)
+		return
+	}
+	s.deductAssistUsage(ctx, session.TenantID, session.ProjectID, &session.ID, "handoff_summary", completion)
+	summary := completion.Content
+	if summary == "" {
+		return
+	}
+
+	metadata := models.JSONMap{
+		"ai_generated":   true,
+		"note_type":      "handoff_summary",
+		"handoff_reason": reason,
+	}
+	if _, err := s.chatSessionService.SendPrivateMessage(ctx, session, s.generateAIAgentID(session.ID), aiHandoffSummaryAuthor, "Handoff summary:\n"+summary, metadata); err != nil {
+		fmt.Printf("Failed to post handoff summary for session %s: %v\n", session.ID, err)
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+)
+
+type fakeAIAssistChats struct {
+	session  *models.ChatSession
+	messages []*models.ChatMessage
+}
+
+func (f *fakeAIAssistChats) GetChatSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	if f.session == nil || f.session.ID != sessionID {
+		return nil, nil
+	}
+	return f.session, nil
+}
+
+func (f *fakeAIAssistChats) GetChatMessages(ctx context.Context, tenantID, projectID, sessionID uuid.UUID, includePrivate bool) ([]*models.ChatMessage, error) {
+	var messages []*models.ChatMessage
+	for _, message := range f.messages {
+		if includePrivate || !message.IsPrivate {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+type fakeAIAssistTickets struct {
+	tickets      map[uuid.UUID]*db.Ticket
+	messages     map[uuid.UUID][]*db.TicketMessage
+	similar      []*db.Ticket
+	searchedWith []string
+	excluded     *uuid.UUID
+}
+
+func (f *fakeAIAssistTickets) GetByTenantAndProjectID(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*db.Ticket, error) {
+	if ticket, ok := f.tickets[ticketID]; ok {
+		return ticket, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeAIAssistTickets) FindSimilarResolved(ctx context.Context, tenantID, projectID uuid.UUID, terms []string, excludeID *uuid.UUID, limit int) ([]*db.Ticket, error) {
+	f.searchedWith = terms
+	f.excluded = excludeID
+	return f.similar, nil
+}
+
+func (f *fakeAIAssistTickets) GetByTenantProjectAndTicketID(ctx context.Context, tenantID, projectID, ticketID uuid.UUID, includePrivate bool, pagination repo.PaginationParams) ([]*db.TicketMessage, string, error) {
+	var messages []*db.TicketMessage
+	for _, message := range f.messages[ticketID] {
+		if includePrivate || !message.IsPrivate {
+			messages = append(messages, message)
+		}
+	}
+	return messages, "", nil
+}
+
+type assistCreditsRepository struct {
+	mockCreditsRepository
+	balance int64
+}
+
+func (r *assistCreditsRepository) GetByTenantID(ctx context.Context, tenantID uuid.UUID) (*db.Credits, error) {
+	return &db.Credits{TenantID: tenantID, Balance: r.balance}, nil
+}
+
+// newAssistLLM answers every completion with reply and records the requests it was sent
+func newAssistLLM(t *testing.T, reply string) (*httptest.Server, *[]ChatCompletionRequest) {
+	var requests []ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		content, _ := json.Marshal(reply)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":` + string(content) + `}}],"usage":{"prompt_tokens":80,"completion_tokens":20,"total_tokens":100}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newTestAssistService(server *httptest.Server, credits *assistCreditsRepository, chats *fakeAIAssistChats, tickets *fakeAIAssistTickets) *AIAssistService {
+	cfg := &config.AIConfig{Enabled: true, APIKey: "test-key", Provider: "openai", BaseURL: server.URL, Model: "gpt-4o"}
+	ai := &AIService{config: cfg, llm: NewLLMRouter(cfg, server.Client())}
+	if credits != nil {
+		ai.usageService = NewAIUsageService(credits, nil)
+	}
+	return NewAIAssistService(ai, chats, tickets, tickets)
+}
+
+func TestSuggestTicketRepliesUsesResolvedTicketsAndBillsUsage(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	ticket := &db.Ticket{ID: uuid.New(), TenantID: tenantID, ProjectID: projectID, Number: 12, Subject: "Refund for damaged parcel"}
+	resolved := &db.Ticket{ID: uuid.New(), TenantID: tenantID, ProjectID: projectID, Number: 7, Subject: "Parcel arrived broken", Status: "resolved"}
+	tickets := &fakeAIAssistTickets{
+		tickets: map[uuid.UUID]*db.Ticket{ticket.ID: ticket},
+		messages: map[uuid.UUID][]*db.TicketMessage{
+			ticket.ID: {
+				{AuthorType: "customer", Body: "My parcel arrived damaged, can I get a refund?"},
+				{AuthorType: "agent", Body: "Customer is a VIP", IsPrivate: true},
+			},
+			resolved.ID: {
+				{AuthorType: "customer", Body: "Broken on arrival"},
+				{AuthorType: "agent", Body: "We refunded you in full; no need to return it."},
+			},
+		},
+		similar: []*db.Ticket{resolved},
+	}
+
+	server, requests := newAssistLLM(t, "```json\n{\"suggestions\": [\"Sorry about that! I've refunded you in full.\", \"Could you send a photo of the damage?\"]}\n```")
+	credits := &assistCreditsRepository{balance: 500}
+	credits.result = &db.CreditTransaction{ID: 1, BalanceAfter: 379}
+	svc := newTestAssistService(server, credits, &fakeAIAssistChats{}, tickets)
+
+	suggestions, err := svc.SuggestTicketReplies(ctx, tenantID, projectID, ticket.ID, &models.AIReplySuggestionsRequest{Count: 2, Instruction: "Offer a refund"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Sorry about that! I've refunded you in full.", "Could you send a photo of the damage?"}, suggestions.Suggestions)
+	require.Len(t, suggestions.SimilarTickets, 1)
+	require.Equal(t, "We refunded you in full; no need to return it.", suggestions.SimilarTickets[0].Resolution)
+	require.Equal(t, int64(80), suggestions.PromptTokens)
+
+	// Similar tickets are searched by the customer's words, leaving out the ticket itself
+	require.Contains(t, tickets.searchedWith, "refund")
+	require.Contains(t, tickets.searchedWith, "damaged")
+	require.Equal(t, &ticket.ID, tickets.excluded)
+
+	require.Len(t, *requests, 1)
+	prompt := (*requests)[0].Messages
+	require.Contains(t, prompt[0].Content, "Draft 2 different replies")
+	require.Contains(t, prompt[0].Content, "#7 Parcel arrived broken")
+	require.Contains(t, prompt[1].Content, "Customer: My parcel arrived damaged")
+	require.Contains(t, prompt[1].Content, "[private note] Agent: Customer is a VIP")
+	require.Contains(t, prompt[1].Content, "The agent wants the replies to: Offer a refund")
+
+	require.Equal(t, 1, credits.calls)
+	require.EqualValues(t, 121, credits.expectedAmount)
+}
+
+func TestSummarizeChatRefusesWithoutCreditsOrMessages(t *testing.T) {
+	ctx := context.Background()
+	session := &models.ChatSession{ID: uuid.New(), TenantID: uuid.New(), ProjectID: uuid.New(), WidgetID: uuid.New()}
+	chats := &fakeAIAssistChats{session: session}
+	server, requests := newAssistLLM(t, "- Wants to change the delivery address")
+
+	svc := newTestAssistService(server, nil, chats, &fakeAIAssistTickets{})
+	_, err := svc.SummarizeChat(ctx, session.TenantID, session.ProjectID, uuid.New())
+	require.ErrorIs(t, err, ErrAIAssistNotFound)
+	_, err = svc.SummarizeChat(ctx, session.TenantID, session.ProjectID, session.ID)
+	require.ErrorIs(t, err, ErrAIAssistEmpty)
+
+	chats.messages = []*models.ChatMessage{
+		{AuthorType: "visitor", MessageType: "text", Content: "Can you ship to my office instead?"},
+		{AuthorType: "system", MessageType: "text", Content: "Agent joined"},
+		{AuthorType: "agent", AuthorName: "Dana", MessageType: "text", Content: "Checking with the warehouse", IsPrivate: true},
+	}
+	svc = newTestAssistService(server, &assistCreditsRepository{}, chats, &fakeAIAssistTickets{})
+	_, err = svc.SummarizeChat(ctx, session.TenantID, session.ProjectID, session.ID)
+	require.ErrorIs(t, err, ErrAIAssistNoCredits)
+	require.Empty(t, *requests)
+
+	svc = newTestAssistService(server, nil, chats, &fakeAIAssistTickets{})
+	summary, err := svc.SummarizeChat(ctx, session.TenantID, session.ProjectID, session.ID)
+	require.NoError(t, err)
+	require.Equal(t, "- Wants to change the delivery address", summary.Summary)
+	require.Equal(t, 2, summary.MessageCount)
+
+	transcript := (*requests)[0].Messages[1].Content
+	require.Equal(t, "Customer: Can you ship to my office instead?\n[private note] Agent (Dana): Checking with the warehouse", transcript)
+}
+
+func TestAssistTranscriptKeepsMostRecentMessages(t *testing.T) {
+	long := strings.Repeat("a", maxAIAssistTranscript/2)
+	transcript := assistTranscript([]string{"Customer: first", long, long})
+	require.True(t, strings.HasPrefix(transcript, "[2 earlier messages omitted]\n"))
+	require.True(t, strings.HasSuffix(transcript, long))
+
+	require.Len(t, []rune(assistTranscript([]string{strings.Repeat("b", maxAIAssistTranscript+10)})), maxAIAssistTranscript+1)
+}
+
+func TestParseReplySuggestionsFallsBackToPlainReply(t *testing.T) {
+	require.Equal(t, []string{"Happy to help!"}, parseReplySuggestions("Happy to help!"))
+	require.Equal(t, []string{"One", "Two"}, parseReplySuggestions(`{"suggestions": ["One", " ", "Two"]}`))
+	require.Empty(t, parseReplySuggestions("  "))
+}
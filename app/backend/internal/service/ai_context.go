@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	tiktoken "github.com/pkoukk/tiktoken-go"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+const (
+	defaultAIContextWindow  = 8192
+	defaultAIReplyReserve   = 1024
+	aiMessageTokenOverhead  = 4    // role and separators the provider adds to every message
+	aiKnowledgeBudgetShare  = 0.4  // of the prompt budget left after the system prompt
+	aiSummaryBudgetShare    = 0.15 // of the prompt budget, the most the rolling summary may take
+	aiHistoryKeepShare      = 0.5  // history is cut to this share of its budget when rolled into the summary
+	minAISummaryTokens      = 128
+	maxAIContextMessages    = 200
+	aiContextSummaryHeading = "Summary of the earlier conversation:\n"
+)
+
+const aiRollingSummaryPrompt = `You keep a running summary of a customer support chat for the assistant answering it. Merge the summary so far with the newer messages into one updated summary of at most %d words. Keep what the customer needs, facts they gave (names, order numbers, account details), answers and promises already made, and what is still open. Do not add anything that is not in the conversation.`
+
+// aiModelContextWindows are the context windows of models by name prefix, longest prefix first
+var aiModelContextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4o-mini", 128000},
+	{"gpt-4o", 128000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+	{"gemini", 1000000},
+}
+
+type aiContextSummaryStore interface {
+	GetContextSummary(ctx context.Context, sessionID uuid.UUID) (*models.ChatContextSummary, error)
+	SaveContextSummary(ctx context.Context, sessionID uuid.UUID, summary string, seq int64) error
+}
+
+// aiTokenCounter counts the tokens of a text for a model
+type aiTokenCounter func(model, text string) int
+
+// newTiktokenCounter counts tokens with the model's tiktoken encoding, cl100k_base for models tiktoken does
+// not know. When no encoding can be loaded it approximates, like estimateTokenCount does.
+func newTiktokenCounter() aiTokenCounter {
+	var mu sync.Mutex
+	encodings := map[string]*tiktoken.Tiktoken{}
+	failed := map[string]bool{}
+
+	return func(model, text string) int {
+		if text == "" {
+			return 0
+		}
+		mu.Lock()
+		enc, ok := encodings[model]
+		if !ok && !failed[model] {
+			var err error
+			enc, err = tiktoken.EncodingForModel(model)
+			if err != nil {
+				enc, err = tiktoken.GetEncoding("cl100k_base")
+			}
+			if err != nil {
+				failed[model] = true
+			} else {
+				encodings[model] = enc
+			}
+		}
+		mu.Unlock()
+
+		if enc == nil {
+			return approxTokenCount(text)
+		}
+		return len(enc.EncodeOrdinary(text))
+	}
+}
+
+// approxTokenCount estimates about four characters per token
+func approxTokenCount(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// countTokens counts the tokens of a text for a model
+func (s *AIService) countTokens(model, text string) int {
+	if s.tokenCounter != nil {
+		return s.tokenCounter(model, text)
+	}
+	return approxTokenCount(text)
+}
+
+// aiContextWindow returns how many tokens a model accepts, prompt and reply together
+func (s *AIService) aiContextWindow(model string) int {
+	if s.config != nil && s.config.ContextWindow > 0 {
+		return s.config.ContextWindow
+	}
+	name := strings.ToLower(model)
+	for _, known := range aiModelContextWindows {
+		if strings.HasPrefix(name, known.prefix) {
+			return known.tokens
+		}
+	}
+	return defaultAIContextWindow
+}
+
+// promptBudget returns how many tokens the prompt of a reply may take: the model's window less room for
+// the reply, capped by the configured context budget
+func (s *AIService) promptBudget(profile *models.ResolvedAIProfile) int {
+	reserve := profile.MaxTokens
+	if reserve <= 0 {
+		reserve = defaultAIReplyReserve
+	}
+	budget := s.aiContextWindow(profile.Model) - reserve
+	if s.config != nil && s.config.ContextTokenBudget > 0 && s.config.ContextTokenBudget < budget {
+		budget = s.config.ContextTokenBudget
+	}
+	return max(budget, minAISummaryTokens)
+}
+
+// budgetedConversation builds the messages sent to the model for a reply. The schemas of the tools
+// offered and the system prompt are always sent; knowledge chunks, best first, take up to
+// aiKnowledgeBudgetShare of what is left, and the chat history the rest, newest first. History that no
+// longer fits is rolled into the session's summary.
+func (s *AIService) budgetedConversation(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, sources []models.KnowledgeSearchResult, messages []models.ChatMessage, tools *aiToolset) []ChatCompletionMessage {
+	budget := max(s.promptBudget(profile)-s.toolTokens(profile.Model, tools), minAISummaryTokens)
+
+	remaining := budget - s.countTokens(profile.Model, s.buildSystemPrompt(profile, nil)) - aiMessageTokenOverhead
+	sources = s.fitKnowledge(profile.Model, sources, int(float64(max(remaining, 0))*aiKnowledgeBudgetShare))
+	system := s.buildSystemPrompt(profile, sources)
+	remaining = budget - s.countTokens(profile.Model, system) - aiMessageTokenOverhead
+
+	summary, history := s.fitConversation(ctx, session, profile, messages, remaining)
+
+	chatMessages := []ChatCompletionMessage{{Role: "system", Content: system}}
+	if summary != "" {
+		chatMessages = append(chatMessages, ChatCompletionMessage{Role: "system", Content: aiContextSummaryHeading + summary})
+	}
+	for _, msg := range history {
+		chatMessages = append(chatMessages, ChatCompletionMessage{Role: chatCompletionRole(&msg), Content: msg.Content})
+	}
+	return chatMessages
+}
+
+// toolTokens estimates the prompt tokens the schemas of the offered tools take
+func (s *AIService) toolTokens(model string, tools *aiToolset) int {
+	if tools == nil || len(tools.definitions) == 0 {
+		return 0
+	}
+	schemas, err := json.Marshal(tools.definitions)
+	if err != nil {
+		return 0
+	}
+	return s.countTokens(model, string(schemas))
+}
+
+// fitKnowledge keeps the knowledge chunks, in the order given, that fit a token budget
+func (s *AIService) fitKnowledge(model string, sources []models.KnowledgeSearchResult, budget int) []models.KnowledgeSearchResult {
+	var kept []models.KnowledgeSearchResult
+	used := 0
+	for _, source := range sources {
+		tokens := s.countTokens(model, source.Content) + aiMessageTokenOverhead
+		if used+tokens > budget {
+			continue
+		}
+		used += tokens
+		kept = append(kept, source)
+	}
+	return kept
+}
+
+// fitConversation returns the rolling summary of a session and the messages after it that fit a token
+// budget, shared by the summary and the history. When messages fall out of the budget, the history is
+// cut to aiHistoryKeepShare of it and what was cut is rolled into the summary, so the summary is not
+// rebuilt on every reply.
+func (s *AIService) fitConversation(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, messages []models.ChatMessage, budget int) (string, []models.ChatMessage) {
+	saved := s.loadContextSummary(ctx, session)
+	if saved.Seq > 0 {
+		after := make([]models.ChatMessage, 0, len(messages))
+		for _, msg := range messages {
+			if msg.Seq == 0 || msg.Seq > saved.Seq {
+				after = append(after, msg)
+			}
+		}
+		messages = after
+	}
+	if len(messages) > maxAIContextMessages {
+		messages = messages[len(messages)-maxAIContextMessages:]
+	}
+
+	summary := saved.Summary
+	historyBudget := budget - s.countTokens(profile.Model, summary) - aiMessageTokenOverhead
+	kept := s.fitHistory(profile.Model, messages, historyBudget)
+	if len(kept) == len(messages) {
+		return summary, kept
+	}
+
+	summaryBudget := max(int(float64(budget)*aiSummaryBudgetShare), minAISummaryTokens)
+	kept = s.fitHistory(profile.Model, messages, int(float64(budget-summaryBudget)*aiHistoryKeepShare))
+	dropped := messages[:len(messages)-len(kept)]
+	if rolled, ok := s.rollContextSummary(ctx, session, profile, summary, dropped, summaryBudget); ok {
+		return rolled, kept
+	}
+
+	// Without a new summary the older messages are left out; the history still fits the budget
+	return summary, s.fitHistory(profile.Model, messages, historyBudget)
+}
+
+// fitHistory keeps the newest messages that fit a token budget. The newest message is always kept,
+// shortened when it does not fit on its own.
+func (s *AIService) fitHistory(model string, messages []models.ChatMessage, budget int) []models.ChatMessage {
+	if len(messages) == 0 {
+		return messages
+	}
+	used := 0
+	start := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		tokens := s.countTokens(model, messages[i].Content) + aiMessageTokenOverhead
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		start = i
+	}
+	if start < len(messages) {
+		return messages[start:]
+	}
+
+	last := messages[len(messages)-1]
+	last.Content = truncateRunes(last.Content, max(budget-aiMessageTokenOverhead, 1)*4)
+	return []models.ChatMessage{last}
+}
+
+// rollContextSummary merges messages that fell out of the context budget into the session's summary and
+// saves it. It reports false when no summary could be made.
+func (s *AIService) rollContextSummary(ctx context.Context, session *models.ChatSession, profile *models.ResolvedAIProfile, summary string, dropped []models.ChatMessage, budget int) (string, bool) {
+	if len(dropped) == 0 || s.llm == nil || !s.IsEnabled() {
+		return "", false
+	}
+
+	var conversation strings.Builder
+	if summary != "" {
+		conversation.WriteString("Summary so far:\n" + summary + "\n\n")
+	}
+	conversation.WriteString("Newer messages:\n")
+	lines := make([]string, 0, len(dropped))
+	for i := range dropped {
+		lines = append(lines, chatAuthorLabel(&dropped[i])+": "+dropped[i].Content)
+	}
+	conversation.WriteString(assistTranscript(lines))
+
+	summaryProfile := *profile
+	summaryProfile.MaxTokens = budget
+	words := max(budget*3/4, 50)
+	completion, err := s.completeWithProfile(ctx, &summaryProfile, []ChatCompletionMessage{
+		{Role: "system", Content: fmt.Sprintf(aiRollingSummaryPrompt, words)},
+		{Role: "user", Content: conversation.String()},
+	}, nil, nil)
+	if err != nil {
+		fmt.Printf("Failed to summarize earlier messages of session %s: %v\n", session.ID, err)
+		return "", false
+	}
+	s.deductAssistUsage(ctx, session.TenantID, session.ProjectID, &session.ID, "context_summary", completion)
+
+	rolled := strings.TrimSpace(completion.Content)
+	if rolled == "" {
+		return "", false
+	}
+	if seq := dropped[len(dropped)-1].Seq; seq > 0 && s.contextSummaries != nil {
+		if err := s.contextSummaries.SaveContextSummary(ctx, session.ID, rolled, seq); err != nil {
+			fmt.Printf("Failed to save context summary of session %s: %v\n", session.ID, err)
+		}
+	}
+	return rolled, true
+}
+
+// loadContextSummary returns the saved rolling summary of a session; lookup failures are logged and
+// answered as no summary
+func (s *AIService) loadContextSummary(ctx context.Context, session *models.ChatSession) *models.ChatContextSummary {
+	if s.contextSummaries == nil {
+		return &models.ChatContextSummary{}
+	}
+	summary, err := s.contextSummaries.GetContextSummary(ctx, session.ID)
+	if err != nil || summary == nil {
+		if err != nil {
+			fmt.Printf("Failed to load context summary of session %s: %v\n", session.ID, err)
+		}
+		return &models.ChatContextSummary{}
+	}
+	return summary
+}
+
+// chatCompletionRole returns the role a chat message is sent to the model as
+func chatCompletionRole(msg *models.ChatMessage) string {
+	if msg.AuthorType == "ai-agent" || msg.AuthorType == "agent" {
+		return "assistant"
+	}
+	return "user"
+}
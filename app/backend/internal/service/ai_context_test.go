@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeContextSummaries struct {
+	summary *models.ChatContextSummary
+	saves   int
+}
+
+func (f *fakeContextSummaries) GetContextSummary(ctx context.Context, sessionID uuid.UUID) (*models.ChatContextSummary, error) {
+	if f.summary == nil {
+		return &models.ChatContextSummary{}, nil
+	}
+	return f.summary, nil
+}
+
+func (f *fakeContextSummaries) SaveContextSummary(ctx context.Context, sessionID uuid.UUID, summary string, seq int64) error {
+	f.saves++
+	f.summary = &models.ChatContextSummary{Summary: summary, Seq: seq}
+	return nil
+}
+
+// contextTestMessages returns chat messages of 13 approximate tokens each, overhead included
+func contextTestMessages(count int) []models.ChatMessage {
+	messages := make([]models.ChatMessage, count)
+	for i := range messages {
+		author := "visitor"
+		if i%2 == 1 {
+			author = "ai-agent"
+		}
+		messages[i] = models.ChatMessage{Seq: int64(i + 1), AuthorType: author, Content: fmt.Sprintf("%-36s", fmt.Sprintf("message %d", i+1))}
+	}
+	return messages
+}
+
+func TestFitConversationRollsOlderMessagesIntoSummary(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newAssistLLM(t, "Customer wants a refund for order 42")
+	cfg := &config.AIConfig{Enabled: true, APIKey: "test-key", Provider: "openai", BaseURL: server.URL, Model: "gpt-4o"}
+	store := &fakeContextSummaries{}
+	svc := &AIService{config: cfg, llm: NewLLMRouter(cfg, server.Client()), contextSummaries: store}
+	session := &models.ChatSession{ID: uuid.New(), TenantID: uuid.New(), ProjectID: uuid.New()}
+	profile := globalAIProfile(cfg)
+
+	messages := contextTestMessages(30)
+	summary, history := svc.fitConversation(ctx, session, profile, messages, 200)
+	require.Equal(t, "Customer wants a refund for order 42", summary)
+
+	// History is cut to half of what the summary leaves, so the next replies fit without summarizing again
+	require.Len(t, history, 2)
+	require.Equal(t, int64(29), history[0].Seq)
+	require.Equal(t, 1, store.saves)
+	require.Equal(t, int64(28), store.summary.Seq)
+
+	require.Len(t, *requests, 1)
+	prompt := (*requests)[0].Messages[1].Content
+	require.Contains(t, prompt, "Customer: message 1 ")
+	require.Contains(t, prompt, "AI assistant: message 28")
+	require.NotContains(t, prompt, "message 29")
+
+	// Messages the summary covers are not sent again, and while the rest fits it is not rebuilt
+	summary, history = svc.fitConversation(ctx, session, profile, contextTestMessages(31), 200)
+	require.Equal(t, "Customer wants a refund for order 42", summary)
+	require.Len(t, history, 3)
+	require.Equal(t, int64(29), history[0].Seq)
+	require.Len(t, *requests, 1)
+
+	// The previous summary is merged into the next one
+	svc.fitConversation(ctx, session, profile, contextTestMessages(60), 200)
+	require.Len(t, *requests, 2)
+	require.Contains(t, (*requests)[1].Messages[1].Content, "Summary so far:\nCustomer wants a refund for order 42")
+	require.NotContains(t, (*requests)[1].Messages[1].Content, "message 28")
+	require.Equal(t, 2, store.saves)
+}
+
+func TestFitConversationWithoutAIDropsOldestMessages(t *testing.T) {
+	cfg := &config.AIConfig{Model: "gpt-4o"}
+	svc := &AIService{config: cfg, contextSummaries: &fakeContextSummaries{}}
+	session := &models.ChatSession{ID: uuid.New()}
+
+	summary, history := svc.fitConversation(context.Background(), session, globalAIProfile(cfg), contextTestMessages(30), 200)
+	require.Empty(t, summary)
+	require.Len(t, history, 15)
+	require.Equal(t, int64(30), history[len(history)-1].Seq)
+}
+
+func TestFitHistoryKeepsNewestMessageShortened(t *testing.T) {
+	svc := &AIService{}
+	messages := []models.ChatMessage{{Content: "older"}, {Content: strings.Repeat("y", 400)}}
+
+	history := svc.fitHistory("gpt-4o", messages, 20)
+	require.Len(t, history, 1)
+	require.Equal(t, strings.Repeat("y", 64)+"…", history[0].Content)
+	require.Len(t, messages[1].Content, 400)
+}
+
+func TestPromptBudgetUsesModelWindowAndConfiguredCap(t *testing.T) {
+	svc := &AIService{config: &config.AIConfig{}}
+	require.Equal(t, 128000-500, svc.promptBudget(&models.ResolvedAIProfile{Model: "gpt-4o-mini", MaxTokens: 500}))
+	require.Equal(t, 8192-defaultAIReplyReserve, svc.promptBudget(&models.ResolvedAIProfile{Model: "my-azure-deployment"}))
+	require.Equal(t, 200000-1000, svc.promptBudget(&models.ResolvedAIProfile{Model: "claude-3-5-sonnet-latest", MaxTokens: 1000}))
+
+	svc.config.ContextTokenBudget = 4000
+	require.Equal(t, 4000, svc.promptBudget(&models.ResolvedAIProfile{Model: "gpt-4o", MaxTokens: 500}))
+
+	svc.config = &config.AIConfig{ContextWindow: 2000}
+	require.Equal(t, 1500, svc.promptBudget(&models.ResolvedAIProfile{Model: "gpt-4o", MaxTokens: 500}))
+}
+
+func TestBudgetedConversationSendsSummaryBeforeHistory(t *testing.T) {
+	cfg := &config.AIConfig{Model: "gpt-4o", ContextWindow: 1200, SystemPrompt: "You are helpful."}
+	store := &fakeContextSummaries{summary: &models.ChatContextSummary{Summary: "Asked about shipping", Seq: 2}}
+	svc := &AIService{config: cfg, contextSummaries: store}
+	session := &models.ChatSession{ID: uuid.New()}
+	profile := globalAIProfile(cfg)
+	profile.MaxTokens = 200
+
+	messages := svc.budgetedConversation(context.Background(), session, profile, nil, contextTestMessages(4), nil)
+	require.Len(t, messages, 4)
+	require.Equal(t, "system", messages[0].Role)
+	require.Equal(t, "system", messages[1].Role)
+	require.Equal(t, aiContextSummaryHeading+"Asked about shipping", messages[1].Content)
+	require.Equal(t, "user", messages[2].Role)
+	require.Equal(t, "assistant", messages[3].Role)
+	require.True(t, strings.HasPrefix(messages[3].Content.(string), "message 4"))
+
+	history := svc.agentConversationHistory(context.Background(), session, profile, contextTestMessages(4))
+	require.Len(t, history, 3)
+	require.Equal(t, ChatMessage{Role: "system", Content: aiContextSummaryHeading + "Asked about shipping"}, history[0])
+}
+
+func TestBudgetedConversationLeavesRoomForToolSchemas(t *testing.T) {
+	cfg := &config.AIConfig{Model: "gpt-4o", ContextWindow: 1200, SystemPrompt: "You are helpful."}
+	svc := &AIService{config: cfg}
+	session := &models.ChatSession{ID: uuid.New()}
+	profile := globalAIProfile(cfg)
+	profile.MaxTokens = 200
+
+	tools := &aiToolset{}
+	tools.define("order_status", strings.Repeat("Look up the status of an order. ", 60), map[string]interface{}{
+		"order_id": map[string]interface{}{"type": "string", "description": "Order number"},
+	})
+	require.Greater(t, svc.toolTokens(profile.Model, tools), 300)
+
+	history := contextTestMessages(8)
+	for i := range history {
+		history[i].Content += strings.Repeat(" more words", 30)
+	}
+	without := svc.budgetedConversation(context.Background(), session, profile, nil, history, nil)
+	with := svc.budgetedConversation(context.Background(), session, profile, nil, history, tools)
+	require.Len(t, without, 1+len(history))
+	require.Less(t, len(with), len(without))
+}
+
+func TestFitKnowledgeKeepsBestChunksWithinBudget(t *testing.T) {
+	svc := &AIService{}
+	sources := []models.KnowledgeSearchResult{
+		{Content: strings.Repeat("a", 80)},
+		{Content: strings.Repeat("b", 400)},
+		{Content: strings.Repeat("c", 40)},
+	}
+	kept := svc.fitKnowledge("gpt-4o", sources, 50)
+	require.Len(t, kept, 2)
+	require.Equal(t, sources[0].Content, kept[0].Content)
+	require.Equal(t, sources[2].Content, kept[1].Content)
+}
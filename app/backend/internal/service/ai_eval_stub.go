@@ -0,0 +1,108 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// evalStubNoAnswer is what the stub model replies when it was given no knowledge
+const evalStubNoAnswer = "I don't know."
+
+// EvalStubEmbeddingService is the knowledge embedding service that embeds text locally with
+// evalStubEmbedding instead of calling a provider
+const EvalStubEmbeddingService = "stub"
+
+// NewEvalStubLLM returns a stand-in for an OpenAI-compatible chat completions API that answers without a
+// model, so evaluation suites can run offline. It replies with the first sentence of the first knowledge
+// source in the system prompt and cites it as Source 1, and with "I don't know." when it was given no
+// knowledge. Replies are the same for the same prompt and use no tokens.
+func NewEvalStubLLM() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIStreamRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":{"message":"invalid request body"}}`, http.StatusBadRequest)
+			return
+		}
+		reply := evalStubReply(req.Messages)
+
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			chunk, _ := json.Marshal(map[string]interface{}{
+				"choices": []map[string]interface{}{{"delta": map[string]string{"content": reply}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":0,\"completion_tokens\":0,\"total_tokens\":0}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": reply}}},
+			"usage":   map[string]int{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0},
+		})
+	})
+}
+
+// evalStubReply answers from the knowledge FormatContextForAI put in the system prompt
+func evalStubReply(messages []ChatCompletionMessage) string {
+	for _, message := range messages {
+		content, ok := message.Content.(string)
+		if message.Role != "system" || !ok {
+			continue
+		}
+		start := strings.Index(content, "Source 1 (")
+		if start < 0 {
+			continue
+		}
+		for _, line := range strings.Split(content[start:], "\n") {
+			text, found := strings.CutPrefix(line, "Content: ")
+			if !found {
+				continue
+			}
+			text = strings.TrimSpace(text)
+			if end := strings.Index(text, ". "); end >= 0 {
+				text = text[:end+1]
+			}
+			if text != "" {
+				return text + " (Source 1)"
+			}
+		}
+	}
+	return evalStubNoAnswer
+}
+
+// evalStubEmbedding embeds text without a model by hashing its words into the dimensions of a vector, so
+// texts sharing words are close. The same text always gets the same vector. It only finds knowledge that
+// was indexed with it too.
+func evalStubEmbedding(text string, dimension int) pgvector.Vector {
+	values := make([]float32, dimension)
+	for _, word := range evalWords(text) {
+		hash := fnv.New32a()
+		hash.Write([]byte(word))
+		sum := hash.Sum32()
+		if sum&1 == 0 {
+			values[int(sum>>1)%dimension]++
+		} else {
+			values[int(sum>>1)%dimension]--
+		}
+	}
+
+	var norm float64
+	for _, value := range values {
+		norm += float64(value) * float64(value)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range values {
+			values[i] *= scale
+		}
+	}
+	return pgvector.NewVector(values)
+}
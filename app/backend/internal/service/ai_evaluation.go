@@ -0,0 +1,747 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+var (
+	ErrAIEvalCaseNotFound = errors.New("evaluation case not found")
+	ErrAIEvalRunNotFound  = errors.New("evaluation run not found")
+	ErrInvalidAIEvalCase  = errors.New("invalid evaluation case")
+	ErrInvalidAIEvalCSV   = errors.New("invalid evaluation CSV")
+	ErrAIEvalSuiteFull    = errors.New("evaluation suite is full")
+	ErrAIEvalNoCases      = errors.New("evaluation suite has no cases")
+)
+
+const (
+	maxAIEvalCases             = 500
+	maxAIEvalExpectedSources   = 20
+	maxAIEvalSourceLen         = 500
+	maxAIEvalQuestionLen       = 4000
+	maxAIEvalExpectedAnswerLen = 8000
+	defaultAIEvalRunsLimit     = 20
+	maxAIEvalRunsLimit         = 100
+	defaultThumbsDownLimit     = 100
+	maxThumbsDownLimit         = 500
+	defaultThumbsDownPeriod    = 30 * 24 * time.Hour
+)
+
+type aiEvalStore interface {
+	CreateCase(ctx context.Context, evalCase *models.AIEvalCase) (bool, error)
+	GetCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) (*models.AIEvalCase, error)
+	ListCases(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIEvalCase, error)
+	UpdateCase(ctx context.Context, evalCase *models.AIEvalCase) error
+	DeleteCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) error
+	ThumbsDownReplies(ctx context.Context, tenantID, projectID uuid.UUID, since time.Time, limit int) ([]*models.AIThumbsDownReply, error)
+	CreateRun(ctx context.Context, run *models.AIEvalRun) error
+	FinishRun(ctx context.Context, run *models.AIEvalRun) error
+	GetRun(ctx context.Context, tenantID, projectID, runID uuid.UUID) (*models.AIEvalRun, error)
+	ListRuns(ctx context.Context, tenantID, projectID uuid.UUID, limit int) ([]*models.AIEvalRun, error)
+	CreateResult(ctx context.Context, result *models.AIEvalResult) error
+	ListResults(ctx context.Context, runID uuid.UUID) ([]*models.AIEvalResult, error)
+}
+
+// AIEvaluationService manages the evaluation suites of projects and runs them through the same knowledge
+// lookup and AI profile a visitor's question goes through, scoring what was retrieved, answered and cited
+type AIEvaluationService struct {
+	store aiEvalStore
+	ai    *AIService
+
+	// provider and model runs ask instead of the project's, such as a local stub
+	provider string
+	model    string
+}
+
+// NewAIEvaluationService creates a new AI evaluation service
+func NewAIEvaluationService(store aiEvalStore, ai *AIService) *AIEvaluationService {
+	return &AIEvaluationService{store: store, ai: ai}
+}
+
+// UseProvider makes runs ask a provider and model instead of the ones of the project's AI profile
+func (s *AIEvaluationService) UseProvider(provider, model string) {
+	s.provider = provider
+	s.model = model
+}
+
+// ListCases returns the evaluation suite of a project
+func (s *AIEvaluationService) ListCases(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIEvalCase, error) {
+	return s.store.ListCases(ctx, tenantID, projectID)
+}
+
+// CreateCase adds a case to the evaluation suite of a project
+func (s *AIEvaluationService) CreateCase(ctx context.Context, tenantID, projectID uuid.UUID, req *models.AIEvalCaseRequest) (*models.AIEvalCase, error) {
+	evalCase := &models.AIEvalCase{TenantID: tenantID, ProjectID: projectID, Origin: models.AIEvalOriginManual}
+	if err := applyAIEvalCaseRequest(evalCase, req); err != nil {
+		return nil, err
+	}
+	room, err := s.suiteRoom(ctx, tenantID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if room == 0 {
+		return nil, ErrAIEvalSuiteFull
+	}
+	if _, err := s.store.CreateCase(ctx, evalCase); err != nil {
+		return nil, fmt.Errorf("failed to create evaluation case: %w", err)
+	}
+	return evalCase, nil
+}
+
+// UpdateCase replaces the question and expectations of an evaluation case
+func (s *AIEvaluationService) UpdateCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID, req *models.AIEvalCaseRequest) (*models.AIEvalCase, error) {
+	evalCase, err := s.getCase(ctx, tenantID, projectID, caseID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyAIEvalCaseRequest(evalCase, req); err != nil {
+		return nil, err
+	}
+	if err := s.store.UpdateCase(ctx, evalCase); err != nil {
+		return nil, fmt.Errorf("failed to update evaluation case: %w", err)
+	}
+	return evalCase, nil
+}
+
+// DeleteCase removes a case from the evaluation suite of a project
+func (s *AIEvaluationService) DeleteCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) error {
+	if _, err := s.getCase(ctx, tenantID, projectID, caseID); err != nil {
+		return err
+	}
+	if err := s.store.DeleteCase(ctx, tenantID, projectID, caseID); err != nil {
+		return fmt.Errorf("failed to delete evaluation case: %w", err)
+	}
+	return nil
+}
+
+func (s *AIEvaluationService) getCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) (*models.AIEvalCase, error) {
+	evalCase, err := s.store.GetCase(ctx, tenantID, projectID, caseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get evaluation case: %w", err)
+	}
+	if evalCase == nil {
+		return nil, ErrAIEvalCaseNotFound
+	}
+	return evalCase, nil
+}
+
+// suiteRoom returns how many more cases the suite of a project takes
+func (s *AIEvaluationService) suiteRoom(ctx context.Context, tenantID, projectID uuid.UUID) (int, error) {
+	cases, err := s.store.ListCases(ctx, tenantID, projectID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list evaluation cases: %w", err)
+	}
+	return max(maxAIEvalCases-len(cases), 0), nil
+}
+
+// ImportCSV adds the cases of a CSV file to the evaluation suite of a project. The header names the
+// columns: question is required, expected_answer and expected_sources are optional, and several expected
+// sources are separated by "|". Rows without a question are skipped; the whole file is rejected when a
+// row is invalid or the suite would grow past its limit.
+func (s *AIEvaluationService) ImportCSV(ctx context.Context, tenantID, projectID uuid.UUID, r io.Reader) (*models.AIEvalImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: the file is empty", ErrInvalidAIEvalCSV)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAIEvalCSV, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["question"]; !ok {
+		return nil, fmt.Errorf("%w: the header has no question column", ErrInvalidAIEvalCSV)
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	result := &models.AIEvalImportResult{}
+	cases := []*models.AIEvalCase{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAIEvalCSV, err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		req := &models.AIEvalCaseRequest{
+			Question:        field(record, "question"),
+			ExpectedAnswer:  field(record, "expected_answer"),
+			ExpectedSources: strings.Split(field(record, "expected_sources"), "|"),
+		}
+		if strings.TrimSpace(req.Question) == "" {
+			result.Skipped++
+			continue
+		}
+		evalCase := &models.AIEvalCase{TenantID: tenantID, ProjectID: projectID, Origin: models.AIEvalOriginCSV}
+		if err := applyAIEvalCaseRequest(evalCase, req); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidAIEvalCSV, line, err)
+		}
+		cases = append(cases, evalCase)
+	}
+
+	room, err := s.suiteRoom(ctx, tenantID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) > room {
+		return nil, fmt.Errorf("%w: it takes %d more cases, the file has %d", ErrAIEvalSuiteFull, room, len(cases))
+	}
+	for _, evalCase := range cases {
+		if _, err := s.store.CreateCase(ctx, evalCase); err != nil {
+			return nil, fmt.Errorf("failed to create evaluation case: %w", err)
+		}
+		result.Imported++
+	}
+	return result, nil
+}
+
+// ImportThumbsDown adds the AI replies visitors gave a thumbs down since a time to the evaluation suite of
+// a project, at most limit of them, newest first. The visitor's question becomes the case and the reply is
+// kept as the rejected answer; the expected answer and sources are left for a reviewer to fill in.
+// Replies that already have a case are skipped.
+func (s *AIEvaluationService) ImportThumbsDown(ctx context.Context, tenantID, projectID uuid.UUID, since *time.Time, limit int) (*models.AIEvalImportResult, error) {
+	from := time.Now().Add(-defaultThumbsDownPeriod)
+	if since != nil {
+		from = *since
+	}
+	if limit <= 0 {
+		limit = defaultThumbsDownLimit
+	}
+	if limit > maxThumbsDownLimit {
+		limit = maxThumbsDownLimit
+	}
+
+	replies, err := s.store.ThumbsDownReplies(ctx, tenantID, projectID, from, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rated AI replies: %w", err)
+	}
+	room, err := s.suiteRoom(ctx, tenantID, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.AIEvalImportResult{}
+	for _, reply := range replies {
+		question := truncateRunes(strings.TrimSpace(reply.Question), maxAIEvalQuestionLen)
+		if question == "" || result.Imported >= room {
+			result.Skipped++
+			continue
+		}
+		sessionID, messageID := reply.SessionID, reply.FeedbackMessageID
+		answer := reply.Answer
+		evalCase := &models.AIEvalCase{
+			TenantID:        tenantID,
+			ProjectID:       projectID,
+			Question:        question,
+			ExpectedSources: pq.StringArray{},
+			Origin:          models.AIEvalOriginThumbsDown,
+			OriginSessionID: &sessionID,
+			OriginMessageID: &messageID,
+			RejectedAnswer:  &answer,
+		}
+		created, err := s.store.CreateCase(ctx, evalCase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create evaluation case: %w", err)
+		}
+		if created {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
+
+// applyAIEvalCaseRequest validates a case request and applies it to a case
+func applyAIEvalCaseRequest(evalCase *models.AIEvalCase, req *models.AIEvalCaseRequest) error {
+	question := strings.TrimSpace(req.Question)
+	if question == "" {
+		return fmt.Errorf("%w: question is required", ErrInvalidAIEvalCase)
+	}
+	if len([]rune(question)) > maxAIEvalQuestionLen {
+		return fmt.Errorf("%w: question is longer than %d characters", ErrInvalidAIEvalCase, maxAIEvalQuestionLen)
+	}
+	answer := strings.TrimSpace(req.ExpectedAnswer)
+	if len([]rune(answer)) > maxAIEvalExpectedAnswerLen {
+		return fmt.Errorf("%w: expected answer is longer than %d characters", ErrInvalidAIEvalCase, maxAIEvalExpectedAnswerLen)
+	}
+
+	sources := pq.StringArray{}
+	seen := map[string]bool{}
+	for _, source := range req.ExpectedSources {
+		source = strings.TrimSpace(source)
+		if source == "" || seen[normalizeEvalSource(source)] {
+			continue
+		}
+		if len(source) > maxAIEvalSourceLen {
+			return fmt.Errorf("%w: expected source is longer than %d characters", ErrInvalidAIEvalCase, maxAIEvalSourceLen)
+		}
+		seen[normalizeEvalSource(source)] = true
+		sources = append(sources, source)
+	}
+	if len(sources) > maxAIEvalExpectedSources {
+		return fmt.Errorf("%w: at most %d expected sources", ErrInvalidAIEvalCase, maxAIEvalExpectedSources)
+	}
+
+	evalCase.Question = question
+	evalCase.ExpectedAnswer = answer
+	evalCase.ExpectedSources = sources
+	return nil
+}
+
+// StartRun runs the evaluation suite of a project in the background and returns the run, which is
+// completed or failed once every case was asked
+func (s *AIEvaluationService) StartRun(ctx context.Context, tenantID, projectID uuid.UUID, label string, startedBy *uuid.UUID) (*models.AIEvalRun, error) {
+	run, profile, cases, err := s.prepareRun(ctx, tenantID, projectID, label, startedBy)
+	if err != nil {
+		return nil, err
+	}
+	started := *run
+	go s.executeRun(context.Background(), run, profile, cases)
+	return &started, nil
+}
+
+// RunSuite runs the evaluation suite of a project and returns the run with its results once it is done
+func (s *AIEvaluationService) RunSuite(ctx context.Context, tenantID, projectID uuid.UUID, label string) (*models.AIEvalRunDetail, error) {
+	run, profile, cases, err := s.prepareRun(ctx, tenantID, projectID, label, nil)
+	if err != nil {
+		return nil, err
+	}
+	results := s.executeRun(ctx, run, profile, cases)
+	return &models.AIEvalRunDetail{AIEvalRun: *run, Results: results}, nil
+}
+
+// prepareRun resolves the AI profile runs of a project are asked with and stores a new run of its suite
+func (s *AIEvaluationService) prepareRun(ctx context.Context, tenantID, projectID uuid.UUID, label string, startedBy *uuid.UUID) (*models.AIEvalRun, *models.ResolvedAIProfile, []*models.AIEvalCase, error) {
+	if !s.ai.IsEnabled() {
+		return nil, nil, nil, ErrAIDisabled
+	}
+	cases, err := s.store.ListCases(ctx, tenantID, projectID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to list evaluation cases: %w", err)
+	}
+	if len(cases) == 0 {
+		return nil, nil, nil, ErrAIEvalNoCases
+	}
+
+	profile := s.ai.assistProfile(ctx, tenantID, projectID, nil)
+	if s.provider != "" {
+		overridden := *profile
+		overridden.Provider = s.provider
+		if s.model != "" {
+			overridden.Model = s.model
+		}
+		profile = &overridden
+	}
+
+	run := &models.AIEvalRun{
+		TenantID:  tenantID,
+		ProjectID: projectID,
+		Label:     truncateRunes(strings.TrimSpace(label), 254),
+		Status:    models.AIEvalRunRunning,
+		Provider:  profile.Provider,
+		Model:     profile.Model,
+		CaseCount: len(cases),
+		StartedBy: startedBy,
+	}
+	if err := s.store.CreateRun(ctx, run); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create evaluation run: %w", err)
+	}
+	return run, profile, cases, nil
+}
+
+// executeRun asks every case of a run, stores its result and finishes the run with its scores. Cases
+// whose question could not be answered count as failed and are left out of the scores; the run fails
+// when all of them did.
+func (s *AIEvaluationService) executeRun(ctx context.Context, run *models.AIEvalRun, profile *models.ResolvedAIProfile, cases []*models.AIEvalCase) []*models.AIEvalResult {
+	results := make([]*models.AIEvalResult, 0, len(cases))
+	var firstErr string
+	for _, evalCase := range cases {
+		result := s.evaluateCase(ctx, run, profile, evalCase)
+		if result.Error != nil {
+			run.FailedCount++
+			if firstErr == "" {
+				firstErr = *result.Error
+			}
+		}
+		if err := s.store.CreateResult(ctx, result); err != nil {
+			fmt.Printf("Failed to store evaluation result of case %s: %v\n", evalCase.ID, err)
+		}
+		results = append(results, result)
+	}
+
+	scoreAIEvalRun(run, results)
+	run.Status = models.AIEvalRunCompleted
+	if run.FailedCount == len(cases) {
+		run.Status = models.AIEvalRunFailed
+		run.Error = &firstErr
+	}
+	completedAt := time.Now()
+	run.CompletedAt = &completedAt
+	if err := s.store.FinishRun(ctx, run); err != nil {
+		fmt.Printf("Failed to finish evaluation run %s: %v\n", run.ID, err)
+	}
+	return results
+}
+
+// evaluateCase asks the question of a case as a visitor would and scores the answer
+func (s *AIEvaluationService) evaluateCase(ctx context.Context, run *models.AIEvalRun, profile *models.ResolvedAIProfile, evalCase *models.AIEvalCase) *models.AIEvalResult {
+	result := &models.AIEvalResult{
+		RunID:            run.ID,
+		CaseID:           evalCase.ID,
+		Question:         evalCase.Question,
+		ExpectedAnswer:   evalCase.ExpectedAnswer,
+		ExpectedSources:  append(pq.StringArray{}, evalCase.ExpectedSources...),
+		RetrievedSources: pq.StringArray{},
+		CitedSources:     pq.StringArray{},
+	}
+
+	started := time.Now()
+	preview, err := s.ai.PreviewProfile(ctx, run.TenantID, run.ProjectID, profile, evalCase.Question)
+	result.LatencyMS = time.Since(started).Milliseconds()
+	if err != nil {
+		message := err.Error()
+		result.Error = &message
+		return result
+	}
+
+	run.PromptTokens += preview.PromptTokens
+	run.CompletionTokens += preview.CompletionTokens
+	for _, source := range preview.Sources {
+		result.RetrievedSources = append(result.RetrievedSources, source.Source)
+	}
+	result.Answer = preview.Reply
+	result.Handoff = preview.Handoff
+	result.Blocked = preview.Blocked
+
+	// A handoff or a blocked reply is not what the visitor gets an answer from
+	answer := result.Answer
+	if result.Handoff || result.Blocked {
+		answer = ""
+	}
+	result.CitedSources = citedEvalSources(answer, result.RetrievedSources)
+	scoreAIEvalResult(result, answer)
+	return result
+}
+
+// scoreAIEvalResult scores an answer against the expectations of its case. Scores a case has no
+// expectations for are left nil.
+func scoreAIEvalResult(result *models.AIEvalResult, answer string) {
+	if len(result.ExpectedSources) > 0 {
+		hit := false
+		for _, expected := range result.ExpectedSources {
+			if evalSourceIn(expected, result.RetrievedSources) {
+				hit = true
+				break
+			}
+		}
+		result.RetrievalHit = &hit
+
+		correct := len(result.CitedSources) > 0
+		for _, cited := range result.CitedSources {
+			matched := false
+			for _, expected := range result.ExpectedSources {
+				if evalSourceMatches(cited, expected) {
+					matched = true
+					break
+				}
+			}
+			correct = correct && matched
+		}
+		result.CitationCorrect = &correct
+	}
+	if result.ExpectedAnswer != "" {
+		similarity := answerSimilarity(answer, result.ExpectedAnswer)
+		result.AnswerSimilarity = &similarity
+	}
+}
+
+// scoreAIEvalRun sets the scores of a run from the results of its cases that were answered
+func scoreAIEvalRun(run *models.AIEvalRun, results []*models.AIEvalResult) {
+	var hits, retrievals, similarities, correct, citations int
+	var similarity float64
+	for _, result := range results {
+		if result.Error != nil {
+			continue
+		}
+		if result.RetrievalHit != nil {
+			retrievals++
+			if *result.RetrievalHit {
+				hits++
+			}
+		}
+		if result.AnswerSimilarity != nil {
+			similarities++
+			similarity += *result.AnswerSimilarity
+		}
+		if result.CitationCorrect != nil {
+			citations++
+			if *result.CitationCorrect {
+				correct++
+			}
+		}
+	}
+	run.RetrievalHitRate = evalRatio(float64(hits), retrievals)
+	run.AnswerSimilarity = evalRatio(similarity, similarities)
+	run.CitationAccuracy = evalRatio(float64(correct), citations)
+}
+
+func evalRatio(total float64, count int) *float64 {
+	if count == 0 {
+		return nil
+	}
+	ratio := roundEvalScore(total / float64(count))
+	return &ratio
+}
+
+func roundEvalScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
+
+// normalizeEvalSource reduces a knowledge source to the form sources are compared in: lower case, without
+// the URL scheme, a leading "www." or a trailing slash
+func normalizeEvalSource(source string) string {
+	source = strings.ToLower(strings.TrimSpace(source))
+	for _, prefix := range []string{"https://", "http://", "www."} {
+		source = strings.TrimPrefix(source, prefix)
+	}
+	return strings.TrimRight(source, "/")
+}
+
+// evalSourceMatches reports whether a source is an expected source or a page below it
+func evalSourceMatches(source, expected string) bool {
+	source, expected = normalizeEvalSource(source), normalizeEvalSource(expected)
+	if expected == "" || !strings.HasPrefix(source, expected) {
+		return false
+	}
+	if len(source) == len(expected) {
+		return true
+	}
+	switch source[len(expected)] {
+	case '/', '#', '?':
+		return true
+	}
+	return false
+}
+
+func evalSourceIn(expected string, sources []string) bool {
+	for _, source := range sources {
+		if evalSourceMatches(source, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceCitationPattern matches the "Source N" labels knowledge is given to the model with
+var sourceCitationPattern = regexp.MustCompile(`(?i)\bsource\s*#?(\d+)`)
+
+// citedEvalSources returns the knowledge sources an answer cites, by their "Source N" label or by naming
+// the source itself. A label no source was given under is returned as it is written, so it never counts
+// as a correct citation.
+func citedEvalSources(answer string, retrieved []string) pq.StringArray {
+	cited := pq.StringArray{}
+	seen := map[string]bool{}
+	add := func(source string) {
+		if !seen[source] {
+			seen[source] = true
+			cited = append(cited, source)
+		}
+	}
+
+	for _, match := range sourceCitationPattern.FindAllStringSubmatch(answer, -1) {
+		n, err := strconv.Atoi(match[1])
+		if err != nil || n < 1 || n > len(retrieved) {
+			add(match[0])
+			continue
+		}
+		add(retrieved[n-1])
+	}
+	lower := strings.ToLower(answer)
+	for _, source := range retrieved {
+		if normalized := normalizeEvalSource(source); normalized != "" && strings.Contains(lower, normalized) {
+			add(source)
+		}
+	}
+	return cited
+}
+
+// answerSimilarity is the word overlap (F1) of an answer with the expected answer, from 0 to 1
+func answerSimilarity(answer, expected string) float64 {
+	answerWords, expectedWords := evalWords(answer), evalWords(expected)
+	if len(answerWords) == 0 || len(expectedWords) == 0 {
+		return 0
+	}
+
+	counts := map[string]int{}
+	for _, word := range expectedWords {
+		counts[word]++
+	}
+	overlap := 0
+	for _, word := range answerWords {
+		if counts[word] > 0 {
+			counts[word]--
+			overlap++
+		}
+	}
+	if overlap == 0 {
+		return 0
+	}
+	precision := float64(overlap) / float64(len(answerWords))
+	recall := float64(overlap) / float64(len(expectedWords))
+	return roundEvalScore(2 * precision * recall / (precision + recall))
+}
+
+func evalWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// GetRun returns a run of the evaluation suite of a project with the results of its cases
+func (s *AIEvaluationService) GetRun(ctx context.Context, tenantID, projectID, runID uuid.UUID) (*models.AIEvalRunDetail, error) {
+	run, err := s.store.GetRun(ctx, tenantID, projectID, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get evaluation run: %w", err)
+	}
+	if run == nil {
+		return nil, ErrAIEvalRunNotFound
+	}
+	results, err := s.store.ListResults(ctx, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list evaluation results: %w", err)
+	}
+	return &models.AIEvalRunDetail{AIEvalRun: *run, Results: results}, nil
+}
+
+// ListRuns returns the latest runs of the evaluation suite of a project, newest first
+func (s *AIEvaluationService) ListRuns(ctx context.Context, tenantID, projectID uuid.UUID, limit int) ([]*models.AIEvalRun, error) {
+	if limit <= 0 {
+		limit = defaultAIEvalRunsLimit
+	}
+	if limit > maxAIEvalRunsLimit {
+		limit = maxAIEvalRunsLimit
+	}
+	return s.store.ListRuns(ctx, tenantID, projectID, limit)
+}
+
+// CompareRuns diffs a run against a base run: how its scores moved and which cases got better or worse
+func (s *AIEvaluationService) CompareRuns(ctx context.Context, tenantID, projectID, baseRunID, runID uuid.UUID) (*models.AIEvalComparison, error) {
+	base, err := s.GetRun(ctx, tenantID, projectID, baseRunID)
+	if err != nil {
+		return nil, err
+	}
+	run, err := s.GetRun(ctx, tenantID, projectID, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &models.AIEvalComparison{
+		Base: base.AIEvalRun,
+		Run:  run.AIEvalRun,
+		Delta: models.AIEvalScoreDelta{
+			RetrievalHitRate: evalScoreDelta(base.RetrievalHitRate, run.RetrievalHitRate),
+			AnswerSimilarity: evalScoreDelta(base.AnswerSimilarity, run.AnswerSimilarity),
+			CitationAccuracy: evalScoreDelta(base.CitationAccuracy, run.CitationAccuracy),
+		},
+		Cases: []models.AIEvalCaseDiff{},
+	}
+
+	baseResults := map[uuid.UUID]*models.AIEvalResult{}
+	for _, result := range base.Results {
+		baseResults[result.CaseID] = result
+	}
+	inRun := map[uuid.UUID]bool{}
+	for _, result := range run.Results {
+		inRun[result.CaseID] = true
+		diff := models.AIEvalCaseDiff{CaseID: result.CaseID, Question: result.Question, Base: baseResults[result.CaseID], Run: result}
+		diff.Change = aiEvalChange(diff.Base, result)
+		comparison.Cases = append(comparison.Cases, diff)
+	}
+	for _, result := range base.Results {
+		if !inRun[result.CaseID] {
+			comparison.Cases = append(comparison.Cases, models.AIEvalCaseDiff{CaseID: result.CaseID, Question: result.Question, Base: result, Change: "removed"})
+		}
+	}
+	return comparison, nil
+}
+
+func evalScoreDelta(base, run *float64) *float64 {
+	if base == nil || run == nil {
+		return nil
+	}
+	delta := roundEvalScore(*run - *base)
+	return &delta
+}
+
+// aiEvalChange classifies how the result of a case changed from a base run. A case regressed when any
+// of its scores dropped or it failed where it had not, and improved when scores only went up.
+func aiEvalChange(base, run *models.AIEvalResult) string {
+	if base == nil {
+		return "added"
+	}
+
+	up, down := false, false
+	compare := func(before, after float64) {
+		if after > before {
+			up = true
+		} else if after < before {
+			down = true
+		}
+	}
+	compare(evalOutcome(base.Error == nil), evalOutcome(run.Error == nil))
+	if base.RetrievalHit != nil && run.RetrievalHit != nil {
+		compare(evalOutcome(*base.RetrievalHit), evalOutcome(*run.RetrievalHit))
+	}
+	if base.AnswerSimilarity != nil && run.AnswerSimilarity != nil {
+		compare(*base.AnswerSimilarity, *run.AnswerSimilarity)
+	}
+	if base.CitationCorrect != nil && run.CitationCorrect != nil {
+		compare(evalOutcome(*base.CitationCorrect), evalOutcome(*run.CitationCorrect))
+	}
+
+	switch {
+	case down:
+		return "regressed"
+	case up:
+		return "improved"
+	case base.Answer != run.Answer:
+		return "changed"
+	default:
+		return "unchanged"
+	}
+}
+
+func evalOutcome(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeAIEvalStore struct {
+	cases      []*models.AIEvalCase
+	thumbsDown []*models.AIThumbsDownReply
+	runs       map[uuid.UUID]*models.AIEvalRun
+	results    map[uuid.UUID][]*models.AIEvalResult
+}
+
+func newFakeAIEvalStore() *fakeAIEvalStore {
+	return &fakeAIEvalStore{runs: map[uuid.UUID]*models.AIEvalRun{}, results: map[uuid.UUID][]*models.AIEvalResult{}}
+}
+
+func (f *fakeAIEvalStore) CreateCase(ctx context.Context, evalCase *models.AIEvalCase) (bool, error) {
+	for _, existing := range f.cases {
+		if evalCase.OriginMessageID != nil && existing.OriginMessageID != nil && *existing.OriginMessageID == *evalCase.OriginMessageID {
+			return false, nil
+		}
+	}
+	evalCase.ID = uuid.New()
+	evalCase.CreatedAt = time.Now()
+	f.cases = append(f.cases, evalCase)
+	return true, nil
+}
+
+func (f *fakeAIEvalStore) GetCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) (*models.AIEvalCase, error) {
+	for _, evalCase := range f.cases {
+		if evalCase.ID == caseID && evalCase.ProjectID == projectID {
+			return evalCase, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAIEvalStore) ListCases(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIEvalCase, error) {
+	return f.cases, nil
+}
+
+func (f *fakeAIEvalStore) UpdateCase(ctx context.Context, evalCase *models.AIEvalCase) error {
+	return nil
+}
+
+func (f *fakeAIEvalStore) DeleteCase(ctx context.Context, tenantID, projectID, caseID uuid.UUID) error {
+	for i, evalCase := range f.cases {
+		if evalCase.ID == caseID {
+			f.cases = append(f.cases[:i], f.cases[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeAIEvalStore) ThumbsDownReplies(ctx context.Context, tenantID, projectID uuid.UUID, since time.Time, limit int) ([]*models.AIThumbsDownReply, error) {
+	return f.thumbsDown, nil
+}
+
+func (f *fakeAIEvalStore) CreateRun(ctx context.Context, run *models.AIEvalRun) error {
+	run.ID = uuid.New()
+	run.CreatedAt = time.Now()
+	stored := *run
+	f.runs[run.ID] = &stored
+	return nil
+}
+
+func (f *fakeAIEvalStore) FinishRun(ctx context.Context, run *models.AIEvalRun) error {
+	stored := *run
+	f.runs[run.ID] = &stored
+	return nil
+}
+
+func (f *fakeAIEvalStore) GetRun(ctx context.Context, tenantID, projectID, runID uuid.UUID) (*models.AIEvalRun, error) {
+	return f.runs[runID], nil
+}
+
+func (f *fakeAIEvalStore) ListRuns(ctx context.Context, tenantID, projectID uuid.UUID, limit int) ([]*models.AIEvalRun, error) {
+	runs := []*models.AIEvalRun{}
+	for _, run := range f.runs {
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+func (f *fakeAIEvalStore) CreateResult(ctx context.Context, result *models.AIEvalResult) error {
+	result.ID = uuid.New()
+	f.results[result.RunID] = append(f.results[result.RunID], result)
+	return nil
+}
+
+func (f *fakeAIEvalStore) ListResults(ctx context.Context, runID uuid.UUID) ([]*models.AIEvalResult, error) {
+	return f.results[runID], nil
+}
+
+func TestScoreAIEvalResult(t *testing.T) {
+	result := &models.AIEvalResult{
+		ExpectedAnswer:   "Orders ship within 2 business days.",
+		ExpectedSources:  pq.StringArray{"https://www.acme.example/help/shipping/"},
+		RetrievedSources: pq.StringArray{"https://acme.example/help/shipping/rates", "https://acme.example/returns"},
+	}
+	answer := "We ship orders within 2 business days (Source 1)."
+	result.CitedSources = citedEvalSources(answer, result.RetrievedSources)
+	scoreAIEvalResult(result, answer)
+
+	require.Equal(t, pq.StringArray{"https://acme.example/help/shipping/rates"}, result.CitedSources)
+	require.True(t, *result.RetrievalHit)
+	require.True(t, *result.CitationCorrect)
+	require.Equal(t, 0.8, *result.AnswerSimilarity)
+
+	// Citing a source that was not expected, or one that was never given, is wrong
+	require.Equal(t, pq.StringArray{"Source 7", "https://acme.example/returns"},
+		citedEvalSources("See acme.example/returns and Source 7", result.RetrievedSources))
+	result.CitedSources = citedEvalSources("See Source 2", result.RetrievedSources)
+	scoreAIEvalResult(result, "See Source 2")
+	require.False(t, *result.CitationCorrect)
+	require.Less(t, *result.AnswerSimilarity, 0.3)
+
+	// Cases without expectations are not scored on them
+	unscored := &models.AIEvalResult{RetrievedSources: pq.StringArray{"faq.pdf"}}
+	scoreAIEvalResult(unscored, "Anything")
+	require.Nil(t, unscored.RetrievalHit)
+	require.Nil(t, unscored.CitationCorrect)
+	require.Nil(t, unscored.AnswerSimilarity)
+
+	require.False(t, evalSourceMatches("https://acme.example/help/shipping-policy", "acme.example/help/shipping"))
+	require.True(t, evalSourceMatches("FAQ.pdf", "faq.pdf"))
+}
+
+func TestImportCSVAddsCasesAndRejectsInvalidFiles(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	store := newFakeAIEvalStore()
+	svc := NewAIEvaluationService(store, nil)
+
+	csvFile := "\ufeffQuestion,Expected_Sources,expected_answer\n" +
+		"How long does shipping take?,https://acme.example/shipping | faq.pdf,Two business days.\n" +
+		",,\n" +
+		"\"Can I return, exchange or refund?\",,\n"
+	result, err := svc.ImportCSV(ctx, tenantID, projectID, strings.NewReader(csvFile))
+	require.NoError(t, err)
+	require.Equal(t, &models.AIEvalImportResult{Imported: 2, Skipped: 1}, result)
+	require.Len(t, store.cases, 2)
+	require.Equal(t, models.AIEvalOriginCSV, store.cases[0].Origin)
+	require.Equal(t, pq.StringArray{"https://acme.example/shipping", "faq.pdf"}, store.cases[0].ExpectedSources)
+	require.Equal(t, "Two business days.", store.cases[0].ExpectedAnswer)
+	require.Equal(t, "Can I return, exchange or refund?", store.cases[1].Question)
+	require.Empty(t, store.cases[1].ExpectedSources)
+
+	_, err = svc.ImportCSV(ctx, tenantID, projectID, strings.NewReader("prompt,answer\nHi,Hello\n"))
+	require.ErrorIs(t, err, ErrInvalidAIEvalCSV)
+
+	_, err = svc.ImportCSV(ctx, tenantID, projectID, strings.NewReader("question\nok\n"+strings.Repeat("x", maxAIEvalQuestionLen+1)+"\n"))
+	require.ErrorIs(t, err, ErrInvalidAIEvalCSV)
+	require.Contains(t, err.Error(), "line 3")
+	require.Len(t, store.cases, 2)
+}
+
+func TestImportThumbsDownSkipsRepliesAlreadyImported(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	store := newFakeAIEvalStore()
+	store.thumbsDown = []*models.AIThumbsDownReply{
+		{FeedbackMessageID: uuid.New(), SessionID: uuid.New(), Question: " Do you ship to Canada? ", Answer: "We only ship to the US."},
+		{FeedbackMessageID: uuid.New(), SessionID: uuid.New(), Question: "   ", Answer: "Hello!"},
+	}
+	svc := NewAIEvaluationService(store, nil)
+
+	result, err := svc.ImportThumbsDown(ctx, tenantID, projectID, nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, &models.AIEvalImportResult{Imported: 1, Skipped: 1}, result)
+	evalCase := store.cases[0]
+	require.Equal(t, "Do you ship to Canada?", evalCase.Question)
+	require.Equal(t, models.AIEvalOriginThumbsDown, evalCase.Origin)
+	require.Equal(t, "We only ship to the US.", *evalCase.RejectedAnswer)
+	require.Equal(t, store.thumbsDown[0].FeedbackMessageID, *evalCase.OriginMessageID)
+	require.Empty(t, evalCase.ExpectedAnswer)
+
+	result, err = svc.ImportThumbsDown(ctx, tenantID, projectID, nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, &models.AIEvalImportResult{Imported: 0, Skipped: 2}, result)
+	require.Len(t, store.cases, 1)
+}
+
+func newTestEvaluationService(t *testing.T, handler http.Handler) (*AIEvaluationService, *fakeAIEvalStore) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cfg := &config.AIConfig{Enabled: true, APIKey: "test-key", Provider: "openai", BaseURL: server.URL, Model: "gpt-4o"}
+	store := newFakeAIEvalStore()
+	return NewAIEvaluationService(store, &AIService{config: cfg, llm: NewLLMRouter(cfg, server.Client())}), store
+}
+
+func TestRunSuiteScoresAndComparesRuns(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+
+	server, requests := newAssistLLM(t, "Shipping takes two business days.")
+	cfg := &config.AIConfig{Enabled: true, APIKey: "test-key", Provider: "openai", BaseURL: server.URL, Model: "gpt-4o"}
+	store := newFakeAIEvalStore()
+	svc := NewAIEvaluationService(store, &AIService{config: cfg, llm: NewLLMRouter(cfg, server.Client())})
+
+	_, err := svc.RunSuite(ctx, tenantID, projectID, "empty")
+	require.ErrorIs(t, err, ErrAIEvalNoCases)
+
+	shipping, err := svc.CreateCase(ctx, tenantID, projectID, &models.AIEvalCaseRequest{
+		Question:        "How long does shipping take?",
+		ExpectedAnswer:  "Shipping takes two business days.",
+		ExpectedSources: []string{"https://acme.example/shipping"},
+	})
+	require.NoError(t, err)
+	_, err = svc.CreateCase(ctx, tenantID, projectID, &models.AIEvalCaseRequest{Question: "I want to speak to a human agent"})
+	require.NoError(t, err)
+
+	base, err := svc.RunSuite(ctx, tenantID, projectID, "baseline")
+	require.NoError(t, err)
+	require.Len(t, *requests, 1) // the handoff is not sent to the model
+	require.Equal(t, models.AIEvalRunCompleted, base.Status)
+	require.Equal(t, "gpt-4o", base.Model)
+	require.Equal(t, int64(80), base.PromptTokens)
+	require.Len(t, base.Results, 2)
+	require.Equal(t, "Shipping takes two business days.", base.Results[0].Answer)
+	require.Equal(t, 1.0, *base.Results[0].AnswerSimilarity)
+	require.False(t, *base.Results[0].RetrievalHit) // no knowledge was configured
+	require.True(t, base.Results[1].Handoff)
+	require.Equal(t, 0.0, *base.RetrievalHitRate)
+	require.Equal(t, 1.0, *base.AnswerSimilarity)
+	require.Equal(t, 0.0, *base.CitationAccuracy)
+	require.Equal(t, models.AIEvalRunCompleted, store.runs[base.ID].Status)
+
+	// The answer gets worse and a case is added
+	*requests = nil
+	_, err = svc.CreateCase(ctx, tenantID, projectID, &models.AIEvalCaseRequest{Question: "Do you ship abroad?"})
+	require.NoError(t, err)
+	worse, _ := newAssistLLM(t, "It depends.")
+	cfg.BaseURL = worse.URL
+	svc.ai.llm = NewLLMRouter(cfg, worse.Client())
+	run, err := svc.RunSuite(ctx, tenantID, projectID, "new prompt")
+	require.NoError(t, err)
+
+	comparison, err := svc.CompareRuns(ctx, tenantID, projectID, base.ID, run.ID)
+	require.NoError(t, err)
+	require.Equal(t, -1.0, *comparison.Delta.AnswerSimilarity)
+	require.Equal(t, 0.0, *comparison.Delta.RetrievalHitRate)
+	require.Len(t, comparison.Cases, 3)
+	require.Equal(t, shipping.ID, comparison.Cases[0].CaseID)
+	require.Equal(t, "regressed", comparison.Cases[0].Change)
+	require.Equal(t, "unchanged", comparison.Cases[1].Change)
+	require.Equal(t, "added", comparison.Cases[2].Change)
+
+	_, err = svc.CompareRuns(ctx, tenantID, projectID, uuid.New(), run.ID)
+	require.ErrorIs(t, err, ErrAIEvalRunNotFound)
+}
+
+func TestRunSuiteFailsWhenNoCaseIsAnswered(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	svc, store := newTestEvaluationService(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"invalid api key"}}`, http.StatusUnauthorized)
+	}))
+	_, err := svc.CreateCase(ctx, tenantID, projectID, &models.AIEvalCaseRequest{Question: "Where is my order?", ExpectedAnswer: "On its way."})
+	require.NoError(t, err)
+
+	run, err := svc.RunSuite(ctx, tenantID, projectID, "")
+	require.NoError(t, err)
+	require.Equal(t, models.AIEvalRunFailed, run.Status)
+	require.Equal(t, 1, run.FailedCount)
+	require.NotNil(t, run.Error)
+	require.Nil(t, run.AnswerSimilarity)
+	require.NotNil(t, store.results[run.ID][0].Error)
+}
+
+func TestEvalStubLLMAnswersFromTheFirstSource(t *testing.T) {
+	svc, store := newTestEvaluationService(t, NewEvalStubLLM())
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	_, err := svc.CreateCase(ctx, tenantID, projectID, &models.AIEvalCaseRequest{Question: "Do you ship to Canada?"})
+	require.NoError(t, err)
+
+	run, err := svc.RunSuite(ctx, tenantID, projectID, "stub")
+	require.NoError(t, err)
+	require.Equal(t, evalStubNoAnswer, run.Results[0].Answer)
+	require.Zero(t, run.PromptTokens)
+	require.Len(t, store.results[run.ID], 1)
+
+	knowledge := (&KnowledgeService{}).FormatContextForAI([]models.KnowledgeSearchResult{
+		{Source: "https://acme.example/shipping", Content: "We ship to the US and Canada. Rates vary.", Score: 0.9},
+	})
+	body, _ := json.Marshal(ChatCompletionRequest{Model: "stub", Messages: []ChatCompletionMessage{
+		{Role: "system", Content: "You are helpful.\n\n" + knowledge},
+		{Role: "user", Content: "Do you ship to Canada?"},
+	}})
+	recorder := httptest.NewRecorder()
+	NewEvalStubLLM().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)))
+	var resp ChatCompletionResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+	require.Equal(t, "We ship to the US and Canada. (Source 1)", resp.Choices[0].Message.Content)
+}
+
+func TestEvalStubEmbeddingServiceEmbedsLocally(t *testing.T) {
+	ctx := context.Background()
+	embeddings := NewEmbeddingService(&config.KnowledgeConfig{Enabled: true, EmbeddingService: EvalStubEmbeddingService})
+	require.True(t, embeddings.IsEnabled())
+
+	query, err := embeddings.QueryEmbedding(ctx, "Do you ship to Canada?")
+	require.NoError(t, err)
+	again, err := embeddings.QueryEmbedding(ctx, "do you SHIP to canada")
+	require.NoError(t, err)
+	require.Len(t, query.Slice(), embeddings.GetDimension())
+	require.Equal(t, query.Slice(), again.Slice())
+
+	chunks, err := embeddings.GenerateEmbeddings(ctx, []string{"We ship to the US and Canada.", "Refunds take five days."})
+	require.NoError(t, err)
+	similarity := func(a, b []float32) float32 {
+		var dot float32
+		for i := range a {
+			dot += a[i] * b[i]
+		}
+		return dot
+	}
+	require.Greater(t, similarity(query.Slice(), chunks[0].Slice()), similarity(query.Slice(), chunks[1].Slice()))
+}
@@ -0,0 +1,562 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+const (
+	aiGuardrailSettingsKey      = "ai_guardrail_settings"
+	defaultAIGuardrailEventList = 50
+	maxAIGuardrailEventList     = 200
+)
+
+// piiPlaceholders replace the personal data the guardrails mask
+var piiPlaceholders = map[string]string{
+	models.PIITypeEmail:      "[EMAIL]",
+	models.PIITypePhone:      "[PHONE]",
+	models.PIITypeCard:       "[CARD]",
+	models.PIITypeIBAN:       "[IBAN]",
+	models.PIITypeNationalID: "[NATIONAL_ID]",
+}
+
+// piiPattern finds one kind of personal data. check, when set, confirms a match and returns how much of it
+// is the data; the rest is kept.
+type piiPattern struct {
+	piiType string
+	pattern *regexp.Regexp
+	check   func(match string) (int, bool)
+}
+
+var piiPatterns = []piiPattern{
+	{models.PIITypeEmail, regexp.MustCompile(`(?i)\b[a-z0-9._%+\-]+@[a-z0-9\-]+(?:\.[a-z0-9\-]+)*\.[a-z]{2,}\b`), nil},
+	{models.PIITypeIBAN, regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`), checkIBAN},
+	{models.PIITypeCard, regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`), checkCardNumber},
+	// US social security numbers
+	{models.PIITypeNationalID, regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), nil},
+	// UK national insurance numbers
+	{models.PIITypeNationalID, regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`), nil},
+	// Spanish DNI and NIE
+	{models.PIITypeNationalID, regexp.MustCompile(`\b[XYZ]?\d{7,8}-?[A-Z]\b`), checkSpanishID},
+	// Italian codice fiscale
+	{models.PIITypeNationalID, regexp.MustCompile(`\b[A-Z]{6}\d{2}[A-EHLMPR-T]\d{2}[A-Z]\d{3}[A-Z]\b`), nil},
+	// French social security numbers
+	{models.PIITypeNationalID, regexp.MustCompile(`\b[12] ?\d{2} ?(?:0[1-9]|1[0-2]) ?\d{2} ?\d{3} ?\d{3} ?\d{2}\b`), checkFrenchNIR},
+	{models.PIITypePhone, regexp.MustCompile(`(?:\+|\b00)?\(?\d[\d ().\-]{6,}\d\b`), checkPhoneNumber},
+}
+
+var isoDatePattern = regexp.MustCompile(`^\d{4}[\-/.]\d{2}[\-/.]\d{2}$`)
+
+// promptInjectionPatterns are phrasings that try to override an assistant's instructions, by name
+var promptInjectionPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"ignore_instructions", regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override)\b[^.\n]{0,40}\b(?:previous|prior|above|earlier|all|any|your|the)\b[^.\n]{0,20}\b(?:instructions?|prompts?|rules|directions|guidelines)\b`)},
+	{"role_override", regexp.MustCompile(`(?i)\byou are now\b|\bfrom now on,? you (?:are|will|must)\b|\bact as (?:an? )?(?:unrestricted|unfiltered|jailbroken)\b`)},
+	{"prompt_leak", regexp.MustCompile(`(?i)\b(?:reveal|show|print|repeat|output|tell me)\b[^.\n]{0,30}\b(?:system prompt|hidden instructions|initial instructions|your instructions)\b`)},
+	{"jailbreak", regexp.MustCompile(`(?i)\b(?:do anything now|developer mode|jailbreak(?:ed)?)\b`)},
+	{"new_instructions", regexp.MustCompile(`(?i)\b(?:new|updated|real) (?:system )?instructions?\s*:`)},
+	{"chat_markup", regexp.MustCompile(`(?i)<\|(?:im_start|im_end|system|user|assistant|endoftext)\|>|\[/?INST\]|<</?SYS>>|(?m)^\s*#{2,}\s*(?:system|instructions?)\b`)},
+}
+
+// redactPII masks the personal data of the given kinds, all kinds when none are given, and counts what it
+// masked by kind
+func redactPII(text string, types []string) (string, map[string]int) {
+	found := map[string]int{}
+	if strings.TrimSpace(text) == "" {
+		return text, found
+	}
+
+	wanted := map[string]bool{}
+	for _, piiType := range types {
+		wanted[piiType] = true
+	}
+	for _, p := range piiPatterns {
+		if len(wanted) > 0 && !wanted[p.piiType] {
+			continue
+		}
+		text = p.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			end := len(match)
+			if p.check != nil {
+				var ok bool
+				if end, ok = p.check(match); !ok {
+					return match
+				}
+			}
+			found[p.piiType]++
+			return piiPlaceholders[p.piiType] + match[end:]
+		})
+	}
+	return text, found
+}
+
+// detectPromptInjection returns the names of the injection patterns a text matches
+func detectPromptInjection(text string) []string {
+	var matched []string
+	for _, p := range promptInjectionPatterns {
+		if p.pattern.MatchString(text) {
+			matched = append(matched, p.name)
+		}
+	}
+	return matched
+}
+
+func digitsOf(text string) string {
+	var digits strings.Builder
+	for _, r := range text {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	return digits.String()
+}
+
+// checkCardNumber accepts 13 to 19 digits that pass the Luhn check
+func checkCardNumber(match string) (int, bool) {
+	digits := digitsOf(match)
+	if len(digits) < 13 || len(digits) > 19 {
+		return 0, false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return len(match), sum%10 == 0
+}
+
+// checkIBAN accepts the longest start of a match that passes the IBAN mod-97 check, so words in capitals
+// after the IBAN are kept
+func checkIBAN(match string) (int, bool) {
+	var compact []rune
+	var ends []int
+	for i, r := range match {
+		if r != ' ' {
+			compact = append(compact, r)
+			ends = append(ends, i+1)
+		}
+	}
+	for n := len(compact); n >= 15; n-- {
+		if validIBAN(string(compact[:n])) {
+			return ends[n-1], true
+		}
+	}
+	return 0, false
+}
+
+func validIBAN(iban string) bool {
+	rearranged := iban[4:] + iban[:4]
+	var numeric strings.Builder
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			numeric.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			numeric.WriteString(fmt.Sprint(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// checkSpanishID accepts DNI and NIE numbers whose control letter matches
+func checkSpanishID(match string) (int, bool) {
+	id := strings.ReplaceAll(match, "-", "")
+	number := id[:len(id)-1]
+	switch number[0] {
+	case 'X':
+		number = "0" + number[1:]
+	case 'Y':
+		number = "1" + number[1:]
+	case 'Z':
+		number = "2" + number[1:]
+	}
+	var n int
+	if _, err := fmt.Sscan(number, &n); err != nil {
+		return 0, false
+	}
+	return len(match), "TRWAGMYFPDXBNJZSQVHLCKE"[n%23] == id[len(id)-1]
+}
+
+// checkFrenchNIR accepts French social security numbers whose key matches
+func checkFrenchNIR(match string) (int, bool) {
+	digits := digitsOf(match)
+	var number, key int64
+	if _, err := fmt.Sscan(digits[:13], &number); err != nil {
+		return 0, false
+	}
+	if _, err := fmt.Sscan(digits[13:], &key); err != nil {
+		return 0, false
+	}
+	return len(match), 97-number%97 == key
+}
+
+// checkPhoneNumber accepts 8 to 15 digits written like a phone number: in international format, with an
+// area code in brackets, split into groups, or starting with a trunk 0. Dates and plain runs of digits,
+// such as order numbers, are kept.
+func checkPhoneNumber(match string) (int, bool) {
+	digits := digitsOf(match)
+	if len(digits) < 8 || len(digits) > 15 || isoDatePattern.MatchString(match) {
+		return 0, false
+	}
+	if strings.HasPrefix(match, "+") || strings.HasPrefix(match, "00") || strings.HasPrefix(match, "(") {
+		return len(match), true
+	}
+	groups := strings.FieldsFunc(match, func(r rune) bool { return !unicode.IsDigit(r) })
+	if len(groups) >= 2 {
+		return len(match), true
+	}
+	return len(match), digits[0] == '0' && len(digits) >= 10
+}
+
+// ErrInvalidPIIType is returned for guardrail settings naming a kind of personal data that is not known
+var ErrInvalidPIIType = errors.New("unknown personal data type")
+
+// projectSettingsStore reads and writes a project's settings by key; implemented by SettingsRepository
+type projectSettingsStore interface {
+	GetSetting(ctx context.Context, tenantID, projectID uuid.UUID, settingKey string) (map[string]interface{}, int, error)
+	UpdateSetting(ctx context.Context, tenantID, projectID uuid.UUID, settingKey string, settingValue map[string]interface{}) error
+}
+
+type aiGuardrailEventStore interface {
+	Create(ctx context.Context, event *models.AIGuardrailEvent) error
+	List(ctx context.Context, tenantID, projectID uuid.UUID, filter models.AIGuardrailEventFilter) ([]*models.AIGuardrailEvent, error)
+}
+
+// MessageRedactor masks personal data in customer messages before they are stored, when the project
+// asks for it
+type MessageRedactor interface {
+	RedactStoredMessage(ctx context.Context, tenantID, projectID uuid.UUID, sessionID, ticketID *uuid.UUID, body string) string
+}
+
+// AIGuardrailService applies a project's guardrail settings to what goes to and comes from AI providers,
+// and records every intervention
+type AIGuardrailService struct {
+	settings projectSettingsStore
+	events   aiGuardrailEventStore
+}
+
+// NewAIGuardrailService creates a new AI guardrail service
+func NewAIGuardrailService(settings projectSettingsStore, events aiGuardrailEventStore) *AIGuardrailService {
+	return &AIGuardrailService{settings: settings, events: events}
+}
+
+// Settings returns the guardrail settings of a project, the defaults for what it has not set
+func (s *AIGuardrailService) Settings(ctx context.Context, tenantID, projectID uuid.UUID) *models.AIGuardrailSettings {
+	settings := models.DefaultAIGuardrailSettings()
+	if s.settings == nil {
+		return settings
+	}
+	stored, _, err := s.settings.GetSetting(ctx, tenantID, projectID, aiGuardrailSettingsKey)
+	if err != nil {
+		return settings
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return settings
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		fmt.Printf("Invalid AI guardrail settings for project %s, using defaults: %v\n", projectID, err)
+		return models.DefaultAIGuardrailSettings()
+	}
+	return settings
+}
+
+// UpdateSettings replaces the guardrail settings of a project
+func (s *AIGuardrailService) UpdateSettings(ctx context.Context, tenantID, projectID uuid.UUID, settings *models.AIGuardrailSettings) (*models.AIGuardrailSettings, error) {
+	known := map[string]bool{}
+	for _, piiType := range models.AllPIITypes {
+		known[piiType] = true
+	}
+	piiTypes := []string{}
+	for _, piiType := range settings.PIITypes {
+		if !known[piiType] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPIIType, piiType)
+		}
+		piiTypes = append(piiTypes, piiType)
+	}
+	blocklist := []string{}
+	for _, phrase := range settings.OutputBlocklist {
+		if phrase = strings.TrimSpace(phrase); phrase != "" {
+			blocklist = append(blocklist, phrase)
+		}
+	}
+
+	updated := *settings
+	updated.PIITypes = piiTypes
+	updated.OutputBlocklist = blocklist
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if err := s.settings.UpdateSetting(ctx, tenantID, projectID, aiGuardrailSettingsKey, value); err != nil {
+		return nil, fmt.Errorf("failed to update AI guardrail settings: %w", err)
+	}
+	return &updated, nil
+}
+
+// ListEvents lists a project's guardrail events, newest first
+func (s *AIGuardrailService) ListEvents(ctx context.Context, tenantID, projectID uuid.UUID, filter models.AIGuardrailEventFilter) ([]*models.AIGuardrailEvent, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAIGuardrailEventList
+	}
+	if filter.Limit > maxAIGuardrailEventList {
+		filter.Limit = maxAIGuardrailEventList
+	}
+	return s.events.List(ctx, tenantID, projectID, filter)
+}
+
+// RedactStoredMessage masks personal data in a customer message when the project redacts stored messages
+func (s *AIGuardrailService) RedactStoredMessage(ctx context.Context, tenantID, projectID uuid.UUID, sessionID, ticketID *uuid.UUID, body string) string {
+	settings := s.Settings(ctx, tenantID, projectID)
+	if !settings.RedactStoredMessages {
+		return body
+	}
+	redacted, found := redactPII(body, settings.PIITypes)
+	if len(found) > 0 {
+		s.record(ctx, &aiGuardrailScope{tenantID: tenantID, projectID: projectID, sessionID: sessionID, ticketID: ticketID},
+			models.AIGuardrailStageStorage, models.AIGuardrailKindPII, models.AIGuardrailActionMasked, piiDetails(found))
+	}
+	return redacted
+}
+
+// record logs and stores an intervention. Failures to store are logged; the intervention has been made.
+func (s *AIGuardrailService) record(ctx context.Context, scope *aiGuardrailScope, stage, kind, action string, details models.JSONMap) {
+	fmt.Printf("AI guardrail %s %s at %s for project %s: %v\n", action, kind, stage, scope.projectID, details)
+	if s.events == nil || scope.tenantID == uuid.Nil {
+		return
+	}
+	event := &models.AIGuardrailEvent{
+		TenantID:  scope.tenantID,
+		ProjectID: scope.projectID,
+		SessionID: scope.sessionID,
+		TicketID:  scope.ticketID,
+		Stage:     stage,
+		Kind:      kind,
+		Action:    action,
+		Details:   details,
+	}
+	if err := s.events.Create(ctx, event); err != nil {
+		fmt.Printf("Failed to store AI guardrail event for project %s: %v\n", scope.projectID, err)
+	}
+}
+
+// piiDetails describes masked personal data by kind and count
+func piiDetails(found map[string]int) models.JSONMap {
+	total := 0
+	counts := map[string]interface{}{}
+	for piiType, count := range found {
+		counts[piiType] = count
+		total += count
+	}
+	return models.JSONMap{"counts": counts, "total": total}
+}
+
+// aiGuardrailScope is whose AI work a context is for, and the guardrail settings that apply to it
+type aiGuardrailScope struct {
+	tenantID  uuid.UUID
+	projectID uuid.UUID
+	sessionID *uuid.UUID
+	ticketID  *uuid.UUID
+	settings  *models.AIGuardrailSettings
+}
+
+type aiGuardrailScopeKey struct{}
+
+// guardedContext scopes the AI work done with a context to a project, so every prompt sent with it is
+// guarded by the project's settings and interventions are recorded against it
+func (s *AIService) guardedContext(ctx context.Context, tenantID, projectID uuid.UUID, sessionID, ticketID *uuid.UUID) context.Context {
+	if s.guardrails == nil {
+		return ctx
+	}
+	if scope := guardrailScopeFrom(ctx); scope != nil && scope.tenantID == tenantID && scope.projectID == projectID {
+		return ctx
+	}
+	return context.WithValue(ctx, aiGuardrailScopeKey{}, &aiGuardrailScope{
+		tenantID:  tenantID,
+		projectID: projectID,
+		sessionID: sessionID,
+		ticketID:  ticketID,
+		settings:  s.guardrails.Settings(ctx, tenantID, projectID),
+	})
+}
+
+func guardrailScopeFrom(ctx context.Context) *aiGuardrailScope {
+	scope, _ := ctx.Value(aiGuardrailScopeKey{}).(*aiGuardrailScope)
+	return scope
+}
+
+// guardrailScope returns the scope of a context; work outside any project is guarded by the defaults
+// without being recorded
+func (s *AIService) guardrailScope(ctx context.Context) *aiGuardrailScope {
+	if scope := guardrailScopeFrom(ctx); scope != nil {
+		return scope
+	}
+	return &aiGuardrailScope{settings: models.DefaultAIGuardrailSettings()}
+}
+
+// redactForAI masks personal data in a text sent to an AI provider
+func (s *AIService) redactForAI(ctx context.Context, text string) string {
+	if s.guardrails == nil {
+		return text
+	}
+	scope := s.guardrailScope(ctx)
+	if !scope.settings.RedactPII {
+		return text
+	}
+	redacted, found := redactPII(text, scope.settings.PIITypes)
+	if len(found) > 0 {
+		s.guardrails.record(ctx, scope, models.AIGuardrailStageInput, models.AIGuardrailKindPII, models.AIGuardrailActionMasked, piiDetails(found))
+	}
+	return redacted
+}
+
+// redactCompletionMessages masks personal data in the conversation part of a prompt: everything but the
+// system messages, which hold the operator's instructions and knowledge
+func (s *AIService) redactCompletionMessages(ctx context.Context, messages []ChatCompletionMessage) {
+	if s.guardrails == nil {
+		return
+	}
+	scope := s.guardrailScope(ctx)
+	if !scope.settings.RedactPII {
+		return
+	}
+
+	found := map[string]int{}
+	redact := func(text string) string {
+		redacted, counts := redactPII(text, scope.settings.PIITypes)
+		for piiType, count := range counts {
+			found[piiType] += count
+		}
+		return redacted
+	}
+	for i := range messages {
+		if messages[i].Role == "system" {
+			continue
+		}
+		switch content := messages[i].Content.(type) {
+		case string:
+			messages[i].Content = redact(content)
+		case []ContentPart:
+			parts := make([]ContentPart, len(content))
+			for j, part := range content {
+				part.Text = redact(part.Text)
+				parts[j] = part
+			}
+			messages[i].Content = parts
+		}
+	}
+	if len(found) > 0 {
+		s.guardrails.record(ctx, scope, models.AIGuardrailStageInput, models.AIGuardrailKindPII, models.AIGuardrailActionMasked, piiDetails(found))
+	}
+}
+
+// screenKnowledge leaves out knowledge chunks that try to instruct the assistant
+func (s *AIService) screenKnowledge(ctx context.Context, sources []models.KnowledgeSearchResult) []models.KnowledgeSearchResult {
+	if s.guardrails == nil || len(sources) == 0 {
+		return sources
+	}
+	scope := s.guardrailScope(ctx)
+	if !scope.settings.DetectPromptInjection {
+		return sources
+	}
+
+	kept := make([]models.KnowledgeSearchResult, 0, len(sources))
+	for _, source := range sources {
+		patterns := detectPromptInjection(source.Content)
+		if len(patterns) == 0 {
+			kept = append(kept, source)
+			continue
+		}
+		s.guardrails.record(ctx, scope, models.AIGuardrailStageKnowledge, models.AIGuardrailKindPromptInjection, models.AIGuardrailActionDropped,
+			models.JSONMap{"patterns": patterns, "source": source.Source, "chunk_id": source.ID.String()})
+	}
+	return kept
+}
+
+// screenVisitorMessage looks for a prompt injection in a visitor's message and records it. It reports true
+// when the message must not be answered by AI.
+func (s *AIService) screenVisitorMessage(ctx context.Context, content string) bool {
+	if s.guardrails == nil {
+		return false
+	}
+	scope := s.guardrailScope(ctx)
+	if !scope.settings.DetectPromptInjection {
+		return false
+	}
+	patterns := detectPromptInjection(content)
+	if len(patterns) == 0 {
+		return false
+	}
+
+	action := models.AIGuardrailActionFlagged
+	if scope.settings.BlockPromptInjection {
+		action = models.AIGuardrailActionBlocked
+	}
+	s.guardrails.record(ctx, scope, models.AIGuardrailStageInput, models.AIGuardrailKindPromptInjection, action, models.JSONMap{"patterns": patterns})
+	return scope.settings.BlockPromptInjection
+}
+
+// recordBlockedOutput records an AI reply or draft that used a blocked phrase
+func (s *AIService) recordBlockedOutput(ctx context.Context, phrase, action string) {
+	if s.guardrails == nil {
+		return
+	}
+	s.guardrails.record(ctx, s.guardrailScope(ctx), models.AIGuardrailStageOutput, models.AIGuardrailKindBlocklist, action, models.JSONMap{"phrase": phrase})
+}
+
+// withOutputBlocklist adds the project's output blocklist to the forbidden phrases of a profile
+func (s *AIService) withOutputBlocklist(ctx context.Context, tenantID, projectID uuid.UUID, profile *models.ResolvedAIProfile) *models.ResolvedAIProfile {
+	if s.guardrails == nil {
+		return profile
+	}
+	var settings *models.AIGuardrailSettings
+	if scope := guardrailScopeFrom(ctx); scope != nil && scope.tenantID == tenantID && scope.projectID == projectID {
+		settings = scope.settings
+	} else {
+		settings = s.guardrails.Settings(ctx, tenantID, projectID)
+	}
+	if len(settings.OutputBlocklist) == 0 {
+		return profile
+	}
+
+	seen := map[string]bool{}
+	phrases := make([]string, 0, len(profile.ForbiddenPhrases)+len(settings.OutputBlocklist))
+	for _, phrase := range append(append([]string{}, profile.ForbiddenPhrases...), settings.OutputBlocklist...) {
+		key := strings.ToLower(strings.TrimSpace(phrase))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		phrases = append(phrases, phrase)
+	}
+	guarded := *profile
+	guarded.ForbiddenPhrases = phrases
+	return &guarded
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+var (
+	ErrAIProfileNotFound = errors.New("AI profile not found")
+	ErrAIProfileExists   = errors.New("an AI profile already exists for this project or widget")
+	ErrInvalidAIProfile  = errors.New("invalid AI profile")
+	ErrAIDisabled        = errors.New("AI assistance is not enabled")
+)
+
+const (
+	maxAIProfileListItems    = 50
+	maxAIProfileListItemLen  = 200
+	maxAIPersonaNameLen      = 100
+	maxAIProfileSystemPrompt = 20000
+)
+
+// defaultHandoffKeywords hand a chat to a human when neither the config nor a profile lists any
+var defaultHandoffKeywords = []string{
+	"speak to human", "human agent", "real person", "live agent",
+	"escalate", "supervisor", "manager", "human help",
+	"not helpful", "doesn't work", "frustrated", "angry",
+}
+
+type aiProfileStore interface {
+	Create(ctx context.Context, profile *models.AIProfile) error
+	GetByID(ctx context.Context, tenantID, projectID, profileID uuid.UUID) (*models.AIProfile, error)
+	ListByProject(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIProfile, error)
+	ListEffective(ctx context.Context, tenantID, projectID uuid.UUID, widgetID *uuid.UUID) ([]*models.AIProfile, error)
+	Update(ctx context.Context, profile *models.AIProfile) error
+	Delete(ctx context.Context, tenantID, projectID, profileID uuid.UUID) error
+}
+
+type aiProfileWidgetLookup interface {
+	GetChatWidget(ctx context.Context, tenantID, projectID, widgetID uuid.UUID) (*models.ChatWidget, error)
+}
+
+// AIProfileService manages the per-project and per-widget AI assistant profiles and resolves the
+// configuration in effect for a chat
+type AIProfileService struct {
+	repo    aiProfileStore
+	widgets aiProfileWidgetLookup
+	config  *config.AIConfig
+}
+
+// NewAIProfileService creates a new AI profile service
+func NewAIProfileService(repo aiProfileStore, widgets aiProfileWidgetLookup, cfg *config.AIConfig) *AIProfileService {
+	return &AIProfileService{
+		repo:    repo,
+		widgets: widgets,
+		config:  cfg,
+	}
+}
+
+// ListProfiles returns the AI profiles of a project
+func (s *AIProfileService) ListProfiles(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIProfile, error) {
+	return s.repo.ListByProject(ctx, tenantID, projectID)
+}
+
+// GetProfile returns an AI profile of a project
+func (s *AIProfileService) GetProfile(ctx context.Context, tenantID, projectID, profileID uuid.UUID) (*models.AIProfile, error) {
+	profile, err := s.repo.GetByID(ctx, tenantID, projectID, profileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AI profile: %w", err)
+	}
+	if profile == nil {
+		return nil, ErrAIProfileNotFound
+	}
+	return profile, nil
+}
+
+// CreateProfile creates the project-wide AI profile, or the profile of a widget when the request names one
+func (s *AIProfileService) CreateProfile(ctx context.Context, tenantID, projectID uuid.UUID, req *models.AIProfileRequest) (*models.AIProfile, error) {
+	profile := &models.AIProfile{
+		TenantID:         tenantID,
+		ProjectID:        projectID,
+		WidgetID:         req.WidgetID,
+		Enabled:          true,
+		AllowedTopics:    pq.StringArray{},
+		ForbiddenPhrases: pq.StringArray{},
+		HandoffKeywords:  pq.StringArray{},
+	}
+	if req.WidgetID != nil {
+		widget, err := s.widgets.GetChatWidget(ctx, tenantID, projectID, *req.WidgetID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chat widget: %w", err)
+		}
+		if widget == nil {
+			return nil, fmt.Errorf("%w: widget not found in this project", ErrInvalidAIProfile)
+		}
+	}
+	if err := applyAIProfileRequest(profile, req, s.config); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, profile); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrAIProfileExists
+		}
+		return nil, fmt.Errorf("failed to create AI profile: %w", err)
+	}
+	return profile, nil
+}
+
+// UpdateProfile changes the fields an AI profile request sets
+func (s *AIProfileService) UpdateProfile(ctx context.Context, tenantID, projectID, profileID uuid.UUID, req *models.AIProfileRequest) (*models.AIProfile, error) {
+	profile, err := s.GetProfile(ctx, tenantID, projectID, profileID)
+	if err != nil {
+		return nil, err
+	}
+	if req.WidgetID != nil && (profile.WidgetID == nil || *profile.WidgetID != *req.WidgetID) {
+		return nil, fmt.Errorf("%w: the widget of a profile cannot be changed", ErrInvalidAIProfile)
+	}
+	if err := applyAIProfileRequest(profile, req, s.config); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Update(ctx, profile); err != nil {
+		return nil, fmt.Errorf("failed to update AI profile: %w", err)
+	}
+	return profile, nil
+}
+
+// DeleteProfile removes an AI profile; its project or widget falls back to the next level
+func (s *AIProfileService) DeleteProfile(ctx context.Context, tenantID, projectID, profileID uuid.UUID) error {
+	if _, err := s.GetProfile(ctx, tenantID, projectID, profileID); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, tenantID, projectID, profileID); err != nil {
+		return fmt.Errorf("failed to delete AI profile: %w", err)
+	}
+	return nil
+}
+
+// ResolveProfile returns the AI configuration in effect for a widget of a project: the global config,
+// overridden field by field by the project-wide profile and then by the widget's profile
+func (s *AIProfileService) ResolveProfile(ctx context.Context, tenantID, projectID uuid.UUID, widgetID *uuid.UUID) (*models.ResolvedAIProfile, error) {
+	profiles, err := s.repo.ListEffective(ctx, tenantID, projectID, widgetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AI profiles: %w", err)
+	}
+
+	resolved := globalAIProfile(s.config)
+	for _, profile := range profiles {
+		layerAIProfile(resolved, profile)
+	}
+	s.keepConfiguredProvider(resolved)
+	return resolved, nil
+}
+
+// keepConfiguredProvider falls back to the global provider and model when a profile names a provider
+// whose credentials have since been removed from the config
+func (s *AIProfileService) keepConfiguredProvider(resolved *models.ResolvedAIProfile) {
+	if s.config == nil || resolved.Provider == s.config.Provider {
+		return
+	}
+	if _, _, ok := providerCredentials(s.config, AIProvider(resolved.Provider)); ok {
+		return
+	}
+	fmt.Printf("No credentials configured for AI provider %s, using %s\n", resolved.Provider, s.config.Provider)
+	resolved.Provider = s.config.Provider
+	resolved.Model = s.config.Model
+}
+
+// PreviewProfile resolves the configuration for a widget of a project with unsaved draft settings on top
+func (s *AIProfileService) PreviewProfile(ctx context.Context, tenantID, projectID uuid.UUID, widgetID *uuid.UUID, draft *models.AIProfileRequest) (*models.ResolvedAIProfile, error) {
+	resolved, err := s.ResolveProfile(ctx, tenantID, projectID, widgetID)
+	if err != nil {
+		return nil, err
+	}
+	if draft == nil {
+		return resolved, nil
+	}
+
+	draftProfile := &models.AIProfile{Enabled: true}
+	if err := applyAIProfileRequest(draftProfile, draft, s.config); err != nil {
+		return nil, err
+	}
+	layerAIProfile(resolved, draftProfile)
+	s.keepConfiguredProvider(resolved)
+	return resolved, nil
+}
+
+// globalAIProfile is the configuration of projects without a profile
+func globalAIProfile(cfg *config.AIConfig) *models.ResolvedAIProfile {
+	resolved := &models.ResolvedAIProfile{
+		ProfileIDs:       []uuid.UUID{},
+		AllowedTopics:    []string{},
+		ForbiddenPhrases: []string{},
+		HandoffKeywords:  defaultHandoffKeywords,
+	}
+	if cfg == nil {
+		return resolved
+	}
+
+	resolved.SystemPrompt = cfg.SystemPrompt
+	resolved.Provider = cfg.Provider
+	resolved.Model = cfg.Model
+	resolved.Temperature = cfg.Temperature
+	resolved.MaxTokens = cfg.MaxTokens
+	if len(cfg.HandoffKeywords) > 0 {
+		resolved.HandoffKeywords = cfg.HandoffKeywords
+	}
+	return resolved
+}
+
+// layerAIProfile overrides the resolved configuration with the fields a profile sets
+func layerAIProfile(resolved *models.ResolvedAIProfile, profile *models.AIProfile) {
+	if profile.ID != uuid.Nil {
+		resolved.ProfileIDs = append(resolved.ProfileIDs, profile.ID)
+	}
+	if profile.PersonaName != nil {
+		resolved.PersonaName = *profile.PersonaName
+	}
+	if profile.SystemPrompt != nil {
+		resolved.SystemPrompt = *profile.SystemPrompt
+	}
+	if len(profile.AllowedTopics) > 0 {
+		resolved.AllowedTopics = profile.AllowedTopics
+	}
+	if len(profile.ForbiddenPhrases) > 0 {
+		resolved.ForbiddenPhrases = profile.ForbiddenPhrases
+	}
+	if len(profile.HandoffKeywords) > 0 {
+		resolved.HandoffKeywords = profile.HandoffKeywords
+	}
+	if profile.Provider != nil {
+		resolved.Provider = *profile.Provider
+	}
+	if profile.Model != nil {
+		resolved.Model = *profile.Model
+	}
+	if profile.Temperature != nil {
+		resolved.Temperature = *profile.Temperature
+	}
+	if profile.MaxTokens != nil {
+		resolved.MaxTokens = *profile.MaxTokens
+	}
+}
+
+// applyAIProfileRequest validates a request and copies the fields it sets onto a profile. Empty strings
+// and lists, and a zero max_tokens, clear a field so it is inherited again. A provider must have
+// credentials in the AI config.
+func applyAIProfileRequest(profile *models.AIProfile, req *models.AIProfileRequest, cfg *config.AIConfig) error {
+	if req.Enabled != nil {
+		profile.Enabled = *req.Enabled
+	}
+
+	if req.PersonaName != nil {
+		name := strings.TrimSpace(*req.PersonaName)
+		if len(name) > maxAIPersonaNameLen {
+			return fmt.Errorf("%w: persona_name is longer than %d characters", ErrInvalidAIProfile, maxAIPersonaNameLen)
+		}
+		profile.PersonaName = optionalString(name)
+	}
+	if req.SystemPrompt != nil {
+		prompt := strings.TrimSpace(*req.SystemPrompt)
+		if len(prompt) > maxAIProfileSystemPrompt {
+			return fmt.Errorf("%w: system_prompt is longer than %d characters", ErrInvalidAIProfile, maxAIProfileSystemPrompt)
+		}
+		profile.SystemPrompt = optionalString(prompt)
+	}
+
+	lists := []struct {
+		field     string
+		requested []string
+		target    *pq.StringArray
+	}{
+		{"allowed_topics", req.AllowedTopics, &profile.AllowedTopics},
+		{"forbidden_phrases", req.ForbiddenPhrases, &profile.ForbiddenPhrases},
+		{"handoff_keywords", req.HandoffKeywords, &profile.HandoffKeywords},
+	}
+	for _, list := range lists {
+		if list.requested == nil {
+			continue
+		}
+		normalized, err := normalizeAIProfileList(list.field, list.requested)
+		if err != nil {
+			return err
+		}
+		*list.target = normalized
+	}
+
+	if req.Provider != nil {
+		provider := strings.ToLower(strings.TrimSpace(*req.Provider))
+		switch AIProvider(provider) {
+		case "", ProviderOpenAI, ProviderAnthropic, ProviderAzure, ProviderBB:
+		default:
+			return fmt.Errorf("%w: unsupported provider %q", ErrInvalidAIProfile, provider)
+		}
+		if provider != "" {
+			if _, _, ok := providerCredentials(cfg, AIProvider(provider)); !ok {
+				return fmt.Errorf("%w: no credentials are configured for provider %q", ErrInvalidAIProfile, provider)
+			}
+		}
+		profile.Provider = optionalString(provider)
+	}
+	if req.Model != nil {
+		profile.Model = optionalString(strings.TrimSpace(*req.Model))
+	}
+	if req.Temperature != nil {
+		if *req.Temperature < 0 || *req.Temperature > 2 {
+			return fmt.Errorf("%w: temperature must be between 0 and 2", ErrInvalidAIProfile)
+		}
+		temperature := *req.Temperature
+		profile.Temperature = &temperature
+	}
+	if req.MaxTokens != nil {
+		switch {
+		case *req.MaxTokens < 0:
+			return fmt.Errorf("%w: max_tokens must be positive", ErrInvalidAIProfile)
+		case *req.MaxTokens == 0:
+			profile.MaxTokens = nil
+		default:
+			maxTokens := *req.MaxTokens
+			profile.MaxTokens = &maxTokens
+		}
+	}
+	return nil
+}
+
+// normalizeAIProfileList trims the entries of a profile list and drops blanks and duplicates
+func normalizeAIProfileList(field string, requested []string) (pq.StringArray, error) {
+	normalized := pq.StringArray{}
+	seen := make(map[string]bool, len(requested))
+	for _, item := range requested {
+		item = strings.TrimSpace(item)
+		key := strings.ToLower(item)
+		if item == "" || seen[key] {
+			continue
+		}
+		if len(item) > maxAIProfileListItemLen {
+			return nil, fmt.Errorf("%w: %s entries must be at most %d characters", ErrInvalidAIProfile, field, maxAIProfileListItemLen)
+		}
+		seen[key] = true
+		normalized = append(normalized, item)
+	}
+	if len(normalized) > maxAIProfileListItems {
+		return nil, fmt.Errorf("%w: %s has more than %d entries", ErrInvalidAIProfile, field, maxAIProfileListItems)
+	}
+	return normalized, nil
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
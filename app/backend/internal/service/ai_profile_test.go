@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeAIProfileStore struct {
+	profiles []*models.AIProfile
+}
+
+func (f *fakeAIProfileStore) Create(ctx context.Context, profile *models.AIProfile) error {
+	for _, existing := range f.profiles {
+		if existing.ProjectID == profile.ProjectID && sameWidget(existing.WidgetID, profile.WidgetID) {
+			return &pq.Error{Code: "23505"}
+		}
+	}
+	profile.ID = uuid.New()
+	f.profiles = append(f.profiles, profile)
+	return nil
+}
+
+func (f *fakeAIProfileStore) GetByID(ctx context.Context, tenantID, projectID, profileID uuid.UUID) (*models.AIProfile, error) {
+	for _, profile := range f.profiles {
+		if profile.TenantID == tenantID && profile.ProjectID == projectID && profile.ID == profileID {
+			return profile, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAIProfileStore) ListByProject(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AIProfile, error) {
+	return f.profiles, nil
+}
+
+func (f *fakeAIProfileStore) ListEffective(ctx context.Context, tenantID, projectID uuid.UUID, widgetID *uuid.UUID) ([]*models.AIProfile, error) {
+	var effective []*models.AIProfile
+	for _, profile := range f.profiles {
+		if profile.Enabled && profile.ProjectID == projectID && profile.WidgetID == nil {
+			effective = append(effective, profile)
+		}
+	}
+	for _, profile := range f.profiles {
+		if profile.Enabled && profile.ProjectID == projectID && profile.WidgetID != nil && sameWidget(profile.WidgetID, widgetID) {
+			effective = append(effective, profile)
+		}
+	}
+	return effective, nil
+}
+
+func (f *fakeAIProfileStore) Update(ctx context.Context, profile *models.AIProfile) error {
+	return nil
+}
+
+func (f *fakeAIProfileStore) Delete(ctx context.Context, tenantID, projectID, profileID uuid.UUID) error {
+	return nil
+}
+
+func sameWidget(a, b *uuid.UUID) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+type fakeAIProfileWidgets struct {
+	widgets map[uuid.UUID]bool
+}
+
+func (f *fakeAIProfileWidgets) GetChatWidget(ctx context.Context, tenantID, projectID, widgetID uuid.UUID) (*models.ChatWidget, error) {
+	if !f.widgets[widgetID] {
+		return nil, nil
+	}
+	return &models.ChatWidget{ID: widgetID, TenantID: tenantID, ProjectID: projectID}, nil
+}
+
+func strRef(value string) *string { return &value }
+
+func TestResolveProfileLayersWidgetOverProjectOverGlobal(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID, widgetID := uuid.New(), uuid.New(), uuid.New()
+	cfg := &config.AIConfig{Provider: "openai", Model: "gpt-4o", SystemPrompt: "Be helpful.", Temperature: 0.7, MaxTokens: 500}
+	svc := NewAIProfileService(&fakeAIProfileStore{}, &fakeAIProfileWidgets{widgets: map[uuid.UUID]bool{widgetID: true}}, cfg)
+
+	// Without profiles the global config applies, with the default handoff keywords
+	resolved, err := svc.ResolveProfile(ctx, tenantID, projectID, &widgetID)
+	require.NoError(t, err)
+	require.Equal(t, "gpt-4o", resolved.Model)
+	require.Equal(t, "Be helpful.", resolved.SystemPrompt)
+	require.Equal(t, defaultHandoffKeywords, resolved.HandoffKeywords)
+	require.Equal(t, models.DefaultAIPersonaName, resolved.AuthorName())
+
+	temperature := 0.2
+	_, err = svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{
+		PersonaName:     strRef("Ava"),
+		SystemPrompt:    strRef("You are the Acme support assistant."),
+		Model:           strRef("gpt-4o-mini"),
+		Temperature:     &temperature,
+		HandoffKeywords: []string{"refund", " Refund ", ""},
+	})
+	require.NoError(t, err)
+	_, err = svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{
+		WidgetID:      &widgetID,
+		PersonaName:   strRef("Ana"),
+		SystemPrompt:  strRef("Responde siempre en español."),
+		AllowedTopics: []string{"envíos"},
+	})
+	require.NoError(t, err)
+
+	resolved, err = svc.ResolveProfile(ctx, tenantID, projectID, &widgetID)
+	require.NoError(t, err)
+	require.Len(t, resolved.ProfileIDs, 2)
+	require.Equal(t, "Ana", resolved.AuthorName())
+	require.Equal(t, "Responde siempre en español.", resolved.SystemPrompt)
+	require.Equal(t, []string{"envíos"}, resolved.AllowedTopics)
+	// Fields the widget leaves unset come from the project profile, then from the global config
+	require.Equal(t, "gpt-4o-mini", resolved.Model)
+	require.Equal(t, 0.2, resolved.Temperature)
+	require.Equal(t, []string{"refund"}, resolved.HandoffKeywords)
+	require.Equal(t, "openai", resolved.Provider)
+	require.Equal(t, 500, resolved.MaxTokens)
+
+	// Other widgets only get the project profile
+	resolved, err = svc.ResolveProfile(ctx, tenantID, projectID, nil)
+	require.NoError(t, err)
+	require.Equal(t, "Ava", resolved.PersonaName)
+	require.Empty(t, resolved.AllowedTopics)
+}
+
+func TestCreateProfileRejectsInvalidProfiles(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	svc := NewAIProfileService(&fakeAIProfileStore{}, &fakeAIProfileWidgets{}, &config.AIConfig{})
+
+	otherWidget := uuid.New()
+	_, err := svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{WidgetID: &otherWidget})
+	require.ErrorIs(t, err, ErrInvalidAIProfile)
+
+	_, err = svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{Provider: strRef("gemini")})
+	require.ErrorIs(t, err, ErrInvalidAIProfile)
+
+	tooHot := 2.5
+	_, err = svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{Temperature: &tooHot})
+	require.ErrorIs(t, err, ErrInvalidAIProfile)
+
+	_, err = svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{})
+	require.NoError(t, err)
+	_, err = svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{})
+	require.ErrorIs(t, err, ErrAIProfileExists)
+}
+
+func TestProfileProviderNeedsCredentials(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	cfg := &config.AIConfig{
+		Provider:  "openai",
+		APIKey:    "openai-key",
+		Model:     "gpt-4o",
+		Fallbacks: []config.AIFallbackConfig{{Provider: "anthropic", APIKey: "anthropic-key"}},
+	}
+	store := &fakeAIProfileStore{}
+	svc := NewAIProfileService(store, &fakeAIProfileWidgets{}, cfg)
+
+	// Azure is supported but has no credentials in this deployment
+	_, err := svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{Provider: strRef("azure")})
+	require.ErrorIs(t, err, ErrInvalidAIProfile)
+
+	_, err = svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{Provider: strRef("anthropic"), Model: strRef("claude-sonnet")})
+	require.NoError(t, err)
+	resolved, err := svc.ResolveProfile(ctx, tenantID, projectID, nil)
+	require.NoError(t, err)
+	require.Equal(t, "anthropic", resolved.Provider)
+	require.Equal(t, "claude-sonnet", resolved.Model)
+
+	// Once the provider's credentials are removed, chats use the global provider and model again
+	cfg.Fallbacks = nil
+	resolved, err = svc.ResolveProfile(ctx, tenantID, projectID, nil)
+	require.NoError(t, err)
+	require.Equal(t, "openai", resolved.Provider)
+	require.Equal(t, "gpt-4o", resolved.Model)
+}
+
+func TestUpdateProfileClearsFieldsBackToInherited(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	svc := NewAIProfileService(&fakeAIProfileStore{}, &fakeAIProfileWidgets{}, &config.AIConfig{Model: "gpt-4o"})
+
+	maxTokens := 800
+	profile, err := svc.CreateProfile(ctx, tenantID, projectID, &models.AIProfileRequest{
+		Model:            strRef("gpt-4o-mini"),
+		MaxTokens:        &maxTokens,
+		ForbiddenPhrases: []string{"guarantee"},
+	})
+	require.NoError(t, err)
+
+	noLimit := 0
+	profile, err = svc.UpdateProfile(ctx, tenantID, projectID, profile.ID, &models.AIProfileRequest{
+		Model:            strRef(""),
+		MaxTokens:        &noLimit,
+		ForbiddenPhrases: []string{},
+	})
+	require.NoError(t, err)
+	require.Nil(t, profile.Model)
+	require.Nil(t, profile.MaxTokens)
+	require.Empty(t, profile.ForbiddenPhrases)
+
+	_, err = svc.UpdateProfile(ctx, tenantID, projectID, uuid.New(), &models.AIProfileRequest{})
+	require.ErrorIs(t, err, ErrAIProfileNotFound)
+}
+
+func TestShouldHandoffToAgentUsesProfileKeywords(t *testing.T) {
+	svc := &AIService{config: &config.AIConfig{}}
+	session := &models.ChatSession{ID: uuid.New(), CreatedAt: time.Now()}
+	profile := globalAIProfile(svc.config)
+
+	require.True(t, svc.shouldHandoffToAgent(session, profile, "Can I speak to human please"))
+
+	profile.HandoffKeywords = []string{"hablar con un agente"}
+	require.False(t, svc.shouldHandoffToAgent(session, profile, "Can I speak to human please"))
+	require.True(t, svc.shouldHandoffToAgent(session, profile, "Quiero HABLAR con un agente"))
+}
+
+func TestAgentRepliesFollowTheProfileRules(t *testing.T) {
+	svc := &AIService{config: &config.AIConfig{}}
+	session := &models.ChatSession{ID: uuid.New(), CreatedAt: time.Now()}
+	profile := globalAIProfile(svc.config)
+	profile.HandoffKeywords = []string{"hablar con un agente"}
+	profile.ForbiddenPhrases = []string{"lawsuit"}
+
+	stream := func(agent *fakeAgentStream, content string) ([]string, string) {
+		var replies []string
+		reason := svc.streamAgentReply(context.Background(), session, profile, content, "conn", agent, func(bool) {}, func(reply string) {
+			replies = append(replies, reply)
+		})
+		return replies, reason
+	}
+
+	// The profile's handoff keywords go to a human without asking the agent
+	agent := &fakeAgentStream{}
+	replies, reason := stream(agent, "Quiero hablar con un agente")
+	require.Equal(t, "Customer requested human assistance", reason)
+	require.Empty(t, replies)
+	require.Empty(t, agent.requests)
+
+	// So do replies that use one of the profile's forbidden phrases
+	agent = &fakeAgentStream{responses: []AgentResponse{{Type: "message", Content: "You could file a Lawsuit."}}}
+	replies, reason = stream(agent, "What are my options?")
+	require.Equal(t, "AI reply used a forbidden phrase", reason)
+	require.Empty(t, replies)
+	require.Len(t, agent.requests, 1)
+}
+
+func TestPreviewProfileUsesProfileModelAndFlagsForbiddenPhrases(t *testing.T) {
+	var received ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/chat/completions", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"We guarantee delivery in 2 days."}}],"usage":{"prompt_tokens":40,"completion_tokens":9,"total_tokens":49}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.AIConfig{Enabled: true, APIKey: "key", Provider: "openai", BaseURL: server.URL, Model: "gpt-4o", MaxTokens: 500}
+	svc := &AIService{config: cfg, llm: NewLLMRouter(cfg, server.Client())}
+
+	profile := globalAIProfile(cfg)
+	profile.Model = "gpt-4o-mini"
+	profile.Temperature = 0.1
+	profile.PersonaName = "Ava"
+	profile.AllowedTopics = []string{"shipping"}
+	profile.ForbiddenPhrases = []string{"Guarantee"}
+
+	preview, err := svc.PreviewProfile(context.Background(), uuid.New(), uuid.New(), profile, "How fast do you ship?")
+	require.NoError(t, err)
+	require.Equal(t, "We guarantee delivery in 2 days.", preview.Reply)
+	require.True(t, preview.Blocked)
+	require.False(t, preview.Handoff)
+	require.Equal(t, int64(40), preview.PromptTokens)
+
+	require.Equal(t, "gpt-4o-mini", received.Model)
+	require.Equal(t, 0.1, received.Temperature)
+	require.Len(t, received.Messages, 2)
+	systemPrompt, _ := received.Messages[0].Content.(string)
+	require.Contains(t, systemPrompt, "Your name is Ava.")
+	require.Contains(t, systemPrompt, "Only help with questions about: shipping.")
+
+	// Handoff keywords are answered by a human without calling the model
+	received = ChatCompletionRequest{}
+	preview, err = svc.PreviewProfile(context.Background(), uuid.New(), uuid.New(), profile, "I want a live agent")
+	require.NoError(t, err)
+	require.True(t, preview.Handoff)
+	require.Empty(t, received.Model)
+}
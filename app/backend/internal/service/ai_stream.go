@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/models"
+	ws "github.com/bareuptime/tms/internal/websocket"
+)
+
+// replyStreamInterval is how often the pieces of a streamed AI reply are sent, so a chat gets a few
+// updates a second rather than one per token
+const replyStreamInterval = 100 * time.Millisecond
+
+// aiReplyStream sends an AI reply to the visitor and the project's agents as it is generated. The
+// reply is stored and sent as a chat message once complete, with the stream ID in its metadata.
+type aiReplyStream struct {
+	id         uuid.UUID
+	service    *AIService
+	session    *models.ChatSession
+	connID     string
+	authorName string
+	pending    strings.Builder
+	lastSent   time.Time
+}
+
+func (s *AIService) newReplyStream(session *models.ChatSession, connID, authorName string) *aiReplyStream {
+	return &aiReplyStream{
+		id:         uuid.New(),
+		service:    s,
+		session:    session,
+		connID:     connID,
+		authorName: authorName,
+		lastSent:   time.Now(),
+	}
+}
+
+// add queues a piece of the reply and sends what is queued when the interval has passed
+func (r *aiReplyStream) add(delta string) {
+	r.pending.WriteString(delta)
+	if time.Since(r.lastSent) >= replyStreamInterval {
+		r.flush()
+	}
+}
+
+// flush sends the queued pieces of the reply
+func (r *aiReplyStream) flush() {
+	if r.pending.Len() == 0 {
+		return
+	}
+	r.send(map[string]interface{}{"delta": r.pending.String()})
+	r.pending.Reset()
+	r.lastSent = time.Now()
+}
+
+// abort tells clients to drop what was streamed; the reply failed and will not be stored
+func (r *aiReplyStream) abort() {
+	r.pending.Reset()
+	r.send(map[string]interface{}{"delta": "", "aborted": true})
+}
+
+func (r *aiReplyStream) send(data map[string]interface{}) {
+	data["stream_id"] = r.id
+	data["session_id"] = r.session.ID
+	data["author_name"] = r.authorName
+	data["author_type"] = "ai-agent"
+	payload, _ := json.Marshal(data)
+
+	// Like stored AI messages: to the visitor's connection, then to the project's agents
+	message := &ws.Message{
+		Type:      string(models.WSMsgTypeChatMessageDelta),
+		SessionID: r.session.ID,
+		Data:      payload,
+		FromType:  ws.ConnectionTypeAiAgent,
+		ProjectID: &r.session.ProjectID,
+		TenantID:  &r.session.TenantID,
+		AgentID:   &uuid.Nil,
+		Timestamp: time.Now(),
+	}
+	if r.session.AssignedAgentID != nil {
+		message.AgentID = r.session.AssignedAgentID
+	}
+	r.service.connectionManager.SendToConnection(r.connID, message)
+	message.FromType = ws.ConnectionTypeVisitor
+	r.service.connectionManager.DeliverWebSocketMessage(r.session.ID, message)
+}
@@ -0,0 +1,730 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/bareuptime/tms/internal/crypto"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+var (
+	ErrAIToolNotFound = errors.New("AI tool not found")
+	ErrAIToolExists   = errors.New("the project already has an AI tool with this name")
+	ErrInvalidAITool  = errors.New("invalid AI tool")
+
+	errAIToolAddressBlocked = errors.New("AI tools may only call public addresses")
+)
+
+// Built-in tools of the assistant
+const (
+	aiToolCreateTicket  = "create_ticket"
+	aiToolListTickets   = "list_my_tickets"
+	aiToolBusinessHours = "check_business_hours"
+	aiToolHandoff       = "hand_off_to_human"
+)
+
+const (
+	defaultAIToolTimeoutMs        = 5000
+	maxAIToolTimeoutMs            = 30000
+	defaultAIToolMaxResponseBytes = 16 << 10
+	maxAIToolResponseBytes        = 256 << 10
+	maxAIToolSchemaBytes          = 16 << 10
+
+	// aiToolTicketsLimit caps the tickets list_my_tickets returns
+	aiToolTicketsLimit = 10
+
+	// maxAIToolRounds bounds the rounds of tool calls of one reply
+	maxAIToolRounds = 4
+)
+
+var (
+	aiToolNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+
+	builtinAIToolNames = map[string]bool{
+		aiToolCreateTicket:  true,
+		aiToolListTickets:   true,
+		aiToolBusinessHours: true,
+		aiToolHandoff:       true,
+	}
+
+	// Headers the tool call sets itself
+	reservedAIToolHeaders = map[string]bool{
+		"Host":              true,
+		"Content-Type":      true,
+		"Content-Length":    true,
+		"X-Visitor-Id":      true,
+		"X-Visitor-Email":   true,
+		"X-Chat-Session-Id": true,
+	}
+)
+
+// aiToolStore is the part of the AI tool repository the service needs
+type aiToolStore interface {
+	Create(ctx context.Context, tool *models.AITool) error
+	GetByID(ctx context.Context, tenantID, projectID, toolID uuid.UUID) (*models.AITool, error)
+	ListByProject(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AITool, error)
+	ListEnabled(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AITool, error)
+	Update(ctx context.Context, tool *models.AITool) error
+	Delete(ctx context.Context, tenantID, projectID, toolID uuid.UUID) error
+}
+
+// aiToolTickets lists tickets; implemented by TicketService
+type aiToolTickets interface {
+	ListTickets(ctx context.Context, tenantID, projectID, agentID uuid.UUID, req ListTicketsRequest) ([]*TicketWithDetails, string, error)
+}
+
+// aiToolTicketConverter turns a chat into a ticket; implemented by ChatTicketService
+type aiToolTicketConverter interface {
+	ConvertSession(ctx context.Context, session *models.ChatSession, agentID *uuid.UUID) (*db.Ticket, error)
+}
+
+// AIToolService manages the HTTP tools of a project and runs the tools the assistant calls
+type AIToolService struct {
+	store      aiToolStore
+	tickets    aiToolTickets
+	converter  aiToolTicketConverter
+	widgets    aiProfileWidgetLookup
+	encryption *crypto.PasswordEncryption
+	client     *http.Client
+	now        func() time.Time
+}
+
+// NewAIToolService creates a new AI tool service
+func NewAIToolService(store aiToolStore, tickets aiToolTickets, converter aiToolTicketConverter, widgets aiProfileWidgetLookup, encryption *crypto.PasswordEncryption) *AIToolService {
+	return &AIToolService{
+		store:      store,
+		tickets:    tickets,
+		converter:  converter,
+		widgets:    widgets,
+		encryption: encryption,
+		client:     newAIToolHTTPClient(),
+		now:        time.Now,
+	}
+}
+
+// newAIToolHTTPClient creates the client tenant tools are called with. It only connects to public
+// addresses, so a tool cannot reach our own network, and does not follow redirects, which would send
+// the auth headers elsewhere.
+func newAIToolHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return errAIToolAddressBlocked
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        20,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// ListTools lists the HTTP tools of a project
+func (s *AIToolService) ListTools(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AITool, error) {
+	return s.store.ListByProject(ctx, tenantID, projectID)
+}
+
+// GetTool returns an HTTP tool of a project
+func (s *AIToolService) GetTool(ctx context.Context, tenantID, projectID, toolID uuid.UUID) (*models.AITool, error) {
+	tool, err := s.store.GetByID(ctx, tenantID, projectID, toolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AI tool: %w", err)
+	}
+	if tool == nil {
+		return nil, ErrAIToolNotFound
+	}
+	return tool, nil
+}
+
+// CreateTool adds an HTTP tool to a project
+func (s *AIToolService) CreateTool(ctx context.Context, tenantID, projectID uuid.UUID, req *models.AIToolRequest) (*models.AITool, error) {
+	tool := &models.AITool{
+		TenantID:         tenantID,
+		ProjectID:        projectID,
+		Method:           http.MethodPost,
+		Parameters:       models.JSONMap{"type": "object", "properties": map[string]interface{}{}},
+		HeaderNames:      pq.StringArray{},
+		TimeoutMs:        defaultAIToolTimeoutMs,
+		MaxResponseBytes: defaultAIToolMaxResponseBytes,
+		Enabled:          true,
+	}
+	if req.Name == nil || req.Description == nil || req.URL == nil {
+		return nil, fmt.Errorf("%w: name, description and url are required", ErrInvalidAITool)
+	}
+	if err := s.applyAIToolRequest(tool, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Create(ctx, tool); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrAIToolExists
+		}
+		return nil, fmt.Errorf("failed to create AI tool: %w", err)
+	}
+	return tool, nil
+}
+
+// UpdateTool changes the fields of an HTTP tool the request sets
+func (s *AIToolService) UpdateTool(ctx context.Context, tenantID, projectID, toolID uuid.UUID, req *models.AIToolRequest) (*models.AITool, error) {
+	tool, err := s.GetTool(ctx, tenantID, projectID, toolID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyAIToolRequest(tool, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Update(ctx, tool); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrAIToolExists
+		}
+		return nil, fmt.Errorf("failed to update AI tool: %w", err)
+	}
+	return tool, nil
+}
+
+// DeleteTool removes an HTTP tool
+func (s *AIToolService) DeleteTool(ctx context.Context, tenantID, projectID, toolID uuid.UUID) error {
+	if _, err := s.GetTool(ctx, tenantID, projectID, toolID); err != nil {
+		return err
+	}
+	if err := s.store.Delete(ctx, tenantID, projectID, toolID); err != nil {
+		return fmt.Errorf("failed to delete AI tool: %w", err)
+	}
+	return nil
+}
+
+// TestTool calls an HTTP tool with the given arguments as the assistant would for an anonymous visitor
+func (s *AIToolService) TestTool(ctx context.Context, tenantID, projectID, toolID uuid.UUID, arguments map[string]interface{}) (*models.AIToolTestResponse, error) {
+	tool, err := s.GetTool(ctx, tenantID, projectID, toolID)
+	if err != nil {
+		return nil, err
+	}
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(arguments)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAITool, err)
+	}
+
+	toolset := &aiToolset{service: s, http: map[string]*models.AITool{tool.Name: tool}}
+	result := toolset.call(ctx, ToolCall{ID: "test", Type: "function", Function: ToolCallFunction{Name: tool.Name, Arguments: string(raw)}})
+	return &models.AIToolTestResponse{Invocation: toolset.invocations[0], Result: result}, nil
+}
+
+// applyAIToolRequest validates the fields a request sets and copies them onto a tool
+func (s *AIToolService) applyAIToolRequest(tool *models.AITool, req *models.AIToolRequest) error {
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if !aiToolNamePattern.MatchString(name) {
+			return fmt.Errorf("%w: name must start with a letter and have at most 64 letters, digits, _ or -", ErrInvalidAITool)
+		}
+		if builtinAIToolNames[name] {
+			return fmt.Errorf("%w: %s is the name of a built-in tool", ErrInvalidAITool, name)
+		}
+		tool.Name = name
+	}
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		if description == "" || len(description) > 1000 {
+			return fmt.Errorf("%w: description must be 1-1000 characters", ErrInvalidAITool)
+		}
+		tool.Description = description
+	}
+	if req.Parameters != nil {
+		if req.Parameters["type"] != "object" {
+			return fmt.Errorf("%w: parameters must be a JSON schema of type object", ErrInvalidAITool)
+		}
+		if properties, ok := req.Parameters["properties"]; ok {
+			if _, ok := properties.(map[string]interface{}); !ok {
+				return fmt.Errorf("%w: parameters.properties must be an object", ErrInvalidAITool)
+			}
+		}
+		raw, err := json.Marshal(req.Parameters)
+		if err != nil || len(raw) > maxAIToolSchemaBytes {
+			return fmt.Errorf("%w: parameters must be a JSON schema of at most %d bytes", ErrInvalidAITool, maxAIToolSchemaBytes)
+		}
+		tool.Parameters = models.JSONMap(req.Parameters)
+	}
+	if req.Method != nil {
+		method := strings.ToUpper(strings.TrimSpace(*req.Method))
+		if method != http.MethodGet && method != http.MethodPost {
+			return fmt.Errorf("%w: method must be GET or POST", ErrInvalidAITool)
+		}
+		tool.Method = method
+	}
+	if req.URL != nil {
+		rawURL := strings.TrimSpace(*req.URL)
+		if !isHTTPURL(rawURL) {
+			return fmt.Errorf("%w: url must be an http or https URL", ErrInvalidAITool)
+		}
+		tool.URL = rawURL
+	}
+	if req.Headers != nil {
+		if err := s.setAIToolHeaders(tool, req.Headers); err != nil {
+			return err
+		}
+	}
+	if req.TimeoutMs != nil {
+		if *req.TimeoutMs < 100 || *req.TimeoutMs > maxAIToolTimeoutMs {
+			return fmt.Errorf("%w: timeout_ms must be between 100 and %d", ErrInvalidAITool, maxAIToolTimeoutMs)
+		}
+		tool.TimeoutMs = *req.TimeoutMs
+	}
+	if req.MaxResponseBytes != nil {
+		if *req.MaxResponseBytes < 256 || *req.MaxResponseBytes > maxAIToolResponseBytes {
+			return fmt.Errorf("%w: max_response_bytes must be between 256 and %d", ErrInvalidAITool, maxAIToolResponseBytes)
+		}
+		tool.MaxResponseBytes = *req.MaxResponseBytes
+	}
+	if req.RequiresVerifiedVisitor != nil {
+		tool.RequiresVerifiedVisitor = *req.RequiresVerifiedVisitor
+	}
+	if req.Enabled != nil {
+		tool.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// setAIToolHeaders replaces the auth headers of a tool, stored encrypted
+func (s *AIToolService) setAIToolHeaders(tool *models.AITool, headers map[string]string) error {
+	if len(headers) == 0 {
+		tool.HeadersEncrypted = nil
+		tool.HeaderNames = pq.StringArray{}
+		return nil
+	}
+
+	canonical := make(map[string]string, len(headers))
+	names := pq.StringArray{}
+	for name, value := range headers {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" || strings.ContainsAny(name, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("%w: invalid header %q", ErrInvalidAITool, name)
+		}
+		if reservedAIToolHeaders[name] {
+			return fmt.Errorf("%w: header %s is set by the tool call", ErrInvalidAITool, name)
+		}
+		canonical[name] = value
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	raw, err := json.Marshal(canonical)
+	if err != nil {
+		return fmt.Errorf("failed to encode AI tool headers: %w", err)
+	}
+	encrypted, err := s.encryption.Encrypt(string(raw))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt AI tool headers: %w", err)
+	}
+	stored := string(encrypted)
+	tool.HeadersEncrypted = &stored
+	tool.HeaderNames = names
+	return nil
+}
+
+// aiToolset is the tools the assistant may call while answering in a chat session. It records every
+// call so the reply can carry them in its metadata. A handoff is only recorded; the caller hands the
+// chat over once the model is done.
+type aiToolset struct {
+	service       *AIToolService
+	session       *models.ChatSession
+	definitions   []ChatTool
+	http          map[string]*models.AITool
+	invocations   []models.AIToolInvocation
+	handoffReason string
+}
+
+// Toolset returns the tools available to the assistant in a chat session. Tools that need a verified
+// visitor are left out of sessions without one.
+func (s *AIToolService) Toolset(ctx context.Context, session *models.ChatSession) (*aiToolset, error) {
+	toolset := &aiToolset{
+		service: s,
+		session: session,
+		http:    map[string]*models.AITool{},
+	}
+
+	// The ticket link is emailed to the visitor, so the address must belong to a verified visitor
+	if s.converter != nil && session.TicketID == nil && session.IdentityVerified && session.CustomerEmail != nil && *session.CustomerEmail != "" {
+		toolset.define(aiToolCreateTicket, "Create a support ticket from this conversation when the visitor's problem needs follow-up by the support team. The visitor is emailed a link to the ticket.", nil)
+	}
+	if s.tickets != nil && session.IdentityVerified && session.CustomerID != nil {
+		toolset.define(aiToolListTickets, "List the visitor's most recent support tickets with their status.", map[string]interface{}{
+			"status": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"new", "open", "pending", "resolved", "closed"},
+				"description": "Only list tickets with this status",
+			},
+		})
+	}
+	if s.widgets != nil {
+		toolset.define(aiToolBusinessHours, "Check whether the support team is currently within business hours and see the weekly schedule.", nil)
+	}
+	if session.AssignedAgentID == nil {
+		toolset.define(aiToolHandoff, "Hand the conversation to a human agent when the visitor asks for one or you cannot help.", map[string]interface{}{
+			"reason": map[string]interface{}{"type": "string", "description": "Why a human is needed"},
+		})
+	}
+
+	tools, err := s.store.ListEnabled(ctx, session.TenantID, session.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list AI tools: %w", err)
+	}
+	for _, tool := range tools {
+		if tool.RequiresVerifiedVisitor && !session.IdentityVerified {
+			continue
+		}
+		toolset.http[tool.Name] = tool
+		toolset.definitions = append(toolset.definitions, ChatTool{
+			Type:     "function",
+			Function: ChatToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	return toolset, nil
+}
+
+// handedOff reports whether the assistant asked for a human agent
+func (t *aiToolset) handedOff() bool {
+	return t.handoffReason != ""
+}
+
+func (t *aiToolset) define(name, description string, properties map[string]interface{}) {
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	t.definitions = append(t.definitions, ChatTool{
+		Type: "function",
+		Function: ChatToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  map[string]interface{}{"type": "object", "properties": properties},
+		},
+	})
+}
+
+// defined reports whether the assistant was offered a tool
+func (t *aiToolset) defined(name string) bool {
+	for _, definition := range t.definitions {
+		if definition.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// call runs a tool call and returns the result for the model. Failures are returned to the model as
+// an error object, so it can tell the visitor or try something else.
+func (t *aiToolset) call(ctx context.Context, call ToolCall) string {
+	started := t.service.now()
+	invocation := models.AIToolInvocation{
+		Tool:      call.Function.Name,
+		Source:    models.AIToolSourceBuiltin,
+		Arguments: json.RawMessage(call.Function.Arguments),
+	}
+	if !json.Valid(invocation.Arguments) {
+		quoted, _ := json.Marshal(call.Function.Arguments)
+		invocation.Arguments = quoted
+	}
+
+	var arguments map[string]interface{}
+	result, err := "", json.Unmarshal([]byte(call.Function.Arguments), &arguments)
+	if err != nil {
+		err = fmt.Errorf("arguments must be a JSON object")
+	} else if tool, ok := t.http[call.Function.Name]; ok {
+		invocation.Source = models.AIToolSourceHTTP
+		invocation.ToolID = &tool.ID
+		result, err = t.service.callHTTPTool(ctx, tool, t.session, arguments)
+	} else if t.defined(call.Function.Name) {
+		result, err = t.callBuiltin(ctx, call.Function.Name, arguments)
+	} else {
+		err = fmt.Errorf("unknown tool %s", call.Function.Name)
+	}
+
+	invocation.DurationMs = t.service.now().Sub(started).Milliseconds()
+	invocation.Status = "ok"
+	if err != nil {
+		invocation.Status = "error"
+		invocation.Error = err.Error()
+		encoded, _ := json.Marshal(map[string]string{"error": err.Error()})
+		result = string(encoded)
+	}
+	t.invocations = append(t.invocations, invocation)
+	return result
+}
+
+func (t *aiToolset) callBuiltin(ctx context.Context, name string, arguments map[string]interface{}) (string, error) {
+	switch name {
+	case aiToolCreateTicket:
+		ticket, err := t.service.converter.ConvertSession(ctx, t.session, nil)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Created ticket #%d. The visitor was emailed a link to follow up at %s.", ticket.Number, *t.session.CustomerEmail), nil
+	case aiToolListTickets:
+		return t.service.listVisitorTickets(ctx, t.session, arguments)
+	case aiToolBusinessHours:
+		return t.service.businessHours(ctx, t.session)
+	case aiToolHandoff:
+		reason, _ := arguments["reason"].(string)
+		if strings.TrimSpace(reason) == "" {
+			reason = "AI requested a human agent"
+		}
+		t.handoffReason = reason
+		return "A human agent has been requested.", nil
+	default:
+		return "", fmt.Errorf("unknown tool %s", name)
+	}
+}
+
+// listVisitorTickets returns the most recent tickets of a session's verified visitor
+func (s *AIToolService) listVisitorTickets(ctx context.Context, session *models.ChatSession, arguments map[string]interface{}) (string, error) {
+	customerID := session.CustomerID.String()
+	req := ListTicketsRequest{CustomerID: &customerID, Limit: aiToolTicketsLimit}
+	if status, ok := arguments["status"].(string); ok && status != "" {
+		req.Status = []string{status}
+	}
+
+	tickets, _, err := s.tickets.ListTickets(ctx, session.TenantID, session.ProjectID, uuid.Nil, req)
+	if err != nil {
+		return "", err
+	}
+	if len(tickets) > aiToolTicketsLimit {
+		tickets = tickets[:aiToolTicketsLimit]
+	}
+
+	type visitorTicket struct {
+		Number    int       `json:"number"`
+		Subject   string    `json:"subject"`
+		Status    string    `json:"status"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	list := make([]visitorTicket, 0, len(tickets))
+	for _, ticket := range tickets {
+		list = append(list, visitorTicket{Number: ticket.Number, Subject: ticket.Subject, Status: ticket.Status, UpdatedAt: ticket.UpdatedAt})
+	}
+	encoded, err := json.Marshal(map[string]interface{}{"tickets": list})
+	return string(encoded), err
+}
+
+// businessHours reports whether the widget of a session is within its business hours. The schedule is
+// the widget's business_hours setting: {enabled, timezone, schedule: {mon: {enabled, open, close}, ...}}.
+func (s *AIToolService) businessHours(ctx context.Context, session *models.ChatSession) (string, error) {
+	widget, err := s.widgets.GetChatWidget(ctx, session.TenantID, session.ProjectID, session.WidgetID)
+	if err != nil {
+		return "", err
+	}
+	result := map[string]interface{}{"open": true, "configured": false}
+	if widget == nil || widget.BusinessHours == nil || widget.BusinessHours["enabled"] != true {
+		encoded, err := json.Marshal(result)
+		return string(encoded), err
+	}
+
+	location := time.UTC
+	if name, ok := widget.BusinessHours["timezone"].(string); ok && name != "" {
+		if loaded, err := time.LoadLocation(name); err == nil {
+			location = loaded
+		}
+	}
+	now := s.now().In(location)
+	schedule, _ := widget.BusinessHours["schedule"].(map[string]interface{})
+	day := strings.ToLower(now.Weekday().String()[:3])
+	today, _ := schedule[day].(map[string]interface{})
+
+	open := false
+	if today != nil && today["enabled"] == true {
+		opens, _ := today["open"].(string)
+		closes, _ := today["close"].(string)
+		clock := now.Format("15:04")
+		open = clock >= opens && clock <= closes
+	}
+
+	result["configured"] = true
+	result["open"] = open
+	result["timezone"] = location.String()
+	result["local_time"] = now.Format("Mon 15:04")
+	result["schedule"] = schedule
+	encoded, err := json.Marshal(result)
+	return string(encoded), err
+}
+
+// callHTTPTool calls a tenant tool. GET tools get the arguments as query parameters, POST tools as a
+// JSON body. A verified visitor is identified by the X-Visitor-Id and X-Visitor-Email headers.
+func (s *AIToolService) callHTTPTool(ctx context.Context, tool *models.AITool, session *models.ChatSession, arguments map[string]interface{}) (string, error) {
+	if err := checkAIToolArguments(tool.Parameters, arguments); err != nil {
+		return "", err
+	}
+	if tool.RequiresVerifiedVisitor && session != nil && !session.IdentityVerified {
+		return "", fmt.Errorf("this tool needs a verified visitor")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(tool.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	var body io.Reader
+	target := tool.URL
+	if tool.Method == http.MethodGet {
+		parsed, err := url.Parse(tool.URL)
+		if err != nil {
+			return "", fmt.Errorf("invalid tool URL: %w", err)
+		}
+		query := parsed.Query()
+		for key, value := range arguments {
+			if text, ok := value.(string); ok {
+				query.Set(key, text)
+				continue
+			}
+			encoded, _ := json.Marshal(value)
+			query.Set(key, string(encoded))
+		}
+		parsed.RawQuery = query.Encode()
+		target = parsed.String()
+	} else {
+		encoded, err := json.Marshal(arguments)
+		if err != nil {
+			return "", err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, tool.Method, target, body)
+	if err != nil {
+		return "", fmt.Errorf("invalid tool request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if tool.HeadersEncrypted != nil {
+		decrypted, err := s.encryption.Decrypt([]byte(*tool.HeadersEncrypted))
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt tool headers: %w", err)
+		}
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(decrypted), &headers); err != nil {
+			return "", fmt.Errorf("failed to decode tool headers: %w", err)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+	}
+	if session != nil {
+		req.Header.Set("X-Chat-Session-Id", session.ID.String())
+		if session.IdentityVerified {
+			if userID, ok := session.VisitorInfo["verified_user_id"].(string); ok {
+				req.Header.Set("X-Visitor-Id", userID)
+			}
+			if session.CustomerEmail != nil {
+				req.Header.Set("X-Visitor-Email", *session.CustomerEmail)
+			}
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("tool did not answer within %dms", tool.TimeoutMs)
+		}
+		if errors.Is(err, errAIToolAddressBlocked) {
+			return "", errAIToolAddressBlocked
+		}
+		return "", fmt.Errorf("tool call failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, int64(tool.MaxResponseBytes)+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read tool response: %w", err)
+	}
+	if len(content) > tool.MaxResponseBytes {
+		return "", fmt.Errorf("tool response exceeds %d bytes", tool.MaxResponseBytes)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("tool returned status %d", resp.StatusCode)
+	}
+	return string(content), nil
+}
+
+// checkAIToolArguments checks arguments against the required properties and the top-level types of a
+// tool's schema
+func checkAIToolArguments(schema models.JSONMap, arguments map[string]interface{}) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := arguments[key]; !present {
+					return fmt.Errorf("missing required argument %s", key)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for key, value := range arguments {
+		property, ok := properties[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		expected, _ := property["type"].(string)
+		if expected != "" && !jsonTypeMatches(expected, value) {
+			return fmt.Errorf("argument %s must be of type %s", key, expected)
+		}
+	}
+	return nil
+}
+
+func jsonTypeMatches(expected string, value interface{}) bool {
+	switch expected {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == float64(int64(number))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	default:
+		return true
+	}
+}
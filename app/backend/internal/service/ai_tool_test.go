@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/crypto"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeAIToolStore struct {
+	tools []*models.AITool
+}
+
+func (f *fakeAIToolStore) Create(ctx context.Context, tool *models.AITool) error {
+	for _, existing := range f.tools {
+		if existing.ProjectID == tool.ProjectID && existing.Name == tool.Name {
+			return &pq.Error{Code: "23505"}
+		}
+	}
+	tool.ID = uuid.New()
+	f.tools = append(f.tools, tool)
+	return nil
+}
+
+func (f *fakeAIToolStore) GetByID(ctx context.Context, tenantID, projectID, toolID uuid.UUID) (*models.AITool, error) {
+	for _, tool := range f.tools {
+		if tool.TenantID == tenantID && tool.ProjectID == projectID && tool.ID == toolID {
+			return tool, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAIToolStore) ListByProject(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AITool, error) {
+	return f.tools, nil
+}
+
+func (f *fakeAIToolStore) ListEnabled(ctx context.Context, tenantID, projectID uuid.UUID) ([]*models.AITool, error) {
+	var enabled []*models.AITool
+	for _, tool := range f.tools {
+		if tool.Enabled {
+			enabled = append(enabled, tool)
+		}
+	}
+	return enabled, nil
+}
+
+func (f *fakeAIToolStore) Update(ctx context.Context, tool *models.AITool) error {
+	return nil
+}
+
+func (f *fakeAIToolStore) Delete(ctx context.Context, tenantID, projectID, toolID uuid.UUID) error {
+	return nil
+}
+
+func newTestAIToolService(t *testing.T) *AIToolService {
+	encryption, err := crypto.NewPasswordEncryption()
+	require.NoError(t, err)
+	return NewAIToolService(&fakeAIToolStore{}, nil, nil, nil, encryption)
+}
+
+func TestCreateToolValidatesAndEncryptsHeaders(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	svc := newTestAIToolService(t)
+
+	request := func() *models.AIToolRequest {
+		return &models.AIToolRequest{
+			Name:        strRef("order_status"),
+			Description: strRef("Look up an order"),
+			URL:         strRef("https://api.example.com/orders"),
+		}
+	}
+
+	req := request()
+	req.Name = strRef(aiToolHandoff)
+	_, err := svc.CreateTool(ctx, tenantID, projectID, req)
+	require.ErrorIs(t, err, ErrInvalidAITool)
+
+	req = request()
+	req.Parameters = map[string]interface{}{"type": "string"}
+	_, err = svc.CreateTool(ctx, tenantID, projectID, req)
+	require.ErrorIs(t, err, ErrInvalidAITool)
+
+	req = request()
+	req.Headers = map[string]string{"X-Visitor-Id": "spoofed"}
+	_, err = svc.CreateTool(ctx, tenantID, projectID, req)
+	require.ErrorIs(t, err, ErrInvalidAITool)
+
+	req = request()
+	req.URL = strRef("ftp://api.example.com/orders")
+	_, err = svc.CreateTool(ctx, tenantID, projectID, req)
+	require.ErrorIs(t, err, ErrInvalidAITool)
+
+	req = request()
+	req.Headers = map[string]string{"authorization": "Bearer s3cret"}
+	tool, err := svc.CreateTool(ctx, tenantID, projectID, req)
+	require.NoError(t, err)
+	require.Equal(t, http.MethodPost, tool.Method)
+	require.Equal(t, pq.StringArray{"Authorization"}, tool.HeaderNames)
+	require.NotNil(t, tool.HeadersEncrypted)
+	require.NotContains(t, *tool.HeadersEncrypted, "s3cret")
+
+	_, err = svc.CreateTool(ctx, tenantID, projectID, request())
+	require.ErrorIs(t, err, ErrAIToolExists)
+}
+
+func TestCallHTTPToolEnforcesLimitsAndPublicAddresses(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer server.Close()
+
+	svc := newTestAIToolService(t)
+	tool := &models.AITool{
+		Name:             "order_status",
+		Method:           http.MethodGet,
+		URL:              server.URL,
+		Parameters:       models.JSONMap{"type": "object", "required": []interface{}{"order_id"}},
+		TimeoutMs:        1000,
+		MaxResponseBytes: 500,
+	}
+
+	// Loopback addresses are refused by the default client
+	_, err := svc.callHTTPTool(ctx, tool, nil, map[string]interface{}{"order_id": "A1"})
+	require.ErrorIs(t, err, errAIToolAddressBlocked)
+
+	svc.client = server.Client()
+	_, err = svc.callHTTPTool(ctx, tool, nil, map[string]interface{}{})
+	require.ErrorContains(t, err, "missing required argument order_id")
+
+	_, err = svc.callHTTPTool(ctx, tool, nil, map[string]interface{}{"order_id": "A1"})
+	require.ErrorContains(t, err, "exceeds 500 bytes")
+}
+
+func TestCompleteWithProfileRunsToolCallsAndRecordsInvocations(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+
+	// The tenant's order API, called with the verified visitor
+	orderAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "A1", r.URL.Query().Get("order_id"))
+		require.Equal(t, "Bearer s3cret", r.Header.Get("Authorization"))
+		require.Equal(t, "user-42", r.Header.Get("X-Visitor-Id"))
+		w.Write([]byte(`{"status":"shipped"}`))
+	}))
+	defer orderAPI.Close()
+
+	var requests []ChatCompletionRequest
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"order_status","arguments":"{\"order_id\":\"A1\"}"}}]}}],"usage":{"prompt_tokens":50,"completion_tokens":10,"total_tokens":60}}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Your order has shipped."}}],"usage":{"prompt_tokens":70,"completion_tokens":6,"total_tokens":76}}`))
+	}))
+	defer llm.Close()
+
+	toolService := newTestAIToolService(t)
+	toolService.client = orderAPI.Client()
+	verified := true
+	_, err := toolService.CreateTool(ctx, tenantID, projectID, &models.AIToolRequest{
+		Name:                    strRef("order_status"),
+		Description:             strRef("Look up an order"),
+		URL:                     strRef(orderAPI.URL),
+		Method:                  strRef("GET"),
+		Parameters:              map[string]interface{}{"type": "object", "properties": map[string]interface{}{"order_id": map[string]interface{}{"type": "string"}}},
+		Headers:                 map[string]string{"Authorization": "Bearer s3cret"},
+		RequiresVerifiedVisitor: &verified,
+	})
+	require.NoError(t, err)
+
+	// Anonymous visitors are not offered tools that need a verified visitor
+	session := &models.ChatSession{ID: uuid.New(), TenantID: tenantID, ProjectID: projectID}
+	tools, err := toolService.Toolset(ctx, session)
+	require.NoError(t, err)
+	require.False(t, tools.defined("order_status"))
+
+	session.IdentityVerified = true
+	session.VisitorInfo = models.JSONMap{"verified_user_id": "user-42"}
+	tools, err = toolService.Toolset(ctx, session)
+	require.NoError(t, err)
+	require.True(t, tools.defined("order_status"))
+	require.True(t, tools.defined(aiToolHandoff))
+
+	cfg := &config.AIConfig{Enabled: true, Provider: "openai", BaseURL: llm.URL, Model: "gpt-4o"}
+	svc := &AIService{config: cfg, llm: NewLLMRouter(cfg, llm.Client())}
+	completion, err := svc.completeWithProfile(ctx, globalAIProfile(cfg), []ChatCompletionMessage{
+		{Role: "user", Content: "Where is order A1?"},
+	}, tools, nil)
+	require.NoError(t, err)
+	require.Equal(t, "Your order has shipped.", completion.Content)
+	require.Equal(t, int64(136), completion.Usage.TotalTokens)
+
+	require.Len(t, requests, 2)
+	require.NotEmpty(t, requests[0].Tools)
+	toolResult := requests[1].Messages[len(requests[1].Messages)-1]
+	require.Equal(t, "tool", toolResult.Role)
+	require.Equal(t, "call_1", toolResult.ToolCallID)
+	require.Equal(t, `{"status":"shipped"}`, toolResult.Content)
+
+	require.Len(t, tools.invocations, 1)
+	require.Equal(t, "order_status", tools.invocations[0].Tool)
+	require.Equal(t, models.AIToolSourceHTTP, tools.invocations[0].Source)
+	require.Equal(t, "ok", tools.invocations[0].Status)
+	require.JSONEq(t, `{"order_id":"A1"}`, string(tools.invocations[0].Arguments))
+}
+
+type fakeAIToolTicketConverter struct{}
+
+func (fakeAIToolTicketConverter) ConvertSession(ctx context.Context, session *models.ChatSession, agentID *uuid.UUID) (*db.Ticket, error) {
+	return &db.Ticket{Number: 7}, nil
+}
+
+func TestCreateTicketToolNeedsAVerifiedVisitor(t *testing.T) {
+	ctx := context.Background()
+	encryption, err := crypto.NewPasswordEncryption()
+	require.NoError(t, err)
+	toolService := NewAIToolService(&fakeAIToolStore{}, nil, fakeAIToolTicketConverter{}, nil, encryption)
+
+	// An address the visitor typed in is not enough to email them a ticket link
+	email := "visitor@example.com"
+	session := &models.ChatSession{ID: uuid.New(), TenantID: uuid.New(), ProjectID: uuid.New(), CustomerEmail: &email}
+	tools, err := toolService.Toolset(ctx, session)
+	require.NoError(t, err)
+	require.False(t, tools.defined(aiToolCreateTicket))
+
+	session.IdentityVerified = true
+	tools, err = toolService.Toolset(ctx, session)
+	require.NoError(t, err)
+	require.True(t, tools.defined(aiToolCreateTicket))
+}
+
+func TestHandoffToolEndsTheToolLoop(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"hand_off_to_human","arguments":"{\"reason\":\"wants a refund\"}"}}]}}]}`))
+	}))
+	defer llm.Close()
+
+	tools, err := newTestAIToolService(t).Toolset(ctx, &models.ChatSession{ID: uuid.New()})
+	require.NoError(t, err)
+
+	cfg := &config.AIConfig{Enabled: true, Provider: "openai", BaseURL: llm.URL, Model: "gpt-4o"}
+	svc := &AIService{config: cfg, llm: NewLLMRouter(cfg, llm.Client())}
+	completion, err := svc.completeWithProfile(ctx, globalAIProfile(cfg), []ChatCompletionMessage{
+		{Role: "user", Content: "I want my money back"},
+	}, tools, nil)
+	require.NoError(t, err)
+	require.Empty(t, completion.Content)
+	require.Equal(t, 1, calls)
+	require.True(t, tools.handedOff())
+	require.Equal(t, "wants a refund", tools.handoffReason)
+}
+
+func TestAnthropicRequestGroupsToolResults(t *testing.T) {
+	provider := &anthropicProvider{}
+	req := provider.request(ChatCompletionRequest{
+		Messages: []ChatCompletionMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Where are my orders?"},
+			{Role: "assistant", Content: "Checking.", ToolCalls: []ToolCall{
+				{ID: "a", Type: "function", Function: ToolCallFunction{Name: "order_status", Arguments: `{"order_id":"A1"}`}},
+				{ID: "b", Type: "function", Function: ToolCallFunction{Name: "order_status", Arguments: `{"order_id":"B2"}`}},
+			}},
+			{Role: "tool", ToolCallID: "a", Content: "shipped"},
+			{Role: "tool", ToolCallID: "b", Content: "pending"},
+		},
+		Tools: []ChatTool{{Type: "function", Function: ChatToolFunction{Name: "order_status", Parameters: map[string]interface{}{"type": "object"}}}},
+	}, false)
+
+	require.Equal(t, "Be brief.", req.System)
+	require.Len(t, req.Tools, 1)
+	require.Len(t, req.Messages, 3)
+
+	assistant := req.Messages[1].Content.([]anthropicContentBlock)
+	require.Len(t, assistant, 3)
+	require.Equal(t, "tool_use", assistant[1].Type)
+	require.JSONEq(t, `{"order_id":"A1"}`, string(assistant[1].Input))
+
+	results := req.Messages[2].Content.([]anthropicContentBlock)
+	require.Equal(t, "user", req.Messages[2].Role)
+	require.Len(t, results, 2)
+	require.Equal(t, "b", results[1].ToolUseID)
+	require.Equal(t, "pending", results[1].Content)
+}
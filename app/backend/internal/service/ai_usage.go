@@ -2,9 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 
+	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/models"
 	"github.com/bareuptime/tms/internal/repo"
 	"github.com/google/uuid"
@@ -21,6 +23,7 @@ type TokenUsageMetrics struct {
 type UsageDeductionInput struct {
 	TenantID  uuid.UUID
 	ProjectID uuid.UUID
+	Provider  string
 	Model     string
 	SessionID *uuid.UUID
 	RequestID string
@@ -39,19 +42,36 @@ type UsageDeductionResult struct {
 // AIUsageService handles converting token usage into credit deductions.
 type AIUsageService struct {
 	creditsRepo   repo.CreditsRepository
+	planService   *PlanService
 	markupPercent float64
 }
 
 const defaultMarkupPercent = 0.21 // 21% markup on consumed tokens
 
 // NewAIUsageService creates a new instance of AIUsageService.
-func NewAIUsageService(creditsRepo repo.CreditsRepository) *AIUsageService {
+func NewAIUsageService(creditsRepo repo.CreditsRepository, planService *PlanService) *AIUsageService {
 	return &AIUsageService{
 		creditsRepo:   creditsRepo,
+		planService:   planService,
 		markupPercent: defaultMarkupPercent,
 	}
 }
 
+// HasAvailableCredits reports whether the tenant may use AI replies. Only a credits record with no
+// balance left is a hard stop; tenants without a credits record are not metered.
+func (s *AIUsageService) HasAvailableCredits(ctx context.Context, tenantID uuid.UUID) (bool, error) {
+	if s == nil || s.creditsRepo == nil {
+		return false, fmt.Errorf("usage service not configured")
+	}
+
+	credits, err := s.creditsRepo.GetByTenantID(ctx, tenantID)
+	if err != nil {
+		return false, err
+	}
+
+	return credits == nil || credits.Balance > 0, nil
+}
+
 // DeductUsage converts the supplied token metrics into a credit deduction and records it.
 func (s *AIUsageService) DeductUsage(ctx context.Context, input UsageDeductionInput) (*UsageDeductionResult, error) {
 	if s == nil || s.creditsRepo == nil {
@@ -81,6 +101,10 @@ func (s *AIUsageService) DeductUsage(ctx context.Context, input UsageDeductionIn
 		s.markupPercent*100,
 	)
 
+	if input.Provider != "" {
+		description = fmt.Sprintf("%s | provider=%s", description, input.Provider)
+	}
+
 	if input.SessionID != nil {
 		description = fmt.Sprintf("%s | session=%s", description, input.SessionID.String())
 	}
@@ -90,10 +114,19 @@ func (s *AIUsageService) DeductUsage(ctx context.Context, input UsageDeductionIn
 	}
 
 	tx, err := s.creditsRepo.DeductCredits(ctx, input.TenantID, chargedCredits, models.TransactionTypeAIUsage, description)
+	if errors.Is(err, repo.ErrInsufficientCredits) {
+		// The reply has already been generated, so drain what is left to reach the zero-balance hard stop.
+		tx, err = s.drainRemainingCredits(ctx, input.TenantID, description)
+		if tx != nil {
+			chargedCredits = -tx.Amount
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	s.planService.CheckLowBalance(ctx, input.TenantID, tx.BalanceAfter)
+
 	result := &UsageDeductionResult{
 		TransactionID:  tx.ID,
 		ChargedCredits: chargedCredits,
@@ -105,6 +138,19 @@ func (s *AIUsageService) DeductUsage(ctx context.Context, input UsageDeductionIn
 	return result, nil
 }
 
+func (s *AIUsageService) drainRemainingCredits(ctx context.Context, tenantID uuid.UUID, description string) (*db.CreditTransaction, error) {
+	credits, err := s.creditsRepo.GetByTenantID(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if credits == nil || credits.Balance <= 0 {
+		return nil, fmt.Errorf("%w: balance exhausted", repo.ErrInsufficientCredits)
+	}
+
+	return s.creditsRepo.DeductCredits(ctx, tenantID, credits.Balance, models.TransactionTypeAIUsage,
+		fmt.Sprintf("%s | partial charge, balance exhausted", description))
+}
+
 func (s *AIUsageService) calculateCharge(totalTokens int64) int64 {
 	multiplier := 1 + s.markupPercent
 	charged := math.Ceil(float64(totalTokens) * multiplier)
@@ -17,6 +17,11 @@ type mockCreditsRepository struct {
 	result         *db.CreditTransaction
 	err            error
 	calls          int
+	credits        *db.Credits
+}
+
+func (m *mockCreditsRepository) GetByTenantID(ctx context.Context, tenantID uuid.UUID) (*db.Credits, error) {
+	return m.credits, nil
 }
 
 func (m *mockCreditsRepository) DeductCredits(ctx context.Context, tenantID uuid.UUID, amount int64, transactionType, description string) (*db.CreditTransaction, error) {
@@ -39,7 +44,7 @@ func TestAIUsageServiceDeductUsageSuccess(t *testing.T) {
 		},
 	}
 
-	svc := NewAIUsageService(creditsRepo)
+	svc := NewAIUsageService(creditsRepo, nil)
 
 	res, err := svc.DeductUsage(context.Background(), UsageDeductionInput{
 		TenantID: tenantID,
@@ -61,7 +66,7 @@ func TestAIUsageServiceDeductUsageSuccess(t *testing.T) {
 func TestAIUsageServiceRejectsZeroUsage(t *testing.T) {
 	t.Parallel()
 
-	svc := NewAIUsageService(&mockCreditsRepository{})
+	svc := NewAIUsageService(&mockCreditsRepository{}, nil)
 
 	_, err := svc.DeductUsage(context.Background(), UsageDeductionInput{
 		TenantID: uuid.New(),
@@ -80,7 +85,7 @@ func TestAIUsageServicePropagatesRepositoryError(t *testing.T) {
 		err: expectedErr,
 	}
 
-	svc := NewAIUsageService(creditsRepo)
+	svc := NewAIUsageService(creditsRepo, nil)
 
 	_, err := svc.DeductUsage(context.Background(), UsageDeductionInput{
 		TenantID: uuid.New(),
@@ -93,3 +98,24 @@ func TestAIUsageServicePropagatesRepositoryError(t *testing.T) {
 	require.Error(t, err)
 	require.ErrorIs(t, err, expectedErr)
 }
+
+func TestAIUsageServiceHasAvailableCredits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tenantID := uuid.New()
+
+	// Tenants without a credits record are not cut off from AI replies
+	hasCredits, err := NewAIUsageService(&mockCreditsRepository{}, nil).HasAvailableCredits(ctx, tenantID)
+	require.NoError(t, err)
+	require.True(t, hasCredits)
+
+	hasCredits, err = NewAIUsageService(&mockCreditsRepository{credits: &db.Credits{Balance: 10}}, nil).HasAvailableCredits(ctx, tenantID)
+	require.NoError(t, err)
+	require.True(t, hasCredits)
+
+	// An exhausted balance is a hard stop
+	hasCredits, err = NewAIUsageService(&mockCreditsRepository{credits: &db.Credits{Balance: 0}}, nil).HasAvailableCredits(ctx, tenantID)
+	require.NoError(t, err)
+	require.False(t, hasCredits)
+}
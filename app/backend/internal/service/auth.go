@@ -35,6 +35,8 @@ type AuthService struct {
 	tenantRepo    repo.TenantRepository
 	projectRepo   repo.ProjectRepository
 	googleOAuth   *oauth2.Config
+	mfaService    *MFAService
+	ssoService    *SSOService
 }
 
 // FeatureFlags represents the feature configuration
@@ -58,6 +60,16 @@ func NewAuthService(agentRepo repo.AgentRepository, rbacService *rbac.Service, a
 	}
 }
 
+// SetMFAService enables the second factor check on agent logins
+func (s *AuthService) SetMFAService(mfaService *MFAService) {
+	s.mfaService = mfaService
+}
+
+// SetSSOService enables single sign-on logins and the per-tenant password login switch
+func (s *AuthService) SetSSOService(ssoService *SSOService) {
+	s.ssoService = ssoService
+}
+
 // Personal/Consumer email domains that should be blocked for corporate signup
 var blockedEmailDomains = map[string]bool{
 	// Google
@@ -208,6 +220,10 @@ type LoginResponse struct {
 	RefreshToken string              `json:"refresh_token"`
 	Agent        *db.Agent           `json:"agent"`
 	RoleBindings map[string][]string `json:"role_bindings"`
+	// MFAChallenge is set instead of the tokens when the agent must pass a second factor
+	MFAChallenge *MFAChallenge `json:"mfa_challenge,omitempty"`
+	// RecoveryCodes are returned once when enrollment completes during login
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
 // Login authenticates an agent and returns tokens
@@ -229,6 +245,10 @@ func (s *AuthService) Login(ctx context.Context, req LoginRequest) (*LoginRespon
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
+	if err := s.ensurePasswordLoginAllowed(ctx, agent.TenantID); err != nil {
+		return nil, err
+	}
+
 	// Get role bindings
 	roleBindings, err := s.rbacService.GetAgentRoleBindings(ctx, agent.ID, agent.TenantID)
 	if err != nil {
@@ -237,6 +257,11 @@ func (s *AuthService) Login(ctx context.Context, req LoginRequest) (*LoginRespon
 
 	fmt.Println("Role bindings:", roleBindings)
 
+	// Agents with a second factor get a challenge instead of tokens
+	if challengeResponse, err := s.mfaChallenge(ctx, agent, roleBindings); err != nil || challengeResponse != nil {
+		return challengeResponse, err
+	}
+
 	// Generate tokens
 	accessToken, err := s.authService.GenerateAccessToken(
 		agent.ID.String(),
@@ -267,6 +292,112 @@ func (s *AuthService) Login(ctx context.Context, req LoginRequest) (*LoginRespon
 	}, nil
 }
 
+// ensurePasswordLoginAllowed rejects credential logins for tenants that only allow single sign-on
+func (s *AuthService) ensurePasswordLoginAllowed(ctx context.Context, tenantID uuid.UUID) error {
+	if s.ssoService == nil {
+		return nil
+	}
+
+	allowed, err := s.ssoService.PasswordLoginAllowed(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrPasswordLoginDisabled
+	}
+	return nil
+}
+
+// mfaChallenge returns a login response carrying an MFA challenge when the agent must pass a second factor
+func (s *AuthService) mfaChallenge(ctx context.Context, agent *db.Agent, roleBindings []*db.RoleBinding) (*LoginResponse, error) {
+	if s.mfaService == nil {
+		return nil, nil
+	}
+
+	challenge, err := s.mfaService.BeginLoginChallenge(ctx, agent, roleBindings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start MFA challenge: %w", err)
+	}
+	if challenge == nil {
+		return nil, nil
+	}
+
+	agent.PasswordHash = nil
+	return &LoginResponse{
+		Agent:        agent,
+		MFAChallenge: challenge,
+	}, nil
+}
+
+// VerifyMFARequest represents the second step of a login that requires MFA
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// StartMFALoginEnrollment issues a TOTP secret to an agent the tenant policy forces to enroll during login
+func (s *AuthService) StartMFALoginEnrollment(ctx context.Context, mfaToken string) (*MFAEnrollment, error) {
+	if s.mfaService == nil {
+		return nil, ErrMFAChallengeExpired
+	}
+	return s.mfaService.StartChallengeEnrollment(ctx, mfaToken)
+}
+
+// VerifyMFA completes a login challenge with a TOTP or recovery code and returns tokens
+func (s *AuthService) VerifyMFA(ctx context.Context, req VerifyMFARequest) (*LoginResponse, error) {
+	if s.mfaService == nil {
+		return nil, ErrMFAChallengeExpired
+	}
+
+	verification, err := s.mfaService.VerifyChallenge(ctx, req.MFAToken, req.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	agent, err := s.agentRepo.GetByID(ctx, verification.TenantID, verification.AgentID)
+	if err != nil || agent == nil {
+		return nil, fmt.Errorf("agent not found")
+	}
+
+	if agent.Status != "active" {
+		return nil, fmt.Errorf("account is not active")
+	}
+
+	roleBindings, err := s.rbacService.GetAgentRoleBindings(ctx, agent.ID, agent.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role bindings: %w", err)
+	}
+
+	accessToken, err := s.authService.GenerateAccessToken(
+		agent.ID.String(),
+		agent.TenantID.String(),
+		agent.Email,
+		s.convertRoleBindings(roleBindings),
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	refreshToken, err := s.authService.GenerateRefreshToken(
+		agent.ID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	// Remove password hash from response
+	agent.PasswordHash = nil
+
+	return &LoginResponse{
+		AccessToken:   accessToken,
+		RefreshToken:  refreshToken,
+		Agent:         agent,
+		RoleBindings:  s.convertRoleBindings(roleBindings),
+		RecoveryCodes: verification.RecoveryCodes,
+	}, nil
+}
+
 func (s *AuthService) AiAgentLogin(ctx context.Context, req LoginRequest, tenantID, projectID uuid.UUID) (*LoginResponse, error) {
 
 	// Get agent by email
@@ -482,6 +613,11 @@ func (s *AuthService) ConsumeMagicLink(ctx context.Context, req ConsumeMagicLink
 		return nil, fmt.Errorf("failed to get role bindings: %w", err)
 	}
 
+	// Agents with a second factor get a challenge instead of tokens
+	if challengeResponse, err := s.mfaChallenge(ctx, agent, roleBindings); err != nil || challengeResponse != nil {
+		return challengeResponse, err
+	}
+
 	// Generate tokens
 	accessToken, err := s.authService.GenerateAccessToken(
 		agent.ID.String(),
@@ -851,6 +987,11 @@ func (s *AuthService) GoogleOAuthCallback(ctx context.Context, code string) (*Lo
 		return s.createAgentFromGoogle(ctx, googleUser)
 	}
 
+	// Tenants that enforce SSO only accept logins through their identity provider
+	if err := s.ensurePasswordLoginAllowed(ctx, agent.TenantID); err != nil {
+		return nil, err
+	}
+
 	// Agent exists, login
 	return s.loginExistingAgent(ctx, agent)
 }
@@ -893,65 +1034,94 @@ func (s *AuthService) createAgentFromGoogle(ctx context.Context, googleUser Goog
 		return nil, fmt.Errorf("failed to create project: %w", err)
 	}
 
-	// Create agent account (no password for OAuth users)
+	agent, err := s.provisionAgent(ctx, tenantID, googleUser.Email, googleUser.Name,
+		[]RoleAssignment{{ProjectID: projectID, Role: models.RoleTenantAdmin}})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.loginExistingAgent(ctx, agent)
+}
+
+// provisionAgent creates a password-less agent for an external identity, grants its roles
+// and sends the welcome email
+func (s *AuthService) provisionAgent(ctx context.Context, tenantID uuid.UUID, email, name string, assignments []RoleAssignment) (*db.Agent, error) {
+	// Create agent account (no password for OAuth and SSO users)
 	agent := &db.Agent{
 		ID:           uuid.New(),
 		TenantID:     tenantID,
-		Email:        googleUser.Email,
-		Name:         googleUser.Name,
+		Email:        email,
+		Name:         name,
 		Status:       "active",
-		PasswordHash: nil, // No password for OAuth users
+		PasswordHash: nil, // No password for OAuth and SSO users
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
-	err = s.agentRepo.Create(ctx, agent)
+	err := s.agentRepo.Create(ctx, agent)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create agent: %w", err)
 	}
 
-	// Assign tenant admin role
-	err = s.rbacService.AssignRole(ctx, agent.ID, agent.TenantID, projectID, models.RoleTenantAdmin)
-	if err != nil {
-		fmt.Printf("Warning: failed to assign default role to agent %s: %v\n", agent.ID, err)
+	for _, assignment := range assignments {
+		err = s.rbacService.AssignRole(ctx, agent.ID, agent.TenantID, assignment.ProjectID, assignment.Role)
+		if err != nil {
+			fmt.Printf("Warning: failed to assign role %s to agent %s: %v\n", assignment.Role, agent.ID, err)
+		}
 	}
 
-	// Get role bindings
-	roleBindings, err := s.rbacService.GetAgentRoleBindings(ctx, agent.ID, agent.TenantID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get role bindings: %w", err)
+	// Send welcome email
+	if err := s.emailProvider.SendSignupWelcomeEmail(ctx, agent.Email, agent.Name); err != nil {
+		fmt.Printf("Warning: failed to send signup welcome email to %s: %v\n", agent.Email, err)
 	}
 
-	// Generate tokens
-	accessToken, err := s.authService.GenerateAccessToken(
-		agent.ID.String(),
-		agent.TenantID.String(),
-		agent.Email,
-		s.convertRoleBindings(roleBindings),
-		nil,
-	)
+	return agent, nil
+}
+
+// CompleteSSOLogin signs in the agent behind a verified SSO identity. Unknown agents are
+// created when the provider allows just-in-time provisioning, and the roles of existing
+// agents follow their IdP groups whenever a group mapping matches.
+func (s *AuthService) CompleteSSOLogin(ctx context.Context, identity *SSOIdentity) (*LoginResponse, error) {
+	if s.ssoService == nil {
+		return nil, ErrSSOProviderNotFound
+	}
+	provider := identity.Provider
+
+	assignments, matched, err := s.ssoService.ResolveRoleAssignments(ctx, provider, identity.Groups)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
+		return nil, err
 	}
 
-	refreshToken, err := s.authService.GenerateRefreshToken(
-		agent.ID.String(),
-	)
+	agent, err := s.agentRepo.GetByEmailWithoutTenantID(ctx, identity.Email)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+		// Agent doesn't exist, create it in the provider's tenant
+		if !provider.JITProvisioning {
+			return nil, ErrSSONotProvisioned
+		}
+
+		agent, err = s.provisionAgent(ctx, provider.TenantID, identity.Email, identity.Name, assignments)
+		if err != nil {
+			return nil, err
+		}
+		logger.InfofCtx(ctx, "SSO agent provisioned - tenant_id: %s, agent_id: %s, provider_id: %s, roles: %d",
+			provider.TenantID, agent.ID, provider.ID, len(assignments))
+
+		return s.loginExistingAgent(ctx, agent)
 	}
 
-	// Send welcome email
-	if err := s.emailProvider.SendSignupWelcomeEmail(ctx, agent.Email, agent.Name); err != nil {
-		fmt.Printf("Warning: failed to send signup welcome email to %s: %v\n", agent.Email, err)
+	if agent.TenantID != provider.TenantID {
+		return nil, ErrSSOAccountConflict
 	}
 
-	return &LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		Agent:        agent,
-		RoleBindings: s.convertRoleBindings(roleBindings),
-	}, nil
+	if matched {
+		for _, assignment := range assignments {
+			if err := s.rbacService.AssignRole(ctx, agent.ID, agent.TenantID, assignment.ProjectID, assignment.Role); err != nil {
+				return nil, fmt.Errorf("failed to sync roles: %w", err)
+			}
+		}
+	}
+
+	return s.loginExistingAgent(ctx, agent)
 }
 
 // loginExistingAgent logs in an existing agent
@@ -967,6 +1137,11 @@ func (s *AuthService) loginExistingAgent(ctx context.Context, agent *db.Agent) (
 		return nil, fmt.Errorf("failed to get role bindings: %w", err)
 	}
 
+	// Agents with a second factor get a challenge instead of tokens
+	if challengeResponse, err := s.mfaChallenge(ctx, agent, roleBindings); err != nil || challengeResponse != nil {
+		return challengeResponse, err
+	}
+
 	// Generate tokens
 	accessToken, err := s.authService.GenerateAccessToken(
 		agent.ID.String(),
@@ -1072,6 +1247,11 @@ func (s *AuthService) GoogleIDTokenCallback(ctx context.Context, idToken string)
 		return s.createAgentFromGoogle(ctx, googleUser)
 	}
 
+	// Tenants that enforce SSO only accept logins through their identity provider
+	if err := s.ensurePasswordLoginAllowed(ctx, agent.TenantID); err != nil {
+		return nil, err
+	}
+
 	// Agent exists, login
 	return s.loginExistingAgent(ctx, agent)
 }
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/websocket"
+)
+
+const (
+	// chatResumeBatchSize caps the messages replayed per resume; the client resumes again from the last one
+	chatResumeBatchSize = 200
+
+	// chatDeliveryRetention keeps acknowledgements around for visitors coming back to a session
+	chatDeliveryRetention = 7 * 24 * time.Hour
+
+	// ChatRecipientVisitor acknowledges deliveries on behalf of the visitor of a session
+	ChatRecipientVisitor = "visitor"
+)
+
+// acknowledgeScript raises the acknowledged sequence number of a recipient, never lowering it, and
+// returns 1 when it moved
+var acknowledgeScript = goredis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+local seq = tonumber(ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+if seq <= current then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], seq)
+return 1
+`)
+
+// ChatResume is what a client missed while it was disconnected
+type ChatResume struct {
+	Messages []*models.ChatMessage `json:"messages"`
+	LastSeq  int64                 `json:"last_seq"` // sequence number to resume from next time
+	HasMore  bool                  `json:"has_more"`
+}
+
+func chatDeliveryKey(sessionID uuid.UUID) string {
+	return fmt.Sprintf("chat:delivery:%s", sessionID)
+}
+
+// GetMessagesAfter returns the messages of a session stored after the given sequence number, oldest first
+func (s *ChatSessionService) GetMessagesAfter(ctx context.Context, sessionID uuid.UUID, afterSeq int64, includePrivate bool) (*ChatResume, error) {
+	messages, err := s.chatMessageRepo.ListChatMessagesAfter(ctx, sessionID, afterSeq, includePrivate, chatResumeBatchSize+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list missed messages: %w", err)
+	}
+
+	resume := &ChatResume{Messages: messages, LastSeq: afterSeq}
+	if len(messages) > chatResumeBatchSize {
+		resume.Messages = messages[:chatResumeBatchSize]
+		resume.HasMore = true
+	}
+	if len(resume.Messages) > 0 {
+		resume.LastSeq = resume.Messages[len(resume.Messages)-1].Seq
+	} else {
+		resume.Messages = []*models.ChatMessage{}
+	}
+	return resume, nil
+}
+
+// ReplayMessages sends a visitor connection the messages it missed after afterSeq, followed by a
+// resume_complete event telling the client where to resume from next
+func (s *ChatSessionService) ReplayMessages(ctx context.Context, session *models.ChatSession, afterSeq int64, connID string) (*ChatResume, error) {
+	resume, err := s.GetMessagesAfter(ctx, session.ID, afterSeq, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.connectionManager == nil {
+		return resume, nil
+	}
+
+	for _, message := range resume.Messages {
+		messageData, _ := json.Marshal(message)
+		s.connectionManager.SendToConnection(connID, &websocket.Message{
+			Type:         string(models.WSMsgTypeChatMessage),
+			SessionID:    session.ID,
+			Data:         messageData,
+			FromType:     chatMessageFromType(message.AuthorType),
+			ProjectID:    &session.ProjectID,
+			TenantID:     &session.TenantID,
+			DeliveryType: websocket.Self,
+		})
+	}
+
+	completeData, _ := json.Marshal(map[string]interface{}{
+		"last_seq": resume.LastSeq,
+		"has_more": resume.HasMore,
+	})
+	s.connectionManager.SendToConnection(connID, &websocket.Message{
+		Type:         string(models.WSMsgTypeResumeComplete),
+		SessionID:    session.ID,
+		Data:         completeData,
+		FromType:     websocket.ConnectionTypeVisitor,
+		DeliveryType: websocket.Self,
+	})
+	return resume, nil
+}
+
+// AcknowledgeDelivery records that a recipient received every message of a session up to seq. The first
+// time a visitor acknowledges a message, the agents are told it was delivered.
+func (s *ChatSessionService) AcknowledgeDelivery(ctx context.Context, session *models.ChatSession, recipient string, seq int64) error {
+	if seq <= 0 {
+		return nil
+	}
+
+	advanced, err := acknowledgeScript.Run(ctx, s.redisService.GetClient(), []string{chatDeliveryKey(session.ID)},
+		recipient, seq, int(chatDeliveryRetention.Seconds())).Int()
+	if err != nil {
+		return fmt.Errorf("failed to record delivery: %w", err)
+	}
+	if advanced == 0 || recipient != ChatRecipientVisitor || s.connectionManager == nil {
+		return nil
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"session_id": session.ID,
+		"seq":        seq,
+	})
+	// Visitor-side messages without an agent reach every agent of the project, like visitor messages do
+	noAgent := uuid.Nil
+	msg := &websocket.Message{
+		Type:         string(models.WSMsgTypeMessageDelivered),
+		SessionID:    session.ID,
+		Data:         data,
+		FromType:     websocket.ConnectionTypeVisitor,
+		ProjectID:    &session.ProjectID,
+		TenantID:     &session.TenantID,
+		AgentID:      &noAgent,
+		DeliveryType: websocket.Direct,
+		Timestamp:    time.Now(),
+	}
+	return s.connectionManager.DeliverWebSocketMessage(session.ID, msg)
+}
+
+// AcknowledgedSeq returns the sequence number a recipient acknowledged last, zero when they have not
+func (s *ChatSessionService) AcknowledgedSeq(ctx context.Context, sessionID uuid.UUID, recipient string) (int64, error) {
+	seq, err := s.redisService.GetClient().HGet(ctx, chatDeliveryKey(sessionID), recipient).Int64()
+	if err == goredis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read delivery: %w", err)
+	}
+	return seq, nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/redis"
+	"github.com/bareuptime/tms/internal/repo"
+)
+
+func newTestChatDeliveryService(t *testing.T) (*ChatSessionService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	t.Cleanup(func() { sqlxDB.Close() })
+
+	mini, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mini.Close)
+
+	redisSvc := redis.NewService(redis.RedisConfig{
+		URL:         fmt.Sprintf("redis://%s", mini.Addr()),
+		Environment: "test",
+	})
+
+	return &ChatSessionService{
+		chatMessageRepo: repo.NewChatMessageRepo(sqlxDB),
+		redisService:    redisSvc,
+	}, mock
+}
+
+func chatMessageRows(sessionID uuid.UUID, seqs ...int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"id", "tenant_id", "project_id", "session_id", "seq", "message_type", "content",
+		"author_type", "author_id", "author_name", "metadata", "is_private",
+		"read_by_visitor", "read_by_agent", "read_at", "created_at",
+	})
+	for _, seq := range seqs {
+		rows.AddRow(uuid.New(), uuid.New(), uuid.New(), sessionID, seq, "text", fmt.Sprintf("message %d", seq),
+			"agent", nil, "Ada", []byte(`{}`), false, false, true, nil, time.Now())
+	}
+	return rows
+}
+
+func TestGetMessagesAfterReturnsMissedMessagesInOrder(t *testing.T) {
+	svc, mock := newTestChatDeliveryService(t)
+	sessionID := uuid.New()
+
+	mock.ExpectQuery(`(?s)FROM chat_messages.*seq > \$2.*ORDER BY seq ASC`).
+		WithArgs(sessionID, int64(4), false, chatResumeBatchSize+1).
+		WillReturnRows(chatMessageRows(sessionID, 5, 6, 7))
+
+	resume, err := svc.GetMessagesAfter(context.Background(), sessionID, 4, false)
+	require.NoError(t, err)
+	require.Len(t, resume.Messages, 3)
+	require.Equal(t, int64(7), resume.LastSeq)
+	require.False(t, resume.HasMore)
+
+	// Nothing missed: resume from the same place
+	mock.ExpectQuery(`(?s)FROM chat_messages.*seq > \$2`).
+		WithArgs(sessionID, int64(7), true, chatResumeBatchSize+1).
+		WillReturnRows(chatMessageRows(sessionID))
+
+	resume, err = svc.GetMessagesAfter(context.Background(), sessionID, 7, true)
+	require.NoError(t, err)
+	require.Empty(t, resume.Messages)
+	require.NotNil(t, resume.Messages)
+	require.Equal(t, int64(7), resume.LastSeq)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetMessagesAfterPagesLongGaps(t *testing.T) {
+	svc, mock := newTestChatDeliveryService(t)
+	sessionID := uuid.New()
+
+	seqs := make([]int64, chatResumeBatchSize+1)
+	for i := range seqs {
+		seqs[i] = int64(i + 1)
+	}
+	mock.ExpectQuery(`(?s)FROM chat_messages.*seq > \$2`).
+		WithArgs(sessionID, int64(0), false, chatResumeBatchSize+1).
+		WillReturnRows(chatMessageRows(sessionID, seqs...))
+
+	resume, err := svc.GetMessagesAfter(context.Background(), sessionID, 0, false)
+	require.NoError(t, err)
+	require.Len(t, resume.Messages, chatResumeBatchSize)
+	require.Equal(t, int64(chatResumeBatchSize), resume.LastSeq)
+	require.True(t, resume.HasMore)
+}
+
+func TestAcknowledgeDeliveryOnlyMovesForward(t *testing.T) {
+	svc, _ := newTestChatDeliveryService(t)
+	ctx := context.Background()
+	session := &models.ChatSession{ID: uuid.New(), TenantID: uuid.New(), ProjectID: uuid.New()}
+
+	seq, err := svc.AcknowledgedSeq(ctx, session.ID, ChatRecipientVisitor)
+	require.NoError(t, err)
+	require.Zero(t, seq)
+
+	require.NoError(t, svc.AcknowledgeDelivery(ctx, session, ChatRecipientVisitor, 5))
+	// A late acknowledgement of an older message does not move delivery back
+	require.NoError(t, svc.AcknowledgeDelivery(ctx, session, ChatRecipientVisitor, 3))
+
+	seq, err = svc.AcknowledgedSeq(ctx, session.ID, ChatRecipientVisitor)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), seq)
+
+	require.NoError(t, svc.AcknowledgeDelivery(ctx, session, ChatRecipientVisitor, 8))
+	seq, err = svc.AcknowledgedSeq(ctx, session.ID, ChatRecipientVisitor)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), seq)
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+const (
+	// Defaults for new widgets; existing widgets get the same values from the migration
+	defaultIdleWarningMinutes = 10
+	defaultIdleTimeoutMinutes = 30
+
+	// idleSweepBatchSize bounds the sessions handled per step of one sweep
+	idleSweepBatchSize = 200
+
+	idleCloseReason = "inactivity"
+)
+
+// idleSessionStore is the part of the chat session repository the sweeper needs
+type idleSessionStore interface {
+	ListSessionsToWarn(ctx context.Context, now time.Time, limit int) ([]*models.IdleChatSession, error)
+	ListSessionsToClose(ctx context.Context, now time.Time, limit int) ([]*models.IdleChatSession, error)
+	MarkIdleWarned(ctx context.Context, sessionID uuid.UUID, lastActivityAt, warnedAt time.Time) (bool, error)
+	CloseIdleSession(ctx context.Context, sessionID uuid.UUID, lastActivityAt, endedAt time.Time) (bool, error)
+}
+
+// chatSessionNotifier delivers session events; implemented by ChatSessionService
+type chatSessionNotifier interface {
+	SendSystemNotice(ctx context.Context, session *models.ChatSession, content string) (*models.ChatMessage, error)
+	PublishSessionEnded(ctx context.Context, session *models.ChatSession, reason string)
+	GetChatMessagesForSession(ctx context.Context, sessionID uuid.UUID) ([]*models.ChatMessage, error)
+}
+
+// ChatInactivityService warns idle visitors and closes chat sessions that went quiet
+type ChatInactivityService struct {
+	store         idleSessionStore
+	sessions      chatSessionNotifier
+	emailProvider EmailProvider
+}
+
+// NewChatInactivityService creates a new chat inactivity service
+func NewChatInactivityService(store idleSessionStore, sessions chatSessionNotifier, emailProvider EmailProvider) *ChatInactivityService {
+	return &ChatInactivityService{
+		store:         store,
+		sessions:      sessions,
+		emailProvider: emailProvider,
+	}
+}
+
+// RunSweeper sweeps idle sessions every interval until ctx is cancelled
+func (s *ChatInactivityService) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		warned, closed, err := s.Sweep(ctx, time.Now())
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Chat inactivity sweep failed")
+		} else if warned > 0 || closed > 0 {
+			logger.InfofCtx(ctx, "Chat inactivity sweep warned %d and closed %d session(s)", warned, closed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep closes sessions past their idle timeout, then warns sessions past their warning threshold.
+// Closing runs first so a session overdue for both is not warned right before it closes.
+func (s *ChatInactivityService) Sweep(ctx context.Context, now time.Time) (warned, closed int, err error) {
+	toClose, err := s.store.ListSessionsToClose(ctx, now, idleSweepBatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list idle sessions to close: %w", err)
+	}
+	for _, session := range toClose {
+		ok, err := s.closeSession(ctx, session, now)
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to close idle chat session %s", session.ID)
+			continue
+		}
+		if ok {
+			closed++
+		}
+	}
+
+	toWarn, err := s.store.ListSessionsToWarn(ctx, now, idleSweepBatchSize)
+	if err != nil {
+		return 0, closed, fmt.Errorf("failed to list idle sessions to warn: %w", err)
+	}
+	for _, session := range toWarn {
+		ok, err := s.store.MarkIdleWarned(ctx, session.ID, session.LastActivityAt, now)
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to mark chat session %s as warned", session.ID)
+			continue
+		}
+		if !ok {
+			continue // the visitor or an agent replied meanwhile
+		}
+
+		if _, err := s.sessions.SendSystemNotice(ctx, &session.ChatSession, idleWarningText(session)); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to warn idle chat session %s", session.ID)
+			continue
+		}
+		warned++
+	}
+
+	return warned, closed, nil
+}
+
+func (s *ChatInactivityService) closeSession(ctx context.Context, session *models.IdleChatSession, now time.Time) (bool, error) {
+	ok, err := s.store.CloseIdleSession(ctx, session.ID, session.LastActivityAt, now)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	session.Status = "ended"
+	session.EndedAt = &now
+
+	if _, err := s.sessions.SendSystemNotice(ctx, &session.ChatSession, "This chat was closed due to inactivity."); err != nil {
+		logger.WarnfCtx(ctx, "Failed to post closing notice to chat session %s: %v", session.ID, err)
+	}
+	s.sessions.PublishSessionEnded(ctx, &session.ChatSession, idleCloseReason)
+
+	if session.SendTranscript {
+		s.sendTranscript(ctx, session)
+	}
+	return true, nil
+}
+
+// sendTranscript emails the conversation to the visitor and to the widget's archive address
+func (s *ChatInactivityService) sendTranscript(ctx context.Context, session *models.IdleChatSession) {
+	if s.emailProvider == nil {
+		return
+	}
+
+	type recipient struct{ email, name string }
+	var recipients []recipient
+	if session.CustomerEmail != nil && *session.CustomerEmail != "" {
+		name := ""
+		if session.CustomerName != nil {
+			name = *session.CustomerName
+		}
+		recipients = append(recipients, recipient{*session.CustomerEmail, name})
+	}
+	if session.TranscriptArchiveEmail != nil && *session.TranscriptArchiveEmail != "" {
+		recipients = append(recipients, recipient{*session.TranscriptArchiveEmail, "Transcript archive"})
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	messages, err := s.sessions.GetChatMessagesForSession(ctx, session.ID)
+	if err != nil {
+		logger.ErrorfCtx(ctx, err, "Failed to load messages for transcript of chat session %s", session.ID)
+		return
+	}
+
+	transcript := &ChatTranscript{
+		SessionID: session.ID.String(),
+		StartedAt: session.StartedAt,
+		EndedAt:   *session.EndedAt,
+		Messages:  messages,
+	}
+	if session.WidgetName != nil {
+		transcript.WidgetName = *session.WidgetName
+	}
+	if session.CustomerName != nil {
+		transcript.VisitorName = *session.CustomerName
+	}
+
+	for _, r := range recipients {
+		if err := s.emailProvider.SendChatTranscriptEmail(ctx, transcript, r.email, r.name); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to send transcript of chat session %s", session.ID)
+		}
+	}
+}
+
+func idleWarningText(session *models.IdleChatSession) string {
+	if session.IdleTimeoutMinutes > session.IdleWarningMinutes {
+		return fmt.Sprintf("Are you still there? This chat will close automatically in %d minutes if there is no reply.",
+			session.IdleTimeoutMinutes-session.IdleWarningMinutes)
+	}
+	return "Are you still there? Reply to keep this chat open."
+}
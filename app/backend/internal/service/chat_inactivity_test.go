@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeIdleSessionStore struct {
+	toWarn  []*models.IdleChatSession
+	toClose []*models.IdleChatSession
+
+	// sessions that saw activity after they were listed
+	active map[uuid.UUID]bool
+
+	warned map[uuid.UUID]time.Time
+	closed map[uuid.UUID]time.Time
+}
+
+func (f *fakeIdleSessionStore) ListSessionsToWarn(ctx context.Context, now time.Time, limit int) ([]*models.IdleChatSession, error) {
+	return f.toWarn, nil
+}
+
+func (f *fakeIdleSessionStore) ListSessionsToClose(ctx context.Context, now time.Time, limit int) ([]*models.IdleChatSession, error) {
+	return f.toClose, nil
+}
+
+func (f *fakeIdleSessionStore) MarkIdleWarned(ctx context.Context, sessionID uuid.UUID, lastActivityAt, warnedAt time.Time) (bool, error) {
+	if f.active[sessionID] {
+		return false, nil
+	}
+	f.warned[sessionID] = warnedAt
+	return true, nil
+}
+
+func (f *fakeIdleSessionStore) CloseIdleSession(ctx context.Context, sessionID uuid.UUID, lastActivityAt, endedAt time.Time) (bool, error) {
+	if f.active[sessionID] {
+		return false, nil
+	}
+	f.closed[sessionID] = endedAt
+	return true, nil
+}
+
+type fakeChatSessionNotifier struct {
+	notices  map[uuid.UUID][]string
+	ended    map[uuid.UUID]string
+	messages []*models.ChatMessage
+}
+
+func (f *fakeChatSessionNotifier) SendSystemNotice(ctx context.Context, session *models.ChatSession, content string) (*models.ChatMessage, error) {
+	f.notices[session.ID] = append(f.notices[session.ID], content)
+	return &models.ChatMessage{ID: uuid.New(), SessionID: session.ID, Content: content, AuthorType: "system"}, nil
+}
+
+func (f *fakeChatSessionNotifier) PublishSessionEnded(ctx context.Context, session *models.ChatSession, reason string) {
+	f.ended[session.ID] = reason
+}
+
+func (f *fakeChatSessionNotifier) GetChatMessagesForSession(ctx context.Context, sessionID uuid.UUID) ([]*models.ChatMessage, error) {
+	return f.messages, nil
+}
+
+type transcriptEmail struct {
+	to         string
+	transcript *ChatTranscript
+}
+
+type fakeTranscriptEmailProvider struct {
+	EmailProvider
+	sent []transcriptEmail
+}
+
+func (f *fakeTranscriptEmailProvider) SendChatTranscriptEmail(ctx context.Context, transcript *ChatTranscript, toEmail, recipientName string) error {
+	f.sent = append(f.sent, transcriptEmail{to: toEmail, transcript: transcript})
+	return nil
+}
+
+func newIdleSession(warnMinutes, timeoutMinutes int, lastActivity time.Time) *models.IdleChatSession {
+	return &models.IdleChatSession{
+		ChatSession: models.ChatSession{
+			ID:             uuid.New(),
+			TenantID:       uuid.New(),
+			ProjectID:      uuid.New(),
+			Status:         "active",
+			StartedAt:      lastActivity.Add(-5 * time.Minute),
+			LastActivityAt: lastActivity,
+		},
+		IdleWarningMinutes: warnMinutes,
+		IdleTimeoutMinutes: timeoutMinutes,
+	}
+}
+
+func TestChatInactivitySweepClosesAndWarns(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	visitorEmail, visitorName, archive := "jane@example.com", "Jane", "archive@acme.test"
+
+	stale := newIdleSession(10, 30, now.Add(-45*time.Minute))
+	stale.SendTranscript = true
+	stale.CustomerEmail = &visitorEmail
+	stale.CustomerName = &visitorName
+	stale.TranscriptArchiveEmail = &archive
+
+	revived := newIdleSession(10, 30, now.Add(-40*time.Minute))
+	quiet := newIdleSession(10, 30, now.Add(-12*time.Minute))
+	replied := newIdleSession(10, 30, now.Add(-15*time.Minute))
+
+	store := &fakeIdleSessionStore{
+		toClose: []*models.IdleChatSession{stale, revived},
+		toWarn:  []*models.IdleChatSession{quiet, replied},
+		active:  map[uuid.UUID]bool{revived.ID: true, replied.ID: true},
+		warned:  map[uuid.UUID]time.Time{},
+		closed:  map[uuid.UUID]time.Time{},
+	}
+	notifier := &fakeChatSessionNotifier{
+		notices: map[uuid.UUID][]string{},
+		ended:   map[uuid.UUID]string{},
+		messages: []*models.ChatMessage{
+			{AuthorType: "visitor", AuthorName: "Jane", Content: "Hi", CreatedAt: now.Add(-50 * time.Minute)},
+		},
+	}
+	emails := &fakeTranscriptEmailProvider{}
+
+	svc := NewChatInactivityService(store, notifier, emails)
+	warned, closed, err := svc.Sweep(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 1, warned)
+	require.Equal(t, 1, closed)
+
+	// The stale session is ended, announced and its transcript mailed to the visitor and the archive
+	require.Equal(t, now, store.closed[stale.ID])
+	require.Equal(t, "ended", stale.Status)
+	require.Equal(t, idleCloseReason, notifier.ended[stale.ID])
+	require.Equal(t, []string{"This chat was closed due to inactivity."}, notifier.notices[stale.ID])
+	require.Len(t, emails.sent, 2)
+	require.Equal(t, visitorEmail, emails.sent[0].to)
+	require.Equal(t, archive, emails.sent[1].to)
+	require.Equal(t, now, emails.sent[0].transcript.EndedAt)
+	require.Len(t, emails.sent[0].transcript.Messages, 1)
+
+	// Sessions that saw activity after being listed are left alone
+	require.NotContains(t, store.closed, revived.ID)
+	require.NotContains(t, notifier.ended, revived.ID)
+	require.NotContains(t, store.warned, replied.ID)
+	require.Empty(t, notifier.notices[replied.ID])
+
+	require.Equal(t, now, store.warned[quiet.ID])
+	require.Equal(t, []string{"Are you still there? This chat will close automatically in 20 minutes if there is no reply."}, notifier.notices[quiet.ID])
+}
+
+func TestChatInactivitySkipsTranscriptWithoutRecipients(t *testing.T) {
+	now := time.Now()
+	session := newIdleSession(0, 15, now.Add(-time.Hour))
+	session.SendTranscript = true
+
+	store := &fakeIdleSessionStore{
+		toClose: []*models.IdleChatSession{session},
+		warned:  map[uuid.UUID]time.Time{},
+		closed:  map[uuid.UUID]time.Time{},
+	}
+	notifier := &fakeChatSessionNotifier{notices: map[uuid.UUID][]string{}, ended: map[uuid.UUID]string{}}
+	emails := &fakeTranscriptEmailProvider{}
+
+	_, closed, err := NewChatInactivityService(store, notifier, emails).Sweep(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 1, closed)
+	require.Empty(t, emails.sent)
+}
+
+func TestIdleWarningText(t *testing.T) {
+	require.Contains(t, idleWarningText(newIdleSession(5, 20, time.Now())), "in 15 minutes")
+	require.Equal(t, "Are you still there? Reply to keep this chat open.", idleWarningText(newIdleSession(5, 0, time.Now())))
+}
+
+func TestBuildChatTranscriptEmailEscapesContent(t *testing.T) {
+	at := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	subject, htmlBody, textBody := buildChatTranscriptEmail(&ChatTranscript{
+		WidgetName: "Acme Support",
+		StartedAt:  at,
+		EndedAt:    at.Add(30 * time.Minute),
+		Messages: []*models.ChatMessage{
+			{AuthorType: "visitor", AuthorName: "Jane", Content: "<script>alert(1)</script>", CreatedAt: at},
+			{AuthorType: "agent", AuthorName: "Bob", Content: "internal note", IsPrivate: true, CreatedAt: at},
+			{AuthorType: "system", AuthorName: "System", Content: "Bob joined", CreatedAt: at},
+		},
+	}, "Jane", "jane@example.com")
+
+	require.Equal(t, "Your chat transcript with Acme Support", subject)
+	require.Contains(t, htmlBody, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	require.NotContains(t, htmlBody, "<script>")
+	require.NotContains(t, htmlBody, "internal note")
+	require.NotContains(t, textBody, "Bob joined")
+	require.Contains(t, textBody, "[12:00] Jane: <script>alert(1)</script>")
+}
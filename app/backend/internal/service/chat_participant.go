@@ -0,0 +1,383 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+)
+
+var (
+	ErrChatSessionNotActive   = errors.New("chat session is not active")
+	ErrChatAgentUnavailable   = errors.New("agent not found or cannot chat in this project")
+	ErrChatAlreadyAssigned    = errors.New("chat session is already assigned there")
+	ErrChatAlreadyParticipant = errors.New("agent is already taking part in this chat")
+	ErrChatNotParticipant     = errors.New("agent is not taking part in this chat")
+	ErrChatTransferConflict   = errors.New("chat session changed hands while transferring, reload and try again")
+	ErrChatTransferForbidden  = errors.New("only the assigned agent or a supervisor can transfer this chat")
+	ErrChatMonitorForbidden   = errors.New("only supervisors can monitor chats")
+	ErrChatPrimaryCannotLeave = errors.New("the assigned agent must transfer the chat before leaving")
+)
+
+// chatParticipantStore records which agents take part in a session; implemented by ChatParticipantRepo
+type chatParticipantStore interface {
+	AddParticipant(ctx context.Context, participant *models.ChatSessionParticipant) error
+	RemoveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (bool, error)
+	GetActiveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (*models.ChatSessionParticipant, error)
+	ListActiveParticipants(ctx context.Context, tenantID, sessionID uuid.UUID) ([]*models.ChatSessionParticipant, error)
+}
+
+// chatParticipantSessions loads, reassigns and notifies sessions; implemented by ChatSessionService
+type chatParticipantSessions interface {
+	GetChatSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error)
+	ReassignSession(ctx context.Context, session *models.ChatSession, agentID *uuid.UUID) (bool, error)
+	SendSystemNotice(ctx context.Context, session *models.ChatSession, content string) (*models.ChatMessage, error)
+	SendPrivateMessage(ctx context.Context, session *models.ChatSession, agentID uuid.UUID, agentName, content string, metadata models.JSONMap) (*models.ChatMessage, error)
+	PublishParticipantEvent(ctx context.Context, session *models.ChatSession, eventType string, data json.RawMessage, agentsOnly bool)
+}
+
+// chatAgentDirectory looks agents up; implemented by AgentService
+type chatAgentDirectory interface {
+	GetAgent(ctx context.Context, tenantID, agentID uuid.UUID) (*db.Agent, error)
+}
+
+// chatPermissionChecker checks an agent's project permissions; implemented by rbac.Service
+type chatPermissionChecker interface {
+	CheckPermission(ctx context.Context, agentID, tenantID, projectID uuid.UUID, permission rbac.Permission) (bool, error)
+}
+
+// chatParticipantEvent is the payload of the agent_joined and agent_left events
+type chatParticipantEvent struct {
+	SessionID       uuid.UUID  `json:"session_id"`
+	AgentID         uuid.UUID  `json:"agent_id"`
+	AgentName       string     `json:"agent_name"`
+	Role            string     `json:"role"`
+	Reason          string     `json:"reason"`
+	AssignedAgentID *uuid.UUID `json:"assigned_agent_id"`
+}
+
+// ChatParticipantService lets several agents work a live chat: transfers between agents and back to
+// the project queue, invited agents, and supervisors who monitor a chat and whisper to its agents
+type ChatParticipantService struct {
+	store    chatParticipantStore
+	sessions chatParticipantSessions
+	agents   chatAgentDirectory
+	perms    chatPermissionChecker
+}
+
+// NewChatParticipantService creates a new chat participant service
+func NewChatParticipantService(store chatParticipantStore, sessions chatParticipantSessions, agents chatAgentDirectory, perms chatPermissionChecker) *ChatParticipantService {
+	return &ChatParticipantService{
+		store:    store,
+		sessions: sessions,
+		agents:   agents,
+		perms:    perms,
+	}
+}
+
+// ListParticipants lists the agents currently in a session
+func (s *ChatParticipantService) ListParticipants(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) ([]*models.ChatSessionParticipant, error) {
+	if _, err := s.getSession(ctx, tenantID, projectID, sessionID); err != nil {
+		return nil, err
+	}
+	return s.store.ListActiveParticipants(ctx, tenantID, sessionID)
+}
+
+// TransferSession hands an active session to another agent, or back to the project queue when no agent is
+// given. The handover note is kept as a private message so the next agent picks up where the last one left.
+func (s *ChatParticipantService) TransferSession(ctx context.Context, tenantID, projectID, sessionID, actorID uuid.UUID, req models.TransferChatSessionRequest) (*models.ChatSession, error) {
+	session, err := s.getActiveSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureCanTransfer(ctx, session, actorID); err != nil {
+		return nil, err
+	}
+
+	target := req.AgentID
+	if target == nil && session.AssignedAgentID == nil {
+		return nil, ErrChatAlreadyAssigned
+	}
+	if target != nil && session.AssignedAgentID != nil && *session.AssignedAgentID == *target {
+		return nil, ErrChatAlreadyAssigned
+	}
+
+	targetName := ""
+	if target != nil {
+		agent, err := s.availableAgent(ctx, tenantID, projectID, *target)
+		if err != nil {
+			return nil, err
+		}
+		targetName = agent.Name
+	}
+
+	previousAgentID := session.AssignedAgentID
+	reassigned, err := s.sessions.ReassignSession(ctx, session, target)
+	if err != nil {
+		return nil, err
+	}
+	if !reassigned {
+		return nil, ErrChatTransferConflict
+	}
+
+	if req.Note != "" {
+		metadata := models.JSONMap{"handover": true}
+		if target != nil {
+			metadata["transferred_to"] = target.String()
+		} else {
+			metadata["transferred_to"] = "queue"
+		}
+		if _, err := s.sessions.SendPrivateMessage(ctx, session, actorID, s.agentName(ctx, tenantID, actorID), req.Note, metadata); err != nil {
+			logger.WarnfCtx(ctx, "Failed to store handover note for chat session %s: %v", session.ID, err)
+		}
+	}
+
+	if previousAgentID != nil {
+		if _, err := s.store.RemoveParticipant(ctx, session.ID, *previousAgentID); err != nil {
+			logger.WarnfCtx(ctx, "Failed to record agent %s leaving chat session %s: %v", *previousAgentID, session.ID, err)
+		}
+		s.publish(ctx, session, models.WSMsgTypeAgentLeft, *previousAgentID, s.agentName(ctx, tenantID, *previousAgentID), models.ChatParticipantPrimary, "transferred")
+	}
+
+	if target == nil {
+		s.notice(ctx, session, "Please hold on while we connect you with another agent.")
+		return session, nil
+	}
+
+	if err := s.store.AddParticipant(ctx, &models.ChatSessionParticipant{
+		SessionID: session.ID,
+		AgentID:   *target,
+		TenantID:  tenantID,
+		Role:      models.ChatParticipantPrimary,
+		JoinedAt:  time.Now(),
+	}); err != nil {
+		logger.WarnfCtx(ctx, "Failed to record agent %s as primary of chat session %s: %v", *target, session.ID, err)
+	}
+	s.publish(ctx, session, models.WSMsgTypeAgentJoined, *target, targetName, models.ChatParticipantPrimary, "transferred")
+	s.notice(ctx, session, fmt.Sprintf("You're now chatting with our agent %s", targetName))
+	return session, nil
+}
+
+// InviteAgent brings a second agent into an active session alongside the assigned one. A supervisor who is
+// monitoring the chat can be invited too, which makes them visible to the visitor.
+func (s *ChatParticipantService) InviteAgent(ctx context.Context, tenantID, projectID, sessionID, agentID uuid.UUID) (*models.ChatSessionParticipant, error) {
+	session, err := s.getActiveSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.AssignedAgentID != nil && *session.AssignedAgentID == agentID {
+		return nil, ErrChatAlreadyParticipant
+	}
+	existing, err := s.store.GetActiveParticipant(ctx, sessionID, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participant: %w", err)
+	}
+	if existing != nil && existing.Role != models.ChatParticipantObserver {
+		return nil, ErrChatAlreadyParticipant
+	}
+
+	agent, err := s.availableAgent(ctx, tenantID, projectID, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	participant, err := s.join(ctx, session, agent, models.ChatParticipantParticipant, "invited")
+	if err != nil {
+		return nil, err
+	}
+	s.notice(ctx, session, fmt.Sprintf("Our agent %s has joined the conversation", agent.Name))
+	return participant, nil
+}
+
+// MonitorSession adds a supervisor to an active session as an observer. Observers receive every message
+// and can whisper to the agents, but the visitor is never told they are there.
+func (s *ChatParticipantService) MonitorSession(ctx context.Context, tenantID, projectID, sessionID, supervisorID uuid.UUID) (*models.ChatSessionParticipant, error) {
+	session, err := s.getActiveSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.AssignedAgentID != nil && *session.AssignedAgentID == supervisorID {
+		return nil, ErrChatAlreadyParticipant
+	}
+	existing, err := s.store.GetActiveParticipant(ctx, sessionID, supervisorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participant: %w", err)
+	}
+	if existing != nil {
+		return nil, ErrChatAlreadyParticipant
+	}
+	allowed, err := s.perms.CheckPermission(ctx, supervisorID, tenantID, projectID, rbac.PermChatSupervise)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check agent permissions: %w", err)
+	}
+	if !allowed {
+		return nil, ErrChatMonitorForbidden
+	}
+
+	agent, err := s.agents.GetAgent(ctx, tenantID, supervisorID)
+	if err != nil || agent == nil {
+		return nil, ErrChatAgentUnavailable
+	}
+	return s.join(ctx, session, agent, models.ChatParticipantObserver, "monitoring")
+}
+
+// Whisper sends a private message that only the agents of the session see. Only the assigned agent and
+// the agents in the session can whisper.
+func (s *ChatParticipantService) Whisper(ctx context.Context, tenantID, projectID, sessionID, agentID uuid.UUID, content string) (*models.ChatMessage, error) {
+	session, err := s.getActiveSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.AssignedAgentID == nil || *session.AssignedAgentID != agentID {
+		participant, err := s.store.GetActiveParticipant(ctx, sessionID, agentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get participant: %w", err)
+		}
+		if participant == nil {
+			return nil, ErrChatNotParticipant
+		}
+	}
+
+	return s.sessions.SendPrivateMessage(ctx, session, agentID, s.agentName(ctx, tenantID, agentID), content, models.JSONMap{"whisper": true})
+}
+
+// LeaveSession takes an invited agent or an observer out of a session. The assigned agent has to
+// transfer the chat instead, so the visitor is never left without one.
+func (s *ChatParticipantService) LeaveSession(ctx context.Context, tenantID, projectID, sessionID, agentID uuid.UUID) error {
+	session, err := s.getSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.Status == "active" && session.AssignedAgentID != nil && *session.AssignedAgentID == agentID {
+		return ErrChatPrimaryCannotLeave
+	}
+
+	participant, err := s.store.GetActiveParticipant(ctx, sessionID, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to get participant: %w", err)
+	}
+	if participant == nil {
+		return ErrChatNotParticipant
+	}
+	removed, err := s.store.RemoveParticipant(ctx, sessionID, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to remove participant: %w", err)
+	}
+	if !removed {
+		return ErrChatNotParticipant
+	}
+
+	s.publish(ctx, session, models.WSMsgTypeAgentLeft, agentID, participant.AgentName, participant.Role, "left")
+	if participant.Role != models.ChatParticipantObserver && session.Status == "active" {
+		s.notice(ctx, session, fmt.Sprintf("Our agent %s has left the conversation", participant.AgentName))
+	}
+	return nil
+}
+
+// join records an agent joining a session and tells the session about it
+func (s *ChatParticipantService) join(ctx context.Context, session *models.ChatSession, agent *db.Agent, role, reason string) (*models.ChatSessionParticipant, error) {
+	participant := &models.ChatSessionParticipant{
+		SessionID:  session.ID,
+		AgentID:    agent.ID,
+		TenantID:   session.TenantID,
+		Role:       role,
+		JoinedAt:   time.Now(),
+		AgentName:  agent.Name,
+		AgentEmail: agent.Email,
+	}
+	if err := s.store.AddParticipant(ctx, participant); err != nil {
+		return nil, fmt.Errorf("failed to add participant: %w", err)
+	}
+	s.publish(ctx, session, models.WSMsgTypeAgentJoined, agent.ID, agent.Name, role, reason)
+	return participant, nil
+}
+
+// publish delivers an agent_joined or agent_left event; observers are announced to agents only
+func (s *ChatParticipantService) publish(ctx context.Context, session *models.ChatSession, eventType models.WSMessageType, agentID uuid.UUID, agentName, role, reason string) {
+	data, _ := json.Marshal(chatParticipantEvent{
+		SessionID:       session.ID,
+		AgentID:         agentID,
+		AgentName:       agentName,
+		Role:            role,
+		Reason:          reason,
+		AssignedAgentID: session.AssignedAgentID,
+	})
+	s.sessions.PublishParticipantEvent(ctx, session, string(eventType), data, role == models.ChatParticipantObserver)
+}
+
+// notice posts a system message the visitor sees
+func (s *ChatParticipantService) notice(ctx context.Context, session *models.ChatSession, content string) {
+	if _, err := s.sessions.SendSystemNotice(ctx, session, content); err != nil {
+		logger.WarnfCtx(ctx, "Failed to post notice to chat session %s: %v", session.ID, err)
+	}
+}
+
+// ensureCanTransfer lets the assigned agent hand over their own chat and supervisors move any chat
+func (s *ChatParticipantService) ensureCanTransfer(ctx context.Context, session *models.ChatSession, actorID uuid.UUID) error {
+	if session.AssignedAgentID != nil && *session.AssignedAgentID == actorID {
+		return nil
+	}
+	allowed, err := s.perms.CheckPermission(ctx, actorID, session.TenantID, session.ProjectID, rbac.PermChatSupervise)
+	if err != nil {
+		return fmt.Errorf("failed to check agent permissions: %w", err)
+	}
+	if !allowed {
+		return ErrChatTransferForbidden
+	}
+	return nil
+}
+
+// availableAgent loads an agent who may chat in the project
+func (s *ChatParticipantService) availableAgent(ctx context.Context, tenantID, projectID, agentID uuid.UUID) (*db.Agent, error) {
+	allowed, err := s.perms.CheckPermission(ctx, agentID, tenantID, projectID, rbac.PermChatWrite)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check agent permissions: %w", err)
+	}
+	if !allowed {
+		return nil, ErrChatAgentUnavailable
+	}
+	agent, err := s.agents.GetAgent(ctx, tenantID, agentID)
+	if err != nil || agent == nil {
+		return nil, ErrChatAgentUnavailable
+	}
+	return agent, nil
+}
+
+// agentName returns an agent's display name, falling back to a generic one
+func (s *ChatParticipantService) agentName(ctx context.Context, tenantID, agentID uuid.UUID) string {
+	agent, err := s.agents.GetAgent(ctx, tenantID, agentID)
+	if err != nil || agent == nil || agent.Name == "" {
+		return "Agent"
+	}
+	return agent.Name
+}
+
+func (s *ChatParticipantService) getSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	session, err := s.sessions.GetChatSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrChatSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *ChatParticipantService) getActiveSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	session, err := s.getSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != "active" {
+		return nil, ErrChatSessionNotActive
+	}
+	return session, nil
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+)
+
+type fakeChatParticipantStore struct {
+	active map[uuid.UUID]*models.ChatSessionParticipant // keyed by agent
+}
+
+func (f *fakeChatParticipantStore) AddParticipant(ctx context.Context, participant *models.ChatSessionParticipant) error {
+	f.active[participant.AgentID] = participant
+	return nil
+}
+
+func (f *fakeChatParticipantStore) RemoveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (bool, error) {
+	if _, ok := f.active[agentID]; !ok {
+		return false, nil
+	}
+	delete(f.active, agentID)
+	return true, nil
+}
+
+func (f *fakeChatParticipantStore) GetActiveParticipant(ctx context.Context, sessionID, agentID uuid.UUID) (*models.ChatSessionParticipant, error) {
+	return f.active[agentID], nil
+}
+
+func (f *fakeChatParticipantStore) ListActiveParticipants(ctx context.Context, tenantID, sessionID uuid.UUID) ([]*models.ChatSessionParticipant, error) {
+	var participants []*models.ChatSessionParticipant
+	for _, participant := range f.active {
+		participants = append(participants, participant)
+	}
+	return participants, nil
+}
+
+type participantEvent struct {
+	eventType  string
+	agentsOnly bool
+	data       chatParticipantEvent
+}
+
+type fakeChatParticipantSessions struct {
+	session  *models.ChatSession
+	conflict bool
+	notices  []string
+	private  []*models.ChatMessage
+	events   []participantEvent
+}
+
+func (f *fakeChatParticipantSessions) GetChatSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	if f.session == nil || f.session.ID != sessionID || f.session.TenantID != tenantID || f.session.ProjectID != projectID {
+		return nil, nil
+	}
+	return f.session, nil
+}
+
+func (f *fakeChatParticipantSessions) ReassignSession(ctx context.Context, session *models.ChatSession, agentID *uuid.UUID) (bool, error) {
+	if f.conflict {
+		return false, nil
+	}
+	session.AssignedAgentID = agentID
+	return true, nil
+}
+
+func (f *fakeChatParticipantSessions) SendSystemNotice(ctx context.Context, session *models.ChatSession, content string) (*models.ChatMessage, error) {
+	f.notices = append(f.notices, content)
+	return &models.ChatMessage{ID: uuid.New(), Content: content, AuthorType: "system"}, nil
+}
+
+func (f *fakeChatParticipantSessions) SendPrivateMessage(ctx context.Context, session *models.ChatSession, agentID uuid.UUID, agentName, content string, metadata models.JSONMap) (*models.ChatMessage, error) {
+	message := &models.ChatMessage{ID: uuid.New(), SessionID: session.ID, AuthorType: "agent", AuthorID: &agentID, AuthorName: agentName, Content: content, Metadata: metadata, IsPrivate: true}
+	f.private = append(f.private, message)
+	return message, nil
+}
+
+func (f *fakeChatParticipantSessions) PublishParticipantEvent(ctx context.Context, session *models.ChatSession, eventType string, data json.RawMessage, agentsOnly bool) {
+	var event chatParticipantEvent
+	_ = json.Unmarshal(data, &event)
+	f.events = append(f.events, participantEvent{eventType: eventType, agentsOnly: agentsOnly, data: event})
+}
+
+type fakeChatAgentDirectory struct {
+	agents map[uuid.UUID]*db.Agent
+}
+
+func (f *fakeChatAgentDirectory) GetAgent(ctx context.Context, tenantID, agentID uuid.UUID) (*db.Agent, error) {
+	return f.agents[agentID], nil
+}
+
+// fakeChatPermissionChecker grants the permissions of each agent's built-in project role
+type fakeChatPermissionChecker struct {
+	roles map[uuid.UUID]models.RoleType
+}
+
+func (f *fakeChatPermissionChecker) CheckPermission(ctx context.Context, agentID, tenantID, projectID uuid.UUID, permission rbac.Permission) (bool, error) {
+	return rbac.RoleHasPermission(f.roles[agentID], permission), nil
+}
+
+type chatParticipantFixture struct {
+	svc      *ChatParticipantService
+	store    *fakeChatParticipantStore
+	sessions *fakeChatParticipantSessions
+	session  *models.ChatSession
+	alice    uuid.UUID // assigned agent
+	bob      uuid.UUID // agent in the project
+	carol    uuid.UUID // supervisor
+	outsider uuid.UUID // agent without access to the project
+}
+
+func newChatParticipantFixture() *chatParticipantFixture {
+	f := &chatParticipantFixture{alice: uuid.New(), bob: uuid.New(), carol: uuid.New(), outsider: uuid.New()}
+	f.session = &models.ChatSession{ID: uuid.New(), TenantID: uuid.New(), ProjectID: uuid.New(), Status: "active", AssignedAgentID: &f.alice}
+	f.store = &fakeChatParticipantStore{active: map[uuid.UUID]*models.ChatSessionParticipant{
+		f.alice: {SessionID: f.session.ID, AgentID: f.alice, Role: models.ChatParticipantPrimary, AgentName: "Alice"},
+	}}
+	f.sessions = &fakeChatParticipantSessions{session: f.session}
+	agents := &fakeChatAgentDirectory{agents: map[uuid.UUID]*db.Agent{
+		f.alice:    {ID: f.alice, Name: "Alice"},
+		f.bob:      {ID: f.bob, Name: "Bob"},
+		f.carol:    {ID: f.carol, Name: "Carol"},
+		f.outsider: {ID: f.outsider, Name: "Oscar"},
+	}}
+	perms := &fakeChatPermissionChecker{roles: map[uuid.UUID]models.RoleType{
+		f.alice: models.RoleAgent, f.bob: models.RoleAgent, f.carol: models.RoleSupervisor,
+	}}
+	f.svc = NewChatParticipantService(f.store, f.sessions, agents, perms)
+	return f
+}
+
+func TestChatParticipantTransferToAgent(t *testing.T) {
+	f := newChatParticipantFixture()
+	ctx := context.Background()
+
+	session, err := f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.alice,
+		models.TransferChatSessionRequest{AgentID: &f.bob, Note: "Wants a refund for order 1234"})
+	require.NoError(t, err)
+	require.Equal(t, f.bob, *session.AssignedAgentID)
+
+	// The handover note is private and names the new assignee
+	require.Len(t, f.sessions.private, 1)
+	note := f.sessions.private[0]
+	require.Equal(t, "Wants a refund for order 1234", note.Content)
+	require.Equal(t, "Alice", note.AuthorName)
+	require.Equal(t, f.bob.String(), note.Metadata["transferred_to"])
+
+	// Alice leaves, Bob becomes primary, and both changes are visible to the visitor
+	require.NotContains(t, f.store.active, f.alice)
+	require.Equal(t, models.ChatParticipantPrimary, f.store.active[f.bob].Role)
+	require.Len(t, f.sessions.events, 2)
+	require.Equal(t, string(models.WSMsgTypeAgentLeft), f.sessions.events[0].eventType)
+	require.Equal(t, f.alice, f.sessions.events[0].data.AgentID)
+	require.Equal(t, string(models.WSMsgTypeAgentJoined), f.sessions.events[1].eventType)
+	require.Equal(t, "Bob", f.sessions.events[1].data.AgentName)
+	require.False(t, f.sessions.events[1].agentsOnly)
+	require.Equal(t, []string{"You're now chatting with our agent Bob"}, f.sessions.notices)
+
+	// Transferring to the current assignee or an agent outside the project is refused
+	_, err = f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob, models.TransferChatSessionRequest{AgentID: &f.bob})
+	require.ErrorIs(t, err, ErrChatAlreadyAssigned)
+	_, err = f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob, models.TransferChatSessionRequest{AgentID: &f.outsider})
+	require.ErrorIs(t, err, ErrChatAgentUnavailable)
+}
+
+func TestChatParticipantTransferToQueue(t *testing.T) {
+	f := newChatParticipantFixture()
+	ctx := context.Background()
+
+	session, err := f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.alice, models.TransferChatSessionRequest{})
+	require.NoError(t, err)
+	require.Nil(t, session.AssignedAgentID)
+	require.Empty(t, f.sessions.private)
+	require.Len(t, f.sessions.events, 1)
+	require.Equal(t, string(models.WSMsgTypeAgentLeft), f.sessions.events[0].eventType)
+	require.Nil(t, f.sessions.events[0].data.AssignedAgentID)
+	require.Equal(t, []string{"Please hold on while we connect you with another agent."}, f.sessions.notices)
+
+	// Alice no longer holds the chat, and a supervisor finds it already queued
+	_, err = f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.alice, models.TransferChatSessionRequest{})
+	require.ErrorIs(t, err, ErrChatTransferForbidden)
+	_, err = f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol, models.TransferChatSessionRequest{})
+	require.ErrorIs(t, err, ErrChatAlreadyAssigned)
+}
+
+func TestChatParticipantTransferRequiresAssigneeOrSupervisor(t *testing.T) {
+	f := newChatParticipantFixture()
+	ctx := context.Background()
+
+	// Bob takes part in the project but the chat is Alice's
+	_, err := f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob, models.TransferChatSessionRequest{AgentID: &f.bob})
+	require.ErrorIs(t, err, ErrChatTransferForbidden)
+	require.Equal(t, f.alice, *f.session.AssignedAgentID)
+	require.Empty(t, f.sessions.events)
+
+	// Carol supervises the project and may move it
+	session, err := f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol, models.TransferChatSessionRequest{AgentID: &f.bob})
+	require.NoError(t, err)
+	require.Equal(t, f.bob, *session.AssignedAgentID)
+}
+
+func TestChatParticipantTransferConflictAndEndedSession(t *testing.T) {
+	f := newChatParticipantFixture()
+	ctx := context.Background()
+
+	f.sessions.conflict = true
+	_, err := f.svc.TransferSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.alice, models.TransferChatSessionRequest{AgentID: &f.bob, Note: "note"})
+	require.ErrorIs(t, err, ErrChatTransferConflict)
+	require.Empty(t, f.sessions.private)
+	require.Empty(t, f.sessions.events)
+
+	f.session.Status = "ended"
+	_, err = f.svc.InviteAgent(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob)
+	require.ErrorIs(t, err, ErrChatSessionNotActive)
+
+	_, err = f.svc.InviteAgent(ctx, f.session.TenantID, f.session.ProjectID, uuid.New(), f.bob)
+	require.ErrorIs(t, err, ErrChatSessionNotFound)
+}
+
+func TestChatParticipantInviteAndLeave(t *testing.T) {
+	f := newChatParticipantFixture()
+	ctx := context.Background()
+
+	participant, err := f.svc.InviteAgent(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob)
+	require.NoError(t, err)
+	require.Equal(t, models.ChatParticipantParticipant, participant.Role)
+	require.Equal(t, "Bob", participant.AgentName)
+	require.Equal(t, []string{"Our agent Bob has joined the conversation"}, f.sessions.notices)
+	require.False(t, f.sessions.events[0].agentsOnly)
+
+	_, err = f.svc.InviteAgent(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob)
+	require.ErrorIs(t, err, ErrChatAlreadyParticipant)
+	_, err = f.svc.InviteAgent(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.alice)
+	require.ErrorIs(t, err, ErrChatAlreadyParticipant)
+	_, err = f.svc.InviteAgent(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.outsider)
+	require.ErrorIs(t, err, ErrChatAgentUnavailable)
+
+	// The assigned agent cannot walk away from the visitor
+	require.ErrorIs(t, f.svc.LeaveSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.alice), ErrChatPrimaryCannotLeave)
+
+	require.NoError(t, f.svc.LeaveSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob))
+	require.NotContains(t, f.store.active, f.bob)
+	last := f.sessions.events[len(f.sessions.events)-1]
+	require.Equal(t, string(models.WSMsgTypeAgentLeft), last.eventType)
+	require.Equal(t, "left", last.data.Reason)
+	require.Equal(t, "Our agent Bob has left the conversation", f.sessions.notices[len(f.sessions.notices)-1])
+
+	require.ErrorIs(t, f.svc.LeaveSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob), ErrChatNotParticipant)
+}
+
+func TestChatParticipantMonitorAndWhisper(t *testing.T) {
+	f := newChatParticipantFixture()
+	ctx := context.Background()
+
+	// Only agents in the chat can whisper
+	_, err := f.svc.Whisper(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol, "psst")
+	require.ErrorIs(t, err, ErrChatNotParticipant)
+
+	// Monitoring is for supervisors; an agent in the project cannot listen in
+	_, err = f.svc.MonitorSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.bob)
+	require.ErrorIs(t, err, ErrChatMonitorForbidden)
+
+	participant, err := f.svc.MonitorSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol)
+	require.NoError(t, err)
+	require.Equal(t, models.ChatParticipantObserver, participant.Role)
+
+	// A monitoring supervisor is announced to agents only and never to the visitor
+	require.Len(t, f.sessions.events, 1)
+	require.True(t, f.sessions.events[0].agentsOnly)
+	require.Empty(t, f.sessions.notices)
+
+	_, err = f.svc.MonitorSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol)
+	require.ErrorIs(t, err, ErrChatAlreadyParticipant)
+
+	message, err := f.svc.Whisper(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol, "Offer the 10% voucher")
+	require.NoError(t, err)
+	require.True(t, message.IsPrivate)
+	require.Equal(t, "Carol", message.AuthorName)
+	require.Equal(t, true, message.Metadata["whisper"])
+
+	// The assigned agent can whisper back without a participant row
+	delete(f.store.active, f.alice)
+	_, err = f.svc.Whisper(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.alice, "Will do")
+	require.NoError(t, err)
+
+	// Leaving as an observer stays invisible to the visitor as well
+	require.NoError(t, f.svc.LeaveSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol))
+	require.True(t, f.sessions.events[len(f.sessions.events)-1].agentsOnly)
+	require.Empty(t, f.sessions.notices)
+
+	// Inviting a supervisor who was monitoring makes them a visible participant
+	_, err = f.svc.MonitorSession(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol)
+	require.NoError(t, err)
+	participant, err = f.svc.InviteAgent(ctx, f.session.TenantID, f.session.ProjectID, f.session.ID, f.carol)
+	require.NoError(t, err)
+	require.Equal(t, models.ChatParticipantParticipant, participant.Role)
+	require.Equal(t, []string{"Our agent Carol has joined the conversation"}, f.sessions.notices)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
 	"github.com/bareuptime/tms/internal/models"
 	"github.com/bareuptime/tms/internal/redis"
 	"github.com/bareuptime/tms/internal/repo"
@@ -20,6 +21,7 @@ type ChatSessionService struct {
 	chatSessionRepo     *repo.ChatSessionRepo
 	chatMessageRepo     *repo.ChatMessageRepo
 	chatWidgetRepo      *repo.ChatWidgetRepo
+	chatParticipantRepo *repo.ChatParticipantRepo
 	redisService        *redis.Service
 	customerRepo        repo.CustomerRepository
 	ticketService       *TicketService
@@ -27,12 +29,15 @@ type ChatSessionService struct {
 	connectionManager   *websocket.ConnectionManager
 	howlingAlarmService *HowlingAlarmService
 	slackService        *SlackService
+	redactor            MessageRedactor
+	translator          MessageTranslator
 }
 
 func NewChatSessionService(
 	chatSessionRepo *repo.ChatSessionRepo,
 	chatMessageRepo *repo.ChatMessageRepo,
 	chatWidgetRepo *repo.ChatWidgetRepo,
+	chatParticipantRepo *repo.ChatParticipantRepo,
 	customerRepo repo.CustomerRepository,
 	ticketService *TicketService,
 	agentService *AgentService,
@@ -46,6 +51,7 @@ func NewChatSessionService(
 		chatMessageRepo:     chatMessageRepo,
 		redisService:        redisService,
 		chatWidgetRepo:      chatWidgetRepo,
+		chatParticipantRepo: chatParticipantRepo,
 		customerRepo:        customerRepo,
 		ticketService:       ticketService,
 		agentService:        agentService,
@@ -55,6 +61,17 @@ func NewChatSessionService(
 	}
 }
 
+// SetMessageRedactor sets the redactor that masks personal data in visitor messages before they are stored
+func (s *ChatSessionService) SetMessageRedactor(redactor MessageRedactor) {
+	s.redactor = redactor
+}
+
+// SetTranslator sets the translator that translates visitor messages for agents and agent replies for
+// visitors
+func (s *ChatSessionService) SetTranslator(translator MessageTranslator) {
+	s.translator = translator
+}
+
 // InitiateChat starts a new chat session
 func (s *ChatSessionService) InitiateChat(ctx context.Context, widgetID uuid.UUID, clientSessionID string, req *models.InitiateChatRequest) (*models.ChatSession, error) {
 	// Get widget to validate and get tenant/project context
@@ -66,10 +83,15 @@ func (s *ChatSessionService) InitiateChat(ctx context.Context, widgetID uuid.UUI
 		return nil, fmt.Errorf("widget not found or inactive")
 	}
 
+	identity, visitorInfo, err := resolveVisitorIdentity(widget, req, time.Now())
+	if err != nil {
+		logger.WarnfCtx(ctx, "Rejected identity token for widget %s: %v", widget.ID, err)
+	}
+
 	// Find or create customer if email provided
 	var customerID *uuid.UUID
-	if req.VisitorEmail != "" {
-		customer, err := s.customerRepo.GetByEmail(ctx, widget.TenantID, req.VisitorEmail)
+	if identity.Email != "" {
+		customer, err := s.customerRepo.GetByEmail(ctx, widget.TenantID, identity.Email)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get customer: %w", err)
 		}
@@ -78,8 +100,8 @@ func (s *ChatSessionService) InitiateChat(ctx context.Context, widgetID uuid.UUI
 			newCustomer := &db.Customer{
 				ID:       uuid.New(),
 				TenantID: widget.TenantID,
-				Email:    req.VisitorEmail,
-				Name:     req.VisitorName,
+				Email:    identity.Email,
+				Name:     identity.Name,
 			}
 			err = s.customerRepo.Create(ctx, newCustomer)
 			if err != nil {
@@ -93,19 +115,20 @@ func (s *ChatSessionService) InitiateChat(ctx context.Context, widgetID uuid.UUI
 
 	// Create chat session
 	session := &models.ChatSession{
-		ID:              uuid.New(),
-		TenantID:        widget.TenantID,
-		ProjectID:       widget.ProjectID,
-		WidgetID:        widget.ID,
-		CustomerID:      customerID,
-		ClientSessionID: clientSessionID,
-		Status:          "active",
-		VisitorInfo:     req.VisitorInfo,
-		StartedAt:       time.Now(),
-		LastActivityAt:  time.Now(),
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
-		UseAI:           widget.UseAI,
+		ID:               uuid.New(),
+		TenantID:         widget.TenantID,
+		ProjectID:        widget.ProjectID,
+		WidgetID:         widget.ID,
+		CustomerID:       customerID,
+		ClientSessionID:  clientSessionID,
+		Status:           "active",
+		VisitorInfo:      visitorInfo,
+		IdentityVerified: identity.Verified,
+		StartedAt:        time.Now(),
+		LastActivityAt:   time.Now(),
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		UseAI:            widget.UseAI,
 	}
 
 	err = s.chatSessionRepo.CreateChatSession(ctx, session)
@@ -193,6 +216,7 @@ func (s *ChatSessionService) AssignAgent(ctx context.Context, tenantID, projectI
 
 func (s *ChatSessionService) AssignAgentWithSessionObj(ctx context.Context, tenantID, projectID, agentID uuid.UUID, session *models.ChatSession) error {
 
+	previousAgentID := session.AssignedAgentID
 	now := time.Now()
 	session.AssignedAgentID = &agentID
 	session.AssignedAt = &now
@@ -203,9 +227,21 @@ func (s *ChatSessionService) AssignAgentWithSessionObj(ctx context.Context, tena
 	}
 
 	// Delete the Redis cache entry for the client session ID since the session has been assigned
-	if session.ClientSessionID != "" {
-		cacheKey := fmt.Sprintf("chat_session:client:%s", session.ClientSessionID)
-		s.redisService.GetClient().Del(ctx, cacheKey)
+	s.invalidateSessionCache(ctx, session)
+
+	if previousAgentID != nil && *previousAgentID != agentID {
+		if _, err := s.chatParticipantRepo.RemoveParticipant(ctx, session.ID, *previousAgentID); err != nil {
+			logger.WarnfCtx(ctx, "Failed to record agent %s leaving chat session %s: %v", *previousAgentID, session.ID, err)
+		}
+	}
+	if err := s.chatParticipantRepo.AddParticipant(ctx, &models.ChatSessionParticipant{
+		SessionID: session.ID,
+		AgentID:   agentID,
+		TenantID:  session.TenantID,
+		Role:      models.ChatParticipantPrimary,
+		JoinedAt:  now,
+	}); err != nil {
+		logger.WarnfCtx(ctx, "Failed to record agent %s as primary of chat session %s: %v", agentID, session.ID, err)
 	}
 
 	// Fetch agent details for the system message
@@ -237,7 +273,42 @@ func (s *ChatSessionService) EndSession(ctx context.Context, tenantID, projectID
 	session.Status = "ended"
 	session.EndedAt = &now
 
-	return s.chatSessionRepo.UpdateChatSession(ctx, session)
+	if err := s.chatSessionRepo.UpdateChatSession(ctx, session); err != nil {
+		return err
+	}
+	if err := s.chatParticipantRepo.RemoveAllParticipants(ctx, sessionID); err != nil {
+		logger.WarnfCtx(ctx, "Failed to release participants of ended chat session %s: %v", sessionID, err)
+	}
+	return nil
+}
+
+// ReassignSession moves an active session from its current assignee to another agent, or back to the
+// project queue when agentID is nil. It reports false when the session changed hands concurrently.
+func (s *ChatSessionService) ReassignSession(ctx context.Context, session *models.ChatSession, agentID *uuid.UUID) (bool, error) {
+	reassigned, err := s.chatSessionRepo.ReassignAgent(ctx, session.ID, session.AssignedAgentID, agentID)
+	if err != nil {
+		return false, fmt.Errorf("failed to reassign chat session: %w", err)
+	}
+	if !reassigned {
+		return false, nil
+	}
+
+	session.AssignedAgentID = agentID
+	session.AssignedAt = nil
+	if agentID != nil {
+		now := time.Now()
+		session.AssignedAt = &now
+	}
+	s.invalidateSessionCache(ctx, session)
+	return true, nil
+}
+
+// invalidateSessionCache drops the cached copy kept for visitor lookups by client session ID
+func (s *ChatSessionService) invalidateSessionCache(ctx context.Context, session *models.ChatSession) {
+	if session.ClientSessionID != "" {
+		cacheKey := fmt.Sprintf("chat_session:client:%s", session.ClientSessionID)
+		s.redisService.GetClient().Del(ctx, cacheKey)
+	}
 }
 
 // SendMessage sends a message in a chat session
@@ -263,6 +334,19 @@ func (s *ChatSessionService) SendMessageWithUuidDetails(ctx context.Context, ten
 	if req.MessageType == "" {
 		req.MessageType = "text"
 	}
+	if authorType == "visitor" && s.redactor != nil {
+		req.Content = s.redactor.RedactStoredMessage(ctx, tenantID, projectID, &sessionID, nil, req.Content)
+	}
+
+	// Agent replies reach the visitor in the language the visitor writes in; Slack keeps the original
+	var reply *models.ReplyTranslation
+	if authorType == "agent" && !req.IsPrivate && s.translator != nil {
+		var err error
+		reply, err = s.translator.TranslateReply(ctx, tenantID, projectID, models.MessageKindChat, sessionID, authorID, req.Content)
+		if err != nil {
+			logger.WarnfCtx(ctx, "Failed to translate agent reply in chat session %s, sending it untranslated: %v", sessionID, err)
+		}
+	}
 
 	message := &models.ChatMessage{
 		ID:            uuid.New(),
@@ -284,9 +368,15 @@ func (s *ChatSessionService) SendMessageWithUuidDetails(ctx context.Context, ten
 	if message.Metadata == nil {
 		message.Metadata = make(models.JSONMap)
 	}
+	if reply != nil {
+		message.Content = reply.Text
+		message.Metadata["original_content"] = reply.Original
+		message.Metadata["original_language"] = reply.SourceLanguage
+		message.Metadata["language"] = reply.TargetLanguage
+	}
 	fmt.Printf("Trying to send message to slack if applicable %s SessionId: %s, || ProjectID: %s || TenantID: %s", authorType, sessionID, projectID, tenantID)
-	// Post to Slack if applicable
-	if !strings.HasPrefix(authorName, "Slack: ") {
+	// Post to Slack if applicable; private messages stay between the agents
+	if !req.IsPrivate && !strings.HasPrefix(authorName, "Slack: ") {
 		go func() {
 			// Fetch session to get meta information
 			session, err := s.chatSessionRepo.GetChatSession(ctx, tenantID, projectID, sessionID)
@@ -313,16 +403,198 @@ func (s *ChatSessionService) SendMessageWithUuidDetails(ctx context.Context, ten
 		assignedAgentID = uuid.Nil
 	}
 
-	go s.broadcastChatMessage(tenantID, projectID, assignedAgentID, sessionID, message, authorType, connID)
+	// Store the message first: it gets its sequence number there, and a client that misses the broadcast
+	// can only catch up on messages that were stored
+	if err := s.chatMessageRepo.CreateChatMessage(ctx, message); err != nil {
+		return nil, fmt.Errorf("failed to store message: %w", err)
+	}
+
+	s.broadcastChatMessage(tenantID, projectID, assignedAgentID, sessionID, message, authorType, connID)
+
+	if reply != nil {
+		if err := s.translator.RecordReply(ctx, tenantID, projectID, models.MessageKindChat, message.ID, sessionID, reply); err != nil {
+			logger.WarnfCtx(ctx, "Failed to save original of message %s in chat session %s: %v", message.ID, sessionID, err)
+		}
+	}
+	if authorType == "visitor" && !message.IsPrivate && s.translator != nil {
+		go s.translateVisitorMessage(tenantID, projectID, sessionID, message.ID, message.Content)
+	}
+
+	// Update session last activity; system notices are not conversation activity and must
+	// not keep an idle session alive
+	if authorType != "system" {
+		go s.chatSessionRepo.UpdateLastActivity(ctx, sessionID)
+	}
+
+	return message, nil
+}
+
+// SendSystemNotice stores a system message in the session and delivers it to the visitor and the agents
+func (s *ChatSessionService) SendSystemNotice(ctx context.Context, session *models.ChatSession, content string) (*models.ChatMessage, error) {
+	message := &models.ChatMessage{
+		ID:          uuid.New(),
+		TenantID:    session.TenantID,
+		ProjectID:   session.ProjectID,
+		SessionID:   session.ID,
+		MessageType: "text",
+		Content:     content,
+		AuthorType:  "system",
+		AuthorName:  "System",
+		Metadata:    make(models.JSONMap),
+		ReadByAgent: true,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := s.chatMessageRepo.CreateChatMessage(ctx, message); err != nil {
+		return nil, fmt.Errorf("failed to store system notice: %w", err)
+	}
+
+	messageData, _ := json.Marshal(message)
+	s.publishSessionEvent(ctx, session, "chat_message", messageData)
+	return message, nil
+}
 
-	go s.chatMessageRepo.CreateChatMessage(ctx, message)
+// SendPrivateMessage stores an agent message that only the agents of the session can see, such as a
+// supervisor whisper or a handover note, and delivers it to them
+func (s *ChatSessionService) SendPrivateMessage(ctx context.Context, session *models.ChatSession, agentID uuid.UUID, agentName, content string, metadata models.JSONMap) (*models.ChatMessage, error) {
+	if metadata == nil {
+		metadata = make(models.JSONMap)
+	}
+	message := &models.ChatMessage{
+		ID:          uuid.New(),
+		TenantID:    session.TenantID,
+		ProjectID:   session.ProjectID,
+		SessionID:   session.ID,
+		MessageType: "text",
+		Content:     content,
+		AuthorType:  "agent",
+		AuthorID:    &agentID,
+		AuthorName:  agentName,
+		Metadata:    metadata,
+		IsPrivate:   true,
+		ReadByAgent: true,
+		CreatedAt:   time.Now(),
+	}
 
-	// Update session last activity
-	go s.chatSessionRepo.UpdateLastActivity(ctx, sessionID)
+	if err := s.chatMessageRepo.CreateChatMessage(ctx, message); err != nil {
+		return nil, fmt.Errorf("failed to store private message: %w", err)
+	}
 
+	messageData, _ := json.Marshal(message)
+	if s.connectionManager != nil {
+		go s.deliverAgentMessage(session.TenantID, session.ProjectID, session.ID, messageData, agentID)
+	}
 	return message, nil
 }
 
+// PublishSessionEnded tells the visitor and the agents that a session has ended
+func (s *ChatSessionService) PublishSessionEnded(ctx context.Context, session *models.ChatSession, reason string) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"session_id": session.ID,
+		"status":     "ended",
+		"reason":     reason,
+		"ended_at":   session.EndedAt,
+	})
+	s.publishSessionEvent(ctx, session, string(models.WSMsgTypeSessionEnded), data)
+}
+
+// PublishParticipantEvent delivers a participant change (agent joined or left) of a session. Events about
+// observers are delivered to agents only, so the visitor never learns a supervisor is watching.
+func (s *ChatSessionService) PublishParticipantEvent(ctx context.Context, session *models.ChatSession, eventType string, data json.RawMessage, agentsOnly bool) {
+	if !agentsOnly {
+		s.publishSessionEvent(ctx, session, eventType, data)
+		return
+	}
+	if s.connectionManager == nil {
+		return
+	}
+
+	agentID := uuid.Nil
+	if session.AssignedAgentID != nil {
+		agentID = *session.AssignedAgentID
+	}
+	msg := &websocket.Message{
+		Type:         eventType,
+		SessionID:    session.ID,
+		Data:         data,
+		FromType:     websocket.ConnectionTypeVisitor,
+		ProjectID:    &session.ProjectID,
+		TenantID:     &session.TenantID,
+		AgentID:      &agentID,
+		DeliveryType: websocket.Direct,
+		Timestamp:    time.Now(),
+	}
+	if err := s.connectionManager.DeliverWebSocketMessage(session.ID, msg); err != nil {
+		logger.ErrorfCtx(ctx, err, "Failed to publish %s for session %s", eventType, session.ID)
+	}
+	s.deliverToParticipants(ctx, session, eventType, data, agentID)
+}
+
+// publishSessionEvent delivers an event to the session connections (visitor side), to the
+// assigned agent, or every project agent when nobody is assigned, and to the other agents in the session
+func (s *ChatSessionService) publishSessionEvent(ctx context.Context, session *models.ChatSession, eventType string, data json.RawMessage) {
+	if s.connectionManager == nil {
+		return
+	}
+
+	agentID := uuid.Nil
+	if session.AssignedAgentID != nil {
+		agentID = *session.AssignedAgentID
+	}
+
+	for _, fromType := range []websocket.ConnectionType{websocket.ConnectionTypeAgent, websocket.ConnectionTypeVisitor} {
+		msg := &websocket.Message{
+			Type:         eventType,
+			SessionID:    session.ID,
+			Data:         data,
+			FromType:     fromType,
+			ProjectID:    &session.ProjectID,
+			TenantID:     &session.TenantID,
+			AgentID:      &agentID,
+			DeliveryType: websocket.Direct,
+			Timestamp:    time.Now(),
+		}
+		if err := s.connectionManager.DeliverWebSocketMessage(session.ID, msg); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to publish %s for session %s", eventType, session.ID)
+		}
+	}
+	s.deliverToParticipants(ctx, session, eventType, data, agentID)
+}
+
+// deliverToParticipants delivers an event to each agent still in the session except the excluded one.
+// Messages from the visitor side are routed to the agent named in AgentID.
+func (s *ChatSessionService) deliverToParticipants(ctx context.Context, session *models.ChatSession, eventType string, data json.RawMessage, exclude uuid.UUID) {
+	if s.chatParticipantRepo == nil {
+		return
+	}
+	participants, err := s.chatParticipantRepo.ListActiveParticipants(ctx, session.TenantID, session.ID)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Failed to list participants of chat session %s: %v", session.ID, err)
+		return
+	}
+
+	for _, participant := range participants {
+		agentID := participant.AgentID
+		if agentID == exclude {
+			continue
+		}
+		msg := &websocket.Message{
+			Type:         eventType,
+			SessionID:    session.ID,
+			Data:         data,
+			FromType:     websocket.ConnectionTypeVisitor,
+			ProjectID:    &session.ProjectID,
+			TenantID:     &session.TenantID,
+			AgentID:      &agentID,
+			DeliveryType: websocket.Direct,
+			Timestamp:    time.Now(),
+		}
+		if err := s.connectionManager.DeliverWebSocketMessage(session.ID, msg); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to deliver %s for session %s to agent %s", eventType, session.ID, agentID)
+		}
+	}
+}
+
 // broadcastChatMessage builds and delivers a websocket.Message for a chat message.
 func (s *ChatSessionService) broadcastChatMessage(tenantID, projectID, assignedAgentID, sessionID uuid.UUID, message *models.ChatMessage, authorType, connID string) {
 	if s.connectionManager == nil {
@@ -331,18 +603,7 @@ func (s *ChatSessionService) broadcastChatMessage(tenantID, projectID, assignedA
 
 	messageData, _ := json.Marshal(message)
 
-	// Determine the FromType based on authorType
-	var fromType websocket.ConnectionType
-	switch authorType {
-	case "visitor":
-		fromType = websocket.ConnectionTypeVisitor
-	case "agent":
-		fromType = websocket.ConnectionTypeAgent
-	case "ai-agent":
-		fromType = websocket.ConnectionTypeAiAgent
-	default:
-		fromType = websocket.ConnectionTypeVisitor // Default to visitor for system messages
-	}
+	fromType := chatMessageFromType(authorType)
 
 	broadcastMsg := &websocket.Message{
 		Type:         "chat_message",
@@ -356,17 +617,93 @@ func (s *ChatSessionService) broadcastChatMessage(tenantID, projectID, assignedA
 		Timestamp:    time.Now(),
 	}
 
-	// Deliver the message to all session connections
+	if message.IsPrivate || fromType == websocket.ConnectionTypeAgent {
+		go s.deliverAgentMessage(tenantID, projectID, sessionID, messageData, assignedAgentID)
+	}
+	// Private messages (whispers, handover notes) never reach the visitor
+	if message.IsPrivate {
+		return
+	}
+
+	// Deliver the message to all session connections. Publishing inline keeps the messages of a
+	// session in sequence order.
 	switch fromType {
 	case websocket.ConnectionTypeVisitor, websocket.ConnectionTypeAgent:
-		go s.connectionManager.DeliverWebSocketMessage(sessionID, broadcastMsg)
+		s.connectionManager.DeliverWebSocketMessage(sessionID, broadcastMsg)
 	case websocket.ConnectionTypeAiAgent:
 		fmt.Println("AI Agent message - sending to connection:", connID)
 		s.connectionManager.SendToConnection(connID, broadcastMsg)
 		broadcastMsg.FromType = websocket.ConnectionTypeVisitor
-		go s.connectionManager.DeliverWebSocketMessage(sessionID, broadcastMsg)
+		s.connectionManager.DeliverWebSocketMessage(sessionID, broadcastMsg)
+	}
+
+}
+
+// chatMessageFromType determines the FromType of a message based on its authorType
+func chatMessageFromType(authorType string) websocket.ConnectionType {
+	switch authorType {
+	case "visitor":
+		return websocket.ConnectionTypeVisitor
+	case "agent":
+		return websocket.ConnectionTypeAgent
+	case "ai-agent":
+		return websocket.ConnectionTypeAiAgent
+	default:
+		return websocket.ConnectionTypeVisitor // Default to visitor for system messages
+	}
+}
+
+// deliverAgentMessage delivers a message written by an agent to the other agents of the session. Visitor
+// messages already reach every project agent, but agent messages otherwise only reach the visitor.
+func (s *ChatSessionService) deliverAgentMessage(tenantID, projectID, sessionID uuid.UUID, messageData json.RawMessage, authorID uuid.UUID) {
+	ctx := context.Background()
+	session, err := s.chatSessionRepo.GetChatSession(ctx, tenantID, projectID, sessionID)
+	if err != nil || session == nil {
+		return
+	}
+
+	// Sessions assigned before participants were recorded have no row for their assignee
+	if session.AssignedAgentID != nil && *session.AssignedAgentID != authorID {
+		participant, err := s.chatParticipantRepo.GetActiveParticipant(ctx, sessionID, *session.AssignedAgentID)
+		if err == nil && participant == nil {
+			assigneeID := *session.AssignedAgentID
+			msg := &websocket.Message{
+				Type:         "chat_message",
+				SessionID:    sessionID,
+				Data:         messageData,
+				FromType:     websocket.ConnectionTypeVisitor,
+				ProjectID:    &projectID,
+				TenantID:     &tenantID,
+				AgentID:      &assigneeID,
+				DeliveryType: websocket.Direct,
+				Timestamp:    time.Now(),
+			}
+			s.connectionManager.DeliverWebSocketMessage(sessionID, msg)
+		}
+	}
+	s.deliverToParticipants(ctx, session, "chat_message", messageData, authorID)
+}
+
+// translateVisitorMessage translates a visitor message into the language of the session's agent and
+// delivers the translation to the agents of the session
+func (s *ChatSessionService) translateVisitorMessage(tenantID, projectID, sessionID, messageID uuid.UUID, content string) {
+	ctx := context.Background()
+	session, err := s.chatSessionRepo.GetChatSession(ctx, tenantID, projectID, sessionID)
+	if err != nil || session == nil {
+		return
 	}
 
+	translation, err := s.translator.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindChat, messageID, sessionID, session.AssignedAgentID, content)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Failed to translate message %s in chat session %s: %v", messageID, sessionID, err)
+		return
+	}
+	if translation == nil {
+		return
+	}
+
+	data, _ := json.Marshal(translation)
+	s.PublishParticipantEvent(ctx, session, string(models.WSMsgTypeMessageTranslation), data, true)
 }
 
 // GetChatMessages gets messages for a chat session
@@ -374,11 +711,96 @@ func (s *ChatSessionService) GetChatMessages(ctx context.Context, tenantID, proj
 	return s.chatMessageRepo.ListChatMessages(ctx, tenantID, projectID, sessionID, includePrivate)
 }
 
+// GetContextSummary gets the rolling summary the AI is given in place of the older messages of a session
+func (s *ChatSessionService) GetContextSummary(ctx context.Context, sessionID uuid.UUID) (*models.ChatContextSummary, error) {
+	return s.chatSessionRepo.GetContextSummary(ctx, sessionID)
+}
+
+// SaveContextSummary saves the rolling summary of a session's messages up to and including seq
+func (s *ChatSessionService) SaveContextSummary(ctx context.Context, sessionID uuid.UUID, summary string, seq int64) error {
+	return s.chatSessionRepo.UpdateContextSummary(ctx, sessionID, summary, seq)
+}
+
 // GetChatMessagesForSession gets messages for a session (public access)
 func (s *ChatSessionService) GetChatMessagesForSession(ctx context.Context, sessionID uuid.UUID) ([]*models.ChatMessage, error) {
 	return s.chatMessageRepo.ListChatMessagesForSession(ctx, sessionID)
 }
 
+// ListVisitorConversations lists the past conversations of the visitor an identity token was issued for.
+// Only sessions started with a verified identity are returned, so a self-reported email never
+// unlocks someone else's history.
+func (s *ChatSessionService) ListVisitorConversations(ctx context.Context, widgetID uuid.UUID, identityToken string) ([]*models.VisitorConversation, error) {
+	widget, customerID, err := s.verifiedVisitor(ctx, widgetID, identityToken)
+	if err != nil {
+		return nil, err
+	}
+	conversations := []*models.VisitorConversation{}
+	if customerID == nil {
+		return conversations, nil
+	}
+
+	sessions, err := s.chatSessionRepo.ListVerifiedSessionsForCustomer(ctx, widget.TenantID, widget.ProjectID, *customerID, visitorConversationsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	for _, session := range sessions {
+		conversations = append(conversations, &models.VisitorConversation{
+			ID:                session.ID,
+			Status:            session.Status,
+			AssignedAgentName: session.AssignedAgentName,
+			TicketID:          session.TicketID,
+			StartedAt:         session.StartedAt,
+			EndedAt:           session.EndedAt,
+			LastActivityAt:    session.LastActivityAt,
+		})
+	}
+	return conversations, nil
+}
+
+// GetVisitorConversationMessages returns the public messages of one of the verified visitor's conversations
+func (s *ChatSessionService) GetVisitorConversationMessages(ctx context.Context, widgetID, sessionID uuid.UUID, identityToken string) ([]*models.ChatMessage, error) {
+	widget, customerID, err := s.verifiedVisitor(ctx, widgetID, identityToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.chatSessionRepo.GetChatSession(ctx, widget.TenantID, widget.ProjectID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat session: %w", err)
+	}
+	if session == nil || customerID == nil || !session.IdentityVerified ||
+		session.CustomerID == nil || *session.CustomerID != *customerID {
+		return nil, ErrChatSessionNotFound
+	}
+
+	return s.chatMessageRepo.ListChatMessages(ctx, widget.TenantID, widget.ProjectID, session.ID, false)
+}
+
+// verifiedVisitor checks an identity token against the widget and returns the matching customer, if any
+func (s *ChatSessionService) verifiedVisitor(ctx context.Context, widgetID uuid.UUID, identityToken string) (*models.ChatWidget, *uuid.UUID, error) {
+	widget, err := s.chatWidgetRepo.GetChatWidgetById(ctx, widgetID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get widget: %w", err)
+	}
+	if widget == nil || !widget.IsActive || !widget.IdentityVerificationEnabled || widget.IdentitySecret == nil {
+		return nil, nil, ErrIdentityVerificationDisabled
+	}
+
+	claims, err := VerifyVisitorIdentity(*widget.IdentitySecret, identityToken, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	customer, err := s.customerRepo.GetByEmail(ctx, widget.TenantID, claims.Email)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+	if customer == nil {
+		return widget, nil, nil
+	}
+	return widget, &customer.ID, nil
+}
+
 // MarkMessagesAsRead marks messages as read
 func (s *ChatSessionService) MarkAgentMessagesAsRead(ctx context.Context, tenantID, projectID, sessionID, messageID uuid.UUID, readerType string) error {
 	return s.chatMessageRepo.MarkAgentMessagesAsRead(ctx, tenantID, projectID, sessionID, messageID, readerType)
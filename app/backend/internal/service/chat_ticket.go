@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+const (
+	// Converting unanswered chats is off until a widget opts in; existing widgets get the same from the migration
+	defaultUnansweredTicketMinutes = 0
+
+	// unansweredSweepBatchSize bounds the sessions converted per sweep
+	unansweredSweepBatchSize = 100
+
+	unansweredCloseReason = "converted_to_ticket"
+)
+
+var (
+	ErrChatSessionNotFound  = errors.New("chat session not found")
+	ErrChatAlreadyConverted = errors.New("chat session has already been converted to a ticket")
+	ErrChatWithoutEmail     = errors.New("the visitor has not shared an email address, so the chat cannot become a ticket")
+)
+
+// chatTicketStore is the part of the chat session repository chat conversion needs
+type chatTicketStore interface {
+	ListUnansweredSessions(ctx context.Context, now time.Time, limit int) ([]*models.ChatSession, error)
+	CreateLinkedTicket(ctx context.Context, sessionID uuid.UUID, ticket *db.Ticket, message *db.TicketMessage) (bool, error)
+}
+
+// chatTicketSessions loads and ends sessions; implemented by ChatSessionService
+type chatTicketSessions interface {
+	chatSessionNotifier
+	GetChatSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error)
+	EndSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) error
+}
+
+// chatTicketCreator creates tickets and links customers to them; implemented by TicketService
+type chatTicketCreator interface {
+	CreateTicketWith(ctx context.Context, tenantID, projectID, agentID uuid.UUID, req CreateTicketRequest, insert TicketInserter) (*db.Ticket, bool, error)
+	CustomerMagicLink(ticket *db.Ticket) (string, error)
+}
+
+// ChatTicketService converts chat sessions into tickets, on request of an agent or
+// automatically when a visitor has been left without an answer
+type ChatTicketService struct {
+	store         chatTicketStore
+	sessions      chatTicketSessions
+	tickets       chatTicketCreator
+	emailProvider EmailProvider
+}
+
+// NewChatTicketService creates a new chat ticket service
+func NewChatTicketService(store chatTicketStore, sessions chatTicketSessions, tickets chatTicketCreator, emailProvider EmailProvider) *ChatTicketService {
+	return &ChatTicketService{
+		store:         store,
+		sessions:      sessions,
+		tickets:       tickets,
+		emailProvider: emailProvider,
+	}
+}
+
+// ConvertSessionToTicket converts a session of the project into a ticket assigned to the converting agent
+func (s *ChatTicketService) ConvertSessionToTicket(ctx context.Context, tenantID, projectID, sessionID, agentID uuid.UUID) (*db.Ticket, error) {
+	session, err := s.sessions.GetChatSession(ctx, tenantID, projectID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrChatSessionNotFound
+	}
+	return s.ConvertSession(ctx, session, &agentID)
+}
+
+// ConvertSession claims the session and, in the same transaction, creates a ticket with the chat
+// transcript as its first message. The visitor is then emailed a link to follow up. A nil agentID
+// leaves the ticket unassigned.
+func (s *ChatTicketService) ConvertSession(ctx context.Context, session *models.ChatSession, agentID *uuid.UUID) (*db.Ticket, error) {
+	if session.TicketID != nil {
+		return nil, ErrChatAlreadyConverted
+	}
+	if session.CustomerEmail == nil || *session.CustomerEmail == "" {
+		return nil, ErrChatWithoutEmail
+	}
+	requesterName := *session.CustomerEmail
+	if session.CustomerName != nil && *session.CustomerName != "" {
+		requesterName = *session.CustomerName
+	}
+
+	messages, err := s.sessions.GetChatMessagesForSession(ctx, session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat messages: %w", err)
+	}
+
+	req := CreateTicketRequest{
+		Subject:                  fmt.Sprintf("Chat with %s", requesterName),
+		Priority:                 "normal",
+		Type:                     "question",
+		Source:                   "chat",
+		RequesterEmail:           *session.CustomerEmail,
+		RequesterName:            requesterName,
+		InitialMessage:           formatChatTranscript(messages),
+		SkipCustomerNotification: true,
+	}
+	createdBy := uuid.Nil
+	if agentID != nil {
+		assignee := agentID.String()
+		req.AssigneeAgentID = &assignee
+		createdBy = *agentID
+	}
+
+	ticket, created, err := s.tickets.CreateTicketWith(ctx, session.TenantID, session.ProjectID, createdBy, req,
+		func(ctx context.Context, ticket *db.Ticket, message *db.TicketMessage) (bool, error) {
+			return s.store.CreateLinkedTicket(ctx, session.ID, ticket, message)
+		})
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		// Converted concurrently; the other conversion owns the ticket and the follow-up email
+		return nil, ErrChatAlreadyConverted
+	}
+	session.TicketID = &ticket.ID
+
+	if s.emailProvider != nil {
+		link, err := s.tickets.CustomerMagicLink(ticket)
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to create follow-up link for chat session %s", session.ID)
+		}
+		if err := s.emailProvider.SendChatFollowUpEmail(ctx, ticket, link, *session.CustomerEmail, requesterName); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to send follow-up email for chat session %s", session.ID)
+		}
+	}
+	return ticket, nil
+}
+
+// RunSweeper converts unanswered sessions every interval until ctx is cancelled
+func (s *ChatTicketService) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		converted, err := s.Sweep(ctx, time.Now())
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Unanswered chat sweep failed")
+		} else if converted > 0 {
+			logger.InfofCtx(ctx, "Unanswered chat sweep converted %d session(s) to tickets", converted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep converts sessions whose visitor has waited past the widget's threshold without an answer,
+// tells the visitor the conversation continues by email and ends the chat
+func (s *ChatTicketService) Sweep(ctx context.Context, now time.Time) (int, error) {
+	sessions, err := s.store.ListUnansweredSessions(ctx, now, unansweredSweepBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list unanswered sessions: %w", err)
+	}
+
+	converted := 0
+	for _, session := range sessions {
+		ticket, err := s.ConvertSession(ctx, session, nil)
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to convert unanswered chat session %s", session.ID)
+			continue
+		}
+		converted++
+
+		notice := fmt.Sprintf("Sorry we couldn't reply sooner. We've created ticket #%d from this conversation and emailed %s a link to follow up.",
+			ticket.Number, *session.CustomerEmail)
+		if _, err := s.sessions.SendSystemNotice(ctx, session, notice); err != nil {
+			logger.WarnfCtx(ctx, "Failed to post ticket notice to chat session %s: %v", session.ID, err)
+		}
+
+		if err := s.sessions.EndSession(ctx, session.TenantID, session.ProjectID, session.ID); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to end converted chat session %s", session.ID)
+			continue
+		}
+		session.Status = "ended"
+		session.EndedAt = &now
+		s.sessions.PublishSessionEnded(ctx, session, unansweredCloseReason)
+	}
+
+	return converted, nil
+}
+
+// formatChatTranscript renders chat messages as the initial message of a ticket
+func formatChatTranscript(messages []*models.ChatMessage) string {
+	var b strings.Builder
+	b.WriteString("Chat transcript\n")
+	for _, message := range messages {
+		if message.AuthorType == "system" || message.IsPrivate {
+			continue
+		}
+		author := message.AuthorName
+		if author == "" {
+			author = message.AuthorType
+		}
+		fmt.Fprintf(&b, "\n[%s] %s: %s", message.CreatedAt.Format("2006-01-02 15:04"), author, message.Content)
+	}
+	return b.String()
+}
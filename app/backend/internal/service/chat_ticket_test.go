@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeChatTicketStore struct {
+	unanswered []*models.ChatSession
+	links      map[uuid.UUID]uuid.UUID
+	messages   []*db.TicketMessage
+	nextNumber int
+}
+
+func (f *fakeChatTicketStore) ListUnansweredSessions(ctx context.Context, now time.Time, limit int) ([]*models.ChatSession, error) {
+	return f.unanswered, nil
+}
+
+func (f *fakeChatTicketStore) CreateLinkedTicket(ctx context.Context, sessionID uuid.UUID, ticket *db.Ticket, message *db.TicketMessage) (bool, error) {
+	if _, ok := f.links[sessionID]; ok {
+		return false, nil
+	}
+	f.nextNumber++
+	ticket.Number = 100 + f.nextNumber
+	f.links[sessionID] = ticket.ID
+	f.messages = append(f.messages, message)
+	return true, nil
+}
+
+type fakeChatTicketSessions struct {
+	fakeChatSessionNotifier
+	sessions map[uuid.UUID]*models.ChatSession
+	endedIDs []uuid.UUID
+}
+
+func (f *fakeChatTicketSessions) GetChatSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	session := f.sessions[sessionID]
+	if session == nil || session.TenantID != tenantID || session.ProjectID != projectID {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (f *fakeChatTicketSessions) EndSession(ctx context.Context, tenantID, projectID, sessionID uuid.UUID) error {
+	f.endedIDs = append(f.endedIDs, sessionID)
+	return nil
+}
+
+type fakeChatTicketCreator struct {
+	requests  []CreateTicketRequest
+	createdBy []uuid.UUID
+}
+
+func (f *fakeChatTicketCreator) CreateTicketWith(ctx context.Context, tenantID, projectID, agentID uuid.UUID, req CreateTicketRequest, insert TicketInserter) (*db.Ticket, bool, error) {
+	f.requests = append(f.requests, req)
+	f.createdBy = append(f.createdBy, agentID)
+	url := "https://tickets.example.com/tickets/" + uuid.NewString()
+	ticket := &db.Ticket{ID: uuid.New(), TenantID: tenantID, ProjectID: projectID, Subject: req.Subject, Source: req.Source, TicketURL: &url}
+	message := &db.TicketMessage{ID: uuid.New(), TicketID: ticket.ID, Body: req.InitialMessage}
+	inserted, err := insert(ctx, ticket, message)
+	if err != nil || !inserted {
+		return nil, false, err
+	}
+	return ticket, true, nil
+}
+
+func (f *fakeChatTicketCreator) CustomerMagicLink(ticket *db.Ticket) (string, error) {
+	return "https://tickets.example.com/tickets/" + ticket.ID.String() + "?token=magic", nil
+}
+
+type followUpEmail struct {
+	to     string
+	name   string
+	link   string
+	ticket *db.Ticket
+}
+
+type fakeFollowUpEmailProvider struct {
+	EmailProvider
+	sent []followUpEmail
+}
+
+func (f *fakeFollowUpEmailProvider) SendChatFollowUpEmail(ctx context.Context, ticket *db.Ticket, link, toEmail, recipientName string) error {
+	f.sent = append(f.sent, followUpEmail{to: toEmail, name: recipientName, link: link, ticket: ticket})
+	return nil
+}
+
+func newChatTicketFixture() (*ChatTicketService, *fakeChatTicketStore, *fakeChatTicketSessions, *fakeChatTicketCreator, *fakeFollowUpEmailProvider) {
+	store := &fakeChatTicketStore{links: map[uuid.UUID]uuid.UUID{}}
+	sessions := &fakeChatTicketSessions{
+		fakeChatSessionNotifier: fakeChatSessionNotifier{
+			notices: map[uuid.UUID][]string{},
+			ended:   map[uuid.UUID]string{},
+			messages: []*models.ChatMessage{
+				{AuthorType: "visitor", AuthorName: "Jane", Content: "Is anyone there?", CreatedAt: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)},
+				{AuthorType: "agent", AuthorName: "Bob", Content: "internal note", IsPrivate: true, CreatedAt: time.Date(2025, 6, 1, 9, 1, 0, 0, time.UTC)},
+			},
+		},
+		sessions: map[uuid.UUID]*models.ChatSession{},
+	}
+	tickets := &fakeChatTicketCreator{}
+	emails := &fakeFollowUpEmailProvider{}
+	return NewChatTicketService(store, sessions, tickets, emails), store, sessions, tickets, emails
+}
+
+func newVisitorSession(email, name string) *models.ChatSession {
+	session := &models.ChatSession{ID: uuid.New(), TenantID: uuid.New(), ProjectID: uuid.New(), Status: "active"}
+	if email != "" {
+		session.CustomerEmail = &email
+	}
+	if name != "" {
+		session.CustomerName = &name
+	}
+	return session
+}
+
+func TestChatTicketSweepConvertsUnansweredSessions(t *testing.T) {
+	svc, store, sessions, tickets, emails := newChatTicketFixture()
+	now := time.Date(2025, 6, 1, 9, 30, 0, 0, time.UTC)
+
+	waiting := newVisitorSession("jane@example.com", "Jane")
+	store.unanswered = []*models.ChatSession{waiting}
+
+	converted, err := svc.Sweep(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 1, converted)
+
+	// The ticket is unassigned, sourced from chat and starts with the public transcript
+	require.Len(t, tickets.requests, 1)
+	req := tickets.requests[0]
+	require.Equal(t, "chat", req.Source)
+	require.Equal(t, "Chat with Jane", req.Subject)
+	require.Equal(t, "jane@example.com", req.RequesterEmail)
+	require.Nil(t, req.AssigneeAgentID)
+	require.True(t, req.SkipCustomerNotification)
+	require.Equal(t, "Chat transcript\n\n[2025-06-01 09:00] Jane: Is anyone there?", req.InitialMessage)
+	require.Equal(t, uuid.Nil, tickets.createdBy[0])
+
+	require.Equal(t, *waiting.TicketID, store.links[waiting.ID])
+
+	// The visitor gets a follow-up link, a notice in the chat, and the chat ends
+	require.Len(t, emails.sent, 1)
+	require.Equal(t, "jane@example.com", emails.sent[0].to)
+	require.Equal(t, 101, emails.sent[0].ticket.Number)
+	require.Equal(t, "https://tickets.example.com/tickets/"+waiting.TicketID.String()+"?token=magic", emails.sent[0].link)
+	require.Equal(t, []string{"Sorry we couldn't reply sooner. We've created ticket #101 from this conversation and emailed jane@example.com a link to follow up."},
+		sessions.notices[waiting.ID])
+	require.Equal(t, []uuid.UUID{waiting.ID}, sessions.endedIDs)
+	require.Equal(t, unansweredCloseReason, sessions.ended[waiting.ID])
+	require.Equal(t, "ended", waiting.Status)
+}
+
+func TestChatTicketConvertByAgent(t *testing.T) {
+	svc, _, sessions, tickets, emails := newChatTicketFixture()
+	agentID := uuid.New()
+
+	session := newVisitorSession("sam@example.com", "")
+	sessions.sessions[session.ID] = session
+
+	ticket, err := svc.ConvertSessionToTicket(context.Background(), session.TenantID, session.ProjectID, session.ID, agentID)
+	require.NoError(t, err)
+	require.Equal(t, 101, ticket.Number)
+	require.Equal(t, "Chat with sam@example.com", tickets.requests[0].Subject)
+	require.Equal(t, agentID.String(), *tickets.requests[0].AssigneeAgentID)
+	require.Equal(t, agentID, tickets.createdBy[0])
+	require.Len(t, emails.sent, 1)
+
+	// Converting again is refused, and the chat stays open for the agent
+	_, err = svc.ConvertSessionToTicket(context.Background(), session.TenantID, session.ProjectID, session.ID, agentID)
+	require.ErrorIs(t, err, ErrChatAlreadyConverted)
+	require.Empty(t, sessions.endedIDs)
+	require.Empty(t, sessions.notices)
+}
+
+func TestChatTicketConcurrentConversionCreatesNothing(t *testing.T) {
+	svc, store, _, _, emails := newChatTicketFixture()
+
+	// Another conversion claimed the session after it was loaded here
+	session := newVisitorSession("jane@example.com", "Jane")
+	store.links[session.ID] = uuid.New()
+
+	_, err := svc.ConvertSession(context.Background(), session, nil)
+	require.ErrorIs(t, err, ErrChatAlreadyConverted)
+	require.Empty(t, store.messages)
+	require.Empty(t, emails.sent)
+	require.Nil(t, session.TicketID)
+}
+
+func TestChatTicketConvertRejectsMissingSessionOrEmail(t *testing.T) {
+	svc, _, sessions, tickets, _ := newChatTicketFixture()
+
+	_, err := svc.ConvertSessionToTicket(context.Background(), uuid.New(), uuid.New(), uuid.New(), uuid.New())
+	require.ErrorIs(t, err, ErrChatSessionNotFound)
+
+	anonymous := newVisitorSession("", "Anonymous")
+	sessions.sessions[anonymous.ID] = anonymous
+	_, err = svc.ConvertSessionToTicket(context.Background(), anonymous.TenantID, anonymous.ProjectID, anonymous.ID, uuid.New())
+	require.ErrorIs(t, err, ErrChatWithoutEmail)
+	require.Empty(t, tickets.requests)
+}
+
+func TestFormatChatTranscript(t *testing.T) {
+	at := time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC)
+	transcript := formatChatTranscript([]*models.ChatMessage{
+		{AuthorType: "visitor", AuthorName: "Jane", Content: "My order is late", CreatedAt: at},
+		{AuthorType: "system", AuthorName: "System", Content: "Our agent Bob has joined the conversation", CreatedAt: at},
+		{AuthorType: "agent", Content: "Let me check", CreatedAt: at.Add(time.Minute)},
+	})
+
+	require.Equal(t, "Chat transcript\n\n[2025-03-01 10:30] Jane: My order is late\n[2025-03-01 10:31] agent: Let me check", transcript)
+}
+
+func TestBuildChatFollowUpEmailLinksTicket(t *testing.T) {
+	url := "https://tickets.example.com/tickets/abc?token=magic"
+	subject, htmlBody, textBody := buildChatFollowUpEmail(&db.Ticket{Number: 42, Subject: "Chat with <Jane>"}, url, "Jane", "jane@example.com")
+
+	require.Equal(t, "We'll follow up on your chat: ticket #42", subject)
+	require.Contains(t, htmlBody, `href="https://tickets.example.com/tickets/abc?token=magic"`)
+	require.Contains(t, htmlBody, "Chat with &lt;Jane&gt;")
+	require.Contains(t, textBody, "View and reply to your ticket: "+url)
+}
@@ -17,12 +17,14 @@ import (
 type ChatWidgetService struct {
 	chatWidgetRepo *repo.ChatWidgetRepo
 	domainRepo     *repo.DomainValidationRepo
+	planService    *PlanService
 }
 
-func NewChatWidgetService(chatWidgetRepo *repo.ChatWidgetRepo, domainRepo *repo.DomainValidationRepo) *ChatWidgetService {
+func NewChatWidgetService(chatWidgetRepo *repo.ChatWidgetRepo, domainRepo *repo.DomainValidationRepo, planService *PlanService) *ChatWidgetService {
 	return &ChatWidgetService{
 		chatWidgetRepo: chatWidgetRepo,
 		domainRepo:     domainRepo,
+		planService:    planService,
 	}
 }
 
@@ -38,6 +40,10 @@ func normalizeDomainURL(domainURL string) string {
 
 // CreateChatWidget creates a new chat widget
 func (s *ChatWidgetService) CreateChatWidget(ctx context.Context, tenantID, projectID uuid.UUID, req *models.CreateChatWidgetRequest) (*models.ChatWidget, error) {
+	// Enforce the widget limit of the tenant's plan
+	if err := s.planService.CheckWidgetLimit(ctx, tenantID); err != nil {
+		return nil, err
+	}
 
 	// Normalize domain_url
 	if req.DomainURL == "" {
@@ -90,6 +96,21 @@ func (s *ChatWidgetService) CreateChatWidget(ctx context.Context, tenantID, proj
 		req.AgentAvatarURL = nil
 	}
 
+	idleWarningMinutes, idleTimeoutMinutes := defaultIdleWarningMinutes, defaultIdleTimeoutMinutes
+	if req.IdleWarningMinutes != nil {
+		idleWarningMinutes = *req.IdleWarningMinutes
+	}
+	if req.IdleTimeoutMinutes != nil {
+		idleTimeoutMinutes = *req.IdleTimeoutMinutes
+	}
+	if req.TranscriptArchiveEmail != nil && *req.TranscriptArchiveEmail == "" {
+		req.TranscriptArchiveEmail = nil
+	}
+	unansweredTicketMinutes := defaultUnansweredTicketMinutes
+	if req.UnansweredTicketMinutes != nil {
+		unansweredTicketMinutes = *req.UnansweredTicketMinutes
+	}
+
 	widget := &models.ChatWidget{
 		ID:               uuid.New(),
 		TenantID:         tenantID,
@@ -120,8 +141,16 @@ func (s *ChatWidgetService) CreateChatWidget(ctx context.Context, tenantID, proj
 		RequireEmail:     req.RequireEmail,
 		RequireName:      req.RequireName,
 		BusinessHours:    req.BusinessHours,
-		CreatedAt:        time.Now(),
-		UpdatedAt:        time.Now(),
+
+		IdleWarningMinutes:     idleWarningMinutes,
+		IdleTimeoutMinutes:     idleTimeoutMinutes,
+		SendTranscript:         req.SendTranscript,
+		TranscriptArchiveEmail: req.TranscriptArchiveEmail,
+
+		UnansweredTicketMinutes: unansweredTicketMinutes,
+
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
 	err := s.chatWidgetRepo.CreateChatWidget(ctx, widget)
@@ -246,6 +275,31 @@ func (s *ChatWidgetService) UpdateChatWidget(ctx context.Context, tenantID, proj
 	if req.BusinessHours != nil {
 		widget.BusinessHours = *req.BusinessHours
 	}
+	if req.IdleWarningMinutes != nil {
+		widget.IdleWarningMinutes = *req.IdleWarningMinutes
+	}
+	if req.IdleTimeoutMinutes != nil {
+		widget.IdleTimeoutMinutes = *req.IdleTimeoutMinutes
+	}
+	if req.SendTranscript != nil {
+		widget.SendTranscript = *req.SendTranscript
+	}
+	if req.TranscriptArchiveEmail != nil {
+		if *req.TranscriptArchiveEmail == "" {
+			widget.TranscriptArchiveEmail = nil
+		} else {
+			widget.TranscriptArchiveEmail = req.TranscriptArchiveEmail
+		}
+	}
+	if req.UnansweredTicketMinutes != nil {
+		widget.UnansweredTicketMinutes = *req.UnansweredTicketMinutes
+	}
+	if req.IdentityVerificationEnabled != nil {
+		if *req.IdentityVerificationEnabled && widget.IdentitySecret == nil {
+			return nil, ErrIdentitySecretMissing
+		}
+		widget.IdentityVerificationEnabled = *req.IdentityVerificationEnabled
+	}
 	if req.ChatBubbleStyle != nil {
 		widget.ChatBubbleStyle = *req.ChatBubbleStyle
 	}
@@ -286,6 +340,30 @@ func (s *ChatWidgetService) UpdateChatWidget(ctx context.Context, tenantID, proj
 	return widget, nil
 }
 
+// RotateIdentitySecret generates a new identity secret for the widget and returns it.
+// Identity tokens signed with the previous secret stop verifying immediately.
+func (s *ChatWidgetService) RotateIdentitySecret(ctx context.Context, tenantID, projectID, widgetID uuid.UUID) (string, error) {
+	widget, err := s.chatWidgetRepo.GetChatWidget(ctx, tenantID, projectID, widgetID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get chat widget: %w", err)
+	}
+	if widget == nil {
+		return "", fmt.Errorf("chat widget not found")
+	}
+
+	secret, err := GenerateIdentitySecret()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate identity secret: %w", err)
+	}
+	widget.IdentitySecret = &secret
+	widget.UpdatedAt = time.Now()
+
+	if err := s.chatWidgetRepo.UpdateChatWidget(ctx, widget); err != nil {
+		return "", fmt.Errorf("failed to update chat widget: %w", err)
+	}
+	return secret, nil
+}
+
 // DeleteChatWidget deletes a chat widget
 func (s *ChatWidgetService) DeleteChatWidget(ctx context.Context, tenantID, projectID, widgetID uuid.UUID) error {
 	return s.chatWidgetRepo.DeleteChatWidget(ctx, tenantID, projectID, widgetID)
@@ -23,7 +23,7 @@ func newTestChatWidgetService(t *testing.T) (*ChatWidgetService, sqlmock.Sqlmock
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
 	chatWidgetRepo := repo.NewChatWidgetRepo(sqlxDB)
 
-	service := NewChatWidgetService(chatWidgetRepo, nil)
+	service := NewChatWidgetService(chatWidgetRepo, nil, nil)
 
 	cleanup := func() {
 		mock.ExpectClose()
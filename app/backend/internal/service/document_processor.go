@@ -22,6 +22,7 @@ type DocumentProcessorService struct {
 	embeddingService *EmbeddingService
 	uploadDir       string
 	maxFileSize     int64
+	knowledgeListener KnowledgeChangeListener
 }
 
 func NewDocumentProcessorService(knowledgeRepo *repo.KnowledgeRepository, embeddingService *EmbeddingService, uploadDir string, maxFileSize int64) *DocumentProcessorService {
@@ -33,6 +34,11 @@ func NewDocumentProcessorService(knowledgeRepo *repo.KnowledgeRepository, embedd
 	}
 }
 
+// SetKnowledgeChangeListener tells the listener when a document of a project was processed or deleted
+func (s *DocumentProcessorService) SetKnowledgeChangeListener(listener KnowledgeChangeListener) {
+	s.knowledgeListener = listener
+}
+
 // ProcessDocument handles the entire document processing pipeline
 func (s *DocumentProcessorService) ProcessDocument(ctx context.Context, tenantID, projectID uuid.UUID, file multipart.File, header *multipart.FileHeader) (*models.KnowledgeDocument, error) {
 	// Validate file
@@ -139,6 +145,10 @@ func (s *DocumentProcessorService) processDocumentAsync(ctx context.Context, doc
 		}
 		
 		s.knowledgeRepo.UpdateDocumentStatus(doc.ID, status, errorMessage)
+		// The upload request may be over by now
+		if err == nil && s.knowledgeListener != nil {
+			s.knowledgeListener.KnowledgeChanged(context.Background(), doc.TenantID, doc.ProjectID)
+		}
 	}()
 
 	// Extract text content
@@ -362,6 +372,9 @@ func (s *DocumentProcessorService) DeleteDocument(ctx context.Context, documentI
 	if err := s.knowledgeRepo.DeleteDocument(documentID); err != nil {
 		return fmt.Errorf("failed to delete document from database: %w", err)
 	}
+	if s.knowledgeListener != nil {
+		s.knowledgeListener.KnowledgeChanged(ctx, doc.TenantID, doc.ProjectID)
+	}
 
 	// Delete file from disk
 	if doc.FilePath != "" {
@@ -24,6 +24,7 @@ type EmailInboxService struct {
 	emailRepo      *repo.EmailRepo
 	mailService    *mail.Service
 	logger         zerolog.Logger
+	redactor       MessageRedactor
 }
 
 // NewEmailInboxService creates a new email inbox service
@@ -47,6 +48,11 @@ func NewEmailInboxService(
 	}
 }
 
+// SetMessageRedactor sets the redactor that masks personal data in emails converted to tickets before they are stored
+func (s *EmailInboxService) SetMessageRedactor(redactor MessageRedactor) {
+	s.redactor = redactor
+}
+
 // ListEmails lists emails in the inbox with filtering
 func (s *EmailInboxService) ListEmails(ctx context.Context, tenantID uuid.UUID, filter repo.EmailFilter) ([]*models.EmailInbox, int, error) {
 	emails, err := s.emailInboxRepo.ListEmails(ctx, tenantID, filter)
@@ -366,7 +372,7 @@ func (s *EmailInboxService) convertMessageToEmailInbox(msg *mail.ParsedMessage,
 		SentAt:          &msg.Date,
 		ReceivedAt:      now,
 		SyncStatus:      "synced",
-		ConnectorID:     connector.ID,
+		ConnectorID:     &connector.ID,
 		CreatedAt:       now,
 		UpdatedAt:       now,
 	}
@@ -543,6 +549,9 @@ func (s *EmailInboxService) ConvertEmailToTicket(ctx context.Context, tenantID,
 		IsPrivate:  false,
 		CreatedAt:  email.ReceivedAt,
 	}
+	if s.redactor != nil {
+		message.Body = s.redactor.RedactStoredMessage(ctx, tenantID, projectID, nil, &ticket.ID, message.Body)
+	}
 
 	err = s.messageRepo.Create(ctx, message)
 	if err != nil {
@@ -563,8 +572,13 @@ func (s *EmailInboxService) ReplyToEmail(ctx context.Context, tenantID, emailID,
 		return nil
 	}
 
+	// Mail received through the SMTP email-server has no connector to reply through
+	if originalEmail.ConnectorID == nil {
+		return fmt.Errorf("email was not received through a connector and cannot be replied to directly")
+	}
+
 	// Get the connector used by the original email for reply
-	activeConnector, err := s.emailRepo.GetConnector(ctx, tenantID, projectID, originalEmail.ConnectorID)
+	activeConnector, err := s.emailRepo.GetConnector(ctx, tenantID, projectID, *originalEmail.ConnectorID)
 	if err != nil {
 		return fmt.Errorf("failed to get connector for email reply: %w", err)
 	}
@@ -656,7 +670,7 @@ func (s *EmailInboxService) ReplyToEmail(ctx context.Context, tenantID, emailID,
 			SentAt:          &[]time.Time{time.Now()}[0],
 			ReceivedAt:      time.Now(),
 			SyncStatus:      "synced",
-			ConnectorID:     activeConnector.ID,
+			ConnectorID:     &activeConnector.ID,
 			CreatedAt:       time.Now(),
 			UpdatedAt:       time.Now(),
 		}
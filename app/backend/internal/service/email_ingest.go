@@ -0,0 +1,649 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/mail"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/bareuptime/tms/internal/util"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrEmailIngestDisabled         = errors.New("email ingestion is not configured")
+	ErrEmailIngestSignatureInvalid = errors.New("invalid email ingestion signature")
+	ErrEmailIngestInvalidMessage   = errors.New("invalid email message")
+	ErrEmailIngestUnknownRecipient = errors.New("no project accepts mail for this recipient")
+)
+
+const (
+	// emailIngestSignatureTolerance bounds the clock skew accepted on signed requests and
+	// how long a captured request can be replayed
+	emailIngestSignatureTolerance = 5 * time.Minute
+	inboundAddressPrefix          = "tenant-"
+)
+
+// inboundMailStore is the part of repo.EmailRepo used to route mail from the SMTP email-server
+type inboundMailStore interface {
+	GetProjectByInboundKey(ctx context.Context, key string) (*models.Project, error)
+	GetProjectInboundKey(ctx context.Context, tenantID, projectID uuid.UUID) (string, error)
+	GetMailbox(ctx context.Context, tenantID uuid.UUID, address string) (*models.EmailMailbox, error)
+	GetTicketRoutingByPublicToken(ctx context.Context, token string) (*models.TicketMailRouting, error)
+	GetTicketRouting(ctx context.Context, tenantID, ticketID uuid.UUID) (*models.TicketMailRouting, error)
+	FindTicketByMessageID(ctx context.Context, tenantID uuid.UUID, messageIDRoot string) (*uuid.UUID, error)
+	CreateTicketRouting(ctx context.Context, routing *models.TicketMailRouting) error
+}
+
+// InboundEmailRequest is a message delivered to the bundled SMTP email-server
+type InboundEmailRequest struct {
+	MailFrom   string    `json:"mail_from"`
+	RcptTo     []string  `json:"rcpt_to"`
+	RawMessage []byte    `json:"raw_message"` // Full RFC 5322 message, base64 encoded in JSON
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// InboundEmailResult reports what happened to an inbound message
+type InboundEmailResult struct {
+	Action    string     `json:"action"` // create, reply, ignore, reject or duplicate
+	Reason    string     `json:"reason,omitempty"`
+	TenantID  *uuid.UUID `json:"tenant_id,omitempty"`
+	ProjectID *uuid.UUID `json:"project_id,omitempty"`
+	TicketID  *uuid.UUID `json:"ticket_id,omitempty"`
+	EmailID   *uuid.UUID `json:"email_id,omitempty"`
+}
+
+// inboundTarget is the mailbox a recipient address resolved to. Routing is set for
+// t+{token}@ reply addresses, which always belong to one ticket.
+type inboundTarget struct {
+	mailbox *models.EmailMailbox
+	routing *models.TicketMailRouting
+	domain  string
+}
+
+// EmailIngestService turns mail received by the SMTP email-server into tickets
+type EmailIngestService struct {
+	store          inboundMailStore
+	emailInboxRepo repo.EmailInboxRepository
+	ticketRepo     repo.TicketRepository
+	messageRepo    repo.TicketMessageRepository
+	customerRepo   repo.CustomerRepository
+	mailService    *mail.Service
+	secret         string
+	domain         string
+	logger         zerolog.Logger
+	triager        TicketTriager
+	redactor       MessageRedactor
+	translator     MessageTranslator
+}
+
+// NewEmailIngestService creates a new email ingestion service
+func NewEmailIngestService(
+	store inboundMailStore,
+	emailInboxRepo repo.EmailInboxRepository,
+	ticketRepo repo.TicketRepository,
+	messageRepo repo.TicketMessageRepository,
+	customerRepo repo.CustomerRepository,
+	mailService *mail.Service,
+	secret string,
+	domain string,
+	logger zerolog.Logger,
+) *EmailIngestService {
+	return &EmailIngestService{
+		store:          store,
+		emailInboxRepo: emailInboxRepo,
+		ticketRepo:     ticketRepo,
+		messageRepo:    messageRepo,
+		customerRepo:   customerRepo,
+		mailService:    mailService,
+		secret:         secret,
+		domain:         strings.ToLower(domain),
+		logger:         logger,
+	}
+}
+
+// SetTriager sets the triager that classifies tickets opened by email
+func (s *EmailIngestService) SetTriager(triager TicketTriager) {
+	s.triager = triager
+}
+
+// SetMessageRedactor sets the redactor that masks personal data in inbound emails before they are stored
+func (s *EmailIngestService) SetMessageRedactor(redactor MessageRedactor) {
+	s.redactor = redactor
+}
+
+// SetTranslator sets the translator that translates inbound emails for the ticket's agent
+func (s *EmailIngestService) SetTranslator(translator MessageTranslator) {
+	s.translator = translator
+}
+
+// SignEmailIngestRequest computes the signature the email-server sends with a request body
+func SignEmailIngestRequest(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the timestamp and HMAC signature headers of an ingestion request
+func (s *EmailIngestService) VerifySignature(timestamp, signature string, body []byte) error {
+	if s.secret == "" {
+		return ErrEmailIngestDisabled
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrEmailIngestSignatureInvalid
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > emailIngestSignatureTolerance || skew < -emailIngestSignatureTolerance {
+		return ErrEmailIngestSignatureInvalid
+	}
+
+	expected := SignEmailIngestRequest(s.secret, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrEmailIngestSignatureInvalid
+	}
+
+	return nil
+}
+
+// InboundAddress returns the tenant-<key>@ address that delivers mail to a project
+func (s *EmailIngestService) InboundAddress(ctx context.Context, tenantID, projectID uuid.UUID) (string, error) {
+	key, err := s.store.GetProjectInboundKey(ctx, tenantID, projectID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get inbound email key: %w", err)
+	}
+	if key == "" {
+		return "", ErrEmailIngestUnknownRecipient
+	}
+	return inboundAddressPrefix + key + "@" + s.domain, nil
+}
+
+// Ingest creates a ticket from a new message or appends a reply to the ticket it threads onto
+func (s *EmailIngestService) Ingest(ctx context.Context, req *InboundEmailRequest) (*InboundEmailResult, error) {
+	if len(req.RawMessage) == 0 {
+		return nil, fmt.Errorf("%w: message is empty", ErrEmailIngestInvalidMessage)
+	}
+
+	msg, err := mail.ParseRawMessage(req.RawMessage)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEmailIngestInvalidMessage, err)
+	}
+	if msg.From == "" {
+		msg.From = req.MailFrom
+	}
+	if !strings.Contains(util.ExtractEmailAddress(msg.From), "@") {
+		return nil, fmt.Errorf("%w: message has no sender", ErrEmailIngestInvalidMessage)
+	}
+	if msg.MessageID == "" {
+		// Derive a stable ID so retried deliveries are still recognised as duplicates
+		sum := sha256.Sum256(req.RawMessage)
+		msg.MessageID = hex.EncodeToString(sum[:16]) + "@" + s.domain
+	}
+
+	target, err := s.resolveTarget(ctx, append(append([]string{}, req.RcptTo...), append(msg.To, msg.CC...)...))
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, ErrEmailIngestUnknownRecipient
+	}
+	mailbox := target.mailbox
+
+	result := &InboundEmailResult{TenantID: &mailbox.TenantID}
+
+	// The email-server retries until it gets an answer, so a stored message means an earlier attempt succeeded
+	existing, err := s.emailInboxRepo.GetEmailByMessageID(ctx, mailbox.TenantID, msg.MessageID, mailbox.Address)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to check for duplicate email: %w", err)
+	}
+	if existing != nil {
+		result.Action = "duplicate"
+		result.ProjectID = existing.ProjectID
+		result.TicketID = existing.TicketID
+		result.EmailID = &existing.ID
+		return result, nil
+	}
+
+	inbound, err := s.mailService.ProcessInboundEmail(ctx, msg, mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process inbound email: %w", err)
+	}
+
+	// Mail sent to a reply address belongs to that ticket whatever its headers say
+	if target.routing != nil && inbound.Action != "ignore" {
+		inbound.Action = "reply"
+		inbound.Reason = ""
+		inbound.TicketID = target.routing.TicketID
+	}
+
+	var routing *models.TicketMailRouting
+	if inbound.Action == "reply" {
+		routing = target.routing
+		if routing == nil {
+			routing, err = s.store.GetTicketRouting(ctx, mailbox.TenantID, inbound.TicketID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get ticket routing: %w", err)
+			}
+		}
+
+		// Only tickets started by email can be threaded onto; anything else is new mail
+		if routing == nil {
+			if mailbox.AllowNewTicket {
+				inbound.Action = "create"
+				inbound.ProjectID = mailbox.ProjectID
+			} else {
+				inbound.Action = "reject"
+				inbound.Reason = "ticket not found"
+			}
+		}
+	}
+
+	result.Action = inbound.Action
+	result.Reason = inbound.Reason
+
+	var ticket *db.Ticket
+	switch inbound.Action {
+	case "create":
+		ticket, routing, err = s.createTicket(ctx, mailbox.TenantID, inbound.ProjectID, msg, target.domain)
+	case "reply":
+		ticket, err = s.addReply(ctx, routing, msg)
+	default:
+		s.logger.Info().
+			Str("tenant_id", mailbox.TenantID.String()).
+			Str("message_id", msg.MessageID).
+			Str("action", inbound.Action).
+			Str("reason", inbound.Reason).
+			Msg("Inbound email not converted to a ticket")
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := s.storeEmail(ctx, req, msg, mailbox, ticket, routing)
+	if err != nil {
+		return nil, err
+	}
+
+	result.ProjectID = &ticket.ProjectID
+	result.TicketID = &ticket.ID
+	result.EmailID = &email.ID
+	return result, nil
+}
+
+// resolveTarget finds the first recipient that is a project inbound address or a ticket reply address
+func (s *EmailIngestService) resolveTarget(ctx context.Context, recipients []string) (*inboundTarget, error) {
+	for _, recipient := range recipients {
+		address := strings.ToLower(util.ExtractEmailAddress(recipient))
+		localPart, domain, ok := strings.Cut(address, "@")
+		if !ok {
+			continue
+		}
+
+		if token := mail.ExtractVERPToken(address); token != "" {
+			routing, err := s.store.GetTicketRoutingByPublicToken(ctx, token)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve reply address: %w", err)
+			}
+			if routing != nil {
+				return &inboundTarget{
+					mailbox: &models.EmailMailbox{
+						TenantID:  routing.TenantID,
+						ProjectID: routing.ProjectID,
+						Address:   address,
+					},
+					routing: routing,
+					domain:  domain,
+				}, nil
+			}
+			continue
+		}
+
+		key := strings.TrimPrefix(localPart, inboundAddressPrefix)
+		if key == localPart || key == "" {
+			continue
+		}
+
+		project, err := s.store.GetProjectByInboundKey(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve inbound address: %w", err)
+		}
+		if project == nil {
+			continue
+		}
+
+		// A configured mailbox for the address brings its routing rules and new-ticket policy
+		mailbox, err := s.store.GetMailbox(ctx, project.TenantID, address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get mailbox: %w", err)
+		}
+		if mailbox == nil {
+			mailbox = &models.EmailMailbox{
+				TenantID:       project.TenantID,
+				ProjectID:      project.ID,
+				Address:        address,
+				AllowNewTicket: true,
+			}
+		}
+
+		return &inboundTarget{mailbox: mailbox, domain: domain}, nil
+	}
+
+	return nil, nil
+}
+
+// createTicket opens a ticket for a new message and the reply address that threads answers back to it.
+// The reply address is keyed on the Message-ID, so a retried delivery whose earlier attempt failed after
+// opening the ticket resumes that ticket instead of opening a second one.
+func (s *EmailIngestService) createTicket(ctx context.Context, tenantID, projectID uuid.UUID, msg *mail.ParsedMessage, domain string) (*db.Ticket, *models.TicketMailRouting, error) {
+	customer, err := s.findOrCreateCustomer(ctx, tenantID, msg.From)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ticketID, err := s.store.FindTicketByMessageID(ctx, tenantID, msg.MessageID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check for an earlier delivery: %w", err)
+	}
+	if ticketID != nil {
+		routing, err := s.store.GetTicketRouting(ctx, tenantID, *ticketID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get ticket routing: %w", err)
+		}
+		if routing != nil && routing.ProjectID == projectID {
+			ticket, err := s.resumeTicket(ctx, routing, customer, msg)
+			return ticket, routing, err
+		}
+	}
+
+	subject := strings.TrimSpace(msg.Subject)
+	if subject == "" {
+		subject = "(no subject)"
+	}
+
+	ticket := &db.Ticket{
+		ID:         uuid.New(),
+		TenantID:   tenantID,
+		ProjectID:  projectID,
+		Subject:    subject,
+		Status:     "new",
+		Priority:   "normal",
+		Type:       "question",
+		Source:     "email",
+		CustomerID: customer.ID,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if err := s.ticketRepo.Create(ctx, ticket); err != nil {
+		return nil, nil, fmt.Errorf("failed to create ticket: %w", err)
+	}
+
+	token, err := generateReplyToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	routing := &models.TicketMailRouting{
+		ID:            uuid.New(),
+		TenantID:      tenantID,
+		ProjectID:     projectID,
+		TicketID:      ticket.ID,
+		PublicToken:   token,
+		ReplyAddress:  "t+" + token + "@" + domain,
+		MessageIDRoot: msg.MessageID,
+		CreatedAt:     time.Now(),
+	}
+	if err := s.store.CreateTicketRouting(ctx, routing); err != nil {
+		return nil, nil, fmt.Errorf("failed to create ticket routing: %w", err)
+	}
+
+	if err := s.createMessage(ctx, ticket, customer, msg); err != nil {
+		return nil, nil, err
+	}
+
+	if s.triager != nil {
+		body := msg.TextBody
+		if strings.TrimSpace(body) == "" {
+			body = msg.HTMLBody
+		}
+		s.triager.TriageNewTicket(ticket, body)
+	}
+
+	return ticket, routing, nil
+}
+
+// resumeTicket picks up the ticket an earlier delivery of the message opened, adding the message when
+// that attempt failed before storing it
+func (s *EmailIngestService) resumeTicket(ctx context.Context, routing *models.TicketMailRouting, customer *db.Customer, msg *mail.ParsedMessage) (*db.Ticket, error) {
+	ticket, err := s.ticketRepo.GetByTenantAndProjectID(ctx, routing.TenantID, routing.ProjectID, routing.TicketID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ticket: %w", err)
+	}
+
+	messages, _, err := s.messageRepo.GetByTicketID(ctx, ticket.ID, true, repo.PaginationParams{Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ticket messages: %w", err)
+	}
+	if len(messages) == 0 {
+		if err := s.createMessage(ctx, ticket, customer, msg); err != nil {
+			return nil, err
+		}
+	}
+
+	return ticket, nil
+}
+
+// addReply appends a customer reply to a ticket and reopens it when it was already solved
+func (s *EmailIngestService) addReply(ctx context.Context, routing *models.TicketMailRouting, msg *mail.ParsedMessage) (*db.Ticket, error) {
+	ticket, err := s.ticketRepo.GetByTenantAndProjectID(ctx, routing.TenantID, routing.ProjectID, routing.TicketID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ticket: %w", err)
+	}
+
+	customer, err := s.findOrCreateCustomer(ctx, routing.TenantID, msg.From)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.createMessage(ctx, ticket, customer, msg); err != nil {
+		return nil, err
+	}
+
+	if ticket.Status == "resolved" || ticket.Status == "closed" {
+		ticket.Status = "open"
+		if err := s.ticketRepo.Update(ctx, ticket); err != nil {
+			return nil, fmt.Errorf("failed to reopen ticket: %w", err)
+		}
+	}
+
+	return ticket, nil
+}
+
+func (s *EmailIngestService) createMessage(ctx context.Context, ticket *db.Ticket, customer *db.Customer, msg *mail.ParsedMessage) error {
+	body := msg.TextBody
+	if strings.TrimSpace(body) == "" {
+		body = msg.HTMLBody
+	}
+
+	message := &db.TicketMessage{
+		ID:         uuid.New(),
+		TenantID:   ticket.TenantID,
+		ProjectID:  ticket.ProjectID,
+		TicketID:   ticket.ID,
+		AuthorType: "customer",
+		AuthorID:   &customer.ID,
+		Body:       body,
+		IsPrivate:  false,
+		CreatedAt:  time.Now(),
+	}
+	if s.redactor != nil {
+		message.Body = s.redactor.RedactStoredMessage(ctx, ticket.TenantID, ticket.ProjectID, nil, &ticket.ID, message.Body)
+	}
+	if err := s.messageRepo.Create(ctx, message); err != nil {
+		return fmt.Errorf("failed to create ticket message: %w", err)
+	}
+
+	if s.translator != nil {
+		go func() {
+			_, err := s.translator.TranslateIncoming(context.Background(), ticket.TenantID, ticket.ProjectID, models.MessageKindTicket, message.ID, ticket.ID, ticket.AssigneeAgentID, message.Body)
+			if err != nil {
+				s.logger.Warn().
+					Err(err).
+					Str("ticket_id", ticket.ID.String()).
+					Str("message_id", message.ID.String()).
+					Msg("Failed to translate inbound email")
+			}
+		}()
+	}
+	return nil
+}
+
+// storeEmail keeps the original message, including attachments, in the email inbox linked to its ticket
+func (s *EmailIngestService) storeEmail(ctx context.Context, req *InboundEmailRequest, msg *mail.ParsedMessage, mailbox *models.EmailMailbox, ticket *db.Ticket, routing *models.TicketMailRouting) (*models.EmailInbox, error) {
+	now := time.Now()
+	receivedAt := req.ReceivedAt
+	if receivedAt.IsZero() {
+		receivedAt = now
+	}
+
+	toAddresses := msg.To
+	if len(toAddresses) == 0 {
+		toAddresses = req.RcptTo
+	}
+
+	sizeBytes := len(req.RawMessage)
+	email := &models.EmailInbox{
+		ID:                  uuid.New(),
+		TenantID:            ticket.TenantID,
+		ProjectID:           &ticket.ProjectID,
+		MessageID:           msg.MessageID,
+		MailboxAddress:      mailbox.Address,
+		FromAddress:         msg.From,
+		ToAddresses:         pq.StringArray(append([]string{}, toAddresses...)),
+		CcAddresses:         pq.StringArray(msg.CC),
+		Subject:             msg.Subject,
+		BodyText:            &msg.TextBody,
+		BodyHTML:            &msg.HTMLBody,
+		IsReply:             routing != nil && routing.MessageIDRoot != msg.MessageID,
+		HasAttachments:      len(msg.Attachments) > 0,
+		AttachmentCount:     len(msg.Attachments),
+		SizeBytes:           &sizeBytes,
+		ReceivedAt:          receivedAt,
+		SyncStatus:          "synced",
+		TicketID:            &ticket.ID,
+		IsConvertedToTicket: true,
+		RawEmail:            req.RawMessage,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+	if routing != nil {
+		email.ThreadID = &routing.MessageIDRoot
+	}
+	if !msg.Date.IsZero() {
+		email.SentAt = &msg.Date
+	}
+	if name := emailDisplayName(msg.From); name != "" {
+		email.FromName = &name
+	}
+	if len(msg.Headers) > 0 {
+		headers := models.JSONMap{}
+		for key, values := range msg.Headers {
+			if len(values) == 1 {
+				headers[key] = values[0]
+			} else if len(values) > 1 {
+				headers[key] = values
+			}
+		}
+		email.Headers = headers
+	}
+
+	if err := s.emailInboxRepo.CreateEmail(ctx, email); err != nil {
+		return nil, fmt.Errorf("failed to store email: %w", err)
+	}
+
+	for _, attachment := range msg.Attachments {
+		filename := attachment.Filename
+		if filename == "" {
+			filename = "attachment"
+		}
+		record := &models.EmailAttachment{
+			ID:          uuid.New(),
+			EmailID:     email.ID,
+			TenantID:    email.TenantID,
+			Filename:    filename,
+			ContentType: attachment.ContentType,
+			SizeBytes:   len(attachment.Content),
+			IsInline:    attachment.Inline,
+			CreatedAt:   now,
+		}
+		if attachment.ContentID != "" {
+			contentID := attachment.ContentID
+			record.ContentID = &contentID
+		}
+		if err := s.emailInboxRepo.CreateAttachment(ctx, record); err != nil {
+			return nil, fmt.Errorf("failed to store email attachment: %w", err)
+		}
+	}
+
+	return email, nil
+}
+
+func (s *EmailIngestService) findOrCreateCustomer(ctx context.Context, tenantID uuid.UUID, from string) (*db.Customer, error) {
+	email := strings.ToLower(util.ExtractEmailAddress(from))
+	customer, err := s.customerRepo.GetByEmail(ctx, tenantID, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup customer: %w", err)
+	}
+	if customer != nil {
+		return customer, nil
+	}
+
+	name := emailDisplayName(from)
+	if name == "" {
+		name = email
+	}
+
+	customer = &db.Customer{
+		ID:        uuid.New(),
+		TenantID:  tenantID,
+		Email:     email,
+		Name:      name,
+		Metadata:  make(map[string]string),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := s.customerRepo.Create(ctx, customer); err != nil {
+		return nil, fmt.Errorf("failed to create customer: %w", err)
+	}
+	return customer, nil
+}
+
+func emailDisplayName(address string) string {
+	if parsed, err := netmail.ParseAddress(address); err == nil {
+		return parsed.Name
+	}
+	return ""
+}
+
+func generateReplyToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate reply token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
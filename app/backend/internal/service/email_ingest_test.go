@@ -0,0 +1,427 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/mail"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+type mockInboundMailStore struct {
+	projects  map[string]*models.Project
+	mailboxes map[string]*models.EmailMailbox
+	routings  []*models.TicketMailRouting
+}
+
+func (m *mockInboundMailStore) GetProjectByInboundKey(ctx context.Context, key string) (*models.Project, error) {
+	return m.projects[key], nil
+}
+
+func (m *mockInboundMailStore) GetProjectInboundKey(ctx context.Context, tenantID, projectID uuid.UUID) (string, error) {
+	for key, project := range m.projects {
+		if project.TenantID == tenantID && project.ID == projectID {
+			return key, nil
+		}
+	}
+	return "", nil
+}
+
+func (m *mockInboundMailStore) GetMailbox(ctx context.Context, tenantID uuid.UUID, address string) (*models.EmailMailbox, error) {
+	return m.mailboxes[address], nil
+}
+
+func (m *mockInboundMailStore) GetTicketRoutingByPublicToken(ctx context.Context, token string) (*models.TicketMailRouting, error) {
+	for _, routing := range m.routings {
+		if routing.PublicToken == token {
+			return routing, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockInboundMailStore) GetTicketRoutingByToken(ctx context.Context, tenantID uuid.UUID, token string) (*models.TicketMailRouting, error) {
+	routing, _ := m.GetTicketRoutingByPublicToken(ctx, token)
+	if routing == nil || routing.TenantID != tenantID {
+		return nil, nil
+	}
+	return routing, nil
+}
+
+func (m *mockInboundMailStore) GetTicketRouting(ctx context.Context, tenantID, ticketID uuid.UUID) (*models.TicketMailRouting, error) {
+	for _, routing := range m.routings {
+		if routing.TenantID == tenantID && routing.TicketID == ticketID {
+			return routing, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockInboundMailStore) FindTicketByMessageID(ctx context.Context, tenantID uuid.UUID, messageIDRoot string) (*uuid.UUID, error) {
+	for _, routing := range m.routings {
+		if routing.TenantID == tenantID && routing.MessageIDRoot == messageIDRoot {
+			return &routing.TicketID, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockInboundMailStore) CreateTicketRouting(ctx context.Context, routing *models.TicketMailRouting) error {
+	m.routings = append(m.routings, routing)
+	return nil
+}
+
+type mockEmailInboxRepository struct {
+	repo.EmailInboxRepository
+	emails      []*models.EmailInbox
+	attachments []*models.EmailAttachment
+	failCreate  bool
+}
+
+func (m *mockEmailInboxRepository) CreateEmail(ctx context.Context, email *models.EmailInbox) error {
+	if m.failCreate {
+		return fmt.Errorf("connection reset")
+	}
+	m.emails = append(m.emails, email)
+	return nil
+}
+
+func (m *mockEmailInboxRepository) GetEmailByMessageID(ctx context.Context, tenantID uuid.UUID, messageID, mailboxAddress string) (*models.EmailInbox, error) {
+	for _, email := range m.emails {
+		if email.TenantID == tenantID && email.MessageID == messageID && email.MailboxAddress == mailboxAddress {
+			return email, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockEmailInboxRepository) CreateAttachment(ctx context.Context, attachment *models.EmailAttachment) error {
+	m.attachments = append(m.attachments, attachment)
+	return nil
+}
+
+type mockIngestTicketRepository struct {
+	repo.TicketRepository
+	tickets map[uuid.UUID]*db.Ticket
+}
+
+func (m *mockIngestTicketRepository) Create(ctx context.Context, ticket *db.Ticket) error {
+	ticket.Number = len(m.tickets) + 1
+	m.tickets[ticket.ID] = ticket
+	return nil
+}
+
+func (m *mockIngestTicketRepository) GetByTenantAndProjectID(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*db.Ticket, error) {
+	ticket, ok := m.tickets[ticketID]
+	if !ok || ticket.TenantID != tenantID || ticket.ProjectID != projectID {
+		return nil, fmt.Errorf("ticket not found")
+	}
+	return ticket, nil
+}
+
+func (m *mockIngestTicketRepository) Update(ctx context.Context, ticket *db.Ticket) error {
+	m.tickets[ticket.ID] = ticket
+	return nil
+}
+
+type mockIngestMessageRepository struct {
+	repo.TicketMessageRepository
+	messages []*db.TicketMessage
+}
+
+func (m *mockIngestMessageRepository) Create(ctx context.Context, message *db.TicketMessage) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *mockIngestMessageRepository) GetByTicketID(ctx context.Context, ticketID uuid.UUID, includePrivate bool, pagination repo.PaginationParams) ([]*db.TicketMessage, string, error) {
+	messages := []*db.TicketMessage{}
+	for _, message := range m.messages {
+		if message.TicketID == ticketID {
+			messages = append(messages, message)
+		}
+	}
+	return messages, "", nil
+}
+
+type mockIngestCustomerRepository struct {
+	repo.CustomerRepository
+	customers []*db.Customer
+}
+
+func (m *mockIngestCustomerRepository) GetByEmail(ctx context.Context, tenantID uuid.UUID, email string) (*db.Customer, error) {
+	for _, customer := range m.customers {
+		if customer.TenantID == tenantID && customer.Email == email {
+			return customer, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockIngestCustomerRepository) Create(ctx context.Context, customer *db.Customer) error {
+	m.customers = append(m.customers, customer)
+	return nil
+}
+
+type emailIngestFixture struct {
+	service   *EmailIngestService
+	store     *mockInboundMailStore
+	inbox     *mockEmailInboxRepository
+	tickets   *mockIngestTicketRepository
+	messages  *mockIngestMessageRepository
+	customers *mockIngestCustomerRepository
+	project   *models.Project
+}
+
+func newEmailIngestFixture(t *testing.T) *emailIngestFixture {
+	t.Helper()
+
+	project := &models.Project{ID: uuid.New(), TenantID: uuid.New(), Key: "SUP", Name: "Support", Status: "active"}
+	f := &emailIngestFixture{
+		store: &mockInboundMailStore{
+			projects:  map[string]*models.Project{"a1b2c3": project},
+			mailboxes: make(map[string]*models.EmailMailbox),
+		},
+		inbox:     &mockEmailInboxRepository{},
+		tickets:   &mockIngestTicketRepository{tickets: make(map[uuid.UUID]*db.Ticket)},
+		messages:  &mockIngestMessageRepository{},
+		customers: &mockIngestCustomerRepository{},
+		project:   project,
+	}
+
+	mailService := mail.NewService(zerolog.Nop())
+	mailService.SetRoutingLookup(f.store)
+	f.service = NewEmailIngestService(f.store, f.inbox, f.tickets, f.messages, f.customers, mailService, "ingest-secret", "hith.chat", zerolog.Nop())
+	return f
+}
+
+func rawEmail(headers map[string]string, body string) []byte {
+	var b strings.Builder
+	for key, value := range headers {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	b.WriteString("\r\n" + body)
+	return []byte(b.String())
+}
+
+const multipartEmailBody = "--b1\r\n" +
+	"Content-Type: multipart/alternative; boundary=b2\r\n\r\n" +
+	"--b2\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+	"My printer is on fire.\r\n" +
+	"--b2\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n\r\n" +
+	"<p>My printer is on fire.</p>\r\n" +
+	"--b2--\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/pdf; name=\"invoice.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"--b1--\r\n"
+
+func TestEmailIngestVerifySignature(t *testing.T) {
+	f := newEmailIngestFixture(t)
+	body := []byte(`{"rcpt_to":["tenant-a1b2c3@hith.chat"]}`)
+	now := time.Now().Unix()
+	ts := strconv.FormatInt(now, 10)
+
+	require.NoError(t, f.service.VerifySignature(ts, SignEmailIngestRequest("ingest-secret", now, body), body))
+
+	err := f.service.VerifySignature(ts, SignEmailIngestRequest("other-secret", now, body), body)
+	require.ErrorIs(t, err, ErrEmailIngestSignatureInvalid)
+
+	err = f.service.VerifySignature(ts, SignEmailIngestRequest("ingest-secret", now, body), []byte(`{"rcpt_to":[]}`))
+	require.ErrorIs(t, err, ErrEmailIngestSignatureInvalid)
+
+	stale := now - int64((10 * time.Minute).Seconds())
+	err = f.service.VerifySignature(strconv.FormatInt(stale, 10), SignEmailIngestRequest("ingest-secret", stale, body), body)
+	require.ErrorIs(t, err, ErrEmailIngestSignatureInvalid)
+
+	disabled := NewEmailIngestService(f.store, f.inbox, f.tickets, f.messages, f.customers, mail.NewService(zerolog.Nop()), "", "hith.chat", zerolog.Nop())
+	require.ErrorIs(t, disabled.VerifySignature(ts, SignEmailIngestRequest("", now, body), body), ErrEmailIngestDisabled)
+}
+
+func TestEmailIngestCreatesTicketAndThreadsReplies(t *testing.T) {
+	ctx := context.Background()
+	f := newEmailIngestFixture(t)
+
+	address, err := f.service.InboundAddress(ctx, f.project.TenantID, f.project.ID)
+	require.NoError(t, err)
+	require.Equal(t, "tenant-a1b2c3@hith.chat", address)
+
+	original := &InboundEmailRequest{
+		MailFrom: "jane@example.com",
+		RcptTo:   []string{address},
+		RawMessage: rawEmail(map[string]string{
+			"From":         `"Jane Doe" <Jane@Example.com>`,
+			"To":           address,
+			"Subject":      "Printer on fire",
+			"Message-ID":   "<first@example.com>",
+			"MIME-Version": "1.0",
+			"Content-Type": "multipart/mixed; boundary=b1",
+		}, multipartEmailBody),
+	}
+
+	result, err := f.service.Ingest(ctx, original)
+	require.NoError(t, err)
+	require.Equal(t, "create", result.Action)
+	require.Equal(t, f.project.ID, *result.ProjectID)
+
+	ticket := f.tickets.tickets[*result.TicketID]
+	require.Equal(t, "Printer on fire", ticket.Subject)
+	require.Equal(t, "email", ticket.Source)
+	require.Len(t, f.customers.customers, 1)
+	require.Equal(t, "jane@example.com", f.customers.customers[0].Email)
+	require.Equal(t, "Jane Doe", f.customers.customers[0].Name)
+	require.Len(t, f.messages.messages, 1)
+	require.Equal(t, "My printer is on fire.", f.messages.messages[0].Body)
+
+	require.Len(t, f.store.routings, 1)
+	routing := f.store.routings[0]
+	require.Equal(t, "first@example.com", routing.MessageIDRoot)
+	require.Equal(t, "t+"+routing.PublicToken+"@hith.chat", routing.ReplyAddress)
+
+	require.Len(t, f.inbox.emails, 1)
+	require.True(t, f.inbox.emails[0].IsConvertedToTicket)
+	require.Nil(t, f.inbox.emails[0].ConnectorID)
+	require.Equal(t, original.RawMessage, f.inbox.emails[0].RawEmail)
+	require.Len(t, f.inbox.attachments, 1)
+	require.Equal(t, "invoice.pdf", f.inbox.attachments[0].Filename)
+	require.Equal(t, "application/pdf", f.inbox.attachments[0].ContentType)
+	require.Equal(t, 9, f.inbox.attachments[0].SizeBytes)
+
+	// A retried delivery of the same message is acknowledged without a second ticket
+	result, err = f.service.Ingest(ctx, original)
+	require.NoError(t, err)
+	require.Equal(t, "duplicate", result.Action)
+	require.Equal(t, ticket.ID, *result.TicketID)
+	require.Len(t, f.tickets.tickets, 1)
+
+	// A reply to the inbound address threads through In-Reply-To
+	ticket.Status = "resolved"
+	result, err = f.service.Ingest(ctx, &InboundEmailRequest{
+		RcptTo: []string{address},
+		RawMessage: rawEmail(map[string]string{
+			"From":        "jane@example.com",
+			"To":          address,
+			"Subject":     "Re: Printer on fire",
+			"Message-ID":  "<second@example.com>",
+			"In-Reply-To": "<first@example.com>",
+		}, "It is still burning."),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "reply", result.Action)
+	require.Equal(t, ticket.ID, *result.TicketID)
+	require.Equal(t, "open", ticket.Status)
+	require.Len(t, f.messages.messages, 2)
+	require.Equal(t, "It is still burning.", f.messages.messages[1].Body)
+
+	// Mail to the reply address lands on the ticket even without threading headers
+	result, err = f.service.Ingest(ctx, &InboundEmailRequest{
+		RcptTo: []string{routing.ReplyAddress},
+		RawMessage: rawEmail(map[string]string{
+			"From":       "Bob <bob@example.com>",
+			"To":         routing.ReplyAddress,
+			"Subject":    "Fire brigade is on the way",
+			"Message-ID": "<third@example.com>",
+		}, "Help is coming."),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "reply", result.Action)
+	require.Equal(t, ticket.ID, *result.TicketID)
+	require.Len(t, f.customers.customers, 2)
+	require.Equal(t, f.customers.customers[1].ID, *f.messages.messages[2].AuthorID)
+	require.Len(t, f.tickets.tickets, 1)
+}
+
+func TestEmailIngestRetryAfterFailedStoreResumesTicket(t *testing.T) {
+	ctx := context.Background()
+	f := newEmailIngestFixture(t)
+	address := "tenant-a1b2c3@hith.chat"
+
+	req := &InboundEmailRequest{
+		RcptTo: []string{address},
+		RawMessage: rawEmail(map[string]string{
+			"From":       "jane@example.com",
+			"To":         address,
+			"Subject":    "Printer on fire",
+			"Message-ID": "<first@example.com>",
+		}, "My printer is on fire."),
+	}
+
+	// The ticket is opened but storing the original email fails, so the email-server retries
+	f.inbox.failCreate = true
+	_, err := f.service.Ingest(ctx, req)
+	require.Error(t, err)
+	require.Len(t, f.tickets.tickets, 1)
+	require.Empty(t, f.inbox.emails)
+
+	f.inbox.failCreate = false
+	result, err := f.service.Ingest(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, "create", result.Action)
+	require.Len(t, f.tickets.tickets, 1)
+	require.Contains(t, f.tickets.tickets, *result.TicketID)
+	require.Len(t, f.messages.messages, 1)
+	require.Len(t, f.store.routings, 1)
+	require.Len(t, f.inbox.emails, 1)
+}
+
+func TestEmailIngestRejectsUnroutableMail(t *testing.T) {
+	ctx := context.Background()
+	f := newEmailIngestFixture(t)
+	address := "tenant-a1b2c3@hith.chat"
+
+	// Auto-replies are acknowledged but never become tickets
+	result, err := f.service.Ingest(ctx, &InboundEmailRequest{
+		RcptTo: []string{address},
+		RawMessage: rawEmail(map[string]string{
+			"From":           "jane@example.com",
+			"To":             address,
+			"Subject":        "Out of office",
+			"Auto-Submitted": "auto-replied",
+		}, "I am away."),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "ignore", result.Action)
+	require.Empty(t, f.tickets.tickets)
+
+	_, err = f.service.Ingest(ctx, &InboundEmailRequest{
+		RcptTo:     []string{"tenant-unknown@hith.chat", "t+deadbeef@hith.chat"},
+		RawMessage: rawEmail(map[string]string{"From": "jane@example.com", "Subject": "Hello"}, "Hi"),
+	})
+	require.ErrorIs(t, err, ErrEmailIngestUnknownRecipient)
+
+	_, err = f.service.Ingest(ctx, &InboundEmailRequest{
+		RcptTo:     []string{address},
+		RawMessage: rawEmail(map[string]string{"Subject": "No sender"}, "Hi"),
+	})
+	require.ErrorIs(t, err, ErrEmailIngestInvalidMessage)
+
+	// A configured mailbox that only accepts replies rejects new conversations
+	f.store.mailboxes[address] = &models.EmailMailbox{
+		TenantID:       f.project.TenantID,
+		ProjectID:      f.project.ID,
+		Address:        address,
+		AllowNewTicket: false,
+	}
+	result, err = f.service.Ingest(ctx, &InboundEmailRequest{
+		RcptTo:     []string{address},
+		RawMessage: rawEmail(map[string]string{"From": "jane@example.com", "To": address, "Subject": "New issue"}, "Hi"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "reject", result.Action)
+	require.Empty(t, f.tickets.tickets)
+	require.Empty(t, f.inbox.emails)
+}
@@ -2,8 +2,10 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
 )
 
 // EmailProvider defines the contract for sending transactional emails used in the application.
@@ -12,4 +14,16 @@ type EmailProvider interface {
 	SendSignupWelcomeEmail(ctx context.Context, toEmail, recipientName string) error
 	SendTicketCreatedNotification(ctx context.Context, ticket *db.Ticket, customer *db.Customer, toEmail, recipientName, recipientType string) error
 	SendTicketUpdatedNotification(ctx context.Context, ticket *db.Ticket, customer *db.Customer, toEmail, recipientName, updateType, updateDetails string) error
+	SendChatTranscriptEmail(ctx context.Context, transcript *ChatTranscript, toEmail, recipientName string) error
+	SendChatFollowUpEmail(ctx context.Context, ticket *db.Ticket, link, toEmail, recipientName string) error
+}
+
+// ChatTranscript is the content of a chat transcript email
+type ChatTranscript struct {
+	SessionID   string
+	WidgetName  string
+	VisitorName string
+	StartedAt   time.Time
+	EndedAt     time.Time
+	Messages    []*models.ChatMessage
 }
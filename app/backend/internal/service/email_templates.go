@@ -2,6 +2,8 @@ package service
 
 import (
 	"fmt"
+	"html"
+	"strings"
 
 	"github.com/bareuptime/tms/internal/db"
 )
@@ -374,3 +376,166 @@ Hith - Ticket Management System
 
 	return subject, htmlBody, textBody
 }
+
+func buildChatTranscriptEmail(transcript *ChatTranscript, recipientName, toEmail string) (subject, htmlBody, textBody string) {
+	if recipientName == "" {
+		recipientName = "there"
+	}
+	widgetName := transcript.WidgetName
+	if widgetName == "" {
+		widgetName = "our team"
+	}
+
+	subject = fmt.Sprintf("Your chat transcript with %s", widgetName)
+	period := fmt.Sprintf("%s – %s", transcript.StartedAt.UTC().Format("Jan 2, 2006 15:04"), transcript.EndedAt.UTC().Format("15:04 MST"))
+
+	var htmlMessages, textMessages strings.Builder
+	for _, message := range transcript.Messages {
+		if message.IsPrivate || message.AuthorType == "system" {
+			continue
+		}
+		author := message.AuthorName
+		if author == "" {
+			author = message.AuthorType
+		}
+		fmt.Fprintf(&htmlMessages, `
+                <div class="message"><span class="meta">%s · %s</span><br>%s</div>`,
+			html.EscapeString(author), message.CreatedAt.UTC().Format("15:04"),
+			strings.ReplaceAll(html.EscapeString(message.Content), "\n", "<br>"))
+		fmt.Fprintf(&textMessages, "[%s] %s: %s\n", message.CreatedAt.UTC().Format("15:04"), author, message.Content)
+	}
+
+	htmlBody = fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Chat Transcript</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; line-height: 1.6; color: #333; margin: 0; padding: 0; background-color: #f5f5f5; }
+        .container { max-width: 600px; margin: 0 auto; background: white; border-radius: 8px; overflow: hidden; box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1); }
+        .header { background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%); color: white; padding: 40px 20px; text-align: center; }
+        .content { padding: 40px 20px; }
+        .transcript { background: #f8f9fa; border: 1px solid #e9ecef; border-radius: 8px; padding: 20px; margin: 20px 0; }
+        .message { margin-bottom: 12px; }
+        .meta { color: #6c757d; font-size: 13px; }
+        .footer { background: #f8f9fa; padding: 20px; text-align: center; color: #6c757d; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>Chat Transcript</h1>
+            <p>%s</p>
+        </div>
+        <div class="content">
+            <h2>Hi %s,</h2>
+            <p>Here is a copy of your conversation with %s.</p>
+
+            <div class="transcript">%s
+            </div>
+
+            <p>If you need more help, just start a new chat or reply to this email.</p>
+        </div>
+        <div class="footer">
+            <p>This email was sent to %s</p>
+            <p>Hith - Ticket Management System</p>
+        </div>
+    </div>
+</body>
+</html>
+    `, html.EscapeString(period), html.EscapeString(recipientName), html.EscapeString(widgetName), htmlMessages.String(), html.EscapeString(toEmail))
+
+	textBody = fmt.Sprintf(`
+Chat Transcript (%s)
+
+Hi %s,
+
+Here is a copy of your conversation with %s.
+
+%s
+If you need more help, just start a new chat or reply to this email.
+
+This email was sent to %s
+Hith - Ticket Management System
+    `, period, recipientName, widgetName, textMessages.String(), toEmail)
+
+	return subject, htmlBody, textBody
+}
+
+func buildChatFollowUpEmail(ticket *db.Ticket, link, recipientName, toEmail string) (subject, htmlBody, textBody string) {
+	if recipientName == "" {
+		recipientName = "there"
+	}
+
+	subject = fmt.Sprintf("We'll follow up on your chat: ticket #%d", ticket.Number)
+
+	htmlLink, textLink := "", ""
+	if link != "" {
+		htmlLink = fmt.Sprintf(`
+            <a href="%s" class="btn">View and reply to your ticket</a>`, html.EscapeString(link))
+		textLink = fmt.Sprintf("View and reply to your ticket: %s\n", link)
+	}
+
+	htmlBody = fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Chat Follow-up</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; line-height: 1.6; color: #333; margin: 0; padding: 0; background-color: #f5f5f5; }
+        .container { max-width: 600px; margin: 0 auto; background: white; border-radius: 8px; overflow: hidden; box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1); }
+        .header { background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%); color: white; padding: 40px 20px; text-align: center; }
+        .content { padding: 40px 20px; }
+        .ticket-info { background: #f8f9fa; border: 1px solid #e9ecef; border-radius: 8px; padding: 20px; margin: 20px 0; }
+        .footer { background: #f8f9fa; padding: 20px; text-align: center; color: #6c757d; font-size: 14px; }
+        .btn { display: inline-block; background: #667eea; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; margin: 20px 0; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>We'll Follow Up</h1>
+        </div>
+        <div class="content">
+            <p>Dear %s,</p>
+            <p>Thanks for chatting with us. We've turned your conversation into a support ticket so our team can get back to you, even after you close the chat window.</p>
+
+            <div class="ticket-info">
+                <p><strong>Ticket:</strong> #%d</p>
+                <p><strong>Subject:</strong> %s</p>
+            </div>
+%s
+            <p>Your chat transcript is attached to the ticket, so there is no need to repeat yourself.</p>
+        </div>
+        <div class="footer">
+            <p>This email was sent to %s</p>
+            <p>Hith - Ticket Management System</p>
+        </div>
+    </div>
+</body>
+</html>
+    `, html.EscapeString(recipientName), ticket.Number, html.EscapeString(ticket.Subject), htmlLink, html.EscapeString(toEmail))
+
+	textBody = fmt.Sprintf(`
+We'll Follow Up
+
+Dear %s,
+
+Thanks for chatting with us. We've turned your conversation into a support ticket so our team can get back to you, even after you close the chat window.
+
+Ticket: #%d
+Subject: %s
+
+%s
+Your chat transcript is attached to the ticket, so there is no need to repeat yourself.
+
+This email was sent to %s
+Hith - Ticket Management System
+    `, recipientName, ticket.Number, ticket.Subject, textLink, toEmail)
+
+	return subject, htmlBody, textBody
+}
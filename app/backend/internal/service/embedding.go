@@ -33,6 +33,8 @@ func (s *EmbeddingService) GenerateEmbedding(ctx context.Context, text string) (
 	switch s.config.EmbeddingService {
 	case "openai":
 		return s.generateOpenAIEmbedding(ctx, text)
+	case EvalStubEmbeddingService:
+		return evalStubEmbedding(text, s.GetDimension()), nil
 	default:
 		return pgvector.Vector{}, fmt.Errorf("unsupported embedding service: %s", s.config.EmbeddingService)
 	}
@@ -49,6 +51,12 @@ func (s *EmbeddingService) GenerateEmbeddings(ctx context.Context, texts []strin
 	switch s.config.EmbeddingService {
 	case "openai":
 		return s.generateOpenAIEmbeddings(ctx, texts)
+	case EvalStubEmbeddingService:
+		embeddings := make([]pgvector.Vector, len(texts))
+		for i, text := range texts {
+			embeddings[i] = evalStubEmbedding(text, s.GetDimension())
+		}
+		return embeddings, nil
 	default:
 		return nil, fmt.Errorf("unsupported embedding service: %s", s.config.EmbeddingService)
 	}
@@ -145,7 +153,7 @@ func (s *EmbeddingService) IsEnabled() bool {
 	fmt.Println("Checking if embedding service is enabled...")
 	fmt.Println("Embedding service enabled:", s.config.Enabled)
 	fmt.Println("OpenAI API key present:", s.config.OpenAIAPIKey != "")
-	return s.config.Enabled && s.config.OpenAIAPIKey != ""
+	return s.config.Enabled && (s.config.EmbeddingService == EvalStubEmbeddingService || s.config.OpenAIAPIKey != "")
 }
 
 // GetModel returns the current embedding model being used
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
 
 	"github.com/bareuptime/tms/internal/models"
 	"github.com/bareuptime/tms/internal/repo"
@@ -136,6 +137,21 @@ func (s *KnowledgeService) GetRelevantContext(ctx context.Context, tenantID, pro
 		return nil, fmt.Errorf("failed to generate message embedding: %w", err)
 	}
 
+	return s.searchRelevantContext(tenantID, projectID, messageEmbedding, settings)
+}
+
+// GetRelevantContextForEmbedding gets relevant context for AI chat for a message that was already embedded
+func (s *KnowledgeService) GetRelevantContextForEmbedding(ctx context.Context, tenantID, projectID uuid.UUID, messageEmbedding pgvector.Vector) ([]models.KnowledgeSearchResult, error) {
+	settings, err := s.knowledgeRepo.GetSettings(projectID)
+	if err != nil || !settings.Enabled {
+		return []models.KnowledgeSearchResult{}, nil
+	}
+
+	return s.searchRelevantContext(tenantID, projectID, messageEmbedding, settings)
+}
+
+// searchRelevantContext searches the knowledge of a project near a message embedding, as its settings say
+func (s *KnowledgeService) searchRelevantContext(tenantID, projectID uuid.UUID, messageEmbedding pgvector.Vector, settings *models.KnowledgeSettings) ([]models.KnowledgeSearchResult, error) {
 	// Search for relevant context
 	results, err := s.knowledgeRepo.SearchKnowledgeBase(
 		tenantID,
@@ -0,0 +1,676 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LLMProvider is a chat completion backend. Complete returns the whole reply; Stream hands each piece of
+// the reply to onDelta as it arrives and returns the whole reply once it is done.
+type LLMProvider interface {
+	Name() AIProvider
+	Complete(ctx context.Context, req ChatCompletionRequest) (*LLMResponse, error)
+	Stream(ctx context.Context, req ChatCompletionRequest, onDelta func(string)) (*LLMResponse, error)
+}
+
+// LLMResponse is a reply from a provider, with the provider and model that produced it. A reply with
+// tool calls asks for their results before the model continues.
+type LLMResponse struct {
+	Content   string
+	ToolCalls []ToolCall
+	Usage     *TokenUsageMetrics
+	Provider  AIProvider
+	Model     string
+}
+
+// LLMError is a failed provider call. Retryable errors (rate limits, server errors, timeouts and network
+// failures) move on to the next attempt or provider; others are returned as they are.
+type LLMError struct {
+	Provider   AIProvider
+	StatusCode int
+	Message    string
+	Retryable  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *LLMError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s API call failed with status %d: %s", e.Provider, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s API call failed: %s", e.Provider, e.Message)
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.Err
+}
+
+// llmEndpoint is where and how to reach a provider
+type llmEndpoint struct {
+	Provider   AIProvider
+	APIKey     string
+	BaseURL    string
+	Deployment string // Azure OpenAI deployment
+}
+
+// maxLLMResponseSize caps what is read from a provider response
+const maxLLMResponseSize = 8 << 20
+
+// newLLMProvider creates the provider for an endpoint. timeout bounds a completion, and the wait for
+// each piece of a stream.
+func newLLMProvider(endpoint llmEndpoint, client *http.Client, timeout time.Duration) (LLMProvider, error) {
+	switch endpoint.Provider {
+	case ProviderOpenAI:
+		url := "https://api.openai.com/v1/chat/completions"
+		if endpoint.BaseURL != "" {
+			url = endpoint.BaseURL + "/v1/chat/completions"
+		}
+		return &openAICompatibleProvider{
+			name:         ProviderOpenAI,
+			url:          url,
+			headers:      map[string]string{"Authorization": "Bearer " + endpoint.APIKey},
+			client:       client,
+			timeout:      timeout,
+			includeUsage: true,
+		}, nil
+	case ProviderBB:
+		if endpoint.BaseURL == "" {
+			return nil, fmt.Errorf("base URL required for %s", ProviderBB)
+		}
+		return &openAICompatibleProvider{
+			name:    ProviderBB,
+			url:     endpoint.BaseURL + "/v1/chat/completions",
+			headers: map[string]string{"api-key": endpoint.APIKey},
+			client:  client,
+			timeout: timeout,
+		}, nil
+	case ProviderAzure:
+		if endpoint.BaseURL == "" {
+			return nil, fmt.Errorf("base URL required for Azure OpenAI")
+		}
+		return &openAICompatibleProvider{
+			name:    ProviderAzure,
+			url:     endpoint.BaseURL + "/openai/deployments/" + endpoint.Deployment + "/chat/completions?api-version=2023-12-01-preview",
+			headers: map[string]string{"api-key": endpoint.APIKey},
+			client:  client,
+			timeout: timeout,
+		}, nil
+	case ProviderAnthropic:
+		url := "https://api.anthropic.com/v1/messages"
+		if endpoint.BaseURL != "" {
+			url = endpoint.BaseURL + "/v1/messages"
+		}
+		return &anthropicProvider{
+			url:     url,
+			apiKey:  endpoint.APIKey,
+			client:  client,
+			timeout: timeout,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported AI provider: %s", endpoint.Provider)
+	}
+}
+
+// openAICompatibleProvider speaks the OpenAI chat completions API, which Azure OpenAI and BB share
+type openAICompatibleProvider struct {
+	name         AIProvider
+	url          string
+	headers      map[string]string
+	client       *http.Client
+	timeout      time.Duration
+	includeUsage bool // ask for token usage at the end of a stream
+}
+
+type openAIStreamRequest struct {
+	ChatCompletionRequest
+	Stream        bool                 `json:"stream"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+func (u *openAIUsage) metrics() *TokenUsageMetrics {
+	if u == nil {
+		return nil
+	}
+	return &TokenUsageMetrics{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
+
+func (p *openAICompatibleProvider) Name() AIProvider {
+	return p.name
+}
+
+// Complete makes a chat completion call
+func (p *openAICompatibleProvider) Complete(ctx context.Context, req ChatCompletionRequest) (*LLMResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	resp, err := postLLM(ctx, p.client, p.name, p.url, p.headers, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLLMResponseSize))
+	if err != nil {
+		return nil, llmTransportError(ctx, p.name, err)
+	}
+
+	var chatResp struct {
+		Choices []struct {
+			Message ChatCompletionMessage `json:"message"`
+		} `json:"choices"`
+		Usage *openAIUsage `json:"usage,omitempty"`
+	}
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return nil, &LLMError{Provider: p.name, Message: "invalid response: " + err.Error(), Err: err}
+	}
+	if len(chatResp.Choices) == 0 {
+		return nil, &LLMError{Provider: p.name, Message: "no choices in response"}
+	}
+
+	return &LLMResponse{
+		Content:   messageText(chatResp.Choices[0].Message.Content),
+		ToolCalls: chatResp.Choices[0].Message.ToolCalls,
+		Usage:     chatResp.Usage.metrics(),
+		Provider:  p.name,
+		Model:     req.Model,
+	}, nil
+}
+
+// Stream makes a streamed chat completion call
+func (p *openAICompatibleProvider) Stream(ctx context.Context, req ChatCompletionRequest, onDelta func(string)) (*LLMResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idle := time.AfterFunc(p.timeout, cancel)
+	defer idle.Stop()
+
+	streamReq := openAIStreamRequest{ChatCompletionRequest: req, Stream: true}
+	if p.includeUsage {
+		streamReq.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	resp, err := postLLM(ctx, p.client, p.name, p.url, p.headers, streamReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &LLMResponse{Provider: p.name, Model: req.Model}
+	var content strings.Builder
+	var toolCalls toolCallBuilder
+	err = readServerSentEvents(resp.Body, func(data []byte) (bool, error) {
+		idle.Reset(p.timeout)
+		if string(data) == "[DONE]" {
+			return true, nil
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int              `json:"index"`
+						ID       string           `json:"id"`
+						Function ToolCallFunction `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *openAIUsage `json:"usage,omitempty"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return false, &LLMError{Provider: p.name, Message: "invalid stream event: " + err.Error(), Err: err}
+		}
+		if chunk.Usage != nil {
+			result.Usage = chunk.Usage.metrics()
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				toolCalls.add(call.Index, call.ID, call.Function.Name, call.Function.Arguments)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, llmTransportError(ctx, p.name, err)
+	}
+
+	result.Content = content.String()
+	result.ToolCalls = toolCalls.calls()
+	return result, nil
+}
+
+// anthropicProvider speaks the Anthropic messages API
+type anthropicProvider struct {
+	url     string
+	apiKey  string
+	client  *http.Client
+	timeout time.Duration
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	Temperature float64            `json:"temperature"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+// anthropicMessage has string content, or content blocks for tool use and tool results
+type anthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// anthropicDefaultMaxTokens is sent when the request sets no limit, which Anthropic requires
+const anthropicDefaultMaxTokens = 1024
+
+func (p *anthropicProvider) Name() AIProvider {
+	return ProviderAnthropic
+}
+
+func (p *anthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": "2023-06-01",
+	}
+}
+
+// request converts a chat completion request. Anthropic takes the system prompt separately, tool calls
+// as tool_use blocks of the assistant and tool results as tool_result blocks of the user.
+func (p *anthropicProvider) request(req ChatCompletionRequest, stream bool) anthropicRequest {
+	converted := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stream:      stream,
+		Messages:    []anthropicMessage{},
+	}
+	if converted.MaxTokens <= 0 {
+		converted.MaxTokens = anthropicDefaultMaxTokens
+	}
+	for _, tool := range req.Tools {
+		converted.Tools = append(converted.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+	}
+
+	var system []string
+	for _, message := range req.Messages {
+		switch {
+		case message.Role == "system":
+			system = append(system, messageText(message.Content))
+		case message.Role == "tool":
+			result := anthropicContentBlock{Type: "tool_result", ToolUseID: message.ToolCallID, Content: messageText(message.Content)}
+			// Results of the calls of one turn go back together in a single user message
+			if last := len(converted.Messages) - 1; last >= 0 {
+				if blocks, ok := converted.Messages[last].Content.([]anthropicContentBlock); ok && converted.Messages[last].Role == "user" {
+					converted.Messages[last].Content = append(blocks, result)
+					continue
+				}
+			}
+			converted.Messages = append(converted.Messages, anthropicMessage{Role: "user", Content: []anthropicContentBlock{result}})
+		case len(message.ToolCalls) > 0:
+			var blocks []anthropicContentBlock
+			if text := messageText(message.Content); text != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: text})
+			}
+			for _, call := range message.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+			converted.Messages = append(converted.Messages, anthropicMessage{Role: message.Role, Content: blocks})
+		default:
+			converted.Messages = append(converted.Messages, anthropicMessage{Role: message.Role, Content: message.Content})
+		}
+	}
+	converted.System = strings.Join(system, "\n\n")
+	return converted
+}
+
+// Complete makes a messages call
+func (p *anthropicProvider) Complete(ctx context.Context, req ChatCompletionRequest) (*LLMResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	resp, err := postLLM(ctx, p.client, ProviderAnthropic, p.url, p.headers(), p.request(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLLMResponseSize))
+	if err != nil {
+		return nil, llmTransportError(ctx, ProviderAnthropic, err)
+	}
+
+	var anthropicResp struct {
+		Content []anthropicContentBlock `json:"content"`
+		Usage   *anthropicUsage         `json:"usage,omitempty"`
+	}
+	if err := json.Unmarshal(body, &anthropicResp); err != nil {
+		return nil, &LLMError{Provider: ProviderAnthropic, Message: "invalid response: " + err.Error(), Err: err}
+	}
+	if len(anthropicResp.Content) == 0 {
+		return nil, &LLMError{Provider: ProviderAnthropic, Message: "no content in response"}
+	}
+
+	result := &LLMResponse{Provider: ProviderAnthropic, Model: req.Model}
+	var content strings.Builder
+	for _, block := range anthropicResp.Content {
+		switch block.Type {
+		case "", "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			result.ToolCalls = append(result.ToolCalls, ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: ToolCallFunction{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+	result.Content = content.String()
+	if anthropicResp.Usage != nil {
+		result.Usage = &TokenUsageMetrics{
+			PromptTokens:     anthropicResp.Usage.InputTokens,
+			CompletionTokens: anthropicResp.Usage.OutputTokens,
+			TotalTokens:      anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens,
+		}
+	}
+	return result, nil
+}
+
+// Stream makes a streamed messages call
+func (p *anthropicProvider) Stream(ctx context.Context, req ChatCompletionRequest, onDelta func(string)) (*LLMResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idle := time.AfterFunc(p.timeout, cancel)
+	defer idle.Stop()
+
+	resp, err := postLLM(ctx, p.client, ProviderAnthropic, p.url, p.headers(), p.request(req, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var toolCalls toolCallBuilder
+	var usage anthropicUsage
+	err = readServerSentEvents(resp.Body, func(data []byte) (bool, error) {
+		idle.Reset(p.timeout)
+
+		var event struct {
+			Type    string `json:"type"`
+			Index   int    `json:"index"`
+			Message struct {
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			ContentBlock anthropicContentBlock `json:"content_block"`
+			Delta        struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+			} `json:"delta"`
+			Usage *anthropicUsage `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return false, &LLMError{Provider: ProviderAnthropic, Message: "invalid stream event: " + err.Error(), Err: err}
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage.InputTokens = event.Message.Usage.InputTokens
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				toolCalls.add(event.Index, event.ContentBlock.ID, event.ContentBlock.Name, "")
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				if event.Delta.Text != "" {
+					content.WriteString(event.Delta.Text)
+					onDelta(event.Delta.Text)
+				}
+			case "input_json_delta":
+				toolCalls.add(event.Index, "", "", event.Delta.PartialJSON)
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return true, nil
+		case "error":
+			return false, &LLMError{
+				Provider:  ProviderAnthropic,
+				Message:   event.Error.Message,
+				Retryable: event.Error.Type == "overloaded_error" || event.Error.Type == "api_error",
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, llmTransportError(ctx, ProviderAnthropic, err)
+	}
+
+	return &LLMResponse{
+		Content:   content.String(),
+		ToolCalls: toolCalls.calls(),
+		Provider:  ProviderAnthropic,
+		Model:     req.Model,
+		Usage: &TokenUsageMetrics{
+			PromptTokens:     usage.InputTokens,
+			CompletionTokens: usage.OutputTokens,
+			TotalTokens:      usage.InputTokens + usage.OutputTokens,
+		},
+	}, nil
+}
+
+// toolCallBuilder assembles tool calls that are streamed in pieces, keyed by their index in the reply
+type toolCallBuilder struct {
+	order []int
+	byIdx map[int]*ToolCall
+}
+
+func (b *toolCallBuilder) add(index int, id, name, arguments string) {
+	if b.byIdx == nil {
+		b.byIdx = make(map[int]*ToolCall)
+	}
+	call, ok := b.byIdx[index]
+	if !ok {
+		call = &ToolCall{Type: "function"}
+		b.byIdx[index] = call
+		b.order = append(b.order, index)
+	}
+	if id != "" {
+		call.ID = id
+	}
+	if name != "" {
+		call.Function.Name = name
+	}
+	call.Function.Arguments += arguments
+}
+
+func (b *toolCallBuilder) calls() []ToolCall {
+	var calls []ToolCall
+	for _, index := range b.order {
+		call := *b.byIdx[index]
+		if call.Function.Arguments == "" {
+			call.Function.Arguments = "{}"
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// postLLM posts a JSON request to a provider and returns the response when it succeeded
+func postLLM(ctx context.Context, client *http.Client, provider AIProvider, url string, headers map[string]string, payload interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, &LLMError{Provider: provider, Message: err.Error(), Err: err}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, &LLMError{Provider: provider, Message: err.Error(), Err: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, llmTransportError(ctx, provider, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return nil, &LLMError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		Retryable:  retryableLLMStatus(resp.StatusCode),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// retryableLLMStatus reports whether a provider may answer the same request successfully later:
+// rate limits, timeouts and server errors (including Anthropic's 529 overloaded)
+func retryableLLMStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+}
+
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// llmTransportError wraps a failure to reach a provider or read its response. Those are retryable
+// unless the caller gave up.
+func llmTransportError(ctx context.Context, provider AIProvider, err error) error {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return err
+	}
+	if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+		// Canceled by the caller's own context, not by our timeout
+		return err
+	}
+	return &LLMError{Provider: provider, Message: err.Error(), Retryable: true, Err: err}
+}
+
+// readServerSentEvents hands the data of each event of a stream to handle until it reports done or the
+// stream ends
+func readServerSentEvents(body io.Reader, handle func(data []byte) (bool, error)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), maxLLMResponseSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		done, err := handle(bytes.TrimSpace(line[len("data:"):]))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// messageText extracts the text of message content, which is a string or a list of multi-modal parts
+func messageText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var text strings.Builder
+		for _, part := range v {
+			if partMap, ok := part.(map[string]interface{}); ok && partMap["type"] == "text" {
+				if partText, ok := partMap["text"].(string); ok {
+					text.WriteString(partText)
+				}
+			}
+		}
+		return text.String()
+	case []ContentPart:
+		var text strings.Builder
+		for _, part := range v {
+			if part.Type == "text" {
+				text.WriteString(part.Text)
+			}
+		}
+		return text.String()
+	default:
+		return ""
+	}
+}
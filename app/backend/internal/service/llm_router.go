@@ -0,0 +1,314 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/logger"
+)
+
+// ErrLLMUnavailable is returned when every provider in the chain failed or is cooling down
+var ErrLLMUnavailable = errors.New("no AI provider available")
+
+// defaultLLMRequestTimeout bounds a provider call when the configuration sets no timeout
+const defaultLLMRequestTimeout = 30 * time.Second
+
+// LLMCall is a completion to run through the provider chain
+type LLMCall struct {
+	Provider   AIProvider // primary provider, with the credentials configured for it
+	Deployment string     // Azure OpenAI deployment of the primary provider
+	Request    ChatCompletionRequest
+}
+
+// LLMRouter runs completions against the primary provider and fails over to the configured fallbacks
+// when a provider is rate limited, erroring or timing out. Each provider endpoint is retried with
+// exponential backoff and has a circuit breaker, so a provider that keeps failing is skipped until it
+// has had time to recover.
+type LLMRouter struct {
+	cfg    *config.AIConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewLLMRouter creates a provider router. The client must not set a Timeout, which would cut streams
+// short; calls are bounded by the configured request timeout instead.
+func NewLLMRouter(cfg *config.AIConfig, client *http.Client) *LLMRouter {
+	return &LLMRouter{
+		cfg:      cfg,
+		client:   client,
+		breakers: make(map[string]*circuitBreaker),
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+}
+
+// llmTarget is a provider endpoint of the chain with the model to ask it for
+type llmTarget struct {
+	endpoint llmEndpoint
+	model    string
+}
+
+// Complete returns the reply of the first provider of the chain that answers
+func (r *LLMRouter) Complete(ctx context.Context, call LLMCall) (*LLMResponse, error) {
+	return r.run(ctx, call, func(ctx context.Context, provider LLMProvider, req ChatCompletionRequest) (*LLMResponse, bool, error) {
+		resp, err := provider.Complete(ctx, req)
+		return resp, false, err
+	})
+}
+
+// Stream hands the reply to onDelta piece by piece as the provider produces it. Once part of a reply
+// was handed over, a failure is returned rather than starting over with another provider.
+func (r *LLMRouter) Stream(ctx context.Context, call LLMCall, onDelta func(string)) (*LLMResponse, error) {
+	return r.run(ctx, call, func(ctx context.Context, provider LLMProvider, req ChatCompletionRequest) (*LLMResponse, bool, error) {
+		started := false
+		resp, err := provider.Stream(ctx, req, func(delta string) {
+			started = true
+			onDelta(delta)
+		})
+		return resp, started, err
+	})
+}
+
+// run tries each provider of the chain in turn. attempt reports whether a failed call already
+// produced output, which rules out trying again.
+func (r *LLMRouter) run(ctx context.Context, call LLMCall, attempt func(context.Context, LLMProvider, ChatCompletionRequest) (*LLMResponse, bool, error)) (*LLMResponse, error) {
+	timeout := r.cfg.RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultLLMRequestTimeout
+	}
+
+	var lastErr error
+	for _, target := range r.chain(call) {
+		breaker := r.breaker(target.endpoint)
+		if !breaker.allow(r.now()) {
+			lastErr = fmt.Errorf("%s circuit open", target.endpoint.Provider)
+			continue
+		}
+
+		provider, err := newLLMProvider(target.endpoint, r.client, timeout)
+		if err != nil {
+			breaker.success()
+			lastErr = err
+			continue
+		}
+
+		req := call.Request
+		req.Model = target.model
+
+		for try := 0; ; try++ {
+			resp, started, err := attempt(ctx, provider, req)
+			if err == nil {
+				breaker.success()
+				if resp.Usage == nil {
+					resp.Usage = estimateTokenUsage(req, resp.Content)
+				}
+				return resp, nil
+			}
+			if ctx.Err() != nil {
+				breaker.success()
+				return nil, ctx.Err()
+			}
+
+			var llmErr *LLMError
+			if !errors.As(err, &llmErr) || !llmErr.Retryable {
+				// The provider is up and rejected the request; another one would too
+				breaker.success()
+				return nil, err
+			}
+
+			lastErr = err
+			opened := breaker.failure(r.now())
+			if started {
+				return nil, err
+			}
+			if opened || try >= r.cfg.MaxRetries || llmErr.RetryAfter > timeout {
+				logger.WarnfCtx(ctx, "AI provider %s failed, trying the next provider: %v", target.endpoint.Provider, err)
+				break
+			}
+
+			backoff := r.cfg.RetryBackoff << try
+			if llmErr.RetryAfter > backoff {
+				backoff = llmErr.RetryAfter
+			}
+			if err := r.sleep(ctx, backoff); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if lastErr == nil {
+		return nil, ErrLLMUnavailable
+	}
+	return nil, fmt.Errorf("%w: %v", ErrLLMUnavailable, lastErr)
+}
+
+// chain lists the primary provider followed by the fallbacks, without repeating an endpoint
+func (r *LLMRouter) chain(call LLMCall) []llmTarget {
+	primaryModel := call.Request.Model
+	deployment := call.Deployment
+	if deployment == "" {
+		deployment = primaryModel
+	}
+
+	provider := call.Provider
+	if provider == "" {
+		provider = AIProvider(r.cfg.Provider)
+	}
+	apiKey, baseURL, _ := providerCredentials(r.cfg, provider)
+	targets := []llmTarget{{
+		endpoint: llmEndpoint{
+			Provider:   provider,
+			APIKey:     apiKey,
+			BaseURL:    baseURL,
+			Deployment: deployment,
+		},
+		model: primaryModel,
+	}}
+	seen := map[string]bool{llmEndpointKey(targets[0].endpoint): true}
+
+	for _, fallback := range r.cfg.Fallbacks {
+		model := fallback.Model
+		if model == "" {
+			model = primaryModel
+		}
+		endpoint := llmEndpoint{
+			Provider:   AIProvider(fallback.Provider),
+			APIKey:     fallback.APIKey,
+			BaseURL:    fallback.BaseURL,
+			Deployment: model,
+		}
+		key := llmEndpointKey(endpoint)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		targets = append(targets, llmTarget{endpoint: endpoint, model: model})
+	}
+	return targets
+}
+
+// providerCredentials returns the API key and base URL configured for a provider: the global ones for
+// the configured provider, otherwise those of the first fallback of that provider. ok is false when no
+// API key is configured for it.
+func providerCredentials(cfg *config.AIConfig, provider AIProvider) (apiKey, baseURL string, ok bool) {
+	if cfg == nil {
+		return "", "", false
+	}
+	if provider == AIProvider(cfg.Provider) {
+		return cfg.APIKey, cfg.BaseURL, cfg.APIKey != ""
+	}
+	for _, fallback := range cfg.Fallbacks {
+		if AIProvider(fallback.Provider) == provider {
+			return fallback.APIKey, fallback.BaseURL, fallback.APIKey != ""
+		}
+	}
+	return "", "", false
+}
+
+func llmEndpointKey(endpoint llmEndpoint) string {
+	return string(endpoint.Provider) + "|" + endpoint.BaseURL
+}
+
+// breaker returns the circuit breaker of a provider endpoint
+func (r *LLMRouter) breaker(endpoint llmEndpoint) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := llmEndpointKey(endpoint)
+	breaker, ok := r.breakers[key]
+	if !ok {
+		breaker = &circuitBreaker{threshold: r.cfg.CircuitBreakerThreshold, cooldown: r.cfg.CircuitBreakerCooldown}
+		r.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// circuitBreaker opens after threshold consecutive failures and lets a single probe through once the
+// cooldown has passed. A threshold of zero never opens.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	open     bool
+	probing  bool
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if b.probing || now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.open = false
+	b.probing = false
+}
+
+// failure records a failed call and reports whether the breaker is open now
+func (b *circuitBreaker) failure(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.threshold > 0 && (b.probing || b.failures >= b.threshold) {
+		b.open = true
+		b.openedAt = now
+	}
+	b.probing = false
+	return b.open
+}
+
+// estimateTokenUsage approximates usage at four characters a token for providers that do not report it,
+// so replies are still billed
+func estimateTokenUsage(req ChatCompletionRequest, reply string) *TokenUsageMetrics {
+	promptChars := 0
+	for _, message := range req.Messages {
+		promptChars += len(messageText(message.Content))
+	}
+	usage := &TokenUsageMetrics{
+		PromptTokens:     int64(promptChars / 4),
+		CompletionTokens: int64(len(reply) / 4),
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
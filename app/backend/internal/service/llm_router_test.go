@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/config"
+)
+
+func openAIReply(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"` + content + `"}}],"usage":{"prompt_tokens":10,"completion_tokens":3,"total_tokens":13}}`))
+}
+
+func newTestRouter(cfg *config.AIConfig) *LLMRouter {
+	router := NewLLMRouter(cfg, &http.Client{})
+	router.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return router
+}
+
+func testCall(provider AIProvider) LLMCall {
+	return LLMCall{
+		Provider: provider,
+		Request: ChatCompletionRequest{
+			Model:    "gpt-4o",
+			Messages: []ChatCompletionMessage{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Hi"}},
+		},
+	}
+}
+
+func TestLLMRouterRetriesThenFailsOverOnServerErrors(t *testing.T) {
+	var primaryCalls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	var fallbackModel string
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "fallback-key", r.Header.Get("api-key"))
+		var req ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		fallbackModel = req.Model
+		openAIReply(w, "Hello from the fallback")
+	}))
+	defer fallback.Close()
+
+	cfg := &config.AIConfig{
+		Provider:   "openai",
+		APIKey:     "key",
+		BaseURL:    primary.URL,
+		MaxRetries: 2,
+		Fallbacks:  []config.AIFallbackConfig{{Provider: "bb", APIKey: "fallback-key", BaseURL: fallback.URL, Model: "bb-large"}},
+	}
+	resp, err := newTestRouter(cfg).Complete(context.Background(), testCall(ProviderOpenAI))
+	require.NoError(t, err)
+	require.Equal(t, "Hello from the fallback", resp.Content)
+	require.Equal(t, ProviderBB, resp.Provider)
+	require.Equal(t, "bb-large", fallbackModel)
+	require.Equal(t, int64(13), resp.Usage.TotalTokens)
+	require.Equal(t, int32(3), atomic.LoadInt32(&primaryCalls))
+}
+
+func TestLLMRouterDoesNotFailOverOnClientErrors(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"context too long"}`))
+	}))
+	defer primary.Close()
+
+	var fallbackCalls int32
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fallbackCalls, 1)
+		openAIReply(w, "unused")
+	}))
+	defer fallback.Close()
+
+	cfg := &config.AIConfig{
+		Provider:   "openai",
+		BaseURL:    primary.URL,
+		MaxRetries: 2,
+		Fallbacks:  []config.AIFallbackConfig{{Provider: "bb", BaseURL: fallback.URL}},
+	}
+	_, err := newTestRouter(cfg).Complete(context.Background(), testCall(ProviderOpenAI))
+
+	var llmErr *LLMError
+	require.ErrorAs(t, err, &llmErr)
+	require.Equal(t, http.StatusBadRequest, llmErr.StatusCode)
+	require.NotErrorIs(t, err, ErrLLMUnavailable)
+	require.Zero(t, atomic.LoadInt32(&fallbackCalls))
+}
+
+func TestLLMRouterCircuitBreakerSkipsFailingProviderUntilCooldown(t *testing.T) {
+	var primaryCalls int32
+	var healthy atomic.Bool
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		openAIReply(w, "primary")
+	}))
+	defer primary.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openAIReply(w, "fallback")
+	}))
+	defer fallback.Close()
+
+	cfg := &config.AIConfig{
+		Provider:                "openai",
+		BaseURL:                 primary.URL,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Minute,
+		Fallbacks:               []config.AIFallbackConfig{{Provider: "bb", BaseURL: fallback.URL}},
+	}
+	router := newTestRouter(cfg)
+	now := time.Now()
+	router.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		resp, err := router.Complete(context.Background(), testCall(ProviderOpenAI))
+		require.NoError(t, err)
+		require.Equal(t, "fallback", resp.Content)
+	}
+	// The breaker opened after two failures, so the third call went straight to the fallback
+	require.Equal(t, int32(2), atomic.LoadInt32(&primaryCalls))
+
+	// After the cooldown a probe is let through and closes the breaker again
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	resp, err := router.Complete(context.Background(), testCall(ProviderOpenAI))
+	require.NoError(t, err)
+	require.Equal(t, "primary", resp.Content)
+}
+
+func TestLLMRouterReturnsUnavailableWhenEveryProviderFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	cfg := &config.AIConfig{Provider: "openai", BaseURL: server.URL}
+	_, err := newTestRouter(cfg).Complete(context.Background(), testCall(ProviderOpenAI))
+	require.ErrorIs(t, err, ErrLLMUnavailable)
+}
+
+func TestLLMRouterStreamsOpenAIDeltas(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIStreamRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.True(t, req.Stream)
+		require.NotNil(t, req.StreamOptions)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(strings.Join([]string{
+			`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+			`data: {"choices":[{"delta":{"content":"Hel"}}]}`,
+			`data: {"choices":[{"delta":{"content":"lo!"}}]}`,
+			`data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":2,"total_tokens":14}}`,
+			`data: [DONE]`,
+			``,
+		}, "\n\n")))
+	}))
+	defer server.Close()
+
+	var deltas []string
+	resp, err := newTestRouter(&config.AIConfig{Provider: "openai", BaseURL: server.URL}).Stream(context.Background(), testCall(ProviderOpenAI), func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Hel", "lo!"}, deltas)
+	require.Equal(t, "Hello!", resp.Content)
+	require.Equal(t, int64(14), resp.Usage.TotalTokens)
+}
+
+func TestLLMRouterStreamsAnthropicDeltas(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/messages", r.URL.Path)
+		var req anthropicRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "Be brief.", req.System)
+		require.Len(t, req.Messages, 1)
+		require.Equal(t, anthropicDefaultMaxTokens, req.MaxTokens)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(strings.Join([]string{
+			"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":20,\"output_tokens\":1}}}",
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi \"}}",
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"there\"}}",
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":2}}",
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}",
+			"",
+		}, "\n\n")))
+	}))
+	defer server.Close()
+
+	var streamed strings.Builder
+	resp, err := newTestRouter(&config.AIConfig{Provider: "anthropic", BaseURL: server.URL}).Stream(context.Background(), testCall(ProviderAnthropic), func(delta string) {
+		streamed.WriteString(delta)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Hi there", streamed.String())
+	require.Equal(t, "Hi there", resp.Content)
+	require.Equal(t, int64(20), resp.Usage.PromptTokens)
+	require.Equal(t, int64(2), resp.Usage.CompletionTokens)
+}
+
+func TestLLMRouterDoesNotFailOverAfterStreamStarted(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// The stream breaks off before [DONE]
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Partial\"}}]}\n\n"))
+	}))
+	defer primary.Close()
+
+	var fallbackCalls int32
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fallbackCalls, 1)
+	}))
+	defer fallback.Close()
+
+	cfg := &config.AIConfig{
+		Provider:   "openai",
+		BaseURL:    primary.URL,
+		MaxRetries: 1,
+		Fallbacks:  []config.AIFallbackConfig{{Provider: "bb", BaseURL: fallback.URL}},
+	}
+	_, err := newTestRouter(cfg).Stream(context.Background(), testCall(ProviderOpenAI), func(string) {})
+	require.Error(t, err)
+	require.Zero(t, atomic.LoadInt32(&fallbackCalls))
+}
+
+func TestLLMRouterUsesCredentialsOfTheRequestedProvider(t *testing.T) {
+	var authHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("api-key"))
+		openAIReply(w, "Hello")
+	}))
+	defer server.Close()
+
+	cfg := &config.AIConfig{
+		Provider:  "openai",
+		APIKey:    "openai-key",
+		Fallbacks: []config.AIFallbackConfig{{Provider: "bb", APIKey: "bb-key", BaseURL: server.URL}},
+	}
+	resp, err := newTestRouter(cfg).Complete(context.Background(), testCall(ProviderBB))
+	require.NoError(t, err)
+	require.Equal(t, "Hello", resp.Content)
+	require.Equal(t, []string{"bb-key"}, authHeaders)
+}
+
+func TestCompleteWithProfileReportsTheProviderThatAnswered(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openAIReply(w, "Hello")
+	}))
+	defer fallback.Close()
+
+	cfg := &config.AIConfig{
+		Provider:  "openai",
+		APIKey:    "key",
+		BaseURL:   primary.URL,
+		Model:     "gpt-4o",
+		Fallbacks: []config.AIFallbackConfig{{Provider: "bb", APIKey: "fallback-key", BaseURL: fallback.URL, Model: "bb-large"}},
+	}
+	svc := &AIService{config: cfg, llm: newTestRouter(cfg)}
+	completion, err := svc.completeWithProfile(context.Background(), globalAIProfile(cfg), []ChatCompletionMessage{
+		{Role: "user", Content: "Hi"},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	// The reply is billed at the fallback's model, not the profile's
+	require.Equal(t, "Hello", completion.Content)
+	require.Equal(t, ProviderBB, completion.Provider)
+	require.Equal(t, "bb-large", completion.Model)
+	require.Equal(t, int64(13), completion.Usage.TotalTokens)
+}
@@ -171,3 +171,53 @@ func (s *MailerooService) SendTicketUpdatedNotification(ctx context.Context, tic
 
 	return nil
 }
+
+// SendChatTranscriptEmail sends the transcript of an ended chat session.
+func (s *MailerooService) SendChatTranscriptEmail(ctx context.Context, transcript *ChatTranscript, toEmail, recipientName string) error {
+	if s.environment == "development" {
+		fmt.Printf("Development mode: Would send chat transcript via Maileroo to %s\n", toEmail)
+		return nil
+	}
+
+	subject, htmlBody, textBody := buildChatTranscriptEmail(transcript, recipientName, toEmail)
+	html := htmlBody
+	text := textBody
+
+	_, err := s.client.SendBasicEmail(ctx, maileroo.BasicEmailData{
+		From:    s.newSender("", ""),
+		To:      []maileroo.EmailAddress{s.newRecipient(toEmail, recipientName)},
+		Subject: subject,
+		HTML:    &html,
+		Plain:   &text,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send chat transcript via Maileroo: %w", err)
+	}
+
+	return nil
+}
+
+// SendChatFollowUpEmail sends the visitor a link to the ticket their chat was converted to.
+func (s *MailerooService) SendChatFollowUpEmail(ctx context.Context, ticket *db.Ticket, link, toEmail, recipientName string) error {
+	if s.environment == "development" {
+		fmt.Printf("Development mode: Would send chat follow-up via Maileroo for ticket #%d to %s\n", ticket.Number, toEmail)
+		return nil
+	}
+
+	subject, htmlBody, textBody := buildChatFollowUpEmail(ticket, link, recipientName, toEmail)
+	html := htmlBody
+	text := textBody
+
+	_, err := s.client.SendBasicEmail(ctx, maileroo.BasicEmailData{
+		From:    s.newSender("", ""),
+		To:      []maileroo.EmailAddress{s.newRecipient(toEmail, recipientName)},
+		Subject: subject,
+		HTML:    &html,
+		Plain:   &text,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send chat follow-up via Maileroo: %w", err)
+	}
+
+	return nil
+}
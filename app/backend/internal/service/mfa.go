@@ -0,0 +1,567 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bareuptime/tms/internal/auth"
+	"github.com/bareuptime/tms/internal/crypto"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/bareuptime/tms/internal/redis"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/google/uuid"
+)
+
+// MFA errors
+var (
+	ErrMFAInvalidCode      = errors.New("invalid verification code")
+	ErrMFATooManyAttempts  = errors.New("too many verification attempts, try again later")
+	ErrMFAChallengeExpired = errors.New("MFA challenge expired or not found")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFANotEnrolling     = errors.New("no pending two-factor enrollment")
+	ErrMFARequiredByPolicy = errors.New("two-factor authentication is required by tenant policy")
+	ErrMFAInvalidPolicy    = errors.New("invalid MFA policy")
+)
+
+const (
+	mfaChallengeTTL        = 5 * time.Minute
+	mfaAttemptWindow       = 15 * time.Minute
+	mfaMaxAttempts         = 5
+	mfaRecoveryCodeCount   = 10
+	mfaRecoveryCodeLength  = 10
+	mfaChallengeTokenBytes = 32
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAChallenge is returned by login instead of tokens when a second factor is needed
+type MFAChallenge struct {
+	MFARequired        bool   `json:"mfa_required" example:"true"`
+	MFAToken           string `json:"mfa_token" example:"3q2-7wEAAAB..."`
+	EnrollmentRequired bool   `json:"enrollment_required" example:"false"`
+	ExpiresIn          int    `json:"expires_in" example:"300"`
+}
+
+// MFAEnrollment carries a new TOTP secret for the authenticator app
+type MFAEnrollment struct {
+	Secret          string `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	ProvisioningURI string `json:"provisioning_uri" example:"otpauth://totp/Hith:agent@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Hith"`
+}
+
+// MFAStatus describes the second factor of an agent
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	Required               bool       `json:"required"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// MFAVerification is the outcome of a successful login challenge
+type MFAVerification struct {
+	AgentID       uuid.UUID
+	TenantID      uuid.UUID
+	RecoveryCodes []string
+}
+
+// mfaChallengeData is the state kept in Redis for a pending login challenge
+type mfaChallengeData struct {
+	AgentID  uuid.UUID `json:"agent_id"`
+	TenantID uuid.UUID `json:"tenant_id"`
+	Enroll   bool      `json:"enroll"`
+}
+
+// MFAService handles TOTP enrollment, login challenges, recovery codes and the tenant MFA policy
+type MFAService struct {
+	mfaRepo      repo.MFARepository
+	agentRepo    repo.AgentRepository
+	rbacService  *rbac.Service
+	redisService *redis.Service
+	encryption   *crypto.PasswordEncryption
+	issuer       string
+}
+
+// NewMFAService creates a new MFA service
+func NewMFAService(mfaRepo repo.MFARepository, agentRepo repo.AgentRepository, rbacService *rbac.Service, redisService *redis.Service, encryption *crypto.PasswordEncryption, issuer string) *MFAService {
+	return &MFAService{
+		mfaRepo:      mfaRepo,
+		agentRepo:    agentRepo,
+		rbacService:  rbacService,
+		redisService: redisService,
+		encryption:   encryption,
+		issuer:       issuer,
+	}
+}
+
+func mfaChallengeKey(token string) string {
+	return fmt.Sprintf("mfa_challenge:%s", token)
+}
+
+func mfaAttemptsKey(agentID uuid.UUID) string {
+	return fmt.Sprintf("mfa_verify_attempts:%s", agentID.String())
+}
+
+// mfaRequired reports whether the tenant policy requires a second factor for the role bindings
+func mfaRequired(policy string, roleBindings []*db.RoleBinding) bool {
+	switch policy {
+	case db.MFAPolicyAll:
+		return true
+	case db.MFAPolicyAdmins:
+		for _, binding := range roleBindings {
+			if binding.Role == models.RoleTenantAdmin || binding.Role == models.RoleProjectAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetMFAPolicy returns the MFA policy of a tenant
+func (s *MFAService) GetMFAPolicy(ctx context.Context, tenantID uuid.UUID) (string, error) {
+	settings, err := s.mfaRepo.GetSecuritySettings(ctx, tenantID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get security settings: %w", err)
+	}
+	if settings == nil {
+		return db.MFAPolicyOff, nil
+	}
+	return settings.MFAPolicy, nil
+}
+
+// SetMFAPolicy changes the MFA policy of a tenant. Agents that are not enrolled
+// will be asked to enroll on their next login.
+func (s *MFAService) SetMFAPolicy(ctx context.Context, tenantID uuid.UUID, policy string) (*db.TenantSecuritySettings, error) {
+	switch policy {
+	case db.MFAPolicyOff, db.MFAPolicyAdmins, db.MFAPolicyAll:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrMFAInvalidPolicy, policy)
+	}
+
+	settings, err := s.mfaRepo.SetMFAPolicy(ctx, tenantID, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save MFA policy: %w", err)
+	}
+	return settings, nil
+}
+
+// requiredFor reports whether the tenant policy applies to an agent
+func (s *MFAService) requiredFor(ctx context.Context, tenantID, agentID uuid.UUID, roleBindings []*db.RoleBinding) (bool, error) {
+	policy, err := s.GetMFAPolicy(ctx, tenantID)
+	if err != nil {
+		return false, err
+	}
+	if policy == db.MFAPolicyOff {
+		return false, nil
+	}
+
+	if roleBindings == nil {
+		roleBindings, err = s.rbacService.GetAgentRoleBindings(ctx, agentID, tenantID)
+		if err != nil {
+			return false, fmt.Errorf("failed to get role bindings: %w", err)
+		}
+	}
+	return mfaRequired(policy, roleBindings), nil
+}
+
+// BeginLoginChallenge decides whether a login needs a second factor. It returns nil when
+// tokens can be issued right away, otherwise a challenge to complete with VerifyChallenge.
+func (s *MFAService) BeginLoginChallenge(ctx context.Context, agent *db.Agent, roleBindings []*db.RoleBinding) (*MFAChallenge, error) {
+	mfa, err := s.mfaRepo.GetAgentMFA(ctx, agent.TenantID, agent.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA settings: %w", err)
+	}
+
+	enroll := false
+	if mfa == nil || !mfa.Enabled {
+		required, err := s.requiredFor(ctx, agent.TenantID, agent.ID, roleBindings)
+		if err != nil {
+			return nil, err
+		}
+		if !required {
+			return nil, nil
+		}
+		enroll = true
+	}
+
+	token, err := generateMFAToken()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(mfaChallengeData{AgentID: agent.ID, TenantID: agent.TenantID, Enroll: enroll})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode MFA challenge: %w", err)
+	}
+	if err := s.redisService.GetClient().Set(ctx, mfaChallengeKey(token), data, mfaChallengeTTL).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store MFA challenge: %w", err)
+	}
+
+	return &MFAChallenge{
+		MFARequired:        true,
+		MFAToken:           token,
+		EnrollmentRequired: enroll,
+		ExpiresIn:          int(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// getChallenge loads a pending login challenge
+func (s *MFAService) getChallenge(ctx context.Context, token string) (*mfaChallengeData, error) {
+	if token == "" {
+		return nil, ErrMFAChallengeExpired
+	}
+
+	raw, err := s.redisService.GetClient().Get(ctx, mfaChallengeKey(token)).Result()
+	if err != nil {
+		return nil, ErrMFAChallengeExpired
+	}
+
+	var challenge mfaChallengeData
+	if err := json.Unmarshal([]byte(raw), &challenge); err != nil {
+		return nil, ErrMFAChallengeExpired
+	}
+	return &challenge, nil
+}
+
+// StartChallengeEnrollment issues a TOTP secret to an agent that the tenant policy forces to enroll during login
+func (s *MFAService) StartChallengeEnrollment(ctx context.Context, token string) (*MFAEnrollment, error) {
+	challenge, err := s.getChallenge(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !challenge.Enroll {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return s.StartEnrollment(ctx, challenge.TenantID, challenge.AgentID)
+}
+
+// VerifyChallenge completes a login challenge with a TOTP code or a recovery code.
+// For challenges that require enrollment, the code confirms the new secret and the
+// recovery codes are returned once.
+func (s *MFAService) VerifyChallenge(ctx context.Context, token, code string) (*MFAVerification, error) {
+	challenge, err := s.getChallenge(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAttempts(ctx, challenge.AgentID); err != nil {
+		// Force a fresh password login once the attempt budget is spent
+		s.redisService.GetClient().Del(ctx, mfaChallengeKey(token))
+		return nil, err
+	}
+
+	verification := &MFAVerification{AgentID: challenge.AgentID, TenantID: challenge.TenantID}
+	if challenge.Enroll {
+		codes, err := s.ConfirmEnrollment(ctx, challenge.TenantID, challenge.AgentID, code)
+		if err != nil {
+			return nil, err
+		}
+		verification.RecoveryCodes = codes
+	} else {
+		if err := s.verifyCode(ctx, challenge.TenantID, challenge.AgentID, code, true); err != nil {
+			return nil, err
+		}
+	}
+
+	s.redisService.GetClient().Del(ctx, mfaChallengeKey(token))
+	return verification, nil
+}
+
+// GetStatus returns the second factor status of an agent
+func (s *MFAService) GetStatus(ctx context.Context, tenantID, agentID uuid.UUID) (*MFAStatus, error) {
+	mfa, err := s.mfaRepo.GetAgentMFA(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA settings: %w", err)
+	}
+
+	required, err := s.requiredFor(ctx, tenantID, agentID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MFAStatus{Required: required}
+	if mfa != nil && mfa.Enabled {
+		status.Enabled = true
+		status.EnabledAt = mfa.EnabledAt
+		status.RecoveryCodesRemaining, err = s.mfaRepo.CountUnusedRecoveryCodes(ctx, tenantID, agentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count recovery codes: %w", err)
+		}
+	}
+
+	return status, nil
+}
+
+// StartEnrollment generates a new TOTP secret for an agent. The factor stays inactive
+// until ConfirmEnrollment receives a valid code for it.
+func (s *MFAService) StartEnrollment(ctx context.Context, tenantID, agentID uuid.UUID) (*MFAEnrollment, error) {
+	mfa, err := s.mfaRepo.GetAgentMFA(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA settings: %w", err)
+	}
+	if mfa != nil && mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	agent, err := s.agentRepo.GetByID(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	if agent == nil {
+		return nil, fmt.Errorf("agent not found")
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := s.encryption.Encrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+
+	if err := s.mfaRepo.SavePendingAgentMFA(ctx, tenantID, agentID, string(encrypted)); err != nil {
+		return nil, fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, s.issuer, agent.Email),
+	}, nil
+}
+
+// ConfirmEnrollment enables the pending factor once the agent proves their app produces
+// valid codes. It returns the recovery codes, which are only shown this once.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, tenantID, agentID uuid.UUID, code string) ([]string, error) {
+	mfa, err := s.mfaRepo.GetAgentMFA(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA settings: %w", err)
+	}
+	if mfa == nil {
+		return nil, ErrMFANotEnrolling
+	}
+	if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if err := s.checkAttempts(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	step, ok, err := s.validateTOTP(mfa, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.recordFailure(ctx, agentID)
+		return nil, ErrMFAInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.mfaRepo.EnableAgentMFA(ctx, tenantID, agentID, step, hashes); err != nil {
+		return nil, fmt.Errorf("failed to enable MFA: %w", err)
+	}
+
+	s.clearAttempts(ctx, agentID)
+	logger.InfofCtx(ctx, "Two-factor authentication enabled - agent_id: %s, tenant_id: %s", agentID, tenantID)
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a TOTP code
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, tenantID, agentID uuid.UUID, code string) ([]string, error) {
+	if err := s.verifyCode(ctx, tenantID, agentID, code, false); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(ctx, tenantID, agentID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to replace recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// Disable turns off the second factor of an agent after verifying a code.
+// Agents covered by the tenant policy cannot opt out.
+func (s *MFAService) Disable(ctx context.Context, tenantID, agentID uuid.UUID, code string) error {
+	required, err := s.requiredFor(ctx, tenantID, agentID, nil)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequiredByPolicy
+	}
+
+	if err := s.verifyCode(ctx, tenantID, agentID, code, true); err != nil {
+		return err
+	}
+
+	if err := s.mfaRepo.DeleteAgentMFA(ctx, tenantID, agentID); err != nil {
+		return fmt.Errorf("failed to disable MFA: %w", err)
+	}
+
+	logger.InfofCtx(ctx, "Two-factor authentication disabled - agent_id: %s, tenant_id: %s", agentID, tenantID)
+	return nil
+}
+
+// ResetAgentMFA lets a tenant admin remove the second factor of an agent who lost their device.
+// If the policy applies to the agent, they will be asked to enroll again on next login.
+func (s *MFAService) ResetAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID) error {
+	if err := s.mfaRepo.DeleteAgentMFA(ctx, tenantID, agentID); err != nil {
+		return fmt.Errorf("failed to reset MFA: %w", err)
+	}
+
+	s.clearAttempts(ctx, agentID)
+	logger.InfofCtx(ctx, "Two-factor authentication reset by admin - agent_id: %s, tenant_id: %s", agentID, tenantID)
+	return nil
+}
+
+// verifyCode checks a TOTP code, or a recovery code when allowed, against an enabled factor
+func (s *MFAService) verifyCode(ctx context.Context, tenantID, agentID uuid.UUID, code string, allowRecovery bool) error {
+	mfa, err := s.mfaRepo.GetAgentMFA(ctx, tenantID, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to get MFA settings: %w", err)
+	}
+	if mfa == nil || !mfa.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	if err := s.checkAttempts(ctx, agentID); err != nil {
+		return err
+	}
+
+	valid := false
+	if isTOTPCode(code) {
+		step, ok, err := s.validateTOTP(mfa, code)
+		if err != nil {
+			return err
+		}
+		if ok {
+			// A code can only be used once, even inside its validity window
+			valid, err = s.mfaRepo.MarkStepUsed(ctx, tenantID, agentID, step)
+			if err != nil {
+				return fmt.Errorf("failed to record TOTP use: %w", err)
+			}
+		}
+	} else if allowRecovery {
+		valid, err = s.mfaRepo.ConsumeRecoveryCode(ctx, tenantID, agentID, hashRecoveryCode(code))
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		if valid {
+			logger.InfofCtx(ctx, "Recovery code used - agent_id: %s, tenant_id: %s", agentID, tenantID)
+		}
+	}
+
+	if !valid {
+		s.recordFailure(ctx, agentID)
+		return ErrMFAInvalidCode
+	}
+
+	s.clearAttempts(ctx, agentID)
+	return nil
+}
+
+// validateTOTP decrypts the stored secret and checks the code against it
+func (s *MFAService) validateTOTP(mfa *db.AgentMFA, code string) (int64, bool, error) {
+	secret, err := s.encryption.Decrypt([]byte(mfa.SecretEncrypted))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+
+	step, ok := auth.ValidateTOTPCode(secret, code, time.Now())
+	if ok && step <= mfa.LastUsedStep {
+		return 0, false, nil
+	}
+	return step, ok, nil
+}
+
+// checkAttempts rejects verification while the agent is over the failed attempt budget
+func (s *MFAService) checkAttempts(ctx context.Context, agentID uuid.UUID) error {
+	attempts, err := s.redisService.GetAttempts(ctx, mfaAttemptsKey(agentID))
+	if err != nil {
+		return fmt.Errorf("failed to check attempts: %w", err)
+	}
+	if attempts >= mfaMaxAttempts {
+		return ErrMFATooManyAttempts
+	}
+	return nil
+}
+
+func (s *MFAService) recordFailure(ctx context.Context, agentID uuid.UUID) {
+	if _, err := s.redisService.IncrementAttempts(ctx, mfaAttemptsKey(agentID), mfaAttemptWindow); err != nil {
+		logger.WarnfCtx(ctx, "Failed to record MFA attempt for agent %s: %v", agentID, err)
+	}
+}
+
+func (s *MFAService) clearAttempts(ctx context.Context, agentID uuid.UUID) {
+	s.redisService.GetClient().Del(ctx, mfaAttemptsKey(agentID))
+}
+
+// isTOTPCode reports whether the input looks like an authenticator code rather than a recovery code
+func isTOTPCode(code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != auth.TOTPDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func generateMFAToken() (string, error) {
+	buf := make([]byte, mfaChallengeTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate MFA token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// generateRecoveryCodes returns display codes (xxxxx-xxxxx) and their hashes for storage
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, mfaRecoveryCodeCount)
+	hashes := make([]string, 0, mfaRecoveryCodeCount)
+
+	for i := 0; i < mfaRecoveryCodeCount; i++ {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))[:mfaRecoveryCodeLength]
+		code := raw[:mfaRecoveryCodeLength/2] + "-" + raw[mfaRecoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes a recovery code and returns its SHA-256 hex digest.
+// Codes carry 50 bits of randomness, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bareuptime/tms/internal/auth"
+	"github.com/bareuptime/tms/internal/crypto"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/redis"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type mockMFARepository struct {
+	repo.MFARepository
+	factors       map[uuid.UUID]*db.AgentMFA
+	recoveryCodes map[uuid.UUID]map[string]bool
+	policy        string
+}
+
+func newMockMFARepository() *mockMFARepository {
+	return &mockMFARepository{
+		factors:       make(map[uuid.UUID]*db.AgentMFA),
+		recoveryCodes: make(map[uuid.UUID]map[string]bool),
+	}
+}
+
+func (m *mockMFARepository) GetAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID) (*db.AgentMFA, error) {
+	mfa, ok := m.factors[agentID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *mfa
+	return &copied, nil
+}
+
+func (m *mockMFARepository) SavePendingAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID, secretEncrypted string) error {
+	if existing, ok := m.factors[agentID]; ok && existing.Enabled {
+		return nil
+	}
+	m.factors[agentID] = &db.AgentMFA{AgentID: agentID, TenantID: tenantID, SecretEncrypted: secretEncrypted}
+	return nil
+}
+
+func (m *mockMFARepository) EnableAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	mfa := m.factors[agentID]
+	now := time.Now()
+	mfa.Enabled = true
+	mfa.EnabledAt = &now
+	mfa.LastUsedStep = step
+	return m.ReplaceRecoveryCodes(ctx, tenantID, agentID, recoveryCodeHashes)
+}
+
+func (m *mockMFARepository) MarkStepUsed(ctx context.Context, tenantID, agentID uuid.UUID, step int64) (bool, error) {
+	mfa := m.factors[agentID]
+	if mfa.LastUsedStep >= step {
+		return false, nil
+	}
+	mfa.LastUsedStep = step
+	return true, nil
+}
+
+func (m *mockMFARepository) ReplaceRecoveryCodes(ctx context.Context, tenantID, agentID uuid.UUID, recoveryCodeHashes []string) error {
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = false
+	}
+	m.recoveryCodes[agentID] = codes
+	return nil
+}
+
+func (m *mockMFARepository) ConsumeRecoveryCode(ctx context.Context, tenantID, agentID uuid.UUID, codeHash string) (bool, error) {
+	used, ok := m.recoveryCodes[agentID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	m.recoveryCodes[agentID][codeHash] = true
+	return true, nil
+}
+
+func (m *mockMFARepository) CountUnusedRecoveryCodes(ctx context.Context, tenantID, agentID uuid.UUID) (int, error) {
+	count := 0
+	for _, used := range m.recoveryCodes[agentID] {
+		if !used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockMFARepository) DeleteAgentMFA(ctx context.Context, tenantID, agentID uuid.UUID) error {
+	delete(m.factors, agentID)
+	delete(m.recoveryCodes, agentID)
+	return nil
+}
+
+func (m *mockMFARepository) GetSecuritySettings(ctx context.Context, tenantID uuid.UUID) (*db.TenantSecuritySettings, error) {
+	if m.policy == "" {
+		return nil, nil
+	}
+	return &db.TenantSecuritySettings{TenantID: tenantID, MFAPolicy: m.policy}, nil
+}
+
+func (m *mockMFARepository) SetMFAPolicy(ctx context.Context, tenantID uuid.UUID, policy string) (*db.TenantSecuritySettings, error) {
+	m.policy = policy
+	return &db.TenantSecuritySettings{TenantID: tenantID, MFAPolicy: policy}, nil
+}
+
+type mfaAgentRepository struct {
+	repo.AgentRepository
+	agent *db.Agent
+}
+
+func (m *mfaAgentRepository) GetByID(ctx context.Context, tenantID, agentID uuid.UUID) (*db.Agent, error) {
+	return m.agent, nil
+}
+
+func newTestMFAService(t *testing.T, agent *db.Agent) (*MFAService, *mockMFARepository) {
+	t.Helper()
+
+	mini, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mini.Close)
+
+	encryption, err := crypto.NewPasswordEncryption()
+	require.NoError(t, err)
+
+	mfaRepo := newMockMFARepository()
+	redisService := redis.NewService(redis.RedisConfig{
+		URL:         fmt.Sprintf("redis://%s", mini.Addr()),
+		Environment: "test",
+	})
+
+	return NewMFAService(mfaRepo, &mfaAgentRepository{agent: agent}, nil, redisService, encryption, "Hith"), mfaRepo
+}
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	t.Parallel()
+
+	// RFC 6238 appendix B secret "12345678901234567890", truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		code, err := auth.GenerateTOTPCode(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, expected, code, "time %d", unix)
+
+		step, ok := auth.ValidateTOTPCode(secret, expected, time.Unix(unix+30, 0))
+		require.True(t, ok, "previous step should be accepted for clock drift")
+		require.Equal(t, auth.TOTPStep(time.Unix(unix, 0)), step)
+
+		_, ok = auth.ValidateTOTPCode(secret, expected, time.Unix(unix+90, 0))
+		require.False(t, ok, "codes outside the drift window must be rejected")
+	}
+}
+
+func TestMFAServiceEnrollmentAndLoginChallenge(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	agent := &db.Agent{ID: uuid.New(), TenantID: uuid.New(), Email: "agent@example.com", Status: "active"}
+	svc, _ := newTestMFAService(t, agent)
+
+	// Without MFA and without a policy, login proceeds directly
+	challenge, err := svc.BeginLoginChallenge(ctx, agent, nil)
+	require.NoError(t, err)
+	require.Nil(t, challenge)
+
+	enrollment, err := svc.StartEnrollment(ctx, agent.TenantID, agent.ID)
+	require.NoError(t, err)
+	require.Contains(t, enrollment.ProvisioningURI, "otpauth://totp/Hith:agent@example.com?")
+	require.Contains(t, enrollment.ProvisioningURI, "secret="+enrollment.Secret)
+
+	_, err = svc.ConfirmEnrollment(ctx, agent.TenantID, agent.ID, "000000")
+	require.ErrorIs(t, err, ErrMFAInvalidCode)
+
+	code, err := auth.GenerateTOTPCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := svc.ConfirmEnrollment(ctx, agent.TenantID, agent.ID, code)
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, mfaRecoveryCodeCount)
+
+	challenge, err = svc.BeginLoginChallenge(ctx, agent, nil)
+	require.NoError(t, err)
+	require.NotNil(t, challenge)
+	require.True(t, challenge.MFARequired)
+	require.False(t, challenge.EnrollmentRequired)
+
+	// The code used for enrollment cannot be replayed
+	_, err = svc.VerifyChallenge(ctx, challenge.MFAToken, code)
+	require.ErrorIs(t, err, ErrMFAInvalidCode)
+
+	verification, err := svc.VerifyChallenge(ctx, challenge.MFAToken, recoveryCodes[0])
+	require.NoError(t, err)
+	require.Equal(t, agent.ID, verification.AgentID)
+
+	// Challenges and recovery codes are single use
+	_, err = svc.VerifyChallenge(ctx, challenge.MFAToken, recoveryCodes[1])
+	require.ErrorIs(t, err, ErrMFAChallengeExpired)
+
+	challenge, err = svc.BeginLoginChallenge(ctx, agent, nil)
+	require.NoError(t, err)
+	_, err = svc.VerifyChallenge(ctx, challenge.MFAToken, recoveryCodes[0])
+	require.ErrorIs(t, err, ErrMFAInvalidCode)
+
+	status, err := svc.GetStatus(ctx, agent.TenantID, agent.ID)
+	require.NoError(t, err)
+	require.True(t, status.Enabled)
+	require.Equal(t, mfaRecoveryCodeCount-1, status.RecoveryCodesRemaining)
+}
+
+func TestMFAServiceLocksOutAfterFailedAttempts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	agent := &db.Agent{ID: uuid.New(), TenantID: uuid.New(), Email: "agent@example.com", Status: "active"}
+	svc, _ := newTestMFAService(t, agent)
+
+	enrollment, err := svc.StartEnrollment(ctx, agent.TenantID, agent.ID)
+	require.NoError(t, err)
+	code, err := auth.GenerateTOTPCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	_, err = svc.ConfirmEnrollment(ctx, agent.TenantID, agent.ID, code)
+	require.NoError(t, err)
+
+	challenge, err := svc.BeginLoginChallenge(ctx, agent, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		_, err = svc.VerifyChallenge(ctx, challenge.MFAToken, "abcde-fghij")
+		require.ErrorIs(t, err, ErrMFAInvalidCode)
+	}
+
+	next, err := auth.GenerateTOTPCode(enrollment.Secret, time.Now().Add(auth.TOTPPeriod))
+	require.NoError(t, err)
+	_, err = svc.VerifyChallenge(ctx, challenge.MFAToken, next)
+	require.ErrorIs(t, err, ErrMFATooManyAttempts)
+
+	// The locked challenge is dropped, so a new password login is needed
+	_, err = svc.VerifyChallenge(ctx, challenge.MFAToken, next)
+	require.ErrorIs(t, err, ErrMFAChallengeExpired)
+}
+
+func TestMFAServiceTenantPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	agent := &db.Agent{ID: uuid.New(), TenantID: uuid.New(), Email: "admin@example.com", Status: "active"}
+	svc, mfaRepo := newTestMFAService(t, agent)
+
+	_, err := svc.SetMFAPolicy(ctx, agent.TenantID, "sometimes")
+	require.ErrorIs(t, err, ErrMFAInvalidPolicy)
+
+	_, err = svc.SetMFAPolicy(ctx, agent.TenantID, db.MFAPolicyAdmins)
+	require.NoError(t, err)
+
+	agentBindings := []*db.RoleBinding{{AgentID: agent.ID, TenantID: agent.TenantID, Role: models.RoleAgent}}
+	challenge, err := svc.BeginLoginChallenge(ctx, agent, agentBindings)
+	require.NoError(t, err)
+	require.Nil(t, challenge, "policy only covers admins")
+
+	adminBindings := []*db.RoleBinding{{AgentID: agent.ID, TenantID: agent.TenantID, Role: models.RoleTenantAdmin}}
+	challenge, err = svc.BeginLoginChallenge(ctx, agent, adminBindings)
+	require.NoError(t, err)
+	require.NotNil(t, challenge)
+	require.True(t, challenge.EnrollmentRequired)
+
+	// Enrollment happens inside the login challenge and completes the login
+	enrollment, err := svc.StartChallengeEnrollment(ctx, challenge.MFAToken)
+	require.NoError(t, err)
+	code, err := auth.GenerateTOTPCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	verification, err := svc.VerifyChallenge(ctx, challenge.MFAToken, code)
+	require.NoError(t, err)
+	require.Len(t, verification.RecoveryCodes, mfaRecoveryCodeCount)
+	require.True(t, mfaRepo.factors[agent.ID].Enabled)
+
+	// An admin reset removes the factor and the recovery codes
+	require.NoError(t, svc.ResetAgentMFA(ctx, agent.TenantID, agent.ID))
+	require.Nil(t, mfaRepo.factors[agent.ID])
+	require.Empty(t, mfaRepo.recoveryCodes[agent.ID])
+}
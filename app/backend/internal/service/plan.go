@@ -0,0 +1,429 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/google/uuid"
+)
+
+// ErrPlanLimitReached is returned when an action would exceed a limit of the tenant's billing plan
+var ErrPlanLimitReached = errors.New("plan limit reached")
+
+// ErrPlanNotFound is returned when the requested billing plan does not exist or is inactive
+var ErrPlanNotFound = errors.New("billing plan not found")
+
+// SystemNotifier delivers system notifications to agents
+type SystemNotifier interface {
+	CreateSystemNotification(ctx context.Context, tenantID uuid.UUID, projectID *uuid.UUID, agentID uuid.UUID,
+		notificationType models.NotificationType, title, message string,
+		priority models.NotificationPriority, actionURL *string) error
+}
+
+// PlanService handles billing plans, plan limits, monthly credit grants and usage statements
+type PlanService struct {
+	planRepo    repo.PlanRepository
+	creditsRepo repo.CreditsRepository
+	agentRepo   repo.AgentRepository
+	notifier    SystemNotifier
+}
+
+// NewPlanService creates a new plan service
+func NewPlanService(planRepo repo.PlanRepository, creditsRepo repo.CreditsRepository, agentRepo repo.AgentRepository) *PlanService {
+	return &PlanService{
+		planRepo:    planRepo,
+		creditsRepo: creditsRepo,
+		agentRepo:   agentRepo,
+	}
+}
+
+// SetNotifier sets the notifier used for low balance warnings
+func (s *PlanService) SetNotifier(notifier SystemNotifier) {
+	s.notifier = notifier
+}
+
+// PlanOverview combines a tenant's plan with its current usage and credit balance
+type PlanOverview struct {
+	Plan    *db.TenantPlan `json:"plan"`
+	Usage   *db.PlanUsage  `json:"usage"`
+	Balance int64          `json:"balance"`
+}
+
+// ListPlans returns all active billing plans
+func (s *PlanService) ListPlans(ctx context.Context) ([]*db.BillingPlan, error) {
+	return s.planRepo.ListPlans(ctx)
+}
+
+// GetTenantPlan returns the tenant's plan, or nil when the tenant has no plan assigned
+func (s *PlanService) GetTenantPlan(ctx context.Context, tenantID uuid.UUID) (*db.TenantPlan, error) {
+	if s == nil || s.planRepo == nil {
+		return nil, nil
+	}
+	return s.planRepo.GetTenantPlan(ctx, tenantID)
+}
+
+// GetPlanOverview returns the tenant's plan together with its usage and credit balance
+func (s *PlanService) GetPlanOverview(ctx context.Context, tenantID uuid.UUID) (*PlanOverview, error) {
+	tenantPlan, err := s.planRepo.GetTenantPlan(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant plan: %w", err)
+	}
+
+	usage, err := s.planRepo.GetUsage(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan usage: %w", err)
+	}
+
+	overview := &PlanOverview{Plan: tenantPlan, Usage: usage}
+
+	credits, err := s.creditsRepo.GetByTenantID(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credit balance: %w", err)
+	}
+	if credits != nil {
+		overview.Balance = credits.Balance
+	}
+
+	return overview, nil
+}
+
+// AssignPlan subscribes the tenant to the plan with the given code
+func (s *PlanService) AssignPlan(ctx context.Context, tenantID uuid.UUID, planCode string) (*db.TenantPlan, error) {
+	plan, err := s.planRepo.GetPlanByCode(ctx, planCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+	if plan == nil || !plan.IsActive {
+		return nil, ErrPlanNotFound
+	}
+
+	if err := s.planRepo.AssignPlan(ctx, tenantID, plan.ID); err != nil {
+		return nil, fmt.Errorf("failed to assign plan: %w", err)
+	}
+
+	return s.planRepo.GetTenantPlan(ctx, tenantID)
+}
+
+// CheckSeatLimit verifies that the tenant can add another agent
+func (s *PlanService) CheckSeatLimit(ctx context.Context, tenantID uuid.UUID) error {
+	return s.checkLimit(ctx, tenantID, "seat", 1,
+		func(p *db.BillingPlan) *int { return p.SeatLimit },
+		func(u *db.PlanUsage) int { return u.Seats })
+}
+
+// CheckProjectLimit verifies that the tenant can add another project
+func (s *PlanService) CheckProjectLimit(ctx context.Context, tenantID uuid.UUID) error {
+	return s.checkLimit(ctx, tenantID, "project", 1,
+		func(p *db.BillingPlan) *int { return p.ProjectLimit },
+		func(u *db.PlanUsage) int { return u.Projects })
+}
+
+// CheckWidgetLimit verifies that the tenant can add another chat widget
+func (s *PlanService) CheckWidgetLimit(ctx context.Context, tenantID uuid.UUID) error {
+	return s.checkLimit(ctx, tenantID, "widget", 1,
+		func(p *db.BillingPlan) *int { return p.WidgetLimit },
+		func(u *db.PlanUsage) int { return u.Widgets })
+}
+
+// CheckKnowledgePageLimit verifies that the tenant can index the given number of additional knowledge pages
+func (s *PlanService) CheckKnowledgePageLimit(ctx context.Context, tenantID uuid.UUID, additional int) error {
+	return s.checkLimit(ctx, tenantID, "knowledge page", additional,
+		func(p *db.BillingPlan) *int { return p.KnowledgePageLimit },
+		func(u *db.PlanUsage) int { return u.KnowledgePages })
+}
+
+// RemainingKnowledgePages returns how many more knowledge pages the tenant can index, or -1 when the
+// tenant is not restricted
+func (s *PlanService) RemainingKnowledgePages(ctx context.Context, tenantID uuid.UUID) (int, error) {
+	if s == nil || s.planRepo == nil {
+		return -1, nil
+	}
+
+	tenantPlan, err := s.planRepo.GetTenantPlan(ctx, tenantID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get tenant plan: %w", err)
+	}
+	if tenantPlan == nil || tenantPlan.Plan == nil || tenantPlan.Plan.KnowledgePageLimit == nil {
+		return -1, nil
+	}
+
+	usage, err := s.planRepo.GetUsage(ctx, tenantID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get plan usage: %w", err)
+	}
+
+	remaining := *tenantPlan.Plan.KnowledgePageLimit - usage.KnowledgePages
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, nil
+}
+
+// checkLimit is the shared implementation of the plan limit checks. Tenants without a plan
+// and plans without a limit for the resource are not restricted.
+func (s *PlanService) checkLimit(ctx context.Context, tenantID uuid.UUID, resource string, additional int,
+	limitOf func(*db.BillingPlan) *int, usedOf func(*db.PlanUsage) int) error {
+	if s == nil || s.planRepo == nil {
+		return nil
+	}
+
+	tenantPlan, err := s.planRepo.GetTenantPlan(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant plan: %w", err)
+	}
+	if tenantPlan == nil || tenantPlan.Plan == nil {
+		return nil
+	}
+
+	limit := limitOf(tenantPlan.Plan)
+	if limit == nil {
+		return nil
+	}
+
+	usage, err := s.planRepo.GetUsage(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to get plan usage: %w", err)
+	}
+
+	if usedOf(usage)+additional > *limit {
+		return fmt.Errorf("%w: the %s plan allows %d %s(s), currently using %d",
+			ErrPlanLimitReached, tenantPlan.Plan.Name, *limit, resource, usedOf(usage))
+	}
+
+	return nil
+}
+
+// planGrantEventID builds the idempotency key stored on monthly grant transactions
+func planGrantEventID(tenantID uuid.UUID, period string) string {
+	return fmt.Sprintf("plan_grant:%s:%s", tenantID, period)
+}
+
+// GrantMonthlyCredits adds the monthly AI credit allowance to every tenant that has not
+// received it for the current month yet. It returns the number of tenants credited.
+func (s *PlanService) GrantMonthlyCredits(ctx context.Context, now time.Time) (int, error) {
+	tenantPlans, err := s.planRepo.ListTenantPlans(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tenant plans: %w", err)
+	}
+
+	now = now.UTC()
+	period := now.Format("2006-01")
+	granted := 0
+
+	for _, tenantPlan := range tenantPlans {
+		if tenantPlan.Plan == nil || tenantPlan.Plan.MonthlyAICredits <= 0 {
+			continue
+		}
+		if tenantPlan.LastGrantAt != nil && tenantPlan.LastGrantAt.UTC().Format("2006-01") == period {
+			continue
+		}
+
+		eventID := planGrantEventID(tenantPlan.TenantID, period)
+		existing, err := s.creditsRepo.GetTransactionByPaymentEventID(ctx, eventID)
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to check monthly grant for tenant %s", tenantPlan.TenantID)
+			continue
+		}
+
+		if existing == nil {
+			description := fmt.Sprintf("Monthly %s plan AI credits for %s", tenantPlan.Plan.Name, period)
+			if _, err := s.creditsRepo.AddCredits(ctx, tenantPlan.TenantID, tenantPlan.Plan.MonthlyAICredits,
+				models.TransactionTypePlanGrant, "", eventID, description); err != nil {
+				logger.ErrorfCtx(ctx, err, "Failed to grant monthly credits to tenant %s", tenantPlan.TenantID)
+				continue
+			}
+			granted++
+		}
+
+		if err := s.planRepo.MarkCreditsGranted(ctx, tenantPlan.TenantID, now); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to record monthly grant for tenant %s", tenantPlan.TenantID)
+		}
+	}
+
+	return granted, nil
+}
+
+// RunMonthlyGrants grants monthly credits immediately and then on every interval until ctx is cancelled
+func (s *PlanService) RunMonthlyGrants(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		granted, err := s.GrantMonthlyCredits(ctx, time.Now())
+		if err != nil {
+			logger.ErrorfCtx(ctx, err, "Monthly credit grant run failed")
+		} else if granted > 0 {
+			logger.InfofCtx(ctx, "Granted monthly plan credits to %d tenant(s)", granted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckLowBalance warns tenant admins once per grant cycle when the balance drops to the plan threshold,
+// and once more when it is exhausted
+func (s *PlanService) CheckLowBalance(ctx context.Context, tenantID uuid.UUID, balance int64) {
+	if s == nil || s.planRepo == nil || s.notifier == nil {
+		return
+	}
+
+	tenantPlan, err := s.planRepo.GetTenantPlan(ctx, tenantID)
+	if err != nil {
+		logger.ErrorfCtx(ctx, err, "Failed to get tenant plan for low balance check")
+		return
+	}
+	if tenantPlan == nil || tenantPlan.Plan == nil {
+		return
+	}
+
+	var (
+		title, message string
+		priority       models.NotificationPriority
+		markNotified   func(ctx context.Context, tenantID uuid.UUID, notifiedAt time.Time) error
+	)
+	switch {
+	case balance <= 0 && tenantPlan.ExhaustedNotifiedAt == nil:
+		title = "AI credits exhausted"
+		message = "Your AI credit balance has reached zero. AI replies are paused and new chats are handed to human agents."
+		priority = models.NotificationPriorityUrgent
+		markNotified = s.planRepo.MarkExhaustedNotified
+	case balance > 0 && balance <= tenantPlan.Plan.LowBalanceThreshold && tenantPlan.LowBalanceNotifiedAt == nil:
+		title = "AI credits running low"
+		message = fmt.Sprintf("Your AI credit balance is %d. AI replies stop and chats are handed to human agents when it reaches zero.", balance)
+		priority = models.NotificationPriorityHigh
+		markNotified = s.planRepo.MarkLowBalanceNotified
+	default:
+		return
+	}
+
+	admins, err := s.agentRepo.GetTenantAdmins(ctx, tenantID)
+	if err != nil {
+		logger.ErrorfCtx(ctx, err, "Failed to get tenant admins for low balance warning")
+		return
+	}
+
+	for _, admin := range admins {
+		if err := s.notifier.CreateSystemNotification(ctx, tenantID, nil, admin.ID,
+			models.NotificationTypeLowCreditBalance, title, message, priority, nil); err != nil {
+			logger.ErrorfCtx(ctx, err, "Failed to send low balance notification to agent %s", admin.ID)
+		}
+	}
+
+	if err := markNotified(ctx, tenantID, time.Now()); err != nil {
+		logger.ErrorfCtx(ctx, err, "Failed to record low balance notification for tenant %s", tenantID)
+	}
+}
+
+// UsageStatement summarises a tenant's credit ledger for one calendar month
+type UsageStatement struct {
+	TenantID       uuid.UUID               `json:"tenant_id"`
+	Period         string                  `json:"period"`
+	PeriodStart    time.Time               `json:"period_start"`
+	PeriodEnd      time.Time               `json:"period_end"`
+	OpeningBalance int64                   `json:"opening_balance"`
+	ClosingBalance int64                   `json:"closing_balance"`
+	TotalCredited  int64                   `json:"total_credited"`
+	TotalDebited   int64                   `json:"total_debited"`
+	TotalsByType   map[string]int64        `json:"totals_by_type"`
+	Transactions   []*db.CreditTransaction `json:"transactions"`
+}
+
+// GenerateStatement builds the usage statement for the month identified by period (YYYY-MM)
+func (s *PlanService) GenerateStatement(ctx context.Context, tenantID uuid.UUID, period string) (*UsageStatement, error) {
+	start, err := time.ParseInLocation("2006-01", period, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statement period %q, expected YYYY-MM", period)
+	}
+	end := start.AddDate(0, 1, 0)
+
+	opening, err := s.creditsRepo.GetBalanceAt(ctx, tenantID, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get opening balance: %w", err)
+	}
+
+	transactions, err := s.creditsRepo.GetTransactionsInRange(ctx, tenantID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	statement := &UsageStatement{
+		TenantID:       tenantID,
+		Period:         period,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		OpeningBalance: opening,
+		ClosingBalance: opening,
+		TotalsByType:   make(map[string]int64),
+		Transactions:   transactions,
+	}
+
+	for _, tx := range transactions {
+		if tx.Amount > 0 {
+			statement.TotalCredited += tx.Amount
+		} else {
+			statement.TotalDebited += -tx.Amount
+		}
+		statement.TotalsByType[tx.TransactionType] += tx.Amount
+		statement.ClosingBalance = tx.BalanceAfter
+	}
+
+	if statement.Transactions == nil {
+		statement.Transactions = []*db.CreditTransaction{}
+	}
+
+	return statement, nil
+}
+
+// WriteCSV writes the statement transactions as CSV, followed by a summary row
+func (st *UsageStatement) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"id", "created_at", "transaction_type", "description", "amount", "balance_before", "balance_after"}); err != nil {
+		return err
+	}
+
+	for _, tx := range st.Transactions {
+		description := ""
+		if tx.Description != nil {
+			description = *tx.Description
+		}
+		record := []string{
+			strconv.FormatInt(tx.ID, 10),
+			tx.CreatedAt.UTC().Format(time.RFC3339),
+			tx.TransactionType,
+			description,
+			strconv.FormatInt(tx.Amount, 10),
+			strconv.FormatInt(tx.BalanceBefore, 10),
+			strconv.FormatInt(tx.BalanceAfter, 10),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	summary := []string{"", st.PeriodEnd.UTC().Format(time.RFC3339), "summary",
+		fmt.Sprintf("opening=%d credited=%d debited=%d", st.OpeningBalance, st.TotalCredited, st.TotalDebited),
+		strconv.FormatInt(st.TotalCredited-st.TotalDebited, 10),
+		strconv.FormatInt(st.OpeningBalance, 10),
+		strconv.FormatInt(st.ClosingBalance, 10),
+	}
+	if err := writer.Write(summary); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
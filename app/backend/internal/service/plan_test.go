@@ -0,0 +1,279 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPlanRepository struct {
+	repo.PlanRepository
+	tenantPlan     *db.TenantPlan
+	tenantPlans    []*db.TenantPlan
+	usage          *db.PlanUsage
+	grantedAt      map[uuid.UUID]time.Time
+	notifiedCalls  int
+	exhaustedCalls int
+}
+
+func (m *mockPlanRepository) GetTenantPlan(ctx context.Context, tenantID uuid.UUID) (*db.TenantPlan, error) {
+	return m.tenantPlan, nil
+}
+
+func (m *mockPlanRepository) ListTenantPlans(ctx context.Context) ([]*db.TenantPlan, error) {
+	return m.tenantPlans, nil
+}
+
+func (m *mockPlanRepository) GetUsage(ctx context.Context, tenantID uuid.UUID) (*db.PlanUsage, error) {
+	return m.usage, nil
+}
+
+func (m *mockPlanRepository) MarkCreditsGranted(ctx context.Context, tenantID uuid.UUID, grantedAt time.Time) error {
+	if m.grantedAt == nil {
+		m.grantedAt = make(map[uuid.UUID]time.Time)
+	}
+	m.grantedAt[tenantID] = grantedAt
+	return nil
+}
+
+func (m *mockPlanRepository) MarkLowBalanceNotified(ctx context.Context, tenantID uuid.UUID, notifiedAt time.Time) error {
+	m.notifiedCalls++
+	if m.tenantPlan != nil {
+		m.tenantPlan.LowBalanceNotifiedAt = &notifiedAt
+	}
+	return nil
+}
+
+func (m *mockPlanRepository) MarkExhaustedNotified(ctx context.Context, tenantID uuid.UUID, notifiedAt time.Time) error {
+	m.exhaustedCalls++
+	if m.tenantPlan != nil {
+		m.tenantPlan.ExhaustedNotifiedAt = &notifiedAt
+	}
+	return nil
+}
+
+type mockLedgerRepository struct {
+	repo.CreditsRepository
+	existingEvents map[string]bool
+	added          map[uuid.UUID]int64
+	transactions   []*db.CreditTransaction
+	openingBalance int64
+}
+
+func (m *mockLedgerRepository) GetTransactionByPaymentEventID(ctx context.Context, paymentEventID string) (*db.CreditTransaction, error) {
+	if m.existingEvents[paymentEventID] {
+		return &db.CreditTransaction{ID: 1}, nil
+	}
+	return nil, nil
+}
+
+func (m *mockLedgerRepository) AddCredits(ctx context.Context, tenantID uuid.UUID, amount int64, transactionType, paymentGateway, paymentEventID, description string) (*db.CreditTransaction, error) {
+	if m.added == nil {
+		m.added = make(map[uuid.UUID]int64)
+	}
+	m.added[tenantID] += amount
+	if m.existingEvents == nil {
+		m.existingEvents = make(map[string]bool)
+	}
+	m.existingEvents[paymentEventID] = true
+	return &db.CreditTransaction{TenantID: tenantID, Amount: amount, TransactionType: transactionType}, nil
+}
+
+func (m *mockLedgerRepository) GetTransactionsInRange(ctx context.Context, tenantID uuid.UUID, from, to time.Time) ([]*db.CreditTransaction, error) {
+	return m.transactions, nil
+}
+
+func (m *mockLedgerRepository) GetBalanceAt(ctx context.Context, tenantID uuid.UUID, at time.Time) (int64, error) {
+	return m.openingBalance, nil
+}
+
+type mockTenantAdminRepository struct {
+	repo.AgentRepository
+	admins []*db.Agent
+}
+
+func (m *mockTenantAdminRepository) GetTenantAdmins(ctx context.Context, tenantID uuid.UUID) ([]*db.Agent, error) {
+	return m.admins, nil
+}
+
+type mockSystemNotifier struct {
+	notified []uuid.UUID
+	priority models.NotificationPriority
+}
+
+func (m *mockSystemNotifier) CreateSystemNotification(ctx context.Context, tenantID uuid.UUID, projectID *uuid.UUID, agentID uuid.UUID,
+	notificationType models.NotificationType, title, message string,
+	priority models.NotificationPriority, actionURL *string) error {
+	m.notified = append(m.notified, agentID)
+	m.priority = priority
+	return nil
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestPlanServiceCheckLimits(t *testing.T) {
+	t.Parallel()
+
+	tenantID := uuid.New()
+
+	t.Run("tenant without plan is unrestricted", func(t *testing.T) {
+		svc := NewPlanService(&mockPlanRepository{}, nil, nil)
+		require.NoError(t, svc.CheckSeatLimit(context.Background(), tenantID))
+	})
+
+	t.Run("nil service is unrestricted", func(t *testing.T) {
+		var svc *PlanService
+		require.NoError(t, svc.CheckWidgetLimit(context.Background(), tenantID))
+	})
+
+	t.Run("limit reached", func(t *testing.T) {
+		planRepo := &mockPlanRepository{
+			tenantPlan: &db.TenantPlan{TenantID: tenantID, Plan: &db.BillingPlan{Name: "Starter", SeatLimit: intPtr(5), WidgetLimit: intPtr(3)}},
+			usage:      &db.PlanUsage{Seats: 5, Widgets: 2},
+		}
+		svc := NewPlanService(planRepo, nil, nil)
+
+		require.ErrorIs(t, svc.CheckSeatLimit(context.Background(), tenantID), ErrPlanLimitReached)
+		require.NoError(t, svc.CheckWidgetLimit(context.Background(), tenantID))
+	})
+
+	t.Run("knowledge pages count the whole batch", func(t *testing.T) {
+		planRepo := &mockPlanRepository{
+			tenantPlan: &db.TenantPlan{TenantID: tenantID, Plan: &db.BillingPlan{Name: "Free", KnowledgePageLimit: intPtr(50)}},
+			usage:      &db.PlanUsage{KnowledgePages: 45},
+		}
+		svc := NewPlanService(planRepo, nil, nil)
+
+		require.NoError(t, svc.CheckKnowledgePageLimit(context.Background(), tenantID, 5))
+		require.ErrorIs(t, svc.CheckKnowledgePageLimit(context.Background(), tenantID, 6), ErrPlanLimitReached)
+	})
+
+	t.Run("nil limit is unlimited", func(t *testing.T) {
+		planRepo := &mockPlanRepository{
+			tenantPlan: &db.TenantPlan{TenantID: tenantID, Plan: &db.BillingPlan{Name: "Enterprise"}},
+			usage:      &db.PlanUsage{Projects: 500},
+		}
+		svc := NewPlanService(planRepo, nil, nil)
+
+		require.NoError(t, svc.CheckProjectLimit(context.Background(), tenantID))
+	})
+}
+
+func TestPlanServiceGrantMonthlyCredits(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, time.March, 1, 0, 30, 0, 0, time.UTC)
+	lastMonth := now.AddDate(0, -1, 0)
+
+	dueTenant := uuid.New()
+	grantedTenant := uuid.New()
+	freeTenant := uuid.New()
+	recordedTenant := uuid.New()
+
+	plan := &db.BillingPlan{Name: "Starter", MonthlyAICredits: 1000}
+	planRepo := &mockPlanRepository{
+		tenantPlans: []*db.TenantPlan{
+			{TenantID: dueTenant, LastGrantAt: &lastMonth, Plan: plan},
+			{TenantID: grantedTenant, LastGrantAt: &now, Plan: plan},
+			{TenantID: freeTenant, Plan: &db.BillingPlan{Name: "Custom"}},
+			{TenantID: recordedTenant, Plan: plan},
+		},
+	}
+	ledger := &mockLedgerRepository{
+		existingEvents: map[string]bool{planGrantEventID(recordedTenant, "2025-03"): true},
+	}
+
+	svc := NewPlanService(planRepo, ledger, nil)
+
+	granted, err := svc.GrantMonthlyCredits(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 1, granted)
+	require.Equal(t, map[uuid.UUID]int64{dueTenant: 1000}, ledger.added)
+	require.Contains(t, planRepo.grantedAt, recordedTenant)
+
+	// A second run in the same month must not grant again
+	planRepo.tenantPlans[0].LastGrantAt = &now
+	granted, err = svc.GrantMonthlyCredits(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 0, granted)
+	require.Equal(t, int64(1000), ledger.added[dueTenant])
+}
+
+func TestPlanServiceCheckLowBalanceNotifiesOnce(t *testing.T) {
+	t.Parallel()
+
+	tenantID := uuid.New()
+	admins := []*db.Agent{{ID: uuid.New()}, {ID: uuid.New()}}
+	planRepo := &mockPlanRepository{
+		tenantPlan: &db.TenantPlan{TenantID: tenantID, Plan: &db.BillingPlan{LowBalanceThreshold: 100}},
+	}
+	notifier := &mockSystemNotifier{}
+
+	svc := NewPlanService(planRepo, nil, &mockTenantAdminRepository{admins: admins})
+	svc.SetNotifier(notifier)
+
+	svc.CheckLowBalance(context.Background(), tenantID, 500)
+	require.Empty(t, notifier.notified)
+
+	svc.CheckLowBalance(context.Background(), tenantID, 80)
+	require.Len(t, notifier.notified, 2)
+	require.Equal(t, models.NotificationPriorityHigh, notifier.priority)
+	require.Equal(t, 1, planRepo.notifiedCalls)
+
+	svc.CheckLowBalance(context.Background(), tenantID, 50)
+	require.Len(t, notifier.notified, 2)
+
+	// Running out is announced even though the low balance warning was already sent
+	svc.CheckLowBalance(context.Background(), tenantID, 0)
+	require.Len(t, notifier.notified, 4)
+	require.Equal(t, models.NotificationPriorityUrgent, notifier.priority)
+	require.Equal(t, 1, planRepo.exhaustedCalls)
+
+	svc.CheckLowBalance(context.Background(), tenantID, -10)
+	require.Len(t, notifier.notified, 4)
+}
+
+func TestPlanServiceGenerateStatement(t *testing.T) {
+	t.Parallel()
+
+	tenantID := uuid.New()
+	description := "AI usage"
+	ledger := &mockLedgerRepository{
+		openingBalance: 200,
+		transactions: []*db.CreditTransaction{
+			{ID: 1, Amount: 1000, TransactionType: models.TransactionTypePlanGrant, BalanceBefore: 200, BalanceAfter: 1200, CreatedAt: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+			{ID: 2, Amount: -150, TransactionType: models.TransactionTypeAIUsage, Description: &description, BalanceBefore: 1200, BalanceAfter: 1050, CreatedAt: time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)},
+			{ID: 3, Amount: -50, TransactionType: models.TransactionTypeAIUsage, BalanceBefore: 1050, BalanceAfter: 1000, CreatedAt: time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	svc := NewPlanService(&mockPlanRepository{}, ledger, nil)
+
+	statement, err := svc.GenerateStatement(context.Background(), tenantID, "2025-03")
+	require.NoError(t, err)
+	require.Equal(t, int64(200), statement.OpeningBalance)
+	require.Equal(t, int64(1000), statement.ClosingBalance)
+	require.Equal(t, int64(1000), statement.TotalCredited)
+	require.Equal(t, int64(200), statement.TotalDebited)
+	require.Equal(t, int64(-200), statement.TotalsByType[models.TransactionTypeAIUsage])
+	require.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), statement.PeriodEnd)
+
+	var buf bytes.Buffer
+	require.NoError(t, statement.WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Equal(t, "2,2025-03-02T00:00:00Z,ai_usage,AI usage,-150,1200,1050", lines[2])
+
+	_, err = svc.GenerateStatement(context.Background(), tenantID, "March")
+	require.Error(t, err)
+}
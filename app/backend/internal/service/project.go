@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"fmt"
@@ -13,17 +14,22 @@ import (
 
 type ProjectService struct {
 	projectRepo repo.ProjectRepository
+	planService *PlanService
 }
 
-func NewProjectService(projectRepo repo.ProjectRepository) *ProjectService {
+func NewProjectService(projectRepo repo.ProjectRepository, planService *PlanService) *ProjectService {
 	return &ProjectService{
 		projectRepo: projectRepo,
+		planService: planService,
 	}
 }
 
 // ErrProjectLimitReached is returned when tenant has reached maximum allowed projects
 var ErrProjectLimitReached = fmt.Errorf("tenant project limit reached")
 
+// defaultProjectLimit applies to tenants without a billing plan
+const defaultProjectLimit = 5
+
 func (s *ProjectService) GetProject(ctx context.Context, tenantID, projectID uuid.UUID) (*db.Project, error) {
 	return s.projectRepo.GetByID(ctx, tenantID, projectID)
 }
@@ -41,13 +47,26 @@ func (s *ProjectService) ListProjectsForAgent(ctx context.Context, tenantID, age
 }
 
 func (s *ProjectService) CreateProject(ctx context.Context, tenantID uuid.UUID, key, name string) (*db.Project, error) {
-	// Enforce a maximum of 5 projects per tenant
-	count, err := s.projectRepo.Count(ctx, tenantID)
+	// Tenants on a billing plan are limited by the plan, everyone else by the default limit
+	tenantPlan, err := s.planService.GetTenantPlan(ctx, tenantID)
 	if err != nil {
 		return nil, err
 	}
-	if count >= 5 {
-		return nil, ErrProjectLimitReached
+	if tenantPlan != nil {
+		if err := s.planService.CheckProjectLimit(ctx, tenantID); err != nil {
+			if errors.Is(err, ErrPlanLimitReached) {
+				return nil, ErrProjectLimitReached
+			}
+			return nil, err
+		}
+	} else {
+		count, err := s.projectRepo.Count(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		if count >= defaultProjectLimit {
+			return nil, ErrProjectLimitReached
+		}
 	}
 	now := time.Now()
 	project := &db.Project{
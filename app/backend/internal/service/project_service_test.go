@@ -66,7 +66,7 @@ func TestProjectService_CreateProject_LimitReached(t *testing.T) {
 	t.Parallel()
 
 	repo := &mockProjectRepo{countResult: 5}
-	svc := NewProjectService(repo)
+	svc := NewProjectService(repo, nil)
 
 	project, err := svc.CreateProject(context.Background(), uuid.New(), "KEY", "Name")
 
@@ -79,7 +79,7 @@ func TestProjectService_CreateProject_Success(t *testing.T) {
 	t.Parallel()
 
 	repo := &mockProjectRepo{countResult: 2}
-	svc := NewProjectService(repo)
+	svc := NewProjectService(repo, nil)
 
 	tenantID := uuid.New()
 	project, err := svc.CreateProject(context.Background(), tenantID, "SUP", "Support")
@@ -101,7 +101,7 @@ func TestProjectService_UpdateProject_NotFound(t *testing.T) {
 	t.Parallel()
 
 	repo := &mockProjectRepo{}
-	svc := NewProjectService(repo)
+	svc := NewProjectService(repo, nil)
 
 	updated, err := svc.UpdateProject(context.Background(), uuid.New(), uuid.New(), "K", "Name", "archived")
 
@@ -121,7 +121,7 @@ func TestProjectService_UpdateProject_Success(t *testing.T) {
 	}
 
 	repo := &mockProjectRepo{getByIDResult: original}
-	svc := NewProjectService(repo)
+	svc := NewProjectService(repo, nil)
 
 	newKey := "NEW"
 	newName := "New name"
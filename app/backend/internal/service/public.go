@@ -17,6 +17,7 @@ type PublicService struct {
 	messageRepo    repo.TicketMessageRepository
 	jwtAuth        *auth.Service
 	messageService *MessageService
+	redactor       MessageRedactor
 }
 
 // NewPublicService creates a new public service
@@ -34,6 +35,11 @@ func NewPublicService(
 	}
 }
 
+// SetMessageRedactor sets the redactor that masks personal data in customer replies before they are stored
+func (s *PublicService) SetMessageRedactor(redactor MessageRedactor) {
+	s.redactor = redactor
+}
+
 // AddPublicMessageRequest represents a request to add a public message to a ticket
 type AddPublicMessageRequest struct {
 	Body string `json:"body" validate:"required"`
@@ -156,6 +162,9 @@ func (s *PublicService) AddMessageByMagicLink(ctx context.Context, magicToken st
 		IsPrivate:  false, // Customer messages are always public
 		CreatedAt:  time.Now(),
 	}
+	if s.redactor != nil {
+		message.Body = s.redactor.RedactStoredMessage(ctx, ticket.TenantID, ticket.ProjectID, nil, &ticketID, message.Body)
+	}
 
 	err = s.messageRepo.Create(ctx, message)
 	if err != nil {
@@ -235,6 +244,9 @@ func (s *PublicService) AddMessageByID(ctx context.Context, ticketID uuid.UUID,
 		IsPrivate:  false, // Customer messages are always public
 		CreatedAt:  time.Now(),
 	}
+	if s.redactor != nil {
+		message.Body = s.redactor.RedactStoredMessage(ctx, ticket.TenantID, ticket.ProjectID, nil, &ticketID, message.Body)
+	}
 
 	err = s.messageRepo.Create(ctx, message)
 	if err != nil {
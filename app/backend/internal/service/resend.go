@@ -157,3 +157,51 @@ func (s *ResendService) SendTicketUpdatedNotification(ctx context.Context, ticke
 
 	return nil
 }
+
+// SendChatTranscriptEmail sends the transcript of an ended chat session
+func (s *ResendService) SendChatTranscriptEmail(ctx context.Context, transcript *ChatTranscript, toEmail, recipientName string) error {
+	if s.environment == "development" {
+		fmt.Printf("Development mode: Would send chat transcript to %s\n", toEmail)
+		return nil
+	}
+
+	subject, htmlBody, textBody := buildChatTranscriptEmail(transcript, recipientName, toEmail)
+	params := &resend.SendEmailRequest{
+		From:    s.senderAddress("", ""),
+		To:      []string{toEmail},
+		Subject: subject,
+		Html:    htmlBody,
+		Text:    textBody,
+	}
+
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		return fmt.Errorf("failed to send chat transcript via Resend: %w", err)
+	}
+
+	return nil
+}
+
+// SendChatFollowUpEmail sends the visitor a link to the ticket their chat was converted to
+func (s *ResendService) SendChatFollowUpEmail(ctx context.Context, ticket *db.Ticket, link, toEmail, recipientName string) error {
+	if s.environment == "development" {
+		fmt.Printf("Development mode: Would send chat follow-up for ticket #%d to %s\n", ticket.Number, toEmail)
+		return nil
+	}
+
+	subject, htmlBody, textBody := buildChatFollowUpEmail(ticket, link, recipientName, toEmail)
+	params := &resend.SendEmailRequest{
+		From:    s.senderAddress("", ""),
+		To:      []string{toEmail},
+		Subject: subject,
+		Html:    htmlBody,
+		Text:    textBody,
+	}
+
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		return fmt.Errorf("failed to send chat follow-up via Resend: %w", err)
+	}
+
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -44,11 +46,12 @@ func NewSlackService(
 
 // SlackMessageRequest represents a Slack API message request
 type SlackMessageRequest struct {
-	Channel   string `json:"channel"`
-	Text      string `json:"text"`
-	ThreadTS  string `json:"thread_ts,omitempty"`
-	Username  string `json:"username,omitempty"`
-	IconEmoji string `json:"icon_emoji,omitempty"`
+	Channel   string       `json:"channel"`
+	Text      string       `json:"text"`
+	Blocks    []SlackBlock `json:"blocks,omitempty"`
+	ThreadTS  string       `json:"thread_ts,omitempty"`
+	Username  string       `json:"username,omitempty"`
+	IconEmoji string       `json:"icon_emoji,omitempty"`
 }
 
 // SlackMessageResponse represents a Slack API message response
@@ -71,6 +74,7 @@ type SlackUserInfo struct {
 		Profile  struct {
 			DisplayName string `json:"display_name"`
 			RealName    string `json:"real_name"`
+			Email       string `json:"email"` // requires the users:read.email scope
 		} `json:"profile"`
 	} `json:"user"`
 }
@@ -148,6 +152,11 @@ func (s *SlackService) PostMessageToSlack(
 		ThreadTS: threadTS,
 	}
 
+	// The first post starts the thread and carries the triage buttons
+	if slackThreadMeta == nil {
+		reqBody.Blocks = SessionActionBlocks(text, session.ID)
+	}
+
 	// Call Slack API using access token
 	resp, err := s.postMessageToSlackAPI(ctx, slackMeta.AccessToken, &reqBody)
 	if err != nil {
@@ -302,3 +311,116 @@ func (s *SlackService) GetUserDisplayName(ctx context.Context, token, userID str
 func slackUserCacheKey(userID string) string {
 	return fmt.Sprintf("slack:user:%s:display_name", userID)
 }
+
+// GetUserEmail fetches a Slack user's email address with Redis caching
+func (s *SlackService) GetUserEmail(ctx context.Context, token, userID string) (string, error) {
+	cacheKey := slackUserEmailCacheKey(userID)
+	cachedEmail, err := s.redisService.GetClient().Get(ctx, cacheKey).Result()
+	if err == nil && cachedEmail != "" {
+		return cachedEmail, nil
+	}
+	if err != nil && err != redis.Nil {
+		logger.GetTxLogger(ctx).Warn().Err(err).Msg("Failed to get Slack user email from cache, fetching from API")
+	}
+
+	userInfo, err := s.fetchUserInfo(ctx, token, userID)
+	if err != nil {
+		return "", err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(userInfo.User.Profile.Email))
+	if email == "" {
+		return "", fmt.Errorf("Slack user %s has no visible email address", userID)
+	}
+
+	if err := s.redisService.GetClient().Set(ctx, cacheKey, email, 24*time.Hour).Err(); err != nil {
+		logger.GetTxLogger(ctx).Warn().Err(err).Msg("Failed to cache Slack user email")
+	}
+
+	return email, nil
+}
+
+// fetchUserInfo calls the Slack users.info API
+func (s *SlackService) fetchUserInfo(ctx context.Context, token, userID string) (*SlackUserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://slack.com/api/users.info?user="+url.QueryEscape(userID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Slack API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var userInfo SlackUserInfo
+	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
+		return nil, err
+	}
+	if !userInfo.OK {
+		return nil, fmt.Errorf("failed to get Slack user info")
+	}
+
+	return &userInfo, nil
+}
+
+// FindIntegrationByTeam resolves the project a Slack workspace callback belongs to. When a workspace
+// is connected to several projects the one posting to the given channel wins, then the oldest install.
+func (s *SlackService) FindIntegrationByTeam(ctx context.Context, teamID, channelID string) (*models.ProjectIntegration, *models.SlackIntegrationMeta, error) {
+	integrations, err := s.integrationRepo.ListActiveBySlackTeam(ctx, teamID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get Slack integrations: %w", err)
+	}
+
+	var (
+		fallback     *models.ProjectIntegration
+		fallbackMeta *models.SlackIntegrationMeta
+	)
+	for _, integration := range integrations {
+		slackMeta, err := models.SlackMetaFromIntegration(integration.Meta)
+		if err != nil {
+			continue
+		}
+		for _, webhook := range slackMeta.Webhooks {
+			if channelID != "" && webhook.ChannelID == channelID {
+				return integration, slackMeta, nil
+			}
+		}
+		if fallback == nil {
+			fallback, fallbackMeta = integration, slackMeta
+		}
+	}
+
+	return fallback, fallbackMeta, nil
+}
+
+// RespondToURL posts a message to an interaction or slash command response_url
+func (s *SlackService) RespondToURL(ctx context.Context, responseURL string, msg *SlackResponseMessage) error {
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", responseURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Slack response_url request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Slack response_url returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// slackUserEmailCacheKey generates a Redis cache key for Slack user email addresses
+func slackUserEmailCacheKey(userID string) string {
+	return fmt.Sprintf("slack:user:%s:email", userID)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"github.com/google/uuid"
+)
+
+// Block Kit action IDs of the buttons attached to chat session posts
+const (
+	SlackActionClaim    = "tms_session_claim"
+	SlackActionEscalate = "tms_session_escalate"
+	SlackActionClose    = "tms_session_close"
+	SlackActionConvert  = "tms_session_convert"
+)
+
+// SlackText is a Block Kit text object
+type SlackText struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// SlackConfirmDialog is a Block Kit confirmation dialog shown before an action runs
+type SlackConfirmDialog struct {
+	Title   *SlackText `json:"title"`
+	Text    *SlackText `json:"text"`
+	Confirm *SlackText `json:"confirm"`
+	Deny    *SlackText `json:"deny"`
+}
+
+// SlackBlockElement is an interactive Block Kit element (only buttons are used)
+type SlackBlockElement struct {
+	Type     string              `json:"type"`
+	Text     *SlackText          `json:"text,omitempty"`
+	ActionID string              `json:"action_id,omitempty"`
+	Value    string              `json:"value,omitempty"`
+	Style    string              `json:"style,omitempty"`
+	Confirm  *SlackConfirmDialog `json:"confirm,omitempty"`
+}
+
+// SlackBlock is a Block Kit layout block
+type SlackBlock struct {
+	Type     string              `json:"type"`
+	BlockID  string              `json:"block_id,omitempty"`
+	Text     *SlackText          `json:"text,omitempty"`
+	Elements []SlackBlockElement `json:"elements,omitempty"`
+}
+
+// SlackResponseMessage is posted to an interaction or slash command response_url
+type SlackResponseMessage struct {
+	ResponseType    string       `json:"response_type,omitempty"` // "ephemeral" or "in_channel"
+	Text            string       `json:"text"`
+	Blocks          []SlackBlock `json:"blocks,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original,omitempty"`
+}
+
+// SessionActionBlocks renders a chat session post with the triage buttons agents use from Slack
+func SessionActionBlocks(text string, sessionID uuid.UUID) []SlackBlock {
+	value := sessionID.String()
+
+	return []SlackBlock{
+		{
+			Type: "section",
+			Text: &SlackText{Type: "mrkdwn", Text: text},
+		},
+		{
+			Type:    "actions",
+			BlockID: "tms_session_actions",
+			Elements: []SlackBlockElement{
+				slackButton("Claim", SlackActionClaim, value, "primary", nil),
+				slackButton("Escalate", SlackActionEscalate, value, "", nil),
+				slackButton("Convert to ticket", SlackActionConvert, value, "", nil),
+				slackButton("Close", SlackActionClose, value, "danger", &SlackConfirmDialog{
+					Title:   &SlackText{Type: "plain_text", Text: "Close chat?"},
+					Text:    &SlackText{Type: "mrkdwn", Text: "The visitor will no longer be able to reply in this chat."},
+					Confirm: &SlackText{Type: "plain_text", Text: "Close"},
+					Deny:    &SlackText{Type: "plain_text", Text: "Cancel"},
+				}),
+			},
+		},
+	}
+}
+
+func slackButton(label, actionID, value, style string, confirm *SlackConfirmDialog) SlackBlockElement {
+	return SlackBlockElement{
+		Type:     "button",
+		Text:     &SlackText{Type: "plain_text", Text: label, Emoji: true},
+		ActionID: actionID,
+		Value:    value,
+		Style:    style,
+		Confirm:  confirm,
+	}
+}
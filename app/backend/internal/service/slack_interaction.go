@@ -0,0 +1,411 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/bareuptime/tms/internal/repo"
+)
+
+const (
+	slackSearchMaxResults  = 3
+	slackStatusMaxSessions = 10
+	slackSnippetLength     = 280
+)
+
+var (
+	ErrSlackIntegrationNotFound = errors.New("this Slack workspace is not connected to a project")
+	ErrSlackAgentNotLinked      = errors.New("your Slack account is not linked to an agent; ask an admin to invite the email address of your Slack profile")
+	ErrSlackPermissionDenied    = errors.New("you do not have permission to do that in this project")
+	ErrSlackSessionNotFound     = errors.New("chat session not found")
+	ErrSlackUnknownAction       = errors.New("unknown Slack action")
+	ErrSlackChatWithoutEmail    = errors.New("the visitor has not shared an email address, so the chat cannot become a ticket")
+)
+
+// SlackInteractionPayload is the block_actions payload Slack posts to the interactivity endpoint
+type SlackInteractionPayload struct {
+	Type        string `json:"type"`
+	ResponseURL string `json:"response_url"`
+	Team        struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Message struct {
+		TS       string `json:"ts"`
+		ThreadTS string `json:"thread_ts,omitempty"`
+	} `json:"message"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		BlockID  string `json:"block_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// SlackSlashCommand is the form Slack posts when someone runs the /tms command
+type SlackSlashCommand struct {
+	TeamID      string `form:"team_id"`
+	ChannelID   string `form:"channel_id"`
+	UserID      string `form:"user_id"`
+	Command     string `form:"command"`
+	Text        string `form:"text"`
+	ResponseURL string `form:"response_url"`
+}
+
+// SlackCommand is a parsed /tms sub-command
+type SlackCommand struct {
+	Name string
+	Args string
+}
+
+// ParseSlackCommand splits the text of a slash command into its sub-command and arguments
+func ParseSlackCommand(text string) SlackCommand {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return SlackCommand{Name: "help"}
+	}
+	return SlackCommand{
+		Name: strings.ToLower(fields[0]),
+		Args: strings.Join(fields[1:], " "),
+	}
+}
+
+// SlackInteractionService lets agents triage chats from Slack: session buttons and the /tms command
+type SlackInteractionService struct {
+	slackService       *SlackService
+	chatSessionService *ChatSessionService
+	chatSessionRepo    *repo.ChatSessionRepo
+	chatTicketService  *ChatTicketService
+	ticketRepo         repo.TicketRepository
+	agentRepo          repo.AgentRepository
+	knowledgeService   *KnowledgeService
+	rbacService        *rbac.Service
+}
+
+// NewSlackInteractionService creates a new Slack interaction service
+func NewSlackInteractionService(
+	slackService *SlackService,
+	chatSessionService *ChatSessionService,
+	chatSessionRepo *repo.ChatSessionRepo,
+	chatTicketService *ChatTicketService,
+	ticketRepo repo.TicketRepository,
+	agentRepo repo.AgentRepository,
+	knowledgeService *KnowledgeService,
+	rbacService *rbac.Service,
+) *SlackInteractionService {
+	return &SlackInteractionService{
+		slackService:       slackService,
+		chatSessionService: chatSessionService,
+		chatSessionRepo:    chatSessionRepo,
+		chatTicketService:  chatTicketService,
+		ticketRepo:         ticketRepo,
+		agentRepo:          agentRepo,
+		knowledgeService:   knowledgeService,
+		rbacService:        rbacService,
+	}
+}
+
+// HandleBlockAction runs a session button (claim, escalate, close, convert) and posts the outcome to the thread
+func (s *SlackInteractionService) HandleBlockAction(ctx context.Context, payload *SlackInteractionPayload) error {
+	if len(payload.Actions) == 0 {
+		return ErrSlackUnknownAction
+	}
+	action := payload.Actions[0]
+
+	sessionID, err := uuid.Parse(action.Value)
+	if err != nil {
+		return ErrSlackUnknownAction
+	}
+
+	// Look the session up by the thread the button lives in rather than trusting the button value alone
+	threadTS := payload.Message.ThreadTS
+	if threadTS == "" {
+		threadTS = payload.Message.TS
+	}
+	session, err := s.chatSessionRepo.GetChatSessionBySlackThread(ctx, threadTS, payload.Channel.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat session: %w", err)
+	}
+	if session == nil || session.ID != sessionID {
+		return ErrSlackSessionNotFound
+	}
+
+	slackMeta, err := s.slackService.GetSlackIntegration(ctx, session.TenantID, session.ProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to get Slack integration: %w", err)
+	}
+	if slackMeta == nil || slackMeta.TeamID != payload.Team.ID {
+		return ErrSlackIntegrationNotFound
+	}
+
+	agent, err := s.resolveAgent(ctx, slackMeta, session.TenantID, session.ProjectID, payload.User.ID, rbac.PermChatWrite)
+	if err != nil {
+		return err
+	}
+
+	var note string
+	switch action.ActionID {
+	case SlackActionClaim:
+		note, err = s.claimSession(ctx, session, agent)
+	case SlackActionEscalate:
+		note, err = s.escalateSession(ctx, session, agent)
+	case SlackActionClose:
+		note, err = s.closeSession(ctx, session, agent)
+	case SlackActionConvert:
+		note, err = s.convertSession(ctx, session, agent)
+	default:
+		return ErrSlackUnknownAction
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.slackService.PostMessageToSlack(ctx, session.TenantID, session.ProjectID, session, note, ""); err != nil {
+		logger.GetTxLogger(ctx).Warn().Err(err).Str("session_id", session.ID.String()).Msg("Failed to post Slack action result to thread")
+	}
+	return nil
+}
+
+// HandleSlashCommand runs a /tms sub-command and returns the ephemeral reply for the caller
+func (s *SlackInteractionService) HandleSlashCommand(ctx context.Context, cmd *SlackSlashCommand) (*SlackResponseMessage, error) {
+	command := ParseSlackCommand(cmd.Text)
+	if command.Name == "help" {
+		return slackEphemeral(slackCommandUsage(cmd.Command)), nil
+	}
+
+	integration, slackMeta, err := s.slackService.FindIntegrationByTeam(ctx, cmd.TeamID, cmd.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if integration == nil {
+		return nil, ErrSlackIntegrationNotFound
+	}
+	tenantID, projectID := integration.TenantID, integration.ProjectID
+
+	switch command.Name {
+	case "ticket":
+		number, err := strconv.Atoi(strings.TrimPrefix(command.Args, "#"))
+		if err != nil || number <= 0 {
+			return slackEphemeral(fmt.Sprintf("Usage: `%s ticket 1234`", cmd.Command)), nil
+		}
+		if _, err := s.resolveAgent(ctx, slackMeta, tenantID, projectID, cmd.UserID, rbac.PermTicketRead); err != nil {
+			return nil, err
+		}
+		return s.ticketSummary(ctx, tenantID, projectID, number), nil
+
+	case "search":
+		if command.Args == "" {
+			return slackEphemeral(fmt.Sprintf("Usage: `%s search <query>`", cmd.Command)), nil
+		}
+		if _, err := s.resolveAgent(ctx, slackMeta, tenantID, projectID, cmd.UserID, rbac.PermKnowledgeRead); err != nil {
+			return nil, err
+		}
+		return s.searchKnowledge(ctx, tenantID, projectID, command.Args)
+
+	case "status":
+		if _, err := s.resolveAgent(ctx, slackMeta, tenantID, projectID, cmd.UserID, rbac.PermChatRead); err != nil {
+			return nil, err
+		}
+		return s.chatStatus(ctx, tenantID, projectID)
+	}
+
+	return slackEphemeral(fmt.Sprintf("Unknown command `%s`.\n%s", command.Name, slackCommandUsage(cmd.Command))), nil
+}
+
+// resolveAgent maps a Slack user to the TMS agent with the same email and checks a project permission
+func (s *SlackInteractionService) resolveAgent(ctx context.Context, slackMeta *models.SlackIntegrationMeta, tenantID, projectID uuid.UUID, slackUserID string, permission rbac.Permission) (*db.Agent, error) {
+	email, err := s.slackService.GetUserEmail(ctx, slackMeta.AccessToken, slackUserID)
+	if err != nil {
+		logger.GetTxLogger(ctx).Warn().Err(err).Str("slack_user", slackUserID).Msg("Failed to get Slack user email")
+		return nil, ErrSlackAgentNotLinked
+	}
+
+	agent, err := s.agentRepo.GetByEmail(ctx, tenantID, email)
+	if err != nil || agent == nil || agent.Status != "active" {
+		return nil, ErrSlackAgentNotLinked
+	}
+
+	allowed, err := s.rbacService.CheckPermission(ctx, agent.ID, tenantID, projectID, permission)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check permission: %w", err)
+	}
+	if !allowed {
+		return nil, ErrSlackPermissionDenied
+	}
+
+	return agent, nil
+}
+
+func (s *SlackInteractionService) claimSession(ctx context.Context, session *models.ChatSession, agent *db.Agent) (string, error) {
+	if session.Status == "ended" {
+		return "This chat has already ended.", nil
+	}
+	if session.AssignedAgentID != nil && *session.AssignedAgentID == agent.ID {
+		return fmt.Sprintf("%s is already handling this chat.", agent.Name), nil
+	}
+
+	if err := s.chatSessionService.AssignAgentWithSessionObj(ctx, session.TenantID, session.ProjectID, agent.ID, session); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(":raising_hand: %s claimed this chat.", agent.Name), nil
+}
+
+func (s *SlackInteractionService) escalateSession(ctx context.Context, session *models.ChatSession, agent *db.Agent) (string, error) {
+	_, err := s.chatSessionService.EscalateSession(ctx, session.TenantID, session.ProjectID, session.ID, &models.EscalateChatSessionRequest{
+		Reason:   fmt.Sprintf("Escalated from Slack by %s", agent.Name),
+		Priority: models.NotificationPriorityHigh,
+	}, agent.ID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(":rotating_light: %s escalated this chat.", agent.Name), nil
+}
+
+func (s *SlackInteractionService) closeSession(ctx context.Context, session *models.ChatSession, agent *db.Agent) (string, error) {
+	if session.Status == "ended" {
+		return "This chat has already ended.", nil
+	}
+
+	if err := s.chatSessionService.EndSession(ctx, session.TenantID, session.ProjectID, session.ID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(":white_check_mark: %s closed this chat.", agent.Name), nil
+}
+
+func (s *SlackInteractionService) convertSession(ctx context.Context, session *models.ChatSession, agent *db.Agent) (string, error) {
+	if session.TicketID != nil {
+		return "This chat has already been converted to a ticket.", nil
+	}
+
+	allowed, err := s.rbacService.CheckPermission(ctx, agent.ID, session.TenantID, session.ProjectID, rbac.PermTicketWrite)
+	if err != nil {
+		return "", fmt.Errorf("failed to check permission: %w", err)
+	}
+	if !allowed {
+		return "", ErrSlackPermissionDenied
+	}
+
+	ticket, err := s.chatTicketService.ConvertSession(ctx, session, &agent.ID)
+	if errors.Is(err, ErrChatWithoutEmail) {
+		return "", ErrSlackChatWithoutEmail
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(":ticket: %s converted this chat to ticket #%d.", agent.Name, ticket.Number), nil
+}
+
+func (s *SlackInteractionService) ticketSummary(ctx context.Context, tenantID, projectID uuid.UUID, number int) *SlackResponseMessage {
+	ticket, err := s.ticketRepo.GetByProjectAndNumber(ctx, tenantID, projectID, number)
+	if err != nil {
+		return slackEphemeral(fmt.Sprintf("Ticket #%d was not found.", number))
+	}
+
+	assignee := "Unassigned"
+	if ticket.AssigneeAgentID != nil {
+		if agent, err := s.agentRepo.GetByID(ctx, tenantID, *ticket.AssigneeAgentID); err == nil && agent != nil {
+			assignee = agent.Name
+		}
+	}
+
+	text := fmt.Sprintf("*#%d %s*\nStatus: %s · Priority: %s · Type: %s\nAssignee: %s · Updated %s",
+		ticket.Number, ticket.Subject, ticket.Status, ticket.Priority, ticket.Type,
+		assignee, ticket.UpdatedAt.Format("2006-01-02 15:04 MST"))
+	return slackEphemeral(text)
+}
+
+func (s *SlackInteractionService) searchKnowledge(ctx context.Context, tenantID, projectID uuid.UUID, query string) (*SlackResponseMessage, error) {
+	resp, err := s.knowledgeService.SearchKnowledgeBase(ctx, tenantID, projectID, &models.KnowledgeSearchRequest{
+		Query:            query,
+		MaxResults:       slackSearchMaxResults,
+		IncludeDocuments: true,
+		IncludePages:     true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search knowledge base: %w", err)
+	}
+	if len(resp.Results) == 0 {
+		return slackEphemeral(fmt.Sprintf("No knowledge base results for _%s_.", query)), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Top results for _%s_:\n", query)
+	for i, result := range resp.Results {
+		title := result.Source
+		if result.Title != nil && *result.Title != "" {
+			title = *result.Title
+		}
+		fmt.Fprintf(&b, "\n*%d. %s*\n>%s\n", i+1, title, truncateSlackText(result.Content, slackSnippetLength))
+	}
+	return slackEphemeral(b.String()), nil
+}
+
+func (s *SlackInteractionService) chatStatus(ctx context.Context, tenantID, projectID uuid.UUID) (*SlackResponseMessage, error) {
+	sessions, err := s.chatSessionService.ListChatSessions(ctx, tenantID, projectID, repo.ChatSessionFilters{Status: "active"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chat sessions: %w", err)
+	}
+	if len(sessions) == 0 {
+		return slackEphemeral("No open chats right now. :tada:"), nil
+	}
+
+	unassigned := 0
+	for _, session := range sessions {
+		if session.AssignedAgentID == nil {
+			unassigned++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d open chats*, %d unassigned\n", len(sessions), unassigned)
+	for i, session := range sessions {
+		if i == slackStatusMaxSessions {
+			fmt.Fprintf(&b, "…and %d more", len(sessions)-slackStatusMaxSessions)
+			break
+		}
+		visitor := "Anonymous visitor"
+		if session.CustomerName != nil && *session.CustomerName != "" {
+			visitor = *session.CustomerName
+		}
+		assignee := "unassigned"
+		if session.AssignedAgentName != nil {
+			assignee = *session.AssignedAgentName
+		}
+		fmt.Fprintf(&b, "• %s — %s, last activity %s\n", visitor, assignee, session.LastActivityAt.Format("15:04 MST"))
+	}
+	return slackEphemeral(b.String()), nil
+}
+
+func slackEphemeral(text string) *SlackResponseMessage {
+	return &SlackResponseMessage{ResponseType: "ephemeral", Text: text}
+}
+
+func slackCommandUsage(command string) string {
+	if command == "" {
+		command = "/tms"
+	}
+	return fmt.Sprintf("Usage:\n• `%[1]s ticket 1234` show a ticket\n• `%[1]s search <query>` search the knowledge base\n• `%[1]s status` list open chats", command)
+}
+
+func truncateSlackText(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSlackCommand(t *testing.T) {
+	require.Equal(t, SlackCommand{Name: "help"}, ParseSlackCommand("   "))
+	require.Equal(t, SlackCommand{Name: "ticket", Args: "1234"}, ParseSlackCommand("ticket 1234"))
+	require.Equal(t, SlackCommand{Name: "search", Args: "reset my password"}, ParseSlackCommand("Search  reset   my password"))
+	require.Equal(t, SlackCommand{Name: "status"}, ParseSlackCommand("status"))
+}
+
+func TestSessionActionBlocks(t *testing.T) {
+	sessionID := uuid.New()
+	blocks := SessionActionBlocks("*Visitor:* hello", sessionID)
+
+	require.Len(t, blocks, 2)
+	require.Equal(t, "section", blocks[0].Type)
+	require.Equal(t, "*Visitor:* hello", blocks[0].Text.Text)
+
+	actions := blocks[1]
+	require.Equal(t, "actions", actions.Type)
+	var actionIDs []string
+	for _, element := range actions.Elements {
+		require.Equal(t, "button", element.Type)
+		require.Equal(t, sessionID.String(), element.Value)
+		actionIDs = append(actionIDs, element.ActionID)
+	}
+	require.ElementsMatch(t, []string{SlackActionClaim, SlackActionEscalate, SlackActionConvert, SlackActionClose}, actionIDs)
+
+	// Slack rejects unknown or empty keys, so optional fields must be omitted
+	data, err := json.Marshal(blocks)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), `"style":""`)
+	require.NotContains(t, string(data), `"confirm":null`)
+}
+
+func TestSlackInteractionHelpNeedsNoWorkspaceLookup(t *testing.T) {
+	svc := NewSlackInteractionService(nil, nil, nil, nil, nil, nil, nil, nil)
+
+	resp, err := svc.HandleSlashCommand(context.Background(), &SlackSlashCommand{Command: "/tms", Text: ""})
+	require.NoError(t, err)
+	require.Equal(t, "ephemeral", resp.ResponseType)
+	require.Contains(t, resp.Text, "/tms ticket 1234")
+	require.Contains(t, resp.Text, "/tms search <query>")
+	require.Contains(t, resp.Text, "/tms status")
+}
+
+func TestTruncateSlackText(t *testing.T) {
+	require.Equal(t, "short text", truncateSlackText("short\n  text", 20))
+	require.Equal(t, strings.Repeat("é", 5)+"…", truncateSlackText(strings.Repeat("é", 10), 5))
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// slackSignatureVersion is the only request signing version Slack uses today
+	slackSignatureVersion = "v0"
+
+	// slackRequestMaxAge is the replay window Slack recommends for request timestamps
+	slackRequestMaxAge = 5 * time.Minute
+
+	// slackEventDedupTTL covers Slack's retry schedule (immediately, after 1 minute and after 5 minutes)
+	slackEventDedupTTL = time.Hour
+)
+
+var (
+	ErrSlackSigningDisabled    = errors.New("slack signing secret is not configured")
+	ErrSlackSignatureInvalid   = errors.New("invalid slack request signature")
+	ErrSlackTimestampOutOfDate = errors.New("slack request timestamp is outside the allowed window")
+)
+
+// SignSlackRequest computes the X-Slack-Signature value for a request body
+func SignSlackRequest(signingSecret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(slackSignatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return slackSignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySlackRequest checks the X-Slack-Request-Timestamp and X-Slack-Signature headers
+// of a request against the app signing secret
+func VerifySlackRequest(signingSecret, timestamp, signature string, body []byte, now time.Time) error {
+	if signingSecret == "" {
+		return ErrSlackSigningDisabled
+	}
+	if timestamp == "" || signature == "" {
+		return ErrSlackSignatureInvalid
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSlackSignatureInvalid
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return ErrSlackTimestampOutOfDate
+	}
+
+	expected := SignSlackRequest(signingSecret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSlackSignatureInvalid
+	}
+	return nil
+}
+
+// ClaimSlackEvent records a Slack event_id and reports whether this is the first delivery.
+// Slack redelivers events it did not see acknowledged in time, so retries are dropped here.
+func (s *SlackService) ClaimSlackEvent(ctx context.Context, eventID string) (bool, error) {
+	if eventID == "" || s.redisService == nil {
+		return true, nil
+	}
+
+	claimed, err := s.redisService.GetClient().SetNX(ctx, slackEventKey(eventID), time.Now().Unix(), slackEventDedupTTL).Result()
+	if err != nil {
+		return true, fmt.Errorf("failed to record slack event: %w", err)
+	}
+	return claimed, nil
+}
+
+func slackEventKey(eventID string) string {
+	return "slack:event:" + eventID
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bareuptime/tms/internal/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySlackRequest(t *testing.T) {
+	const secret = "8f742231b10e8888abcd99yyyzzz85a5"
+	body := []byte(`{"type":"event_callback","event_id":"Ev123"}`)
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := SignSlackRequest(secret, timestamp, body)
+
+	require.NoError(t, VerifySlackRequest(secret, timestamp, signature, body, now))
+	require.NoError(t, VerifySlackRequest(secret, timestamp, signature, body, now.Add(4*time.Minute)))
+
+	require.ErrorIs(t, VerifySlackRequest("", timestamp, signature, body, now), ErrSlackSigningDisabled)
+	require.ErrorIs(t, VerifySlackRequest(secret, "", signature, body, now), ErrSlackSignatureInvalid)
+	require.ErrorIs(t, VerifySlackRequest(secret, "not-a-number", signature, body, now), ErrSlackSignatureInvalid)
+	require.ErrorIs(t, VerifySlackRequest("other-secret", timestamp, signature, body, now), ErrSlackSignatureInvalid)
+	require.ErrorIs(t, VerifySlackRequest(secret, timestamp, signature, []byte(`{"type":"event_callback"}`), now), ErrSlackSignatureInvalid)
+	require.ErrorIs(t, VerifySlackRequest(secret, timestamp, signature, body, now.Add(6*time.Minute)), ErrSlackTimestampOutOfDate)
+	require.ErrorIs(t, VerifySlackRequest(secret, timestamp, signature, body, now.Add(-6*time.Minute)), ErrSlackTimestampOutOfDate)
+}
+
+func TestSlackServiceClaimSlackEvent(t *testing.T) {
+	mini, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mini.Close()
+
+	svc := NewSlackService(nil, nil, redis.NewService(redis.RedisConfig{
+		URL:         fmt.Sprintf("redis://%s", mini.Addr()),
+		Environment: "test",
+	}))
+	ctx := context.Background()
+
+	first, err := svc.ClaimSlackEvent(ctx, "Ev123")
+	require.NoError(t, err)
+	require.True(t, first)
+
+	retry, err := svc.ClaimSlackEvent(ctx, "Ev123")
+	require.NoError(t, err)
+	require.False(t, retry)
+
+	other, err := svc.ClaimSlackEvent(ctx, "Ev456")
+	require.NoError(t, err)
+	require.True(t, other)
+
+	require.Equal(t, slackEventDedupTTL, mini.TTL(slackEventKey("Ev123")))
+
+	noID, err := svc.ClaimSlackEvent(ctx, "")
+	require.NoError(t, err)
+	require.True(t, noID)
+}
@@ -0,0 +1,921 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bareuptime/tms/internal/crypto"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/logger"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/bareuptime/tms/internal/redis"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	saml2 "github.com/russellhaering/gosaml2"
+	dsig "github.com/russellhaering/goxmldsig"
+	"golang.org/x/oauth2"
+)
+
+// SSO errors
+var (
+	ErrSSOProviderNotFound   = errors.New("SSO provider not found")
+	ErrSSOProviderExists     = errors.New("an SSO provider with this name already exists")
+	ErrSSOInvalidProvider    = errors.New("invalid SSO provider configuration")
+	ErrSSOStateInvalid       = errors.New("SSO login expired or was already used")
+	ErrSSOAuthFailed         = errors.New("SSO authentication failed")
+	ErrSSOEmailNotAllowed    = errors.New("email domain is not allowed for this SSO provider")
+	ErrSSONotProvisioned     = errors.New("no agent account exists for this identity")
+	ErrSSOAccountConflict    = errors.New("an account with this email belongs to another tenant")
+	ErrSSONoEnabledProvider  = errors.New("password login can only be disabled with an enabled SSO provider")
+	ErrSSOLastProvider       = errors.New("cannot remove the last enabled SSO provider while password login is disabled")
+	ErrPasswordLoginDisabled = errors.New("password login is disabled for this tenant, sign in with SSO")
+)
+
+const (
+	ssoStateTTL        = 10 * time.Minute
+	ssoHTTPTimeout     = 10 * time.Second
+	defaultGroupsClaim = "groups"
+)
+
+// SAML attribute names commonly used by Okta, Azure AD and Keycloak
+var (
+	samlEmailAttributes = []string{
+		"email", "mail", "emailaddress",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+	}
+	samlNameAttributes = []string{
+		"name", "displayName",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+		"http://schemas.microsoft.com/identity/claims/displayname",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+	}
+)
+
+// SSOProviderRequest is the payload for creating or updating an identity provider
+type SSOProviderRequest struct {
+	Name             string                  `json:"name" binding:"required" example:"Okta"`
+	Protocol         string                  `json:"protocol" binding:"required" example:"oidc"`
+	Enabled          *bool                   `json:"enabled,omitempty"`
+	IssuerURL        string                  `json:"issuer_url,omitempty" example:"https://example.okta.com"`
+	ClientID         string                  `json:"client_id,omitempty"`
+	ClientSecret     string                  `json:"client_secret,omitempty"`
+	Scopes           []string                `json:"scopes,omitempty"`
+	IDPEntityID      string                  `json:"idp_entity_id,omitempty"`
+	IDPSSOURL        string                  `json:"idp_sso_url,omitempty"`
+	IDPCertificate   string                  `json:"idp_certificate,omitempty"`
+	GroupsClaim      string                  `json:"groups_claim,omitempty" example:"groups"`
+	EmailDomains     []string                `json:"email_domains,omitempty" example:"example.com"`
+	JITProvisioning  *bool                   `json:"jit_provisioning,omitempty"`
+	DefaultRole      string                  `json:"default_role,omitempty" example:"agent"`
+	DefaultProjectID *uuid.UUID              `json:"default_project_id,omitempty"`
+	GroupMappings    models.SSOGroupMappings `json:"group_mappings,omitempty"`
+}
+
+// SSOProviderSummary is the public view of a provider used by the login page
+type SSOProviderSummary struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Protocol string    `json:"protocol"`
+	LoginURL string    `json:"login_url"`
+}
+
+// SSOIdentity is the verified identity returned by an identity provider
+type SSOIdentity struct {
+	Provider *db.SSOProvider
+	Subject  string
+	Email    string
+	Name     string
+	Groups   []string
+}
+
+// RoleAssignment grants a role on a project
+type RoleAssignment struct {
+	ProjectID uuid.UUID
+	Role      models.RoleType
+}
+
+// ssoState is kept in Redis between the redirect to the IdP and its callback
+type ssoState struct {
+	ProviderID uuid.UUID `json:"provider_id"`
+	Nonce      string    `json:"nonce,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// SSOService manages tenant identity providers and verifies OIDC and SAML logins
+type SSOService struct {
+	ssoRepo      repo.SSORepository
+	projectRepo  repo.ProjectRepository
+	rbacService  *rbac.Service
+	redisService *redis.Service
+	encryption   *crypto.PasswordEncryption
+	baseURL      string
+	httpClient   *http.Client
+
+	oidcMu        sync.Mutex
+	oidcProviders map[string]*oidc.Provider
+}
+
+// NewSSOService creates a new SSO service
+func NewSSOService(ssoRepo repo.SSORepository, projectRepo repo.ProjectRepository, rbacService *rbac.Service, redisService *redis.Service, encryption *crypto.PasswordEncryption, baseURL string) *SSOService {
+	return &SSOService{
+		ssoRepo:       ssoRepo,
+		projectRepo:   projectRepo,
+		rbacService:   rbacService,
+		redisService:  redisService,
+		encryption:    encryption,
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		httpClient:    &http.Client{Timeout: ssoHTTPTimeout},
+		oidcProviders: make(map[string]*oidc.Provider),
+	}
+}
+
+func ssoStateKey(state string) string {
+	return fmt.Sprintf("sso_state:%s", state)
+}
+
+func (s *SSOService) providerURL(providerID uuid.UUID, endpoint string) string {
+	return fmt.Sprintf("%s/v1/auth/sso/%s/%s", s.baseURL, providerID.String(), endpoint)
+}
+
+// LoginURL returns the URL that starts a login with the provider
+func (s *SSOService) LoginURL(providerID uuid.UUID) string {
+	return s.providerURL(providerID, "login")
+}
+
+// CallbackURL returns the OIDC redirect URI to register at the IdP
+func (s *SSOService) CallbackURL(providerID uuid.UUID) string {
+	return s.providerURL(providerID, "callback")
+}
+
+// ACSURL returns the SAML assertion consumer service URL to register at the IdP
+func (s *SSOService) ACSURL(providerID uuid.UUID) string {
+	return s.providerURL(providerID, "acs")
+}
+
+// EntityID returns the SAML service provider entity ID, which is also the metadata URL
+func (s *SSOService) EntityID(providerID uuid.UUID) string {
+	return s.providerURL(providerID, "metadata")
+}
+
+// ListProviders returns the identity providers of a tenant
+func (s *SSOService) ListProviders(ctx context.Context, tenantID uuid.UUID) ([]*db.SSOProvider, error) {
+	providers, err := s.ssoRepo.ListProviders(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SSO providers: %w", err)
+	}
+	return providers, nil
+}
+
+// GetProvider returns an identity provider of a tenant
+func (s *SSOService) GetProvider(ctx context.Context, tenantID, providerID uuid.UUID) (*db.SSOProvider, error) {
+	provider, err := s.ssoRepo.GetProvider(ctx, tenantID, providerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSO provider: %w", err)
+	}
+	if provider == nil {
+		return nil, ErrSSOProviderNotFound
+	}
+	return provider, nil
+}
+
+// CreateProvider validates and stores a new identity provider
+func (s *SSOService) CreateProvider(ctx context.Context, tenantID uuid.UUID, req SSOProviderRequest) (*db.SSOProvider, error) {
+	provider := &db.SSOProvider{
+		TenantID:        tenantID,
+		Enabled:         true,
+		JITProvisioning: true,
+	}
+	if err := s.applyProviderRequest(ctx, provider, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.ssoRepo.CreateProvider(ctx, provider); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrSSOProviderExists
+		}
+		return nil, fmt.Errorf("failed to create SSO provider: %w", err)
+	}
+	return provider, nil
+}
+
+// UpdateProvider replaces the configuration of an identity provider.
+// An empty client secret keeps the stored one.
+func (s *SSOService) UpdateProvider(ctx context.Context, tenantID, providerID uuid.UUID, req SSOProviderRequest) (*db.SSOProvider, error) {
+	provider, err := s.GetProvider(ctx, tenantID, providerID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Protocol != provider.Protocol {
+		return nil, fmt.Errorf("%w: protocol cannot be changed", ErrSSOInvalidProvider)
+	}
+
+	wasEnabled := provider.Enabled
+	if err := s.applyProviderRequest(ctx, provider, req); err != nil {
+		return nil, err
+	}
+	if wasEnabled && !provider.Enabled {
+		if err := s.ensureNotLastProvider(ctx, tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.ssoRepo.UpdateProvider(ctx, provider); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrSSOProviderExists
+		}
+		return nil, fmt.Errorf("failed to update SSO provider: %w", err)
+	}
+	return provider, nil
+}
+
+// DeleteProvider removes an identity provider
+func (s *SSOService) DeleteProvider(ctx context.Context, tenantID, providerID uuid.UUID) error {
+	provider, err := s.GetProvider(ctx, tenantID, providerID)
+	if err != nil {
+		return err
+	}
+	if provider.Enabled {
+		if err := s.ensureNotLastProvider(ctx, tenantID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.ssoRepo.DeleteProvider(ctx, tenantID, providerID); err != nil {
+		return fmt.Errorf("failed to delete SSO provider: %w", err)
+	}
+	return nil
+}
+
+// ensureNotLastProvider keeps agents from being locked out when only SSO logins are allowed
+func (s *SSOService) ensureNotLastProvider(ctx context.Context, tenantID uuid.UUID) error {
+	disabled, err := s.ssoRepo.IsPasswordLoginDisabled(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to get security settings: %w", err)
+	}
+	if !disabled {
+		return nil
+	}
+
+	count, err := s.ssoRepo.CountEnabledProviders(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to count SSO providers: %w", err)
+	}
+	if count <= 1 {
+		return ErrSSOLastProvider
+	}
+	return nil
+}
+
+// applyProviderRequest validates a request and copies it onto the provider
+func (s *SSOService) applyProviderRequest(ctx context.Context, provider *db.SSOProvider, req SSOProviderRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return fmt.Errorf("%w: name must be 1-100 characters", ErrSSOInvalidProvider)
+	}
+	provider.Name = name
+	provider.Protocol = req.Protocol
+
+	if req.Enabled != nil {
+		provider.Enabled = *req.Enabled
+	}
+	if req.JITProvisioning != nil {
+		provider.JITProvisioning = *req.JITProvisioning
+	}
+
+	switch req.Protocol {
+	case models.SSOProtocolOIDC:
+		issuer := strings.TrimRight(strings.TrimSpace(req.IssuerURL), "/")
+		if !isHTTPURL(issuer) || strings.TrimSpace(req.ClientID) == "" {
+			return fmt.Errorf("%w: OIDC providers need an issuer_url and a client_id", ErrSSOInvalidProvider)
+		}
+		clientID := strings.TrimSpace(req.ClientID)
+		provider.IssuerURL = &issuer
+		provider.ClientID = &clientID
+
+		if req.ClientSecret != "" {
+			encrypted, err := s.encryption.Encrypt(req.ClientSecret)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt client secret: %w", err)
+			}
+			secret := string(encrypted)
+			provider.ClientSecretEncrypted = &secret
+		}
+		if provider.ClientSecretEncrypted == nil {
+			return fmt.Errorf("%w: OIDC providers need a client_secret", ErrSSOInvalidProvider)
+		}
+
+		scopes := pq.StringArray{oidc.ScopeOpenID}
+		for _, scope := range req.Scopes {
+			scope = strings.TrimSpace(scope)
+			if scope != "" && scope != oidc.ScopeOpenID {
+				scopes = append(scopes, scope)
+			}
+		}
+		if len(scopes) == 1 {
+			scopes = append(scopes, "email", "profile")
+		}
+		provider.Scopes = scopes
+		provider.IDPEntityID, provider.IDPSSOURL, provider.IDPCertificate = nil, nil, nil
+
+	case models.SSOProtocolSAML:
+		entityID := strings.TrimSpace(req.IDPEntityID)
+		ssoURL := strings.TrimSpace(req.IDPSSOURL)
+		if entityID == "" || !isHTTPURL(ssoURL) {
+			return fmt.Errorf("%w: SAML providers need an idp_entity_id and an idp_sso_url", ErrSSOInvalidProvider)
+		}
+		certificate := strings.TrimSpace(req.IDPCertificate)
+		if _, err := parseIDPCertificate(certificate); err != nil {
+			return fmt.Errorf("%w: %v", ErrSSOInvalidProvider, err)
+		}
+		provider.IDPEntityID = &entityID
+		provider.IDPSSOURL = &ssoURL
+		provider.IDPCertificate = &certificate
+		provider.IssuerURL, provider.ClientID, provider.ClientSecretEncrypted = nil, nil, nil
+		provider.Scopes = pq.StringArray{}
+
+	default:
+		return fmt.Errorf("%w: protocol must be oidc or saml", ErrSSOInvalidProvider)
+	}
+
+	provider.GroupsClaim = strings.TrimSpace(req.GroupsClaim)
+	if provider.GroupsClaim == "" {
+		provider.GroupsClaim = defaultGroupsClaim
+	}
+
+	domains := pq.StringArray{}
+	for _, domain := range req.EmailDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain == "" {
+			continue
+		}
+		if !strings.Contains(domain, ".") {
+			return fmt.Errorf("%w: invalid email domain %s", ErrSSOInvalidProvider, domain)
+		}
+		domains = append(domains, domain)
+	}
+	provider.EmailDomains = domains
+
+	provider.DefaultRole = models.RoleType(strings.TrimSpace(req.DefaultRole))
+	if provider.DefaultRole == "" {
+		provider.DefaultRole = models.RoleAgent
+	}
+	if err := s.validateRole(ctx, provider.TenantID, provider.DefaultRole); err != nil {
+		return err
+	}
+
+	provider.DefaultProjectID = req.DefaultProjectID
+	if err := s.validateProject(ctx, provider.TenantID, provider.DefaultProjectID); err != nil {
+		return err
+	}
+
+	mappings := models.SSOGroupMappings{}
+	for _, mapping := range req.GroupMappings {
+		mapping.Group = strings.TrimSpace(mapping.Group)
+		if mapping.Group == "" {
+			return fmt.Errorf("%w: group mappings need a group", ErrSSOInvalidProvider)
+		}
+		if err := s.validateRole(ctx, provider.TenantID, mapping.Role); err != nil {
+			return err
+		}
+		if err := s.validateProject(ctx, provider.TenantID, mapping.ProjectID); err != nil {
+			return err
+		}
+		mappings = append(mappings, mapping)
+	}
+	provider.GroupMappings = mappings
+
+	return nil
+}
+
+func (s *SSOService) validateRole(ctx context.Context, tenantID uuid.UUID, role models.RoleType) error {
+	exists, err := s.rbacService.RoleExists(ctx, tenantID, role)
+	if err != nil {
+		return fmt.Errorf("failed to validate role: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: unknown role %s", ErrSSOInvalidProvider, role)
+	}
+	return nil
+}
+
+func (s *SSOService) validateProject(ctx context.Context, tenantID uuid.UUID, projectID *uuid.UUID) error {
+	if projectID == nil {
+		return nil
+	}
+	project, err := s.projectRepo.GetByID(ctx, tenantID, *projectID)
+	if err != nil || project == nil {
+		return fmt.Errorf("%w: unknown project %s", ErrSSOInvalidProvider, projectID)
+	}
+	return nil
+}
+
+// SetPasswordLoginDisabled turns password logins off or on for a tenant.
+// Turning them off requires an enabled identity provider so agents can still sign in.
+func (s *SSOService) SetPasswordLoginDisabled(ctx context.Context, tenantID uuid.UUID, disabled bool) error {
+	if disabled {
+		count, err := s.ssoRepo.CountEnabledProviders(ctx, tenantID)
+		if err != nil {
+			return fmt.Errorf("failed to count SSO providers: %w", err)
+		}
+		if count == 0 {
+			return ErrSSONoEnabledProvider
+		}
+	}
+
+	if err := s.ssoRepo.SetPasswordLoginDisabled(ctx, tenantID, disabled); err != nil {
+		return fmt.Errorf("failed to save security settings: %w", err)
+	}
+	return nil
+}
+
+// PasswordLoginAllowed reports whether agents of the tenant may sign in with a password
+func (s *SSOService) PasswordLoginAllowed(ctx context.Context, tenantID uuid.UUID) (bool, error) {
+	disabled, err := s.ssoRepo.IsPasswordLoginDisabled(ctx, tenantID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get security settings: %w", err)
+	}
+	return !disabled, nil
+}
+
+// DiscoverProviders returns the providers that claim the domain of an email address
+func (s *SSOService) DiscoverProviders(ctx context.Context, email string) ([]*SSOProviderSummary, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return []*SSOProviderSummary{}, nil
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+
+	providers, err := s.ssoRepo.FindProvidersByEmailDomain(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find SSO providers: %w", err)
+	}
+
+	summaries := make([]*SSOProviderSummary, 0, len(providers))
+	for _, provider := range providers {
+		summaries = append(summaries, &SSOProviderSummary{
+			ID:       provider.ID,
+			Name:     provider.Name,
+			Protocol: provider.Protocol,
+			LoginURL: s.LoginURL(provider.ID),
+		})
+	}
+	return summaries, nil
+}
+
+// BeginLogin returns the IdP URL to redirect the browser to
+func (s *SSOService) BeginLogin(ctx context.Context, providerID uuid.UUID) (string, error) {
+	provider, err := s.enabledProvider(ctx, providerID)
+	if err != nil {
+		return "", err
+	}
+
+	stateToken, err := randomSSOToken()
+	if err != nil {
+		return "", err
+	}
+	state := ssoState{ProviderID: provider.ID}
+
+	var redirectURL string
+	switch provider.Protocol {
+	case models.SSOProtocolOIDC:
+		config, _, err := s.oidcConfig(ctx, provider)
+		if err != nil {
+			return "", err
+		}
+		state.Nonce, err = randomSSOToken()
+		if err != nil {
+			return "", err
+		}
+		redirectURL = config.AuthCodeURL(stateToken, oidc.Nonce(state.Nonce))
+
+	case models.SSOProtocolSAML:
+		sp, err := s.samlServiceProvider(provider)
+		if err != nil {
+			return "", err
+		}
+		doc, err := sp.BuildAuthRequestDocument()
+		if err != nil {
+			return "", fmt.Errorf("failed to build SAML request: %w", err)
+		}
+		state.RequestID = doc.Root().SelectAttrValue("ID", "")
+		redirectURL, err = sp.BuildAuthURLFromDocument(stateToken, doc)
+		if err != nil {
+			return "", fmt.Errorf("failed to build SAML redirect: %w", err)
+		}
+
+	default:
+		return "", ErrSSOInvalidProvider
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode SSO state: %w", err)
+	}
+	if err := s.redisService.GetClient().Set(ctx, ssoStateKey(stateToken), data, ssoStateTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store SSO state: %w", err)
+	}
+
+	return redirectURL, nil
+}
+
+// CompleteOIDCLogin exchanges the authorization code and verifies the ID token
+func (s *SSOService) CompleteOIDCLogin(ctx context.Context, providerID uuid.UUID, stateToken, code string) (*SSOIdentity, error) {
+	state, err := s.consumeState(ctx, providerID, stateToken)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := s.enabledProvider(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+	if provider.Protocol != models.SSOProtocolOIDC {
+		return nil, ErrSSOProviderNotFound
+	}
+
+	config, verifier, err := s.oidcConfig(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(oidc.ClientContext(ctx, s.httpClient), code)
+	if err != nil {
+		return nil, fmt.Errorf("%w: code exchange failed: %v", ErrSSOAuthFailed, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in token response", ErrSSOAuthFailed)
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSOAuthFailed, err)
+	}
+	if idToken.Nonce != state.Nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrSSOAuthFailed)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims: %v", ErrSSOAuthFailed, err)
+	}
+
+	if verified, present := claims["email_verified"].(bool); present && !verified {
+		return nil, fmt.Errorf("%w: email not verified with the identity provider", ErrSSOAuthFailed)
+	}
+
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	return s.newIdentity(provider, idToken.Subject, email, name, claimStrings(claims[provider.GroupsClaim]))
+}
+
+// CompleteSAMLLogin validates a SAML response posted to the ACS endpoint
+func (s *SSOService) CompleteSAMLLogin(ctx context.Context, providerID uuid.UUID, relayState, samlResponse string) (*SSOIdentity, error) {
+	state, err := s.consumeState(ctx, providerID, relayState)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := s.enabledProvider(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+	if provider.Protocol != models.SSOProtocolSAML {
+		return nil, ErrSSOProviderNotFound
+	}
+
+	sp, err := s.samlServiceProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := sp.RetrieveAssertionInfo(samlResponse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSOAuthFailed, err)
+	}
+	if info.WarningInfo.InvalidTime {
+		return nil, fmt.Errorf("%w: assertion is expired or not yet valid", ErrSSOAuthFailed)
+	}
+	if info.WarningInfo.NotInAudience {
+		return nil, fmt.Errorf("%w: assertion is not intended for this service provider", ErrSSOAuthFailed)
+	}
+
+	// Only accept responses to the request we issued, which rules out unsolicited and replayed assertions
+	assertion := info.Assertions[0]
+	if assertion.Subject == nil || assertion.Subject.SubjectConfirmation == nil ||
+		assertion.Subject.SubjectConfirmation.SubjectConfirmationData == nil ||
+		assertion.Subject.SubjectConfirmation.SubjectConfirmationData.InResponseTo != state.RequestID {
+		return nil, fmt.Errorf("%w: response does not match the login request", ErrSSOAuthFailed)
+	}
+
+	email := firstSAMLValue(info.Values, samlEmailAttributes)
+	if email == "" && strings.Contains(info.NameID, "@") {
+		email = info.NameID
+	}
+	name := firstSAMLValue(info.Values, samlNameAttributes)
+
+	return s.newIdentity(provider, info.NameID, email, name, info.Values.GetAll(provider.GroupsClaim))
+}
+
+// ServiceProviderMetadata returns the SAML SP metadata to upload to the IdP
+func (s *SSOService) ServiceProviderMetadata(ctx context.Context, providerID uuid.UUID) (string, error) {
+	provider, err := s.enabledProvider(ctx, providerID)
+	if err != nil {
+		return "", err
+	}
+	if provider.Protocol != models.SSOProtocolSAML {
+		return "", ErrSSOProviderNotFound
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:NameIDFormat>%s</md:NameIDFormat>
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="%s" index="0" isDefault="true"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`, html.EscapeString(s.EntityID(provider.ID)), saml2.NameIdFormatEmailAddress, html.EscapeString(s.ACSURL(provider.ID))), nil
+}
+
+// ResolveRoleAssignments maps IdP groups to project roles. Mappings are evaluated in order
+// and the first match per project wins. When no mapping matches, the provider's default
+// role is returned and matched is false.
+func (s *SSOService) ResolveRoleAssignments(ctx context.Context, provider *db.SSOProvider, groups []string) ([]RoleAssignment, bool, error) {
+	memberOf := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		memberOf[normalizeGroup(group)] = true
+	}
+
+	var allProjects []uuid.UUID
+	projectsFor := func(projectID *uuid.UUID) ([]uuid.UUID, error) {
+		if projectID != nil {
+			return []uuid.UUID{*projectID}, nil
+		}
+		if allProjects == nil {
+			projects, err := s.projectRepo.List(ctx, provider.TenantID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list projects: %w", err)
+			}
+			allProjects = make([]uuid.UUID, 0, len(projects))
+			for _, project := range projects {
+				allProjects = append(allProjects, project.ID)
+			}
+		}
+		return allProjects, nil
+	}
+
+	var assignments []RoleAssignment
+	assigned := make(map[uuid.UUID]bool)
+	for _, mapping := range provider.GroupMappings {
+		if !memberOf[normalizeGroup(mapping.Group)] {
+			continue
+		}
+		projects, err := projectsFor(mapping.ProjectID)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, projectID := range projects {
+			if assigned[projectID] {
+				continue
+			}
+			assigned[projectID] = true
+			assignments = append(assignments, RoleAssignment{ProjectID: projectID, Role: mapping.Role})
+		}
+	}
+	if len(assignments) > 0 {
+		return assignments, true, nil
+	}
+
+	projects, err := projectsFor(provider.DefaultProjectID)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, projectID := range projects {
+		assignments = append(assignments, RoleAssignment{ProjectID: projectID, Role: provider.DefaultRole})
+	}
+	return assignments, false, nil
+}
+
+func (s *SSOService) enabledProvider(ctx context.Context, providerID uuid.UUID) (*db.SSOProvider, error) {
+	provider, err := s.ssoRepo.GetEnabledProvider(ctx, providerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSO provider: %w", err)
+	}
+	if provider == nil {
+		return nil, ErrSSOProviderNotFound
+	}
+	return provider, nil
+}
+
+// consumeState loads and deletes the login state so each IdP response is accepted once
+func (s *SSOService) consumeState(ctx context.Context, providerID uuid.UUID, stateToken string) (*ssoState, error) {
+	if stateToken == "" {
+		return nil, ErrSSOStateInvalid
+	}
+
+	raw, err := s.redisService.GetClient().GetDel(ctx, ssoStateKey(stateToken)).Result()
+	if err != nil {
+		return nil, ErrSSOStateInvalid
+	}
+
+	var state ssoState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil || state.ProviderID != providerID {
+		return nil, ErrSSOStateInvalid
+	}
+	return &state, nil
+}
+
+// oidcConfig builds the OAuth2 config and ID token verifier for a provider.
+// Discovery documents and signing keys are cached per issuer.
+func (s *SSOService) oidcConfig(ctx context.Context, provider *db.SSOProvider) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	if provider.IssuerURL == nil || provider.ClientID == nil || provider.ClientSecretEncrypted == nil {
+		return nil, nil, ErrSSOInvalidProvider
+	}
+
+	oidcProvider, err := s.oidcProvider(*provider.IssuerURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientSecret, err := s.encryption.Decrypt([]byte(*provider.ClientSecretEncrypted))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt client secret: %w", err)
+	}
+
+	config := &oauth2.Config{
+		ClientID:     *provider.ClientID,
+		ClientSecret: clientSecret,
+		Endpoint:     oidcProvider.Endpoint(),
+		RedirectURL:  s.CallbackURL(provider.ID),
+		Scopes:       provider.Scopes,
+	}
+	verifier := oidcProvider.Verifier(&oidc.Config{ClientID: *provider.ClientID})
+	return config, verifier, nil
+}
+
+func (s *SSOService) oidcProvider(issuer string) (*oidc.Provider, error) {
+	s.oidcMu.Lock()
+	defer s.oidcMu.Unlock()
+
+	if cached, ok := s.oidcProviders[issuer]; ok {
+		return cached, nil
+	}
+
+	// The provider keeps this context to refresh signing keys, so it must outlive the request
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), s.httpClient), issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: OIDC discovery failed: %v", ErrSSOAuthFailed, err)
+	}
+	s.oidcProviders[issuer] = provider
+	return provider, nil
+}
+
+func (s *SSOService) samlServiceProvider(provider *db.SSOProvider) (*saml2.SAMLServiceProvider, error) {
+	if provider.IDPEntityID == nil || provider.IDPSSOURL == nil || provider.IDPCertificate == nil {
+		return nil, ErrSSOInvalidProvider
+	}
+
+	certificate, err := parseIDPCertificate(*provider.IDPCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSOInvalidProvider, err)
+	}
+
+	return &saml2.SAMLServiceProvider{
+		IdentityProviderSSOURL:      *provider.IDPSSOURL,
+		IdentityProviderIssuer:      *provider.IDPEntityID,
+		ServiceProviderIssuer:       s.EntityID(provider.ID),
+		AssertionConsumerServiceURL: s.ACSURL(provider.ID),
+		AudienceURI:                 s.EntityID(provider.ID),
+		IDPCertificateStore:         &dsig.MemoryX509CertificateStore{Roots: []*x509.Certificate{certificate}},
+		NameIdFormat:                saml2.NameIdFormatEmailAddress,
+		AllowMissingAttributes:      true,
+	}, nil
+}
+
+// newIdentity checks the email against the provider's domains and builds the identity
+func (s *SSOService) newIdentity(provider *db.SSOProvider, subject, email, name string, groups []string) (*SSOIdentity, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return nil, fmt.Errorf("%w: identity provider did not return an email address", ErrSSOAuthFailed)
+	}
+
+	if len(provider.EmailDomains) > 0 {
+		allowed := false
+		for _, domain := range provider.EmailDomains {
+			if email[at+1:] == domain {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, ErrSSOEmailNotAllowed
+		}
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = email[:at]
+	}
+
+	logger.Infof("SSO identity verified - provider_id: %s, tenant_id: %s, email: %s, groups: %d",
+		provider.ID, provider.TenantID, email, len(groups))
+
+	return &SSOIdentity{
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+		Name:     name,
+		Groups:   groups,
+	}, nil
+}
+
+// parseIDPCertificate accepts a PEM certificate or the bare base64 body found in IdP metadata
+func parseIDPCertificate(certificate string) (*x509.Certificate, error) {
+	if certificate == "" {
+		return nil, fmt.Errorf("idp_certificate is required")
+	}
+
+	var der []byte
+	if block, _ := pem.Decode([]byte(certificate)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certificate), ""))
+		if err != nil {
+			return nil, fmt.Errorf("idp_certificate is not a PEM or base64 certificate")
+		}
+		der = decoded
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idp_certificate: %v", err)
+	}
+	return parsed, nil
+}
+
+// claimStrings reads a claim that may be a single string or a list of strings
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func firstSAMLValue(values saml2.Values, names []string) string {
+	for _, name := range names {
+		if value := values.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// normalizeGroup compares groups case-insensitively and ignores Keycloak's leading slash
+func normalizeGroup(group string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(group)), "/")
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func isHTTPURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != ""
+}
+
+func randomSSOToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate SSO state: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
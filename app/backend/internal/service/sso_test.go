@@ -0,0 +1,484 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bareuptime/tms/internal/crypto"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/rbac"
+	"github.com/bareuptime/tms/internal/redis"
+	"github.com/bareuptime/tms/internal/repo"
+	"github.com/beevik/etree"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSSORepository struct {
+	providers        map[uuid.UUID]*db.SSOProvider
+	passwordDisabled map[uuid.UUID]bool
+}
+
+func newMockSSORepository() *mockSSORepository {
+	return &mockSSORepository{
+		providers:        make(map[uuid.UUID]*db.SSOProvider),
+		passwordDisabled: make(map[uuid.UUID]bool),
+	}
+}
+
+func (m *mockSSORepository) ListProviders(ctx context.Context, tenantID uuid.UUID) ([]*db.SSOProvider, error) {
+	var providers []*db.SSOProvider
+	for _, provider := range m.providers {
+		if provider.TenantID == tenantID {
+			providers = append(providers, provider)
+		}
+	}
+	return providers, nil
+}
+
+func (m *mockSSORepository) GetProvider(ctx context.Context, tenantID, providerID uuid.UUID) (*db.SSOProvider, error) {
+	provider, ok := m.providers[providerID]
+	if !ok || provider.TenantID != tenantID {
+		return nil, nil
+	}
+	copied := *provider
+	return &copied, nil
+}
+
+func (m *mockSSORepository) GetEnabledProvider(ctx context.Context, providerID uuid.UUID) (*db.SSOProvider, error) {
+	provider, ok := m.providers[providerID]
+	if !ok || !provider.Enabled {
+		return nil, nil
+	}
+	copied := *provider
+	return &copied, nil
+}
+
+func (m *mockSSORepository) FindProvidersByEmailDomain(ctx context.Context, domain string) ([]*db.SSOProvider, error) {
+	var providers []*db.SSOProvider
+	for _, provider := range m.providers {
+		for _, d := range provider.EmailDomains {
+			if provider.Enabled && d == domain {
+				providers = append(providers, provider)
+			}
+		}
+	}
+	return providers, nil
+}
+
+func (m *mockSSORepository) CreateProvider(ctx context.Context, provider *db.SSOProvider) error {
+	provider.ID = uuid.New()
+	copied := *provider
+	m.providers[provider.ID] = &copied
+	return nil
+}
+
+func (m *mockSSORepository) UpdateProvider(ctx context.Context, provider *db.SSOProvider) error {
+	copied := *provider
+	m.providers[provider.ID] = &copied
+	return nil
+}
+
+func (m *mockSSORepository) DeleteProvider(ctx context.Context, tenantID, providerID uuid.UUID) error {
+	delete(m.providers, providerID)
+	return nil
+}
+
+func (m *mockSSORepository) CountEnabledProviders(ctx context.Context, tenantID uuid.UUID) (int, error) {
+	count := 0
+	for _, provider := range m.providers {
+		if provider.TenantID == tenantID && provider.Enabled {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockSSORepository) IsPasswordLoginDisabled(ctx context.Context, tenantID uuid.UUID) (bool, error) {
+	return m.passwordDisabled[tenantID], nil
+}
+
+func (m *mockSSORepository) SetPasswordLoginDisabled(ctx context.Context, tenantID uuid.UUID, disabled bool) error {
+	m.passwordDisabled[tenantID] = disabled
+	return nil
+}
+
+type ssoProjectRepository struct {
+	repo.ProjectRepository
+	projects []*db.Project
+}
+
+func (m *ssoProjectRepository) GetByID(ctx context.Context, tenantID, projectID uuid.UUID) (*db.Project, error) {
+	for _, project := range m.projects {
+		if project.TenantID == tenantID && project.ID == projectID {
+			return project, nil
+		}
+	}
+	return nil, fmt.Errorf("project not found")
+}
+
+func (m *ssoProjectRepository) List(ctx context.Context, tenantID uuid.UUID) ([]*db.Project, error) {
+	return m.projects, nil
+}
+
+func newTestSSOService(t *testing.T, projects []*db.Project) (*SSOService, *mockSSORepository, *miniredis.Miniredis) {
+	t.Helper()
+
+	mini, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mini.Close)
+
+	encryption, err := crypto.NewPasswordEncryption()
+	require.NoError(t, err)
+
+	ssoRepo := newMockSSORepository()
+	redisService := redis.NewService(redis.RedisConfig{
+		URL:         fmt.Sprintf("redis://%s", mini.Addr()),
+		Environment: "test",
+	})
+
+	// Built-in roles are validated without a database
+	service := NewSSOService(ssoRepo, &ssoProjectRepository{projects: projects}, rbac.NewService(nil), redisService, encryption, "https://tms.example.com")
+	return service, ssoRepo, mini
+}
+
+// mockOIDCProvider is a minimal OpenID provider with discovery, JWKS and a token endpoint
+type mockOIDCProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	nonce    string
+	claims   jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T, clientID string) *mockOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &mockOIDCProvider{key: key, clientID: clientID}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test-key",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   idp.clientID,
+			"sub":   "idp-user-1",
+			"nonce": idp.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     signed,
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func TestSSOServiceOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	tenantID := uuid.New()
+	svc, _, _ := newTestSSOService(t, nil)
+	idp := newMockOIDCProvider(t, "tms-client")
+
+	provider, err := svc.CreateProvider(ctx, tenantID, SSOProviderRequest{
+		Name:         "Keycloak",
+		Protocol:     models.SSOProtocolOIDC,
+		IssuerURL:    idp.server.URL,
+		ClientID:     "tms-client",
+		ClientSecret: "s3cret",
+		EmailDomains: []string{"@Example.com"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com"}, []string(provider.EmailDomains))
+	require.NotEqual(t, "s3cret", *provider.ClientSecretEncrypted)
+
+	discovered, err := svc.DiscoverProviders(ctx, "jane@EXAMPLE.com")
+	require.NoError(t, err)
+	require.Len(t, discovered, 1)
+	require.Equal(t, svc.LoginURL(provider.ID), discovered[0].LoginURL)
+
+	beginLogin := func() string {
+		redirectURL, err := svc.BeginLogin(ctx, provider.ID)
+		require.NoError(t, err)
+		parsed, err := url.Parse(redirectURL)
+		require.NoError(t, err)
+		require.Equal(t, svc.CallbackURL(provider.ID), parsed.Query().Get("redirect_uri"))
+		idp.nonce = parsed.Query().Get("nonce")
+		return parsed.Query().Get("state")
+	}
+
+	idp.claims = jwt.MapClaims{"email": "Jane@Example.com", "email_verified": true, "name": "Jane Doe", "groups": []string{"/Support"}}
+	state := beginLogin()
+	identity, err := svc.CompleteOIDCLogin(ctx, provider.ID, state, "valid-code")
+	require.NoError(t, err)
+	require.Equal(t, "jane@example.com", identity.Email)
+	require.Equal(t, "Jane Doe", identity.Name)
+	require.Equal(t, "idp-user-1", identity.Subject)
+	require.Equal(t, []string{"/Support"}, identity.Groups)
+
+	// The state is single use
+	_, err = svc.CompleteOIDCLogin(ctx, provider.ID, state, "valid-code")
+	require.ErrorIs(t, err, ErrSSOStateInvalid)
+
+	// A token issued for another login request is rejected
+	state = beginLogin()
+	idp.nonce = "other-request"
+	_, err = svc.CompleteOIDCLogin(ctx, provider.ID, state, "valid-code")
+	require.ErrorIs(t, err, ErrSSOAuthFailed)
+
+	state = beginLogin()
+	idp.claims = jwt.MapClaims{"email": "jane@example.com", "email_verified": false}
+	_, err = svc.CompleteOIDCLogin(ctx, provider.ID, state, "valid-code")
+	require.ErrorIs(t, err, ErrSSOAuthFailed)
+
+	state = beginLogin()
+	idp.claims = jwt.MapClaims{"email": "mallory@other.com"}
+	_, err = svc.CompleteOIDCLogin(ctx, provider.ID, state, "valid-code")
+	require.ErrorIs(t, err, ErrSSOEmailNotAllowed)
+
+	state = beginLogin()
+	_, err = svc.CompleteOIDCLogin(ctx, provider.ID, state, "bad-code")
+	require.ErrorIs(t, err, ErrSSOAuthFailed)
+}
+
+func TestSSOServiceSAMLLogin(t *testing.T) {
+	ctx := context.Background()
+	tenantID := uuid.New()
+	svc, _, mini := newTestSSOService(t, nil)
+
+	keyStore := dsig.RandomKeyStoreForTest()
+	_, certDER, err := keyStore.GetKeyPair()
+	require.NoError(t, err)
+
+	provider, err := svc.CreateProvider(ctx, tenantID, SSOProviderRequest{
+		Name:           "Okta",
+		Protocol:       models.SSOProtocolSAML,
+		IDPEntityID:    "http://www.okta.com/exk1",
+		IDPSSOURL:      "https://example.okta.com/app/sso/saml",
+		IDPCertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})),
+		GroupsClaim:    "memberOf",
+	})
+	require.NoError(t, err)
+
+	beginLogin := func() (string, string) {
+		redirectURL, err := svc.BeginLogin(ctx, provider.ID)
+		require.NoError(t, err)
+		parsed, err := url.Parse(redirectURL)
+		require.NoError(t, err)
+		require.NotEmpty(t, parsed.Query().Get("SAMLRequest"))
+
+		relayState := parsed.Query().Get("RelayState")
+		raw, err := mini.Get(ssoStateKey(relayState))
+		require.NoError(t, err)
+		var state ssoState
+		require.NoError(t, json.Unmarshal([]byte(raw), &state))
+		return relayState, state.RequestID
+	}
+
+	signedResponse := func(inResponseTo, audience string) string {
+		now := time.Now().UTC()
+		doc := etree.NewDocument()
+		require.NoError(t, doc.ReadFromString(fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_response" Version="2.0" IssueInstant="%[1]s" Destination="%[2]s" InResponseTo="%[3]s">
+<saml:Issuer>http://www.okta.com/exk1</saml:Issuer>
+<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+<saml:Assertion ID="_assertion" Version="2.0" IssueInstant="%[1]s">
+<saml:Issuer>http://www.okta.com/exk1</saml:Issuer>
+<saml:Subject>
+<saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">Sam@Example.com</saml:NameID>
+<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"><saml:SubjectConfirmationData InResponseTo="%[3]s" NotOnOrAfter="%[4]s" Recipient="%[2]s"/></saml:SubjectConfirmation>
+</saml:Subject>
+<saml:Conditions NotBefore="%[5]s" NotOnOrAfter="%[4]s"><saml:AudienceRestriction><saml:Audience>%[6]s</saml:Audience></saml:AudienceRestriction></saml:Conditions>
+<saml:AuthnStatement AuthnInstant="%[1]s" SessionIndex="_session"><saml:AuthnContext><saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:Password</saml:AuthnContextClassRef></saml:AuthnContext></saml:AuthnStatement>
+<saml:AttributeStatement>
+<saml:Attribute Name="displayName"><saml:AttributeValue>Sam Smith</saml:AttributeValue></saml:Attribute>
+<saml:Attribute Name="memberOf"><saml:AttributeValue>support-leads</saml:AttributeValue><saml:AttributeValue>everyone</saml:AttributeValue></saml:Attribute>
+</saml:AttributeStatement>
+</saml:Assertion>
+</samlp:Response>`, now.Format(time.RFC3339), svc.ACSURL(provider.ID), inResponseTo,
+			now.Add(5*time.Minute).Format(time.RFC3339), now.Add(-time.Minute).Format(time.RFC3339), audience)))
+
+		signed, err := dsig.NewDefaultSigningContext(keyStore).SignEnveloped(doc.Root())
+		require.NoError(t, err)
+		doc.SetRoot(signed)
+		encoded, err := doc.WriteToString()
+		require.NoError(t, err)
+		return base64.StdEncoding.EncodeToString([]byte(encoded))
+	}
+
+	relayState, requestID := beginLogin()
+	identity, err := svc.CompleteSAMLLogin(ctx, provider.ID, relayState, signedResponse(requestID, svc.EntityID(provider.ID)))
+	require.NoError(t, err)
+	require.Equal(t, "sam@example.com", identity.Email)
+	require.Equal(t, "Sam Smith", identity.Name)
+	require.Equal(t, []string{"support-leads", "everyone"}, identity.Groups)
+
+	// Responses are accepted once
+	_, err = svc.CompleteSAMLLogin(ctx, provider.ID, relayState, signedResponse(requestID, svc.EntityID(provider.ID)))
+	require.ErrorIs(t, err, ErrSSOStateInvalid)
+
+	relayState, _ = beginLogin()
+	_, err = svc.CompleteSAMLLogin(ctx, provider.ID, relayState, signedResponse("_unsolicited", svc.EntityID(provider.ID)))
+	require.ErrorIs(t, err, ErrSSOAuthFailed)
+
+	relayState, requestID = beginLogin()
+	_, err = svc.CompleteSAMLLogin(ctx, provider.ID, relayState, signedResponse(requestID, "https://other-sp.example.com"))
+	require.ErrorIs(t, err, ErrSSOAuthFailed)
+
+	// A response signed by another key is rejected
+	keyStore = dsig.RandomKeyStoreForTest()
+	relayState, requestID = beginLogin()
+	_, err = svc.CompleteSAMLLogin(ctx, provider.ID, relayState, signedResponse(requestID, svc.EntityID(provider.ID)))
+	require.ErrorIs(t, err, ErrSSOAuthFailed)
+
+	metadata, err := svc.ServiceProviderMetadata(ctx, provider.ID)
+	require.NoError(t, err)
+	require.Contains(t, metadata, svc.ACSURL(provider.ID))
+}
+
+func TestSSOServiceResolveRoleAssignments(t *testing.T) {
+	ctx := context.Background()
+	tenantID := uuid.New()
+	support := &db.Project{ID: uuid.New(), TenantID: tenantID, Name: "Support"}
+	sales := &db.Project{ID: uuid.New(), TenantID: tenantID, Name: "Sales"}
+	svc, _, _ := newTestSSOService(t, []*db.Project{support, sales})
+
+	provider := &db.SSOProvider{
+		TenantID:         tenantID,
+		DefaultRole:      models.RoleAgent,
+		DefaultProjectID: &support.ID,
+		GroupMappings: models.SSOGroupMappings{
+			{Group: "Support-Leads", Role: models.RoleProjectAdmin, ProjectID: &support.ID},
+			{Group: "admins", Role: models.RoleTenantAdmin},
+			{Group: "everyone", Role: models.RoleAgent},
+		},
+	}
+
+	assignments, matched, err := svc.ResolveRoleAssignments(ctx, provider, []string{"/support-leads", "everyone"})
+	require.NoError(t, err)
+	require.True(t, matched)
+	require.ElementsMatch(t, []RoleAssignment{
+		{ProjectID: support.ID, Role: models.RoleProjectAdmin},
+		{ProjectID: sales.ID, Role: models.RoleAgent},
+	}, assignments)
+
+	assignments, matched, err = svc.ResolveRoleAssignments(ctx, provider, []string{"Admins", "support-leads"})
+	require.NoError(t, err)
+	require.True(t, matched)
+	require.ElementsMatch(t, []RoleAssignment{
+		{ProjectID: support.ID, Role: models.RoleProjectAdmin},
+		{ProjectID: sales.ID, Role: models.RoleTenantAdmin},
+	}, assignments)
+
+	// Without a matching group the default role applies to the default project
+	assignments, matched, err = svc.ResolveRoleAssignments(ctx, provider, []string{"contractors"})
+	require.NoError(t, err)
+	require.False(t, matched)
+	require.Equal(t, []RoleAssignment{{ProjectID: support.ID, Role: models.RoleAgent}}, assignments)
+
+	// Mappings may only target projects of the tenant
+	otherProject := uuid.New()
+	_, err = svc.CreateProvider(ctx, tenantID, SSOProviderRequest{
+		Name:          "Azure AD",
+		Protocol:      models.SSOProtocolOIDC,
+		IssuerURL:     "https://login.microsoftonline.com/tenant/v2.0",
+		ClientID:      "client",
+		ClientSecret:  "secret",
+		GroupMappings: models.SSOGroupMappings{{Group: "ops", Role: models.RoleAgent, ProjectID: &otherProject}},
+	})
+	require.ErrorIs(t, err, ErrSSOInvalidProvider)
+}
+
+func TestSSOServicePasswordLoginSwitch(t *testing.T) {
+	ctx := context.Background()
+	tenantID := uuid.New()
+	svc, _, _ := newTestSSOService(t, nil)
+
+	require.ErrorIs(t, svc.SetPasswordLoginDisabled(ctx, tenantID, true), ErrSSONoEnabledProvider)
+
+	provider, err := svc.CreateProvider(ctx, tenantID, SSOProviderRequest{
+		Name:         "Keycloak",
+		Protocol:     models.SSOProtocolOIDC,
+		IssuerURL:    "https://sso.example.com/realms/acme",
+		ClientID:     "tms",
+		ClientSecret: "secret",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.SetPasswordLoginDisabled(ctx, tenantID, true))
+	allowed, err := svc.PasswordLoginAllowed(ctx, tenantID)
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	// The last provider cannot be disabled or removed while it is the only way in
+	disabled := false
+	_, err = svc.UpdateProvider(ctx, tenantID, provider.ID, SSOProviderRequest{
+		Name:      "Keycloak",
+		Protocol:  models.SSOProtocolOIDC,
+		Enabled:   &disabled,
+		IssuerURL: "https://sso.example.com/realms/acme",
+		ClientID:  "tms",
+	})
+	require.ErrorIs(t, err, ErrSSOLastProvider)
+	require.ErrorIs(t, svc.DeleteProvider(ctx, tenantID, provider.ID), ErrSSOLastProvider)
+
+	require.NoError(t, svc.SetPasswordLoginDisabled(ctx, tenantID, false))
+	require.NoError(t, svc.DeleteProvider(ctx, tenantID, provider.ID))
+}
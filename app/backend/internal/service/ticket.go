@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"time"
 
 	"github.com/bareuptime/tms/internal/db"
 	"github.com/bareuptime/tms/internal/mail"
+	"github.com/bareuptime/tms/internal/models"
 	"github.com/bareuptime/tms/internal/rbac"
 	"github.com/bareuptime/tms/internal/repo"
 	"github.com/bareuptime/tms/internal/util"
@@ -25,6 +27,14 @@ type TicketService struct {
 	publicService   *PublicService
 	emailProvider   EmailProvider
 	publicTicketUrl string
+	triager         TicketTriager
+	redactor        MessageRedactor
+	translator      MessageTranslator
+}
+
+// TicketTriager classifies tickets customers open
+type TicketTriager interface {
+	TriageNewTicket(ticket *db.Ticket, firstMessage string)
 }
 
 // TicketWithDetails represents a ticket with populated customer and agent details
@@ -73,6 +83,21 @@ func NewTicketService(
 	}
 }
 
+// SetTriager sets the triager that classifies tickets customers open
+func (s *TicketService) SetTriager(triager TicketTriager) {
+	s.triager = triager
+}
+
+// SetMessageRedactor sets the redactor that masks personal data in the first messages of tickets before they are stored
+func (s *TicketService) SetMessageRedactor(redactor MessageRedactor) {
+	s.redactor = redactor
+}
+
+// SetTranslator sets the translator that translates agent replies into the customer's language
+func (s *TicketService) SetTranslator(translator MessageTranslator) {
+	s.translator = translator
+}
+
 // populateTicketURL sets the TicketURL field based on configured host
 func (s *TicketService) populateTicketURL(ticket *db.Ticket) {
 	if ticket == nil {
@@ -84,6 +109,15 @@ func (s *TicketService) populateTicketURL(ticket *db.Ticket) {
 	ticket.TicketURL = &url
 }
 
+// CustomerMagicLink returns a link that opens the ticket for its customer without signing in
+func (s *TicketService) CustomerMagicLink(ticket *db.Ticket) (string, error) {
+	magicToken, err := s.publicService.GenerateMagicLinkToken(ticket.ID, ticket.CustomerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate magic link: %w", err)
+	}
+	return fmt.Sprintf("%s/tickets/%s?token=%s", s.publicTicketUrl, ticket.ID, url.QueryEscape(magicToken)), nil
+}
+
 // CreateTicketRequest represents a ticket creation request
 type CreateTicketRequest struct {
 	Subject         string  `json:"subject" validate:"required,min=1,max=500"`
@@ -94,15 +128,41 @@ type CreateTicketRequest struct {
 	RequesterName   string  `json:"requester_name" validate:"required,min=1,max=255"`
 	InitialMessage  string  `json:"initial_message" validate:"required"`
 	AssigneeAgentID *string `json:"assignee_agent_id,omitempty"`
+
+	// SkipCustomerNotification suppresses the "ticket created" email to the requester,
+	// for callers that send their own (e.g. chat conversion)
+	SkipCustomerNotification bool `json:"-"`
 }
 
+// TicketInserter stores a new ticket together with its first message. It reports false, storing nothing,
+// when the ticket must not be created after all.
+type TicketInserter func(ctx context.Context, ticket *db.Ticket, message *db.TicketMessage) (bool, error)
+
 // CreateTicket creates a new ticket
 func (s *TicketService) CreateTicket(ctx context.Context, tenantID, projectID, agentID uuid.UUID, req CreateTicketRequest) (*db.Ticket, error) {
+	ticket, _, err := s.CreateTicketWith(ctx, tenantID, projectID, agentID, req, s.insertTicket)
+	return ticket, err
+}
+
+// insertTicket is the TicketInserter of CreateTicket
+func (s *TicketService) insertTicket(ctx context.Context, ticket *db.Ticket, message *db.TicketMessage) (bool, error) {
+	if err := s.ticketRepo.Create(ctx, ticket); err != nil {
+		return false, fmt.Errorf("failed to create ticket: %w", err)
+	}
+	if err := s.messageRepo.Create(ctx, message); err != nil {
+		return false, fmt.Errorf("failed to create initial message: %w", err)
+	}
+	return true, nil
+}
+
+// CreateTicketWith creates a new ticket, storing it through insert so callers can create it in the same
+// transaction as their own changes. It reports false, without a ticket, when insert declined.
+func (s *TicketService) CreateTicketWith(ctx context.Context, tenantID, projectID, agentID uuid.UUID, req CreateTicketRequest, insert TicketInserter) (*db.Ticket, bool, error) {
 	// Find customer by email. The repo returns (nil, nil) when not found,
 	// so handle that case explicitly. If the repo returns an error, fail.
 	customer, err := s.customerRepo.GetByEmail(ctx, tenantID, req.RequesterEmail)
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup customer: %w", err)
+		return nil, false, fmt.Errorf("failed to lookup customer: %w", err)
 	}
 
 	// If customer doesn't exist, create one
@@ -114,7 +174,7 @@ func (s *TicketService) CreateTicket(ctx context.Context, tenantID, projectID, a
 			Name:     req.RequesterName,
 		}
 		if err := s.customerRepo.Create(ctx, customer); err != nil {
-			return nil, fmt.Errorf("failed to create customer: %w", err)
+			return nil, false, fmt.Errorf("failed to create customer: %w", err)
 		}
 	}
 
@@ -135,16 +195,11 @@ func (s *TicketService) CreateTicket(ctx context.Context, tenantID, projectID, a
 	if req.AssigneeAgentID != nil {
 		assigneeID, err := uuid.Parse(*req.AssigneeAgentID)
 		if err != nil {
-			return nil, fmt.Errorf("invalid assignee agent ID")
+			return nil, false, fmt.Errorf("invalid assignee agent ID")
 		}
 		ticket.AssigneeAgentID = &assigneeID
 	}
 
-	err = s.ticketRepo.Create(ctx, ticket)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create ticket: %w", err)
-	}
-
 	// Create initial message
 	initialMessage := &db.TicketMessage{
 		ID:         uuid.New(),
@@ -157,21 +212,31 @@ func (s *TicketService) CreateTicket(ctx context.Context, tenantID, projectID, a
 		IsPrivate:  false,
 		CreatedAt:  time.Now(),
 	}
+	if s.redactor != nil {
+		initialMessage.Body = s.redactor.RedactStoredMessage(ctx, tenantID, projectID, nil, &ticket.ID, initialMessage.Body)
+	}
 
-	err = s.messageRepo.Create(ctx, initialMessage)
+	inserted, err := insert(ctx, ticket, initialMessage)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create initial message: %w", err)
+		return nil, false, err
+	}
+	if !inserted {
+		return nil, false, nil
 	}
 
 	// Send email notifications asynchronously
 	go func() {
-		s.sendTicketCreatedNotifications(context.Background(), ticket, customer)
+		s.sendTicketCreatedNotifications(context.Background(), ticket, customer, !req.SkipCustomerNotification)
 	}()
 
+	if s.triager != nil {
+		s.triager.TriageNewTicket(ticket, req.InitialMessage)
+	}
+
 	// populate URL for API responses
 	s.populateTicketURL(ticket)
 
-	return ticket, nil
+	return ticket, true, nil
 }
 
 // UpdateTicketRequest represents a ticket update request
@@ -444,10 +509,28 @@ func (s *TicketService) AddMessage(ctx context.Context, tenantID, projectID, tic
 		CreatedAt:  time.Now(),
 	}
 
+	// Replies are stored and sent in the language the customer writes in; the agent's original is kept
+	// as the message's translation
+	var reply *models.ReplyTranslation
+	if !req.IsPrivate && s.translator != nil {
+		reply, err = s.translator.TranslateReply(ctx, tenantID, projectID, models.MessageKindTicket, ticketID, &agentID, req.Body)
+		if err != nil {
+			log.Printf("Failed to translate reply on ticket %s, sending it untranslated: %v", ticketID, err)
+		}
+		if reply != nil {
+			message.Body = reply.Text
+		}
+	}
+
 	err = s.messageRepo.Create(ctx, message)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create message: %w", err)
 	}
+	if reply != nil {
+		if err := s.translator.RecordReply(ctx, tenantID, projectID, models.MessageKindTicket, message.ID, ticketID, reply); err != nil {
+			log.Printf("Failed to save original of message %s on ticket %s: %v", message.ID, ticketID, err)
+		}
+	}
 
 	// If this is not a private message, send notifications
 	if !req.IsPrivate {
@@ -456,7 +539,7 @@ func (s *TicketService) AddMessage(ctx context.Context, tenantID, projectID, tic
 		if err == nil {
 			// Send notification asynchronously
 			go func() {
-				s.sendTicketUpdatedNotifications(context.Background(), ticket, "New Message", req.Body)
+				s.sendTicketUpdatedNotifications(context.Background(), ticket, "New Message", message.Body)
 			}()
 		}
 	}
@@ -690,13 +773,15 @@ func (s *TicketService) DeleteTicket(ctx context.Context, tenantID, projectID, t
 }
 
 // sendTicketCreatedNotifications sends email notifications when a ticket is created
-func (s *TicketService) sendTicketCreatedNotifications(ctx context.Context, ticket *db.Ticket, customer *db.Customer) {
+func (s *TicketService) sendTicketCreatedNotifications(ctx context.Context, ticket *db.Ticket, customer *db.Customer, notifyCustomer bool) {
 	// Send notification to customer
-	err := s.emailProvider.SendTicketCreatedNotification(ctx, ticket, customer, customer.Email, customer.Name, "customer")
-	if err != nil {
-		log.Printf("Failed to send ticket created notification to customer %s: %v", customer.Email, err)
-	} else {
-		log.Printf("Sent ticket created notification to customer: %s", customer.Email)
+	if notifyCustomer {
+		err := s.emailProvider.SendTicketCreatedNotification(ctx, ticket, customer, customer.Email, customer.Name, "customer")
+		if err != nil {
+			log.Printf("Failed to send ticket created notification to customer %s: %v", customer.Email, err)
+		} else {
+			log.Printf("Sent ticket created notification to customer: %s", customer.Email)
+		}
 	}
 
 	// Send notification to tenant admins
@@ -0,0 +1,529 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+	"github.com/bareuptime/tms/internal/repo"
+)
+
+var (
+	ErrTicketTriageNotFound   = errors.New("ticket not found")
+	ErrTicketTriageNotTriaged = errors.New("ticket has not been triaged yet")
+)
+
+const (
+	defaultTriageAutoApplyConfidence = 0.85
+	defaultTriageSuggestConfidence   = 0.5
+	maxHeuristicTriageConfidence     = 0.7 // keyword heuristics alone never reach the default auto-apply threshold
+	triageUrgencyThreshold           = 0.3 // lower than the chat handoff threshold so milder urgency still counts
+	ticketTriageTimeout              = 60 * time.Second
+	maxTicketTriageText              = 8000
+	maxTicketTriageMessages          = 5
+	maxTicketTriageTags              = 5
+	maxTicketTriageTagLength         = 50
+	defaultTicketTriageListLimit     = 50
+	maxTicketTriageListLimit         = 200
+)
+
+const ticketTriagePrompt = `You triage new customer support tickets. Classify the ticket below.
+
+- type: "question" (asks for information), "incident" (something is broken for this customer), "problem" (an underlying issue affecting many customers) or "task" (asks the team to do something)
+- priority: "low", "normal", "high" or "urgent"
+- tags: up to 5 short lowercase tags for the topic
+- language: ISO 639-1 code of the customer's language
+- sentiment: "positive", "neutral" or "negative"
+- urgency: "low", "normal", "high" or "critical"
+- type_confidence and priority_confidence: how sure you are, from 0 to 1
+
+Keyword heuristics suggest type %q and priority %q; overrule them when the ticket says otherwise.
+
+Respond with JSON only, in the form {"type": "", "type_confidence": 0, "priority": "", "priority_confidence": 0, "tags": [], "language": "", "sentiment": "", "urgency": ""}.`
+
+// ticketTriageSources are the channels customers open tickets through; agents set type and priority
+// themselves on the others
+var ticketTriageSources = map[string]bool{"email": true, "web": true, "chat": true}
+
+var (
+	ticketTriageTypes      = map[string]bool{"question": true, "incident": true, "problem": true, "task": true}
+	ticketTriagePriorities = map[string]bool{"low": true, "normal": true, "high": true, "urgent": true}
+	ticketTriageSentiments = map[string]bool{"positive": true, "neutral": true, "negative": true}
+	ticketTriageUrgencies  = map[string]bool{"low": true, "normal": true, "high": true, "critical": true}
+)
+
+var (
+	ticketTriagePositivePattern = regexp.MustCompile(`\b(?:thanks|thank\s+you|great|love|awesome|appreciate)\b`)
+	ticketTriageLanguagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+	ticketTriageTagPattern      = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+)
+
+type ticketTriageStore interface {
+	Create(ctx context.Context, triage *models.TicketTriage) error
+	GetLatest(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*models.TicketTriage, error)
+	List(ctx context.Context, tenantID, projectID uuid.UUID, reviewStatus string, limit int) ([]*models.TicketTriage, error)
+	SaveReview(ctx context.Context, triage *models.TicketTriage) error
+	Accuracy(ctx context.Context, tenantID, projectID uuid.UUID, since time.Time) (*models.TicketTriageAccuracy, error)
+}
+
+type ticketTriageTickets interface {
+	GetByTenantAndProjectID(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*db.Ticket, error)
+	Update(ctx context.Context, ticket *db.Ticket) error
+	ApplyTriage(ctx context.Context, ticket *db.Ticket, previousType, previousPriority string) (bool, error)
+}
+
+type ticketTriageSettings interface {
+	GetSetting(ctx context.Context, tenantID, projectID uuid.UUID, settingKey string) (map[string]interface{}, int, error)
+}
+
+// ticketTriageConfig is a project's triage configuration from its automation settings
+type ticketTriageConfig struct {
+	enabled   bool
+	autoApply float64
+	suggest   float64
+}
+
+// TicketTriageService classifies new tickets by type, priority, tags, language and sentiment. Keyword
+// heuristics give a first guess that an LLM pass refines when the tenant has AI credits. Type and priority
+// are applied to the ticket or only suggested depending on the project's confidence thresholds, and every
+// decision is stored so agents can review how accurate triage is.
+type TicketTriageService struct {
+	store         ticketTriageStore
+	tickets       ticketTriageTickets
+	messages      aiAssistTicketMessages
+	settings      ticketTriageSettings
+	ai            *AIService
+	questions     *QuestionClassificationService
+	agentRequests *AgentRequestDetectionService
+}
+
+// NewTicketTriageService creates a new ticket triage service. The heuristics run whatever the agentic chat
+// features are set to.
+func NewTicketTriageService(store ticketTriageStore, tickets ticketTriageTickets, messages aiAssistTicketMessages, settings ticketTriageSettings, ai *AIService, agentic *config.AgenticConfig) *TicketTriageService {
+	heuristics := config.AgenticConfig{}
+	if agentic != nil {
+		heuristics = *agentic
+	}
+	heuristics.Enabled = true
+	heuristics.KnowledgeResponses = true
+	heuristics.AgentRequestDetection = true
+	heuristics.AgentRequestThreshold = triageUrgencyThreshold
+
+	return &TicketTriageService{
+		store:         store,
+		tickets:       tickets,
+		messages:      messages,
+		settings:      settings,
+		ai:            ai,
+		questions:     NewQuestionClassificationService(&heuristics),
+		agentRequests: NewAgentRequestDetectionService(&heuristics),
+	}
+}
+
+// TriageNewTicket triages a ticket a customer just opened, in the background, when the project has
+// enabled AI triage
+func (s *TicketTriageService) TriageNewTicket(ticket *db.Ticket, firstMessage string) {
+	if ticket == nil || !ticketTriageSources[ticket.Source] {
+		return
+	}
+	tenantID, projectID, ticketID := ticket.TenantID, ticket.ProjectID, ticket.ID
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ticketTriageTimeout)
+		defer cancel()
+
+		cfg := s.triageConfig(ctx, tenantID, projectID)
+		if !cfg.enabled {
+			return
+		}
+		// Reload the ticket; the caller keeps using the one it created
+		current, err := s.tickets.GetByTenantAndProjectID(ctx, tenantID, projectID, ticketID)
+		if err != nil || current == nil {
+			fmt.Printf("Failed to load ticket %s for triage: %v\n", ticketID, err)
+			return
+		}
+		if _, err := s.triage(ctx, current, firstMessage, cfg); err != nil {
+			fmt.Printf("Failed to triage ticket %s: %v\n", ticketID, err)
+		}
+	}()
+}
+
+// TriageTicket triages a ticket on request, from its first customer messages
+func (s *TicketTriageService) TriageTicket(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*models.TicketTriage, error) {
+	ticket, err := s.tickets.GetByTenantAndProjectID(ctx, tenantID, projectID, ticketID)
+	if err != nil || ticket == nil {
+		return nil, ErrTicketTriageNotFound
+	}
+
+	messages, _, err := s.messages.GetByTenantProjectAndTicketID(ctx, tenantID, projectID, ticketID, false, repo.PaginationParams{Limit: maxAIAssistTicketMessages})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ticket messages: %w", err)
+	}
+	var customerText []string
+	for _, message := range messages {
+		if message.AuthorType == "customer" && len(customerText) < maxTicketTriageMessages {
+			customerText = append(customerText, strings.TrimSpace(message.Body))
+		}
+	}
+
+	return s.triage(ctx, ticket, strings.Join(customerText, "\n\n"), s.triageConfig(ctx, tenantID, projectID))
+}
+
+// GetLatestTriage returns the most recent triage decision of a ticket
+func (s *TicketTriageService) GetLatestTriage(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*models.TicketTriage, error) {
+	triage, err := s.store.GetLatest(ctx, tenantID, projectID, ticketID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ticket triage: %w", err)
+	}
+	if triage == nil {
+		return nil, ErrTicketTriageNotTriaged
+	}
+	return triage, nil
+}
+
+// ListTriage lists a project's triage decisions, newest first, optionally only those with a review status
+func (s *TicketTriageService) ListTriage(ctx context.Context, tenantID, projectID uuid.UUID, reviewStatus string, limit int) ([]*models.TicketTriage, error) {
+	if limit <= 0 {
+		limit = defaultTicketTriageListLimit
+	}
+	if limit > maxTicketTriageListLimit {
+		limit = maxTicketTriageListLimit
+	}
+	return s.store.List(ctx, tenantID, projectID, reviewStatus, limit)
+}
+
+// ReviewTriage records an agent's verdict on the latest triage decision of a ticket and applies the
+// reviewed type and priority to the ticket
+func (s *TicketTriageService) ReviewTriage(ctx context.Context, tenantID, projectID, ticketID, agentID uuid.UUID, req *models.TicketTriageReviewRequest) (*models.TicketTriage, error) {
+	ticket, err := s.tickets.GetByTenantAndProjectID(ctx, tenantID, projectID, ticketID)
+	if err != nil || ticket == nil {
+		return nil, ErrTicketTriageNotFound
+	}
+	triage, err := s.GetLatestTriage(ctx, tenantID, projectID, ticketID)
+	if err != nil {
+		return nil, err
+	}
+
+	reviewedType, reviewedPriority, reviewedTags := triage.Type, triage.Priority, triage.Tags
+	if req.Type != nil {
+		reviewedType = *req.Type
+	}
+	if req.Priority != nil {
+		reviewedPriority = *req.Priority
+	}
+	if req.Tags != nil {
+		reviewedTags = normalizeTriageTags(req.Tags)
+	}
+
+	now := time.Now()
+	triage.ReviewStatus = models.TicketTriageAccepted
+	if reviewedType != triage.Type || reviewedPriority != triage.Priority || !sameTriageTags(reviewedTags, triage.Tags) {
+		triage.ReviewStatus = models.TicketTriageCorrected
+	}
+	triage.ReviewedType = &reviewedType
+	triage.ReviewedPriority = &reviewedPriority
+	triage.ReviewedTags = reviewedTags
+	triage.ReviewedBy = &agentID
+	triage.ReviewedAt = &now
+
+	if ticket.Type != reviewedType || ticket.Priority != reviewedPriority {
+		ticket.Type = reviewedType
+		ticket.Priority = reviewedPriority
+		ticket.UpdatedAt = now
+		if err := s.tickets.Update(ctx, ticket); err != nil {
+			return nil, fmt.Errorf("failed to update ticket: %w", err)
+		}
+	}
+	if err := s.store.SaveReview(ctx, triage); err != nil {
+		return nil, fmt.Errorf("failed to save triage review: %w", err)
+	}
+	return triage, nil
+}
+
+// Accuracy reports how often reviewers agreed with a project's triage decisions since a time
+func (s *TicketTriageService) Accuracy(ctx context.Context, tenantID, projectID uuid.UUID, since time.Time) (*models.TicketTriageAccuracy, error) {
+	accuracy, err := s.store.Accuracy(ctx, tenantID, projectID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get triage accuracy: %w", err)
+	}
+	for _, field := range []*models.TicketTriageFieldAccuracy{&accuracy.Type, &accuracy.Priority} {
+		if field.Reviewed > 0 {
+			field.Accuracy = float64(field.Correct) / float64(field.Reviewed)
+		}
+		if field.AutoApplied > 0 {
+			field.AppliedAccuracy = float64(field.AutoAppliedCorrect) / float64(field.AutoApplied)
+		}
+	}
+	return accuracy, nil
+}
+
+// triage classifies a ticket, applies or suggests its type and priority and stores the decision
+func (s *TicketTriageService) triage(ctx context.Context, ticket *db.Ticket, text string, cfg ticketTriageConfig) (*models.TicketTriage, error) {
+	content := truncateRunes(strings.TrimSpace(ticket.Subject+"\n\n"+text), maxTicketTriageText)
+
+	triage := s.heuristicTriage(ctx, content)
+	if s.llmAvailable(ctx, ticket.TenantID) {
+		if err := s.llmTriage(ctx, ticket, content, triage); err != nil {
+			fmt.Printf("LLM triage of ticket %s failed, keeping heuristics: %v\n", ticket.ID, err)
+		}
+	}
+
+	triage.TenantID = ticket.TenantID
+	triage.ProjectID = ticket.ProjectID
+	triage.TicketID = ticket.ID
+	triage.PreviousType = ticket.Type
+	triage.PreviousPriority = ticket.Priority
+	triage.AppliedFields = pq.StringArray{}
+	triage.SuggestedFields = pq.StringArray{}
+	triage.ReviewStatus = models.TicketTriagePending
+
+	if triage.Type != ticket.Type {
+		if triage.TypeConfidence >= cfg.autoApply {
+			ticket.Type = triage.Type
+			triage.AppliedFields = append(triage.AppliedFields, models.TicketTriageFieldType)
+		} else if triage.TypeConfidence >= cfg.suggest {
+			triage.SuggestedFields = append(triage.SuggestedFields, models.TicketTriageFieldType)
+		}
+	}
+	if triage.Priority != ticket.Priority {
+		if triage.PriorityConfidence >= cfg.autoApply {
+			ticket.Priority = triage.Priority
+			triage.AppliedFields = append(triage.AppliedFields, models.TicketTriageFieldPriority)
+		} else if triage.PriorityConfidence >= cfg.suggest {
+			triage.SuggestedFields = append(triage.SuggestedFields, models.TicketTriageFieldPriority)
+		}
+	}
+
+	if len(triage.AppliedFields) > 0 {
+		applied, err := s.tickets.ApplyTriage(ctx, ticket, triage.PreviousType, triage.PreviousPriority)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update ticket: %w", err)
+		}
+		if applied {
+			ticket.UpdatedAt = time.Now()
+		} else {
+			// An agent set type or priority while triage ran; their choice stands and triage only suggests
+			ticket.Type, ticket.Priority = triage.PreviousType, triage.PreviousPriority
+			triage.SuggestedFields = append(triage.SuggestedFields, triage.AppliedFields...)
+			triage.AppliedFields = pq.StringArray{}
+		}
+	}
+	if err := s.store.Create(ctx, triage); err != nil {
+		return nil, fmt.Errorf("failed to store ticket triage: %w", err)
+	}
+	return triage, nil
+}
+
+// heuristicTriage classifies a ticket with the keyword heuristics used for chat messages
+func (s *TicketTriageService) heuristicTriage(ctx context.Context, content string) *models.TicketTriage {
+	classification := s.questions.ClassifyQuestion(ctx, content)
+	request, err := s.agentRequests.DetectAgentRequest(ctx, content)
+	if err != nil || request == nil {
+		request = &AgentRequestResult{Urgency: UrgencyLow, RequestType: AgentRequestTypeGeneral}
+	}
+
+	triage := &models.TicketTriage{
+		Method:    models.TicketTriageMethodHeuristic,
+		Type:      "question",
+		Priority:  "normal",
+		Sentiment: "neutral",
+		Urgency:   string(UrgencyNormal),
+	}
+	if request.IsAgentRequest {
+		triage.Urgency = string(request.Urgency)
+		if request.Urgency == UrgencyLow {
+			triage.Urgency = string(UrgencyNormal)
+		}
+	}
+
+	switch {
+	case request.Urgency == UrgencyCritical:
+		triage.Type = "incident"
+	case classification.QuestionType == QuestionTypeTroubleshooting:
+		triage.Type = "incident"
+	case classification.QuestionType == QuestionTypeRequest:
+		triage.Type = "task"
+	}
+	triage.TypeConfidence = math.Min(classification.Confidence, maxHeuristicTriageConfidence)
+
+	switch request.Urgency {
+	case UrgencyCritical:
+		triage.Priority = "urgent"
+	case UrgencyHigh:
+		triage.Priority = "high"
+	}
+	triage.PriorityConfidence = defaultTriageSuggestConfidence
+	if triage.Priority != "normal" {
+		triage.PriorityConfidence = math.Min(request.Confidence, maxHeuristicTriageConfidence)
+	}
+
+	complaint := classification.Intent == IntentComplaint || request.RequestType == AgentRequestTypeComplaint
+	for _, keyword := range request.Keywords {
+		if keyword == "complaint" {
+			complaint = true
+		}
+	}
+	if complaint {
+		triage.Sentiment = "negative"
+	} else if ticketTriagePositivePattern.MatchString(strings.ToLower(content)) {
+		triage.Sentiment = "positive"
+	}
+
+	var tags []string
+	if classification.Domain != DomainGeneral && classification.Domain != "" {
+		tags = append(tags, string(classification.Domain))
+	}
+	if request.RequestType != AgentRequestTypeGeneral && request.RequestType != "" {
+		tags = append(tags, string(request.RequestType))
+	}
+	if complaint {
+		tags = append(tags, "complaint")
+	}
+	triage.Tags = normalizeTriageTags(tags)
+	return triage
+}
+
+// llmTriage refines a heuristic triage with the project's AI model. Values the model gets wrong are left
+// as the heuristics had them.
+func (s *TicketTriageService) llmTriage(ctx context.Context, ticket *db.Ticket, content string, triage *models.TicketTriage) error {
+	ctx = s.ai.guardedContext(ctx, ticket.TenantID, ticket.ProjectID, nil, &ticket.ID)
+	profile := s.ai.assistProfile(ctx, ticket.TenantID, ticket.ProjectID, nil)
+	completion, err := s.ai.completeWithProfile(ctx, profile, []ChatCompletionMessage{
+		{Role: "system", Content: fmt.Sprintf(ticketTriagePrompt, triage.Type, triage.Priority)},
+		{Role: "user", Content: content},
+	}, nil, nil)
+	if err != nil {
+		return err
+	}
+	s.ai.deductAssistUsage(ctx, ticket.TenantID, ticket.ProjectID, nil, "ticket_triage", completion)
+
+	var result struct {
+		Type               string   `json:"type"`
+		TypeConfidence     float64  `json:"type_confidence"`
+		Priority           string   `json:"priority"`
+		PriorityConfidence float64  `json:"priority_confidence"`
+		Tags               []string `json:"tags"`
+		Language           string   `json:"language"`
+		Sentiment          string   `json:"sentiment"`
+		Urgency            string   `json:"urgency"`
+	}
+	if err := json.Unmarshal([]byte(stripMarkdownCodeBlocks(completion.Content)), &result); err != nil {
+		return fmt.Errorf("invalid triage response: %w", err)
+	}
+
+	triage.Method = models.TicketTriageMethodLLM
+	triage.Model = &profile.Model
+	if value := strings.ToLower(strings.TrimSpace(result.Type)); ticketTriageTypes[value] {
+		triage.Type = value
+		triage.TypeConfidence = clampTriageConfidence(result.TypeConfidence)
+	}
+	if value := strings.ToLower(strings.TrimSpace(result.Priority)); ticketTriagePriorities[value] {
+		triage.Priority = value
+		triage.PriorityConfidence = clampTriageConfidence(result.PriorityConfidence)
+	}
+	if tags := normalizeTriageTags(result.Tags); len(tags) > 0 {
+		triage.Tags = tags
+	}
+	if value := strings.ToLower(strings.TrimSpace(result.Language)); ticketTriageLanguagePattern.MatchString(value) {
+		triage.Language = &value
+	}
+	if value := strings.ToLower(strings.TrimSpace(result.Sentiment)); ticketTriageSentiments[value] {
+		triage.Sentiment = value
+	}
+	if value := strings.ToLower(strings.TrimSpace(result.Urgency)); ticketTriageUrgencies[value] {
+		triage.Urgency = value
+	}
+	return nil
+}
+
+// llmAvailable reports whether triage may call the AI model and bill the tenant for it
+func (s *TicketTriageService) llmAvailable(ctx context.Context, tenantID uuid.UUID) bool {
+	if s.ai == nil || !s.ai.IsEnabled() {
+		return false
+	}
+	if s.ai.usageService != nil {
+		hasCredits, err := s.ai.usageService.HasAvailableCredits(ctx, tenantID)
+		if err != nil || !hasCredits {
+			return false
+		}
+	}
+	return true
+}
+
+// triageConfig reads a project's triage settings from its automation settings
+func (s *TicketTriageService) triageConfig(ctx context.Context, tenantID, projectID uuid.UUID) ticketTriageConfig {
+	cfg := ticketTriageConfig{
+		autoApply: defaultTriageAutoApplyConfidence,
+		suggest:   defaultTriageSuggestConfidence,
+	}
+	if s.settings == nil {
+		return cfg
+	}
+	settings, _, err := s.settings.GetSetting(ctx, tenantID, projectID, "automation_settings")
+	if err != nil {
+		return cfg
+	}
+
+	if enabled, ok := settings["enable_ai_triage"].(bool); ok {
+		cfg.enabled = enabled
+	}
+	if value, ok := settings["ai_triage_auto_apply_confidence"].(float64); ok && value > 0 && value <= 1 {
+		cfg.autoApply = value
+	}
+	if value, ok := settings["ai_triage_suggest_confidence"].(float64); ok && value > 0 && value <= 1 {
+		cfg.suggest = value
+	}
+	return cfg
+}
+
+// normalizeTriageTags lowercases tags, joins their words with dashes and drops duplicates
+func normalizeTriageTags(tags []string) pq.StringArray {
+	normalized := pq.StringArray{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.Trim(ticketTriageTagPattern.ReplaceAllString(strings.ToLower(tag), "-"), "-")
+		if runes := []rune(tag); len(runes) > maxTicketTriageTagLength {
+			tag = strings.TrimRight(string(runes[:maxTicketTriageTagLength]), "-")
+		}
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+		if len(normalized) == maxTicketTriageTags {
+			break
+		}
+	}
+	return normalized
+}
+
+func sameTriageTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := map[string]bool{}
+	for _, tag := range a {
+		set[tag] = true
+	}
+	for _, tag := range b {
+		if !set[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+func clampTriageConfidence(confidence float64) float64 {
+	return math.Max(0, math.Min(confidence, 1))
+}
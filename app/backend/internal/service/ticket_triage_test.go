@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/config"
+	"github.com/bareuptime/tms/internal/db"
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeTicketTriageStore struct {
+	decisions []*models.TicketTriage
+	reviewed  *models.TicketTriage
+}
+
+func (f *fakeTicketTriageStore) Create(ctx context.Context, triage *models.TicketTriage) error {
+	triage.ID = uuid.New()
+	triage.CreatedAt = time.Now()
+	f.decisions = append(f.decisions, triage)
+	return nil
+}
+
+func (f *fakeTicketTriageStore) GetLatest(ctx context.Context, tenantID, projectID, ticketID uuid.UUID) (*models.TicketTriage, error) {
+	for i := len(f.decisions) - 1; i >= 0; i-- {
+		if f.decisions[i].TicketID == ticketID {
+			return f.decisions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeTicketTriageStore) List(ctx context.Context, tenantID, projectID uuid.UUID, reviewStatus string, limit int) ([]*models.TicketTriage, error) {
+	return f.decisions, nil
+}
+
+func (f *fakeTicketTriageStore) SaveReview(ctx context.Context, triage *models.TicketTriage) error {
+	f.reviewed = triage
+	return nil
+}
+
+func (f *fakeTicketTriageStore) Accuracy(ctx context.Context, tenantID, projectID uuid.UUID, since time.Time) (*models.TicketTriageAccuracy, error) {
+	return &models.TicketTriageAccuracy{Since: since}, nil
+}
+
+type fakeTicketTriageTickets struct {
+	fakeAIAssistTickets
+	updates          int
+	changedMeanwhile bool // an agent changes type or priority while triage runs
+}
+
+func (f *fakeTicketTriageTickets) Update(ctx context.Context, ticket *db.Ticket) error {
+	f.updates++
+	f.tickets[ticket.ID] = ticket
+	return nil
+}
+
+func (f *fakeTicketTriageTickets) ApplyTriage(ctx context.Context, ticket *db.Ticket, previousType, previousPriority string) (bool, error) {
+	if f.changedMeanwhile {
+		return false, nil
+	}
+	f.updates++
+	f.tickets[ticket.ID] = ticket
+	return true, nil
+}
+
+func newTestTicketTriageService(server *httptest.Server, credits *assistCreditsRepository, tickets *fakeTicketTriageTickets, settings fakeSettingsStore) (*TicketTriageService, *fakeTicketTriageStore) {
+	var ai *AIService
+	if server != nil {
+		cfg := &config.AIConfig{Enabled: true, APIKey: "test-key", Provider: "openai", BaseURL: server.URL, Model: "gpt-4o"}
+		ai = &AIService{config: cfg, llm: NewLLMRouter(cfg, server.Client())}
+		if credits != nil {
+			ai.usageService = NewAIUsageService(credits, nil)
+		}
+	}
+	store := &fakeTicketTriageStore{}
+	// The agentic chat features being off must not switch off the triage heuristics
+	return NewTicketTriageService(store, tickets, tickets, settings, ai, &config.AgenticConfig{}), store
+}
+
+func newTriageTicket(tickets *fakeTicketTriageTickets, subject, body string) *db.Ticket {
+	ticket := &db.Ticket{ID: uuid.New(), TenantID: uuid.New(), ProjectID: uuid.New(), Subject: subject, Status: "new", Priority: "normal", Type: "question", Source: "email"}
+	if tickets.tickets == nil {
+		tickets.tickets = map[uuid.UUID]*db.Ticket{}
+		tickets.messages = map[uuid.UUID][]*db.TicketMessage{}
+	}
+	tickets.tickets[ticket.ID] = ticket
+	tickets.messages[ticket.ID] = []*db.TicketMessage{
+		{AuthorType: "customer", Body: body},
+		{AuthorType: "agent", Body: "Looking into it"},
+	}
+	return ticket
+}
+
+func TestHeuristicTriageClassifiesWithoutAI(t *testing.T) {
+	ctx := context.Background()
+	tickets := &fakeTicketTriageTickets{}
+	svc, store := newTestTicketTriageService(nil, nil, tickets, nil)
+
+	outage := newTriageTicket(tickets, "Production down", "Our checkout is not working since this morning. This is unacceptable, fix it immediately!")
+	triage, err := svc.TriageTicket(ctx, outage.TenantID, outage.ProjectID, outage.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.TicketTriageMethodHeuristic, triage.Method)
+	require.Equal(t, "incident", triage.Type)
+	require.Equal(t, "urgent", triage.Priority)
+	require.Equal(t, "critical", triage.Urgency)
+	require.Equal(t, "negative", triage.Sentiment)
+	require.Contains(t, triage.Tags, "complaint")
+	require.Nil(t, triage.Language)
+
+	// Heuristic confidence stays below the default auto-apply threshold, so values are only suggested
+	require.LessOrEqual(t, triage.TypeConfidence, maxHeuristicTriageConfidence)
+	require.Empty(t, triage.AppliedFields)
+	require.Equal(t, "question", tickets.tickets[outage.ID].Type)
+	require.Zero(t, tickets.updates)
+	require.Equal(t, "question", triage.PreviousType)
+	require.Equal(t, models.TicketTriagePending, triage.ReviewStatus)
+	require.Len(t, store.decisions, 1)
+
+	pricing := newTriageTicket(tickets, "Plans", "How much does the pro plan cost per month? Thanks!")
+	triage, err = svc.TriageTicket(ctx, pricing.TenantID, pricing.ProjectID, pricing.ID)
+	require.NoError(t, err)
+	require.Equal(t, "question", triage.Type)
+	require.Equal(t, "normal", triage.Priority)
+	require.Equal(t, "positive", triage.Sentiment)
+	require.Contains(t, triage.Tags, "pricing")
+
+	_, err = svc.TriageTicket(ctx, pricing.TenantID, pricing.ProjectID, uuid.New())
+	require.ErrorIs(t, err, ErrTicketTriageNotFound)
+}
+
+func TestTriageAppliesConfidentLLMValuesAndSuggestsTheRest(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newAssistLLM(t, "```json\n{\"type\": \"Incident\", \"type_confidence\": 0.95, \"priority\": \"high\", \"priority_confidence\": 0.6, \"tags\": [\"Checkout Errors\", \"billing\", \"billing\"], \"language\": \"de\", \"sentiment\": \"negative\", \"urgency\": \"extreme\"}\n```")
+	credits := &assistCreditsRepository{balance: 500}
+	credits.result = &db.CreditTransaction{ID: 1, BalanceAfter: 379}
+	tickets := &fakeTicketTriageTickets{}
+	svc, store := newTestTicketTriageService(server, credits, tickets, nil)
+
+	ticket := newTriageTicket(tickets, "Kasse", "Die Kasse funktioniert nicht, Zahlungen schlagen fehl.")
+	triage, err := svc.TriageTicket(ctx, ticket.TenantID, ticket.ProjectID, ticket.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, models.TicketTriageMethodLLM, triage.Method)
+	require.Equal(t, "gpt-4o", *triage.Model)
+	require.Equal(t, "incident", triage.Type)
+	require.Equal(t, "high", triage.Priority)
+	require.Equal(t, []string{"checkout-errors", "billing"}, []string(triage.Tags))
+	require.Equal(t, "de", *triage.Language)
+	require.Equal(t, "negative", triage.Sentiment)
+	require.Equal(t, "normal", triage.Urgency) // an unknown urgency keeps the heuristic one
+
+	require.Equal(t, []string{models.TicketTriageFieldType}, []string(triage.AppliedFields))
+	require.Equal(t, []string{models.TicketTriageFieldPriority}, []string(triage.SuggestedFields))
+	require.Equal(t, "incident", tickets.tickets[ticket.ID].Type)
+	require.Equal(t, "normal", tickets.tickets[ticket.ID].Priority)
+	require.Equal(t, 1, tickets.updates)
+	require.Len(t, store.decisions, 1)
+
+	require.Len(t, *requests, 1)
+	require.Contains(t, (*requests)[0].Messages[0].Content, `Keyword heuristics suggest type "question"`)
+	require.Contains(t, (*requests)[0].Messages[1].Content, "Kasse\n\nDie Kasse funktioniert nicht")
+	require.NotContains(t, (*requests)[0].Messages[1].Content, "Looking into it")
+	require.EqualValues(t, 121, credits.expectedAmount)
+
+	// Without credits the heuristics triage alone
+	svc, _ = newTestTicketTriageService(server, &assistCreditsRepository{}, tickets, nil)
+	triage, err = svc.TriageTicket(ctx, ticket.TenantID, ticket.ProjectID, ticket.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.TicketTriageMethodHeuristic, triage.Method)
+	require.Len(t, *requests, 1)
+}
+
+func TestTriageOnlySuggestsWhenAnAgentChangedTheTicket(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newAssistLLM(t, `{"type": "incident", "type_confidence": 0.95, "priority": "urgent", "priority_confidence": 0.9}`)
+	credits := &assistCreditsRepository{balance: 500}
+	credits.result = &db.CreditTransaction{ID: 1, BalanceAfter: 379}
+	tickets := &fakeTicketTriageTickets{changedMeanwhile: true}
+	svc, store := newTestTicketTriageService(server, credits, tickets, nil)
+
+	ticket := newTriageTicket(tickets, "Checkout", "Payments fail at checkout.")
+	triage, err := svc.TriageTicket(ctx, ticket.TenantID, ticket.ProjectID, ticket.ID)
+	require.NoError(t, err)
+	require.Empty(t, triage.AppliedFields)
+	require.ElementsMatch(t, []string{models.TicketTriageFieldType, models.TicketTriageFieldPriority}, []string(triage.SuggestedFields))
+	require.Equal(t, "question", ticket.Type)
+	require.Equal(t, "normal", ticket.Priority)
+	require.Zero(t, tickets.updates)
+	require.Len(t, store.decisions, 1)
+}
+
+func TestReviewTriageRecordsVerdictAndAppliesCorrections(t *testing.T) {
+	ctx := context.Background()
+	tickets := &fakeTicketTriageTickets{}
+	svc, store := newTestTicketTriageService(nil, nil, tickets, nil)
+	ticket := newTriageTicket(tickets, "Invoice", "Please send me last month's invoice")
+	agentID := uuid.New()
+
+	_, err := svc.ReviewTriage(ctx, ticket.TenantID, ticket.ProjectID, ticket.ID, agentID, &models.TicketTriageReviewRequest{})
+	require.ErrorIs(t, err, ErrTicketTriageNotTriaged)
+
+	store.decisions = append(store.decisions, &models.TicketTriage{ID: uuid.New(), TicketID: ticket.ID, Type: "task", Priority: "normal", Tags: []string{"billing"}, ReviewStatus: models.TicketTriagePending})
+	triage, err := svc.ReviewTriage(ctx, ticket.TenantID, ticket.ProjectID, ticket.ID, agentID, &models.TicketTriageReviewRequest{})
+	require.NoError(t, err)
+	require.Equal(t, models.TicketTriageAccepted, triage.ReviewStatus)
+	require.Equal(t, "task", *triage.ReviewedType)
+	require.Equal(t, agentID, *triage.ReviewedBy)
+	require.Equal(t, "task", tickets.tickets[ticket.ID].Type)
+
+	urgent := "urgent"
+	triage, err = svc.ReviewTriage(ctx, ticket.TenantID, ticket.ProjectID, ticket.ID, agentID, &models.TicketTriageReviewRequest{Priority: &urgent, Tags: []string{"Billing", "Invoices"}})
+	require.NoError(t, err)
+	require.Equal(t, models.TicketTriageCorrected, triage.ReviewStatus)
+	require.Equal(t, "urgent", *triage.ReviewedPriority)
+	require.Equal(t, []string{"billing", "invoices"}, []string(triage.ReviewedTags))
+	require.Equal(t, "urgent", tickets.tickets[ticket.ID].Priority)
+	require.Same(t, triage, store.reviewed)
+}
+
+func TestTriageConfigReadsAutomationSettings(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+
+	svc, _ := newTestTicketTriageService(nil, nil, &fakeTicketTriageTickets{}, nil)
+	cfg := svc.triageConfig(ctx, tenantID, projectID)
+	require.False(t, cfg.enabled)
+	require.Equal(t, defaultTriageAutoApplyConfidence, cfg.autoApply)
+	require.Equal(t, defaultTriageSuggestConfidence, cfg.suggest)
+
+	svc, _ = newTestTicketTriageService(nil, nil, &fakeTicketTriageTickets{}, fakeSettingsStore{
+		"automation_settings": {
+			"enable_ai_triage":                true,
+			"ai_triage_auto_apply_confidence": 0.6,
+			"ai_triage_suggest_confidence":    1.5,
+		},
+	})
+	cfg = svc.triageConfig(ctx, tenantID, projectID)
+	require.True(t, cfg.enabled)
+	require.Equal(t, 0.6, cfg.autoApply)
+	require.Equal(t, defaultTriageSuggestConfidence, cfg.suggest)
+}
@@ -0,0 +1,490 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+const (
+	translationSettingsKey    = "translation_settings"
+	translationTimeout        = 60 * time.Second
+	maxTranslationText        = 16000
+	maxOnDemandTranslations   = 20
+	translationRequestID      = "translation"
+	llmTranslationBackendName = "llm"
+)
+
+var (
+	ErrInvalidTranslationSettings = errors.New("invalid translation settings")
+	ErrTranslationUnavailable     = errors.New("translation backend unavailable")
+)
+
+// translationLanguagePattern matches ISO 639-1 language codes
+var translationLanguagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+
+const translationPrompt = `You translate customer support messages. Detect the language of the message below and translate it into the language with ISO 639-1 code %q.%s
+
+Keep the meaning, tone, formatting and line breaks. Keep placeholders such as [EMAIL_1] exactly as they are. When the message is already in that language, return it unchanged.
+
+Respond with JSON only, in the form {"language": "", "translation": ""}, where language is the ISO 639-1 code of the language the message is written in.`
+
+// TranslationRequest is a text to translate. The language it is written in is detected when
+// SourceLanguage is empty.
+type TranslationRequest struct {
+	TenantID       uuid.UUID
+	ProjectID      uuid.UUID
+	Text           string
+	SourceLanguage string
+	TargetLanguage string
+}
+
+// TranslationResult is a translated text with the language it was translated from. Text is the original
+// when it already was in the target language.
+type TranslationResult struct {
+	SourceLanguage string
+	Text           string
+}
+
+// TranslationBackend detects the language of texts and translates them
+type TranslationBackend interface {
+	// Name identifies the backend in stored translations
+	Name() string
+	Translate(ctx context.Context, req *TranslationRequest) (*TranslationResult, error)
+}
+
+// MessageTranslator translates customer messages for agents and agent replies for customers
+type MessageTranslator interface {
+	// TranslateIncoming detects the language of a customer message and translates it into the language of
+	// the given agent, or of the project's agents when there is none. It returns nil when the project does
+	// not translate or the message already is in that language.
+	TranslateIncoming(ctx context.Context, tenantID, projectID uuid.UUID, kind string, messageID, conversationID uuid.UUID, agentID *uuid.UUID, text string) (*models.MessageTranslation, error)
+	// TranslateReply translates an agent reply into the language the customer last wrote in. It returns
+	// nil when the reply is to be sent as it is.
+	TranslateReply(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, agentID *uuid.UUID, text string) (*models.ReplyTranslation, error)
+	// RecordReply keeps the agent's original text of a translated reply as the translation of the stored
+	// message
+	RecordReply(ctx context.Context, tenantID, projectID uuid.UUID, kind string, messageID, conversationID uuid.UUID, reply *models.ReplyTranslation) error
+}
+
+type translationStore interface {
+	AgentLanguage(ctx context.Context, tenantID, agentID uuid.UUID) (string, error)
+	SaveLanguage(ctx context.Context, language *models.MessageLanguage) error
+	ConversationLanguage(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID) (string, error)
+	GetTranslation(ctx context.Context, kind string, messageID uuid.UUID, targetLanguage string) (*models.MessageTranslation, error)
+	SaveTranslation(ctx context.Context, translation *models.MessageTranslation) error
+	ListTranslations(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, targetLanguage string) ([]*models.MessageTranslation, error)
+	ListUntranslated(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, targetLanguage string, limit int) ([]*models.UntranslatedMessage, error)
+}
+
+// TranslationService translates between customers and agents who do not share a language. Customer chat
+// messages and emails are translated into the agent's preferred language and shown alongside the
+// original; agent replies are translated into the customer's language before they are delivered.
+// Translations are kept per message, so each is made once per language.
+type TranslationService struct {
+	store    translationStore
+	backend  TranslationBackend
+	settings projectSettingsStore
+}
+
+// NewTranslationService creates a new translation service
+func NewTranslationService(store translationStore, backend TranslationBackend, settings projectSettingsStore) *TranslationService {
+	return &TranslationService{store: store, backend: backend, settings: settings}
+}
+
+// Settings returns the translation settings of a project, the defaults for what it has not set
+func (s *TranslationService) Settings(ctx context.Context, tenantID, projectID uuid.UUID) *models.TranslationSettings {
+	settings := models.DefaultTranslationSettings()
+	if s.settings == nil {
+		return settings
+	}
+	stored, _, err := s.settings.GetSetting(ctx, tenantID, projectID, translationSettingsKey)
+	if err != nil {
+		return settings
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return settings
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		fmt.Printf("Invalid translation settings for project %s, using defaults: %v\n", projectID, err)
+		return models.DefaultTranslationSettings()
+	}
+	return settings
+}
+
+// UpdateSettings replaces the translation settings of a project
+func (s *TranslationService) UpdateSettings(ctx context.Context, tenantID, projectID uuid.UUID, settings *models.TranslationSettings) (*models.TranslationSettings, error) {
+	updated := *settings
+	updated.AgentLanguage = strings.ToLower(strings.TrimSpace(updated.AgentLanguage))
+	if !translationLanguagePattern.MatchString(updated.AgentLanguage) {
+		return nil, fmt.Errorf("%w: agent_language must be an ISO 639-1 code", ErrInvalidTranslationSettings)
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if err := s.settings.UpdateSetting(ctx, tenantID, projectID, translationSettingsKey, value); err != nil {
+		return nil, fmt.Errorf("failed to update translation settings: %w", err)
+	}
+	return &updated, nil
+}
+
+// TranslateIncoming detects the language of a customer message and translates it for the agent
+func (s *TranslationService) TranslateIncoming(ctx context.Context, tenantID, projectID uuid.UUID, kind string, messageID, conversationID uuid.UUID, agentID *uuid.UUID, text string) (*models.MessageTranslation, error) {
+	settings := s.Settings(ctx, tenantID, projectID)
+	if !settings.Enabled || !translatable(text) {
+		return nil, nil
+	}
+	target := s.agentLanguage(ctx, tenantID, agentID, settings)
+
+	cached, err := s.store.GetTranslation(ctx, kind, messageID, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get translation: %w", err)
+	}
+	if cached != nil {
+		return cached, nil
+	}
+
+	result, err := s.translate(ctx, &TranslationRequest{
+		TenantID:       tenantID,
+		ProjectID:      projectID,
+		Text:           text,
+		TargetLanguage: target,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.SaveLanguage(ctx, &models.MessageLanguage{
+		MessageKind:    kind,
+		MessageID:      messageID,
+		TenantID:       tenantID,
+		ProjectID:      projectID,
+		ConversationID: conversationID,
+		Language:       result.SourceLanguage,
+		Backend:        s.backend.Name(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to save message language: %w", err)
+	}
+	if result.SourceLanguage == target {
+		return nil, nil
+	}
+
+	translation := &models.MessageTranslation{
+		TenantID:       tenantID,
+		ProjectID:      projectID,
+		MessageKind:    kind,
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		SourceLanguage: result.SourceLanguage,
+		TargetLanguage: target,
+		TranslatedText: result.Text,
+		Backend:        s.backend.Name(),
+	}
+	if err := s.store.SaveTranslation(ctx, translation); err != nil {
+		return nil, fmt.Errorf("failed to save translation: %w", err)
+	}
+	return translation, nil
+}
+
+// TranslateReply translates an agent reply into the language the customer last wrote in. Replies in
+// conversations whose customer writes in the agent's language, and replies the agent already wrote in
+// the customer's language, are sent as they are.
+func (s *TranslationService) TranslateReply(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, agentID *uuid.UUID, text string) (*models.ReplyTranslation, error) {
+	settings := s.Settings(ctx, tenantID, projectID)
+	if !settings.Enabled || !translatable(text) {
+		return nil, nil
+	}
+
+	target, err := s.store.ConversationLanguage(ctx, tenantID, projectID, kind, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation language: %w", err)
+	}
+	if target == "" || target == s.agentLanguage(ctx, tenantID, agentID, settings) {
+		return nil, nil
+	}
+
+	result, err := s.translate(ctx, &TranslationRequest{
+		TenantID:       tenantID,
+		ProjectID:      projectID,
+		Text:           text,
+		TargetLanguage: target,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.SourceLanguage == target {
+		return nil, nil
+	}
+
+	return &models.ReplyTranslation{
+		Original:       text,
+		Text:           result.Text,
+		SourceLanguage: result.SourceLanguage,
+		TargetLanguage: target,
+		Backend:        s.backend.Name(),
+	}, nil
+}
+
+// RecordReply keeps the original of a translated agent reply as the translation of the stored message
+// into the agent's language
+func (s *TranslationService) RecordReply(ctx context.Context, tenantID, projectID uuid.UUID, kind string, messageID, conversationID uuid.UUID, reply *models.ReplyTranslation) error {
+	if reply == nil {
+		return nil
+	}
+	return s.store.SaveTranslation(ctx, &models.MessageTranslation{
+		TenantID:       tenantID,
+		ProjectID:      projectID,
+		MessageKind:    kind,
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		SourceLanguage: reply.TargetLanguage,
+		TargetLanguage: reply.SourceLanguage,
+		TranslatedText: reply.Original,
+		Backend:        reply.Backend,
+	})
+}
+
+// ListTranslations returns the translations of a chat session's or ticket's messages into the language of
+// the agent reading it. Customer messages that were translated for another agent are translated into it
+// first.
+func (s *TranslationService) ListTranslations(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID, agentID uuid.UUID) (*models.MessageTranslationList, error) {
+	settings := s.Settings(ctx, tenantID, projectID)
+	target := s.agentLanguage(ctx, tenantID, &agentID, settings)
+
+	if settings.Enabled {
+		untranslated, err := s.store.ListUntranslated(ctx, tenantID, projectID, kind, conversationID, target, maxOnDemandTranslations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list untranslated messages: %w", err)
+		}
+		for _, message := range untranslated {
+			if !translatable(message.Text) {
+				continue
+			}
+			result, err := s.translate(ctx, &TranslationRequest{
+				TenantID:       tenantID,
+				ProjectID:      projectID,
+				Text:           message.Text,
+				SourceLanguage: message.Language,
+				TargetLanguage: target,
+			})
+			if err != nil {
+				fmt.Printf("Failed to translate message %s into %s: %v\n", message.MessageID, target, err)
+				break
+			}
+			if err := s.store.SaveTranslation(ctx, &models.MessageTranslation{
+				TenantID:       tenantID,
+				ProjectID:      projectID,
+				MessageKind:    kind,
+				MessageID:      message.MessageID,
+				ConversationID: conversationID,
+				SourceLanguage: message.Language,
+				TargetLanguage: target,
+				TranslatedText: result.Text,
+				Backend:        s.backend.Name(),
+			}); err != nil {
+				return nil, fmt.Errorf("failed to save translation: %w", err)
+			}
+		}
+	}
+
+	translations, err := s.store.ListTranslations(ctx, tenantID, projectID, kind, conversationID, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list translations: %w", err)
+	}
+	return &models.MessageTranslationList{Language: target, Translations: translations}, nil
+}
+
+// agentLanguage returns the language an agent reads, the project's agent language when the agent has not
+// set one or there is no agent
+func (s *TranslationService) agentLanguage(ctx context.Context, tenantID uuid.UUID, agentID *uuid.UUID, settings *models.TranslationSettings) string {
+	if agentID != nil && *agentID != uuid.Nil {
+		language, err := s.store.AgentLanguage(ctx, tenantID, *agentID)
+		if err != nil {
+			fmt.Printf("Failed to get preferred language of agent %s: %v\n", *agentID, err)
+		} else if language != "" {
+			return language
+		}
+	}
+	return settings.AgentLanguage
+}
+
+// translate runs a translation on the backend and checks what it returned
+func (s *TranslationService) translate(ctx context.Context, req *TranslationRequest) (*TranslationResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, translationTimeout)
+	defer cancel()
+
+	result, err := s.backend.Translate(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate: %w", err)
+	}
+	result.SourceLanguage = strings.ToLower(strings.TrimSpace(result.SourceLanguage))
+	if !translationLanguagePattern.MatchString(result.SourceLanguage) {
+		return nil, fmt.Errorf("translation backend %s returned invalid language %q", s.backend.Name(), result.SourceLanguage)
+	}
+	if strings.TrimSpace(result.Text) == "" {
+		return nil, fmt.Errorf("translation backend %s returned no text", s.backend.Name())
+	}
+	return result, nil
+}
+
+// translatable reports whether a text is worth translating: it has words, and is not too long to
+// translate in one go
+func translatable(text string) bool {
+	if utf8.RuneCountInString(text) > maxTranslationText {
+		return false
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// LLMTranslationBackend translates with the AI provider and model a project assists its agents with, and
+// bills the tenant for it. Personal data is masked before the text is sent and put back in the translation.
+type LLMTranslationBackend struct {
+	ai *AIService
+}
+
+// NewLLMTranslationBackend creates a translation backend on the AI provider plumbing
+func NewLLMTranslationBackend(ai *AIService) *LLMTranslationBackend {
+	return &LLMTranslationBackend{ai: ai}
+}
+
+// Name identifies the backend in stored translations
+func (b *LLMTranslationBackend) Name() string {
+	return llmTranslationBackendName
+}
+
+// Translate detects the language of a text and translates it in one completion
+func (b *LLMTranslationBackend) Translate(ctx context.Context, req *TranslationRequest) (*TranslationResult, error) {
+	if !b.available(ctx, req.TenantID) {
+		return nil, ErrTranslationUnavailable
+	}
+	ctx = b.ai.guardedContext(ctx, req.TenantID, req.ProjectID, nil, nil)
+	profile := b.ai.assistProfile(ctx, req.TenantID, req.ProjectID, nil)
+
+	hint := ""
+	if req.SourceLanguage != "" {
+		hint = fmt.Sprintf(" The message is written in the language with ISO 639-1 code %q.", req.SourceLanguage)
+	}
+	masked, values := b.maskPII(ctx, req.Text)
+	completion, err := b.ai.completeWithProfile(ctx, profile, []ChatCompletionMessage{
+		{Role: "system", Content: fmt.Sprintf(translationPrompt, req.TargetLanguage, hint)},
+		{Role: "user", Content: masked},
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	b.ai.deductAssistUsage(ctx, req.TenantID, req.ProjectID, nil, translationRequestID, completion)
+
+	var result struct {
+		Language    string `json:"language"`
+		Translation string `json:"translation"`
+	}
+	if err := json.Unmarshal([]byte(stripMarkdownCodeBlocks(completion.Content)), &result); err != nil {
+		return nil, fmt.Errorf("invalid translation response: %w", err)
+	}
+	return &TranslationResult{
+		SourceLanguage: result.Language,
+		Text:           unmaskPII(result.Translation, values),
+	}, nil
+}
+
+// available reports whether the backend may call the AI model and bill the tenant for it
+func (b *LLMTranslationBackend) available(ctx context.Context, tenantID uuid.UUID) bool {
+	if b.ai == nil || !b.ai.IsEnabled() {
+		return false
+	}
+	if b.ai.usageService != nil {
+		hasCredits, err := b.ai.usageService.HasAvailableCredits(ctx, tenantID)
+		if err != nil || !hasCredits {
+			return false
+		}
+	}
+	return true
+}
+
+// maskPII masks the personal data the project's guardrails mask, with numbered placeholders so it can be
+// put back in the translation. The masking is recorded like any other.
+func (b *LLMTranslationBackend) maskPII(ctx context.Context, text string) (string, []piiValue) {
+	if b.ai.guardrails == nil {
+		return text, nil
+	}
+	scope := b.ai.guardrailScope(ctx)
+	if !scope.settings.RedactPII {
+		return text, nil
+	}
+	masked, values, found := maskPIIReversibly(text, scope.settings.PIITypes)
+	if len(found) > 0 {
+		b.ai.guardrails.record(ctx, scope, models.AIGuardrailStageInput, models.AIGuardrailKindPII, models.AIGuardrailActionMasked, piiDetails(found))
+	}
+	return masked, values
+}
+
+// piiValue is personal data masked with a numbered placeholder
+type piiValue struct {
+	placeholder string
+	value       string
+}
+
+// maskPIIReversibly masks personal data like redactPII, but numbers the placeholders ([EMAIL_1],
+// [EMAIL_2], ...) and returns what each replaced
+func maskPIIReversibly(text string, types []string) (string, []piiValue, map[string]int) {
+	var values []piiValue
+	found := map[string]int{}
+	if strings.TrimSpace(text) == "" {
+		return text, values, found
+	}
+
+	wanted := map[string]bool{}
+	for _, piiType := range types {
+		wanted[piiType] = true
+	}
+	for _, p := range piiPatterns {
+		if len(wanted) > 0 && !wanted[p.piiType] {
+			continue
+		}
+		text = p.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			end := len(match)
+			if p.check != nil {
+				var ok bool
+				if end, ok = p.check(match); !ok {
+					return match
+				}
+			}
+			found[p.piiType]++
+			placeholder := strings.TrimSuffix(piiPlaceholders[p.piiType], "]") + "_" + strconv.Itoa(found[p.piiType]) + "]"
+			values = append(values, piiValue{placeholder: placeholder, value: match[:end]})
+			return placeholder + match[end:]
+		})
+	}
+	return text, values, found
+}
+
+// unmaskPII puts masked personal data back in a text
+func unmaskPII(text string, values []piiValue) string {
+	for _, v := range values {
+		text = strings.ReplaceAll(text, v.placeholder, v.value)
+	}
+	return text
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+type fakeTranslationStore struct {
+	agentLanguages map[uuid.UUID]string
+	languages      []*models.MessageLanguage
+	translations   []*models.MessageTranslation
+	texts          map[uuid.UUID]string
+}
+
+func newFakeTranslationStore() *fakeTranslationStore {
+	return &fakeTranslationStore{agentLanguages: map[uuid.UUID]string{}, texts: map[uuid.UUID]string{}}
+}
+
+func (f *fakeTranslationStore) AgentLanguage(ctx context.Context, tenantID, agentID uuid.UUID) (string, error) {
+	return f.agentLanguages[agentID], nil
+}
+
+func (f *fakeTranslationStore) SaveLanguage(ctx context.Context, language *models.MessageLanguage) error {
+	language.CreatedAt = time.Now()
+	f.languages = append(f.languages, language)
+	return nil
+}
+
+func (f *fakeTranslationStore) ConversationLanguage(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID) (string, error) {
+	for i := len(f.languages) - 1; i >= 0; i-- {
+		if f.languages[i].MessageKind == kind && f.languages[i].ConversationID == conversationID {
+			return f.languages[i].Language, nil
+		}
+	}
+	return "", nil
+}
+
+func (f *fakeTranslationStore) GetTranslation(ctx context.Context, kind string, messageID uuid.UUID, targetLanguage string) (*models.MessageTranslation, error) {
+	for _, translation := range f.translations {
+		if translation.MessageKind == kind && translation.MessageID == messageID && translation.TargetLanguage == targetLanguage {
+			return translation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeTranslationStore) SaveTranslation(ctx context.Context, translation *models.MessageTranslation) error {
+	translation.ID = uuid.New()
+	translation.CreatedAt = time.Now()
+	f.translations = append(f.translations, translation)
+	return nil
+}
+
+func (f *fakeTranslationStore) ListTranslations(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, targetLanguage string) ([]*models.MessageTranslation, error) {
+	translations := []*models.MessageTranslation{}
+	for _, translation := range f.translations {
+		if translation.MessageKind == kind && translation.ConversationID == conversationID && translation.TargetLanguage == targetLanguage {
+			translations = append(translations, translation)
+		}
+	}
+	return translations, nil
+}
+
+func (f *fakeTranslationStore) ListUntranslated(ctx context.Context, tenantID, projectID uuid.UUID, kind string, conversationID uuid.UUID, targetLanguage string, limit int) ([]*models.UntranslatedMessage, error) {
+	messages := []*models.UntranslatedMessage{}
+	for _, language := range f.languages {
+		if language.MessageKind != kind || language.ConversationID != conversationID || language.Language == targetLanguage {
+			continue
+		}
+		if translation, _ := f.GetTranslation(ctx, kind, language.MessageID, targetLanguage); translation != nil {
+			continue
+		}
+		messages = append(messages, &models.UntranslatedMessage{MessageID: language.MessageID, Language: language.Language, Text: f.texts[language.MessageID]})
+	}
+	return messages, nil
+}
+
+// fakeTranslationBackend knows the language of some texts and their translations
+type fakeTranslationBackend struct {
+	languages    map[string]string
+	translations map[string]string // by target language and text
+	calls        int
+}
+
+func (f *fakeTranslationBackend) Name() string {
+	return "fake"
+}
+
+func (f *fakeTranslationBackend) Translate(ctx context.Context, req *TranslationRequest) (*TranslationResult, error) {
+	f.calls++
+	language := req.SourceLanguage
+	if language == "" {
+		language = f.languages[req.Text]
+	}
+	if language == req.TargetLanguage {
+		return &TranslationResult{SourceLanguage: language, Text: req.Text}, nil
+	}
+	text, ok := f.translations[req.TargetLanguage+":"+req.Text]
+	if !ok {
+		return nil, errors.New("no translation for " + req.Text)
+	}
+	return &TranslationResult{SourceLanguage: language, Text: text}, nil
+}
+
+func newFakeTranslationBackend() *fakeTranslationBackend {
+	return &fakeTranslationBackend{
+		languages: map[string]string{
+			"Hola, mi pedido no ha llegado":    "es",
+			"My order has not arrived":         "en",
+			"Sorry about that, let me check":   "en",
+			"Lo siento, déjame comprobarlo":    "es",
+			"Mi pedido no ha llegado todavía":  "es",
+			"Meine Bestellung ist nicht da":    "de",
+			"Perdón por eso, déjame revisarlo": "es",
+		},
+		translations: map[string]string{
+			"en:Hola, mi pedido no ha llegado":   "Hello, my order has not arrived",
+			"es:Sorry about that, let me check":  "Lo siento, déjame comprobarlo",
+			"de:Hola, mi pedido no ha llegado":   "Hallo, meine Bestellung ist nicht angekommen",
+			"en:Mi pedido no ha llegado todavía": "My order has not arrived yet",
+		},
+	}
+}
+
+func TestTranslateIncomingDetectsLanguageAndCachesPerMessage(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID, sessionID := uuid.New(), uuid.New(), uuid.New()
+	store := newFakeTranslationStore()
+	backend := newFakeTranslationBackend()
+	svc := NewTranslationService(store, backend, fakeSettingsStore{translationSettingsKey: {"enabled": true, "agent_language": "en"}})
+
+	messageID := uuid.New()
+	translation, err := svc.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindChat, messageID, sessionID, nil, "Hola, mi pedido no ha llegado")
+	require.NoError(t, err)
+	require.NotNil(t, translation)
+	require.Equal(t, "es", translation.SourceLanguage)
+	require.Equal(t, "en", translation.TargetLanguage)
+	require.Equal(t, "Hello, my order has not arrived", translation.TranslatedText)
+	require.Equal(t, "fake", translation.Backend)
+
+	language, err := store.ConversationLanguage(ctx, tenantID, projectID, models.MessageKindChat, sessionID)
+	require.NoError(t, err)
+	require.Equal(t, "es", language)
+
+	// The same message is translated once per language
+	cached, err := svc.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindChat, messageID, sessionID, nil, "Hola, mi pedido no ha llegado")
+	require.NoError(t, err)
+	require.Equal(t, translation.ID, cached.ID)
+	require.Equal(t, 1, backend.calls)
+
+	// An agent with a preferred language gets the message in it
+	agentID := uuid.New()
+	store.agentLanguages[agentID] = "de"
+	translation, err = svc.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindChat, messageID, sessionID, &agentID, "Hola, mi pedido no ha llegado")
+	require.NoError(t, err)
+	require.Equal(t, "Hallo, meine Bestellung ist nicht angekommen", translation.TranslatedText)
+
+	// Messages in the agent's language are not translated, but their language is kept
+	translation, err = svc.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindChat, uuid.New(), sessionID, nil, "My order has not arrived")
+	require.NoError(t, err)
+	require.Nil(t, translation)
+	language, err = store.ConversationLanguage(ctx, tenantID, projectID, models.MessageKindChat, sessionID)
+	require.NoError(t, err)
+	require.Equal(t, "en", language)
+}
+
+func TestTranslateIncomingSkipsWhenDisabledOrWithoutWords(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID := uuid.New(), uuid.New()
+	store := newFakeTranslationStore()
+	backend := newFakeTranslationBackend()
+
+	svc := NewTranslationService(store, backend, fakeSettingsStore{})
+	translation, err := svc.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindTicket, uuid.New(), uuid.New(), nil, "Hola, mi pedido no ha llegado")
+	require.NoError(t, err)
+	require.Nil(t, translation)
+
+	svc = NewTranslationService(store, backend, fakeSettingsStore{translationSettingsKey: {"enabled": true, "agent_language": "en"}})
+	translation, err = svc.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindChat, uuid.New(), uuid.New(), nil, "👍 123")
+	require.NoError(t, err)
+	require.Nil(t, translation)
+
+	require.Zero(t, backend.calls)
+	require.Empty(t, store.languages)
+}
+
+func TestTranslateReplyIntoCustomerLanguageAndKeepOriginal(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID, ticketID := uuid.New(), uuid.New(), uuid.New()
+	store := newFakeTranslationStore()
+	backend := newFakeTranslationBackend()
+	svc := NewTranslationService(store, backend, fakeSettingsStore{translationSettingsKey: {"enabled": true, "agent_language": "en"}})
+
+	// Nothing is known about the customer's language yet
+	reply, err := svc.TranslateReply(ctx, tenantID, projectID, models.MessageKindTicket, ticketID, nil, "Sorry about that, let me check")
+	require.NoError(t, err)
+	require.Nil(t, reply)
+
+	_, err = svc.TranslateIncoming(ctx, tenantID, projectID, models.MessageKindTicket, uuid.New(), ticketID, nil, "Hola, mi pedido no ha llegado")
+	require.NoError(t, err)
+
+	reply, err = svc.TranslateReply(ctx, tenantID, projectID, models.MessageKindTicket, ticketID, nil, "Sorry about that, let me check")
+	require.NoError(t, err)
+	require.NotNil(t, reply)
+	require.Equal(t, "Lo siento, déjame comprobarlo", reply.Text)
+	require.Equal(t, "en", reply.SourceLanguage)
+	require.Equal(t, "es", reply.TargetLanguage)
+
+	messageID := uuid.New()
+	require.NoError(t, svc.RecordReply(ctx, tenantID, projectID, models.MessageKindTicket, messageID, ticketID, reply))
+	original, err := store.GetTranslation(ctx, models.MessageKindTicket, messageID, "en")
+	require.NoError(t, err)
+	require.Equal(t, "Sorry about that, let me check", original.TranslatedText)
+	require.Equal(t, "es", original.SourceLanguage)
+
+	// Replies already written in the customer's language are sent as they are
+	reply, err = svc.TranslateReply(ctx, tenantID, projectID, models.MessageKindTicket, ticketID, nil, "Perdón por eso, déjame revisarlo")
+	require.NoError(t, err)
+	require.Nil(t, reply)
+
+	// So are replies of agents who read the customer's language
+	agentID := uuid.New()
+	store.agentLanguages[agentID] = "es"
+	calls := backend.calls
+	reply, err = svc.TranslateReply(ctx, tenantID, projectID, models.MessageKindTicket, ticketID, &agentID, "Sorry about that, let me check")
+	require.NoError(t, err)
+	require.Nil(t, reply)
+	require.Equal(t, calls, backend.calls)
+}
+
+func TestListTranslationsTranslatesForTheReadingAgent(t *testing.T) {
+	ctx := context.Background()
+	tenantID, projectID, sessionID := uuid.New(), uuid.New(), uuid.New()
+	store := newFakeTranslationStore()
+	backend := newFakeTranslationBackend()
+	svc := NewTranslationService(store, backend, fakeSettingsStore{translationSettingsKey: {"enabled": true, "agent_language": "de"}})
+
+	// A visitor message that was detected as Spanish for an agent reading German
+	messageID := uuid.New()
+	store.texts[messageID] = "Mi pedido no ha llegado todavía"
+	store.languages = append(store.languages, &models.MessageLanguage{
+		MessageKind: models.MessageKindChat, MessageID: messageID, TenantID: tenantID, ProjectID: projectID,
+		ConversationID: sessionID, Language: "es", Backend: "fake",
+	})
+
+	agentID := uuid.New()
+	store.agentLanguages[agentID] = "en"
+	list, err := svc.ListTranslations(ctx, tenantID, projectID, models.MessageKindChat, sessionID, agentID)
+	require.NoError(t, err)
+	require.Equal(t, "en", list.Language)
+	require.Len(t, list.Translations, 1)
+	require.Equal(t, messageID, list.Translations[0].MessageID)
+	require.Equal(t, "My order has not arrived yet", list.Translations[0].TranslatedText)
+
+	calls := backend.calls
+	list, err = svc.ListTranslations(ctx, tenantID, projectID, models.MessageKindChat, sessionID, agentID)
+	require.NoError(t, err)
+	require.Len(t, list.Translations, 1)
+	require.Equal(t, calls, backend.calls)
+}
+
+func TestUpdateTranslationSettingsValidatesAgentLanguage(t *testing.T) {
+	ctx := context.Background()
+	settings := fakeSettingsStore{}
+	svc := NewTranslationService(newFakeTranslationStore(), newFakeTranslationBackend(), settings)
+
+	_, err := svc.UpdateSettings(ctx, uuid.New(), uuid.New(), &models.TranslationSettings{Enabled: true, AgentLanguage: "english"})
+	require.ErrorIs(t, err, ErrInvalidTranslationSettings)
+
+	updated, err := svc.UpdateSettings(ctx, uuid.New(), uuid.New(), &models.TranslationSettings{Enabled: true, AgentLanguage: " FR "})
+	require.NoError(t, err)
+	require.Equal(t, "fr", updated.AgentLanguage)
+	require.Equal(t, "fr", svc.Settings(ctx, uuid.New(), uuid.New()).AgentLanguage)
+	require.True(t, svc.Settings(ctx, uuid.New(), uuid.New()).Enabled)
+}
+
+func TestMaskPIIReversiblyRestoresPersonalData(t *testing.T) {
+	text := "Escríbeme a ana@example.com o a ana.lopez@example.org, mi IBAN es DE89 3704 0044 0532 0130 00"
+	masked, values, found := maskPIIReversibly(text, nil)
+	require.NotContains(t, masked, "ana@example.com")
+	require.NotContains(t, masked, "DE89")
+	require.Contains(t, masked, "[EMAIL_1]")
+	require.Contains(t, masked, "[EMAIL_2]")
+	require.Contains(t, masked, "[IBAN_1]")
+	require.Equal(t, 2, found[models.PIITypeEmail])
+
+	translated := "Write to me at [EMAIL_1] or [EMAIL_2], my IBAN is [IBAN_1]"
+	require.Equal(t, "Write to me at ana@example.com or ana.lopez@example.org, my IBAN is DE89 3704 0044 0532 0130 00", unmaskPII(translated, values))
+}
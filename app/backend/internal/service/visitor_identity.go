@@ -0,0 +1,125 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+// visitorConversationsLimit caps the history returned to a verified visitor
+const visitorConversationsLimit = 50
+
+var (
+	ErrIdentityTokenInvalid         = errors.New("invalid identity token")
+	ErrIdentityVerificationDisabled = errors.New("identity verification is not enabled for this widget")
+	ErrIdentitySecretMissing        = errors.New("generate an identity secret before enabling identity verification")
+)
+
+// VisitorIdentityClaims is the identity the customer's backend signs with the widget's identity
+// secret: {user_id, email, name, exp} as an HS256 JWT
+type VisitorIdentityClaims struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// VerifyVisitorIdentity validates an identity token against the widget's secret.
+// The token must be HMAC-signed, carry an expiry and name both a user ID and a valid email.
+func VerifyVisitorIdentity(secret, token string, now time.Time) (*VisitorIdentityClaims, error) {
+	if secret == "" || token == "" {
+		return nil, ErrIdentityTokenInvalid
+	}
+
+	claims := &VisitorIdentityClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	},
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentityTokenInvalid, err)
+	}
+
+	claims.Email = strings.ToLower(strings.TrimSpace(claims.Email))
+	if claims.UserID == "" || claims.Email == "" {
+		return nil, fmt.Errorf("%w: user_id and email are required", ErrIdentityTokenInvalid)
+	}
+	if _, err := mail.ParseAddress(claims.Email); err != nil {
+		return nil, fmt.Errorf("%w: invalid email", ErrIdentityTokenInvalid)
+	}
+	return claims, nil
+}
+
+// GenerateIdentitySecret creates a new per-widget identity secret
+func GenerateIdentitySecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// visitorIdentity is who a new chat session is linked to
+type visitorIdentity struct {
+	Email    string
+	Name     string
+	UserID   string
+	Verified bool
+}
+
+// resolveVisitorIdentity decides which customer a new session may be linked to and annotates the
+// visitor info accordingly. With identity verification enabled only a valid identity token links a
+// customer; a self-reported email is kept as unverified_email so agents can tell the difference.
+// The returned error explains a rejected token and is meant for logging only.
+func resolveVisitorIdentity(widget *models.ChatWidget, req *models.InitiateChatRequest, now time.Time) (visitorIdentity, models.JSONMap, error) {
+	info := models.JSONMap{}
+	for key, value := range req.VisitorInfo {
+		info[key] = value
+	}
+
+	if !widget.IdentityVerificationEnabled {
+		info["identity_verified"] = false
+		return visitorIdentity{Email: req.VisitorEmail, Name: req.VisitorName}, info, nil
+	}
+
+	var tokenErr error
+	if req.IdentityToken != "" {
+		secret := ""
+		if widget.IdentitySecret != nil {
+			secret = *widget.IdentitySecret
+		}
+		claims, err := VerifyVisitorIdentity(secret, req.IdentityToken, now)
+		if err == nil {
+			name := claims.Name
+			if name == "" {
+				name = req.VisitorName
+			}
+			info["identity_verified"] = true
+			info["verified_user_id"] = claims.UserID
+			info["visitor_email"] = claims.Email
+			delete(info, "unverified_email")
+			return visitorIdentity{Email: claims.Email, Name: name, UserID: claims.UserID, Verified: true}, info, nil
+		}
+		tokenErr = err
+	}
+
+	// Whatever the browser claims stays visible, but is never trusted
+	delete(info, "visitor_email")
+	delete(info, "verified_user_id")
+	if req.VisitorEmail != "" {
+		info["unverified_email"] = req.VisitorEmail
+	}
+	info["identity_verified"] = false
+	return visitorIdentity{Name: req.VisitorName}, info, tokenErr
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bareuptime/tms/internal/models"
+)
+
+func signIdentity(t *testing.T, method jwt.SigningMethod, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return token
+}
+
+func unsignedIdentity(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	return token
+}
+
+func TestVerifyVisitorIdentity(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	secret := "widget-secret"
+	valid := jwt.MapClaims{"user_id": "u-42", "email": " Jane@Example.com ", "name": "Jane", "exp": now.Add(time.Hour).Unix()}
+
+	claims, err := VerifyVisitorIdentity(secret, signIdentity(t, jwt.SigningMethodHS256, secret, valid), now)
+	require.NoError(t, err)
+	require.Equal(t, "u-42", claims.UserID)
+	require.Equal(t, "jane@example.com", claims.Email)
+	require.Equal(t, "Jane", claims.Name)
+
+	cases := map[string]string{
+		"wrong secret":   signIdentity(t, jwt.SigningMethodHS256, "other-secret", valid),
+		"expired":        signIdentity(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"user_id": "u-42", "email": "jane@example.com", "exp": now.Add(-time.Minute).Unix()}),
+		"no expiry":      signIdentity(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"user_id": "u-42", "email": "jane@example.com"}),
+		"no user id":     signIdentity(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"email": "jane@example.com", "exp": now.Add(time.Hour).Unix()}),
+		"invalid email":  signIdentity(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"user_id": "u-42", "email": "not-an-email", "exp": now.Add(time.Hour).Unix()}),
+		"unsigned token": unsignedIdentity(t, valid),
+		"garbage":        "not.a.token",
+	}
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := VerifyVisitorIdentity(secret, token, now)
+			require.ErrorIs(t, err, ErrIdentityTokenInvalid)
+		})
+	}
+
+	_, err = VerifyVisitorIdentity("", signIdentity(t, jwt.SigningMethodHS256, "", valid), now)
+	require.ErrorIs(t, err, ErrIdentityTokenInvalid)
+}
+
+func TestResolveVisitorIdentity(t *testing.T) {
+	now := time.Now()
+	secret := "widget-secret"
+	verifiedWidget := &models.ChatWidget{IdentityVerificationEnabled: true, IdentitySecret: &secret}
+	token := signIdentity(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{
+		"user_id": "u-42", "email": "jane@example.com", "name": "Jane Doe", "exp": now.Add(time.Hour).Unix(),
+	})
+
+	t.Run("verified token links the signed identity", func(t *testing.T) {
+		identity, info, err := resolveVisitorIdentity(verifiedWidget, &models.InitiateChatRequest{
+			VisitorName:   "Jane",
+			VisitorEmail:  "someone-else@example.com",
+			IdentityToken: token,
+			VisitorInfo:   models.JSONMap{"visitor_email": "someone-else@example.com"},
+		}, now)
+		require.NoError(t, err)
+		require.Equal(t, visitorIdentity{Email: "jane@example.com", Name: "Jane Doe", UserID: "u-42", Verified: true}, identity)
+		require.Equal(t, true, info["identity_verified"])
+		require.Equal(t, "u-42", info["verified_user_id"])
+		require.Equal(t, "jane@example.com", info["visitor_email"])
+	})
+
+	t.Run("self-reported email is never linked when verification is on", func(t *testing.T) {
+		req := &models.InitiateChatRequest{
+			VisitorName:  "Mallory",
+			VisitorEmail: "jane@example.com",
+			VisitorInfo:  models.JSONMap{"visitor_email": "jane@example.com", "language": "en"},
+		}
+		identity, info, err := resolveVisitorIdentity(verifiedWidget, req, now)
+		require.NoError(t, err)
+		require.False(t, identity.Verified)
+		require.Empty(t, identity.Email)
+		require.Equal(t, false, info["identity_verified"])
+		require.Equal(t, "jane@example.com", info["unverified_email"])
+		require.NotContains(t, info, "visitor_email")
+		require.Equal(t, "en", info["language"])
+		// The request's own map is left untouched
+		require.Equal(t, "jane@example.com", req.VisitorInfo["visitor_email"])
+
+		req.IdentityToken = "forged"
+		identity, _, err = resolveVisitorIdentity(verifiedWidget, req, now)
+		require.ErrorIs(t, err, ErrIdentityTokenInvalid)
+		require.Empty(t, identity.Email)
+	})
+
+	t.Run("without verification the email is linked but marked unverified", func(t *testing.T) {
+		identity, info, err := resolveVisitorIdentity(&models.ChatWidget{}, &models.InitiateChatRequest{
+			VisitorName:  "Jane",
+			VisitorEmail: "jane@example.com",
+		}, now)
+		require.NoError(t, err)
+		require.Equal(t, visitorIdentity{Email: "jane@example.com", Name: "Jane"}, identity)
+		require.Equal(t, false, info["identity_verified"])
+	})
+}
@@ -26,6 +26,8 @@ type WebScrapingService struct {
 	embeddingService         *EmbeddingService
 	config                   *config.KnowledgeConfig
 	headlessBrowserExtractor *HeadlessBrowserURLExtractor
+	planService              *PlanService
+	knowledgeListener        KnowledgeChangeListener
 }
 
 const (
@@ -91,7 +93,7 @@ type URLExtractionEvent struct {
 	DepthMetrics *DepthMetrics     `json:"depth_metrics,omitempty"` // Per-depth metrics
 }
 
-func NewWebScrapingService(knowledgeRepo *repo.KnowledgeRepository, embeddingService *EmbeddingService, cfg *config.KnowledgeConfig) *WebScrapingService {
+func NewWebScrapingService(knowledgeRepo *repo.KnowledgeRepository, embeddingService *EmbeddingService, cfg *config.KnowledgeConfig, planService *PlanService) *WebScrapingService {
 	// Initialize headless browser extractor with 30 second timeout
 	headlessExtractor := NewHeadlessBrowserURLExtractor(30*time.Second, "")
 
@@ -100,6 +102,19 @@ func NewWebScrapingService(knowledgeRepo *repo.KnowledgeRepository, embeddingSer
 		embeddingService:         embeddingService,
 		config:                   cfg,
 		headlessBrowserExtractor: headlessExtractor,
+		planService:              planService,
+	}
+}
+
+// SetKnowledgeChangeListener tells the listener when pages of a project were indexed
+func (s *WebScrapingService) SetKnowledgeChangeListener(listener KnowledgeChangeListener) {
+	s.knowledgeListener = listener
+}
+
+// knowledgeChanged tells the listener, if any, that the knowledge of a project changed
+func (s *WebScrapingService) knowledgeChanged(ctx context.Context, tenantID, projectID uuid.UUID) {
+	if s.knowledgeListener != nil {
+		s.knowledgeListener.KnowledgeChanged(ctx, tenantID, projectID)
 	}
 }
 
@@ -110,6 +125,11 @@ func (s *WebScrapingService) CreateScrapingJob(ctx context.Context, tenantID, pr
 		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
+	// Refuse new crawls once the knowledge page limit of the tenant's plan is reached
+	if err := s.planService.CheckKnowledgePageLimit(ctx, tenantID, 1); err != nil {
+		return nil, err
+	}
+
 	// Create scraping job
 	job := &models.KnowledgeScrapingJob{
 		ID:        uuid.New(),
@@ -140,6 +160,11 @@ func (s *WebScrapingService) CreateScrapingJobWithStreamAndBrowser(ctx context.C
 		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
+	// Refuse new crawls once the knowledge page limit of the tenant's plan is reached
+	if err := s.planService.CheckKnowledgePageLimit(ctx, tenantID, 1); err != nil {
+		return nil, err
+	}
+
 	// Create scraping job
 	job := &models.KnowledgeScrapingJob{
 		ID:        uuid.New(),
@@ -170,6 +195,11 @@ func (s *WebScrapingService) CreateScrapingJobWithStream(ctx context.Context, te
 		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
+	// Refuse new crawls once the knowledge page limit of the tenant's plan is reached
+	if err := s.planService.CheckKnowledgePageLimit(ctx, tenantID, 1); err != nil {
+		return nil, err
+	}
+
 	// Create scraping job
 	job := &models.KnowledgeScrapingJob{
 		ID:        uuid.New(),
@@ -755,6 +785,15 @@ func (s *WebScrapingService) StreamIndexing(ctx context.Context, jobID, tenantID
 		return fmt.Errorf("no links have been selected for indexing")
 	}
 
+	// The crawl was only checked for room for one page, so index no more pages than the plan has left
+	remaining, err := s.planService.RemainingKnowledgePages(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		return fmt.Errorf("%w: no knowledge pages left to index", ErrPlanLimitReached)
+	}
+
 	if err := s.knowledgeRepo.StartIndexingJob(jobID, job.SelectedLinks); err != nil {
 		return fmt.Errorf("failed to mark job as indexing: %w", err)
 	}
@@ -777,6 +816,15 @@ func (s *WebScrapingService) StreamIndexing(ctx context.Context, jobID, tenantID
 
 	processed := 0
 	for _, url := range job.SelectedLinks {
+		if remaining >= 0 && len(pagesForIndex) >= remaining {
+			s.sendIndexingEvent(ctx, events, IndexingEvent{
+				Type:      "warning",
+				Message:   fmt.Sprintf("Knowledge page limit of the plan reached, indexing only %d page(s)", remaining),
+				Timestamp: time.Now(),
+			})
+			break
+		}
+
 		s.sendIndexingEvent(ctx, events, IndexingEvent{
 			Type:      "processing",
 			Message:   fmt.Sprintf("Fetching content from: %s", url),
@@ -993,6 +1041,7 @@ func (s *WebScrapingService) StreamIndexing(ctx context.Context, jobID, tenantID
 		})
 		return fmt.Errorf("failed to mark indexing job complete: %w", err)
 	}
+	s.knowledgeChanged(ctx, tenantID, projectID)
 
 	s.sendIndexingEvent(ctx, events, IndexingEvent{
 		Type:        "completed",
@@ -2287,6 +2336,11 @@ type ScrapeURLsResult struct {
 
 // ScrapeURLs performs simplified URL scraping - scrapes exact URLs provided without crawling
 func (s *WebScrapingService) ScrapeURLs(ctx context.Context, tenantID, projectID uuid.UUID, urls []string, forceRefresh bool) (*ScrapeURLsResult, error) {
+	// Every requested URL counts against the knowledge page limit of the tenant's plan
+	if err := s.planService.CheckKnowledgePageLimit(ctx, tenantID, len(urls)); err != nil {
+		return nil, err
+	}
+
 	// Create a scraping job to track progress
 	job := &models.KnowledgeScrapingJob{
 		ID:         uuid.New(),
@@ -2431,6 +2485,10 @@ func (s *WebScrapingService) ScrapeURLs(ctx context.Context, tenantID, projectID
 		logger.GetTxLogger(ctx).Error().Err(err).Msg("Failed to update job status")
 	}
 
+	if result.PagesAdded > 0 {
+		s.knowledgeChanged(ctx, tenantID, projectID)
+	}
+
 	return result, nil
 }
 
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 )
@@ -24,18 +23,25 @@ const (
 	ConnectionTypeAiAgent ConnectionType = "ai-agent"
 )
 
+// MessageWriter is the client end of a connection: a WebSocket, or a QueueWriter for clients that
+// receive messages over Server-Sent Events or long polling
+type MessageWriter interface {
+	WriteJSON(v interface{}) error
+	Close() error
+}
+
 // Connection represents a WebSocket connection with metadata
 type Connection struct {
-	ID           string          `json:"id"`
-	SessionID    uuid.UUID       `json:"session_id"`
-	Type         ConnectionType  `json:"type"`
-	AgentID      *uuid.UUID      `json:"user_id,omitempty"` // For agents
-	ServerID     string          `json:"server_id"`         // Which server instance holds this connection
-	ConnectedAt  time.Time       `json:"connected_at"`
-	LastPingAt   time.Time       `json:"last_ping_at"`
-	ProjectIDs   []uuid.UUID     `json:"project_ids"`
-	WsConnection *websocket.Conn `json:"-"`
-	writeMutex   sync.Mutex      `json:"-"` // Mutex to synchronize WebSocket writes
+	ID           string         `json:"id"`
+	SessionID    uuid.UUID      `json:"session_id"`
+	Type         ConnectionType `json:"type"`
+	AgentID      *uuid.UUID     `json:"user_id,omitempty"` // For agents
+	ServerID     string         `json:"server_id"`         // Which server instance holds this connection
+	ConnectedAt  time.Time      `json:"connected_at"`
+	LastPingAt   time.Time      `json:"last_ping_at"`
+	ProjectIDs   []uuid.UUID    `json:"project_ids"`
+	ClientWriter MessageWriter  `json:"-"`
+	writeMutex   sync.Mutex     `json:"-"` // Mutex to synchronize WebSocket writes
 }
 
 type DeliveryType string
@@ -56,6 +62,7 @@ type Message struct {
 	ProjectID    *uuid.UUID      `json:"project_id,omitempty"`
 	AgentID      *uuid.UUID      `json:"agent_id,omitempty"`
 	DeliveryType DeliveryType    `json:"delivery_type"`
+	ConnectionID string          `json:"connection_id,omitempty"` // Set when sent to a single connection held by another server
 }
 
 // MessageHandler is a callback function for handling incoming messages
@@ -108,7 +115,7 @@ func NewConnectionManager(redisClient redis.UniversalClient) *ConnectionManager
 }
 
 // AddConnection registers a new WebSocket connection in Redis only
-func (cm *ConnectionManager) AddConnection(connType ConnectionType, sessionID uuid.UUID, projectIDs []uuid.UUID, agentID *uuid.UUID, conn *websocket.Conn) (string, error) {
+func (cm *ConnectionManager) AddConnection(connType ConnectionType, sessionID uuid.UUID, projectIDs []uuid.UUID, agentID *uuid.UUID, conn MessageWriter) (string, error) {
 	connID := uuid.New().String()
 	// Store WebSocket connection locally for this server instance
 
@@ -117,7 +124,7 @@ func (cm *ConnectionManager) AddConnection(connType ConnectionType, sessionID uu
 		SessionID:    sessionID,
 		Type:         connType,
 		AgentID:      agentID,
-		WsConnection: conn,
+		ClientWriter: conn,
 		ProjectIDs:   projectIDs,
 		ServerID:     cm.serverID,
 		ConnectedAt:  time.Now(),
@@ -172,7 +179,7 @@ func (cm *ConnectionManager) RemoveConnection(connID string) {
 	var connection *Connection
 	cm.connMutex.Lock()
 	if conn, exists := cm.localConnections[connID]; exists {
-		conn.WsConnection.Close()
+		conn.ClientWriter.Close()
 		connection = conn
 		delete(cm.localConnections, connID)
 	}
@@ -234,31 +241,51 @@ func (cm *ConnectionManager) DeliverWebSocketMessage(sessionID uuid.UUID, messag
 	return nil
 }
 
-// SendToConnection sends a message to a specific connection via Redis
+// SendToConnection sends a message to a specific connection. Connections held by another server are
+// reached via Redis.
 func (cm *ConnectionManager) SendToConnection(connID string, message *Message) {
-	// Since we don't store local connections, always publish to Redis for delivery
+	if connID == "" {
+		return
+	}
 	message.Timestamp = time.Now()
 
+	if cm.writeToLocalConnection(connID, message) {
+		return
+	}
+
+	direct := *message
+	direct.ConnectionID = connID
+	msgBytes, _ := json.Marshal(&direct)
+	if err := cm.redis.Publish(cm.ctx, "pubsub:livechat", msgBytes).Err(); err != nil {
+		log.Error().Err(err).Str("connection_id", connID).Msg("Failed to publish connection message to Redis")
+	}
+}
+
+// writeToLocalConnection writes a message to a connection held by this server; it reports false when
+// the connection is not here
+func (cm *ConnectionManager) writeToLocalConnection(connID string, message *Message) bool {
 	cm.connMutex.RLock()
 	conn, exists := cm.localConnections[connID]
 	cm.connMutex.RUnlock()
 
-	if exists {
-		message.SessionID = conn.SessionID
+	if !exists {
+		return false
+	}
 
-		// Use mutex to synchronize WebSocket writes and prevent concurrent write panic
-		conn.writeMutex.Lock()
-		err := conn.WsConnection.WriteJSON(message)
-		conn.writeMutex.Unlock()
+	message.SessionID = conn.SessionID
+	message.ConnectionID = ""
 
-		if err != nil {
-			log.Error().Err(err).Str("connection_id", connID).Msg("Failed to deliver session message to local connection")
-			// Remove failed connection in background
-			go cm.RemoveConnection(connID)
-		} else {
-			// log.Debug().Str("connection_id", connID).Str("session_id", message.SessionID.String()).Msg("Successfully delivered session message to local connection")
-		}
+	// Use mutex to synchronize WebSocket writes and prevent concurrent write panic
+	conn.writeMutex.Lock()
+	err := conn.ClientWriter.WriteJSON(message)
+	conn.writeMutex.Unlock()
+
+	if err != nil {
+		log.Error().Err(err).Str("connection_id", connID).Msg("Failed to deliver session message to local connection")
+		// Remove failed connection in background
+		go cm.RemoveConnection(connID)
 	}
+	return true
 }
 
 // SendToProjectAgents sends a message to all agents connected to a specific project
@@ -336,7 +363,7 @@ func (cm *ConnectionManager) Shutdown() {
 	// Close all local WebSocket connections
 	cm.connMutex.Lock()
 	for connID, conn := range cm.localConnections {
-		conn.WsConnection.Close()
+		conn.ClientWriter.Close()
 		delete(cm.localConnections, connID)
 	}
 	cm.connMutex.Unlock()
@@ -376,6 +403,10 @@ func (cm *ConnectionManager) handleRedisMessage(msg *redis.Message) {
 		return
 	}
 
+	if message.ConnectionID != "" {
+		cm.writeToLocalConnection(message.ConnectionID, &message)
+		return
+	}
 	cm.deliverSessionMessage(&message)
 }
 
@@ -443,7 +474,7 @@ func (cm *ConnectionManager) deliverSessionMessage(message *Message) {
 
 			// Use mutex to synchronize WebSocket writes and prevent concurrent write panic
 			conn.writeMutex.Lock()
-			err := conn.WsConnection.WriteJSON(message)
+			err := conn.ClientWriter.WriteJSON(message)
 			conn.writeMutex.Unlock()
 
 			if err != nil {
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrQueueClosed = errors.New("connection closed")
+	ErrQueueFull   = errors.New("connection queue full")
+)
+
+// QueueWriter is the client end of a connection for clients that cannot use WebSockets. Messages are
+// queued until the client picks them up over Server-Sent Events or a long poll. A client that falls
+// too far behind overflows the queue, which drops the connection like a failed WebSocket write; it
+// catches up on chat messages when it reconnects.
+type QueueWriter struct {
+	messages  chan json.RawMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewQueueWriter creates a queue holding up to size undelivered messages
+func NewQueueWriter(size int) *QueueWriter {
+	return &QueueWriter{
+		messages: make(chan json.RawMessage, size),
+		closed:   make(chan struct{}),
+	}
+}
+
+// WriteJSON queues a message for the client
+func (q *QueueWriter) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-q.closed:
+		return ErrQueueClosed
+	default:
+	}
+
+	select {
+	case q.messages <- data:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close closes the connection; queued messages are dropped
+func (q *QueueWriter) Close() error {
+	q.closeOnce.Do(func() { close(q.closed) })
+	return nil
+}
+
+// Messages delivers the queued messages in order
+func (q *QueueWriter) Messages() <-chan json.RawMessage {
+	return q.messages
+}
+
+// Done is closed once the connection is closed
+func (q *QueueWriter) Done() <-chan struct{} {
+	return q.closed
+}
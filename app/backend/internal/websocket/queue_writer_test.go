@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueWriterQueuesUntilFullOrClosed(t *testing.T) {
+	queue := NewQueueWriter(2)
+
+	require.NoError(t, queue.WriteJSON(&Message{Type: "first"}))
+	require.NoError(t, queue.WriteJSON(&Message{Type: "second"}))
+	require.ErrorIs(t, queue.WriteJSON(&Message{Type: "third"}), ErrQueueFull)
+
+	var message Message
+	require.NoError(t, json.Unmarshal(<-queue.Messages(), &message))
+	require.Equal(t, "first", message.Type)
+
+	require.NoError(t, queue.Close())
+	require.NoError(t, queue.Close())
+	require.ErrorIs(t, queue.WriteJSON(&Message{Type: "fourth"}), ErrQueueClosed)
+	<-queue.Done()
+}
+
+func TestSendToConnectionReachesConnectionOnAnotherServer(t *testing.T) {
+	mini, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mini.Close)
+
+	newManager := func() *ConnectionManager {
+		client := redis.NewClient(&redis.Options{Addr: mini.Addr()})
+		cm := NewConnectionManager(client)
+		t.Cleanup(cm.Shutdown)
+		return cm
+	}
+	holder, sender := newManager(), newManager()
+
+	queue := NewQueueWriter(4)
+	sessionID := uuid.New()
+	connID, err := holder.AddConnection(ConnectionTypeVisitor, sessionID, []uuid.UUID{uuid.New()}, nil, queue)
+	require.NoError(t, err)
+
+	// Wait for both servers to subscribe before publishing
+	require.Eventually(t, func() bool {
+		return mini.PubSubNumPat() >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	sender.SendToConnection(connID, &Message{Type: "chat_message", Data: json.RawMessage(`{"seq":1}`)})
+
+	select {
+	case raw := <-queue.Messages():
+		var message Message
+		require.NoError(t, json.Unmarshal(raw, &message))
+		require.Equal(t, "chat_message", message.Type)
+		require.Equal(t, sessionID, message.SessionID)
+		require.Empty(t, message.ConnectionID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message did not reach the connection on the other server")
+	}
+}
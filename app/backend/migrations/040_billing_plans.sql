@@ -0,0 +1,68 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Billing plans define the limits and monthly AI credit allowance of a subscription tier.
+-- A NULL limit means the plan does not restrict that resource.
+CREATE TABLE IF NOT EXISTS billing_plans (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    seat_limit INTEGER,
+    project_limit INTEGER,
+    widget_limit INTEGER,
+    knowledge_page_limit INTEGER,
+    monthly_ai_credits BIGINT NOT NULL DEFAULT 0,
+    low_balance_threshold BIGINT NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+
+    CONSTRAINT billing_plans_limits_non_negative CHECK (
+        COALESCE(seat_limit, 0) >= 0 AND
+        COALESCE(project_limit, 0) >= 0 AND
+        COALESCE(widget_limit, 0) >= 0 AND
+        COALESCE(knowledge_page_limit, 0) >= 0 AND
+        monthly_ai_credits >= 0 AND
+        low_balance_threshold >= 0
+    )
+);
+
+-- Tenant plan subscriptions (one active plan per tenant)
+CREATE TABLE IF NOT EXISTS tenant_plans (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    plan_id UUID NOT NULL REFERENCES billing_plans(id),
+    last_grant_at TIMESTAMP WITH TIME ZONE,
+    low_balance_notified_at TIMESTAMP WITH TIME ZONE,
+    exhausted_notified_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_plans_plan_id ON tenant_plans(plan_id);
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_tenant_created ON credit_transactions(tenant_id, created_at);
+
+-- Seed default plans
+INSERT INTO billing_plans (code, name, seat_limit, project_limit, widget_limit, knowledge_page_limit, monthly_ai_credits, low_balance_threshold)
+VALUES
+    ('free', 'Free', 2, 1, 1, 50, 10000, 1000),
+    ('starter', 'Starter', 5, 3, 3, 500, 100000, 10000),
+    ('growth', 'Growth', 20, 5, 10, 2500, 500000, 50000),
+    ('enterprise', 'Enterprise', NULL, NULL, NULL, NULL, 2000000, 200000)
+ON CONFLICT (code) DO NOTHING;
+
+COMMENT ON TABLE billing_plans IS 'Subscription plans with seat, project, widget and knowledge page limits';
+COMMENT ON COLUMN billing_plans.monthly_ai_credits IS 'Credits granted to the tenant at the start of every month';
+COMMENT ON COLUMN billing_plans.low_balance_threshold IS 'Balance at or below which tenant admins are warned';
+COMMENT ON TABLE tenant_plans IS 'Active billing plan per tenant';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_credit_transactions_tenant_created;
+DROP INDEX IF EXISTS idx_tenant_plans_plan_id;
+DROP TABLE IF EXISTS tenant_plans;
+DROP TABLE IF EXISTS billing_plans;
+
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Optional IP allowlist for API keys. An empty list allows requests from any address.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[] NOT NULL DEFAULT '{}';
+
+-- Scopes are now enforced. Existing keys could only reach the ticket endpoints,
+-- so keep them working by granting exactly that.
+UPDATE api_keys
+SET scopes = ARRAY['ticket:read', 'ticket:write'], updated_at = NOW()
+WHERE scopes IS NULL OR COALESCE(array_length(scopes, 1), 0) = 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS allowed_cidrs;
+
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Tenant defined roles built from the permission catalogue.
+-- They are assigned through agent_project_roles like the built-in roles.
+CREATE TABLE IF NOT EXISTS tenant_roles (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    description TEXT,
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+
+    CONSTRAINT tenant_roles_tenant_name_unique UNIQUE (tenant_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_roles_tenant_id ON tenant_roles(tenant_id);
+
+-- Role bindings must be able to reference custom role names, not only the role_type enum
+ALTER TABLE agent_project_roles ALTER COLUMN role TYPE VARCHAR(50) USING role::text;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM agent_project_roles
+WHERE role NOT IN ('tenant_admin', 'project_admin', 'supervisor', 'agent', 'read_only');
+
+ALTER TABLE agent_project_roles ALTER COLUMN role TYPE role_type USING role::role_type;
+
+DROP TABLE IF EXISTS tenant_roles;
+
+-- +goose StatementEnd
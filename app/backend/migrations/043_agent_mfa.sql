@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- TOTP second factor of an agent. The secret is encrypted at rest and the
+-- factor only counts once enrollment has been confirmed with a valid code.
+CREATE TABLE IF NOT EXISTS agent_mfa (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    secret_encrypted TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled_at TIMESTAMP WITH TIME ZONE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_mfa_tenant_id ON agent_mfa(tenant_id);
+
+-- One-time recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS agent_mfa_recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+
+    CONSTRAINT agent_mfa_recovery_codes_unique UNIQUE (agent_id, code_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_mfa_recovery_codes_agent ON agent_mfa_recovery_codes(tenant_id, agent_id);
+
+-- Tenant wide authentication policy
+CREATE TABLE IF NOT EXISTS tenant_security_settings (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    mfa_policy VARCHAR(20) NOT NULL DEFAULT 'off' CHECK (mfa_policy IN ('off', 'admins', 'all')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS tenant_security_settings;
+DROP TABLE IF EXISTS agent_mfa_recovery_codes;
+DROP TABLE IF EXISTS agent_mfa;
+
+-- +goose StatementEnd
@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Per-tenant single sign-on identity providers (generic OIDC or SAML 2.0).
+-- OIDC providers use issuer_url/client_id/client_secret, SAML providers the idp_* columns.
+CREATE TABLE IF NOT EXISTS tenant_sso_providers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    protocol VARCHAR(10) NOT NULL CHECK (protocol IN ('oidc', 'saml')),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+
+    issuer_url TEXT,
+    client_id TEXT,
+    client_secret_encrypted TEXT,
+    scopes TEXT[] NOT NULL DEFAULT '{openid,email,profile}',
+
+    idp_entity_id TEXT,
+    idp_sso_url TEXT,
+    idp_certificate TEXT,
+
+    groups_claim VARCHAR(255) NOT NULL DEFAULT 'groups',
+    email_domains TEXT[] NOT NULL DEFAULT '{}',
+    jit_provisioning BOOLEAN NOT NULL DEFAULT TRUE,
+    default_role VARCHAR(50) NOT NULL DEFAULT 'agent',
+    default_project_id UUID REFERENCES projects(id) ON DELETE SET NULL,
+    group_mappings JSONB NOT NULL DEFAULT '[]',
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+
+    CONSTRAINT tenant_sso_providers_tenant_name_unique UNIQUE (tenant_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_sso_providers_tenant_id ON tenant_sso_providers(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_tenant_sso_providers_email_domains ON tenant_sso_providers USING GIN (email_domains);
+
+-- Tenants using SSO can turn off password logins
+ALTER TABLE tenant_security_settings ADD COLUMN IF NOT EXISTS password_login_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE tenant_security_settings DROP COLUMN IF EXISTS password_login_disabled;
+
+DROP TABLE IF EXISTS tenant_sso_providers;
+
+-- +goose StatementEnd
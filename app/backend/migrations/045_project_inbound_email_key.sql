@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Key used in the tenant-<key>@<mail domain> address that the bundled SMTP email-server
+-- forwards to the email-to-ticket endpoint. Existing projects get a random key on upgrade.
+ALTER TABLE projects
+    ADD COLUMN IF NOT EXISTS inbound_email_key VARCHAR(64) NOT NULL DEFAULT substr(md5(gen_random_uuid()::text), 1, 12);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_inbound_email_key ON projects(inbound_email_key);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_projects_inbound_email_key;
+ALTER TABLE projects DROP COLUMN IF EXISTS inbound_email_key;
+
+-- +goose StatementEnd
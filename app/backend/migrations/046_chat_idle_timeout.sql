@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Per-widget inactivity handling: warn the visitor after idle_warning_minutes, end the
+-- session after idle_timeout_minutes (0 disables either step) and optionally email the transcript.
+ALTER TABLE chat_widgets
+    ADD COLUMN IF NOT EXISTS idle_warning_minutes INTEGER NOT NULL DEFAULT 10,
+    ADD COLUMN IF NOT EXISTS idle_timeout_minutes INTEGER NOT NULL DEFAULT 30,
+    ADD COLUMN IF NOT EXISTS send_transcript BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS transcript_archive_email VARCHAR(255);
+
+-- Set when the idle warning was sent; cleared again by any conversation activity
+ALTER TABLE chat_sessions
+    ADD COLUMN IF NOT EXISTS idle_warned_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_chat_sessions_active_last_activity
+    ON chat_sessions(last_activity_at)
+    WHERE status = 'active';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_chat_sessions_active_last_activity;
+ALTER TABLE chat_sessions DROP COLUMN IF EXISTS idle_warned_at;
+ALTER TABLE chat_widgets
+    DROP COLUMN IF EXISTS transcript_archive_email,
+    DROP COLUMN IF EXISTS send_transcript,
+    DROP COLUMN IF EXISTS idle_timeout_minutes,
+    DROP COLUMN IF EXISTS idle_warning_minutes;
+
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Chats whose latest visitor message has gone unanswered for this many minutes, with no agent
+-- assigned, are converted to tickets and the visitor is emailed a follow-up link. 0, the default,
+-- leaves conversion off until a widget opts in.
+ALTER TABLE chat_widgets
+    ADD COLUMN IF NOT EXISTS unanswered_ticket_minutes INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE chat_widgets DROP COLUMN IF EXISTS unanswered_ticket_minutes;
+
+-- +goose StatementEnd
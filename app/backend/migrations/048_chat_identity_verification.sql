@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Identity verification: the customer's backend signs the visitor identity with the widget's
+-- secret. When enabled, sessions are only linked to customers for verified identities.
+ALTER TABLE chat_widgets
+    ADD COLUMN IF NOT EXISTS identity_verification_enabled BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS identity_secret VARCHAR(128);
+
+ALTER TABLE chat_sessions
+    ADD COLUMN IF NOT EXISTS identity_verified BOOLEAN NOT NULL DEFAULT false;
+
+-- Past conversations of a verified visitor
+CREATE INDEX IF NOT EXISTS idx_chat_sessions_verified_customer
+    ON chat_sessions(customer_id, started_at DESC)
+    WHERE identity_verified = true;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_chat_sessions_verified_customer;
+ALTER TABLE chat_sessions DROP COLUMN IF EXISTS identity_verified;
+ALTER TABLE chat_widgets
+    DROP COLUMN IF EXISTS identity_secret,
+    DROP COLUMN IF EXISTS identity_verification_enabled;
+
+-- +goose StatementEnd
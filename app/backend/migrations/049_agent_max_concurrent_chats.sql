@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Intentionally empty: agent chat capacity is stored in the existing agents.max_chats column. The
+-- migration is kept so the numbered sequence stays contiguous.
+SELECT 1;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+SELECT 1;
+
+-- +goose StatementEnd
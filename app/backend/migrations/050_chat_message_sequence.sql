@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Per-session message sequence numbers, so clients can order messages and resume after a reconnect
+ALTER TABLE chat_sessions ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+UPDATE chat_messages m
+SET seq = numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at, id) AS seq
+    FROM chat_messages
+) numbered
+WHERE m.id = numbered.id AND m.seq IS NULL;
+
+UPDATE chat_sessions cs
+SET last_seq = COALESCE((SELECT MAX(m.seq) FROM chat_messages m WHERE m.session_id = cs.id), 0);
+
+ALTER TABLE chat_messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_messages_session_seq ON chat_messages (session_id, seq);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_chat_messages_session_seq;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS seq;
+ALTER TABLE chat_sessions DROP COLUMN IF EXISTS last_seq;
+
+-- +goose StatementEnd
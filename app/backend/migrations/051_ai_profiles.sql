@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Per-project AI assistant profiles. A profile without a widget applies to the whole project, one with a
+-- widget overrides it for that widget. Unset columns fall back to the next level, then to the global AI config.
+CREATE TABLE IF NOT EXISTS ai_profiles (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    widget_id UUID REFERENCES chat_widgets(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+
+    persona_name VARCHAR(100),
+    system_prompt TEXT,
+    allowed_topics TEXT[] NOT NULL DEFAULT '{}',
+    forbidden_phrases TEXT[] NOT NULL DEFAULT '{}',
+    handoff_keywords TEXT[] NOT NULL DEFAULT '{}',
+
+    provider VARCHAR(50),
+    model VARCHAR(255),
+    temperature DOUBLE PRECISION CHECK (temperature >= 0 AND temperature <= 2),
+    max_tokens INTEGER CHECK (max_tokens > 0),
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+-- One project-wide profile and one profile per widget
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ai_profiles_project_default ON ai_profiles(project_id) WHERE widget_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ai_profiles_project_widget ON ai_profiles(project_id, widget_id) WHERE widget_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_ai_profiles_tenant_project ON ai_profiles(tenant_id, project_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS ai_profiles;
+
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- HTTP tools a project's AI assistant may call, such as an order-status API. The parameters are a JSON
+-- schema the model fills in; auth headers are stored encrypted.
+CREATE TABLE IF NOT EXISTS ai_tools (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    description TEXT NOT NULL,
+    parameters JSONB NOT NULL DEFAULT '{"type": "object", "properties": {}}',
+    method VARCHAR(10) NOT NULL DEFAULT 'POST' CHECK (method IN ('GET', 'POST')),
+    url TEXT NOT NULL,
+    headers_encrypted TEXT,
+    header_names TEXT[] NOT NULL DEFAULT '{}',
+    timeout_ms INTEGER NOT NULL DEFAULT 5000 CHECK (timeout_ms > 0),
+    max_response_bytes INTEGER NOT NULL DEFAULT 16384 CHECK (max_response_bytes > 0),
+    requires_verified_visitor BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ai_tools_project_name ON ai_tools(project_id, name);
+CREATE INDEX IF NOT EXISTS idx_ai_tools_tenant_project ON ai_tools(tenant_id, project_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS ai_tools;
+
+-- +goose StatementEnd
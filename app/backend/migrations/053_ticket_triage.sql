@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- AI triage decisions for tickets: the type, priority, tags, language and sentiment the classifier chose,
+-- which of them were applied to the ticket, and how an agent reviewed them, for accuracy tracking.
+CREATE TABLE IF NOT EXISTS ticket_triage (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    ticket_id UUID NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+    method VARCHAR(20) NOT NULL CHECK (method IN ('heuristic', 'llm')),
+    model VARCHAR(100),
+    type VARCHAR(20) NOT NULL,
+    type_confidence DOUBLE PRECISION NOT NULL DEFAULT 0,
+    priority VARCHAR(20) NOT NULL,
+    priority_confidence DOUBLE PRECISION NOT NULL DEFAULT 0,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    language VARCHAR(16),
+    sentiment VARCHAR(20) NOT NULL DEFAULT 'neutral',
+    urgency VARCHAR(20) NOT NULL DEFAULT 'normal',
+    previous_type VARCHAR(20) NOT NULL,
+    previous_priority VARCHAR(20) NOT NULL,
+    applied_fields TEXT[] NOT NULL DEFAULT '{}',
+    suggested_fields TEXT[] NOT NULL DEFAULT '{}',
+    review_status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (review_status IN ('pending', 'accepted', 'corrected')),
+    reviewed_type VARCHAR(20),
+    reviewed_priority VARCHAR(20),
+    reviewed_tags TEXT[],
+    reviewed_by UUID REFERENCES agents(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ticket_triage_ticket ON ticket_triage(ticket_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_ticket_triage_project_review ON ticket_triage(tenant_id, project_id, review_status, created_at DESC);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS ticket_triage;
+
+-- +goose StatementEnd
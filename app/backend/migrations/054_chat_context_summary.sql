@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Rolling summary of the older part of a chat, sent to the model in place of the messages it covers
+-- once the conversation no longer fits the model's context budget.
+ALTER TABLE chat_sessions ADD COLUMN IF NOT EXISTS context_summary TEXT;
+ALTER TABLE chat_sessions ADD COLUMN IF NOT EXISTS context_summary_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE chat_sessions ADD COLUMN IF NOT EXISTS context_summary_updated_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN chat_sessions.context_summary_seq IS 'Seq of the last chat message covered by context_summary';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE chat_sessions DROP COLUMN IF EXISTS context_summary_updated_at;
+ALTER TABLE chat_sessions DROP COLUMN IF EXISTS context_summary_seq;
+ALTER TABLE chat_sessions DROP COLUMN IF EXISTS context_summary;
+
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Every time the AI guardrails masked personal data, dropped a knowledge chunk or blocked a message or
+-- reply. Details hold the kinds and counts of what was found, never the values themselves.
+CREATE TABLE IF NOT EXISTS ai_guardrail_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    session_id UUID REFERENCES chat_sessions(id) ON DELETE SET NULL,
+    ticket_id UUID REFERENCES tickets(id) ON DELETE SET NULL,
+    stage VARCHAR(20) NOT NULL CHECK (stage IN ('input', 'knowledge', 'output', 'storage')),
+    kind VARCHAR(30) NOT NULL CHECK (kind IN ('pii', 'prompt_injection', 'blocklist')),
+    action VARCHAR(20) NOT NULL CHECK (action IN ('masked', 'flagged', 'dropped', 'blocked')),
+    details JSONB NOT NULL DEFAULT '{}',
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_guardrail_events_project ON ai_guardrail_events(tenant_id, project_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_ai_guardrail_events_session ON ai_guardrail_events(session_id) WHERE session_id IS NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS ai_guardrail_events;
+
+-- +goose StatementEnd
@@ -0,0 +1,84 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Test questions a project's assistant is evaluated against, with the answer and knowledge sources
+-- expected. Cases imported from chats keep the answer the visitor gave a thumbs down.
+CREATE TABLE IF NOT EXISTS ai_eval_cases (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    question TEXT NOT NULL,
+    expected_answer TEXT NOT NULL DEFAULT '',
+    expected_sources TEXT[] NOT NULL DEFAULT '{}',
+    origin VARCHAR(20) NOT NULL DEFAULT 'manual' CHECK (origin IN ('manual', 'csv', 'thumbs_down')),
+    origin_session_id UUID REFERENCES chat_sessions(id) ON DELETE SET NULL,
+    origin_message_id UUID,
+    rejected_answer TEXT,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_eval_cases_project ON ai_eval_cases(tenant_id, project_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ai_eval_cases_origin_message ON ai_eval_cases(project_id, origin_message_id) WHERE origin_message_id IS NOT NULL;
+
+-- A run of a project's evaluation suite and its scores. Scores are NULL when no case could be scored on them.
+CREATE TABLE IF NOT EXISTS ai_eval_runs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    label VARCHAR(255) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'completed', 'failed')),
+    provider VARCHAR(50) NOT NULL DEFAULT '',
+    model VARCHAR(100) NOT NULL DEFAULT '',
+    case_count INTEGER NOT NULL DEFAULT 0,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    retrieval_hit_rate DOUBLE PRECISION,
+    answer_similarity DOUBLE PRECISION,
+    citation_accuracy DOUBLE PRECISION,
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    started_by UUID REFERENCES agents(id) ON DELETE SET NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_eval_runs_project ON ai_eval_runs(tenant_id, project_id, created_at DESC);
+
+-- What the assistant answered to each case in a run and how it scored. The question and expected values
+-- are copied so a run can be compared after its cases change.
+CREATE TABLE IF NOT EXISTS ai_eval_results (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    run_id UUID NOT NULL REFERENCES ai_eval_runs(id) ON DELETE CASCADE,
+    case_id UUID NOT NULL,
+    question TEXT NOT NULL,
+    expected_answer TEXT NOT NULL DEFAULT '',
+    expected_sources TEXT[] NOT NULL DEFAULT '{}',
+    answer TEXT NOT NULL DEFAULT '',
+    retrieved_sources TEXT[] NOT NULL DEFAULT '{}',
+    cited_sources TEXT[] NOT NULL DEFAULT '{}',
+    retrieval_hit BOOLEAN,
+    answer_similarity DOUBLE PRECISION,
+    citation_correct BOOLEAN,
+    handoff BOOLEAN NOT NULL DEFAULT FALSE,
+    blocked BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+    latency_ms BIGINT NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_eval_results_run ON ai_eval_results(run_id, case_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS ai_eval_results;
+DROP TABLE IF EXISTS ai_eval_runs;
+DROP TABLE IF EXISTS ai_eval_cases;
+
+-- +goose StatementEnd
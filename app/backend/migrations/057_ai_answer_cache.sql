@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- AI answers to visitor questions, reused for later questions that mean the same. Entries are for one
+-- AI profile configuration and hold the knowledge sources the answer was based on, with their content
+-- hash when it was given, so answers from changed knowledge are not reused.
+CREATE TABLE IF NOT EXISTS ai_answer_cache (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    profile_hash VARCHAR(64) NOT NULL,
+    question TEXT NOT NULL,
+    embedding vector(1536) NOT NULL,
+    answer TEXT NOT NULL,
+    sources JSONB NOT NULL DEFAULT '[]',
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    hit_count INTEGER NOT NULL DEFAULT 0,
+    last_hit_at TIMESTAMP WITH TIME ZONE,
+    rejected_at TIMESTAMP WITH TIME ZONE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_answer_cache_project ON ai_answer_cache(tenant_id, project_id, profile_hash);
+
+-- Daily answer cache lookups of a project, for its hit rate and the tokens it saved
+CREATE TABLE IF NOT EXISTS ai_answer_cache_stats (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    hits INTEGER NOT NULL DEFAULT 0,
+    misses INTEGER NOT NULL DEFAULT 0,
+    tokens_saved BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (project_id, day)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS ai_answer_cache_stats;
+DROP TABLE IF EXISTS ai_answer_cache;
+
+-- +goose StatementEnd
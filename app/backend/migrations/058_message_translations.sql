@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The language customer messages are translated into for the agent (ISO 639-1); the project's agent
+-- language when not set
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS preferred_language VARCHAR(10);
+
+-- The detected language of customer messages, chat messages and ticket messages alike. The latest one of
+-- a conversation is the language agent replies are translated into.
+CREATE TABLE IF NOT EXISTS message_languages (
+    message_kind VARCHAR(10) NOT NULL CHECK (message_kind IN ('chat', 'ticket')),
+    message_id UUID NOT NULL,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL,
+    language VARCHAR(10) NOT NULL,
+    backend VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (message_kind, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_languages_conversation ON message_languages(conversation_id, created_at DESC);
+
+-- Translations of chat and ticket messages, one per message and target language, so a message is
+-- translated once for every agent reading it in that language
+CREATE TABLE IF NOT EXISTS message_translations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    message_kind VARCHAR(10) NOT NULL CHECK (message_kind IN ('chat', 'ticket')),
+    message_id UUID NOT NULL,
+    conversation_id UUID NOT NULL,
+    source_language VARCHAR(10) NOT NULL,
+    target_language VARCHAR(10) NOT NULL,
+    translated_text TEXT NOT NULL,
+    backend VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    UNIQUE (message_kind, message_id, target_language)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_translations_conversation ON message_translations(conversation_id, target_language);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS message_translations;
+DROP TABLE IF EXISTS message_languages;
+ALTER TABLE agents DROP COLUMN IF EXISTS preferred_language;
+
+-- +goose StatementEnd
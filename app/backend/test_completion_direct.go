@@ -38,7 +38,7 @@ func main() {
 	// Create repositories and services
 	knowledgeRepo := repo.NewKnowledgeRepository(database.DB)
 	embeddingService := service.NewEmbeddingService(&cfg.Knowledge)
-	webScraperService := service.NewWebScrapingService(knowledgeRepo, embeddingService, &cfg.Knowledge)
+	webScraperService := service.NewWebScrapingService(knowledgeRepo, embeddingService, &cfg.Knowledge, nil)
 
 	// Use existing test tenant and project IDs
 	tenantID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
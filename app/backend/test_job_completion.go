@@ -36,7 +36,7 @@ func main() {
 	// Create repositories and services
 	knowledgeRepo := repo.NewKnowledgeRepository(database)
 	embeddingService := service.NewEmbeddingService(&cfg.Knowledge)
-	webScraperService := service.NewWebScrapingService(knowledgeRepo, embeddingService, &cfg.Knowledge)
+	webScraperService := service.NewWebScrapingService(knowledgeRepo, embeddingService, &cfg.Knowledge, nil)
 
 	ctx := context.Background()
 
@@ -2,13 +2,16 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/mail"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -19,15 +22,31 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// Ticket data structure
-type TicketData struct {
-	Tenant    string    `json:"tenant"`
-	From      string    `json:"from"`
-	Subject   string    `json:"subject"`
-	Content   string    `json:"content"`
-	MessageID string    `json:"message_id"`
-	InReplyTo string    `json:"in_reply_to"`
-	CreatedAt time.Time `json:"created_at"`
+// InboundEmail is the envelope and raw MIME message posted to the backend
+type InboundEmail struct {
+	MailFrom   string    `json:"mail_from"`
+	RcptTo     []string  `json:"rcpt_to"`
+	RawMessage []byte    `json:"raw_message"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// apiError is a non-2xx answer from the backend
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// permanent reports whether retrying cannot succeed, so the message should bounce
+func (e *apiError) permanent() bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
 }
 
 // Global counter for transaction ID uniqueness
@@ -82,9 +101,9 @@ func (p *TicketProcessor) Process(e *guerrillamail.Envelope, task backends.Selec
 	// Generate transaction ID for this email processing
 	transactionID := generateTransactionID()
 
-	var rcptStr string
-	if len(e.RcptTo) > 0 {
-		rcptStr = e.RcptTo[0].String()
+	rcptTo := make([]string, 0, len(e.RcptTo))
+	for _, rcpt := range e.RcptTo {
+		rcptTo = append(rcptTo, rcpt.String())
 	}
 
 	// Create logger with transaction context
@@ -93,156 +112,46 @@ func (p *TicketProcessor) Process(e *guerrillamail.Envelope, task backends.Selec
 		"component":      "email_processor",
 		"operation":      "process_email",
 		"from":           e.MailFrom.String(),
-		"to":             rcptStr,
+		"to":             strings.Join(rcptTo, ","),
+		"subject":        e.Subject,
+		"message_id":     e.Header.Get("Message-Id"),
 	})
 
 	txLogger.Info("Processing email")
 
-	// Parse email message
-	msg, err := mail.ReadMessage(strings.NewReader(e.Data.String()))
-	if err != nil {
-		txLogger.WithError(err).Error("Error parsing email message")
-		return backends.NewResult("550 Error parsing message"), err
+	// Forward the full message, attachments included, with our Received trace header
+	raw := append([]byte(e.DeliveryHeader), e.Data.Bytes()...)
+	inbound := InboundEmail{
+		MailFrom:   e.MailFrom.String(),
+		RcptTo:     rcptTo,
+		RawMessage: raw,
+		ReceivedAt: time.Now(),
 	}
 
-	// Extract ticket data
-	ticket := TicketData{
-		Tenant:    extractTenant(rcptStr),
-		From:      e.MailFrom.String(),
-		Subject:   msg.Header.Get("Subject"),
-		Content:   extractContent(msg),
-		MessageID: msg.Header.Get("Message-ID"),
-		InReplyTo: msg.Header.Get("In-Reply-To"),
-		CreatedAt: time.Now(),
-	}
-
-	// Add ticket context to logger
-	txLogger = txLogger.WithFields(logrus.Fields{
-		"tenant":     ticket.Tenant,
-		"subject":    ticket.Subject,
-		"message_id": ticket.MessageID,
-	})
-
-	// Send to your ticket API
-	if err := sendToTicketAPI(ticket); err != nil {
+	if err := sendToTicketAPI(inbound); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.permanent() {
+			txLogger.WithError(err).Warn("Email rejected by ticket API")
+			return backends.NewResult("550 Message rejected"), err
+		}
 		txLogger.WithError(err).Error("Failed to create ticket")
 		return backends.NewResult("451 Temporary failure - please retry"), err
 	}
 
-	txLogger.Info("Ticket created successfully")
+	txLogger.Info("Email delivered to ticket API")
 	return backends.NewResult("250 Message accepted for delivery"), nil
 }
 
-// Extract clean content from email
-func extractContent(msg *mail.Message) string {
-	body, err := io.ReadAll(msg.Body)
-	if err != nil {
-		return ""
-	}
-
-	content := string(body)
-
-	// Handle multipart messages (get text/plain part)
-	if strings.Contains(msg.Header.Get("Content-Type"), "multipart") {
-		content = extractTextFromMultipart(content)
-	}
-
-	// Clean up content
-	content = cleanEmailContent(content)
-
-	// Limit content size
-	if len(content) > 5000 {
-		content = content[:5000] + "\n\n[Content truncated]"
-	}
-
-	return content
-}
-
-func extractTextFromMultipart(content string) string {
-	// Look for text/plain part in multipart message
-	lines := strings.Split(content, "\n")
-	var textContent []string
-	inTextPart := false
-
-	for _, line := range lines {
-		if strings.Contains(line, "Content-Type: text/plain") {
-			inTextPart = true
-			continue
-		}
-		if strings.HasPrefix(line, "--") && inTextPart {
-			break
-		}
-		if inTextPart && !strings.HasPrefix(line, "Content-") {
-			textContent = append(textContent, line)
-		}
-	}
-
-	if len(textContent) > 0 {
-		return strings.Join(textContent, "\n")
-	}
-
-	return content
-}
-
-func cleanEmailContent(content string) string {
-	lines := strings.Split(content, "\n")
-	var cleanLines []string
-
-	// Regex patterns for common email artifacts
-	quotedReplyPattern := regexp.MustCompile(`^>.*`)
-	forwardPattern := regexp.MustCompile(`(?i)^(from:|sent:|to:|subject:)`)
-	signaturePattern := regexp.MustCompile(`^--\s*$`)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		// Skip empty lines at the start
-		if len(cleanLines) == 0 && line == "" {
-			continue
-		}
-
-		// Stop at quoted replies
-		if quotedReplyPattern.MatchString(line) {
-			break
-		}
-
-		// Stop at forwarded message headers
-		if forwardPattern.MatchString(line) {
-			break
-		}
-
-		// Stop at email signatures
-		if signaturePattern.MatchString(line) {
-			break
-		}
-
-		// Stop at common reply indicators
-		if strings.Contains(strings.ToLower(line), "wrote:") ||
-			strings.Contains(strings.ToLower(line), "on ") && strings.Contains(strings.ToLower(line), "at ") {
-			break
-		}
-
-		cleanLines = append(cleanLines, line)
-	}
-
-	return strings.TrimSpace(strings.Join(cleanLines, "\n"))
-}
-
-func extractTenant(email string) string {
-	// Extract tenant from email like tenant-penify@yourmailserver.com
-	parts := strings.Split(email, "@")
-	if len(parts) > 0 {
-		localPart := parts[0]
-		if strings.HasPrefix(localPart, "tenant-") {
-			return strings.TrimPrefix(localPart, "tenant-")
-		}
-		// Fallback: use the whole local part
-		return localPart
-	}
-	return "unknown"
+// signRequest computes the HMAC-SHA256 signature the backend expects over "<timestamp>.<body>"
+func signRequest(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
 }
 
-func sendToTicketAPI(ticket TicketData) error {
+func sendToTicketAPI(inbound InboundEmail) error {
 	apiURL := os.Getenv("TICKET_API_URL")
 	if apiURL == "" {
 		apiURL = "http://backend:8080/v1/public/email-to-ticket"
@@ -251,36 +160,48 @@ func sendToTicketAPI(ticket TicketData) error {
 	// Create logger for API call
 	apiLogger := logger.WithFields(logrus.Fields{
 		"component": "ticket_api",
-		"operation": "send_ticket",
+		"operation": "send_email",
 		"api_url":   apiURL,
-		"tenant":    ticket.Tenant,
+		"to":        strings.Join(inbound.RcptTo, ","),
 	})
 
-	jsonData, err := json.Marshal(ticket)
+	jsonData, err := json.Marshal(inbound)
 	if err != nil {
-		apiLogger.WithError(err).Error("Error marshaling ticket data")
-		return fmt.Errorf("error marshaling ticket data: %v", err)
+		apiLogger.WithError(err).Error("Error marshaling email")
+		return fmt.Errorf("error marshaling email: %v", err)
 	}
 
-	apiLogger.Debug("Sending ticket to API")
+	req, err := http.NewRequest(http.MethodPost, apiURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("error building request: %v", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-TMS-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-TMS-Signature", signRequest(os.Getenv("EMAIL_INGEST_SECRET"), timestamp, jsonData))
+
+	apiLogger.Debug("Sending email to API")
 	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Post(apiURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := client.Do(req)
 	if err != nil {
-		apiLogger.WithError(err).Error("Error sending ticket to API")
+		apiLogger.WithError(err).Error("Error sending email to API")
 		return fmt.Errorf("error sending to API: %v", err)
 	}
 	defer resp.Body.Close()
 
+	bodyBytes, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
 		apiLogger.WithFields(logrus.Fields{
 			"status_code":   resp.StatusCode,
 			"response_body": string(bodyBytes),
 		}).Error("API returned error status")
-		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(bodyBytes))
+		return &apiError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
-	apiLogger.WithField("status_code", resp.StatusCode).Info("Ticket created successfully via API")
+	apiLogger.WithFields(logrus.Fields{
+		"status_code":   resp.StatusCode,
+		"response_body": string(bodyBytes),
+	}).Info("Email delivered via API")
 	return nil
 }
 
@@ -341,6 +262,10 @@ func main() {
 		"save_process":      "HeadersParser|Header|Hasher|TicketProcessor",
 	}
 
+	if os.Getenv("EMAIL_INGEST_SECRET") == "" {
+		mainLogger.Warn("EMAIL_INGEST_SECRET is not set, the ticket API will reject forwarded mail")
+	}
+
 	mainLogger.WithFields(logrus.Fields{
 		"ticket_api_url":   os.Getenv("TICKET_API_URL"),
 		"max_message_size": maxSize,
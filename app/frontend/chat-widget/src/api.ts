@@ -56,6 +56,7 @@ export class ChatAPI {
       visitor_name: request.visitor_name,
       visitor_email: request.visitor_email,
       visitor_info: request.visitor_info,
+      identity_token: request.identity_token,
       timestamp: Date.now(),
       iat: Math.floor(Date.now() / 1000),
       exp: Math.floor(Date.now() / 1000) + (7 * 24 * 60 * 60) // 7 days expiration
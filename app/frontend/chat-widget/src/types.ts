@@ -79,6 +79,7 @@ export interface InitiateChatRequest {
   visitor_name: string
   visitor_email?: string
   visitor_info: Record<string, any>
+  identity_token?: string
 }
 
 export interface WSMessage {
@@ -93,6 +94,8 @@ export interface ChatWidgetOptions {
   widgetId: string
   enableSessionPersistence?: boolean
   debugMode?: boolean
+  // Signed by your backend with the widget's identity secret: { user_id, email, name, exp }
+  identityToken?: string
 }
 
 export interface NotificationSound {
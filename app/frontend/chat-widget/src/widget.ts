@@ -694,6 +694,7 @@ export class TMSChatWidget {
       const sessionData: any = {
         visitor_name: visitorInfo.name,
         visitor_email: visitorInfo.email,
+        identity_token: this.options.identityToken,
         initial_message: this.widget.welcome_message,
         visitor_info: {
           fingerprint,
@@ -139,7 +139,7 @@ if docker-compose up $BUILD_OPTION $DETACH_OPTION; then
         
         echo ""
         print_status "📧 Email Configuration:"
-        echo "   Send emails to: tenant-{name}@$(grep MAIL_DOMAIN .env | cut -d'=' -f2 || echo 'yourmailserver.com')"
+        echo "   Send emails to: tenant-{key}@$(grep MAIL_DOMAIN .env | cut -d'=' -f2 || echo 'yourmailserver.com')"
         echo "   Tickets will be created automatically via the backend API"
         
         echo ""